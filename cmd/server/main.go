@@ -2,18 +2,26 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"expvar"
 	"fmt"
 	"identity/internal/config"
 	"identity/internal/handler"
+	"identity/internal/mailer"
 	"identity/internal/middleware"
 	"identity/internal/migrations"
+	"identity/internal/model"
+	"identity/internal/pubsub"
 	"identity/internal/repository"
 	"identity/internal/service"
 	"identity/internal/service/dto"
+	"identity/internal/tlsconfig"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -69,13 +77,133 @@ func main() {
 	userFFRepo := repository.NewUserFeatureFlagRepository(db)
 	sessionRepo := repository.NewSessionRepository(db)
 	auditLogRepo := repository.NewAuditLogRepository(db)
+	flagChangeRepo := repository.NewFlagChangeRepository(db)
+	scheduledFlagChangeRepo := repository.NewScheduledFlagChangeRepository(db)
+	loginChallengeRepo := repository.NewLoginChallengeRepository(db)
+	recoveryCodeRepo := repository.NewRecoveryCodeRepository(db)
+	webAuthnCredentialRepo := repository.NewWebAuthnCredentialRepository(db)
+	webAuthnCeremonyRepo := repository.NewWebAuthnCeremonyRepository(db)
+	passwordResetTokenRepo := repository.NewPasswordResetTokenRepository(db)
+	loginThrottleRepo := repository.NewLoginThrottleRepository(db)
+	passwordHistoryRepo := repository.NewPasswordHistoryRepository(db)
+	oauthClientRepo := repository.NewOAuthClientRepository(db)
+	oauthCodeRepo := repository.NewOAuthAuthorizationCodeRepository(db)
+	oauthTokenRepo := repository.NewOAuthTokenRepository(db)
+	oauthConsentRepo := repository.NewOAuthConsentRepository(db)
+	signingKeyRepo := repository.NewSigningKeyRepository(db)
+	userIdentityRepo := repository.NewUserIdentityRepository(db)
+	externalLoginStateRepo := repository.NewExternalLoginStateRepository(db)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+
+	// Background work (notification listeners, flag scheduler, session
+	// reaper, signing key rotation, OpenID Connect maintenance, certificate
+	// reloading) stops on shutdown
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
 
 	// Setup services
 	auditLogger := service.NewAuditLogger(auditLogRepo, logger)
-	userService := service.NewUserService(userRepo, featureFlagRepo, userFFRepo, auditLogger)
-	featureFlagService := service.NewFeatureFlagService(featureFlagRepo, userFFRepo, auditLogger)
+	flagCache := setupFlagCache(cfg, logger)
+	flagChanges := setupFlagChanges(bgCtx, cfg, flagChangeRepo, flagCache, logger)
+	userService := service.NewUserService(userRepo, featureFlagRepo, userFFRepo, flagChanges, auditLogger)
+	featureFlagService := service.NewFeatureFlagService(featureFlagRepo, userFFRepo, userRepo, scheduledFlagChangeRepo, flagCache, flagChanges, auditLogger)
 	sessionDuration := time.Duration(cfg.Auth.SessionDurationHours) * time.Hour
-	authService := service.NewAuthService(userRepo, sessionRepo, auditLogger, sessionDuration)
+	sessionTimeouts, err := sessionTimeoutsConfig(cfg)
+	if err != nil {
+		logger.Error("invalid session timeout configuration", "error", err)
+		os.Exit(1)
+	}
+	twoFactor, err := twoFactorConfig(cfg)
+	if err != nil {
+		logger.Error("invalid two-factor configuration", "error", err)
+		os.Exit(1)
+	}
+	webAuthn, err := service.NewWebAuthn(service.WebAuthnConfig{
+		RPID:      cfg.Auth.WebAuthnRPID,
+		RPName:    cfg.Auth.WebAuthnRPName,
+		RPOrigins: cfg.Auth.WebAuthnRPOrigins,
+	})
+	if err != nil {
+		logger.Error("invalid WebAuthn configuration", "error", err)
+		os.Exit(1)
+	}
+	passwordPolicyCfg, err := passwordPolicyConfig(cfg, logger)
+	if err != nil {
+		logger.Error("invalid password policy configuration", "error", err)
+		os.Exit(1)
+	}
+	passwordPolicy := service.NewPasswordPolicy(passwordPolicyCfg, passwordHistoryRepo)
+	authService := service.NewAuthService(userRepo, sessionRepo, oauthTokenRepo, loginChallengeRepo, recoveryCodeRepo,
+		webAuthnCredentialRepo, webAuthnCeremonyRepo, loginThrottleRepo, auditLogger, sessionDuration, sessionTimeouts, twoFactor, webAuthn,
+		service.LockoutConfig{
+			Threshold:   cfg.Auth.LoginLockoutThreshold,
+			IPThreshold: cfg.Auth.LoginIPLockoutThreshold,
+			Duration:    time.Duration(cfg.Auth.LoginLockoutSeconds) * time.Second,
+			MaxDuration: time.Duration(cfg.Auth.LoginLockoutMaxMinutes) * time.Minute,
+		}, passwordPolicy)
+	mail, err := setupMailer(cfg, logger)
+	if err != nil {
+		logger.Error("invalid mail configuration", "error", err)
+		os.Exit(1)
+	}
+	passwordResetService := service.NewPasswordResetService(userRepo, sessionRepo, oauthTokenRepo, passwordResetTokenRepo, passwordPolicy, mail, auditLogger, service.PasswordResetConfig{
+		URL:   cfg.Auth.PasswordResetURL,
+		TTL:   time.Duration(cfg.Auth.PasswordResetTTLMinutes) * time.Minute,
+		Limit: cfg.Auth.PasswordResetLimit,
+	}, logger)
+
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo, auditLogger)
+
+	externalLoginService, err := setupExternalLogin(cfg, userIdentityRepo, externalLoginStateRepo, userRepo, authService, auditLogger)
+	if err != nil {
+		logger.Error("invalid external login configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Keys signing ID tokens and session tokens
+	signingKeys := service.NewSigningKeys(signingKeyRepo, twoFactor.Secrets, time.Duration(cfg.OIDC.KeyRotationDays)*24*time.Hour)
+	oidcService, err := setupOIDC(cfg, oauthClientRepo, oauthCodeRepo, oauthTokenRepo, oauthConsentRepo, userRepo, sessionRepo, signingKeys, auditLogger)
+	if err != nil {
+		logger.Error("invalid OpenID Connect configuration", "error", err)
+		os.Exit(1)
+	}
+	sessionTokenService, err := setupSessionTokens(cfg, authService, featureFlagService, signingKeys)
+	if err != nil {
+		logger.Error("invalid session token configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Server certificate and the services client certificates identify
+	tlsReloader, err := setupTLS(cfg)
+	if err != nil {
+		logger.Error("invalid TLS configuration", "error", err)
+		os.Exit(1)
+	}
+	clientServices, err := clientServicesConfig(cfg)
+	if err != nil {
+		logger.Error("invalid TLS configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Encrypt TOTP secrets stored before secrets were encrypted
+	encrypted, err := service.EncryptTOTPSecrets(context.Background(), userRepo, twoFactor.Secrets)
+	if err != nil {
+		logger.Error("failed to encrypt TOTP secrets", "error", err)
+		os.Exit(1)
+	}
+	if encrypted > 0 {
+		logger.Info("encrypted stored TOTP secrets", "count", encrypted)
+	}
+
+	// Encrypt signing keys stored before they were encrypted
+	encrypted, err = service.EncryptSigningKeys(context.Background(), signingKeyRepo, twoFactor.Secrets)
+	if err != nil {
+		logger.Error("failed to encrypt signing keys", "error", err)
+		os.Exit(1)
+	}
+	if encrypted > 0 {
+		logger.Info("encrypted stored signing keys", "count", encrypted)
+	}
 
 	// Seed the initial admin user (first boot only)
 	if err := seedAdminUser(cfg, userRepo, authService, logger); err != nil {
@@ -83,14 +211,60 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Apply scheduled flag changes as they come due
+	if cfg.FlagScheduler.IntervalSeconds > 0 {
+		schedulerInterval := time.Duration(cfg.FlagScheduler.IntervalSeconds) * time.Second
+		go service.RunFlagScheduler(bgCtx, featureFlagService, schedulerInterval, logger)
+	} else {
+		logger.Info("flag scheduler disabled")
+	}
+
+	// Purge expired and ended sessions
+	if cfg.SessionReaper.IntervalSeconds > 0 {
+		sessionReaper := service.NewSessionReaper(sessionRepo, oauthTokenRepo, cfg.SessionReaper.BatchSize, logger)
+		// Rows purged etc. are served on /debug/vars
+		expvar.Publish("session_reaper", expvar.Func(func() any { return sessionReaper.Stats() }))
+		go sessionReaper.Run(bgCtx, time.Duration(cfg.SessionReaper.IntervalSeconds)*time.Second)
+	} else {
+		logger.Info("session reaper disabled")
+	}
+
+	// Rotate token signing keys while anything signs with them
+	if oidcService != nil || sessionTokenService != nil {
+		go service.RunSigningKeyRotation(bgCtx, signingKeys, service.SigningKeyRotationInterval, logger)
+	}
+
+	// Purge expired codes and tokens
+	if oidcService != nil {
+		go service.RunOIDCMaintenance(bgCtx, oidcService, service.OIDCMaintenanceInterval, logger)
+		logger.Info("OpenID Connect provider enabled", "issuer", cfg.OIDC.Issuer)
+	} else {
+		logger.Info("OpenID Connect provider disabled")
+	}
+
+	// Pick up renewed certificates without a restart
+	if tlsReloader != nil && cfg.TLS.ReloadIntervalSeconds > 0 {
+		go tlsReloader.Run(bgCtx, time.Duration(cfg.TLS.ReloadIntervalSeconds)*time.Second, logger)
+	}
+
 	// Setup handlers
 	userHandler := handler.NewUserHandler(userService, logger)
 	featureFlagHandler := handler.NewFeatureFlagHandler(featureFlagService, logger)
 	authHandler := handler.NewAuthHandler(authService, logger, cfg.Auth.CookieSecure)
-	webHandler := handler.NewWebHandler(authService, userService, featureFlagService, auditLogRepo, logger, cfg.Auth.CookieSecure, cfg.Environment)
+	passwordResetHandler := handler.NewPasswordResetHandler(passwordResetService, logger)
+	externalLoginHandler := handler.NewExternalLoginHandler(externalLoginService, logger)
+	webHandler := handler.NewWebHandler(authService, userService, featureFlagService, passwordResetService, oidcService, externalLoginService, apiKeyService, auditLogRepo, logger, cfg.Auth.CookieSecure, cfg.Environment)
+	var oidcHandler *handler.OIDCHandler
+	if oidcService != nil {
+		oidcHandler = handler.NewOIDCHandler(oidcService, logger)
+	}
+	var sessionTokenHandler *handler.SessionTokenHandler
+	if sessionTokenService != nil {
+		sessionTokenHandler = handler.NewSessionTokenHandler(sessionTokenService, logger)
+	}
 
 	// Setup HTTP server
-	router := setupRouter(cfg, logger, userHandler, featureFlagHandler, authHandler, webHandler, authService)
+	router := setupRouter(cfg, logger, userHandler, featureFlagHandler, authHandler, passwordResetHandler, externalLoginHandler, webHandler, oidcHandler, sessionTokenHandler, authService, apiKeyService, clientServices)
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -100,19 +274,50 @@ func main() {
 
 	// Start server in a goroutine
 	go func() {
-		logger.Info("starting HTTP server", "port", cfg.Server.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsReloader != nil {
+			srv.TLSConfig = tlsReloader.TLSConfig()
+			logger.Info("starting HTTPS server", "port", cfg.Server.Port, "client_certificates", clientServices != nil)
+			// Certificates come from srv.TLSConfig, which reloads them
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			logger.Info("starting HTTP server", "port", cfg.Server.Port)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Error("failed to start server", "error", err)
 			os.Exit(1)
 		}
 	}()
 
+	// Runtime and cache metrics (expvar), on their own listener so they
+	// aren't reachable wherever the API is
+	var metricsSrv *http.Server
+	if cfg.Server.MetricsAddr != "" {
+		metrics := http.NewServeMux()
+		metrics.Handle("GET /debug/vars", expvar.Handler())
+		metricsSrv = &http.Server{
+			Addr:              cfg.Server.MetricsAddr,
+			Handler:           metrics,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			logger.Info("serving metrics", "addr", cfg.Server.MetricsAddr)
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("failed to serve metrics", "error", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	logger.Info("shutting down server...")
+	stopBackground()
+	// End open flag streams, which would otherwise hold up the shutdown
+	flagChanges.Close()
 
 	// Context with timeout for shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -122,6 +327,11 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Error("server forced to shutdown", "error", err)
 	}
+	if metricsSrv != nil {
+		_ = metricsSrv.Shutdown(ctx)
+	}
+	// Finish sending the reset links of requests already answered
+	passwordResetService.Close()
 
 	logger.Info("server exited")
 }
@@ -142,7 +352,7 @@ func seedAdminUser(cfg *config.Config, userRepo repository.UserRepository, authS
 		return nil
 	}
 
-	_, err = authService.Register(ctx, &dto.RegisterRequest{
+	created, err := authService.Register(ctx, &dto.RegisterRequest{
 		Name:     "Admin",
 		Email:    cfg.Admin.Email,
 		Password: cfg.Admin.Password,
@@ -151,10 +361,246 @@ func seedAdminUser(cfg *config.Config, userRepo repository.UserRepository, authS
 		return fmt.Errorf("failed to create admin user: %w", err)
 	}
 
+	// Register always creates plain users; promote the seed account
+	admin, err := userRepo.GetByID(ctx, created.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load admin user: %w", err)
+	}
+	admin.Role = model.RoleAdmin
+	if err := userRepo.Update(ctx, admin); err != nil {
+		return fmt.Errorf("failed to promote admin user: %w", err)
+	}
+
 	logger.Info("admin user seeded", "email", cfg.Admin.Email)
 	return nil
 }
 
+// passwordPolicyConfig builds the password policy settings, loading
+// PASSWORD_BREACHED_LIST if set
+func passwordPolicyConfig(cfg *config.Config, logger *slog.Logger) (service.PasswordPolicyConfig, error) {
+	policy := service.PasswordPolicyConfig{
+		MinLength:      cfg.Auth.PasswordMinLength,
+		MinCharClasses: cfg.Auth.PasswordMinCharClasses,
+		History:        cfg.Auth.PasswordHistory,
+	}
+	hasher, err := service.NewPasswordHasher(service.PasswordHashConfig{
+		Algorithm:         cfg.Auth.PasswordHashAlgorithm,
+		BcryptCost:        cfg.Auth.PasswordBcryptCost,
+		Argon2Memory:      cfg.Auth.PasswordArgon2MemoryKiB,
+		Argon2Iterations:  cfg.Auth.PasswordArgon2Iterations,
+		Argon2Parallelism: cfg.Auth.PasswordArgon2Parallelism,
+	})
+	if err != nil {
+		return policy, err
+	}
+	policy.Hasher = hasher
+	if cfg.Auth.PasswordBreachedList != "" {
+		breached, err := service.LoadBreachedPasswords(cfg.Auth.PasswordBreachedList)
+		if err != nil {
+			return policy, err
+		}
+		policy.Breached = breached
+		logger.Info("breached password list loaded", "path", cfg.Auth.PasswordBreachedList, "hashes", breached.Len())
+	}
+	return policy, nil
+}
+
+// sessionTimeoutsConfig builds the session timeouts, rejecting unknown
+// roles and malformed durations in the per-role overrides
+func sessionTimeoutsConfig(cfg *config.Config) (service.SessionTimeouts, error) {
+	timeouts := service.SessionTimeouts{
+		MaxLifetime: time.Duration(cfg.Auth.SessionMaxLifetimeHours) * time.Hour,
+		IdleTimeout: time.Duration(cfg.Auth.SessionIdleTimeoutMinutes) * time.Minute,
+		Roles:       make(map[model.Role]service.RoleSessionTimeouts),
+	}
+
+	overrides := []struct {
+		env   string
+		items []string
+		set   func(*service.RoleSessionTimeouts, time.Duration)
+	}{
+		{"SESSION_ROLE_MAX_LIFETIMES", cfg.Auth.SessionRoleMaxLifetimes, func(t *service.RoleSessionTimeouts, d time.Duration) { t.MaxLifetime = d }},
+		{"SESSION_ROLE_IDLE_TIMEOUTS", cfg.Auth.SessionRoleIdleTimeouts, func(t *service.RoleSessionTimeouts, d time.Duration) { t.IdleTimeout = d }},
+	}
+	for _, override := range overrides {
+		for _, item := range override.items {
+			name, value, ok := strings.Cut(item, "=")
+			role := model.Role(strings.TrimSpace(name))
+			if !ok || !role.Valid() {
+				return timeouts, fmt.Errorf("invalid item %q in %s, want role=duration with a known role", item, override.env)
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(value))
+			if err != nil || d <= 0 {
+				return timeouts, fmt.Errorf("invalid duration in %s item %q", override.env, item)
+			}
+			roleTimeouts := timeouts.Roles[role]
+			override.set(&roleTimeouts, d)
+			timeouts.Roles[role] = roleTimeouts
+		}
+	}
+	return timeouts, nil
+}
+
+// twoFactorConfig builds the TOTP settings, rejecting unknown roles in
+// TOTP_REQUIRED_ROLES rather than silently not enforcing them. The key TOTP
+// secrets are encrypted with is required.
+func twoFactorConfig(cfg *config.Config) (service.TwoFactorConfig, error) {
+	twoFactor := service.TwoFactorConfig{Issuer: cfg.Auth.TOTPIssuer}
+	for _, name := range cfg.Auth.TOTPRequiredRoles {
+		role := model.Role(name)
+		if !role.Valid() {
+			return twoFactor, fmt.Errorf("unknown role %q in TOTP_REQUIRED_ROLES", name)
+		}
+		twoFactor.RequiredRoles = append(twoFactor.RequiredRoles, role)
+	}
+
+	if cfg.Auth.TOTPEncryptionKey == "" {
+		return twoFactor, errors.New("TOTP_ENCRYPTION_KEY is required (e.g. openssl rand -base64 32)")
+	}
+	key, err := base64.StdEncoding.DecodeString(cfg.Auth.TOTPEncryptionKey)
+	if err != nil {
+		return twoFactor, fmt.Errorf("TOTP_ENCRYPTION_KEY is not valid base64: %w", err)
+	}
+	if twoFactor.Secrets, err = service.NewSecretBox(key); err != nil {
+		return twoFactor, fmt.Errorf("invalid TOTP_ENCRYPTION_KEY: %w", err)
+	}
+	return twoFactor, nil
+}
+
+// setupOIDC creates the OpenID Connect provider, or returns nil when
+// OIDC_ISSUER is unset
+func setupOIDC(
+	cfg *config.Config,
+	clientRepo repository.OAuthClientRepository,
+	codeRepo repository.OAuthAuthorizationCodeRepository,
+	tokenRepo repository.OAuthTokenRepository,
+	consentRepo repository.OAuthConsentRepository,
+	userRepo repository.UserRepository,
+	sessionRepo repository.SessionRepository,
+	keys *service.SigningKeys,
+	audit service.AuditLogger,
+) (service.OIDCService, error) {
+	if cfg.OIDC.Issuer == "" {
+		return nil, nil
+	}
+
+	return service.NewOIDCService(clientRepo, codeRepo, tokenRepo, consentRepo, userRepo, sessionRepo, keys, audit, service.OIDCConfig{
+		Issuer:          cfg.OIDC.Issuer,
+		AccessTokenTTL:  time.Duration(cfg.OIDC.AccessTokenTTLMinutes) * time.Minute,
+		RefreshTokenTTL: time.Duration(cfg.OIDC.RefreshTokenTTLHours) * time.Hour,
+	})
+}
+
+// setupExternalLogin creates the logins with the external identity
+// providers listed in LOGIN_PROVIDERS
+func setupExternalLogin(
+	cfg *config.Config,
+	identityRepo repository.UserIdentityRepository,
+	stateRepo repository.ExternalLoginStateRepository,
+	userRepo repository.UserRepository,
+	authService service.AuthService,
+	audit service.AuditLogger,
+) (service.ExternalLoginService, error) {
+	externalLogin := service.ExternalLoginConfig{CallbackURL: cfg.ExternalLogin.CallbackURL}
+	for _, provider := range cfg.ExternalLogin.Providers {
+		externalLogin.Providers = append(externalLogin.Providers, service.ExternalProviderConfig{
+			ID:             provider.ID,
+			Name:           provider.Name,
+			Issuer:         provider.Issuer,
+			ClientID:       provider.ClientID,
+			ClientSecret:   provider.ClientSecret,
+			AllowedDomains: provider.AllowedDomains,
+			Provision:      provider.Provision,
+			LinkExisting:   provider.LinkExisting,
+		})
+	}
+
+	return service.NewExternalLoginService(identityRepo, stateRepo, userRepo, authService, audit, externalLogin)
+}
+
+// setupSessionTokens creates the session token exchange, or returns nil
+// when SESSION_TOKEN_TTL_SECONDS is 0
+func setupSessionTokens(cfg *config.Config, authService service.AuthService, flags service.FeatureFlagService, keys *service.SigningKeys) (service.SessionTokenService, error) {
+	if cfg.SessionToken.TTLSeconds <= 0 {
+		return nil, nil
+	}
+
+	return service.NewSessionTokenService(authService, flags, keys, service.SessionTokenConfig{
+		Issuer: cfg.SessionToken.Issuer,
+		TTL:    time.Duration(cfg.SessionToken.TTLSeconds) * time.Second,
+	})
+}
+
+// setupTLS loads the server certificate and the client CAs, or returns nil
+// (plain HTTP) when TLS_CERT_FILE and TLS_KEY_FILE are unset
+func setupTLS(cfg *config.Config) (*tlsconfig.Reloader, error) {
+	if cfg.TLS.CertFile == "" && cfg.TLS.KeyFile == "" {
+		if cfg.TLS.ClientCAFile != "" {
+			return nil, errors.New("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	if cfg.TLS.CertFile == "" || cfg.TLS.KeyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	return tlsconfig.NewReloader(tlsconfig.Files{
+		CertFile:     cfg.TLS.CertFile,
+		KeyFile:      cfg.TLS.KeyFile,
+		ClientCAFile: cfg.TLS.ClientCAFile,
+	})
+}
+
+// clientServicesConfig maps client certificate subjects to the services they
+// identify from TLS_CLIENT_SERVICES, or returns nil when client certificates
+// aren't required (TLS_CLIENT_CA_FILE unset)
+func clientServicesConfig(cfg *config.Config) (map[string]string, error) {
+	if cfg.TLS.ClientCAFile == "" {
+		if len(cfg.TLS.ClientServices) > 0 {
+			return nil, errors.New("TLS_CLIENT_SERVICES needs TLS_CLIENT_CA_FILE")
+		}
+		return nil, nil
+	}
+
+	services := make(map[string]string)
+	for _, item := range cfg.TLS.ClientServices {
+		subject, name, ok := strings.Cut(item, "=")
+		subject, name = strings.TrimSpace(subject), strings.TrimSpace(name)
+		if !ok || subject == "" || name == "" {
+			return nil, fmt.Errorf("invalid item %q in TLS_CLIENT_SERVICES, want subject=service", item)
+		}
+		services[subject] = name
+	}
+	// Without any, every certificate would be turned away
+	if len(services) == 0 {
+		return nil, errors.New("TLS_CLIENT_SERVICES must name the services allowed to call with a certificate")
+	}
+	return services, nil
+}
+
+// setupMailer picks how emails are delivered: through SMTP, or for local
+// development, into the log or a directory
+func setupMailer(cfg *config.Config, logger *slog.Logger) (mailer.Mailer, error) {
+	switch cfg.Mail.Driver {
+	case "smtp":
+		return mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     cfg.Mail.SMTPHost,
+			Port:     cfg.Mail.SMTPPort,
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+			From:     cfg.Mail.From,
+		})
+	case "file":
+		logger.Info("emails are written to files", "dir", cfg.Mail.FileDir)
+		return mailer.NewFileMailer(cfg.Mail.FileDir, cfg.Mail.From)
+	case "log":
+		logger.Info("emails are written to the log")
+		return mailer.NewLogMailer(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q (want smtp, file or log)", cfg.Mail.Driver)
+	}
+}
+
 func setupLogger(level string) *slog.Logger {
 	var logLevel slog.Level
 	switch level {
@@ -210,14 +656,49 @@ func setupDatabase(cfg *config.Config, logger *slog.Logger) (*gorm.DB, error) {
 	return db, nil
 }
 
+// setupFlagCache creates the in-process flag cache. It returns nil (no
+// caching) when the TTL is 0.
+func setupFlagCache(cfg *config.Config, logger *slog.Logger) *service.FlagCache {
+	if cfg.FlagCache.TTLSeconds <= 0 {
+		logger.Info("flag cache disabled")
+		return nil
+	}
+
+	ttl := time.Duration(cfg.FlagCache.TTLSeconds) * time.Second
+	flagCache := service.NewFlagCache(ttl)
+
+	// Hit rate etc. are served on /debug/vars
+	expvar.Publish("flag_cache", expvar.Func(func() any { return flagCache.Stats() }))
+
+	logger.Info("flag cache enabled", "ttl", ttl)
+	return flagCache
+}
+
+// setupFlagChanges creates the flag change feed and subscribes it to the
+// changes logged by every replica, keeping flagCache and flag streams
+// current, and prunes the change log
+func setupFlagChanges(ctx context.Context, cfg *config.Config, repo repository.FlagChangeRepository, flagCache *service.FlagCache, logger *slog.Logger) *service.FlagChangeFeed {
+	flagChanges := service.NewFlagChangeFeed(repo, flagCache, logger)
+	go pubsub.Listen(ctx, cfg.Database.DSN(), service.FlagChangesChannel,
+		flagChanges.Resync, flagChanges.HandleNotification, logger)
+	go flagChanges.RunPruner(ctx)
+	return flagChanges
+}
+
 func setupRouter(
 	cfg *config.Config,
 	logger *slog.Logger,
 	userHandler *handler.UserHandler,
 	featureFlagHandler *handler.FeatureFlagHandler,
 	authHandler *handler.AuthHandler,
+	passwordResetHandler *handler.PasswordResetHandler,
+	externalLoginHandler *handler.ExternalLoginHandler,
 	webHandler *handler.WebHandler,
+	oidcHandler *handler.OIDCHandler,
+	sessionTokenHandler *handler.SessionTokenHandler,
 	authService service.AuthService,
+	apiKeyService service.APIKeyService,
+	clientServices map[string]string,
 ) *gin.Engine {
 	// Set gin mode
 	if cfg.Log.Level != "debug" {
@@ -225,9 +706,16 @@ func setupRouter(
 	}
 
 	router := gin.New()
+	// Only listed proxies' X-Forwarded-For is believed (none by default), so
+	// clients can't pick the IP their failed logins are counted against
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Error("invalid TRUSTED_PROXIES, trusting none", "error", err)
+		_ = router.SetTrustedProxies(nil)
+	}
 
 	// Middleware
 	router.Use(middleware.Recovery(logger))
+	router.Use(middleware.ClientInfo())
 	router.Use(middleware.Logger(logger))
 
 	// Health check
@@ -246,74 +734,207 @@ func setupRouter(
 		auth := v1.Group("/auth")
 		{
 			auth.POST("/login", authHandler.Login)
+			auth.POST("/login/verify", authHandler.VerifyLogin)
+			auth.POST("/login/enroll", authHandler.BeginLoginEnrollment)
+			auth.POST("/login/webauthn/begin", authHandler.BeginLoginWebAuthn)
+			auth.POST("/login/webauthn/finish", authHandler.VerifyLoginWebAuthn)
+			auth.POST("/passkey/begin", authHandler.BeginPasskeyLogin)
+			auth.POST("/passkey/finish", authHandler.FinishPasskeyLogin)
+			auth.POST("/password/forgot", passwordResetHandler.ForgotPassword)
+			auth.POST("/password/reset", passwordResetHandler.ResetPassword)
 			auth.POST("/logout", authHandler.Logout)
 			auth.GET("/me", authHandler.Me)
 			auth.POST("/validate", authHandler.ValidateSession)
+			// Signed access tokens for a session, unless disabled
+			if sessionTokenHandler != nil {
+				auth.POST("/token", sessionTokenHandler.ExchangeSession)
+				auth.GET("/jwks", sessionTokenHandler.JWKS)
+			}
 		}
 
-		// Flag check is public within the docker network so other services
-		// can evaluate flags without a user session
-		v1.GET("/feature-flags/check", featureFlagHandler.CheckFeatureFlag)
+		// Routes other services call without a user session. With
+		// TLS_CLIENT_CA_FILE they require a client certificate naming one of
+		// TLS_CLIENT_SERVICES rather than trusting the docker network.
+		serviceCalls := v1.Group("")
+		if clientServices != nil {
+			serviceCalls.Use(middleware.RequireClientCert(clientServices, logger))
+		}
+
+		// Flag check, evaluation and the change stream are public within the
+		// docker network so other services can use flags without a user
+		// session. Services may identify themselves with an API key granting
+		// flags:read; with API_KEY_REQUIRED they must.
+		flagReaders := middleware.ServiceAuth(apiKeyService, logger, service.PermFlagsRead, cfg.APIKeys.Required)
+		serviceCalls.GET("/feature-flags/check", flagReaders, featureFlagHandler.CheckFeatureFlag)
+		serviceCalls.POST("/feature-flags/check", flagReaders, featureFlagHandler.EvaluateFeatureFlag)
+		serviceCalls.GET("/feature-flags/evaluate", flagReaders, featureFlagHandler.EvaluateFeatureFlags)
+		serviceCalls.POST("/feature-flags/evaluate", flagReaders, featureFlagHandler.EvaluateFeatureFlagsWithContext)
+		serviceCalls.GET("/feature-flags/stream", flagReaders, featureFlagHandler.StreamFeatureFlags)
 
 		// Minimal user lookup (id, name) is public within the docker network so
 		// other services can resolve a user's display name without a user
 		// session (e.g. the transactions service labelling a transaction's
-		// creator). Only non-sensitive fields are returned.
-		v1.GET("/internal/users/:id", userHandler.GetPublicUser)
+		// creator). Only non-sensitive fields are returned. Like the flag
+		// check, it takes an API key, granting users:read.
+		serviceCalls.GET("/internal/users/:id", middleware.ServiceAuth(apiKeyService, logger, service.PermUsersRead, cfg.APIKeys.Required), userHandler.GetPublicUser)
 
-		// Everything below requires a valid session (cookie or X-Session-ID)
+		// Everything below requires a valid session (cookie or X-Session-ID),
+		// or for users and flags an API key, and a role or key scopes granting
+		// the route's permission; end users (RoleUser) are rejected with 403
 		authed := v1.Group("")
 		authed.Use(middleware.Auth(authService, logger, cfg.Auth.CookieSecure))
 		{
-			users := authed.Group("/users")
+			// Two-factor setup for the logged-in user; open to every role,
+			// end users included
+			totp := authed.Group("/auth/totp")
 			{
-				users.POST("", userHandler.CreateUser)
-				users.GET("", userHandler.GetUsers)
-				users.GET("/:id", userHandler.GetUser)
-				users.PUT("/:id", userHandler.UpdateUser)
-				users.DELETE("/:id", userHandler.DeleteUser)
-				users.GET("/:id/feature-flags", userHandler.GetUserFeatureFlags)
-				users.POST("/:id/feature-flags/:key", userHandler.AssignFeatureFlagToUser)
-				users.DELETE("/:id/feature-flags/:key", userHandler.UnassignFeatureFlagFromUser)
+				totp.POST("/enroll", authHandler.BeginTOTPEnrollment)
+				totp.POST("/confirm", authHandler.ConfirmTOTPEnrollment)
+				totp.POST("/recovery-codes", authHandler.RegenerateRecoveryCodes)
+				totp.POST("/disable", authHandler.DisableTOTP)
 			}
 
-			featureFlags := authed.Group("/feature-flags")
+			// Passkeys and security keys of the logged-in user; open to every
+			// role, like the TOTP setup
+			webAuthn := authed.Group("/auth/webauthn")
 			{
-				featureFlags.POST("", featureFlagHandler.CreateFeatureFlag)
-				featureFlags.GET("", featureFlagHandler.GetFeatureFlags)
-				featureFlags.GET("/:id", featureFlagHandler.GetFeatureFlag)
-				featureFlags.PUT("/:id", featureFlagHandler.UpdateFeatureFlag)
-				featureFlags.DELETE("/:id", featureFlagHandler.DeleteFeatureFlag)
+				webAuthn.POST("/register/begin", authHandler.BeginWebAuthnRegistration)
+				webAuthn.POST("/register/finish", authHandler.FinishWebAuthnRegistration)
+				webAuthn.GET("/credentials", authHandler.GetWebAuthnCredentials)
+				webAuthn.DELETE("/credentials/:id", authHandler.DeleteWebAuthnCredential)
+			}
+
+			// Sessions of the logged-in user; open to every role
+			authed.GET("/auth/sessions", authHandler.GetSessions)
+			authed.DELETE("/auth/sessions/:id", authHandler.RevokeSession)
+		}
+
+		// Users and flags are also managed by other services, with an API key
+		// (Authorization: Bearer) whose scopes grant the route's permission
+		managed := v1.Group("")
+		managed.Use(middleware.AuthOrAPIKey(authService, apiKeyService, logger, cfg.Auth.CookieSecure))
+		{
+			users := managed.Group("/users")
+			{
+				users.POST("", middleware.RequirePermission(service.PermUsersWrite), userHandler.CreateUser)
+				users.GET("", middleware.RequirePermission(service.PermUsersRead), userHandler.GetUsers)
+				users.GET("/:id", middleware.RequirePermission(service.PermUsersRead), userHandler.GetUser)
+				users.PUT("/:id", middleware.RequirePermission(service.PermUsersWrite), userHandler.UpdateUser)
+				users.DELETE("/:id", middleware.RequirePermission(service.PermUsersWrite), userHandler.DeleteUser)
+				users.GET("/:id/feature-flags", middleware.RequirePermission(service.PermUsersRead), userHandler.GetUserFeatureFlags)
+				users.POST("/:id/feature-flags/:key", middleware.RequirePermission(service.PermFlagsWrite), userHandler.AssignFeatureFlagToUser)
+				users.DELETE("/:id/feature-flags/:key", middleware.RequirePermission(service.PermFlagsWrite), userHandler.UnassignFeatureFlagFromUser)
+				users.DELETE("/:id/totp", middleware.RequirePermission(service.PermUsersSecurity), authHandler.ResetUserTOTP)
+				users.GET("/:id/webauthn-credentials", middleware.RequirePermission(service.PermUsersRead), authHandler.GetUserWebAuthnCredentials)
+				users.DELETE("/:id/webauthn-credentials/:credentialId", middleware.RequirePermission(service.PermUsersSecurity), authHandler.RevokeWebAuthnCredential)
+				users.GET("/:id/sessions", middleware.RequirePermission(service.PermUsersRead), authHandler.GetUserSessions)
+				users.DELETE("/:id/sessions/:sessionId", middleware.RequirePermission(service.PermUsersSecurity), authHandler.RevokeUserSession)
+				users.GET("/:id/identities", middleware.RequirePermission(service.PermUsersRead), externalLoginHandler.GetUserIdentities)
+				users.DELETE("/:id/identities/:identityId", middleware.RequirePermission(service.PermUsersWrite), externalLoginHandler.UnlinkUserIdentity)
+				users.GET("/:id/lockout", middleware.RequirePermission(service.PermUsersRead), authHandler.GetUserLockout)
+				users.DELETE("/:id/lockout", middleware.RequirePermission(service.PermUsersSecurity), authHandler.UnlockUser)
+			}
+
+			featureFlags := managed.Group("/feature-flags")
+			{
+				featureFlags.POST("", middleware.RequirePermission(service.PermFlagsWrite), featureFlagHandler.CreateFeatureFlag)
+				featureFlags.GET("", middleware.RequirePermission(service.PermFlagsRead), featureFlagHandler.GetFeatureFlags)
+				featureFlags.GET("/scheduled-changes", middleware.RequirePermission(service.PermFlagsRead), featureFlagHandler.GetScheduledFlagChanges)
+				featureFlags.DELETE("/scheduled-changes/:id", middleware.RequirePermission(service.PermFlagsWrite), featureFlagHandler.CancelScheduledFlagChange)
+				featureFlags.GET("/:id", middleware.RequirePermission(service.PermFlagsRead), featureFlagHandler.GetFeatureFlag)
+				featureFlags.PUT("/:id", middleware.RequirePermission(service.PermFlagsWrite), featureFlagHandler.UpdateFeatureFlag)
+				featureFlags.DELETE("/:id", middleware.RequirePermission(service.PermFlagsWrite), featureFlagHandler.DeleteFeatureFlag)
+				featureFlags.POST("/:id/scheduled-changes", middleware.RequirePermission(service.PermFlagsWrite), featureFlagHandler.ScheduleFlagChange)
 			}
 		}
 	}
 
+	// OpenID Connect provider, when OIDC_ISSUER is set. The authorization
+	// endpoint is a page users see, so it is served by the web handler.
+	if oidcHandler != nil {
+		router.GET("/.well-known/openid-configuration", oidcHandler.Discovery)
+		oauth2 := router.Group("/oauth2")
+		{
+			oauth2.GET("/jwks", oidcHandler.JWKS)
+			oauth2.GET("/authorize", webHandler.Authorize)
+			oauth2.POST("/authorize", webHandler.AuthorizeSubmit)
+			oauth2.POST("/token", oidcHandler.Token)
+			oauth2.GET("/userinfo", oidcHandler.UserInfo)
+			oauth2.POST("/userinfo", oidcHandler.UserInfo)
+			oauth2.POST("/revoke", oidcHandler.Revoke)
+		}
+	}
+
 	// Web admin interface routes
 	admin := router.Group("/admin")
 	{
 		// Public routes
 		admin.GET("/login", webHandler.LoginPage)
 		admin.POST("/login", webHandler.LoginSubmit)
+		admin.POST("/login/verify", webHandler.LoginVerify)
+		admin.POST("/login/webauthn", webHandler.LoginWebAuthn)
+		admin.POST("/login/passkey", webHandler.LoginPasskey)
+		admin.GET("/login/external/callback", webHandler.LoginExternalCallback)
+		admin.GET("/login/external/:provider", webHandler.LoginExternal)
+		admin.GET("/forgot-password", webHandler.ForgotPasswordPage)
+		admin.POST("/forgot-password", webHandler.ForgotPasswordSubmit)
+		admin.GET("/reset-password", webHandler.ResetPasswordPage)
+		admin.POST("/reset-password", webHandler.ResetPasswordSubmit)
 		admin.GET("/logout", webHandler.Logout)
 
-		// Protected routes
+		// Protected routes (any role with admin access; writes need the
+		// matching permission on top)
 		protected := admin.Group("")
 		protected.Use(middleware.WebAuth(authService, logger, cfg.Auth.CookieSecure))
+		protected.Use(middleware.WebRequirePermission(service.PermAdminAccess))
 		{
+			canEditFlags := middleware.WebRequirePermission(service.PermFlagsWrite)
+			canEditUsers := middleware.WebRequirePermission(service.PermUsersWrite)
+			canSecureUsers := middleware.WebRequirePermission(service.PermUsersSecurity)
+
 			protected.GET("", webHandler.Dashboard)
 			protected.GET("/flags", webHandler.FlagsTab)
 			protected.GET("/users", webHandler.UsersTab)
-			protected.POST("/flags", webHandler.CreateFlag)
-			protected.PUT("/flags/:id/toggle", webHandler.ToggleFlag)
-			protected.DELETE("/flags/:id", webHandler.DeleteFlag)
+			protected.POST("/flags", canEditFlags, webHandler.CreateFlag)
+			protected.PUT("/flags/:id/toggle", canEditFlags, webHandler.ToggleFlag)
+			protected.PUT("/flags/:id/rollout", canEditFlags, webHandler.SetFlagRollout)
+			protected.GET("/flags/:id/rules", webHandler.FlagRulesModal)
+			protected.PUT("/flags/:id/rules", canEditFlags, webHandler.UpdateFlagRules)
+			protected.GET("/flags/:id/variants", webHandler.FlagVariantsModal)
+			protected.PUT("/flags/:id/variants", canEditFlags, webHandler.UpdateFlagVariants)
+			protected.GET("/flags/:id/prerequisites", webHandler.FlagPrerequisitesModal)
+			protected.PUT("/flags/:id/prerequisites", canEditFlags, webHandler.UpdateFlagPrerequisites)
+			protected.DELETE("/flags/:id", canEditFlags, webHandler.DeleteFlag)
+			protected.POST("/flags/scheduled", canEditFlags, webHandler.ScheduleFlagChange)
+			protected.DELETE("/flags/scheduled/:id", canEditFlags, webHandler.CancelScheduledFlagChange)
 			protected.GET("/users/:id/flags", webHandler.UserFlags)
-			protected.POST("/users/:id/flags/:key/toggle", webHandler.ToggleUserFlag)
-			protected.POST("/users", webHandler.CreateUser)
-			protected.GET("/users/:id/edit", webHandler.EditUserModal)
-			protected.PUT("/users/:id", webHandler.UpdateUser)
-			protected.DELETE("/users/:id", webHandler.DeleteUser)
-			protected.POST("/users/:id/force-logout", webHandler.ForceLogoutUser)
-			protected.GET("/audit", webHandler.AuditTab)
+			protected.POST("/users/:id/flags/:key/toggle", canEditFlags, webHandler.ToggleUserFlag)
+			protected.PUT("/users/:id/flags/:key/variant", canEditFlags, webHandler.SetUserFlagVariant)
+			protected.POST("/users", canEditUsers, webHandler.CreateUser)
+			protected.GET("/users/:id/edit", canEditUsers, webHandler.EditUserModal)
+			protected.PUT("/users/:id", canEditUsers, webHandler.UpdateUser)
+			protected.DELETE("/users/:id", canEditUsers, webHandler.DeleteUser)
+			protected.POST("/users/:id/force-logout", canSecureUsers, webHandler.ForceLogoutUser)
+			protected.POST("/users/:id/reset-totp", canSecureUsers, webHandler.ResetUserTOTP)
+			protected.GET("/users/:id/webauthn", webHandler.UserWebAuthnCredentials)
+			protected.DELETE("/users/:id/webauthn/:credentialId", canSecureUsers, webHandler.RevokeWebAuthnCredential)
+			protected.GET("/users/:id/sessions", webHandler.UserSessions)
+			protected.DELETE("/users/:id/sessions/:sessionId", canSecureUsers, webHandler.RevokeUserSession)
+			protected.GET("/users/:id/identities", webHandler.UserIdentities)
+			protected.DELETE("/users/:id/identities/:identityId", canEditUsers, webHandler.UnlinkUserIdentity)
+			protected.POST("/users/:id/unlock", canSecureUsers, webHandler.UnlockUser)
+			protected.GET("/audit", middleware.WebRequirePermission(service.PermAuditRead), webHandler.AuditTab)
+			if oidcHandler != nil {
+				canEditClients := middleware.WebRequirePermission(service.PermClientsWrite)
+				protected.GET("/clients", middleware.WebRequirePermission(service.PermClientsRead), webHandler.ClientsTab)
+				protected.POST("/clients", canEditClients, webHandler.CreateClient)
+				protected.POST("/clients/:id/secret", canEditClients, webHandler.RegenerateClientSecret)
+				protected.DELETE("/clients/:id", canEditClients, webHandler.DeleteClient)
+			}
+			canEditAPIKeys := middleware.WebRequirePermission(service.PermAPIKeysWrite)
+			protected.GET("/api-keys", middleware.WebRequirePermission(service.PermAPIKeysRead), webHandler.APIKeysTab)
+			protected.POST("/api-keys", canEditAPIKeys, webHandler.CreateAPIKey)
+			protected.DELETE("/api-keys/:id", canEditAPIKeys, webHandler.RevokeAPIKey)
 		}
 	}
 
@@ -4,22 +4,200 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Config holds all configuration for the application
 type Config struct {
-	Environment string
-	Server      ServerConfig
-	Database    DatabaseConfig
-	Log         LogConfig
-	Auth        AuthConfig
-	Admin       AdminConfig
+	Environment   string
+	Server        ServerConfig
+	Database      DatabaseConfig
+	Log           LogConfig
+	Auth          AuthConfig
+	Admin         AdminConfig
+	Mail          MailConfig
+	FlagCache     FlagCacheConfig
+	FlagScheduler FlagSchedulerConfig
+	SessionReaper SessionReaperConfig
+	OIDC          OIDCConfig
+	SessionToken  SessionTokenConfig
+	ExternalLogin ExternalLoginConfig
+	APIKeys       APIKeysConfig
+	TLS           TLSConfig
+}
+
+// FlagCacheConfig holds the in-process flag cache configuration
+type FlagCacheConfig struct {
+	// TTLSeconds bounds how stale a cached entry can get should a change
+	// notification be missed; 0 disables the cache
+	TTLSeconds int
+}
+
+// FlagSchedulerConfig holds the scheduled flag change runner configuration
+type FlagSchedulerConfig struct {
+	// IntervalSeconds is how often due scheduled changes are looked for, and
+	// so how late a change can be applied
+	IntervalSeconds int
+}
+
+// SessionReaperConfig holds the dead session purge configuration
+type SessionReaperConfig struct {
+	// IntervalSeconds is how often expired and ended sessions are purged; 0
+	// disables purging
+	IntervalSeconds int
+	// BatchSize is how many sessions one delete statement removes
+	BatchSize int
+}
+
+// OIDCConfig holds the OpenID Connect provider configuration
+type OIDCConfig struct {
+	// Issuer is the provider's public base URL; empty disables the provider
+	Issuer                string
+	AccessTokenTTLMinutes int
+	RefreshTokenTTLHours  int
+	KeyRotationDays       int
+}
+
+// ExternalLoginConfig holds the configuration of logins with external
+// identity providers
+type ExternalLoginConfig struct {
+	// CallbackURL is where providers send users back to after they signed in
+	CallbackURL string
+	Providers   []ExternalProviderConfig
+}
+
+// ExternalProviderConfig holds an external identity provider's
+// configuration, read from LOGIN_PROVIDER_<ID>_* variables
+type ExternalProviderConfig struct {
+	ID           string
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// AllowedDomains are the email domains accounts may first sign in from;
+	// * allows any
+	AllowedDomains []string
+	// Provision creates users for accounts without a user to link to
+	Provision bool
+	// LinkExisting links accounts to the user with the same email address
+	LinkExisting bool
+}
+
+// SessionTokenConfig holds the configuration of the access tokens sessions
+// are exchanged for
+type SessionTokenConfig struct {
+	// TTLSeconds is how long a token is valid; 0 disables the exchange
+	TTLSeconds int
+	// Issuer is the tokens' "iss" claim
+	Issuer string
+}
+
+// APIKeysConfig holds the configuration of the keys other services call the
+// API with
+type APIKeysConfig struct {
+	// Required turns away flag checks and internal user lookups made
+	// without an API key
+	Required bool
+}
+
+// TLSConfig holds the configuration of serving over TLS, and of the client
+// certificates flag checks and internal routes can require
+type TLSConfig struct {
+	// CertFile and KeyFile are the server's certificate (chain) and key; the
+	// server listens with TLS when both are set
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is a PEM bundle of the CAs client certificates must chain
+	// to; setting it requires a certificate for flag checks and internal
+	// routes
+	ClientCAFile string
+	// ClientServices names the services client certificates identify, as
+	// subject=service items matching the certificate's common name or a DNS
+	// name (e.g. transactions.internal=transactions)
+	ClientServices []string
+	// ReloadIntervalSeconds is how often the files are checked for renewed
+	// certificates; 0 disables reloading
+	ReloadIntervalSeconds int
 }
 
 // AuthConfig holds authentication configuration
 type AuthConfig struct {
+	// SessionDurationHours is the sliding session lifetime, extended with
+	// every use
 	SessionDurationHours int
-	CookieSecure         bool
+	// SessionMaxLifetimeHours ends sessions this long after login however
+	// active; 0 sets no limit
+	SessionMaxLifetimeHours int
+	// SessionIdleTimeoutMinutes ends sessions unused for this long, if
+	// shorter than SessionDurationHours; 0 leaves that as the idle limit
+	SessionIdleTimeoutMinutes int
+	// SessionRoleMaxLifetimes and SessionRoleIdleTimeouts override the two
+	// per role, as role=duration items (e.g. admin=12h)
+	SessionRoleMaxLifetimes []string
+	SessionRoleIdleTimeouts []string
+	CookieSecure            bool
+	// TOTPIssuer names the service in authenticator apps
+	TOTPIssuer string
+	// TOTPRequiredRoles must log in with a TOTP code; their users enroll on
+	// their next login if they haven't already
+	TOTPRequiredRoles []string
+	// TOTPEncryptionKey is the base64-encoded 32-byte key TOTP secrets are
+	// encrypted with in the database
+	TOTPEncryptionKey string
+	// WebAuthnRPID is the domain passkeys and security keys are bound to
+	WebAuthnRPID string
+	// WebAuthnRPName names the service when browsers ask for a passkey
+	WebAuthnRPName string
+	// WebAuthnRPOrigins are the origins (scheme://host[:port]) WebAuthn
+	// ceremonies may run from
+	WebAuthnRPOrigins []string
+	// PasswordResetURL is the page emailed reset links point to, given the
+	// token as the "token" query parameter
+	PasswordResetURL string
+	// PasswordResetTTLMinutes is how long a reset link works
+	PasswordResetTTLMinutes int
+	// PasswordResetLimit is how many reset emails an address gets per hour
+	PasswordResetLimit int
+	// LoginLockoutThreshold is how many failed logins lock an email address
+	LoginLockoutThreshold int
+	// LoginIPLockoutThreshold is how many failed logins lock a client IP
+	LoginIPLockoutThreshold int
+	// LoginLockoutSeconds is the first lock, doubled with every further
+	// failure up to LoginLockoutMaxMinutes
+	LoginLockoutSeconds    int
+	LoginLockoutMaxMinutes int
+	// PasswordMinLength and PasswordMinCharClasses (of lowercase, uppercase,
+	// digits and symbols) are what new passwords need at least
+	PasswordMinLength      int
+	PasswordMinCharClasses int
+	// PasswordHistory is how many of a user's latest passwords can't be
+	// reused; 0 allows reuse
+	PasswordHistory int
+	// PasswordBreachedList is a file of SHA-1 hashes of breached passwords
+	// refused on top of the bundled common ones
+	PasswordBreachedList string
+	// PasswordHashAlgorithm is argon2id or bcrypt; hashes made with the other
+	// one, or with weaker settings, are replaced at the next login
+	PasswordHashAlgorithm string
+	PasswordBcryptCost    int
+	// PasswordArgon2MemoryKiB, PasswordArgon2Iterations and
+	// PasswordArgon2Parallelism are the argon2id parameters
+	PasswordArgon2MemoryKiB   int
+	PasswordArgon2Iterations  int
+	PasswordArgon2Parallelism int
+}
+
+// MailConfig holds outgoing email configuration
+type MailConfig struct {
+	// Driver is "smtp", "log" (write emails to the log) or "file" (write
+	// them to FileDir), the latter two for local development
+	Driver       string
+	From         string
+	FileDir      string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
 }
 
 // AdminConfig holds the initial admin user seed configuration
@@ -31,6 +209,13 @@ type AdminConfig struct {
 // ServerConfig holds server configuration
 type ServerConfig struct {
 	Port string
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For header is believed; with none, the client IP is
+	// the connection's remote address
+	TrustedProxies []string
+	// MetricsAddr is where /debug/vars is served, apart from the API and
+	// in plain HTTP; loopback only by default, empty to not serve it
+	MetricsAddr string
 }
 
 // DatabaseConfig holds database configuration
@@ -53,7 +238,9 @@ func Load() (*Config, error) {
 	cfg := &Config{
 		Environment: getEnv("APP_ENV", "local"),
 		Server: ServerConfig{
-			Port: getEnv("SERVER_PORT", "8080"),
+			Port:           getEnv("SERVER_PORT", "8080"),
+			TrustedProxies: getEnvAsList("TRUSTED_PROXIES", ""),
+			MetricsAddr:    getEnv("METRICS_ADDR", "127.0.0.1:9090"),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
@@ -67,13 +254,103 @@ func Load() (*Config, error) {
 			Level: getEnv("LOG_LEVEL", "info"),
 		},
 		Auth: AuthConfig{
-			SessionDurationHours: getEnvAsInt("SESSION_DURATION_HOURS", 720),
-			CookieSecure:         getEnv("COOKIE_SECURE", "false") == "true",
+			SessionDurationHours:      getEnvAsInt("SESSION_DURATION_HOURS", 720),
+			SessionMaxLifetimeHours:   getEnvAsInt("SESSION_MAX_LIFETIME_HOURS", 720),
+			SessionIdleTimeoutMinutes: getEnvAsInt("SESSION_IDLE_TIMEOUT_MINUTES", 0),
+			SessionRoleMaxLifetimes:   getEnvAsList("SESSION_ROLE_MAX_LIFETIMES", "admin=12h"),
+			SessionRoleIdleTimeouts:   getEnvAsList("SESSION_ROLE_IDLE_TIMEOUTS", "admin=1h"),
+			CookieSecure:              getEnv("COOKIE_SECURE", "false") == "true",
+			TOTPIssuer:                getEnv("TOTP_ISSUER", "Identity"),
+			TOTPRequiredRoles:         getEnvAsList("TOTP_REQUIRED_ROLES", ""),
+			TOTPEncryptionKey:         getEnv("TOTP_ENCRYPTION_KEY", ""),
+			WebAuthnRPID:              getEnv("WEBAUTHN_RP_ID", "localhost"),
+			WebAuthnRPName:            getEnv("WEBAUTHN_RP_NAME", "Identity"),
+			WebAuthnRPOrigins:         getEnvAsList("WEBAUTHN_RP_ORIGINS", ""),
+			PasswordResetURL:          getEnv("PASSWORD_RESET_URL", ""),
+			PasswordResetTTLMinutes:   getEnvAsInt("PASSWORD_RESET_TTL_MINUTES", 60),
+			PasswordResetLimit:        getEnvAsInt("PASSWORD_RESET_LIMIT", 3),
+			LoginLockoutThreshold:     getEnvAsInt("LOGIN_LOCKOUT_THRESHOLD", 5),
+			LoginIPLockoutThreshold:   getEnvAsInt("LOGIN_IP_LOCKOUT_THRESHOLD", 50),
+			LoginLockoutSeconds:       getEnvAsInt("LOGIN_LOCKOUT_SECONDS", 60),
+			LoginLockoutMaxMinutes:    getEnvAsInt("LOGIN_LOCKOUT_MAX_MINUTES", 60),
+			PasswordMinLength:         getEnvAsInt("PASSWORD_MIN_LENGTH", 8),
+			PasswordMinCharClasses:    getEnvAsInt("PASSWORD_MIN_CHAR_CLASSES", 2),
+			PasswordHistory:           getEnvAsInt("PASSWORD_HISTORY", 5),
+			PasswordBreachedList:      getEnv("PASSWORD_BREACHED_LIST", ""),
+			PasswordHashAlgorithm:     getEnv("PASSWORD_HASH_ALGORITHM", "argon2id"),
+			PasswordBcryptCost:        getEnvAsInt("PASSWORD_BCRYPT_COST", 10),
+			PasswordArgon2MemoryKiB:   getEnvAsInt("PASSWORD_ARGON2_MEMORY_KIB", 19456),
+			PasswordArgon2Iterations:  getEnvAsInt("PASSWORD_ARGON2_ITERATIONS", 2),
+			PasswordArgon2Parallelism: getEnvAsInt("PASSWORD_ARGON2_PARALLELISM", 1),
 		},
 		Admin: AdminConfig{
 			Email:    getEnv("ADMIN_EMAIL", ""),
 			Password: getEnv("ADMIN_PASSWORD", ""),
 		},
+		Mail: MailConfig{
+			Driver:       getEnv("MAIL_DRIVER", "log"),
+			From:         getEnv("MAIL_FROM", "Identity <no-reply@localhost>"),
+			FileDir:      getEnv("MAIL_FILE_DIR", "mail"),
+			SMTPHost:     getEnv("SMTP_HOST", ""),
+			SMTPPort:     getEnv("SMTP_PORT", "587"),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		},
+		FlagCache: FlagCacheConfig{
+			TTLSeconds: getEnvAsInt("FLAG_CACHE_TTL_SECONDS", 60),
+		},
+		FlagScheduler: FlagSchedulerConfig{
+			IntervalSeconds: getEnvAsInt("FLAG_SCHEDULER_INTERVAL_SECONDS", 15),
+		},
+		SessionReaper: SessionReaperConfig{
+			IntervalSeconds: getEnvAsInt("SESSION_REAPER_INTERVAL_SECONDS", 600),
+			BatchSize:       getEnvAsInt("SESSION_REAPER_BATCH_SIZE", 1000),
+		},
+		OIDC: OIDCConfig{
+			Issuer:                getEnv("OIDC_ISSUER", ""),
+			AccessTokenTTLMinutes: getEnvAsInt("OIDC_ACCESS_TOKEN_TTL_MINUTES", 60),
+			RefreshTokenTTLHours:  getEnvAsInt("OIDC_REFRESH_TOKEN_TTL_HOURS", 720),
+			KeyRotationDays:       getEnvAsInt("OIDC_KEY_ROTATION_DAYS", 30),
+		},
+		SessionToken: SessionTokenConfig{
+			TTLSeconds: getEnvAsInt("SESSION_TOKEN_TTL_SECONDS", 300),
+			Issuer:     getEnv("SESSION_TOKEN_ISSUER", "identity"),
+		},
+		ExternalLogin: ExternalLoginConfig{
+			CallbackURL: getEnv("LOGIN_CALLBACK_URL", ""),
+		},
+		APIKeys: APIKeysConfig{
+			Required: getEnv("API_KEY_REQUIRED", "false") == "true",
+		},
+		TLS: TLSConfig{
+			CertFile:              getEnv("TLS_CERT_FILE", ""),
+			KeyFile:               getEnv("TLS_KEY_FILE", ""),
+			ClientCAFile:          getEnv("TLS_CLIENT_CA_FILE", ""),
+			ClientServices:        getEnvAsList("TLS_CLIENT_SERVICES", ""),
+			ReloadIntervalSeconds: getEnvAsInt("TLS_RELOAD_INTERVAL_SECONDS", 60),
+		},
+	}
+	for _, id := range getEnvAsList("LOGIN_PROVIDERS", "") {
+		prefix := "LOGIN_PROVIDER_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_"
+		cfg.ExternalLogin.Providers = append(cfg.ExternalLogin.Providers, ExternalProviderConfig{
+			ID:             id,
+			Name:           getEnv(prefix+"NAME", id),
+			Issuer:         getEnv(prefix+"ISSUER", ""),
+			ClientID:       getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret:   getEnv(prefix+"CLIENT_SECRET", ""),
+			AllowedDomains: getEnvAsList(prefix+"ALLOWED_DOMAINS", ""),
+			Provision:      getEnv(prefix+"PROVISION", "false") == "true",
+			LinkExisting:   getEnv(prefix+"LINK_EXISTING", "false") == "true",
+		})
+	}
+	if cfg.ExternalLogin.CallbackURL == "" {
+		cfg.ExternalLogin.CallbackURL = "http://localhost:" + cfg.Server.Port + "/admin/login/external/callback"
+	}
+	if len(cfg.Auth.WebAuthnRPOrigins) == 0 {
+		cfg.Auth.WebAuthnRPOrigins = []string{"http://localhost:" + cfg.Server.Port}
+	}
+	if cfg.Auth.PasswordResetURL == "" {
+		cfg.Auth.PasswordResetURL = "http://localhost:" + cfg.Server.Port + "/admin/reset-password"
 	}
 
 	return cfg, nil
@@ -103,3 +380,15 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getEnvAsList reads a comma-separated environment variable, skipping empty
+// items, or the default value if unset
+func getEnvAsList(key, defaultValue string) []string {
+	var items []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
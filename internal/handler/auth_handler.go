@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"identity/internal/service"
 	"identity/internal/service/dto"
 	"log/slog"
@@ -56,9 +57,22 @@ func (h *AuthHandler) clearSessionCookieIfPresent(c *gin.Context) {
 	}
 }
 
+// setSessionCookie hands the browser a newly created session
+func (h *AuthHandler) setSessionCookie(c *gin.Context, sessionID string) {
+	c.SetCookie(
+		SessionCookieName,
+		sessionID,
+		h.cookieMaxAge,
+		"/",
+		"",
+		h.cookieSecure,
+		true, // HttpOnly
+	)
+}
+
 // Login godoc
 // @Summary Login user
-// @Description Authenticate a user and create a session
+// @Description Authenticate a user and create a session. Users with two-factor authentication get two_factor_required and a challenge_token instead of a session; complete the login with /api/v1/auth/login/verify. Too many failed logins for the email or from the client IP lock them out for a while (429), whether or not the email has an account.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -66,6 +80,7 @@ func (h *AuthHandler) clearSessionCookieIfPresent(c *gin.Context) {
 // @Success 200 {object} dto.LoginResponse
 // @Failure 400 {object} dto.ErrorResponse
 // @Failure 401 {object} dto.ErrorResponse
+// @Failure 429 {object} dto.ErrorResponse
 // @Router /api/v1/auth/login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req dto.LoginRequest
@@ -80,6 +95,14 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
 	resp, err := h.authService.Login(c.Request.Context(), &req)
 	if err != nil {
+		if errors.Is(err, service.ErrLoginLocked) {
+			h.logger.Warn("login locked out", "email", req.Email, "client_ip", c.ClientIP())
+			c.JSON(http.StatusTooManyRequests, dto.ErrorResponse{
+				Error:   "too_many_attempts",
+				Message: err.Error(),
+			})
+			return
+		}
 		h.logger.Error("login failed", "error", err, "email", req.Email)
 		c.JSON(http.StatusUnauthorized, dto.ErrorResponse{
 			Error:   "login_failed",
@@ -88,16 +111,14 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// Set session cookie
-	c.SetCookie(
-		SessionCookieName,
-		resp.SessionID,
-		h.cookieMaxAge,
-		"/",
-		"",
-		h.cookieSecure,
-		true, // HttpOnly
-	)
+	// The password checked out, but there is no session until the second
+	// factor does too
+	if resp.TwoFactorRequired {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	h.setSessionCookie(c, resp.SessionID)
 
 	h.logger.Info("user logged in", "user_id", resp.User.ID, "email", resp.User.Email)
 	c.JSON(http.StatusOK, resp)
@@ -137,7 +158,7 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 
 // ValidateSession godoc
 // @Summary Validate a session
-// @Description Validate a session ID and return user info. Accepts session_id in JSON body or X-Session-ID header.
+// @Description Validate a session ID and return user info, with when the session expires if left idle (session_expires_at) and when it ends regardless (session_absolute_expires_at). Accepts session_id in JSON body or X-Session-ID header.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -180,7 +201,7 @@ func (h *AuthHandler) ValidateSession(c *gin.Context) {
 
 // Me godoc
 // @Summary Get current user
-// @Description Get the currently authenticated user's information
+// @Description Get the currently authenticated user's information, with the session's idle and absolute expiries
 // @Tags auth
 // @Produce json
 // @Success 200 {object} dto.UserResponse
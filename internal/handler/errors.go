@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"errors"
+	"identity/internal/service"
+	"identity/internal/service/dto"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeForbidden responds with 403 when err is a service permission error and
+// reports whether it did, so handlers can bail out before their own mapping.
+func writeForbidden(c *gin.Context, err error) bool {
+	if !errors.Is(err, service.ErrForbidden) {
+		return false
+	}
+	c.JSON(http.StatusForbidden, dto.ErrorResponse{
+		Error:   "forbidden",
+		Message: err.Error(),
+	})
+	return true
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"identity/internal/service"
+	"identity/internal/service/dto"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExternalLoginHandler manages the external identities linked to users
+type ExternalLoginHandler struct {
+	externalLogin service.ExternalLoginService
+	logger        *slog.Logger
+}
+
+// NewExternalLoginHandler creates a new external login handler
+func NewExternalLoginHandler(externalLogin service.ExternalLoginService, logger *slog.Logger) *ExternalLoginHandler {
+	return &ExternalLoginHandler{
+		externalLogin: externalLogin,
+		logger:        logger,
+	}
+}
+
+// GetUserIdentities godoc
+// @Summary List a user's external identities
+// @Description List the accounts at external identity providers linked to a user, which sign them in
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} dto.UserIdentityResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/v1/users/{id}/identities [get]
+func (h *ExternalLoginHandler) GetUserIdentities(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid user ID",
+		})
+		return
+	}
+
+	identities, err := h.externalLogin.GetUserIdentities(c.Request.Context(), uint(id))
+	if err != nil {
+		h.writeIdentityError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+// UnlinkUserIdentity godoc
+// @Summary Unlink a user's external identity
+// @Description Remove an account at an external identity provider from a user, so it no longer signs them in. Signing in with it again links it anew, to the user with its verified email address if there is one.
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param identityId path int true "Identity ID"
+// @Success 200 {object} dto.SuccessResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/v1/users/{id}/identities/{identityId} [delete]
+func (h *ExternalLoginHandler) UnlinkUserIdentity(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid user ID",
+		})
+		return
+	}
+	identityID, err := strconv.ParseUint(c.Param("identityId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid identity ID",
+		})
+		return
+	}
+
+	if err := h.externalLogin.UnlinkIdentity(c.Request.Context(), uint(userID), uint(identityID)); err != nil {
+		h.writeIdentityError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Message: "Identity unlinked",
+	})
+}
+
+func (h *ExternalLoginHandler) writeIdentityError(c *gin.Context, err error) {
+	if writeForbidden(c, err) {
+		return
+	}
+	switch err.Error() {
+	case "user not found", "identity not found":
+		c.JSON(http.StatusNotFound, dto.ErrorResponse{
+			Error:   "not_found",
+			Message: err.Error(),
+		})
+	default:
+		h.logger.Error("failed to manage identities", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to manage external identities",
+		})
+	}
+}
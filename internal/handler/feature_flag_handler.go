@@ -1,15 +1,24 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"identity/internal/service"
 	"identity/internal/service/dto"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// streamHeartbeatInterval is how often an open flag stream sends a heartbeat
+const streamHeartbeatInterval = 15 * time.Second
+
 // FeatureFlagHandler handles HTTP requests for feature flags
 type FeatureFlagHandler struct {
 	featureFlagService service.FeatureFlagService
@@ -26,13 +35,13 @@ func NewFeatureFlagHandler(featureFlagService service.FeatureFlagService, logger
 
 // CheckFeatureFlag godoc
 // @Summary Check if a feature flag is enabled
-// @Description Check whether a feature flag is enabled globally, or for a specific user. A flag is enabled for a user if it is globally enabled OR explicitly assigned to that user.
+// @Description Check whether a feature flag is enabled globally, or for a specific user. A flag is enabled for a user if it is globally enabled, explicitly assigned to that user, matched by a targeting rule, OR the user falls inside the rollout percentage. The response also carries the resolved variant and its value for multivariate flags. Use the POST variant to pass extra context attributes for targeting rules.
 // @Tags feature-flags
 // @Accept json
 // @Produce json
 // @Param key query string true "Feature flag key"
 // @Param user_id query int false "User ID (optional)"
-// @Success 200 {object} map[string]bool
+// @Success 200 {object} dto.FlagEvaluationResponse
 // @Failure 400 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
@@ -47,21 +56,124 @@ func (h *FeatureFlagHandler) CheckFeatureFlag(c *gin.Context) {
 		return
 	}
 
-	var userID *uint
-	if userIDStr := c.Query("user_id"); userIDStr != "" {
-		id, err := strconv.ParseUint(userIDStr, 10, 32)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
-				Error:   "invalid_request",
-				Message: "Invalid user_id",
-			})
-			return
-		}
-		uid := uint(id)
-		userID = &uid
+	userID, ok := userIDQuery(c)
+	if !ok {
+		return
+	}
+
+	h.evaluate(c, key, &dto.EvaluationContext{UserID: userID})
+}
+
+// EvaluateFeatureFlag godoc
+// @Summary Evaluate a feature flag against a context
+// @Description Evaluate a feature flag for an optional user plus arbitrary context attributes (e.g. groups, plan, country) used by the flag's targeting rules. Attributes from the user record (user_id, email, name, role, created_at) are ignored in the context.
+// @Tags feature-flags
+// @Accept json
+// @Produce json
+// @Param request body dto.EvaluateFeatureFlagRequest true "Flag key and evaluation context"
+// @Success 200 {object} dto.FlagEvaluationResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/v1/feature-flags/check [post]
+func (h *FeatureFlagHandler) EvaluateFeatureFlag(c *gin.Context) {
+	var req dto.EvaluateFeatureFlagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	h.evaluate(c, req.Key, &dto.EvaluationContext{UserID: req.UserID, Attributes: req.Context})
+}
+
+// EvaluateFeatureFlags godoc
+// @Summary Evaluate many feature flags at once
+// @Description Evaluate every feature flag (or those listed in keys, comma-separated) for an optional user in one call, e.g. to bootstrap a frontend. Unknown keys are left out of the result.
+// @Tags feature-flags
+// @Accept json
+// @Produce json
+// @Param keys query string false "Comma-separated flag keys (default: all flags)"
+// @Param user_id query int false "User ID (optional)"
+// @Success 200 {object} dto.BulkEvaluationResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/v1/feature-flags/evaluate [get]
+func (h *FeatureFlagHandler) EvaluateFeatureFlags(c *gin.Context) {
+	userID, ok := userIDQuery(c)
+	if !ok {
+		return
+	}
+
+	var keys []string
+	if keysStr := c.Query("keys"); keysStr != "" {
+		keys = strings.Split(keysStr, ",")
+	}
+
+	h.evaluateBulk(c, keys, &dto.EvaluationContext{UserID: userID})
+}
+
+// EvaluateFeatureFlagsWithContext godoc
+// @Summary Evaluate many feature flags against a context
+// @Description Evaluate every feature flag (or those listed in keys) for an optional user plus context attributes used by targeting rules, in one call. Unknown keys are left out of the result.
+// @Tags feature-flags
+// @Accept json
+// @Produce json
+// @Param request body dto.BulkEvaluateRequest true "Flag keys and evaluation context"
+// @Success 200 {object} dto.BulkEvaluationResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/v1/feature-flags/evaluate [post]
+func (h *FeatureFlagHandler) EvaluateFeatureFlagsWithContext(c *gin.Context) {
+	var req dto.BulkEvaluateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	h.evaluateBulk(c, req.Keys, &dto.EvaluationContext{UserID: req.UserID, Attributes: req.Context})
+}
+
+func (h *FeatureFlagHandler) evaluateBulk(c *gin.Context, keys []string, evalCtx *dto.EvaluationContext) {
+	results, err := h.featureFlagService.EvaluateFeatureFlags(c.Request.Context(), keys, evalCtx)
+	if err != nil {
+		h.logger.Error("failed to evaluate feature flags", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "check_failed",
+			Message: err.Error(),
+		})
+		return
 	}
 
-	enabled, err := h.featureFlagService.CheckFeatureFlag(c.Request.Context(), key, userID)
+	c.JSON(http.StatusOK, dto.BulkEvaluationResponse{Flags: results})
+}
+
+// userIDQuery parses the optional user_id query parameter, answering 400 if
+// it is malformed
+func userIDQuery(c *gin.Context) (*uint, bool) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		return nil, true
+	}
+	id, err := strconv.ParseUint(userIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Invalid user_id",
+		})
+		return nil, false
+	}
+	uid := uint(id)
+	return &uid, true
+}
+
+func (h *FeatureFlagHandler) evaluate(c *gin.Context, key string, evalCtx *dto.EvaluationContext) {
+	result, err := h.featureFlagService.EvaluateFeatureFlag(c.Request.Context(), key, evalCtx)
 	if err != nil {
 		if err.Error() == "feature flag not found" {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
@@ -78,7 +190,124 @@ func (h *FeatureFlagHandler) CheckFeatureFlag(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"enabled": enabled})
+	c.JSON(http.StatusOK, result)
+}
+
+// StreamFeatureFlags godoc
+// @Summary Stream feature flag changes (Server-Sent Events)
+// @Description Keep a local copy of every flag without polling. The stream opens with a snapshot event (all flags and user assignments), then sends flag, flag_deleted and assignment events carrying the current state of whatever changed, and a heartbeat event every 15 seconds. Each event except heartbeats has an id; reconnect with it in the Last-Event-ID header to resume where the stream left off. If the changes since then are no longer known the stream starts over with a fresh snapshot.
+// @Tags feature-flags
+// @Produce text/event-stream
+// @Param Last-Event-ID header int false "ID of the last event received, to resume"
+// @Success 200 {object} dto.FlagSnapshot "snapshot event data; see dto.FeatureFlagResponse, dto.FlagDeletedEvent, dto.FlagAssignmentEvent and dto.HeartbeatEvent for the others"
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/v1/feature-flags/stream [get]
+func (h *FeatureFlagHandler) StreamFeatureFlags(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var lastEventID uint64
+	resume := false
+	if idStr := c.GetHeader("Last-Event-ID"); idStr != "" {
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Invalid Last-Event-ID",
+			})
+			return
+		}
+		lastEventID, resume = id, true
+	}
+
+	// Subscribe before reading anything, so no change slips in between
+	changes, unsubscribe := h.featureFlagService.SubscribeFlagChanges()
+	defer unsubscribe()
+
+	var events []dto.FlagStreamEvent
+	var err error
+	if resume {
+		events, err = h.featureFlagService.FlagChangesSince(ctx, lastEventID)
+	}
+	if !resume || errors.Is(err, service.ErrResyncRequired) {
+		events, err = h.snapshotEvents(ctx)
+	}
+	if err != nil {
+		h.logger.Error("failed to start flag stream", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "stream_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		for _, event := range events {
+			if err := writeStreamEvent(c, event); err != nil {
+				return
+			}
+			lastEventID = event.ID
+		}
+		c.Writer.Flush()
+
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-changes:
+			if !ok {
+				// Shutting down; the client reconnects elsewhere and resumes
+				return
+			}
+		case now := <-heartbeat.C:
+			if err := writeStreamEvent(c, dto.FlagStreamEvent{Event: dto.FlagStreamHeartbeat, Data: dto.HeartbeatEvent{Time: now.UTC()}}); err != nil {
+				return
+			}
+		}
+
+		// Heartbeats re-read the log too, in case a notification was missed
+		events, err = h.featureFlagService.FlagChangesSince(ctx, lastEventID)
+		if errors.Is(err, service.ErrResyncRequired) {
+			events, err = h.snapshotEvents(ctx)
+		}
+		if err != nil {
+			// The client resumes from lastEventID once it reconnects
+			h.logger.Error("failed to read flag changes", "error", err)
+			return
+		}
+	}
+}
+
+func (h *FeatureFlagHandler) snapshotEvents(ctx context.Context) ([]dto.FlagStreamEvent, error) {
+	snapshot, err := h.featureFlagService.FlagSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []dto.FlagStreamEvent{*snapshot}, nil
+}
+
+// writeStreamEvent writes one SSE event; heartbeats carry no id so they
+// don't move the client's resume point
+func writeStreamEvent(c *gin.Context, event dto.FlagStreamEvent) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	if event.Event != dto.FlagStreamHeartbeat {
+		if _, err := fmt.Fprintf(c.Writer, "id: %d\n", event.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event.Event, data)
+	return err
 }
 
 // CreateFeatureFlag godoc
@@ -90,6 +319,7 @@ func (h *FeatureFlagHandler) CheckFeatureFlag(c *gin.Context) {
 // @Param feature_flag body dto.CreateFeatureFlagRequest true "Feature flag information"
 // @Success 201 {object} dto.FeatureFlagResponse
 // @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/v1/feature-flags [post]
 func (h *FeatureFlagHandler) CreateFeatureFlag(c *gin.Context) {
@@ -105,6 +335,16 @@ func (h *FeatureFlagHandler) CreateFeatureFlag(c *gin.Context) {
 
 	flag, err := h.featureFlagService.CreateFeatureFlag(c.Request.Context(), &req)
 	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrInvalidRules) || errors.Is(err, service.ErrInvalidVariants) || errors.Is(err, service.ErrInvalidPrerequisites) {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_request",
+				Message: err.Error(),
+			})
+			return
+		}
 		h.logger.Error("failed to create feature flag", "error", err)
 		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
 			Error:   "creation_failed",
@@ -125,6 +365,7 @@ func (h *FeatureFlagHandler) CreateFeatureFlag(c *gin.Context) {
 // @Param id path int true "Feature Flag ID"
 // @Success 200 {object} dto.FeatureFlagResponse
 // @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/v1/feature-flags/{id} [get]
@@ -141,6 +382,9 @@ func (h *FeatureFlagHandler) GetFeatureFlag(c *gin.Context) {
 
 	flag, err := h.featureFlagService.GetFeatureFlag(c.Request.Context(), uint(id))
 	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
 		if err.Error() == "feature flag not found" {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
 				Error:   "not_found",
@@ -168,6 +412,7 @@ func (h *FeatureFlagHandler) GetFeatureFlag(c *gin.Context) {
 // @Param page query int false "Page number" default(1)
 // @Param page_size query int false "Page size" default(10)
 // @Success 200 {object} dto.FeatureFlagListResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/v1/feature-flags [get]
 func (h *FeatureFlagHandler) GetFeatureFlags(c *gin.Context) {
@@ -191,6 +436,9 @@ func (h *FeatureFlagHandler) GetFeatureFlags(c *gin.Context) {
 
 	flags, err := h.featureFlagService.GetFeatureFlags(c.Request.Context(), &pagination)
 	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
 		h.logger.Error("failed to get feature flags", "error", err)
 		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
 			Error:   "retrieval_failed",
@@ -212,6 +460,7 @@ func (h *FeatureFlagHandler) GetFeatureFlags(c *gin.Context) {
 // @Param feature_flag body dto.UpdateFeatureFlagRequest true "Feature flag information to update"
 // @Success 200 {object} dto.FeatureFlagResponse
 // @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/v1/feature-flags/{id} [put]
@@ -238,6 +487,16 @@ func (h *FeatureFlagHandler) UpdateFeatureFlag(c *gin.Context) {
 
 	flag, err := h.featureFlagService.UpdateFeatureFlag(c.Request.Context(), uint(id), &req)
 	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrInvalidRules) || errors.Is(err, service.ErrInvalidVariants) || errors.Is(err, service.ErrInvalidPrerequisites) {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_request",
+				Message: err.Error(),
+			})
+			return
+		}
 		if err.Error() == "feature flag not found" {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
 				Error:   "not_found",
@@ -258,14 +517,16 @@ func (h *FeatureFlagHandler) UpdateFeatureFlag(c *gin.Context) {
 
 // DeleteFeatureFlag godoc
 // @Summary Delete a feature flag
-// @Description Soft delete a feature flag by feature flag ID
+// @Description Soft delete a feature flag by feature flag ID. Flags other flags depend on can't be deleted (409).
 // @Tags feature-flags
 // @Accept json
 // @Produce json
 // @Param id path int true "Feature Flag ID"
 // @Success 200 {object} dto.SuccessResponse
 // @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/v1/feature-flags/{id} [delete]
 func (h *FeatureFlagHandler) DeleteFeatureFlag(c *gin.Context) {
@@ -281,6 +542,16 @@ func (h *FeatureFlagHandler) DeleteFeatureFlag(c *gin.Context) {
 
 	err = h.featureFlagService.DeleteFeatureFlag(c.Request.Context(), uint(id))
 	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrFlagHasDependents) {
+			c.JSON(http.StatusConflict, dto.ErrorResponse{
+				Error:   "conflict",
+				Message: err.Error(),
+			})
+			return
+		}
 		if err.Error() == "feature flag not found" {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
 				Error:   "not_found",
@@ -300,3 +571,150 @@ func (h *FeatureFlagHandler) DeleteFeatureFlag(c *gin.Context) {
 		Message: "Feature flag deleted successfully",
 	})
 }
+
+// ScheduleFlagChange godoc
+// @Summary Schedule a flag change
+// @Description Schedule enabling, disabling or setting the rollout percentage of a feature flag at a future time. The server's flag scheduler applies it once run_at has passed.
+// @Tags feature-flags
+// @Accept json
+// @Produce json
+// @Param id path int true "Feature Flag ID"
+// @Param change body dto.ScheduleFlagChangeRequest true "Scheduled change"
+// @Success 201 {object} dto.ScheduledFlagChangeResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/v1/feature-flags/{id}/scheduled-changes [post]
+func (h *FeatureFlagHandler) ScheduleFlagChange(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid feature flag ID",
+		})
+		return
+	}
+
+	var req dto.ScheduleFlagChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	change, err := h.featureFlagService.ScheduleFlagChange(c.Request.Context(), uint(id), &req)
+	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrInvalidSchedule) {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_request",
+				Message: err.Error(),
+			})
+			return
+		}
+		if err.Error() == "feature flag not found" {
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: err.Error(),
+			})
+			return
+		}
+		h.logger.Error("failed to schedule flag change", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "creation_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, change)
+}
+
+// GetScheduledFlagChanges godoc
+// @Summary List scheduled flag changes
+// @Description List the scheduled flag changes that have not run yet, soonest first
+// @Tags feature-flags
+// @Accept json
+// @Produce json
+// @Success 200 {array} dto.ScheduledFlagChangeResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/v1/feature-flags/scheduled-changes [get]
+func (h *FeatureFlagHandler) GetScheduledFlagChanges(c *gin.Context) {
+	changes, err := h.featureFlagService.GetScheduledFlagChanges(c.Request.Context())
+	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
+		h.logger.Error("failed to get scheduled flag changes", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "retrieval_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, changes)
+}
+
+// CancelScheduledFlagChange godoc
+// @Summary Cancel a scheduled flag change
+// @Description Cancel a scheduled flag change that has not run yet
+// @Tags feature-flags
+// @Accept json
+// @Produce json
+// @Param id path int true "Scheduled change ID"
+// @Success 200 {object} dto.SuccessResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/v1/feature-flags/scheduled-changes/{id} [delete]
+func (h *FeatureFlagHandler) CancelScheduledFlagChange(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid scheduled change ID",
+		})
+		return
+	}
+
+	err = h.featureFlagService.CancelScheduledFlagChange(c.Request.Context(), uint(id))
+	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrInvalidSchedule) {
+			c.JSON(http.StatusConflict, dto.ErrorResponse{
+				Error:   "conflict",
+				Message: err.Error(),
+			})
+			return
+		}
+		if err.Error() == "scheduled flag change not found" {
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: err.Error(),
+			})
+			return
+		}
+		h.logger.Error("failed to cancel scheduled flag change", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "deletion_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Message: "Scheduled flag change cancelled",
+	})
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"identity/internal/service/dto"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUserLockout godoc
+// @Summary Get a user's login lockout
+// @Description Get how many failed logins a user's email has and, while it is locked out, when it unlocks
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} dto.LoginLockoutResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/v1/users/{id}/lockout [get]
+func (h *AuthHandler) GetUserLockout(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid user ID",
+		})
+		return
+	}
+
+	lockout, err := h.authService.GetUserLockout(c.Request.Context(), uint(id))
+	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
+		if err.Error() == "user not found" {
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: err.Error(),
+			})
+			return
+		}
+		h.logger.Error("failed to get login lockout", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to get login lockout",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, lockout)
+}
+
+// UnlockUser godoc
+// @Summary Unlock a user's login
+// @Description Clear a user's failed logins and lift their lockout. Lockouts of client IPs stay.
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} dto.SuccessResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/v1/users/{id}/lockout [delete]
+func (h *AuthHandler) UnlockUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid user ID",
+		})
+		return
+	}
+
+	if err := h.authService.UnlockUser(c.Request.Context(), uint(id)); err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
+		if err.Error() == "user not found" {
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: err.Error(),
+			})
+			return
+		}
+		h.logger.Error("failed to unlock user", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "unlock_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Message: "User unlocked",
+	})
+}
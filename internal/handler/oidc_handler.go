@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"errors"
+	"identity/internal/service"
+	"identity/internal/service/dto"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OIDCHandler serves the OpenID Connect provider's machine-facing
+// endpoints; the authorization endpoint, which users see, is on WebHandler
+type OIDCHandler struct {
+	oidc   service.OIDCService
+	logger *slog.Logger
+}
+
+// NewOIDCHandler creates a new OpenID Connect handler
+func NewOIDCHandler(oidc service.OIDCService, logger *slog.Logger) *OIDCHandler {
+	return &OIDCHandler{
+		oidc:   oidc,
+		logger: logger,
+	}
+}
+
+// Discovery godoc
+// @Summary OpenID Connect discovery document
+// @Description Describe the OpenID Connect provider: its endpoints and what it supports
+// @Tags oidc
+// @Produce json
+// @Success 200 {object} dto.OIDCDiscovery
+// @Router /.well-known/openid-configuration [get]
+func (h *OIDCHandler) Discovery(c *gin.Context) {
+	c.JSON(http.StatusOK, h.oidc.Discovery())
+}
+
+// JWKS godoc
+// @Summary Token signing keys
+// @Description The public keys ID tokens are signed with. Keys rotate; a new key is published a while before it signs anything and stays published until the tokens it signed have expired.
+// @Tags oidc
+// @Produce json
+// @Success 200 {object} dto.JWKS
+// @Failure 500 {object} dto.OAuthErrorResponse
+// @Router /oauth2/jwks [get]
+func (h *OIDCHandler) JWKS(c *gin.Context) {
+	jwks, err := h.oidc.JWKS(c.Request.Context())
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, jwks)
+}
+
+// Token godoc
+// @Summary Exchange a code or refresh token for tokens
+// @Description Exchange an authorization code (with its PKCE code_verifier) or a refresh token for an access token, a new refresh token and an ID token. Confidential clients authenticate with HTTP Basic or client_id and client_secret; public clients send client_id only. A refresh token works once; presenting it again revokes every token of its grant.
+// @Tags oidc
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param request formData dto.TokenRequest true "Token request"
+// @Success 200 {object} dto.TokenResponse
+// @Failure 400 {object} dto.OAuthErrorResponse
+// @Failure 401 {object} dto.OAuthErrorResponse
+// @Router /oauth2/token [post]
+func (h *OIDCHandler) Token(c *gin.Context) {
+	var req dto.TokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		h.writeError(c, &service.OAuthError{Code: "invalid_request", Description: "malformed token request"})
+		return
+	}
+	if !clientCredentials(c, &req.ClientID, &req.ClientSecret) {
+		h.writeError(c, &service.OAuthError{Code: "invalid_client", Description: "malformed client credentials"})
+		return
+	}
+
+	resp, err := h.oidc.Token(c.Request.Context(), &req)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, resp)
+}
+
+// UserInfo godoc
+// @Summary Claims about the signed-in user
+// @Description Return the claims about the user an access token's scopes allow: name with profile, email with email and role with roles
+// @Tags oidc
+// @Produce json
+// @Param Authorization header string true "Bearer access token"
+// @Success 200 {object} dto.UserInfoResponse
+// @Failure 401 {object} dto.OAuthErrorResponse
+// @Router /oauth2/userinfo [get]
+// @Router /oauth2/userinfo [post]
+func (h *OIDCHandler) UserInfo(c *gin.Context) {
+	scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		c.Header("WWW-Authenticate", `Bearer`)
+		c.JSON(http.StatusUnauthorized, dto.OAuthErrorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "a bearer access token is required",
+		})
+		return
+	}
+
+	info, err := h.oidc.UserInfo(c.Request.Context(), token)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAccessToken) {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, dto.OAuthErrorResponse{
+				Error:            "invalid_token",
+				ErrorDescription: err.Error(),
+			})
+			return
+		}
+		h.writeError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, info)
+}
+
+// Revoke godoc
+// @Summary Revoke a token
+// @Description Revoke an access or refresh token (RFC 7009); revoking a refresh token revokes every token of its grant. Clients authenticate as for the token endpoint. Unknown tokens are not an error.
+// @Tags oidc
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param request formData dto.RevokeTokenRequest true "Token to revoke"
+// @Success 200
+// @Failure 400 {object} dto.OAuthErrorResponse
+// @Failure 401 {object} dto.OAuthErrorResponse
+// @Router /oauth2/revoke [post]
+func (h *OIDCHandler) Revoke(c *gin.Context) {
+	var req dto.RevokeTokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		h.writeError(c, &service.OAuthError{Code: "invalid_request", Description: "token is required"})
+		return
+	}
+	if !clientCredentials(c, &req.ClientID, &req.ClientSecret) {
+		h.writeError(c, &service.OAuthError{Code: "invalid_client", Description: "malformed client credentials"})
+		return
+	}
+
+	if err := h.oidc.Revoke(c.Request.Context(), &req); err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// clientCredentials takes the client's credentials from HTTP Basic
+// authentication if it was used, which must then be the only ones sent.
+// OAuth 2.0 form-encodes both parts before Basic encoding them.
+func clientCredentials(c *gin.Context, clientID, clientSecret *string) bool {
+	user, password, ok := c.Request.BasicAuth()
+	if !ok {
+		return true
+	}
+	if *clientID != "" && *clientID != user || *clientSecret != "" {
+		return false
+	}
+
+	var err error
+	if *clientID, err = url.QueryUnescape(user); err != nil {
+		return false
+	}
+	if *clientSecret, err = url.QueryUnescape(password); err != nil {
+		return false
+	}
+	return true
+}
+
+// writeError answers with an OAuth 2.0 error: 401 for failed client
+// authentication, 400 for the others, and 500 for anything unexpected
+func (h *OIDCHandler) writeError(c *gin.Context, err error) {
+	var oauthErr *service.OAuthError
+	if !errors.As(err, &oauthErr) {
+		h.logger.Error("OpenID Connect request failed", "path", c.FullPath(), "error", err)
+		c.JSON(http.StatusInternalServerError, dto.OAuthErrorResponse{
+			Error:            "server_error",
+			ErrorDescription: "the request could not be completed",
+		})
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == "invalid_client" {
+		status = http.StatusUnauthorized
+		c.Header("WWW-Authenticate", `Basic realm="oauth2"`)
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, dto.OAuthErrorResponse{
+		Error:            oauthErr.Code,
+		ErrorDescription: oauthErr.Description,
+	})
+}
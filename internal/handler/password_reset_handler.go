@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"errors"
+	"identity/internal/service"
+	"identity/internal/service/dto"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PasswordResetHandler handles forgotten password requests
+type PasswordResetHandler struct {
+	passwordResetService service.PasswordResetService
+	logger               *slog.Logger
+}
+
+// NewPasswordResetHandler creates a new password reset handler
+func NewPasswordResetHandler(passwordResetService service.PasswordResetService, logger *slog.Logger) *PasswordResetHandler {
+	return &PasswordResetHandler{
+		passwordResetService: passwordResetService,
+		logger:               logger,
+	}
+}
+
+// ForgotPassword godoc
+// @Summary Request a password reset link
+// @Description Email a single-use link to reset the password of the account with this email. The answer is the same whether or not there is such an account; each address gets a limited number of links per hour.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.ForgotPasswordRequest true "Email"
+// @Success 202 {object} dto.SuccessResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Router /api/v1/auth/password/forgot [post]
+func (h *PasswordResetHandler) ForgotPassword(c *gin.Context) {
+	var req dto.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "a valid email is required",
+		})
+		return
+	}
+
+	if err := h.passwordResetService.RequestPasswordReset(c.Request.Context(), req.Email); err != nil {
+		h.logger.Error("failed to request password reset", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to request a password reset",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, dto.SuccessResponse{
+		Message: "If an account exists for this email, a link to reset its password has been sent",
+	})
+}
+
+// ResetPassword godoc
+// @Summary Reset a password
+// @Description Set a new password with the token from a reset link. All of the user's sessions end. A password the policy refuses (weak_password) leaves the token usable.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.ResetPasswordRequest true "Token and new password"
+// @Success 200 {object} dto.SuccessResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Router /api/v1/auth/password/reset [post]
+func (h *PasswordResetHandler) ResetPassword(c *gin.Context) {
+	var req dto.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "token and password are required",
+		})
+		return
+	}
+
+	if err := h.passwordResetService.ResetPassword(c.Request.Context(), &req); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidResetToken):
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_token",
+				Message: err.Error(),
+			})
+		case errors.Is(err, service.ErrWeakPassword):
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "weak_password",
+				Message: err.Error(),
+			})
+		case err.Error() == "user account is disabled":
+			c.JSON(http.StatusUnauthorized, dto.ErrorResponse{
+				Error:   "reset_failed",
+				Message: err.Error(),
+			})
+		default:
+			h.logger.Error("failed to reset password", "error", err)
+			c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+				Error:   "internal_error",
+				Message: "Failed to reset password",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Message: "Password changed, please log in again",
+	})
+}
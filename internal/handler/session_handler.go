@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"identity/internal/middleware"
+	"identity/internal/service/dto"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSessions godoc
+// @Summary List my sessions
+// @Description List the current user's active sessions with the device, IP address and user agent they were created from and when they were last used. The session making the request has current set.
+// @Tags auth
+// @Produce json
+// @Success 200 {array} dto.SessionResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Router /api/v1/auth/sessions [get]
+func (h *AuthHandler) GetSessions(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+
+	sessions, err := h.authService.GetSessions(c.Request.Context(), user.ID, middleware.SessionIDFromRequest(c))
+	if err != nil {
+		h.writeSessionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession godoc
+// @Summary End one of my sessions
+// @Description End a session of the current user, e.g. one left open on another device. Ending the current session logs out.
+// @Tags auth
+// @Produce json
+// @Param id path string true "Session ID from the session list"
+// @Success 200 {object} dto.SuccessResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/v1/auth/sessions/{id} [delete]
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+
+	if err := h.authService.RevokeSession(c.Request.Context(), user.ID, c.Param("id")); err != nil {
+		h.writeSessionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Message: "Session revoked",
+	})
+}
+
+// GetUserSessions godoc
+// @Summary List a user's sessions
+// @Description List a user's active sessions with the device, IP address and user agent they were created from and when they were last used
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} dto.SessionResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/v1/users/{id}/sessions [get]
+func (h *AuthHandler) GetUserSessions(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid user ID",
+		})
+		return
+	}
+
+	sessions, err := h.authService.GetUserSessions(c.Request.Context(), uint(id))
+	if err != nil {
+		h.writeSessionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeUserSession godoc
+// @Summary End a user's session
+// @Description End one session of a user, e.g. one on a lost device. To end all of them, use force logout.
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param sessionId path string true "Session ID from the session list"
+// @Success 200 {object} dto.SuccessResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/v1/users/{id}/sessions/{sessionId} [delete]
+func (h *AuthHandler) RevokeUserSession(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid user ID",
+		})
+		return
+	}
+
+	if err := h.authService.RevokeUserSession(c.Request.Context(), uint(id), c.Param("sessionId")); err != nil {
+		h.writeSessionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Message: "Session revoked",
+	})
+}
+
+// writeSessionError maps errors of the session endpoints to responses
+func (h *AuthHandler) writeSessionError(c *gin.Context, err error) {
+	if writeForbidden(c, err) {
+		return
+	}
+	switch err.Error() {
+	case "user not found", "session not found":
+		c.JSON(http.StatusNotFound, dto.ErrorResponse{
+			Error:   "not_found",
+			Message: err.Error(),
+		})
+	default:
+		h.logger.Error("session request failed", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to process the session request",
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"errors"
+	"identity/internal/service"
+	"identity/internal/service/dto"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionTokenHandler exchanges sessions for signed access tokens and
+// publishes the keys they are verified with
+type SessionTokenHandler struct {
+	sessionTokens service.SessionTokenService
+	logger        *slog.Logger
+}
+
+// NewSessionTokenHandler creates a new session token handler
+func NewSessionTokenHandler(sessionTokens service.SessionTokenService, logger *slog.Logger) *SessionTokenHandler {
+	return &SessionTokenHandler{
+		sessionTokens: sessionTokens,
+		logger:        logger,
+	}
+}
+
+// ExchangeSession godoc
+// @Summary Exchange a session for an access token
+// @Description Validate a session (sliding its expiry) and return a short-lived RS256 JWT for its user, with the signing key's ID in the kid header and typ at+jwt. Its claims are iss, sub (the user ID), iat, exp, name, roles, flags (each flag's enabled state for the user) and flag_variants. Services verify it against GET /api/v1/auth/jwks and exchange the session again when it expires; it never outlives the session. Accepts session_id in JSON body, the session cookie or X-Session-ID header.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.ValidateSessionRequest false "Session ID"
+// @Success 200 {object} dto.SessionTokenResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/v1/auth/token [post]
+func (h *SessionTokenHandler) ExchangeSession(c *gin.Context) {
+	var sessionID string
+
+	var req dto.ValidateSessionRequest
+	if err := c.ShouldBindJSON(&req); err == nil && req.SessionID != "" {
+		sessionID = req.SessionID
+	}
+
+	if sessionID == "" {
+		sessionID = sessionIDFromRequest(c)
+	}
+
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "session_id is required (provide in JSON body, session cookie or X-Session-ID header)",
+		})
+		return
+	}
+
+	resp, err := h.sessionTokens.Exchange(c.Request.Context(), sessionID)
+	if err != nil {
+		if errors.Is(err, service.ErrSessionRejected) {
+			c.JSON(http.StatusUnauthorized, dto.ErrorResponse{
+				Error:   "unauthorized",
+				Message: err.Error(),
+			})
+			return
+		}
+		h.logger.Error("failed to exchange session", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to issue an access token",
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, resp)
+}
+
+// JWKS godoc
+// @Summary Access token signing keys
+// @Description The public keys session access tokens are signed with, picked by their kid. Keys rotate; a new key is published a while before it signs anything, so refetch the set only when a token names an unknown kid.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} dto.JWKS
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /api/v1/auth/jwks [get]
+func (h *SessionTokenHandler) JWKS(c *gin.Context) {
+	jwks, err := h.sessionTokens.JWKS(c.Request.Context())
+	if err != nil {
+		h.logger.Error("failed to get signing keys", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to get signing keys",
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, jwks)
+}
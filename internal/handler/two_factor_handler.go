@@ -0,0 +1,297 @@
+package handler
+
+import (
+	"errors"
+	"identity/internal/middleware"
+	"identity/internal/service"
+	"identity/internal/service/dto"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerifyLogin godoc
+// @Summary Complete a two-factor login
+// @Description Exchange the challenge_token from /api/v1/auth/login and a TOTP code (or a recovery code) for a session. Users enrolling as part of the login get their recovery_codes in the response, once. Wrong codes count toward the login lockout (429).
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.VerifyLoginRequest true "Challenge token and code"
+// @Success 200 {object} dto.LoginResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Failure 429 {object} dto.ErrorResponse
+// @Router /api/v1/auth/login/verify [post]
+func (h *AuthHandler) VerifyLogin(c *gin.Context) {
+	var req dto.VerifyLoginRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "challenge_token and code are required",
+		})
+		return
+	}
+
+	resp, err := h.authService.VerifyLogin(c.Request.Context(), &req)
+	if err != nil {
+		h.logger.Error("two-factor login failed", "error", err)
+		h.writeTwoFactorError(c, err)
+		return
+	}
+
+	h.setSessionCookie(c, resp.SessionID)
+
+	h.logger.Info("user logged in", "user_id", resp.User.ID, "email", resp.User.Email, "two_factor", true)
+	c.JSON(http.StatusOK, resp)
+}
+
+// BeginLoginEnrollment godoc
+// @Summary Set up two-factor authentication during login
+// @Description For a login answered with enrollment_required: returns the TOTP secret to add to an authenticator app. Asking again returns the same secret. The first code, sent to /api/v1/auth/login/verify, confirms it.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.LoginEnrollmentRequest true "Challenge token"
+// @Success 200 {object} dto.TOTPEnrollmentResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Router /api/v1/auth/login/enroll [post]
+func (h *AuthHandler) BeginLoginEnrollment(c *gin.Context) {
+	var req dto.LoginEnrollmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "challenge_token is required",
+		})
+		return
+	}
+
+	resp, err := h.authService.BeginLoginEnrollment(c.Request.Context(), req.ChallengeToken)
+	if err != nil {
+		h.writeTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// BeginTOTPEnrollment godoc
+// @Summary Start setting up two-factor authentication
+// @Description Generate a new TOTP secret for the current user, returned as is, as an otpauth:// URI and as a QR code. It takes effect once confirmed with a code.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} dto.TOTPEnrollmentResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Router /api/v1/auth/totp/enroll [post]
+func (h *AuthHandler) BeginTOTPEnrollment(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+
+	resp, err := h.authService.BeginTOTPEnrollment(c.Request.Context(), user.ID)
+	if err != nil {
+		h.writeTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// ConfirmTOTPEnrollment godoc
+// @Summary Confirm two-factor authentication
+// @Description Enable two-factor authentication for the current user with a code from the newly set up authenticator. Returns the recovery codes, which are only shown this once.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.TOTPCodeRequest true "TOTP code"
+// @Success 200 {object} dto.RecoveryCodesResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Router /api/v1/auth/totp/confirm [post]
+func (h *AuthHandler) ConfirmTOTPEnrollment(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+
+	var req dto.TOTPCodeRequest
+	if !bindTOTPCode(c, &req) {
+		return
+	}
+
+	resp, err := h.authService.ConfirmTOTPEnrollment(c.Request.Context(), user.ID, req.Code)
+	if err != nil {
+		h.writeTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Replace recovery codes
+// @Description Issue new recovery codes for the current user, invalidating the old ones, given a TOTP code
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.TOTPCodeRequest true "TOTP code"
+// @Success 200 {object} dto.RecoveryCodesResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Router /api/v1/auth/totp/recovery-codes [post]
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+
+	var req dto.TOTPCodeRequest
+	if !bindTOTPCode(c, &req) {
+		return
+	}
+
+	resp, err := h.authService.RegenerateRecoveryCodes(c.Request.Context(), user.ID, req.Code)
+	if err != nil {
+		h.writeTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// DisableTOTP godoc
+// @Summary Turn off two-factor authentication
+// @Description Turn off two-factor authentication for the current user, given a TOTP or recovery code. Not allowed for roles that require it.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.TOTPCodeRequest true "TOTP or recovery code"
+// @Success 200 {object} dto.SuccessResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Router /api/v1/auth/totp/disable [post]
+func (h *AuthHandler) DisableTOTP(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+
+	var req dto.TOTPCodeRequest
+	if !bindTOTPCode(c, &req) {
+		return
+	}
+
+	if err := h.authService.DisableTOTP(c.Request.Context(), user.ID, req.Code); err != nil {
+		h.writeTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Message: "Two-factor authentication disabled",
+	})
+}
+
+// ResetUserTOTP godoc
+// @Summary Reset a user's two-factor authentication
+// @Description Turn off two-factor authentication for a user who lost their authenticator and recovery codes. Users whose role requires it set it up again on their next login.
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} dto.SuccessResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/v1/users/{id}/totp [delete]
+func (h *AuthHandler) ResetUserTOTP(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid user ID",
+		})
+		return
+	}
+
+	if err := h.authService.ResetTOTP(c.Request.Context(), uint(id)); err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
+		if err.Error() == "user not found" {
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: err.Error(),
+			})
+			return
+		}
+		h.logger.Error("failed to reset two-factor authentication", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "reset_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Message: "Two-factor authentication reset",
+	})
+}
+
+// bindTOTPCode binds a request carrying a code, answering 400 and reporting
+// false if there is none
+func bindTOTPCode(c *gin.Context, req *dto.TOTPCodeRequest) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "code is required",
+		})
+		return false
+	}
+	return true
+}
+
+// writeTwoFactorError maps two-factor errors to responses: bad codes, keys
+// and challenges are 401s, requests that don't fit the user's enrollment
+// state are 409s
+func (h *AuthHandler) writeTwoFactorError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidLoginChallenge),
+		errors.Is(err, service.ErrInvalidWebAuthnCeremony):
+		c.JSON(http.StatusUnauthorized, dto.ErrorResponse{
+			Error:   "invalid_challenge",
+			Message: err.Error(),
+		})
+	case errors.Is(err, service.ErrInvalidTOTPCode):
+		c.JSON(http.StatusUnauthorized, dto.ErrorResponse{
+			Error:   "invalid_code",
+			Message: err.Error(),
+		})
+	case errors.Is(err, service.ErrLoginLocked):
+		c.JSON(http.StatusTooManyRequests, dto.ErrorResponse{
+			Error:   "too_many_attempts",
+			Message: err.Error(),
+		})
+	case errors.Is(err, service.ErrWebAuthnVerification):
+		// The details are for the logs, not the client
+		h.logger.Warn("webauthn verification failed", "error", err)
+		c.JSON(http.StatusUnauthorized, dto.ErrorResponse{
+			Error:   "invalid_credential",
+			Message: service.ErrWebAuthnVerification.Error(),
+		})
+	case errors.Is(err, service.ErrTOTPAlreadyEnabled),
+		errors.Is(err, service.ErrTOTPNotEnabled),
+		errors.Is(err, service.ErrTOTPEnrollmentNotStarted),
+		errors.Is(err, service.ErrTOTPRequired),
+		errors.Is(err, service.ErrNoWebAuthnCredentials):
+		c.JSON(http.StatusConflict, dto.ErrorResponse{
+			Error:   "conflict",
+			Message: err.Error(),
+		})
+	case err.Error() == "user account is disabled":
+		c.JSON(http.StatusUnauthorized, dto.ErrorResponse{
+			Error:   "login_failed",
+			Message: err.Error(),
+		})
+	default:
+		h.logger.Error("two-factor request failed", "error", err)
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "internal_error",
+			Message: "Two-factor authentication failed",
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package handler
 
 import (
+	"errors"
 	"identity/internal/service"
 	"identity/internal/service/dto"
+	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
@@ -33,6 +35,7 @@ func NewUserHandler(userService service.UserService, logger *slog.Logger) *UserH
 // @Param user body dto.CreateUserRequest true "User information"
 // @Success 201 {object} dto.UserResponse
 // @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/v1/users [post]
 func (h *UserHandler) CreateUser(c *gin.Context) {
@@ -48,6 +51,16 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 
 	user, err := h.userService.CreateUser(c.Request.Context(), &req)
 	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrInvalidRole) {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_role",
+				Message: err.Error(),
+			})
+			return
+		}
 		h.logger.Error("failed to create user", "error", err)
 		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
 			Error:   "creation_failed",
@@ -68,6 +81,7 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 // @Param id path int true "User ID"
 // @Success 200 {object} dto.UserResponse
 // @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/v1/users/{id} [get]
@@ -84,6 +98,9 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 
 	user, err := h.userService.GetUser(c.Request.Context(), uint(id))
 	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
 		if err.Error() == "user not found" {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
 				Error:   "not_found",
@@ -127,7 +144,7 @@ func (h *UserHandler) GetPublicUser(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userService.GetUser(c.Request.Context(), uint(id))
+	user, err := h.userService.GetPublicUser(c.Request.Context(), uint(id))
 	if err != nil {
 		if err.Error() == "user not found" {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
@@ -144,10 +161,7 @@ func (h *UserHandler) GetPublicUser(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, dto.PublicUserResponse{
-		ID:   user.ID,
-		Name: user.Name,
-	})
+	c.JSON(http.StatusOK, user)
 }
 
 // GetUsers godoc
@@ -159,6 +173,7 @@ func (h *UserHandler) GetPublicUser(c *gin.Context) {
 // @Param page query int false "Page number" default(1)
 // @Param page_size query int false "Page size" default(10)
 // @Success 200 {object} dto.UserListResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/v1/users [get]
 func (h *UserHandler) GetUsers(c *gin.Context) {
@@ -182,6 +197,9 @@ func (h *UserHandler) GetUsers(c *gin.Context) {
 
 	users, err := h.userService.GetUsers(c.Request.Context(), &pagination)
 	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
 		h.logger.Error("failed to get users", "error", err)
 		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
 			Error:   "retrieval_failed",
@@ -203,6 +221,7 @@ func (h *UserHandler) GetUsers(c *gin.Context) {
 // @Param user body dto.UpdateUserRequest true "User information to update"
 // @Success 200 {object} dto.UserResponse
 // @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/v1/users/{id} [put]
@@ -229,6 +248,9 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 
 	user, err := h.userService.UpdateUser(c.Request.Context(), uint(id), &req)
 	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
 		if err.Error() == "user not found" {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
 				Error:   "not_found",
@@ -236,6 +258,20 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 			})
 			return
 		}
+		if errors.Is(err, service.ErrInvalidRole) {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_role",
+				Message: err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, service.ErrOwnRoleChange) {
+			c.JSON(http.StatusForbidden, dto.ErrorResponse{
+				Error:   "forbidden",
+				Message: err.Error(),
+			})
+			return
+		}
 		h.logger.Error("failed to update user", "error", err)
 		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
 			Error:   "update_failed",
@@ -256,6 +292,7 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 // @Param id path int true "User ID"
 // @Success 200 {object} dto.SuccessResponse
 // @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/v1/users/{id} [delete]
@@ -272,6 +309,9 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 
 	err = h.userService.DeleteUser(c.Request.Context(), uint(id))
 	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
 		if err.Error() == "user not found" {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
 				Error:   "not_found",
@@ -299,8 +339,9 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
-// @Success 200 {array} dto.FeatureFlagResponse
+// @Success 200 {array} dto.UserFeatureFlagResponse
 // @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/v1/users/{id}/feature-flags [get]
@@ -317,6 +358,9 @@ func (h *UserHandler) GetUserFeatureFlags(c *gin.Context) {
 
 	flags, err := h.userService.GetUserFeatureFlags(c.Request.Context(), uint(id))
 	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
 		if err.Error() == "user not found" {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
 				Error:   "not_found",
@@ -337,14 +381,16 @@ func (h *UserHandler) GetUserFeatureFlags(c *gin.Context) {
 
 // AssignFeatureFlagToUser godoc
 // @Summary Assign feature flag to user
-// @Description Assign a feature flag to a user by feature flag key
+// @Description Assign a feature flag to a user by feature flag key, optionally pinning the variant they are served. Assigning an already assigned flag with a different variant re-pins it.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
 // @Param key path string true "Feature Flag Key"
+// @Param request body dto.AssignFeatureFlagRequest false "Variant to pin (optional)"
 // @Success 200 {object} dto.SuccessResponse
 // @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/v1/users/{id}/feature-flags/{key} [post]
@@ -368,8 +414,28 @@ func (h *UserHandler) AssignFeatureFlagToUser(c *gin.Context) {
 		return
 	}
 
-	err = h.userService.AssignFeatureFlagToUser(c.Request.Context(), uint(id), key)
+	// The body is optional: without one the user gets the flag's weighted variants
+	var req dto.AssignFeatureFlagRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	err = h.userService.AssignFeatureFlagToUser(c.Request.Context(), uint(id), key, req.Variant)
 	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrInvalidVariants) {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_request",
+				Message: err.Error(),
+			})
+			return
+		}
 		if err.Error() == "user not found" || err.Error() == "feature flag not found" {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
 				Error:   "not_found",
@@ -400,6 +466,7 @@ func (h *UserHandler) AssignFeatureFlagToUser(c *gin.Context) {
 // @Param key path string true "Feature Flag Key"
 // @Success 200 {object} dto.SuccessResponse
 // @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /api/v1/users/{id}/feature-flags/{key} [delete]
@@ -425,6 +492,9 @@ func (h *UserHandler) UnassignFeatureFlagFromUser(c *gin.Context) {
 
 	err = h.userService.UnassignFeatureFlagFromUser(c.Request.Context(), uint(id), key)
 	if err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
 		if err.Error() == "user not found" || err.Error() == "feature flag not found" {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
 				Error:   "not_found",
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"errors"
+	"identity/internal/middleware"
+	"identity/internal/service"
+	"identity/internal/service/dto"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeysTab renders the API keys tab: the keys other services call the API
+// with
+func (h *WebHandler) APIKeysTab(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	data := h.withPermissions(c, PageData{
+		Title:        "API Keys",
+		User:         user,
+		ActiveTab:    "api-keys",
+		APIKeys:      h.loadAPIKeys(c),
+		APIKeyScopes: service.APIKeyScopes,
+	})
+
+	if c.GetHeader("HX-Request") == "true" {
+		h.templates.ExecuteTemplate(c.Writer, "api-keys-content", data)
+		return
+	}
+
+	h.renderTemplate(c, "layout.html", "dashboard.html", data)
+}
+
+// CreateAPIKey mints an API key from the admin UI, showing it once
+func (h *WebHandler) CreateAPIKey(c *gin.Context) {
+	req := dto.CreateAPIKeyRequest{
+		Name:   c.PostForm("name"),
+		Scopes: c.PostFormArray("scopes"),
+	}
+	if days := c.PostForm("expires_in_days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			h.renderAPIKeysList(c, nil, "Expiry must be a number of days")
+			return
+		}
+		req.ExpiresInDays = n
+	}
+
+	created, err := h.apiKeys.CreateAPIKey(c.Request.Context(), &req)
+	if err != nil {
+		h.logger.Error("failed to create API key", "error", err)
+		h.renderAPIKeysList(c, nil, apiKeyErrorMessage(err))
+		return
+	}
+
+	h.renderAPIKeysList(c, created, "")
+}
+
+// RevokeAPIKey revokes an API key; it stops working right away
+func (h *WebHandler) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	if err := h.apiKeys.RevokeAPIKey(c.Request.Context(), uint(id)); err != nil {
+		h.logger.Error("failed to revoke API key", "error", err)
+	}
+
+	h.renderAPIKeysList(c, nil, "")
+}
+
+// renderAPIKeysList renders the API keys table, with a key just minted or an
+// alert about a refused one above it
+func (h *WebHandler) renderAPIKeysList(c *gin.Context, created *dto.APIKeySecretResponse, errMsg string) {
+	data := h.withPermissions(c, PageData{
+		APIKeys:   h.loadAPIKeys(c),
+		NewAPIKey: created,
+		Error:     errMsg,
+	})
+	h.templates.ExecuteTemplate(c.Writer, "api-keys-list", data)
+}
+
+// apiKeyErrorMessage words a refused API key for the admin UI
+func apiKeyErrorMessage(err error) string {
+	if errors.Is(err, service.ErrInvalidAPIKeyRequest) {
+		msg := strings.TrimPrefix(err.Error(), service.ErrInvalidAPIKeyRequest.Error()+": ")
+		return strings.ToUpper(msg[:1]) + msg[1:]
+	}
+	return "Something went wrong, please try again"
+}
+
+func (h *WebHandler) loadAPIKeys(c *gin.Context) []dto.APIKeyResponse {
+	keys, err := h.apiKeys.GetAPIKeys(c.Request.Context())
+	if err != nil {
+		h.logger.Error("failed to get API keys", "error", err)
+		return nil
+	}
+	return keys
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"identity/internal/model"
+	"identity/internal/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// externalLoginCookieName ties a login at an external provider to the
+	// browser that started it
+	externalLoginCookieName = "external_login_state"
+	// externalLoginCookiePath limits the cookie to the login routes
+	externalLoginCookiePath = "/admin/login/external"
+	// externalLoginCookieMaxAge matches how long the login stays valid
+	externalLoginCookieMaxAge = 10 * 60
+	// externalReturnToKey carries the authorization request a login with an
+	// external provider was started for to loginReturnTo
+	externalReturnToKey = "external_return_to"
+)
+
+// LoginExternal starts a login with an external identity provider: it
+// sends the user there, remembering the login in a cookie
+func (h *WebHandler) LoginExternal(c *gin.Context) {
+	redirectURL, state, err := h.externalLogin.BeginLogin(c.Request.Context(), c.Param("provider"), h.loginReturnTo(c))
+	if err != nil {
+		h.logger.Error("failed to start external login", "error", err, "provider", c.Param("provider"))
+		message := "Signing in with this provider is unavailable right now, please try again later"
+		if errors.Is(err, service.ErrUnknownLoginProvider) {
+			c.Status(http.StatusNotFound)
+			message = "Unknown sign-in provider"
+		}
+		h.renderTemplate(c, "layout.html", "login.html", PageData{Title: "Login", Error: message})
+		return
+	}
+
+	c.SetCookie(externalLoginCookieName, state, externalLoginCookieMaxAge, externalLoginCookiePath, "", h.cookieSecure, true)
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// LoginExternalCallback completes a login with an external identity
+// provider, which sends the user back here with the login's state and a code
+func (h *WebHandler) LoginExternalCallback(c *gin.Context) {
+	cookieState, _ := c.Cookie(externalLoginCookieName)
+	c.SetCookie(externalLoginCookieName, "", -1, externalLoginCookiePath, "", h.cookieSecure, true)
+
+	state := c.Query("state")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		h.renderTemplate(c, "layout.html", "login.html", PageData{
+			Title: "Login",
+			Error: "Your sign-in expired or was started in another browser, please sign in again",
+		})
+		return
+	}
+	// e.g. the user declined at the provider
+	if errCode := c.Query("error"); errCode != "" {
+		h.logger.Info("external login declined", "error", errCode, "description", c.Query("error_description"))
+		h.renderTemplate(c, "layout.html", "login.html", PageData{
+			Title: "Login",
+			Error: "Sign-in with the provider was cancelled, please try again",
+		})
+		return
+	}
+
+	resp, returnTo, err := h.externalLogin.FinishLogin(c.Request.Context(), state, c.Query("code"))
+	if err != nil {
+		h.logger.Error("external login failed", "error", err)
+		h.renderTemplate(c, "layout.html", "login.html", PageData{
+			Title: "Login",
+			Error: externalLoginErrorMessage(err),
+		})
+		return
+	}
+	c.Set(externalReturnToKey, returnTo)
+
+	// As with a password, a second factor is asked for first, and end users
+	// may only sign in to apps
+	if resp.TwoFactorRequired {
+		h.renderTwoFactorStep(c, &TwoFactorStep{
+			ChallengeToken: resp.ChallengeToken,
+			Enroll:         resp.EnrollmentRequired,
+			Methods:        resp.TwoFactorMethods,
+		}, "")
+		return
+	}
+
+	if h.denyAdminAccess(c, resp) {
+		return
+	}
+	h.setSessionCookie(c, resp.SessionID)
+	h.redirectAfterLogin(c)
+}
+
+// UserIdentities lists the external identities linked to a user
+func (h *WebHandler) UserIdentities(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	h.renderUserIdentities(c, uint(id))
+}
+
+// UnlinkUserIdentity removes an external identity from a user
+func (h *WebHandler) UnlinkUserIdentity(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	identityID, err := strconv.ParseUint(c.Param("identityId"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid identity ID")
+		return
+	}
+
+	if err := h.externalLogin.UnlinkIdentity(c.Request.Context(), uint(id), uint(identityID)); err != nil {
+		h.logger.Error("failed to unlink identity", "error", err)
+	}
+
+	h.renderUserIdentities(c, uint(id))
+}
+
+// renderUserIdentities renders the external identities modal of a user
+func (h *WebHandler) renderUserIdentities(c *gin.Context, userID uint) {
+	userResp, err := h.userService.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		c.String(http.StatusNotFound, "User not found")
+		return
+	}
+
+	identities, err := h.externalLogin.GetUserIdentities(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to get identities", "error", err)
+	}
+
+	data := h.withPermissions(c, PageData{
+		SelectedUser: &model.User{
+			ID:    userResp.ID,
+			Name:  userResp.Name,
+			Email: userResp.Email,
+		},
+		Identities: identities,
+	})
+
+	h.templates.ExecuteTemplate(c.Writer, "user-identities-modal", data)
+}
+
+// externalLoginErrorMessage words a failed login with an external provider
+// for the login page
+func externalLoginErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, service.ErrInvalidExternalLogin):
+		return "Your sign-in expired, please sign in again"
+	case errors.Is(err, service.ErrExternalEmailUnverified):
+		return "The provider hasn't verified your email address, so it can't be matched to an account"
+	case errors.Is(err, service.ErrExternalAccountNotFound):
+		return "There is no account for this sign-in, ask an administrator to create one"
+	case errors.Is(err, service.ErrExternalDomainNotAllowed):
+		return "Your email address can't be used to sign in with this provider"
+	case errors.Is(err, service.ErrExternalAccountNotLinked):
+		return "An account already uses this email address; sign in with its password instead"
+	case errors.Is(err, service.ErrExternalLoginFailed), errors.Is(err, service.ErrUnknownLoginProvider):
+		return "Sign-in with the provider failed, please try again"
+	default:
+		return err.Error()
+	}
+}
@@ -2,15 +2,21 @@ package handler
 
 import (
 	"embed"
+	"encoding/json"
+	"errors"
 	"html/template"
 	"identity/internal/middleware"
 	"identity/internal/model"
 	"identity/internal/repository"
 	"identity/internal/service"
 	"identity/internal/service/dto"
+	"identity/pkg/api"
 	"log/slog"
 	"net/http"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -24,6 +30,10 @@ type WebHandler struct {
 	authService        service.AuthService
 	userService        service.UserService
 	featureFlagService service.FeatureFlagService
+	passwordReset      service.PasswordResetService
+	oidc               service.OIDCService
+	externalLogin      service.ExternalLoginService
+	apiKeys            service.APIKeyService
 	auditLogRepo       repository.AuditLogRepository
 	logger             *slog.Logger
 	templates          *template.Template
@@ -31,11 +41,16 @@ type WebHandler struct {
 	environment        string
 }
 
-// NewWebHandler creates a new web handler
+// NewWebHandler creates a new web handler. oidc is nil when the OpenID
+// Connect provider is disabled.
 func NewWebHandler(
 	authService service.AuthService,
 	userService service.UserService,
 	featureFlagService service.FeatureFlagService,
+	passwordReset service.PasswordResetService,
+	oidc service.OIDCService,
+	externalLogin service.ExternalLoginService,
+	apiKeys service.APIKeyService,
 	auditLogRepo repository.AuditLogRepository,
 	logger *slog.Logger,
 	cookieSecure bool,
@@ -47,6 +62,10 @@ func NewWebHandler(
 		authService:        authService,
 		userService:        userService,
 		featureFlagService: featureFlagService,
+		passwordReset:      passwordReset,
+		oidc:               oidc,
+		externalLogin:      externalLogin,
+		apiKeys:            apiKeys,
 		auditLogRepo:       auditLogRepo,
 		logger:             logger,
 		templates:          tmpl,
@@ -57,17 +76,57 @@ func NewWebHandler(
 
 // PageData contains common data for all pages
 type PageData struct {
-	Title        string
-	Environment  string
-	User         *model.User
-	Error        string
-	Success      string
-	ActiveTab    string
-	Flags        []FlagWithUserCount
-	Users        []UserWithFlagCount
-	SelectedUser *model.User
-	AllFlags     []FlagWithAssignment
-	AuditLogs    []AuditRow
+	Title         string
+	Environment   string
+	User          *model.User
+	Error         string
+	Success       string
+	TwoFactor     *TwoFactorStep
+	RecoveryCodes []string
+	ResetToken    string
+	// ReturnTo is the authorization request a login continues with
+	ReturnTo string
+	// LoginProviders are the external identity providers to sign in with
+	LoginProviders []dto.ExternalProviderResponse
+	Consent        *ConsentStep
+	ActiveTab      string
+	Flags          []FlagWithUserCount
+	Scheduled      []ScheduledChangeRow
+	FlagGraph      []FlagGraphNode
+	Users          []UserWithFlagCount
+	SelectedUser   *model.User
+	Credentials    []dto.WebAuthnCredentialResponse
+	Sessions       []dto.SessionResponse
+	Identities     []dto.UserIdentityResponse
+	AllFlags       []FlagWithAssignment
+	AuditLogs      []AuditRow
+	Clients        []dto.OAuthClientResponse
+	ClientSecret   *dto.OAuthClientSecretResponse
+	APIKeys        []dto.APIKeyResponse
+	NewAPIKey      *dto.APIKeySecretResponse
+	APIKeyScopes   []service.Permission
+	Roles          []model.Role
+	CanEditFlags   bool
+	CanEditUsers   bool
+	CanEditClients bool
+	CanEditAPIKeys bool
+	OIDCEnabled    bool
+}
+
+// TwoFactorStep is the second step of the login form: entering a code or
+// using a security key, or for users who must enroll first, setting up an
+// authenticator app. Methods are the ones the login challenge offered.
+type TwoFactorStep struct {
+	ChallengeToken string
+	Enroll         bool
+	Methods        []string
+	Secret         string
+	QRCode         template.URL
+}
+
+// HasMethod reports whether the login challenge accepts the given method
+func (s *TwoFactorStep) HasMethod(method string) bool {
+	return slices.Contains(s.Methods, method)
 }
 
 // AuditRow is a template-friendly audit log entry
@@ -79,31 +138,91 @@ type AuditRow struct {
 	Details   string
 }
 
-// FlagWithUserCount represents a feature flag with user count
+// FlagWithUserCount represents a feature flag with user count. CanEdit is
+// carried per row because single rows are re-rendered on their own.
 type FlagWithUserCount struct {
-	ID          uint
-	Key         string
-	Description string
-	Enabled     bool
-	UserCount   int
+	ID                uint
+	Key               string
+	Description       string
+	Enabled           bool
+	RolloutPercentage int
+	RuleCount         int
+	VariantType       string
+	VariantCount      int
+	PrerequisiteCount int
+	UserCount         int
+	CanEdit           bool
 }
 
-// UserWithFlagCount represents a user with flag count
-type UserWithFlagCount struct {
+// FlagGraphNode is a flag in the dependency graph, under each flag it
+// requires; Requires says what it needs of that flag
+type FlagGraphNode struct {
+	Key        string
+	Requires   string
+	Dependents []FlagGraphNode
+}
+
+// FlagPrerequisites is the data for the prerequisites modal; prerequisites
+// are edited as JSON
+type FlagPrerequisites struct {
+	ID                uint
+	Key               string
+	PrerequisitesJSON string
+	RequiredBy        []string
+	CanEdit           bool
+}
+
+// ScheduledChangeRow is a template-friendly pending scheduled flag change
+type ScheduledChangeRow struct {
 	ID        uint
-	Name      string
-	Email     string
-	Enabled   bool
-	FlagCount int
+	FlagKey   string
+	Change    string
+	RunAt     string
+	CreatedBy string
 }
 
-// FlagWithAssignment represents a flag with assignment status
-type FlagWithAssignment struct {
+// FlagVariants is the data for the variants modal; variants are edited as JSON
+type FlagVariants struct {
+	ID           uint
+	Key          string
+	VariantType  string
+	VariantTypes []string
+	VariantsJSON string
+	OffVariant   string
+	CanEdit      bool
+}
+
+// FlagRules is the data for the targeting rules modal; rules are edited as JSON
+type FlagRules struct {
+	ID        uint
+	Key       string
+	RulesJSON string
+	CanEdit   bool
+}
+
+// UserWithFlagCount represents a user with flag count
+type UserWithFlagCount struct {
 	ID          uint
-	Key         string
-	Description string
+	Name        string
+	Email       string
 	Enabled     bool
-	IsAssigned  bool
+	Role        string
+	TOTPEnabled bool
+	FlagCount   int
+	// LockedUntil is set while too many failed logins lock the user out
+	LockedUntil *time.Time
+}
+
+// FlagWithAssignment represents a flag with assignment status. Variants lists
+// the flag's variant keys so an assignment can pin one.
+type FlagWithAssignment struct {
+	ID              uint
+	Key             string
+	Description     string
+	Enabled         bool
+	IsAssigned      bool
+	Variants        []string
+	AssignedVariant string
 }
 
 // LoginPage renders the login page
@@ -137,18 +256,236 @@ func (h *WebHandler) LoginSubmit(c *gin.Context) {
 		return
 	}
 
-	// Set session cookie
-	c.SetCookie(
-		SessionCookieName,
-		resp.SessionID,
-		int(h.authService.SessionDuration().Seconds()),
-		"/",
-		"",
-		h.cookieSecure,
-		true,
-	)
+	// Who the user is isn't known until the second factor passes, so the
+	// admin access check waits until then
+	if resp.TwoFactorRequired {
+		h.renderTwoFactorStep(c, &TwoFactorStep{
+			ChallengeToken: resp.ChallengeToken,
+			Enroll:         resp.EnrollmentRequired,
+			Methods:        resp.TwoFactorMethods,
+		}, "")
+		return
+	}
 
-	c.Redirect(http.StatusFound, "/admin")
+	if h.denyAdminAccess(c, resp) {
+		return
+	}
+	h.setSessionCookie(c, resp.SessionID)
+	h.redirectAfterLogin(c)
+}
+
+// denyAdminAccess ends the session of a login whose user may not use the
+// admin UI, and tells them so, unless they are signing in to an app. End
+// users can hold a valid session but must not reach the admin UI. It
+// reports whether it turned the login away. Recovery codes issued with the
+// session are still shown, as they can't be shown again.
+func (h *WebHandler) denyAdminAccess(c *gin.Context, resp *dto.LoginResponse) bool {
+	if h.loginReturnTo(c) != "" || service.HasPermission(model.Role(resp.User.Role), service.PermAdminAccess) {
+		return false
+	}
+
+	_ = h.authService.Logout(c.Request.Context(), resp.SessionID)
+	h.renderTemplate(c, "layout.html", "login.html", PageData{
+		Title:         "Login",
+		Error:         "Your account does not have access to the admin console",
+		RecoveryCodes: resp.RecoveryCodes,
+	})
+	return true
+}
+
+// LoginPasskey handles a passwordless login: the login page runs the
+// WebAuthn ceremony against the API and posts its result here
+func (h *WebHandler) LoginPasskey(c *gin.Context) {
+	req := dto.FinishPasskeyLoginRequest{
+		CeremonyToken: c.PostForm("ceremony_token"),
+		Credential:    json.RawMessage(c.PostForm("credential")),
+	}
+	if req.CeremonyToken == "" || len(req.Credential) == 0 {
+		h.renderTemplate(c, "layout.html", "login.html", PageData{
+			Title: "Login",
+			Error: "Passkey sign-in was cancelled, please try again",
+		})
+		return
+	}
+
+	resp, err := h.authService.FinishPasskeyLogin(c.Request.Context(), &req)
+	if err != nil {
+		h.logger.Error("web passkey login failed", "error", err)
+		h.renderTemplate(c, "layout.html", "login.html", PageData{
+			Title: "Login",
+			Error: webAuthnErrorMessage(err),
+		})
+		return
+	}
+
+	if h.denyAdminAccess(c, resp) {
+		return
+	}
+	h.setSessionCookie(c, resp.SessionID)
+	h.redirectAfterLogin(c)
+}
+
+// LoginVerify handles the second step of the login form: the TOTP or
+// recovery code, or for users enrolling, the first code from their new
+// authenticator, after which their recovery codes are shown
+func (h *WebHandler) LoginVerify(c *gin.Context) {
+	var req dto.VerifyLoginRequest
+	if err := c.ShouldBind(&req); err != nil {
+		h.renderTwoFactorStep(c, twoFactorStepFromForm(c), "Please enter your code")
+		return
+	}
+
+	resp, err := h.authService.VerifyLogin(c.Request.Context(), &req)
+	if err != nil {
+		h.logger.Error("web two-factor login failed", "error", err)
+		if errors.Is(err, service.ErrInvalidTOTPCode) {
+			h.renderTwoFactorStep(c, twoFactorStepFromForm(c), "Invalid code, please try again")
+			return
+		}
+		message := err.Error()
+		if errors.Is(err, service.ErrInvalidLoginChallenge) {
+			message = "Your sign-in expired or had too many failed attempts, please sign in again"
+		}
+		h.renderTemplate(c, "layout.html", "login.html", PageData{Title: "Login", Error: message})
+		return
+	}
+
+	if h.denyAdminAccess(c, resp) {
+		return
+	}
+	h.setSessionCookie(c, resp.SessionID)
+
+	// Recovery codes are shown once, before moving on
+	if len(resp.RecoveryCodes) > 0 {
+		h.renderTemplate(c, "layout.html", "login.html", PageData{
+			Title:         "Login",
+			RecoveryCodes: resp.RecoveryCodes,
+		})
+		return
+	}
+
+	h.redirectAfterLogin(c)
+}
+
+// LoginWebAuthn handles the second step of the login form done with a
+// security key instead of a code
+func (h *WebHandler) LoginWebAuthn(c *gin.Context) {
+	req := dto.VerifyLoginWebAuthnRequest{
+		ChallengeToken: c.PostForm("challenge_token"),
+		Credential:     json.RawMessage(c.PostForm("credential")),
+	}
+	if len(req.Credential) == 0 {
+		h.renderTwoFactorStep(c, twoFactorStepFromForm(c), "Security key sign-in was cancelled, please try again")
+		return
+	}
+
+	resp, err := h.authService.VerifyLoginWebAuthn(c.Request.Context(), &req)
+	if err != nil {
+		h.logger.Error("web security key login failed", "error", err)
+		if errors.Is(err, service.ErrWebAuthnVerification) {
+			h.renderTwoFactorStep(c, twoFactorStepFromForm(c), webAuthnErrorMessage(err))
+			return
+		}
+		h.renderTemplate(c, "layout.html", "login.html", PageData{Title: "Login", Error: webAuthnErrorMessage(err)})
+		return
+	}
+
+	if h.denyAdminAccess(c, resp) {
+		return
+	}
+	h.setSessionCookie(c, resp.SessionID)
+	h.redirectAfterLogin(c)
+}
+
+// ForgotPasswordPage renders the form asking for a password reset link
+func (h *WebHandler) ForgotPasswordPage(c *gin.Context) {
+	h.renderTemplate(c, "layout.html", "password_reset.html", PageData{Title: "Forgot password"})
+}
+
+// ForgotPasswordSubmit emails a password reset link. The page reads the same
+// whether or not the email belongs to an account.
+func (h *WebHandler) ForgotPasswordSubmit(c *gin.Context) {
+	var req dto.ForgotPasswordRequest
+	if err := c.ShouldBind(&req); err != nil {
+		h.renderTemplate(c, "layout.html", "password_reset.html", PageData{
+			Title: "Forgot password",
+			Error: "Please enter a valid email",
+		})
+		return
+	}
+
+	if err := h.passwordReset.RequestPasswordReset(c.Request.Context(), req.Email); err != nil {
+		h.logger.Error("failed to request password reset", "error", err)
+		h.renderTemplate(c, "layout.html", "password_reset.html", PageData{
+			Title: "Forgot password",
+			Error: "Something went wrong, please try again",
+		})
+		return
+	}
+
+	h.renderTemplate(c, "layout.html", "password_reset.html", PageData{
+		Title:   "Forgot password",
+		Success: "If an account exists for this email, we sent it a link to reset the password.",
+	})
+}
+
+// ResetPasswordPage renders the new password form emailed links lead to
+func (h *WebHandler) ResetPasswordPage(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		h.renderTemplate(c, "layout.html", "password_reset.html", PageData{
+			Title: "Forgot password",
+			Error: "This link is incomplete, please ask for a new one",
+		})
+		return
+	}
+
+	h.renderTemplate(c, "layout.html", "password_reset.html", PageData{
+		Title:      "Reset password",
+		ResetToken: token,
+	})
+}
+
+// ResetPasswordSubmit sets the new password, then sends the user to log in
+// with it
+func (h *WebHandler) ResetPasswordSubmit(c *gin.Context) {
+	var req dto.ResetPasswordRequest
+	if err := c.ShouldBind(&req); err != nil {
+		h.renderTemplate(c, "layout.html", "password_reset.html", PageData{
+			Title:      "Reset password",
+			Error:      "Please choose a new password",
+			ResetToken: c.PostForm("token"),
+		})
+		return
+	}
+
+	if err := h.passwordReset.ResetPassword(c.Request.Context(), &req); err != nil {
+		h.logger.Error("web password reset failed", "error", err)
+		message := "Something went wrong, please try again"
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			message = "This link is invalid, expired or already used, please ask for a new one"
+		} else if errors.Is(err, service.ErrWeakPassword) {
+			// The link still works; let them pick another password
+			h.renderTemplate(c, "layout.html", "password_reset.html", PageData{
+				Title:      "Reset password",
+				Error:      passwordPolicyMessage(err),
+				ResetToken: req.Token,
+			})
+			return
+		} else if err.Error() == "user account is disabled" {
+			message = err.Error()
+		}
+		h.renderTemplate(c, "layout.html", "password_reset.html", PageData{
+			Title: "Forgot password",
+			Error: message,
+		})
+		return
+	}
+
+	h.renderTemplate(c, "layout.html", "login.html", PageData{
+		Title:   "Login",
+		Success: "Your password has been changed, please sign in",
+	})
 }
 
 // Logout handles logout
@@ -170,14 +507,16 @@ func (h *WebHandler) Dashboard(c *gin.Context) {
 		return
 	}
 
-	data := PageData{
+	data := h.withPermissions(c, PageData{
 		Title:     "Dashboard",
 		User:      user,
 		ActiveTab: "flags",
-	}
+	})
 
 	// Load flags
 	data.Flags = h.loadFlags(c)
+	data.FlagGraph = h.loadFlagGraph(c)
+	data.Scheduled = h.loadScheduledChanges(c)
 
 	h.renderTemplate(c, "layout.html", "dashboard.html", data)
 }
@@ -190,12 +529,14 @@ func (h *WebHandler) FlagsTab(c *gin.Context) {
 		return
 	}
 
-	data := PageData{
+	data := h.withPermissions(c, PageData{
 		Title:     "Feature Flags",
 		User:      user,
 		ActiveTab: "flags",
 		Flags:     h.loadFlags(c),
-	}
+		FlagGraph: h.loadFlagGraph(c),
+		Scheduled: h.loadScheduledChanges(c),
+	})
 
 	// Check if this is an HTMX request
 	if c.GetHeader("HX-Request") == "true" {
@@ -214,12 +555,13 @@ func (h *WebHandler) UsersTab(c *gin.Context) {
 		return
 	}
 
-	data := PageData{
+	data := h.withPermissions(c, PageData{
 		Title:     "Users",
 		User:      user,
 		ActiveTab: "users",
 		Users:     h.loadUsers(c),
-	}
+		Roles:     model.Roles,
+	})
 
 	// Check if this is an HTMX request
 	if c.GetHeader("HX-Request") == "true" {
@@ -251,66 +593,339 @@ func (h *WebHandler) CreateFlag(c *gin.Context) {
 	}
 
 	// Return updated flags table
-	data := PageData{
+	data := h.withPermissions(c, PageData{
 		Flags: h.loadFlags(c),
-	}
+	})
 	h.templates.ExecuteTemplate(c.Writer, "flags-table", data)
 }
 
-// ToggleFlag toggles a feature flag's global status
-func (h *WebHandler) ToggleFlag(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
+// ToggleFlag toggles a feature flag's global status
+func (h *WebHandler) ToggleFlag(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid flag ID")
+		return
+	}
+
+	// Get current flag
+	flag, err := h.featureFlagService.GetFeatureFlag(c.Request.Context(), uint(id))
+	if err != nil {
+		c.String(http.StatusNotFound, "Flag not found")
+		return
+	}
+
+	// Toggle enabled status
+	newEnabled := !flag.Enabled
+	_, err = h.featureFlagService.UpdateFeatureFlag(c.Request.Context(), uint(id), &dto.UpdateFeatureFlagRequest{
+		Enabled: &newEnabled,
+	})
+
+	if err != nil {
+		h.logger.Error("failed to toggle flag", "error", err)
+		c.String(http.StatusInternalServerError, "Failed to update flag")
+		return
+	}
+
+	h.renderFlagRow(c, uint(id))
+}
+
+// SetFlagRollout updates a feature flag's rollout percentage (admin slider)
+func (h *WebHandler) SetFlagRollout(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid flag ID")
+		return
+	}
+
+	percentage, err := strconv.Atoi(c.PostForm("rollout_percentage"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid rollout percentage")
+		return
+	}
+
+	_, err = h.featureFlagService.UpdateFeatureFlag(c.Request.Context(), uint(id), &dto.UpdateFeatureFlagRequest{
+		RolloutPercentage: &percentage,
+	})
+	if err != nil {
+		h.logger.Error("failed to set flag rollout", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.renderFlagRow(c, uint(id))
+}
+
+// FlagRulesModal renders a flag's targeting rules for viewing/editing
+func (h *WebHandler) FlagRulesModal(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid flag ID")
+		return
+	}
+
+	flag, err := h.featureFlagService.GetFeatureFlag(c.Request.Context(), uint(id))
+	if err != nil {
+		c.String(http.StatusNotFound, "Flag not found")
+		return
+	}
+
+	rules, err := json.MarshalIndent(flag.Rules, "", "  ")
+	if err != nil {
+		h.logger.Error("failed to encode flag rules", "error", err)
+		c.String(http.StatusInternalServerError, "Failed to load rules")
+		return
+	}
+
+	data := FlagRules{
+		ID:        flag.ID,
+		Key:       flag.Key,
+		RulesJSON: string(rules),
+		CanEdit:   h.withPermissions(c, PageData{}).CanEditFlags,
+	}
+	h.templates.ExecuteTemplate(c.Writer, "flag-rules-modal", data)
+}
+
+// UpdateFlagRules replaces a flag's targeting rules from the JSON in the modal
+func (h *WebHandler) UpdateFlagRules(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid flag ID")
+		return
+	}
+
+	rules := []dto.TargetingRule{}
+	if raw := strings.TrimSpace(c.PostForm("rules")); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+			c.String(http.StatusBadRequest, "Rules must be a JSON array: "+err.Error())
+			return
+		}
+	}
+
+	_, err = h.featureFlagService.UpdateFeatureFlag(c.Request.Context(), uint(id), &dto.UpdateFeatureFlagRequest{
+		Rules: &rules,
+	})
+	if err != nil {
+		h.logger.Error("failed to update flag rules", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.renderFlagRow(c, uint(id))
+}
+
+// FlagVariantsModal renders a flag's variants for viewing/editing
+func (h *WebHandler) FlagVariantsModal(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid flag ID")
+		return
+	}
+
+	flag, err := h.featureFlagService.GetFeatureFlag(c.Request.Context(), uint(id))
+	if err != nil {
+		c.String(http.StatusNotFound, "Flag not found")
+		return
+	}
+
+	variants, err := json.MarshalIndent(flag.Variants, "", "  ")
+	if err != nil {
+		h.logger.Error("failed to encode flag variants", "error", err)
+		c.String(http.StatusInternalServerError, "Failed to load variants")
+		return
+	}
+
+	data := FlagVariants{
+		ID:           flag.ID,
+		Key:          flag.Key,
+		VariantType:  flag.VariantType,
+		VariantTypes: api.VariantTypes,
+		VariantsJSON: string(variants),
+		OffVariant:   flag.OffVariant,
+		CanEdit:      h.withPermissions(c, PageData{}).CanEditFlags,
+	}
+	h.templates.ExecuteTemplate(c.Writer, "flag-variants-modal", data)
+}
+
+// UpdateFlagVariants replaces a flag's variant type, variants and off variant
+func (h *WebHandler) UpdateFlagVariants(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid flag ID")
+		return
+	}
+
+	variants := []dto.FlagVariant{}
+	if raw := strings.TrimSpace(c.PostForm("variants")); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &variants); err != nil {
+			c.String(http.StatusBadRequest, "Variants must be a JSON array: "+err.Error())
+			return
+		}
+	}
+	variantType := c.PostForm("variant_type")
+	offVariant := c.PostForm("off_variant")
+
+	_, err = h.featureFlagService.UpdateFeatureFlag(c.Request.Context(), uint(id), &dto.UpdateFeatureFlagRequest{
+		VariantType: &variantType,
+		Variants:    &variants,
+		OffVariant:  &offVariant,
+	})
+	if err != nil {
+		h.logger.Error("failed to update flag variants", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.renderFlagRow(c, uint(id))
+}
+
+// FlagPrerequisitesModal renders a flag's prerequisites for viewing/editing,
+// along with the flags that depend on it
+func (h *WebHandler) FlagPrerequisitesModal(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid flag ID")
+		return
+	}
+
+	flag, err := h.featureFlagService.GetFeatureFlag(c.Request.Context(), uint(id))
+	if err != nil {
+		c.String(http.StatusNotFound, "Flag not found")
+		return
+	}
+
+	prerequisites, err := json.MarshalIndent(flag.Prerequisites, "", "  ")
+	if err != nil {
+		h.logger.Error("failed to encode flag prerequisites", "error", err)
+		c.String(http.StatusInternalServerError, "Failed to load prerequisites")
+		return
+	}
+
+	var requiredBy []string
+	if flagsResp, err := h.featureFlagService.GetFeatureFlags(c.Request.Context(), &dto.PaginationParams{Page: 1, PageSize: 100}); err == nil {
+		for _, f := range flagsResp.FeatureFlags {
+			for _, pre := range f.Prerequisites {
+				if pre.FlagKey == flag.Key {
+					requiredBy = append(requiredBy, f.Key)
+				}
+			}
+		}
+	}
+
+	data := FlagPrerequisites{
+		ID:                flag.ID,
+		Key:               flag.Key,
+		PrerequisitesJSON: string(prerequisites),
+		RequiredBy:        requiredBy,
+		CanEdit:           h.withPermissions(c, PageData{}).CanEditFlags,
+	}
+	h.templates.ExecuteTemplate(c.Writer, "flag-prerequisites-modal", data)
+}
+
+// UpdateFlagPrerequisites replaces a flag's prerequisites from the JSON in the
+// modal and re-renders the whole tab, whose dependency graph changed
+func (h *WebHandler) UpdateFlagPrerequisites(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid flag ID")
+		return
+	}
+
+	prerequisites := []dto.FlagPrerequisite{}
+	if raw := strings.TrimSpace(c.PostForm("prerequisites")); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &prerequisites); err != nil {
+			c.String(http.StatusBadRequest, "Prerequisites must be a JSON array: "+err.Error())
+			return
+		}
+	}
+
+	_, err = h.featureFlagService.UpdateFeatureFlag(c.Request.Context(), uint(id), &dto.UpdateFeatureFlagRequest{
+		Prerequisites: &prerequisites,
+	})
+	if err != nil {
+		h.logger.Error("failed to update flag prerequisites", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data := h.withPermissions(c, PageData{
+		Flags:     h.loadFlags(c),
+		FlagGraph: h.loadFlagGraph(c),
+		Scheduled: h.loadScheduledChanges(c),
+	})
+	h.templates.ExecuteTemplate(c.Writer, "flags-content", data)
+}
+
+// DeleteFlag deletes a feature flag. Flags others depend on are kept, with
+// the error shown to the user.
+func (h *WebHandler) DeleteFlag(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid flag ID")
+		return
+	}
+
+	err = h.featureFlagService.DeleteFeatureFlag(c.Request.Context(), uint(id))
+	if err != nil {
+		h.logger.Error("failed to delete flag", "error", err)
+		if errors.Is(err, service.ErrFlagHasDependents) {
+			c.String(http.StatusConflict, err.Error())
+			return
+		}
+	}
+
+	// Return empty string to remove the row
+	c.String(http.StatusOK, "")
+}
+
+// ScheduleFlagChange schedules a change to a flag from the form below the
+// flags table. run_at arrives as RFC3339, converted from the browser's local
+// time before the request is sent.
+func (h *WebHandler) ScheduleFlagChange(c *gin.Context) {
+	id, err := strconv.ParseUint(c.PostForm("flag_id"), 10, 32)
 	if err != nil {
 		c.String(http.StatusBadRequest, "Invalid flag ID")
 		return
 	}
-
-	// Get current flag
-	flag, err := h.featureFlagService.GetFeatureFlag(c.Request.Context(), uint(id))
+	runAt, err := time.Parse(time.RFC3339, c.PostForm("run_at"))
 	if err != nil {
-		c.String(http.StatusNotFound, "Flag not found")
+		c.String(http.StatusBadRequest, "Invalid run time")
 		return
 	}
 
-	// Toggle enabled status
-	newEnabled := !flag.Enabled
-	_, err = h.featureFlagService.UpdateFeatureFlag(c.Request.Context(), uint(id), &dto.UpdateFeatureFlagRequest{
-		Enabled: &newEnabled,
-	})
+	req := &dto.ScheduleFlagChangeRequest{Action: c.PostForm("action"), RunAt: runAt}
+	if req.Action == model.ScheduledFlagSetRollout {
+		percentage, err := strconv.Atoi(c.PostForm("rollout_percentage"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid rollout percentage")
+			return
+		}
+		req.RolloutPercentage = &percentage
+	}
 
-	if err != nil {
-		h.logger.Error("failed to toggle flag", "error", err)
-		c.String(http.StatusInternalServerError, "Failed to update flag")
+	if _, err := h.featureFlagService.ScheduleFlagChange(c.Request.Context(), uint(id), req); err != nil {
+		h.logger.Error("failed to schedule flag change", "error", err)
+		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Return updated row
-	flags := h.loadFlags(c)
-	for _, f := range flags {
-		if f.ID == uint(id) {
-			h.templates.ExecuteTemplate(c.Writer, "flag-row", f)
-			return
-		}
-	}
+	h.renderScheduledChanges(c)
 }
 
-// DeleteFlag deletes a feature flag
-func (h *WebHandler) DeleteFlag(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
+// CancelScheduledFlagChange cancels a pending scheduled flag change
+func (h *WebHandler) CancelScheduledFlagChange(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.String(http.StatusBadRequest, "Invalid flag ID")
+		c.String(http.StatusBadRequest, "Invalid scheduled change ID")
 		return
 	}
 
-	err = h.featureFlagService.DeleteFeatureFlag(c.Request.Context(), uint(id))
-	if err != nil {
-		h.logger.Error("failed to delete flag", "error", err)
+	if err := h.featureFlagService.CancelScheduledFlagChange(c.Request.Context(), uint(id)); err != nil {
+		h.logger.Error("failed to cancel scheduled flag change", "error", err)
 	}
 
-	// Return empty string to remove the row
-	c.String(http.StatusOK, "")
+	h.renderScheduledChanges(c)
 }
 
 // UserFlags shows flags for a specific user
@@ -336,8 +951,10 @@ func (h *WebHandler) UserFlags(c *gin.Context) {
 	}
 
 	userFlagKeys := make(map[string]bool)
+	assignedVariants := make(map[string]string)
 	for _, f := range userFlags {
 		userFlagKeys[f.Key] = true
+		assignedVariants[f.Key] = f.AssignedVariant
 	}
 
 	// Get all flags
@@ -346,23 +963,29 @@ func (h *WebHandler) UserFlags(c *gin.Context) {
 
 	allFlags := make([]FlagWithAssignment, 0)
 	for _, f := range flagsResp.FeatureFlags {
+		variants := make([]string, 0, len(f.Variants))
+		for _, v := range f.Variants {
+			variants = append(variants, v.Key)
+		}
 		allFlags = append(allFlags, FlagWithAssignment{
-			ID:          f.ID,
-			Key:         f.Key,
-			Description: f.Description,
-			Enabled:     f.Enabled,
-			IsAssigned:  userFlagKeys[f.Key],
+			ID:              f.ID,
+			Key:             f.Key,
+			Description:     f.Description,
+			Enabled:         f.Enabled,
+			IsAssigned:      userFlagKeys[f.Key],
+			Variants:        variants,
+			AssignedVariant: assignedVariants[f.Key],
 		})
 	}
 
-	data := PageData{
+	data := h.withPermissions(c, PageData{
 		SelectedUser: &model.User{
 			ID:    userResp.ID,
 			Name:  userResp.Name,
 			Email: userResp.Email,
 		},
 		AllFlags: allFlags,
-	}
+	})
 
 	h.templates.ExecuteTemplate(c.Writer, "user-flags-modal", data)
 }
@@ -396,7 +1019,7 @@ func (h *WebHandler) ToggleUserFlag(c *gin.Context) {
 	if isAssigned {
 		err = h.userService.UnassignFeatureFlagFromUser(c.Request.Context(), uint(userID), flagKey)
 	} else {
-		err = h.userService.AssignFeatureFlagToUser(c.Request.Context(), uint(userID), flagKey)
+		err = h.userService.AssignFeatureFlagToUser(c.Request.Context(), uint(userID), flagKey, "")
 	}
 
 	if err != nil {
@@ -407,24 +1030,53 @@ func (h *WebHandler) ToggleUserFlag(c *gin.Context) {
 	h.UserFlags(c)
 }
 
+// SetUserFlagVariant pins the variant an assigned flag serves to a user
+func (h *WebHandler) SetUserFlagVariant(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	err = h.userService.AssignFeatureFlagToUser(c.Request.Context(), uint(userID), c.Param("key"), c.PostForm("variant"))
+	if err != nil {
+		h.logger.Error("failed to set user flag variant", "error", err)
+	}
+
+	// Re-render the modal
+	h.UserFlags(c)
+}
+
 // CreateUser creates a new user from the admin UI
 func (h *WebHandler) CreateUser(c *gin.Context) {
 	name := c.PostForm("name")
 	email := c.PostForm("email")
 	password := c.PostForm("password")
+	role := c.PostForm("role")
 
 	if name == "" || email == "" || password == "" {
 		c.String(http.StatusBadRequest, "Name, email and password are required")
 		return
 	}
 
-	_, err := h.authService.Register(c.Request.Context(), &dto.RegisterRequest{
+	created, err := h.authService.Register(c.Request.Context(), &dto.RegisterRequest{
 		Name:     name,
 		Email:    email,
 		Password: password,
 	})
+	if errors.Is(err, service.ErrWeakPassword) {
+		h.renderUsersListError(c, passwordPolicyMessage(err))
+		return
+	}
 	if err != nil {
 		h.logger.Error("failed to create user", "error", err)
+	} else if role != "" && role != string(model.RoleUser) {
+		// Register always creates plain users; apply the chosen role on top
+		if _, err := h.userService.UpdateUser(c.Request.Context(), created.ID, &dto.UpdateUserRequest{Role: &role}); err != nil {
+			h.logger.Error("failed to set user role", "error", err)
+			h.renderUsersListError(c, "User created, but the role could not be set: "+err.Error())
+			return
+		}
 	}
 
 	h.renderUsersList(c)
@@ -450,7 +1102,9 @@ func (h *WebHandler) EditUserModal(c *gin.Context) {
 			Name:    userResp.Name,
 			Email:   userResp.Email,
 			Enabled: userResp.Enabled,
+			Role:    model.Role(userResp.Role),
 		},
+		Roles: model.Roles,
 	}
 	h.templates.ExecuteTemplate(c.Writer, "user-edit-modal", data)
 }
@@ -466,18 +1120,36 @@ func (h *WebHandler) UpdateUser(c *gin.Context) {
 	name := c.PostForm("name")
 	email := c.PostForm("email")
 	enabled := c.PostForm("enabled") == "true"
-
-	_, err = h.userService.UpdateUser(c.Request.Context(), uint(id), &dto.UpdateUserRequest{
+	req := &dto.UpdateUserRequest{
 		Name:    &name,
 		Email:   &email,
 		Enabled: &enabled,
-	})
-	if err != nil {
+	}
+	if role := c.PostForm("role"); role != "" {
+		req.Role = &role
+	}
+
+	_, err = h.userService.UpdateUser(c.Request.Context(), uint(id), req)
+	switch {
+	case errors.Is(err, service.ErrInvalidRole):
+		h.renderUsersListError(c, "Unknown role")
+		return
+	case errors.Is(err, service.ErrOwnRoleChange):
+		h.renderUsersListError(c, "You cannot change your own role")
+		return
+	case errors.Is(err, service.ErrForbidden):
+		h.renderUsersListError(c, "You are not allowed to make this change")
+		return
+	case err != nil:
 		h.logger.Error("failed to update user", "error", err)
 	}
 
 	if password := c.PostForm("password"); password != "" {
 		if err := h.authService.SetPassword(c.Request.Context(), uint(id), password); err != nil {
+			if errors.Is(err, service.ErrWeakPassword) {
+				h.renderUsersListError(c, passwordPolicyMessage(err))
+				return
+			}
 			h.logger.Error("failed to set password", "error", err)
 		}
 	}
@@ -525,6 +1197,95 @@ func (h *WebHandler) ForceLogoutUser(c *gin.Context) {
 	h.renderUsersList(c)
 }
 
+// ResetUserTOTP turns off a user's two-factor authentication ("Reset 2FA"
+// button), for users who lost their authenticator
+func (h *WebHandler) ResetUserTOTP(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.authService.ResetTOTP(c.Request.Context(), uint(id)); err != nil {
+		h.logger.Error("failed to reset two-factor authentication", "error", err)
+	}
+
+	h.renderUsersList(c)
+}
+
+// UnlockUser lifts a user's login lockout ("Unlock" button)
+func (h *WebHandler) UnlockUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.authService.UnlockUser(c.Request.Context(), uint(id)); err != nil {
+		h.logger.Error("failed to unlock user", "error", err)
+	}
+
+	h.renderUsersList(c)
+}
+
+// UserWebAuthnCredentials lists a user's passkeys and security keys
+func (h *WebHandler) UserWebAuthnCredentials(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	h.renderUserWebAuthnCredentials(c, uint(id))
+}
+
+// RevokeWebAuthnCredential removes one of a user's passkeys or security
+// keys, e.g. a lost one
+func (h *WebHandler) RevokeWebAuthnCredential(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	credentialID, err := strconv.ParseUint(c.Param("credentialId"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid credential ID")
+		return
+	}
+
+	if err := h.authService.RevokeWebAuthnCredential(c.Request.Context(), uint(id), uint(credentialID)); err != nil {
+		h.logger.Error("failed to revoke webauthn credential", "error", err)
+	}
+
+	h.renderUserWebAuthnCredentials(c, uint(id))
+}
+
+// UserSessions lists a user's active sessions
+func (h *WebHandler) UserSessions(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	h.renderUserSessions(c, uint(id))
+}
+
+// RevokeUserSession ends one of a user's sessions
+func (h *WebHandler) RevokeUserSession(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.authService.RevokeUserSession(c.Request.Context(), uint(id), c.Param("sessionId")); err != nil {
+		h.logger.Error("failed to revoke session", "error", err)
+	}
+
+	h.renderUserSessions(c, uint(id))
+}
+
 // AuditTab renders the audit log tab
 func (h *WebHandler) AuditTab(c *gin.Context) {
 	user := middleware.GetUserFromContext(c)
@@ -533,12 +1294,12 @@ func (h *WebHandler) AuditTab(c *gin.Context) {
 		return
 	}
 
-	data := PageData{
+	data := h.withPermissions(c, PageData{
 		Title:     "Audit Log",
 		User:      user,
 		ActiveTab: "audit",
 		AuditLogs: h.loadAuditLogs(c),
-	}
+	})
 
 	if c.GetHeader("HX-Request") == "true" {
 		h.templates.ExecuteTemplate(c.Writer, "audit-content", data)
@@ -550,13 +1311,197 @@ func (h *WebHandler) AuditTab(c *gin.Context) {
 
 // Helper methods
 
+// setSessionCookie hands the browser a newly created session
+func (h *WebHandler) setSessionCookie(c *gin.Context, sessionID string) {
+	c.SetCookie(
+		SessionCookieName,
+		sessionID,
+		int(h.authService.SessionDuration().Seconds()),
+		"/",
+		"",
+		h.cookieSecure,
+		true,
+	)
+}
+
+// renderTwoFactorStep renders the second step of a login challenge; for users
+// enrolling, along with the secret and QR code to set up their authenticator
+func (h *WebHandler) renderTwoFactorStep(c *gin.Context, step *TwoFactorStep, errMsg string) {
+	if step.Enroll {
+		enrollment, err := h.authService.BeginLoginEnrollment(c.Request.Context(), step.ChallengeToken)
+		if err != nil {
+			h.logger.Error("failed to start two-factor enrollment", "error", err)
+			h.renderTemplate(c, "layout.html", "login.html", PageData{
+				Title: "Login",
+				Error: "Your sign-in expired, please sign in again",
+			})
+			return
+		}
+		step.Secret = enrollment.Secret
+		// A data: URI generated by the service, not user input
+		step.QRCode = template.URL(enrollment.QRCode)
+	}
+
+	h.renderTemplate(c, "layout.html", "login.html", PageData{
+		Title:     "Login",
+		Error:     errMsg,
+		TwoFactor: step,
+	})
+}
+
+// twoFactorStepFromForm restores the login challenge carried by the hidden
+// fields of the second step's forms, to show it again
+func twoFactorStepFromForm(c *gin.Context) *TwoFactorStep {
+	return &TwoFactorStep{
+		ChallengeToken: c.PostForm("challenge_token"),
+		Enroll:         c.PostForm("enroll") == "true",
+		Methods:        c.PostFormArray("method"),
+	}
+}
+
+// loginReturnTo returns the authorization request a login form was opened
+// for, from its return_to parameter (or for a login with an external
+// provider, the one it started with), or "" for a login to the admin UI.
+// Only authorization requests are accepted, so it can't redirect elsewhere.
+func (h *WebHandler) loginReturnTo(c *gin.Context) string {
+	returnTo := c.Query("return_to")
+	if c.Request.Method == http.MethodPost {
+		returnTo = c.PostForm("return_to")
+	}
+	if external, ok := c.Get(externalReturnToKey); ok {
+		returnTo = external.(string)
+	}
+	if h.oidc == nil || !strings.HasPrefix(returnTo, authorizePath+"?") {
+		return ""
+	}
+	return returnTo
+}
+
+// redirectAfterLogin continues a completed login with the authorization
+// request it was for, or the dashboard
+func (h *WebHandler) redirectAfterLogin(c *gin.Context) {
+	if returnTo := h.loginReturnTo(c); returnTo != "" {
+		c.Redirect(http.StatusFound, returnTo)
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin")
+}
+
+// webAuthnErrorMessage words a failed passkey or security key login for the
+// login page
+func webAuthnErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, service.ErrWebAuthnVerification):
+		return "Your passkey or security key could not be verified, please try again"
+	case errors.Is(err, service.ErrInvalidLoginChallenge), errors.Is(err, service.ErrInvalidWebAuthnCeremony):
+		return "Your sign-in expired or had too many failed attempts, please sign in again"
+	default:
+		return err.Error()
+	}
+}
+
+// renderUserWebAuthnCredentials renders the security keys modal of a user
+func (h *WebHandler) renderUserWebAuthnCredentials(c *gin.Context, userID uint) {
+	userResp, err := h.userService.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		c.String(http.StatusNotFound, "User not found")
+		return
+	}
+
+	credentials, err := h.authService.GetUserWebAuthnCredentials(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to get webauthn credentials", "error", err)
+	}
+
+	data := h.withPermissions(c, PageData{
+		SelectedUser: &model.User{
+			ID:    userResp.ID,
+			Name:  userResp.Name,
+			Email: userResp.Email,
+		},
+		Credentials: credentials,
+	})
+
+	h.templates.ExecuteTemplate(c.Writer, "user-webauthn-modal", data)
+}
+
+// renderUserSessions renders the sessions modal of a user
+func (h *WebHandler) renderUserSessions(c *gin.Context, userID uint) {
+	userResp, err := h.userService.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		c.String(http.StatusNotFound, "User not found")
+		return
+	}
+
+	sessions, err := h.authService.GetUserSessions(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to get sessions", "error", err)
+	}
+
+	data := h.withPermissions(c, PageData{
+		SelectedUser: &model.User{
+			ID:    userResp.ID,
+			Name:  userResp.Name,
+			Email: userResp.Email,
+		},
+		Sessions: sessions,
+	})
+
+	h.templates.ExecuteTemplate(c.Writer, "user-sessions-modal", data)
+}
+
+// withPermissions fills in what the current user may change, so templates
+// can hide actions the route middleware would reject anyway.
+func (h *WebHandler) withPermissions(c *gin.Context, data PageData) PageData {
+	if user := middleware.GetUserFromContext(c); user != nil {
+		data.CanEditFlags = service.HasPermission(user.Role, service.PermFlagsWrite)
+		data.CanEditUsers = service.HasPermission(user.Role, service.PermUsersWrite)
+		data.CanEditClients = service.HasPermission(user.Role, service.PermClientsWrite)
+		data.CanEditAPIKeys = service.HasPermission(user.Role, service.PermAPIKeysWrite)
+	}
+	data.OIDCEnabled = h.oidc != nil
+	return data
+}
+
+// renderFlagRow re-renders a single row of the flags table
+func (h *WebHandler) renderFlagRow(c *gin.Context, id uint) {
+	for _, f := range h.loadFlags(c) {
+		if f.ID == id {
+			h.templates.ExecuteTemplate(c.Writer, "flag-row", f)
+			return
+		}
+	}
+}
+
+// renderScheduledChanges re-renders the pending scheduled changes table
+func (h *WebHandler) renderScheduledChanges(c *gin.Context) {
+	data := h.withPermissions(c, PageData{
+		Scheduled: h.loadScheduledChanges(c),
+	})
+	h.templates.ExecuteTemplate(c.Writer, "scheduled-changes", data)
+}
+
 func (h *WebHandler) renderUsersList(c *gin.Context) {
-	data := PageData{
+	h.renderUsersListError(c, "")
+}
+
+// renderUsersListError renders the users list with an alert above it, for
+// changes that were refused
+func (h *WebHandler) renderUsersListError(c *gin.Context, errMsg string) {
+	data := h.withPermissions(c, PageData{
 		Users: h.loadUsers(c),
-	}
+		Error: errMsg,
+	})
 	h.templates.ExecuteTemplate(c.Writer, "users-list", data)
 }
 
+// passwordPolicyMessage words a password the policy refused for the admin
+// UI, e.g. "Password must be at least 8 characters long"
+func passwordPolicyMessage(err error) string {
+	msg := err.Error()
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
+
 func (h *WebHandler) loadAuditLogs(c *gin.Context) []AuditRow {
 	logs, _, err := h.auditLogRepo.GetAll(c.Request.Context(), 100, 0)
 	if err != nil {
@@ -571,6 +1516,12 @@ func (h *WebHandler) loadAuditLogs(c *gin.Context) []AuditRow {
 			actor = entry.Actor.Name
 		} else if entry.ActorUserID != nil {
 			actor = "user #" + strconv.FormatUint(uint64(*entry.ActorUserID), 10)
+		} else if entry.ActorAPIKey != nil {
+			actor = "API key " + entry.ActorAPIKey.Name + " (" + entry.ActorAPIKey.Prefix + ")"
+		} else if entry.ActorAPIKeyID != nil {
+			actor = "API key #" + strconv.FormatUint(uint64(*entry.ActorAPIKeyID), 10)
+		} else if entry.ActorService != "" {
+			actor = "service " + entry.ActorService
 		}
 
 		target := entry.TargetType
@@ -598,20 +1549,115 @@ func (h *WebHandler) loadFlags(c *gin.Context) []FlagWithUserCount {
 		return nil
 	}
 
+	canEdit := false
+	if user := middleware.GetUserFromContext(c); user != nil {
+		canEdit = service.HasPermission(user.Role, service.PermFlagsWrite)
+	}
+
 	flags := make([]FlagWithUserCount, 0, len(flagsResp.FeatureFlags))
 	for _, f := range flagsResp.FeatureFlags {
 		flags = append(flags, FlagWithUserCount{
-			ID:          f.ID,
-			Key:         f.Key,
-			Description: f.Description,
-			Enabled:     f.Enabled,
-			UserCount:   0, // TODO: implement user count
+			ID:                f.ID,
+			Key:               f.Key,
+			Description:       f.Description,
+			Enabled:           f.Enabled,
+			RolloutPercentage: f.RolloutPercentage,
+			RuleCount:         len(f.Rules),
+			VariantType:       f.VariantType,
+			VariantCount:      len(f.Variants),
+			PrerequisiteCount: len(f.Prerequisites),
+			UserCount:         0, // TODO: implement user count
+			CanEdit:           canEdit,
 		})
 	}
 
 	return flags
 }
 
+// loadFlagGraph builds the prerequisite graph: flags that others depend on but
+// that depend on nothing themselves at the top, each flag's dependents nested
+// under it. Flags without prerequisites or dependents are left out.
+func (h *WebHandler) loadFlagGraph(c *gin.Context) []FlagGraphNode {
+	pagination := &dto.PaginationParams{Page: 1, PageSize: 100}
+	flagsResp, err := h.featureFlagService.GetFeatureFlags(c.Request.Context(), pagination)
+	if err != nil {
+		h.logger.Error("failed to load flags", "error", err)
+		return nil
+	}
+
+	type edge struct{ key, requires string }
+	dependents := make(map[string][]edge)
+	for _, f := range flagsResp.FeatureFlags {
+		for _, pre := range f.Prerequisites {
+			requires := "enabled"
+			if pre.Variant != "" {
+				requires = "variant " + pre.Variant
+			}
+			dependents[pre.FlagKey] = append(dependents[pre.FlagKey], edge{f.Key, requires})
+		}
+	}
+
+	// Saved flags never form a cycle; path guards against rendering forever
+	// should the data say otherwise
+	var build func(key string, path map[string]bool) []FlagGraphNode
+	build = func(key string, path map[string]bool) []FlagGraphNode {
+		path[key] = true
+		defer delete(path, key)
+		nodes := make([]FlagGraphNode, 0, len(dependents[key]))
+		for _, e := range dependents[key] {
+			node := FlagGraphNode{Key: e.key, Requires: e.requires}
+			if !path[e.key] {
+				node.Dependents = build(e.key, path)
+			}
+			nodes = append(nodes, node)
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Key < nodes[j].Key })
+		return nodes
+	}
+
+	var roots []FlagGraphNode
+	for _, f := range flagsResp.FeatureFlags {
+		if len(f.Prerequisites) == 0 && len(dependents[f.Key]) > 0 {
+			roots = append(roots, FlagGraphNode{Key: f.Key, Dependents: build(f.Key, map[string]bool{})})
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool { return roots[i].Key < roots[j].Key })
+	return roots
+}
+
+func (h *WebHandler) loadScheduledChanges(c *gin.Context) []ScheduledChangeRow {
+	changes, err := h.featureFlagService.GetScheduledFlagChanges(c.Request.Context())
+	if err != nil {
+		h.logger.Error("failed to load scheduled flag changes", "error", err)
+		return nil
+	}
+
+	rows := make([]ScheduledChangeRow, 0, len(changes))
+	for _, change := range changes {
+		label := change.Action
+		if change.RolloutPercentage != nil {
+			label = "rollout → " + strconv.Itoa(*change.RolloutPercentage) + "%"
+		}
+
+		createdBy := "-"
+		if change.CreatedByName != "" {
+			createdBy = change.CreatedByName
+		} else if change.CreatedBy != nil {
+			createdBy = "user #" + strconv.FormatUint(uint64(*change.CreatedBy), 10)
+		}
+
+		rows = append(rows, ScheduledChangeRow{
+			ID:        change.ID,
+			FlagKey:   change.FlagKey,
+			Change:    label,
+			RunAt:     change.RunAt.UTC().Format(time.RFC3339),
+			CreatedBy: createdBy,
+		})
+	}
+
+	return rows
+}
+
 func (h *WebHandler) loadUsers(c *gin.Context) []UserWithFlagCount {
 	pagination := &dto.PaginationParams{Page: 1, PageSize: 100}
 	usersResp, err := h.userService.GetUsers(c.Request.Context(), pagination)
@@ -620,6 +1666,15 @@ func (h *WebHandler) loadUsers(c *gin.Context) []UserWithFlagCount {
 		return nil
 	}
 
+	emails := make([]string, len(usersResp.Users))
+	for i, u := range usersResp.Users {
+		emails[i] = u.Email
+	}
+	lockouts, err := h.authService.GetLoginLockouts(c.Request.Context(), emails)
+	if err != nil {
+		h.logger.Error("failed to load login lockouts", "error", err)
+	}
+
 	users := make([]UserWithFlagCount, 0, len(usersResp.Users))
 	for _, u := range usersResp.Users {
 		flagCount := 0
@@ -628,13 +1683,19 @@ func (h *WebHandler) loadUsers(c *gin.Context) []UserWithFlagCount {
 			flagCount = len(flags)
 		}
 
-		users = append(users, UserWithFlagCount{
-			ID:        u.ID,
-			Name:      u.Name,
-			Email:     u.Email,
-			Enabled:   u.Enabled,
-			FlagCount: flagCount,
-		})
+		user := UserWithFlagCount{
+			ID:          u.ID,
+			Name:        u.Name,
+			Email:       u.Email,
+			Enabled:     u.Enabled,
+			Role:        u.Role,
+			TOTPEnabled: u.TOTPEnabled,
+			FlagCount:   flagCount,
+		}
+		if until, ok := lockouts[u.Email]; ok {
+			user.LockedUntil = &until
+		}
+		users = append(users, user)
 	}
 
 	return users
@@ -645,6 +1706,9 @@ func (h *WebHandler) renderTemplate(c *gin.Context, layout, content string, data
 
 	// Environment label shown in the admin header on every full-page render
 	data.Environment = h.environment
+	// Login pages keep carrying the authorization request that led to them
+	data.ReturnTo = h.loginReturnTo(c)
+	data.LoginProviders = h.externalLogin.Providers()
 
 	// Parse templates fresh each time for development
 	// In production, you might want to cache this
@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"identity/internal/middleware"
+	"identity/internal/service"
+	"identity/internal/service/dto"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorizePath is the OpenID Connect authorization endpoint, which users
+// reach through the apps that sign them in
+const authorizePath = "/oauth2/authorize"
+
+// scopeDescriptions tell users what an app asks for on the consent page
+var scopeDescriptions = map[string]string{
+	service.ScopeOpenID:  "Know who you are",
+	service.ScopeProfile: "See your name",
+	service.ScopeEmail:   "See your email address",
+	service.ScopeRoles:   "See your role",
+}
+
+// ConsentStep asks a signed-in user whether an app may sign them in. The
+// request is carried by the form's hidden fields; Token ties the form to
+// the user's session, so other sites can't post it for them.
+type ConsentStep struct {
+	ClientName string
+	Scopes     []string
+	Request    *dto.AuthorizeRequest
+	Token      string
+}
+
+// Authorize handles the OpenID Connect authorization endpoint: it sends
+// users who aren't signed in to the login page, asks them for consent the
+// first time an app wants their details, and then sends them back to the
+// app with an authorization code
+func (h *WebHandler) Authorize(c *gin.Context) {
+	var req dto.AuthorizeRequest
+	_ = c.ShouldBindQuery(&req)
+
+	h.authorize(c, &req, "")
+}
+
+// AuthorizeSubmit handles the consent form, whose decision is allow or deny
+func (h *WebHandler) AuthorizeSubmit(c *gin.Context) {
+	var req dto.AuthorizeRequest
+	_ = c.ShouldBind(&req)
+
+	decision := c.PostForm("decision")
+	if decision != "allow" && decision != "deny" {
+		decision = "deny"
+	}
+	h.authorize(c, &req, decision)
+}
+
+// authorize answers an authorization request, with the user's decision if
+// they just made one on the consent page
+func (h *WebHandler) authorize(c *gin.Context, req *dto.AuthorizeRequest, decision string) {
+	ctx := c.Request.Context()
+
+	client, err := h.oidc.CheckAuthorization(ctx, req)
+	if err != nil {
+		var oauthErr *service.OAuthError
+		switch {
+		case errors.As(err, &oauthErr):
+			c.Redirect(http.StatusFound, h.oidc.AuthorizationErrorURL(req, oauthErr))
+		case errors.Is(err, service.ErrUnknownOAuthClient), errors.Is(err, service.ErrInvalidRedirectURI):
+			c.Status(http.StatusBadRequest)
+			h.renderTemplate(c, "layout.html", "consent.html", PageData{
+				Title: "Sign in",
+				Error: "This app's sign-in request is invalid: " + err.Error(),
+			})
+		default:
+			h.logger.Error("failed to check authorization request", "error", err)
+			c.Status(http.StatusInternalServerError)
+			h.renderTemplate(c, "layout.html", "consent.html", PageData{
+				Title: "Sign in",
+				Error: "Something went wrong, please try again",
+			})
+		}
+		return
+	}
+	prompts := strings.Fields(req.Prompt)
+
+	sessionID, _ := c.Cookie(SessionCookieName)
+	session, err := h.authService.GetSession(ctx, sessionID)
+	// The consent form was shown after any login the request asked for
+	if err != nil || (decision == "" && service.LoginRequired(req, session.CreatedAt)) {
+		if slices.Contains(prompts, "none") {
+			h.authorizationError(c, req, "login_required", "the user is not signed in")
+			return
+		}
+		c.Redirect(http.StatusFound, "/admin/login?"+url.Values{"return_to": {authorizeURL(req)}}.Encode())
+		return
+	}
+
+	switch decision {
+	case "deny":
+		h.authorizationError(c, req, "access_denied", "the user denied the request")
+		return
+	case "allow":
+		if subtle.ConstantTimeCompare([]byte(c.PostForm("consent_token")), []byte(consentToken(sessionID))) != 1 {
+			c.Status(http.StatusForbidden)
+			h.renderTemplate(c, "layout.html", "consent.html", PageData{
+				Title: "Sign in",
+				Error: "Your consent could not be verified, please go back to the app and try again",
+			})
+			return
+		}
+	default:
+		consented, err := h.oidc.HasConsent(ctx, session.UserID, client, req.Scope)
+		if err != nil {
+			h.logger.Error("failed to check consent", "error", err)
+			h.authorizationError(c, req, "server_error", "the request could not be completed")
+			return
+		}
+		if !consented || slices.Contains(prompts, "consent") {
+			if slices.Contains(prompts, "none") {
+				h.authorizationError(c, req, "consent_required", "the user has not allowed the app")
+				return
+			}
+			h.renderConsentStep(c, client.Name, req, sessionID)
+			return
+		}
+	}
+
+	redirect, err := h.oidc.Authorize(ctx, session, client, req)
+	if err != nil {
+		h.logger.Error("failed to authorize", "error", err)
+		h.authorizationError(c, req, "server_error", "the request could not be completed")
+		return
+	}
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// renderConsentStep asks the user whether the app may sign them in
+func (h *WebHandler) renderConsentStep(c *gin.Context, clientName string, req *dto.AuthorizeRequest, sessionID string) {
+	step := &ConsentStep{
+		ClientName: clientName,
+		Request:    req,
+		Token:      consentToken(sessionID),
+	}
+	for _, scope := range strings.Fields(req.Scope) {
+		step.Scopes = append(step.Scopes, scopeDescriptions[scope])
+	}
+
+	h.renderTemplate(c, "layout.html", "consent.html", PageData{
+		Title:   "Sign in",
+		Consent: step,
+	})
+}
+
+// authorizationError sends the user back to the app with an error
+func (h *WebHandler) authorizationError(c *gin.Context, req *dto.AuthorizeRequest, code, description string) {
+	c.Redirect(http.StatusFound, h.oidc.AuthorizationErrorURL(req, &service.OAuthError{Code: code, Description: description}))
+}
+
+// authorizeURL rebuilds the path and query of an authorization request for
+// the login page to return to. A prompt to sign in again is dropped, as the
+// user is about to.
+func authorizeURL(req *dto.AuthorizeRequest) string {
+	query := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	set("response_type", req.ResponseType)
+	set("client_id", req.ClientID)
+	set("redirect_uri", req.RedirectURI)
+	set("scope", req.Scope)
+	set("state", req.State)
+	set("nonce", req.Nonce)
+	set("code_challenge", req.CodeChallenge)
+	set("code_challenge_method", req.CodeChallengeMethod)
+	set("prompt", strings.Join(slices.DeleteFunc(strings.Fields(req.Prompt), func(p string) bool { return p == "login" }), " "))
+	set("max_age", req.MaxAge)
+	return authorizePath + "?" + query.Encode()
+}
+
+// consentToken derives the token a session's consent forms carry
+func consentToken(sessionID string) string {
+	sum := sha256.Sum256([]byte("oauth-consent:" + sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+// ClientsTab renders the apps tab: the clients registered with the OpenID
+// Connect provider
+func (h *WebHandler) ClientsTab(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	data := h.withPermissions(c, PageData{
+		Title:     "Apps",
+		User:      user,
+		ActiveTab: "clients",
+		Clients:   h.loadClients(c),
+	})
+
+	if c.GetHeader("HX-Request") == "true" {
+		h.templates.ExecuteTemplate(c.Writer, "clients-content", data)
+		return
+	}
+
+	h.renderTemplate(c, "layout.html", "dashboard.html", data)
+}
+
+// CreateClient registers an app from the admin UI, showing its secret once
+func (h *WebHandler) CreateClient(c *gin.Context) {
+	req := dto.CreateOAuthClientRequest{
+		Name:         c.PostForm("name"),
+		RedirectURIs: strings.Fields(c.PostForm("redirect_uris")),
+		Public:       c.PostForm("public") == "true",
+	}
+
+	created, err := h.oidc.CreateClient(c.Request.Context(), &req)
+	if err != nil {
+		h.logger.Error("failed to create client", "error", err)
+		h.renderClientsList(c, nil, clientErrorMessage(err))
+		return
+	}
+
+	h.renderClientsList(c, created, "")
+}
+
+// RegenerateClientSecret replaces an app's secret, showing the new one once
+func (h *WebHandler) RegenerateClientSecret(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid client ID")
+		return
+	}
+
+	rotated, err := h.oidc.RegenerateClientSecret(c.Request.Context(), uint(id))
+	if err != nil {
+		h.logger.Error("failed to regenerate client secret", "error", err)
+		h.renderClientsList(c, nil, clientErrorMessage(err))
+		return
+	}
+
+	h.renderClientsList(c, rotated, "")
+}
+
+// DeleteClient deletes an app, ending the sign-ins it holds
+func (h *WebHandler) DeleteClient(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid client ID")
+		return
+	}
+
+	if err := h.oidc.DeleteClient(c.Request.Context(), uint(id)); err != nil {
+		h.logger.Error("failed to delete client", "error", err)
+	}
+
+	h.renderClientsList(c, nil, "")
+}
+
+// renderClientsList renders the apps table, with a secret just issued or an
+// alert about a refused change above it
+func (h *WebHandler) renderClientsList(c *gin.Context, secret *dto.OAuthClientSecretResponse, errMsg string) {
+	data := h.withPermissions(c, PageData{
+		Clients: h.loadClients(c),
+		Error:   errMsg,
+	})
+	if secret != nil && secret.ClientSecret != "" {
+		data.ClientSecret = secret
+	}
+	h.templates.ExecuteTemplate(c.Writer, "clients-list", data)
+}
+
+// clientErrorMessage words a refused client change for the admin UI
+func clientErrorMessage(err error) string {
+	if errors.Is(err, service.ErrInvalidClientRegistration) {
+		msg := strings.TrimPrefix(err.Error(), service.ErrInvalidClientRegistration.Error()+": ")
+		return strings.ToUpper(msg[:1]) + msg[1:]
+	}
+	return "Something went wrong, please try again"
+}
+
+func (h *WebHandler) loadClients(c *gin.Context) []dto.OAuthClientResponse {
+	clients, err := h.oidc.GetClients(c.Request.Context())
+	if err != nil {
+		h.logger.Error("failed to get clients", "error", err)
+		return nil
+	}
+	return clients
+}
@@ -0,0 +1,319 @@
+package handler
+
+import (
+	"identity/internal/middleware"
+	"identity/internal/service/dto"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BeginWebAuthnRegistration godoc
+// @Summary Start registering a passkey or security key
+// @Description Returns the options for navigator.credentials.create() and the ceremony_token to send its result back with. Passkeys are asked for where the authenticator supports them, so the same credential also signs in without a password.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} dto.WebAuthnOptionsResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Router /api/v1/auth/webauthn/register/begin [post]
+func (h *AuthHandler) BeginWebAuthnRegistration(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+
+	resp, err := h.authService.BeginWebAuthnRegistration(c.Request.Context(), user.ID)
+	if err != nil {
+		h.writeTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// FinishWebAuthnRegistration godoc
+// @Summary Finish registering a passkey or security key
+// @Description Check the result of navigator.credentials.create() and save the new credential for the current user
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.FinishWebAuthnRegistrationRequest true "Ceremony token, name and credential"
+// @Success 201 {object} dto.WebAuthnCredentialResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Router /api/v1/auth/webauthn/register/finish [post]
+func (h *AuthHandler) FinishWebAuthnRegistration(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+
+	var req dto.FinishWebAuthnRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "ceremony_token and credential are required",
+		})
+		return
+	}
+
+	resp, err := h.authService.FinishWebAuthnRegistration(c.Request.Context(), user.ID, &req)
+	if err != nil {
+		h.writeTwoFactorError(c, err)
+		return
+	}
+
+	h.logger.Info("webauthn credential registered", "user_id", user.ID, "credential_id", resp.ID)
+	c.JSON(http.StatusCreated, resp)
+}
+
+// GetWebAuthnCredentials godoc
+// @Summary List my passkeys and security keys
+// @Description List the passkeys and security keys registered by the current user
+// @Tags auth
+// @Produce json
+// @Success 200 {array} dto.WebAuthnCredentialResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Router /api/v1/auth/webauthn/credentials [get]
+func (h *AuthHandler) GetWebAuthnCredentials(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+
+	credentials, err := h.authService.GetWebAuthnCredentials(c.Request.Context(), user.ID)
+	if err != nil {
+		h.writeTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, credentials)
+}
+
+// DeleteWebAuthnCredential godoc
+// @Summary Remove one of my passkeys or security keys
+// @Description Remove a passkey or security key of the current user. Users whose role requires two-factor authentication can't remove their last one without TOTP set up.
+// @Tags auth
+// @Produce json
+// @Param id path int true "Credential ID"
+// @Success 200 {object} dto.SuccessResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Router /api/v1/auth/webauthn/credentials/{id} [delete]
+func (h *AuthHandler) DeleteWebAuthnCredential(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid credential ID",
+		})
+		return
+	}
+
+	if err := h.authService.DeleteWebAuthnCredential(c.Request.Context(), user.ID, uint(id)); err != nil {
+		h.writeCredentialError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Message: "Credential removed",
+	})
+}
+
+// GetUserWebAuthnCredentials godoc
+// @Summary List a user's passkeys and security keys
+// @Description List the passkeys and security keys a user registered
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} dto.WebAuthnCredentialResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/v1/users/{id}/webauthn-credentials [get]
+func (h *AuthHandler) GetUserWebAuthnCredentials(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid user ID",
+		})
+		return
+	}
+
+	credentials, err := h.authService.GetUserWebAuthnCredentials(c.Request.Context(), uint(id))
+	if err != nil {
+		h.writeCredentialError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, credentials)
+}
+
+// RevokeWebAuthnCredential godoc
+// @Summary Revoke a user's passkey or security key
+// @Description Remove a passkey or security key of a user, e.g. a lost one. Users whose role requires two-factor authentication set it up again on their next login if it was their last.
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param credentialId path int true "Credential ID"
+// @Success 200 {object} dto.SuccessResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/v1/users/{id}/webauthn-credentials/{credentialId} [delete]
+func (h *AuthHandler) RevokeWebAuthnCredential(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid user ID",
+		})
+		return
+	}
+	credentialID, err := strconv.ParseUint(c.Param("credentialId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid credential ID",
+		})
+		return
+	}
+
+	if err := h.authService.RevokeWebAuthnCredential(c.Request.Context(), uint(userID), uint(credentialID)); err != nil {
+		h.writeCredentialError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.SuccessResponse{
+		Message: "Credential revoked",
+	})
+}
+
+// BeginPasskeyLogin godoc
+// @Summary Start a passkey login
+// @Description Returns the options for navigator.credentials.get() and the ceremony_token to send its result back with. No email or password is needed: the passkey identifies the user.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} dto.WebAuthnOptionsResponse
+// @Router /api/v1/auth/passkey/begin [post]
+func (h *AuthHandler) BeginPasskeyLogin(c *gin.Context) {
+	resp, err := h.authService.BeginPasskeyLogin(c.Request.Context())
+	if err != nil {
+		h.writeTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// FinishPasskeyLogin godoc
+// @Summary Finish a passkey login
+// @Description Exchange the result of navigator.credentials.get() for a session; sets the session_id cookie
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.FinishPasskeyLoginRequest true "Ceremony token and credential"
+// @Success 200 {object} dto.LoginResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Router /api/v1/auth/passkey/finish [post]
+func (h *AuthHandler) FinishPasskeyLogin(c *gin.Context) {
+	var req dto.FinishPasskeyLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "ceremony_token and credential are required",
+		})
+		return
+	}
+
+	resp, err := h.authService.FinishPasskeyLogin(c.Request.Context(), &req)
+	if err != nil {
+		h.logger.Error("passkey login failed", "error", err)
+		h.writeTwoFactorError(c, err)
+		return
+	}
+
+	h.setSessionCookie(c, resp.SessionID)
+
+	h.logger.Info("user logged in", "user_id", resp.User.ID, "email", resp.User.Email, "passkey", true)
+	c.JSON(http.StatusOK, resp)
+}
+
+// BeginLoginWebAuthn godoc
+// @Summary Use a security key for a two-factor login
+// @Description For a login answered with "webauthn" among its two_factor_methods: returns the options for navigator.credentials.get(), whose result goes to /api/v1/auth/login/webauthn/finish with the same challenge_token
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.LoginWebAuthnRequest true "Challenge token"
+// @Success 200 {object} dto.WebAuthnOptionsResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Router /api/v1/auth/login/webauthn/begin [post]
+func (h *AuthHandler) BeginLoginWebAuthn(c *gin.Context) {
+	var req dto.LoginWebAuthnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "challenge_token is required",
+		})
+		return
+	}
+
+	resp, err := h.authService.BeginLoginWebAuthn(c.Request.Context(), req.ChallengeToken)
+	if err != nil {
+		h.writeTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// VerifyLoginWebAuthn godoc
+// @Summary Complete a two-factor login with a security key
+// @Description Exchange the challenge_token from /api/v1/auth/login and the result of navigator.credentials.get() for a session; sets the session_id cookie
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.VerifyLoginWebAuthnRequest true "Challenge token and credential"
+// @Success 200 {object} dto.LoginResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 429 {object} dto.ErrorResponse
+// @Router /api/v1/auth/login/webauthn/finish [post]
+func (h *AuthHandler) VerifyLoginWebAuthn(c *gin.Context) {
+	var req dto.VerifyLoginWebAuthnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "challenge_token and credential are required",
+		})
+		return
+	}
+
+	resp, err := h.authService.VerifyLoginWebAuthn(c.Request.Context(), &req)
+	if err != nil {
+		h.logger.Error("security key login failed", "error", err)
+		h.writeTwoFactorError(c, err)
+		return
+	}
+
+	h.setSessionCookie(c, resp.SessionID)
+
+	h.logger.Info("user logged in", "user_id", resp.User.ID, "email", resp.User.Email, "two_factor", true)
+	c.JSON(http.StatusOK, resp)
+}
+
+// writeCredentialError maps errors from managing WebAuthn credentials
+func (h *AuthHandler) writeCredentialError(c *gin.Context, err error) {
+	if writeForbidden(c, err) {
+		return
+	}
+	switch err.Error() {
+	case "user not found", "credential not found":
+		c.JSON(http.StatusNotFound, dto.ErrorResponse{
+			Error:   "not_found",
+			Message: err.Error(),
+		})
+	default:
+		h.writeTwoFactorError(c, err)
+	}
+}
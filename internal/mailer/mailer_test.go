@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatMessage(t *testing.T) {
+	data, err := formatMessage("Identity <no-reply@example.com>", Message{
+		To:      "john@example.com",
+		Subject: "Reset your password",
+		Body:    "Hi John,\n\nOpen this link.\n",
+	}, time.Date(2026, 7, 17, 9, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("format failed: %v", err)
+	}
+
+	msg := string(data)
+	for _, want := range []string{
+		"From: Identity <no-reply@example.com>\r\n",
+		"To: john@example.com\r\n",
+		"Subject: Reset your password\r\n",
+		"Date: Fri, 17 Jul 2026 09:30:00 +0000\r\n",
+		"\r\n\r\nHi John,\r\n\r\nOpen this link.\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("expected %q in\n%s", want, msg)
+		}
+	}
+}
+
+func TestFormatMessageRejectsHeaderInjection(t *testing.T) {
+	for _, to := range []string{"john@example.com\r\nBcc: eve@example.com", "not an address"} {
+		if _, err := formatMessage("no-reply@example.com", Message{To: to, Subject: "Hi"}, time.Now()); err == nil {
+			t.Errorf("expected recipient %q to be rejected", to)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m, err := NewFileMailer(dir, "no-reply@example.com")
+	if err != nil {
+		t.Fatalf("failed to create mailer: %v", err)
+	}
+
+	if err := m.Send(context.Background(), Message{To: "john@example.com", Subject: "Hi", Body: "Hello"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one .eml file, got %v (%v)", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("failed to read email: %v", err)
+	}
+	if !strings.Contains(string(data), "To: john@example.com") || !strings.HasSuffix(string(data), "Hello") {
+		t.Errorf("unexpected email:\n%s", data)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"identity/internal/service"
 	"log/slog"
 	"net/http"
+	"slices"
 
 	"github.com/gin-gonic/gin"
 )
@@ -71,9 +72,7 @@ func Auth(authService service.AuthService, logger *slog.Logger, cookieSecure boo
 			return
 		}
 
-		// Set user in context (and as audit actor on the request context)
-		c.Set(UserContextKey, user)
-		c.Request = c.Request.WithContext(service.WithActor(c.Request.Context(), user.ID))
+		setUser(c, user)
 		c.Next()
 	}
 }
@@ -95,9 +94,7 @@ func OptionalAuth(authService service.AuthService, logger *slog.Logger) gin.Hand
 			return
 		}
 
-		// Set user in context (and as audit actor on the request context)
-		c.Set(UserContextKey, user)
-		c.Request = c.Request.WithContext(service.WithActor(c.Request.Context(), user.ID))
+		setUser(c, user)
 		c.Next()
 	}
 }
@@ -123,9 +120,80 @@ func WebAuth(authService service.AuthService, logger *slog.Logger, cookieSecure
 			return
 		}
 
-		// Set user in context (and as audit actor on the request context)
-		c.Set(UserContextKey, user)
-		c.Request = c.Request.WithContext(service.WithActor(c.Request.Context(), user.ID))
+		setUser(c, user)
+		c.Next()
+	}
+}
+
+// setUser stores the user in the gin context, and as audit actor (ID and role)
+// on the request context so services can attribute and authorize actions.
+func setUser(c *gin.Context, user *model.User) {
+	c.Set(UserContextKey, user)
+	ctx := service.WithActor(c.Request.Context(), user.ID)
+	ctx = service.WithActorRole(ctx, user.Role)
+	c.Request = c.Request.WithContext(ctx)
+}
+
+// RequirePermission creates a middleware that rejects users whose role lacks
+// perm, and API keys whose scopes lack it. It must run after Auth or
+// AuthOrAPIKey, which set the user or key in the context.
+func RequirePermission(perm service.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiKey := GetAPIKeyFromContext(c); apiKey != nil {
+			if !slices.Contains(service.APIKeyPermissions(apiKey), perm) {
+				forbid(c)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		user := GetUserFromContext(c)
+		if user == nil || !service.HasPermission(user.Role, perm) {
+			forbid(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireRole creates a middleware that only admits users with one of the
+// given roles. Prefer RequirePermission; this is for routes tied to a role
+// rather than to an action. It must run after Auth.
+func RequireRole(roles ...model.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetUserFromContext(c)
+		if user != nil {
+			for _, role := range roles {
+				if user.Role == role {
+					c.Next()
+					return
+				}
+			}
+		}
+		forbid(c)
+	}
+}
+
+// forbid answers 403 and stops the request
+func forbid(c *gin.Context) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":   "forbidden",
+		"message": "You do not have permission to perform this action",
+	})
+	c.Abort()
+}
+
+// WebRequirePermission is RequirePermission for the admin UI: it answers with
+// plain text (rendered by htmx) instead of JSON. It must run after WebAuth.
+func WebRequirePermission(perm service.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetUserFromContext(c)
+		if user == nil || !service.HasPermission(user.Role, perm) {
+			c.String(http.StatusForbidden, "You do not have permission to perform this action")
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"identity/internal/model"
+	"identity/internal/service"
 	"identity/internal/service/dto"
 	"io"
 	"log/slog"
@@ -33,6 +34,9 @@ func (s *stubAuthService) Register(ctx context.Context, req *dto.RegisterRequest
 func (s *stubAuthService) ValidateSession(ctx context.Context, sessionID string) (*model.User, error) {
 	return s.user, s.validateErr
 }
+func (s *stubAuthService) GetSession(ctx context.Context, sessionID string) (*model.Session, error) {
+	return nil, nil
+}
 func (s *stubAuthService) GetUserBySession(ctx context.Context, sessionID string) (*dto.UserResponse, error) {
 	return nil, nil
 }
@@ -43,6 +47,79 @@ func (s *stubAuthService) ForceLogout(ctx context.Context, actorUserID *uint, us
 	return nil
 }
 func (s *stubAuthService) SessionDuration() time.Duration { return time.Hour }
+func (s *stubAuthService) VerifyLogin(ctx context.Context, req *dto.VerifyLoginRequest) (*dto.LoginResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) BeginLoginEnrollment(ctx context.Context, challengeToken string) (*dto.TOTPEnrollmentResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) BeginTOTPEnrollment(ctx context.Context, userID uint) (*dto.TOTPEnrollmentResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) ConfirmTOTPEnrollment(ctx context.Context, userID uint, code string) (*dto.RecoveryCodesResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) (*dto.RecoveryCodesResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) DisableTOTP(ctx context.Context, userID uint, code string) error {
+	return nil
+}
+func (s *stubAuthService) ResetTOTP(ctx context.Context, userID uint) error { return nil }
+func (s *stubAuthService) BeginWebAuthnRegistration(ctx context.Context, userID uint) (*dto.WebAuthnOptionsResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) FinishWebAuthnRegistration(ctx context.Context, userID uint, req *dto.FinishWebAuthnRegistrationRequest) (*dto.WebAuthnCredentialResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) GetWebAuthnCredentials(ctx context.Context, userID uint) ([]dto.WebAuthnCredentialResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) GetUserWebAuthnCredentials(ctx context.Context, userID uint) ([]dto.WebAuthnCredentialResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) DeleteWebAuthnCredential(ctx context.Context, userID, credentialID uint) error {
+	return nil
+}
+func (s *stubAuthService) RevokeWebAuthnCredential(ctx context.Context, userID, credentialID uint) error {
+	return nil
+}
+func (s *stubAuthService) BeginPasskeyLogin(ctx context.Context) (*dto.WebAuthnOptionsResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) FinishPasskeyLogin(ctx context.Context, req *dto.FinishPasskeyLoginRequest) (*dto.LoginResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) BeginLoginWebAuthn(ctx context.Context, challengeToken string) (*dto.WebAuthnOptionsResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) VerifyLoginWebAuthn(ctx context.Context, req *dto.VerifyLoginWebAuthnRequest) (*dto.LoginResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) LoginWithIdentity(ctx context.Context, userID uint, provider string) (*dto.LoginResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) GetUserLockout(ctx context.Context, userID uint) (*dto.LoginLockoutResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) GetSessions(ctx context.Context, userID uint, currentSessionID string) ([]dto.SessionResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) RevokeSession(ctx context.Context, userID uint, handle string) error {
+	return nil
+}
+func (s *stubAuthService) GetUserSessions(ctx context.Context, userID uint) ([]dto.SessionResponse, error) {
+	return nil, nil
+}
+func (s *stubAuthService) RevokeUserSession(ctx context.Context, userID uint, handle string) error {
+	return nil
+}
+func (s *stubAuthService) GetLoginLockouts(ctx context.Context, emails []string) (map[string]time.Time, error) {
+	return nil, nil
+}
+func (s *stubAuthService) UnlockUser(ctx context.Context, userID uint) error {
+	return nil
+}
 
 func discardLogger() *slog.Logger {
 	return slog.New(slog.NewTextHandler(io.Discard, nil))
@@ -117,3 +194,60 @@ func TestWebAuthClearsCookieOnInvalidSession(t *testing.T) {
 		t.Fatalf("expected the cookie to be expired, got %q", sc)
 	}
 }
+
+// The management API must turn away end users (e.g. BFF logins) even though
+// their session is valid, while letting roles with the permission through.
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name string
+		role model.Role
+		want int
+	}{
+		{name: "admin", role: model.RoleAdmin, want: http.StatusOK},
+		{name: "viewer", role: model.RoleViewer, want: http.StatusForbidden},
+		{name: "end user", role: model.RoleUser, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubAuthService{user: &model.User{ID: 1, Role: tt.role, Enabled: true}}
+
+			router := gin.New()
+			router.Use(Auth(svc, discardLogger(), false))
+			router.DELETE("/api/v1/users/:id", RequirePermission(service.PermUsersWrite), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/2", nil)
+			req.Header.Set(SessionHeaderName, "live-session")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+// Auth must expose the actor's role on the request context so services can
+// enforce permissions themselves.
+func TestAuthSetsActorRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := &stubAuthService{user: &model.User{ID: 7, Role: model.RoleFlagEditor, Enabled: true}}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/feature-flags", nil)
+	req.Header.Set(SessionHeaderName, "live-session")
+	c.Request = req
+
+	Auth(svc, discardLogger(), false)(c)
+
+	role, ok := service.ActorRoleFromContext(c.Request.Context())
+	if !ok || role != model.RoleFlagEditor {
+		t.Fatalf("expected actor role %q, got %q (set=%v)", model.RoleFlagEditor, role, ok)
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"identity/internal/service"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServiceContextKey is the key used to store the name of the service a
+// request's client certificate identifies in the context
+const ServiceContextKey = "service"
+
+// RequireClientCert creates a middleware for the routes other services call
+// when they must identify with a client certificate (TLS_CLIENT_CA_FILE).
+// The TLS handshake verifies the certificate against the client CAs; this
+// turns away requests without one (401) and those whose certificate names
+// no service in services, keyed by subject (403). The service is recorded as
+// the audit actor.
+func RequireClientCert(services map[string]string, logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "unauthorized",
+				"message": "Client certificate required",
+			})
+			c.Abort()
+			return
+		}
+
+		cert := c.Request.TLS.VerifiedChains[0][0]
+		name, ok := certService(cert, services)
+		if !ok {
+			logger.Warn("client certificate names no known service", "subject", cert.Subject.String())
+			forbid(c)
+			return
+		}
+
+		c.Set(ServiceContextKey, name)
+		c.Request = c.Request.WithContext(service.WithActorService(c.Request.Context(), name))
+		c.Next()
+	}
+}
+
+// certService returns the service a certificate's common name, or failing
+// that one of its DNS names, is mapped to
+func certService(cert *x509.Certificate, services map[string]string) (string, bool) {
+	if name, ok := services[cert.Subject.CommonName]; ok && cert.Subject.CommonName != "" {
+		return name, true
+	}
+	for _, dnsName := range cert.DNSNames {
+		if name, ok := services[dnsName]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// GetServiceFromContext retrieves the name of the service a request came
+// from from the gin context, or "" if it didn't identify one
+func GetServiceFromContext(c *gin.Context) string {
+	return c.GetString(ServiceContextKey)
+}
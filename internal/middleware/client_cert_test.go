@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"identity/internal/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Internal routes take only verified client certificates naming a known
+// service, and attribute the request to that service
+func TestRequireClientCert(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	services := map[string]string{
+		"transactions.internal": "transactions",
+		"bff.svc.cluster.local": "bff",
+	}
+
+	tests := []struct {
+		name        string
+		cert        *x509.Certificate
+		want        int
+		wantService string
+	}{
+		{name: "no certificate", want: http.StatusUnauthorized},
+		{
+			name:        "common name",
+			cert:        &x509.Certificate{Subject: pkix.Name{CommonName: "transactions.internal"}},
+			want:        http.StatusOK,
+			wantService: "transactions",
+		},
+		{
+			name:        "DNS name",
+			cert:        &x509.Certificate{DNSNames: []string{"bff.svc.cluster.local"}},
+			want:        http.StatusOK,
+			wantService: "bff",
+		},
+		{
+			name: "unknown subject",
+			cert: &x509.Certificate{Subject: pkix.Name{CommonName: "reports.internal"}},
+			want: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actor string
+			router := gin.New()
+			router.GET("/api/v1/internal/users/:id", RequireClientCert(services, discardLogger()), func(c *gin.Context) {
+				actor = service.ActorServiceFromContext(c.Request.Context())
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/internal/users/1", nil)
+			if tt.cert != nil {
+				// As left by a handshake that verified the certificate
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tt.cert}}}
+			}
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, w.Code)
+			}
+			if actor != tt.wantService {
+				t.Fatalf("expected the audit actor to be %q, got %q", tt.wantService, actor)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"identity/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientInfo stores the client's address and User-Agent in the request
+// context, where login throttling, the audit log and new sessions pick them
+// up. Behind the BFF the address is the one it forwards in X-Forwarded-For,
+// as long as the BFF is in TRUSTED_PROXIES, and the User-Agent is whatever
+// the BFF passes on.
+func ClientInfo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := service.WithClientIP(c.Request.Context(), c.ClientIP())
+		ctx = service.WithUserAgent(ctx, c.Request.UserAgent())
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
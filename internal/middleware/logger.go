@@ -20,13 +20,18 @@ func Logger(logger *slog.Logger) gin.HandlerFunc {
 		latency := time.Since(start)
 
 		// Log request details
-		logger.Info("request completed",
+		attrs := []any{
 			"method", c.Request.Method,
 			"path", c.Request.URL.Path,
 			"status", c.Writer.Status(),
 			"latency", latency.String(),
 			"client_ip", c.ClientIP(),
 			"user_agent", c.Request.UserAgent(),
-		)
+		}
+		// Services identified by their client certificate are named
+		if name := GetServiceFromContext(c); name != "" {
+			attrs = append(attrs, "service", name)
+		}
+		logger.Info("request completed", attrs...)
 	}
 }
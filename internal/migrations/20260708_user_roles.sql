@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(32) NOT NULL DEFAULT 'user';
+
+-- Until now every account could reach the management API. Keep the first
+-- account (the seeded admin) as admin; everyone else becomes a plain user and
+-- must be promoted explicitly from the admin UI.
+UPDATE users SET role = 'admin' WHERE id = (SELECT MIN(id) FROM users WHERE deleted_at IS NULL);
@@ -0,0 +1,5 @@
+ALTER TABLE feature_flags ADD COLUMN IF NOT EXISTS rollout_percentage SMALLINT NOT NULL DEFAULT 0;
+
+ALTER TABLE feature_flags DROP CONSTRAINT IF EXISTS chk_feature_flags_rollout_percentage;
+ALTER TABLE feature_flags ADD CONSTRAINT chk_feature_flags_rollout_percentage
+    CHECK (rollout_percentage BETWEEN 0 AND 100);
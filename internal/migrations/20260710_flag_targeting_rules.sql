@@ -0,0 +1 @@
+ALTER TABLE feature_flags ADD COLUMN IF NOT EXISTS rules JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,10 @@
+ALTER TABLE feature_flags ADD COLUMN IF NOT EXISTS variant_type VARCHAR(16) NOT NULL DEFAULT 'boolean';
+ALTER TABLE feature_flags ADD COLUMN IF NOT EXISTS variants JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE feature_flags ADD COLUMN IF NOT EXISTS off_variant VARCHAR(255) NOT NULL DEFAULT '';
+
+ALTER TABLE feature_flags DROP CONSTRAINT IF EXISTS chk_feature_flags_variant_type;
+ALTER TABLE feature_flags ADD CONSTRAINT chk_feature_flags_variant_type
+    CHECK (variant_type IN ('boolean', 'string', 'number', 'json'));
+
+-- An assignment may pin a variant; empty keeps the flag's weighted distribution
+ALTER TABLE user_feature_flags ADD COLUMN IF NOT EXISTS variant VARCHAR(255) NOT NULL DEFAULT '';
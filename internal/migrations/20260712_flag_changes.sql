@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS flag_changes (
+    id         BIGSERIAL PRIMARY KEY,
+    kind       VARCHAR(16) NOT NULL,
+    flag_key   VARCHAR(255) NOT NULL,
+    user_id    BIGINT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_flag_changes_created_at ON flag_changes (created_at);
+
+-- Every logged change is announced on the flag_changes channel, so each
+-- replica can drop cached flags and wake its flag streams
+CREATE OR REPLACE FUNCTION notify_flag_change() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('flag_changes', json_build_object(
+        'id', NEW.id,
+        'kind', NEW.kind,
+        'flag_key', NEW.flag_key,
+        'user_id', NEW.user_id
+    )::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_flag_changes_notify ON flag_changes;
+CREATE TRIGGER trg_flag_changes_notify
+    AFTER INSERT ON flag_changes
+    FOR EACH ROW EXECUTE FUNCTION notify_flag_change();
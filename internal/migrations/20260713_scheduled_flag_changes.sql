@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS scheduled_flag_changes (
+    id                 BIGSERIAL PRIMARY KEY,
+    feature_flag_id    BIGINT NOT NULL REFERENCES feature_flags (id) ON DELETE CASCADE,
+    action             VARCHAR(16) NOT NULL,
+    rollout_percentage SMALLINT,
+    run_at             TIMESTAMPTZ NOT NULL,
+    status             VARCHAR(16) NOT NULL DEFAULT 'pending',
+    created_by         BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    executed_at        TIMESTAMPTZ,
+    error              TEXT NOT NULL DEFAULT '',
+    created_at         TIMESTAMPTZ,
+    updated_at         TIMESTAMPTZ,
+    CONSTRAINT chk_scheduled_flag_changes_action
+        CHECK (action IN ('enable', 'disable', 'set_rollout')),
+    CONSTRAINT chk_scheduled_flag_changes_rollout_percentage
+        CHECK (action <> 'set_rollout' OR rollout_percentage BETWEEN 0 AND 100)
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_flag_changes_feature_flag_id ON scheduled_flag_changes (feature_flag_id);
+CREATE INDEX IF NOT EXISTS idx_scheduled_flag_changes_created_by ON scheduled_flag_changes (created_by);
+
+-- The scheduler only ever looks for pending changes that are due
+CREATE INDEX IF NOT EXISTS idx_scheduled_flag_changes_pending_run_at
+    ON scheduled_flag_changes (run_at) WHERE status = 'pending';
@@ -0,0 +1,2 @@
+-- Flags a flag depends on: [{"flag_key": "...", "variant": "..."}]
+ALTER TABLE feature_flags ADD COLUMN IF NOT EXISTS prerequisites JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,36 @@
+-- Passkeys and security keys
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id               BIGSERIAL PRIMARY KEY,
+    user_id          BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name             VARCHAR(100) NOT NULL,
+    credential_id    BYTEA NOT NULL,
+    public_key       BYTEA NOT NULL,
+    attestation_type VARCHAR(32) NOT NULL DEFAULT '',
+    aaguid           BYTEA,
+    transports       VARCHAR(100) NOT NULL DEFAULT '',
+    sign_count       BIGINT NOT NULL DEFAULT 0,
+    backup_eligible  BOOLEAN NOT NULL DEFAULT FALSE,
+    backup_state     BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_at     TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_webauthn_credentials_credential_id ON webauthn_credentials (credential_id);
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials (user_id);
+
+-- Registrations and passkey logins waiting for the authenticator's answer;
+-- short-lived, like login challenges
+CREATE TABLE IF NOT EXISTS webauthn_ceremonies (
+    id           VARCHAR(64) PRIMARY KEY,
+    kind         VARCHAR(20) NOT NULL,
+    user_id      BIGINT REFERENCES users (id) ON DELETE CASCADE,
+    session_data JSONB NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    created_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_ceremonies_user_id ON webauthn_ceremonies (user_id);
+CREATE INDEX IF NOT EXISTS idx_webauthn_ceremonies_expires_at ON webauthn_ceremonies (expires_at);
+
+-- Security key challenge of a login's second step
+ALTER TABLE login_challenges ADD COLUMN IF NOT EXISTS webauthn_session JSONB;
@@ -0,0 +1,13 @@
+-- Emailed password reset tokens; only their hashes are stored
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_password_reset_tokens_token_hash ON password_reset_tokens (token_hash);
+-- Rate limiting counts a user's recent requests
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_id_created_at ON password_reset_tokens (user_id, created_at);
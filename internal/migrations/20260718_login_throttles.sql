@@ -0,0 +1,9 @@
+-- Failed login counters per email address and per client IP, for lockouts
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key             VARCHAR(320) PRIMARY KEY,
+    failures        INTEGER NOT NULL DEFAULT 0,
+    locked_until    TIMESTAMPTZ,
+    last_failure_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_throttles_last_failure_at ON login_throttles (last_failure_at);
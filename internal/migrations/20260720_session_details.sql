@@ -0,0 +1,8 @@
+-- Where and on what each session was created, and when it was last used, so
+-- users and admins can tell their sessions apart
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip_address VARCHAR(45) NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent VARCHAR(512) NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ;
+
+UPDATE sessions SET last_seen_at = created_at WHERE last_seen_at IS NULL;
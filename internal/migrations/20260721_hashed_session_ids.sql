@@ -0,0 +1,4 @@
+-- Sessions are stored under the SHA-256 of their token rather than the token
+-- itself, so reading the table (or a backup) doesn't give away live sessions.
+-- Clients keep presenting the same tokens.
+UPDATE sessions SET id = encode(sha256(convert_to(id, 'UTF8')), 'hex');
@@ -0,0 +1,74 @@
+-- Apps that sign their users in through the OpenID Connect provider
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id            BIGSERIAL PRIMARY KEY,
+    client_id     VARCHAR(64) NOT NULL,
+    name          VARCHAR(100) NOT NULL,
+    secret_hash   VARCHAR(64) NOT NULL DEFAULT '',
+    redirect_uris JSONB NOT NULL DEFAULT '[]',
+    created_at    TIMESTAMPTZ,
+    updated_at    TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_oauth_clients_client_id ON oauth_clients (client_id);
+
+-- Authorization codes waiting to be exchanged for tokens; only their hashes
+-- are stored
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+    id             BIGSERIAL PRIMARY KEY,
+    code_hash      VARCHAR(64) NOT NULL,
+    grant_id       VARCHAR(64) NOT NULL,
+    client_id      BIGINT NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    user_id        BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    redirect_uri   TEXT NOT NULL,
+    scope          VARCHAR(255) NOT NULL,
+    nonce          VARCHAR(255) NOT NULL DEFAULT '',
+    code_challenge VARCHAR(128) NOT NULL,
+    auth_time      TIMESTAMPTZ NOT NULL,
+    expires_at     TIMESTAMPTZ NOT NULL,
+    used_at        TIMESTAMPTZ,
+    created_at     TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_oauth_authorization_codes_code_hash ON oauth_authorization_codes (code_hash);
+CREATE INDEX IF NOT EXISTS idx_oauth_authorization_codes_client_id ON oauth_authorization_codes (client_id);
+CREATE INDEX IF NOT EXISTS idx_oauth_authorization_codes_user_id ON oauth_authorization_codes (user_id);
+CREATE INDEX IF NOT EXISTS idx_oauth_authorization_codes_expires_at ON oauth_authorization_codes (expires_at);
+
+-- Access and refresh tokens; only their hashes are stored
+CREATE TABLE IF NOT EXISTS oauth_tokens (
+    id         BIGSERIAL PRIMARY KEY,
+    token_hash VARCHAR(64) NOT NULL,
+    kind       VARCHAR(10) NOT NULL,
+    grant_id   VARCHAR(64) NOT NULL,
+    client_id  BIGINT NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    user_id    BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    scope      VARCHAR(255) NOT NULL,
+    auth_time  TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_oauth_tokens_token_hash ON oauth_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_oauth_tokens_grant_id ON oauth_tokens (grant_id);
+CREATE INDEX IF NOT EXISTS idx_oauth_tokens_client_id ON oauth_tokens (client_id);
+CREATE INDEX IF NOT EXISTS idx_oauth_tokens_user_id ON oauth_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_oauth_tokens_expires_at ON oauth_tokens (expires_at);
+
+-- Scopes each user allowed each client
+CREATE TABLE IF NOT EXISTS oauth_consents (
+    user_id    BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    client_id  BIGINT NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    scope      VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    PRIMARY KEY (user_id, client_id)
+);
+
+-- Keys ID tokens are signed with, rotated in the background
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id          VARCHAR(32) PRIMARY KEY,
+    algorithm   VARCHAR(10) NOT NULL,
+    private_key TEXT NOT NULL,
+    created_at  TIMESTAMPTZ
+);
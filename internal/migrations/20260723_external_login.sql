@@ -0,0 +1,28 @@
+-- Accounts at external identity providers users sign in with
+CREATE TABLE IF NOT EXISTS user_identities (
+    id            BIGSERIAL PRIMARY KEY,
+    user_id       BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider      VARCHAR(50) NOT NULL,
+    subject       VARCHAR(255) NOT NULL,
+    email         VARCHAR(255) NOT NULL DEFAULT '',
+    last_login_at TIMESTAMPTZ,
+    created_at    TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_identities_provider_subject ON user_identities (provider, subject);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+
+-- Logins at an external identity provider waiting for the user to come
+-- back; short-lived, like WebAuthn ceremonies. Only hashes of the state
+-- parameter are stored.
+CREATE TABLE IF NOT EXISTS external_login_states (
+    id            VARCHAR(64) PRIMARY KEY,
+    provider      VARCHAR(50) NOT NULL,
+    nonce         VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    return_to     TEXT NOT NULL DEFAULT '',
+    expires_at    TIMESTAMPTZ NOT NULL,
+    created_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_external_login_states_expires_at ON external_login_states (expires_at);
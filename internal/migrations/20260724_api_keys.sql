@@ -0,0 +1,22 @@
+-- Keys other services call the API with instead of a user session; only
+-- their hashes are stored
+CREATE TABLE IF NOT EXISTS api_keys (
+    id                 BIGSERIAL PRIMARY KEY,
+    name               VARCHAR(100) NOT NULL,
+    prefix             VARCHAR(16) NOT NULL,
+    key_hash           VARCHAR(64) NOT NULL,
+    scopes             JSONB NOT NULL DEFAULT '[]',
+    expires_at         TIMESTAMPTZ,
+    last_used_at       TIMESTAMPTZ,
+    revoked_at         TIMESTAMPTZ,
+    created_by_user_id BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    created_at         TIMESTAMPTZ,
+    updated_at         TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_key_hash ON api_keys (key_hash);
+
+-- Actions taken with an API key are attributed to the key
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS actor_api_key_id BIGINT REFERENCES api_keys (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_actor_api_key_id ON audit_logs (actor_api_key_id);
@@ -0,0 +1,3 @@
+-- Actions taken by a service identified by its client certificate are
+-- attributed to the service
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS actor_service TEXT;
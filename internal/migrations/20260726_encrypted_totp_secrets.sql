@@ -0,0 +1,8 @@
+-- TOTP secrets are stored encrypted (TOTP_ENCRYPTION_KEY), which doesn't fit
+-- in 64 characters. Secrets of enrolled users are encrypted at startup.
+ALTER TABLE users ALTER COLUMN totp_secret TYPE TEXT;
+ALTER TABLE login_challenges ALTER COLUMN enrollment_secret TYPE TEXT;
+
+-- Logins enrolling with a secret stored in the clear start over; challenges
+-- last minutes, so this only affects logins in progress during the upgrade
+DELETE FROM login_challenges WHERE enrollment_secret <> '';
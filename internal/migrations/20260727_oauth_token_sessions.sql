@@ -0,0 +1,7 @@
+-- Grants remember the session the user signed in to the client with, so
+-- ending that session revokes its tokens. Grants from before have none, so
+-- their refresh tokens are refused and apps sign the user in again.
+ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS session_id VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE oauth_tokens ADD COLUMN IF NOT EXISTS session_id VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_oauth_tokens_session_id ON oauth_tokens (session_id);
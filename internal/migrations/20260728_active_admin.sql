@@ -0,0 +1,6 @@
+-- 20260708_user_roles made the lowest user ID admin even when that account
+-- had been deleted, leaving nobody able to manage roles. Where no active
+-- admin is left, make the first active account admin instead.
+UPDATE users SET role = 'admin'
+WHERE id = (SELECT MIN(id) FROM users WHERE deleted_at IS NULL)
+  AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin' AND deleted_at IS NULL);
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// APIKey lets another service call the API without a user session, with
+// the permissions listed in Scopes. Only the SHA-256 hash of the key is
+// stored; Prefix, its first characters, identifies it in listings. Revoked
+// keys are kept so audit entries made with them still name them.
+type APIKey struct {
+	ID              uint                        `gorm:"primaryKey" json:"id"`
+	Name            string                      `gorm:"type:varchar(100);not null" json:"name"`
+	Prefix          string                      `gorm:"type:varchar(16);not null" json:"prefix"`
+	KeyHash         string                      `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	Scopes          datatypes.JSONSlice[string] `gorm:"type:jsonb;not null;default:'[]'" json:"scopes"`
+	ExpiresAt       *time.Time                  `json:"expires_at,omitempty"`
+	LastUsedAt      *time.Time                  `json:"last_used_at,omitempty"`
+	RevokedAt       *time.Time                  `json:"revoked_at,omitempty"`
+	CreatedByUserID *uint                       `json:"created_by_user_id,omitempty"`
+	CreatedAt       time.Time                   `json:"created_at"`
+	UpdatedAt       time.Time                   `json:"updated_at"`
+}
+
+// TableName specifies the table name for the APIKey model
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// IsActive reports whether the key is neither revoked nor expired
+func (k *APIKey) IsActive(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
@@ -6,19 +6,24 @@ import (
 	"gorm.io/datatypes"
 )
 
-// AuditLog represents an audit trail entry for auth and feature flag actions
+// AuditLog represents an audit trail entry for auth and feature flag actions.
+// Actions taken with an API key are attributed to the key rather than a user,
+// and those of a service identified by its client certificate to the service.
 type AuditLog struct {
-	ID          uint           `gorm:"primaryKey" json:"id"`
-	ActorUserID *uint          `gorm:"index" json:"actor_user_id,omitempty"`
-	Action      string         `gorm:"type:text;not null" json:"action"`
-	TargetType  string         `gorm:"type:text" json:"target_type,omitempty"`
-	TargetID    string         `gorm:"type:text" json:"target_id,omitempty"`
-	Details     datatypes.JSON `gorm:"type:jsonb" json:"details,omitempty"`
-	IP          string         `gorm:"type:text" json:"ip,omitempty"`
-	CreatedAt   time.Time      `json:"created_at"`
+	ID            uint           `gorm:"primaryKey" json:"id"`
+	ActorUserID   *uint          `gorm:"index" json:"actor_user_id,omitempty"`
+	ActorAPIKeyID *uint          `gorm:"index" json:"actor_api_key_id,omitempty"`
+	ActorService  string         `gorm:"type:text" json:"actor_service,omitempty"`
+	Action        string         `gorm:"type:text;not null" json:"action"`
+	TargetType    string         `gorm:"type:text" json:"target_type,omitempty"`
+	TargetID      string         `gorm:"type:text" json:"target_id,omitempty"`
+	Details       datatypes.JSON `gorm:"type:jsonb" json:"details,omitempty"`
+	IP            string         `gorm:"type:text" json:"ip,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
 
-	// Relationship
-	Actor *User `gorm:"foreignKey:ActorUserID" json:"actor,omitempty"`
+	// Relationships
+	Actor       *User   `gorm:"foreignKey:ActorUserID" json:"actor,omitempty"`
+	ActorAPIKey *APIKey `gorm:"foreignKey:ActorAPIKeyID" json:"actor_api_key,omitempty"`
 }
 
 // TableName specifies the table name for the AuditLog model
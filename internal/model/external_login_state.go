@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// ExternalLoginState is a login at an external identity provider in
+// progress, kept until the provider sends the user back. Its ID is the
+// SHA-256 in hex of the state parameter; Nonce and CodeVerifier check the
+// provider's answer, and ReturnTo is the authorization request the login
+// continues with, if any.
+type ExternalLoginState struct {
+	ID           string    `gorm:"primaryKey;type:varchar(64)" json:"-"`
+	Provider     string    `gorm:"type:varchar(50);not null" json:"provider"`
+	Nonce        string    `gorm:"type:varchar(64);not null" json:"-"`
+	CodeVerifier string    `gorm:"type:varchar(128);not null" json:"-"`
+	ReturnTo     string    `gorm:"type:text;not null;default:''" json:"return_to"`
+	ExpiresAt    time.Time `gorm:"not null" json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for the ExternalLoginState model
+func (ExternalLoginState) TableName() string {
+	return "external_login_states"
+}
+
+// IsExpired checks if the login has expired
+func (s *ExternalLoginState) IsExpired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
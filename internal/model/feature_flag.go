@@ -3,18 +3,35 @@ package model
 import (
 	"time"
 
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
-// FeatureFlag represents a feature flag in the system
+// FeatureFlag represents a feature flag in the system. Besides the global
+// Enabled switch and explicit per-user assignments, RolloutPercentage (0-100)
+// enables it for a deterministic share of users bucketed by flag key + user ID,
+// and Rules enable it for users matching attribute-based targeting rules.
+//
+// Multivariate flags carry Variants of VariantType; a user the flag is enabled
+// for is served a variant by weight (or the one pinned by their assignment or
+// matching rule), everyone else gets OffVariant.
+//
+// Prerequisites are flags that must be on (or serve a given variant) for the
+// same evaluation context before this flag is considered at all.
 type FeatureFlag struct {
-	ID          uint           `gorm:"primaryKey" json:"id"`
-	Key         string         `gorm:"type:varchar(255);uniqueIndex;not null" json:"key"`
-	Description string         `gorm:"type:text" json:"description"`
-	Enabled     bool           `gorm:"default:false;not null" json:"enabled"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	ID                uint                                  `gorm:"primaryKey" json:"id"`
+	Key               string                                `gorm:"type:varchar(255);uniqueIndex;not null" json:"key"`
+	Description       string                                `gorm:"type:text" json:"description"`
+	Enabled           bool                                  `gorm:"default:false;not null" json:"enabled"`
+	RolloutPercentage int                                   `gorm:"default:0;not null" json:"rollout_percentage"`
+	Rules             datatypes.JSONSlice[TargetingRule]    `gorm:"type:jsonb;not null;default:'[]'" json:"rules"`
+	VariantType       string                                `gorm:"type:varchar(16);default:boolean;not null" json:"variant_type"`
+	Variants          datatypes.JSONSlice[FlagVariant]      `gorm:"type:jsonb;not null;default:'[]'" json:"variants"`
+	OffVariant        string                                `gorm:"type:varchar(255);default:'';not null" json:"off_variant"`
+	Prerequisites     datatypes.JSONSlice[FlagPrerequisite] `gorm:"type:jsonb;not null;default:'[]'" json:"prerequisites"`
+	CreatedAt         time.Time                             `json:"created_at"`
+	UpdatedAt         time.Time                             `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt                        `gorm:"index" json:"deleted_at,omitempty"`
 
 	// Many-to-many relationship with Users
 	Users []User `gorm:"many2many:user_feature_flags;" json:"users,omitempty"`
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+)
+
+// Flag change kinds
+const (
+	FlagChangeFlag       = "flag"
+	FlagChangeAssignment = "assignment"
+)
+
+// FlagChange is an entry of the flag change log: a flag was created, updated
+// or deleted, or a user's assignment to a flag changed. Its ID orders changes
+// across replicas and doubles as the flag stream's event ID.
+type FlagChange struct {
+	ID        uint64    `gorm:"primaryKey" json:"id"`
+	Kind      string    `gorm:"type:varchar(16);not null" json:"kind"`
+	FlagKey   string    `gorm:"type:varchar(255);not null" json:"flag_key"`
+	UserID    *uint     `json:"user_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for the FlagChange model
+func (FlagChange) TableName() string {
+	return "flag_changes"
+}
@@ -0,0 +1,7 @@
+package model
+
+import "identity/pkg/api"
+
+// FlagPrerequisite makes a flag depend on another one, stored in the same
+// form the API and the flag evaluation use
+type FlagPrerequisite = api.FlagPrerequisite
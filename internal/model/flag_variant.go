@@ -0,0 +1,7 @@
+package model
+
+import "identity/pkg/api"
+
+// FlagVariant is one possible value of a multivariate flag, stored in the
+// same form the API and the flag evaluation use
+type FlagVariant = api.FlagVariant
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// LoginChallenge is a login halfway through: the password checked out, but
+// the user must still enter a two-factor code. Its ID is the challenge token
+// handed to the client, exchanged for a session along with a valid code.
+// EnrollmentSecret holds the TOTP secret of a user enrolling as part of the
+// login, until the first code confirms it. WebAuthnSession holds the
+// security key challenge of a user signing in with one instead of a code.
+type LoginChallenge struct {
+	ID               string         `gorm:"primaryKey;type:varchar(64)" json:"-"`
+	UserID           uint           `gorm:"index;not null" json:"user_id"`
+	EnrollmentSecret string         `gorm:"type:text" json:"-"`
+	WebAuthnSession  datatypes.JSON `gorm:"type:jsonb" json:"-"`
+	Attempts         int            `gorm:"default:0;not null" json:"attempts"`
+	ExpiresAt        time.Time      `gorm:"not null" json:"expires_at"`
+	CreatedAt        time.Time      `json:"created_at"`
+
+	// Relationship
+	User User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
+// TableName specifies the table name for the LoginChallenge model
+func (LoginChallenge) TableName() string {
+	return "login_challenges"
+}
+
+// IsExpired checks if the challenge has expired
+func (c *LoginChallenge) IsExpired() bool {
+	return time.Now().After(c.ExpiresAt)
+}
@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// LoginThrottle counts recent failed logins for one key: an email address
+// ("email:…", whether or not it has an account) or a client IP ("ip:…").
+// Past a threshold the key is locked until LockedUntil.
+type LoginThrottle struct {
+	Key           string     `gorm:"primaryKey;type:varchar(320)" json:"key"`
+	Failures      int        `gorm:"default:0;not null" json:"failures"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+	LastFailureAt time.Time  `gorm:"not null" json:"last_failure_at"`
+}
+
+// TableName specifies the table name for the LoginThrottle model
+func (LoginThrottle) TableName() string {
+	return "login_throttles"
+}
+
+// IsLocked reports whether the key is locked at the given time
+func (t *LoginThrottle) IsLocked(at time.Time) bool {
+	return t.LockedUntil != nil && at.Before(*t.LockedUntil)
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// OAuthAuthorizationCode is handed to a client through the user's browser
+// once the user signed in and consented, and exchanged by the client for
+// tokens. Only its SHA-256 hash is stored. It works once, before ExpiresAt,
+// and only with the verifier of CodeChallenge (PKCE). The tokens it is
+// exchanged for share its GrantID and SessionID, the session the user
+// signed in with.
+type OAuthAuthorizationCode struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	CodeHash      string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	GrantID       string     `gorm:"type:varchar(64);not null" json:"grant_id"`
+	ClientID      uint       `gorm:"index;not null" json:"client_id"`
+	UserID        uint       `gorm:"index;not null" json:"user_id"`
+	SessionID     string     `gorm:"type:varchar(64);not null;default:''" json:"-"`
+	RedirectURI   string     `gorm:"type:text;not null" json:"redirect_uri"`
+	Scope         string     `gorm:"type:varchar(255);not null" json:"scope"`
+	Nonce         string     `gorm:"type:varchar(255);not null;default:''" json:"-"`
+	CodeChallenge string     `gorm:"type:varchar(128);not null" json:"-"`
+	AuthTime      time.Time  `gorm:"not null" json:"auth_time"`
+	ExpiresAt     time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt        *time.Time `json:"used_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// TableName specifies the table name for the OAuthAuthorizationCode model
+func (OAuthAuthorizationCode) TableName() string {
+	return "oauth_authorization_codes"
+}
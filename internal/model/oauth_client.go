@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// OAuthClient is an app that signs its users in through the OpenID Connect
+// provider. Confidential clients authenticate with a secret, of which only
+// the SHA-256 hash is stored; public clients (single-page apps, CLIs) have
+// none and rely on PKCE alone. Authorization codes are only sent to one of
+// the registered RedirectURIs.
+type OAuthClient struct {
+	ID           uint                        `gorm:"primaryKey" json:"id"`
+	ClientID     string                      `gorm:"type:varchar(64);uniqueIndex;not null" json:"client_id"`
+	Name         string                      `gorm:"type:varchar(100);not null" json:"name"`
+	SecretHash   string                      `gorm:"type:varchar(64);not null;default:''" json:"-"`
+	RedirectURIs datatypes.JSONSlice[string] `gorm:"type:jsonb;not null;default:'[]'" json:"redirect_uris"`
+	CreatedAt    time.Time                   `json:"created_at"`
+	UpdatedAt    time.Time                   `json:"updated_at"`
+}
+
+// TableName specifies the table name for the OAuthClient model
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+// IsPublic reports whether the client has no secret
+func (c *OAuthClient) IsPublic() bool {
+	return c.SecretHash == ""
+}
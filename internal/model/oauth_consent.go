@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// OAuthConsent remembers the scopes a user allowed a client, so signing in
+// to it again doesn't ask again unless it wants more
+type OAuthConsent struct {
+	UserID    uint      `gorm:"primaryKey" json:"user_id"`
+	ClientID  uint      `gorm:"primaryKey" json:"client_id"`
+	Scope     string    `gorm:"type:varchar(255);not null" json:"scope"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for the OAuthConsent model
+func (OAuthConsent) TableName() string {
+	return "oauth_consents"
+}
@@ -0,0 +1,44 @@
+package model
+
+import "time"
+
+// OAuthTokenKind tells access tokens from refresh tokens
+type OAuthTokenKind string
+
+const (
+	// OAuthAccessToken is presented to the userinfo endpoint
+	OAuthAccessToken OAuthTokenKind = "access"
+	// OAuthRefreshToken is exchanged for new tokens, once
+	OAuthRefreshToken OAuthTokenKind = "refresh"
+)
+
+// OAuthToken is an access or refresh token issued to an OAuth client. Only
+// its SHA-256 hash is stored. The tokens of one grant, issued for one
+// authorization code and the refreshes that followed, share a GrantID so
+// they can be revoked together. AuthTime is when the user signed in for the
+// grant, and SessionID the session they signed in with; ending it revokes
+// the grant.
+type OAuthToken struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	TokenHash string         `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	Kind      OAuthTokenKind `gorm:"type:varchar(10);not null" json:"kind"`
+	GrantID   string         `gorm:"type:varchar(64);index;not null" json:"grant_id"`
+	ClientID  uint           `gorm:"index;not null" json:"client_id"`
+	UserID    uint           `gorm:"index;not null" json:"user_id"`
+	SessionID string         `gorm:"type:varchar(64);index;not null;default:''" json:"-"`
+	Scope     string         `gorm:"type:varchar(255);not null" json:"scope"`
+	AuthTime  time.Time      `gorm:"not null" json:"auth_time"`
+	ExpiresAt time.Time      `gorm:"not null" json:"expires_at"`
+	RevokedAt *time.Time     `json:"revoked_at,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// TableName specifies the table name for the OAuthToken model
+func (OAuthToken) TableName() string {
+	return "oauth_tokens"
+}
+
+// IsActive reports whether the token is neither revoked nor expired
+func (t *OAuthToken) IsActive() bool {
+	return t.RevokedAt == nil && time.Now().Before(t.ExpiresAt)
+}
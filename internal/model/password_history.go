@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// PasswordHistory is a password a user had before, kept as its hash
+// so the password policy can refuse reusing it
+type PasswordHistory struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"index;not null" json:"user_id"`
+	PasswordHash string    `gorm:"not null" json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for the PasswordHistory model
+func (PasswordHistory) TableName() string {
+	return "password_history"
+}
@@ -0,0 +1,30 @@
+package model
+
+// Role is a user's access level. Roles are coarse; what each role may do is
+// defined by the permission matrix in the service layer.
+type Role string
+
+const (
+	// RoleAdmin can manage users, roles, sessions and feature flags
+	RoleAdmin Role = "admin"
+	// RoleFlagEditor can manage feature flags and per-user flag assignments
+	RoleFlagEditor Role = "flag-editor"
+	// RoleViewer has read-only access to the admin UI and management API
+	RoleViewer Role = "viewer"
+	// RoleUser is an end user (e.g. logging in through the BFF) with no
+	// access to the management API or admin UI
+	RoleUser Role = "user"
+)
+
+// Roles lists all roles, most privileged first
+var Roles = []Role{RoleAdmin, RoleFlagEditor, RoleViewer, RoleUser}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	for _, role := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
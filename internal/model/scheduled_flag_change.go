@@ -0,0 +1,46 @@
+package model
+
+import (
+	"time"
+)
+
+// Scheduled flag change actions
+const (
+	ScheduledFlagEnable     = "enable"
+	ScheduledFlagDisable    = "disable"
+	ScheduledFlagSetRollout = "set_rollout"
+)
+
+// Scheduled flag change statuses
+const (
+	ScheduledFlagPending   = "pending"
+	ScheduledFlagDone      = "done"
+	ScheduledFlagCancelled = "cancelled"
+	ScheduledFlagFailed    = "failed"
+)
+
+// ScheduledFlagChange is a change to a flag (enable, disable or set its
+// rollout percentage) to be applied by the flag scheduler once RunAt has
+// passed. Executed and cancelled changes are kept as history.
+type ScheduledFlagChange struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	FeatureFlagID     uint       `gorm:"not null;index" json:"feature_flag_id"`
+	Action            string     `gorm:"type:varchar(16);not null" json:"action"`
+	RolloutPercentage *int       `json:"rollout_percentage,omitempty"`
+	RunAt             time.Time  `gorm:"not null" json:"run_at"`
+	Status            string     `gorm:"type:varchar(16);default:pending;not null" json:"status"`
+	CreatedBy         *uint      `gorm:"index" json:"created_by,omitempty"`
+	ExecutedAt        *time.Time `json:"executed_at,omitempty"`
+	Error             string     `gorm:"type:text;default:'';not null" json:"error,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+
+	// Relationships
+	FeatureFlag *FeatureFlag `gorm:"foreignKey:FeatureFlagID" json:"feature_flag,omitempty"`
+	Creator     *User        `gorm:"foreignKey:CreatedBy" json:"creator,omitempty"`
+}
+
+// TableName specifies the table name for the ScheduledFlagChange model
+func (ScheduledFlagChange) TableName() string {
+	return "scheduled_flag_changes"
+}
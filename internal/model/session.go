@@ -8,11 +8,19 @@ import (
 
 // Session represents a user login session
 type Session struct {
-	ID        string         `gorm:"primaryKey;type:varchar(64)" json:"id"`
-	UserID    uint           `gorm:"index;not null" json:"user_id"`
-	ExpiresAt time.Time      `gorm:"not null" json:"expires_at"`
-	CreatedAt time.Time      `json:"created_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	// ID is the SHA-256 of the session token in hex; the token itself is
+	// only ever held by the client
+	ID        string    `gorm:"primaryKey;type:varchar(64)" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+	// IPAddress and UserAgent are the client's when the session was created;
+	// Device is a readable label derived from the user agent
+	IPAddress  string         `gorm:"type:varchar(45);not null;default:''" json:"ip_address"`
+	UserAgent  string         `gorm:"type:varchar(512);not null;default:''" json:"user_agent"`
+	Device     string         `gorm:"type:varchar(100);not null;default:''" json:"device"`
+	LastSeenAt *time.Time     `json:"last_seen_at,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
 
 	// Relationship
 	User User `gorm:"foreignKey:UserID" json:"user,omitempty"`
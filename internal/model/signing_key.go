@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// SigningKey is a private key tokens are signed with; its ID is the "kid"
+// in their header. Keys rotate: each one is published in the JWKS before it
+// starts signing and stays published for a while after the next one takes
+// over, so the tokens it signed can still be verified.
+type SigningKey struct {
+	ID        string `gorm:"primaryKey;type:varchar(32)" json:"id"`
+	Algorithm string `gorm:"type:varchar(10);not null" json:"algorithm"`
+	// PrivateKey is PKCS #8, PEM encoded and encrypted with the secrets key
+	PrivateKey string    `gorm:"type:text;not null" json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for the SigningKey model
+func (SigningKey) TableName() string {
+	return "signing_keys"
+}
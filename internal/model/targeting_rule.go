@@ -0,0 +1,10 @@
+package model
+
+import "identity/pkg/api"
+
+// TargetingCondition and TargetingRule are stored in the same form the API
+// and the flag evaluation use
+type (
+	TargetingCondition = api.TargetingCondition
+	TargetingRule      = api.TargetingRule
+)
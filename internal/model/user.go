@@ -13,11 +13,19 @@ type User struct {
 	Email        string         `gorm:"type:varchar(255);uniqueIndex;not null" json:"email"`
 	PasswordHash string         `gorm:"type:varchar(255)" json:"-"`
 	Enabled      bool           `gorm:"default:true;not null" json:"enabled"`
+	Role         Role           `gorm:"type:varchar(32);default:user;not null" json:"role"`
 	CreatedAt    time.Time      `json:"created_at"`
 	UpdatedAt    time.Time      `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
 	LastLogin    *time.Time     `json:"last_login,omitempty"`
 
+	// TOTP two-factor authentication. TOTPSecret is pending until a first
+	// code confirms the enrollment and sets TOTPEnabled; TOTPLastStep is the
+	// time step of the last accepted code, so a code can't be used twice.
+	TOTPSecret   string `gorm:"type:text" json:"-"`
+	TOTPEnabled  bool   `gorm:"default:false;not null" json:"totp_enabled"`
+	TOTPLastStep int64  `gorm:"default:0;not null" json:"-"`
+
 	// Many-to-many relationship with FeatureFlags
 	FeatureFlags []FeatureFlag `gorm:"many2many:user_feature_flags;" json:"feature_flags,omitempty"`
 }
@@ -4,10 +4,12 @@ import (
 	"time"
 )
 
-// UserFeatureFlag represents the many-to-many relationship between users and feature flags
+// UserFeatureFlag represents the many-to-many relationship between users and feature flags.
+// Variant pins the variant served to the user; empty means the flag's weighted distribution.
 type UserFeatureFlag struct {
 	UserID        uint      `gorm:"primaryKey" json:"user_id"`
 	FeatureFlagID uint      `gorm:"primaryKey" json:"feature_flag_id"`
+	Variant       string    `gorm:"type:varchar(255);default:'';not null" json:"variant"`
 	CreatedAt     time.Time `json:"created_at"`
 
 	// Foreign key relationships
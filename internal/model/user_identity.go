@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// UserIdentity links a user to their account at an external identity
+// provider, which signs them in. Subject is the provider's ID for the
+// account; Email is the one it last reported.
+type UserIdentity struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"index;not null" json:"user_id"`
+	Provider    string     `gorm:"type:varchar(50);not null" json:"provider"`
+	Subject     string     `gorm:"type:varchar(255);not null" json:"subject"`
+	Email       string     `gorm:"type:varchar(255);not null;default:''" json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// TableName specifies the table name for the UserIdentity model
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
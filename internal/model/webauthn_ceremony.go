@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// WebAuthnCeremony is a registration or passkey login in progress: the
+// challenge handed to the browser, kept until the authenticator's answer
+// comes back. Its ID is the ceremony token given to the client. UserID is
+// nil for passkey logins, where the user is only known from the answer.
+type WebAuthnCeremony struct {
+	ID          string         `gorm:"primaryKey;type:varchar(64)" json:"-"`
+	Kind        string         `gorm:"type:varchar(20);not null" json:"kind"`
+	UserID      *uint          `gorm:"index" json:"user_id,omitempty"`
+	SessionData datatypes.JSON `gorm:"type:jsonb;not null" json:"-"`
+	ExpiresAt   time.Time      `gorm:"not null" json:"expires_at"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+// WebAuthn ceremony kinds
+const (
+	WebAuthnCeremonyRegistration = "registration"
+	WebAuthnCeremonyLogin        = "login"
+)
+
+// TableName specifies the table name for the WebAuthnCeremony model
+func (WebAuthnCeremony) TableName() string {
+	return "webauthn_ceremonies"
+}
+
+// IsExpired checks if the ceremony has expired
+func (c *WebAuthnCeremony) IsExpired() bool {
+	return time.Now().After(c.ExpiresAt)
+}
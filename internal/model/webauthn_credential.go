@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// WebAuthnCredential is a passkey or security key registered by a user. It
+// signs them in on its own (passkeys, which verify the user themselves) or
+// serves as their second factor after the password. CredentialID and
+// PublicKey are the raw bytes the authenticator produced at registration.
+type WebAuthnCredential struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"index;not null" json:"user_id"`
+	Name            string     `gorm:"type:varchar(100);not null" json:"name"`
+	CredentialID    []byte     `gorm:"type:bytea;uniqueIndex;not null" json:"-"`
+	PublicKey       []byte     `gorm:"type:bytea;not null" json:"-"`
+	AttestationType string     `gorm:"type:varchar(32)" json:"-"`
+	AAGUID          []byte     `gorm:"type:bytea" json:"-"`
+	Transports      string     `gorm:"type:varchar(100)" json:"transports"`
+	SignCount       uint32     `gorm:"not null;default:0" json:"-"`
+	BackupEligible  bool       `gorm:"not null;default:false" json:"backup_eligible"`
+	BackupState     bool       `gorm:"not null;default:false" json:"backup_state"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// TableName specifies the table name for the WebAuthnCredential model
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
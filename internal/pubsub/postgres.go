@@ -0,0 +1,61 @@
+package pubsub
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Reconnect backoff for Listen
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Listen holds a dedicated connection LISTENing on channel and calls handle
+// with every notification's payload until ctx is cancelled. After an error it
+// reconnects with backoff. onConnect runs after every successful (re)connect,
+// so callers can resync anything published while they weren't listening.
+func Listen(ctx context.Context, dsn, channel string, onConnect func(), handle func(payload string), logger *slog.Logger) {
+	backoff := minBackoff
+	for {
+		err := listen(ctx, dsn, channel, func() {
+			backoff = minBackoff
+			onConnect()
+		}, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Error("notification listener disconnected", "channel", channel, "error", err, "retry_in", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func listen(ctx context.Context, dsn, channel string, onConnect func(), handle func(payload string)) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+	onConnect()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		handle(notification.Payload)
+	}
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Postgres advisory lock keys, kept together so they stay distinct
+const (
+	// featureFlagLockKey is held while creating, updating or deleting a flag
+	featureFlagLockKey int64 = 0x666c6167_73657421 // "flagset!"
+	// flagChangeLogLockKey is held while appending to the flag change log
+	flagChangeLogLockKey int64 = 0x666c6167_6c6f6721 // "flaglog!"
+	// sessionReaperLockKey is held while purging dead sessions, so only one
+	// replica purges at a time
+	sessionReaperLockKey int64 = 0x73657373_72656170 // "sessreap"
+	// signingKeyLockKey is held while rotating signing keys, so replicas
+	// don't each add a key
+	signingKeyLockKey int64 = 0x7369676b_65797321 // "sigkeys!"
+	// passwordResetLockKey plus a user ID is held while issuing that user a
+	// password reset token, so replicas can't together exceed the limit
+	passwordResetLockKey int64 = 0x70777273_00000000 // "pwrs" + user ID
+)
+
+// withAdvisoryLock runs fn in a transaction that first waits for the
+// advisory lock key, so transactions with the same key take turns. The lock
+// is transaction-scoped, so it is released on commit, on rollback, or if the
+// connection dies.
+func withAdvisoryLock(ctx context.Context, db *gorm.DB, key int64, fn func(tx *gorm.DB) error) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", key).Error; err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// runExclusive runs fn while holding the advisory lock key, reporting false
+// without running it if another replica holds the lock. Like
+// withAdvisoryLock, the lock is transaction-scoped.
+func runExclusive(ctx context.Context, db *gorm.DB, key int64, fn func(ctx context.Context) error) (bool, error) {
+	ran := false
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", key).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+		ran = true
+		return fn(ctx)
+	})
+	return ran, err
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKeyRepository defines the interface for API key data operations
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *model.APIKey) error
+	GetByID(ctx context.Context, id uint) (*model.APIKey, error)
+	GetByHash(ctx context.Context, keyHash string) (*model.APIKey, error)
+	GetAll(ctx context.Context) ([]model.APIKey, error)
+	Update(ctx context.Context, key *model.APIKey) error
+	UpdateLastUsedAt(ctx context.Context, id uint, lastUsedAt time.Time) error
+}
+
+// apiKeyRepository implements APIKeyRepository
+type apiKeyRepository struct {
+	db *gorm.DB
+}
+
+// NewAPIKeyRepository creates a new API key repository
+func NewAPIKeyRepository(db *gorm.DB) APIKeyRepository {
+	return &apiKeyRepository{db: db}
+}
+
+// Create creates a new API key
+func (r *apiKeyRepository) Create(ctx context.Context, key *model.APIKey) error {
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+// GetByID retrieves an API key by ID
+func (r *apiKeyRepository) GetByID(ctx context.Context, id uint) (*model.APIKey, error) {
+	var key model.APIKey
+	if err := r.db.WithContext(ctx).First(&key, id).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetByHash retrieves an API key by the hash of the key
+func (r *apiKeyRepository) GetByHash(ctx context.Context, keyHash string) (*model.APIKey, error) {
+	var key model.APIKey
+	err := r.db.WithContext(ctx).
+		Where("key_hash = ?", keyHash).
+		First(&key).Error
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAll retrieves all API keys, revoked ones included, newest first
+func (r *apiKeyRepository) GetAll(ctx context.Context) ([]model.APIKey, error) {
+	var keys []model.APIKey
+	err := r.db.WithContext(ctx).
+		Order("created_at DESC, id DESC").
+		Find(&keys).Error
+	return keys, err
+}
+
+// Update updates an API key
+func (r *apiKeyRepository) Update(ctx context.Context, key *model.APIKey) error {
+	return r.db.WithContext(ctx).Save(key).Error
+}
+
+// UpdateLastUsedAt records when an API key was last used
+func (r *apiKeyRepository) UpdateLastUsedAt(ctx context.Context, id uint, lastUsedAt time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&model.APIKey{}).
+		Where("id = ?", id).
+		Update("last_used_at", lastUsedAt).Error
+}
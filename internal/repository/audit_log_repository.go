@@ -39,6 +39,7 @@ func (r *auditLogRepository) GetAll(ctx context.Context, limit, offset int) ([]m
 
 	err := r.db.WithContext(ctx).
 		Preload("Actor").
+		Preload("ActorAPIKey").
 		Order("created_at DESC").
 		Limit(limit).
 		Offset(offset).
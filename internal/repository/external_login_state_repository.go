@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ExternalLoginStateRepository defines the interface for external login
+// state data operations
+type ExternalLoginStateRepository interface {
+	Create(ctx context.Context, state *model.ExternalLoginState) error
+	Take(ctx context.Context, id string) (*model.ExternalLoginState, error)
+}
+
+// externalLoginStateRepository implements ExternalLoginStateRepository
+type externalLoginStateRepository struct {
+	db *gorm.DB
+}
+
+// NewExternalLoginStateRepository creates a new external login state
+// repository
+func NewExternalLoginStateRepository(db *gorm.DB) ExternalLoginStateRepository {
+	return &externalLoginStateRepository{db: db}
+}
+
+// Create creates a new external login state
+func (r *externalLoginStateRepository) Create(ctx context.Context, state *model.ExternalLoginState) error {
+	return r.db.WithContext(ctx).Create(state).Error
+}
+
+// Take deletes a login state and returns it, so each login is completed at
+// most once; gorm.ErrRecordNotFound if it doesn't exist (any more)
+func (r *externalLoginStateRepository) Take(ctx context.Context, id string) (*model.ExternalLoginState, error) {
+	var states []model.ExternalLoginState
+	err := r.db.WithContext(ctx).
+		Clauses(clause.Returning{}).
+		Where("id = ?", id).
+		Delete(&states).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(states) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &states[0], nil
+}
@@ -13,8 +13,11 @@ type FeatureFlagRepository interface {
 	GetByID(ctx context.Context, id uint) (*model.FeatureFlag, error)
 	GetByKey(ctx context.Context, key string) (*model.FeatureFlag, error)
 	GetAll(ctx context.Context, limit, offset int) ([]model.FeatureFlag, int64, error)
+	GetByKeys(ctx context.Context, keys []string) ([]model.FeatureFlag, error)
+	GetWithPrerequisites(ctx context.Context) ([]model.FeatureFlag, error)
 	Update(ctx context.Context, flag *model.FeatureFlag) error
 	Delete(ctx context.Context, id uint) error
+	RunLocked(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
 // featureFlagRepository implements FeatureFlagRepository
@@ -29,13 +32,13 @@ func NewFeatureFlagRepository(db *gorm.DB) FeatureFlagRepository {
 
 // Create creates a new feature flag
 func (r *featureFlagRepository) Create(ctx context.Context, flag *model.FeatureFlag) error {
-	return r.db.WithContext(ctx).Create(flag).Error
+	return r.conn(ctx).Create(flag).Error
 }
 
 // GetByID retrieves a feature flag by ID
 func (r *featureFlagRepository) GetByID(ctx context.Context, id uint) (*model.FeatureFlag, error) {
 	var flag model.FeatureFlag
-	err := r.db.WithContext(ctx).First(&flag, id).Error
+	err := r.conn(ctx).First(&flag, id).Error
 	if err != nil {
 		return nil, err
 	}
@@ -45,7 +48,7 @@ func (r *featureFlagRepository) GetByID(ctx context.Context, id uint) (*model.Fe
 // GetByKey retrieves a feature flag by key
 func (r *featureFlagRepository) GetByKey(ctx context.Context, key string) (*model.FeatureFlag, error) {
 	var flag model.FeatureFlag
-	err := r.db.WithContext(ctx).
+	err := r.conn(ctx).
 		Where("key = ?", key).
 		First(&flag).Error
 	if err != nil {
@@ -60,12 +63,12 @@ func (r *featureFlagRepository) GetAll(ctx context.Context, limit, offset int) (
 	var total int64
 
 	// Count total records
-	if err := r.db.WithContext(ctx).Model(&model.FeatureFlag{}).Count(&total).Error; err != nil {
+	if err := r.conn(ctx).Model(&model.FeatureFlag{}).Count(&total).Error; err != nil {
 		return nil, 0, err
 	}
 
 	// Get paginated records
-	err := r.db.WithContext(ctx).
+	err := r.conn(ctx).
 		Limit(limit).
 		Offset(offset).
 		Find(&flags).Error
@@ -73,12 +76,59 @@ func (r *featureFlagRepository) GetAll(ctx context.Context, limit, offset int) (
 	return flags, total, err
 }
 
+// GetByKeys retrieves the feature flags with the given keys, or every
+// feature flag when keys is empty
+func (r *featureFlagRepository) GetByKeys(ctx context.Context, keys []string) ([]model.FeatureFlag, error) {
+	var flags []model.FeatureFlag
+	query := r.conn(ctx)
+	if len(keys) > 0 {
+		query = query.Where("key IN ?", keys)
+	}
+	err := query.Find(&flags).Error
+	return flags, err
+}
+
+// GetWithPrerequisites retrieves the flags that have prerequisites, with
+// only their key and prerequisites loaded: the edges of the dependency graph
+func (r *featureFlagRepository) GetWithPrerequisites(ctx context.Context) ([]model.FeatureFlag, error) {
+	var flags []model.FeatureFlag
+	err := r.conn(ctx).
+		Select("key", "prerequisites").
+		Where("prerequisites <> '[]'::jsonb").
+		Find(&flags).Error
+	return flags, err
+}
+
 // Update updates a feature flag
 func (r *featureFlagRepository) Update(ctx context.Context, flag *model.FeatureFlag) error {
-	return r.db.WithContext(ctx).Save(flag).Error
+	return r.conn(ctx).Save(flag).Error
 }
 
 // Delete soft deletes a feature flag
 func (r *featureFlagRepository) Delete(ctx context.Context, id uint) error {
-	return r.db.WithContext(ctx).Delete(&model.FeatureFlag{}, id).Error
+	return r.conn(ctx).Delete(&model.FeatureFlag{}, id).Error
+}
+
+// RunLocked runs fn while holding the flag advisory lock, waiting for it if
+// another replica holds it. Writes that check a flag against the flags
+// depending on it or it depends on take turns this way, so two of them
+// can't each pass their check and together break the graph. The repository
+// methods fn calls with its ctx run in the transaction holding the lock, so
+// the check and the write are committed together, or not at all.
+func (r *featureFlagRepository) RunLocked(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withAdvisoryLock(ctx, r.db, featureFlagLockKey, func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, lockedTxKey{}, tx))
+	})
+}
+
+// lockedTxKey is the context key RunLocked passes its transaction under
+type lockedTxKey struct{}
+
+// conn returns the database to query for ctx: the transaction of RunLocked
+// when called within it
+func (r *featureFlagRepository) conn(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(lockedTxKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return r.db.WithContext(ctx)
 }
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FlagChangeRepository defines the interface for flag change log operations
+type FlagChangeRepository interface {
+	Create(ctx context.Context, change *model.FlagChange) error
+	ListSince(ctx context.Context, afterID uint64, limit int) ([]model.FlagChange, error)
+	Bounds(ctx context.Context) (oldest, latest uint64, err error)
+	DeleteBefore(ctx context.Context, before time.Time) error
+}
+
+// flagChangeRepository implements FlagChangeRepository
+type flagChangeRepository struct {
+	db *gorm.DB
+}
+
+// NewFlagChangeRepository creates a new flag change repository
+func NewFlagChangeRepository(db *gorm.DB) FlagChangeRepository {
+	return &flagChangeRepository{db: db}
+}
+
+// Create appends a change to the log. Appends take turns, holding an
+// advisory lock until they commit, so IDs are handed out in commit order:
+// once a change is visible, every change with a lower ID is too, and
+// readers paging by ID can't skip one that was still committing.
+func (r *flagChangeRepository) Create(ctx context.Context, change *model.FlagChange) error {
+	return withAdvisoryLock(ctx, r.db, flagChangeLogLockKey, func(tx *gorm.DB) error {
+		return tx.Create(change).Error
+	})
+}
+
+// ListSince retrieves up to limit changes with an ID above afterID, oldest
+// first. IDs follow commit order (see Create), so afterID is a safe cursor.
+func (r *flagChangeRepository) ListSince(ctx context.Context, afterID uint64, limit int) ([]model.FlagChange, error) {
+	var changes []model.FlagChange
+	err := r.db.WithContext(ctx).
+		Where("id > ?", afterID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&changes).Error
+	return changes, err
+}
+
+// Bounds returns the lowest and highest change IDs in the log (0, 0 when empty)
+func (r *flagChangeRepository) Bounds(ctx context.Context) (oldest, latest uint64, err error) {
+	var bounds struct {
+		Oldest uint64
+		Latest uint64
+	}
+	err = r.db.WithContext(ctx).
+		Model(&model.FlagChange{}).
+		Select("COALESCE(MIN(id), 0) AS oldest, COALESCE(MAX(id), 0) AS latest").
+		Scan(&bounds).Error
+	return bounds.Oldest, bounds.Latest, err
+}
+
+// DeleteBefore prunes changes created before the given time, always keeping
+// the latest one so the log's high-water mark survives
+func (r *flagChangeRepository) DeleteBefore(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).
+		Where("created_at < ? AND id < (SELECT MAX(id) FROM flag_changes)", before).
+		Delete(&model.FlagChange{}).Error
+}
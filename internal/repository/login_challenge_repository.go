@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// LoginChallengeRepository defines the interface for login challenge data
+// operations
+type LoginChallengeRepository interface {
+	Create(ctx context.Context, challenge *model.LoginChallenge) error
+	GetByID(ctx context.Context, id string) (*model.LoginChallenge, error)
+	SetEnrollmentSecret(ctx context.Context, id, secret string) error
+	SetWebAuthnSession(ctx context.Context, id string, session []byte) error
+	RecordAttempt(ctx context.Context, id string, maxAttempts int) (bool, error)
+	Consume(ctx context.Context, id string) (bool, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// loginChallengeRepository implements LoginChallengeRepository
+type loginChallengeRepository struct {
+	db *gorm.DB
+}
+
+// NewLoginChallengeRepository creates a new login challenge repository
+func NewLoginChallengeRepository(db *gorm.DB) LoginChallengeRepository {
+	return &loginChallengeRepository{db: db}
+}
+
+// Create creates a new login challenge
+func (r *loginChallengeRepository) Create(ctx context.Context, challenge *model.LoginChallenge) error {
+	return r.db.WithContext(ctx).Create(challenge).Error
+}
+
+// GetByID retrieves a login challenge by ID, with its user
+func (r *loginChallengeRepository) GetByID(ctx context.Context, id string) (*model.LoginChallenge, error) {
+	var challenge model.LoginChallenge
+	err := r.db.WithContext(ctx).
+		Preload("User").
+		Where("id = ?", id).
+		First(&challenge).Error
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// SetEnrollmentSecret stores the TOTP secret a user is enrolling with
+func (r *loginChallengeRepository) SetEnrollmentSecret(ctx context.Context, id, secret string) error {
+	return r.db.WithContext(ctx).
+		Model(&model.LoginChallenge{}).
+		Where("id = ?", id).
+		Update("enrollment_secret", secret).Error
+}
+
+// SetWebAuthnSession stores the security key challenge of a login
+func (r *loginChallengeRepository) SetWebAuthnSession(ctx context.Context, id string, session []byte) error {
+	return r.db.WithContext(ctx).
+		Model(&model.LoginChallenge{}).
+		Where("id = ?", id).
+		Update("webauthn_session", datatypes.JSON(session)).Error
+}
+
+// RecordAttempt counts a code entered against a challenge, reporting false
+// once maxAttempts have been used up. The check and the increment are one
+// statement, so parallel guesses can't exceed the limit.
+func (r *loginChallengeRepository) RecordAttempt(ctx context.Context, id string, maxAttempts int) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.LoginChallenge{}).
+		Where("id = ? AND attempts < ?", id, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	return result.RowsAffected > 0, result.Error
+}
+
+// Consume deletes a challenge once it has been passed, reporting false if it
+// was already gone (e.g. passed by a parallel request)
+func (r *loginChallengeRepository) Consume(ctx context.Context, id string) (bool, error) {
+	result := r.db.WithContext(ctx).Delete(&model.LoginChallenge{}, "id = ?", id)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Delete deletes a login challenge
+func (r *loginChallengeRepository) Delete(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Delete(&model.LoginChallenge{}, "id = ?", id).Error
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginThrottleRepository defines the interface for failed login counter
+// data operations
+type LoginThrottleRepository interface {
+	GetByKeys(ctx context.Context, keys []string) ([]model.LoginThrottle, error)
+	RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (int, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Delete(ctx context.Context, key string) error
+}
+
+// loginThrottleRepository implements LoginThrottleRepository
+type loginThrottleRepository struct {
+	db *gorm.DB
+}
+
+// NewLoginThrottleRepository creates a new login throttle repository
+func NewLoginThrottleRepository(db *gorm.DB) LoginThrottleRepository {
+	return &loginThrottleRepository{db: db}
+}
+
+// GetByKeys retrieves the counters of the given keys; keys without failures
+// have none
+func (r *loginThrottleRepository) GetByKeys(ctx context.Context, keys []string) ([]model.LoginThrottle, error) {
+	var throttles []model.LoginThrottle
+	err := r.db.WithContext(ctx).
+		Where("key IN ?", keys).
+		Find(&throttles).Error
+	return throttles, err
+}
+
+// RecordFailure counts a failed login for a key and returns its count. A
+// count whose last failure is older than window starts over. The upsert is
+// one statement, so parallel attempts are all counted.
+func (r *loginThrottleRepository) RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (int, error) {
+	throttle := model.LoginThrottle{Key: key, Failures: 1, LastFailureAt: at}
+	err := r.db.WithContext(ctx).
+		Clauses(
+			clause.OnConflict{
+				Columns: []clause.Column{{Name: "key"}},
+				DoUpdates: clause.Assignments(map[string]any{
+					"failures": gorm.Expr(
+						"CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END",
+						at.Add(-window),
+					),
+					"last_failure_at": at,
+				}),
+			},
+			clause.Returning{Columns: []clause.Column{{Name: "failures"}}},
+		).
+		Create(&throttle).Error
+	return throttle.Failures, err
+}
+
+// Lock locks a key until the given time
+func (r *loginThrottleRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&model.LoginThrottle{}).
+		Where("key = ?", key).
+		Update("locked_until", until).Error
+}
+
+// Delete clears a key's failures and lock
+func (r *loginThrottleRepository) Delete(ctx context.Context, key string) error {
+	return r.db.WithContext(ctx).Delete(&model.LoginThrottle{}, "key = ?", key).Error
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OAuthAuthorizationCodeRepository defines the interface for authorization
+// code data operations
+type OAuthAuthorizationCodeRepository interface {
+	Create(ctx context.Context, code *model.OAuthAuthorizationCode) error
+	GetByHash(ctx context.Context, codeHash string) (*model.OAuthAuthorizationCode, error)
+	Use(ctx context.Context, codeHash string, clientID uint, usedAt time.Time) (*model.OAuthAuthorizationCode, error)
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
+
+// oauthAuthorizationCodeRepository implements
+// OAuthAuthorizationCodeRepository
+type oauthAuthorizationCodeRepository struct {
+	db *gorm.DB
+}
+
+// NewOAuthAuthorizationCodeRepository creates a new authorization code
+// repository
+func NewOAuthAuthorizationCodeRepository(db *gorm.DB) OAuthAuthorizationCodeRepository {
+	return &oauthAuthorizationCodeRepository{db: db}
+}
+
+// Create creates a new authorization code
+func (r *oauthAuthorizationCodeRepository) Create(ctx context.Context, code *model.OAuthAuthorizationCode) error {
+	return r.db.WithContext(ctx).Create(code).Error
+}
+
+// GetByHash retrieves an authorization code by hash, used or not
+func (r *oauthAuthorizationCodeRepository) GetByHash(ctx context.Context, codeHash string) (*model.OAuthAuthorizationCode, error) {
+	var code model.OAuthAuthorizationCode
+	err := r.db.WithContext(ctx).
+		Where("code_hash = ?", codeHash).
+		First(&code).Error
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+// Use marks an unused, unexpired code issued to a client used and returns
+// it, or returns gorm.ErrRecordNotFound if there is no such code. The check
+// and the update are one statement, so a code can't be exchanged twice by
+// parallel requests, and another client presenting it leaves it unused.
+func (r *oauthAuthorizationCodeRepository) Use(ctx context.Context, codeHash string, clientID uint, usedAt time.Time) (*model.OAuthAuthorizationCode, error) {
+	var code model.OAuthAuthorizationCode
+	result := r.db.WithContext(ctx).
+		Model(&code).
+		Clauses(clause.Returning{}).
+		Where("code_hash = ? AND client_id = ? AND used_at IS NULL AND expires_at > ?", codeHash, clientID, usedAt).
+		Update("used_at", usedAt)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &code, nil
+}
+
+// DeleteExpired deletes the codes that expired before the given time,
+// returning how many it deleted
+func (r *oauthAuthorizationCodeRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("expires_at < ?", before).
+		Delete(&model.OAuthAuthorizationCode{})
+	return result.RowsAffected, result.Error
+}
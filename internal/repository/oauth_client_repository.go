@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// OAuthClientRepository defines the interface for OAuth client data
+// operations
+type OAuthClientRepository interface {
+	Create(ctx context.Context, client *model.OAuthClient) error
+	GetByID(ctx context.Context, id uint) (*model.OAuthClient, error)
+	GetByClientID(ctx context.Context, clientID string) (*model.OAuthClient, error)
+	GetAll(ctx context.Context) ([]model.OAuthClient, error)
+	Update(ctx context.Context, client *model.OAuthClient) error
+	Delete(ctx context.Context, id uint) (bool, error)
+}
+
+// oauthClientRepository implements OAuthClientRepository
+type oauthClientRepository struct {
+	db *gorm.DB
+}
+
+// NewOAuthClientRepository creates a new OAuth client repository
+func NewOAuthClientRepository(db *gorm.DB) OAuthClientRepository {
+	return &oauthClientRepository{db: db}
+}
+
+// Create creates a new OAuth client
+func (r *oauthClientRepository) Create(ctx context.Context, client *model.OAuthClient) error {
+	return r.db.WithContext(ctx).Create(client).Error
+}
+
+// GetByID retrieves an OAuth client by ID
+func (r *oauthClientRepository) GetByID(ctx context.Context, id uint) (*model.OAuthClient, error) {
+	var client model.OAuthClient
+	if err := r.db.WithContext(ctx).First(&client, id).Error; err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// GetByClientID retrieves an OAuth client by the client ID it identifies
+// itself with
+func (r *oauthClientRepository) GetByClientID(ctx context.Context, clientID string) (*model.OAuthClient, error) {
+	var client model.OAuthClient
+	err := r.db.WithContext(ctx).
+		Where("client_id = ?", clientID).
+		First(&client).Error
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// GetAll retrieves all OAuth clients by name
+func (r *oauthClientRepository) GetAll(ctx context.Context) ([]model.OAuthClient, error) {
+	var clients []model.OAuthClient
+	err := r.db.WithContext(ctx).
+		Order("name ASC, id ASC").
+		Find(&clients).Error
+	return clients, err
+}
+
+// Update updates an OAuth client
+func (r *oauthClientRepository) Update(ctx context.Context, client *model.OAuthClient) error {
+	return r.db.WithContext(ctx).Save(client).Error
+}
+
+// Delete deletes an OAuth client, and with it its codes, tokens and
+// consents, reporting whether it existed
+func (r *oauthClientRepository) Delete(ctx context.Context, id uint) (bool, error) {
+	result := r.db.WithContext(ctx).Delete(&model.OAuthClient{}, id)
+	return result.RowsAffected > 0, result.Error
+}
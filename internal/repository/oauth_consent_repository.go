@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OAuthConsentRepository defines the interface for OAuth consent data
+// operations
+type OAuthConsentRepository interface {
+	Get(ctx context.Context, userID, clientID uint) (*model.OAuthConsent, error)
+	Save(ctx context.Context, consent *model.OAuthConsent) error
+}
+
+// oauthConsentRepository implements OAuthConsentRepository
+type oauthConsentRepository struct {
+	db *gorm.DB
+}
+
+// NewOAuthConsentRepository creates a new OAuth consent repository
+func NewOAuthConsentRepository(db *gorm.DB) OAuthConsentRepository {
+	return &oauthConsentRepository{db: db}
+}
+
+// Get retrieves the scopes a user allowed a client
+func (r *oauthConsentRepository) Get(ctx context.Context, userID, clientID uint) (*model.OAuthConsent, error) {
+	var consent model.OAuthConsent
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND client_id = ?", userID, clientID).
+		First(&consent).Error
+	if err != nil {
+		return nil, err
+	}
+	return &consent, nil
+}
+
+// Save records the scopes a user allowed a client, replacing what they
+// allowed before
+func (r *oauthConsentRepository) Save(ctx context.Context, consent *model.OAuthConsent) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"scope", "updated_at"}),
+		}).
+		Create(consent).Error
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OAuthTokenRepository defines the interface for OAuth access and refresh
+// token data operations
+type OAuthTokenRepository interface {
+	Create(ctx context.Context, token *model.OAuthToken) error
+	GetByHash(ctx context.Context, tokenHash string) (*model.OAuthToken, error)
+	Revoke(ctx context.Context, id uint, revokedAt time.Time) (bool, error)
+	RevokeGrant(ctx context.Context, grantID string, revokedAt time.Time) error
+	RevokeSession(ctx context.Context, sessionID string, revokedAt time.Time) error
+	RevokeUser(ctx context.Context, userID uint, revokedAt time.Time) error
+	RevokeEndedSessions(ctx context.Context, revokedAt time.Time) (int64, error)
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
+
+// oauthTokenRepository implements OAuthTokenRepository
+type oauthTokenRepository struct {
+	db *gorm.DB
+}
+
+// NewOAuthTokenRepository creates a new OAuth token repository
+func NewOAuthTokenRepository(db *gorm.DB) OAuthTokenRepository {
+	return &oauthTokenRepository{db: db}
+}
+
+// Create creates a new OAuth token
+func (r *oauthTokenRepository) Create(ctx context.Context, token *model.OAuthToken) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+// GetByHash retrieves a token by hash, whether or not it is still active
+func (r *oauthTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*model.OAuthToken, error) {
+	var token model.OAuthToken
+	err := r.db.WithContext(ctx).
+		Where("token_hash = ?", tokenHash).
+		First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Revoke revokes a token, reporting whether it was revoked by this call
+// rather than before, so of parallel refreshes with the same refresh token
+// only one succeeds
+func (r *oauthTokenRepository) Revoke(ctx context.Context, id uint, revokedAt time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.OAuthToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", revokedAt)
+	return result.RowsAffected > 0, result.Error
+}
+
+// RevokeGrant revokes every token of a grant
+func (r *oauthTokenRepository) RevokeGrant(ctx context.Context, grantID string, revokedAt time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&model.OAuthToken{}).
+		Where("grant_id = ? AND revoked_at IS NULL", grantID).
+		Update("revoked_at", revokedAt).Error
+}
+
+// RevokeSession revokes every token of the grants signed in with a session
+func (r *oauthTokenRepository) RevokeSession(ctx context.Context, sessionID string, revokedAt time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&model.OAuthToken{}).
+		Where("session_id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", revokedAt).Error
+}
+
+// RevokeUser revokes every token of a user, of all clients
+func (r *oauthTokenRepository) RevokeUser(ctx context.Context, userID uint, revokedAt time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&model.OAuthToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", revokedAt).Error
+}
+
+// RevokeEndedSessions revokes the unexpired tokens of the grants whose
+// session has expired, ended or been purged, returning how many it revoked
+func (r *oauthTokenRepository) RevokeEndedSessions(ctx context.Context, revokedAt time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Exec(`UPDATE oauth_tokens SET revoked_at = ?
+		WHERE revoked_at IS NULL AND expires_at > ? AND NOT EXISTS (
+			SELECT 1 FROM sessions
+			WHERE sessions.id = oauth_tokens.session_id AND sessions.expires_at > ? AND sessions.deleted_at IS NULL
+		)`, revokedAt, revokedAt, revokedAt)
+	return result.RowsAffected, result.Error
+}
+
+// DeleteExpired deletes the tokens that expired before the given time,
+// returning how many it deleted. Revoked tokens are kept until they expire,
+// so a revoked refresh token presented again is still recognized.
+func (r *oauthTokenRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("expires_at < ?", before).
+		Delete(&model.OAuthToken{})
+	return result.RowsAffected, result.Error
+}
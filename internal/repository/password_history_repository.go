@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// PasswordHistoryRepository defines the interface for password history data
+// operations
+type PasswordHistoryRepository interface {
+	Create(ctx context.Context, entry *model.PasswordHistory) error
+	GetRecent(ctx context.Context, userID uint, limit int) ([]model.PasswordHistory, error)
+	Prune(ctx context.Context, userID uint, keep int) error
+}
+
+// passwordHistoryRepository implements PasswordHistoryRepository
+type passwordHistoryRepository struct {
+	db *gorm.DB
+}
+
+// NewPasswordHistoryRepository creates a new password history repository
+func NewPasswordHistoryRepository(db *gorm.DB) PasswordHistoryRepository {
+	return &passwordHistoryRepository{db: db}
+}
+
+// Create records a previous password
+func (r *passwordHistoryRepository) Create(ctx context.Context, entry *model.PasswordHistory) error {
+	return r.db.WithContext(ctx).Create(entry).Error
+}
+
+// GetRecent retrieves a user's latest previous passwords, newest first
+func (r *passwordHistoryRepository) GetRecent(ctx context.Context, userID uint, limit int) ([]model.PasswordHistory, error) {
+	var entries []model.PasswordHistory
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&entries).Error
+	return entries, err
+}
+
+// Prune deletes all but a user's keep latest previous passwords
+func (r *passwordHistoryRepository) Prune(ctx context.Context, userID uint, keep int) error {
+	return r.db.WithContext(ctx).
+		Where("user_id = ? AND id NOT IN (?)", userID,
+			r.db.Model(&model.PasswordHistory{}).
+				Select("id").
+				Where("user_id = ?", userID).
+				Order("created_at DESC, id DESC").
+				Limit(keep)).
+		Delete(&model.PasswordHistory{}).Error
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PasswordResetTokenRepository defines the interface for password reset
+// token data operations
+type PasswordResetTokenRepository interface {
+	CreateWithinLimit(ctx context.Context, token *model.PasswordResetToken, since time.Time, limit int) (bool, error)
+	GetValid(ctx context.Context, tokenHash string, at time.Time) (*model.PasswordResetToken, error)
+	Use(ctx context.Context, tokenHash string, usedAt time.Time) (*model.PasswordResetToken, error)
+	DeleteByUserID(ctx context.Context, userID uint) error
+}
+
+// passwordResetTokenRepository implements PasswordResetTokenRepository
+type passwordResetTokenRepository struct {
+	db *gorm.DB
+}
+
+// NewPasswordResetTokenRepository creates a new password reset token
+// repository
+func NewPasswordResetTokenRepository(db *gorm.DB) PasswordResetTokenRepository {
+	return &passwordResetTokenRepository{db: db}
+}
+
+// CreateWithinLimit creates a new password reset token unless its user was
+// issued limit tokens since the given time, used or not, reporting whether
+// it did. Issuing to the same user takes turns under an advisory lock, so
+// parallel requests on any replica can't get past the limit together.
+func (r *passwordResetTokenRepository) CreateWithinLimit(ctx context.Context, token *model.PasswordResetToken, since time.Time, limit int) (bool, error) {
+	created := false
+	err := withAdvisoryLock(ctx, r.db, passwordResetLockKey+int64(token.UserID), func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&model.PasswordResetToken{}).
+			Where("user_id = ? AND created_at >= ?", token.UserID, since).
+			Count(&count).Error
+		if err != nil || count >= int64(limit) {
+			return err
+		}
+		if err := tx.Create(token).Error; err != nil {
+			return err
+		}
+		created = true
+		return nil
+	})
+	return created, err
+}
+
+// GetValid retrieves an unused token that hasn't expired at the given time,
+// without using it
+func (r *passwordResetTokenRepository) GetValid(ctx context.Context, tokenHash string, at time.Time) (*model.PasswordResetToken, error) {
+	var token model.PasswordResetToken
+	err := r.db.WithContext(ctx).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, at).
+		First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Use marks an unused, unexpired token used and returns it, or returns
+// gorm.ErrRecordNotFound if there is no such token. The check and the update
+// are one statement, so a token can't be used twice by parallel requests.
+func (r *passwordResetTokenRepository) Use(ctx context.Context, tokenHash string, usedAt time.Time) (*model.PasswordResetToken, error) {
+	var token model.PasswordResetToken
+	result := r.db.WithContext(ctx).
+		Model(&token).
+		Clauses(clause.Returning{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, usedAt).
+		Update("used_at", usedAt)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &token, nil
+}
+
+// DeleteByUserID deletes all password reset tokens of a user
+func (r *passwordResetTokenRepository) DeleteByUserID(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Delete(&model.PasswordResetToken{}).Error
+}
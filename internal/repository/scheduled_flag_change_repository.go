@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"identity/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ScheduledFlagChangeRepository defines the interface for scheduled flag
+// change data operations
+type ScheduledFlagChangeRepository interface {
+	Create(ctx context.Context, change *model.ScheduledFlagChange) error
+	GetByID(ctx context.Context, id uint) (*model.ScheduledFlagChange, error)
+	ListPending(ctx context.Context) ([]model.ScheduledFlagChange, error)
+	ListDue(ctx context.Context, now time.Time, limit int) ([]model.ScheduledFlagChange, error)
+	Cancel(ctx context.Context, id uint) (bool, error)
+	CancelPendingForFlag(ctx context.Context, featureFlagID uint) error
+	Apply(ctx context.Context, change *model.ScheduledFlagChange, executedAt time.Time) (*model.FeatureFlag, bool, error)
+	MarkFailed(ctx context.Context, id uint, reason string) error
+}
+
+// scheduledFlagChangeRepository implements ScheduledFlagChangeRepository
+type scheduledFlagChangeRepository struct {
+	db *gorm.DB
+}
+
+// NewScheduledFlagChangeRepository creates a new scheduled flag change repository
+func NewScheduledFlagChangeRepository(db *gorm.DB) ScheduledFlagChangeRepository {
+	return &scheduledFlagChangeRepository{db: db}
+}
+
+// Create creates a new scheduled flag change
+func (r *scheduledFlagChangeRepository) Create(ctx context.Context, change *model.ScheduledFlagChange) error {
+	return r.db.WithContext(ctx).Create(change).Error
+}
+
+// GetByID retrieves a scheduled flag change by ID, with its flag and creator
+func (r *scheduledFlagChangeRepository) GetByID(ctx context.Context, id uint) (*model.ScheduledFlagChange, error) {
+	var change model.ScheduledFlagChange
+	err := r.db.WithContext(ctx).
+		Preload("FeatureFlag").
+		Preload("Creator").
+		First(&change, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// ListPending retrieves the changes still waiting to run, soonest first
+func (r *scheduledFlagChangeRepository) ListPending(ctx context.Context) ([]model.ScheduledFlagChange, error) {
+	var changes []model.ScheduledFlagChange
+	err := r.db.WithContext(ctx).
+		Preload("FeatureFlag").
+		Preload("Creator").
+		Where("status = ?", model.ScheduledFlagPending).
+		Order("run_at ASC, id ASC").
+		Find(&changes).Error
+	return changes, err
+}
+
+// ListDue retrieves up to limit pending changes whose run time has passed,
+// in the order they are due
+func (r *scheduledFlagChangeRepository) ListDue(ctx context.Context, now time.Time, limit int) ([]model.ScheduledFlagChange, error) {
+	var changes []model.ScheduledFlagChange
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND run_at <= ?", model.ScheduledFlagPending, now).
+		Order("run_at ASC, id ASC").
+		Limit(limit).
+		Find(&changes).Error
+	return changes, err
+}
+
+// Cancel marks a pending change cancelled, reporting false if it is no
+// longer pending
+func (r *scheduledFlagChangeRepository) Cancel(ctx context.Context, id uint) (bool, error) {
+	return r.transition(ctx, id, map[string]any{"status": model.ScheduledFlagCancelled})
+}
+
+// CancelPendingForFlag cancels every pending change of a flag
+func (r *scheduledFlagChangeRepository) CancelPendingForFlag(ctx context.Context, featureFlagID uint) error {
+	return r.db.WithContext(ctx).
+		Model(&model.ScheduledFlagChange{}).
+		Where("feature_flag_id = ? AND status = ?", featureFlagID, model.ScheduledFlagPending).
+		Update("status", model.ScheduledFlagCancelled).Error
+}
+
+// Apply marks a pending change done and applies it to its flag in one
+// transaction, setting only the column the change is about so edits made to
+// the flag since it was scheduled are kept. It returns the updated flag, or
+// false if the change is no longer pending (e.g. it was cancelled, or
+// another replica applied it). gorm.ErrRecordNotFound means the flag is
+// gone; the change is then left pending.
+func (r *scheduledFlagChangeRepository) Apply(ctx context.Context, change *model.ScheduledFlagChange, executedAt time.Time) (*model.FeatureFlag, bool, error) {
+	var updates map[string]any
+	switch change.Action {
+	case model.ScheduledFlagEnable:
+		updates = map[string]any{"enabled": true}
+	case model.ScheduledFlagDisable:
+		updates = map[string]any{"enabled": false}
+	case model.ScheduledFlagSetRollout:
+		updates = map[string]any{"rollout_percentage": *change.RolloutPercentage}
+	default:
+		return nil, false, fmt.Errorf("unknown scheduled flag change action %q", change.Action)
+	}
+
+	var flag model.FeatureFlag
+	claimed := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Claiming locks the change's row, so a replica applying the same
+		// change waits here and then finds it done
+		result := tx.Model(&model.ScheduledFlagChange{}).
+			Where("id = ? AND status = ?", change.ID, model.ScheduledFlagPending).
+			Updates(map[string]any{"status": model.ScheduledFlagDone, "executed_at": executedAt})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		result = tx.Model(&model.FeatureFlag{}).Where("id = ?", change.FeatureFlagID).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.First(&flag, change.FeatureFlagID).Error; err != nil {
+			return err
+		}
+		claimed = true
+		return nil
+	})
+	if err != nil || !claimed {
+		return nil, false, err
+	}
+	return &flag, true, nil
+}
+
+// MarkFailed records that a pending change could not be applied
+func (r *scheduledFlagChangeRepository) MarkFailed(ctx context.Context, id uint, reason string) error {
+	_, err := r.transition(ctx, id, map[string]any{"status": model.ScheduledFlagFailed, "error": reason})
+	return err
+}
+
+// transition updates a change that is still pending
+func (r *scheduledFlagChangeRepository) transition(ctx context.Context, id uint, updates map[string]any) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.ScheduledFlagChange{}).
+		Where("id = ? AND status = ?", id, model.ScheduledFlagPending).
+		Updates(updates)
+	return result.RowsAffected > 0, result.Error
+}
@@ -12,10 +12,13 @@ import (
 type SessionRepository interface {
 	Create(ctx context.Context, session *model.Session) error
 	GetByID(ctx context.Context, id string) (*model.Session, error)
+	GetActiveByUserID(ctx context.Context, userID uint) ([]model.Session, error)
 	UpdateExpiresAt(ctx context.Context, id string, expiresAt time.Time) error
+	UpdateLastSeenAt(ctx context.Context, id string, lastSeenAt time.Time) error
 	Delete(ctx context.Context, id string) error
 	DeleteByUserID(ctx context.Context, userID uint) error
-	DeleteExpired(ctx context.Context) error
+	PurgeExpired(ctx context.Context, before time.Time, limit int) (int64, error)
+	RunExclusive(ctx context.Context, fn func(ctx context.Context) error) (bool, error)
 }
 
 // sessionRepository implements SessionRepository
@@ -33,7 +36,7 @@ func (r *sessionRepository) Create(ctx context.Context, session *model.Session)
 	return r.db.WithContext(ctx).Create(session).Error
 }
 
-// GetByID retrieves a session by ID
+// GetByID retrieves a session by ID, the hash of its token
 func (r *sessionRepository) GetByID(ctx context.Context, id string) (*model.Session, error) {
 	var session model.Session
 	err := r.db.WithContext(ctx).
@@ -46,6 +49,17 @@ func (r *sessionRepository) GetByID(ctx context.Context, id string) (*model.Sess
 	return &session, nil
 }
 
+// GetActiveByUserID retrieves a user's unexpired sessions, most recently
+// used first
+func (r *sessionRepository) GetActiveByUserID(ctx context.Context, userID uint) ([]model.Session, error) {
+	var sessions []model.Session
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND expires_at > NOW()", userID).
+		Order("last_seen_at DESC NULLS LAST, created_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
 // UpdateExpiresAt extends a session's expiry (sliding sessions)
 func (r *sessionRepository) UpdateExpiresAt(ctx context.Context, id string, expiresAt time.Time) error {
 	return r.db.WithContext(ctx).
@@ -54,6 +68,14 @@ func (r *sessionRepository) UpdateExpiresAt(ctx context.Context, id string, expi
 		Update("expires_at", expiresAt).Error
 }
 
+// UpdateLastSeenAt records when a session was last used
+func (r *sessionRepository) UpdateLastSeenAt(ctx context.Context, id string, lastSeenAt time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&model.Session{}).
+		Where("id = ?", id).
+		Update("last_seen_at", lastSeenAt).Error
+}
+
 // Delete soft deletes a session
 func (r *sessionRepository) Delete(ctx context.Context, id string) error {
 	return r.db.WithContext(ctx).Delete(&model.Session{}, "id = ?", id).Error
@@ -66,9 +88,17 @@ func (r *sessionRepository) DeleteByUserID(ctx context.Context, userID uint) err
 		Delete(&model.Session{}).Error
 }
 
-// DeleteExpired removes all expired sessions
-func (r *sessionRepository) DeleteExpired(ctx context.Context) error {
-	return r.db.WithContext(ctx).
-		Where("expires_at < NOW()").
-		Delete(&model.Session{}).Error
+// PurgeExpired permanently deletes up to limit sessions that expired before
+// the given time or were soft deleted, returning how many it deleted
+func (r *sessionRepository) PurgeExpired(ctx context.Context, before time.Time, limit int) (int64, error) {
+	result := r.db.WithContext(ctx).Exec(`DELETE FROM sessions WHERE id IN (
+		SELECT id FROM sessions WHERE expires_at < ? OR deleted_at IS NOT NULL LIMIT ?
+	)`, before, limit)
+	return result.RowsAffected, result.Error
+}
+
+// RunExclusive runs fn while holding the session reaper's advisory lock,
+// reporting false without running it if another replica holds the lock
+func (r *sessionRepository) RunExclusive(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	return runExclusive(ctx, r.db, sessionReaperLockKey, fn)
 }
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SigningKeyRepository defines the interface for signing key data
+// operations
+type SigningKeyRepository interface {
+	Create(ctx context.Context, key *model.SigningKey) error
+	GetAll(ctx context.Context) ([]model.SigningKey, error)
+	ReplacePrivateKey(ctx context.Context, id, old, privateKey string) (bool, error)
+	DeleteCreatedBefore(ctx context.Context, before time.Time) (int64, error)
+	RunExclusive(ctx context.Context, fn func(ctx context.Context) error) (bool, error)
+}
+
+// signingKeyRepository implements SigningKeyRepository
+type signingKeyRepository struct {
+	db *gorm.DB
+}
+
+// NewSigningKeyRepository creates a new signing key repository
+func NewSigningKeyRepository(db *gorm.DB) SigningKeyRepository {
+	return &signingKeyRepository{db: db}
+}
+
+// Create creates a new signing key
+func (r *signingKeyRepository) Create(ctx context.Context, key *model.SigningKey) error {
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+// GetAll retrieves all signing keys, newest first
+func (r *signingKeyRepository) GetAll(ctx context.Context) ([]model.SigningKey, error) {
+	var keys []model.SigningKey
+	err := r.db.WithContext(ctx).
+		Order("created_at DESC").
+		Find(&keys).Error
+	return keys, err
+}
+
+// ReplacePrivateKey sets a key's private key if it is still old, reporting
+// false if it changed in the meantime
+func (r *signingKeyRepository) ReplacePrivateKey(ctx context.Context, id, old, privateKey string) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.SigningKey{}).
+		Where("id = ? AND private_key = ?", id, old).
+		Update("private_key", privateKey)
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteCreatedBefore deletes the keys created before the given time,
+// returning how many it deleted
+func (r *signingKeyRepository) DeleteCreatedBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("created_at < ?", before).
+		Delete(&model.SigningKey{})
+	return result.RowsAffected, result.Error
+}
+
+// RunExclusive runs fn while holding the key rotation advisory lock,
+// reporting false without running it if another replica holds the lock
+func (r *signingKeyRepository) RunExclusive(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	return runExclusive(ctx, r.db, signingKeyLockKey, fn)
+}
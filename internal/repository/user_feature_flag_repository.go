@@ -5,15 +5,18 @@ import (
 	"identity/internal/model"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // UserFeatureFlagRepository defines the interface for user-feature flag assignment operations
 type UserFeatureFlagRepository interface {
-	AssignFeatureFlagToUser(ctx context.Context, userID uint, featureFlagID uint) error
+	AssignFeatureFlagToUser(ctx context.Context, userID uint, featureFlagID uint, variant string) error
 	UnassignFeatureFlagFromUser(ctx context.Context, userID uint, featureFlagID uint) error
 	GetUserFeatureFlags(ctx context.Context, userID uint) ([]model.FeatureFlag, error)
+	GetUserAssignments(ctx context.Context, userID uint) ([]model.UserFeatureFlag, error)
+	GetAllAssignments(ctx context.Context) ([]model.UserFeatureFlag, error)
 	GetFeatureFlagUsers(ctx context.Context, featureFlagID uint) ([]model.User, error)
-	IsFeatureFlagAssignedToUser(ctx context.Context, userID uint, featureFlagID uint) (bool, error)
+	GetAssignment(ctx context.Context, userID uint, featureFlagID uint) (*model.UserFeatureFlag, error)
 }
 
 // userFeatureFlagRepository implements UserFeatureFlagRepository
@@ -26,13 +29,20 @@ func NewUserFeatureFlagRepository(db *gorm.DB) UserFeatureFlagRepository {
 	return &userFeatureFlagRepository{db: db}
 }
 
-// AssignFeatureFlagToUser assigns a feature flag to a user
-func (r *userFeatureFlagRepository) AssignFeatureFlagToUser(ctx context.Context, userID uint, featureFlagID uint) error {
+// AssignFeatureFlagToUser assigns a feature flag to a user, or changes the
+// pinned variant of an existing assignment
+func (r *userFeatureFlagRepository) AssignFeatureFlagToUser(ctx context.Context, userID uint, featureFlagID uint, variant string) error {
 	assignment := &model.UserFeatureFlag{
 		UserID:        userID,
 		FeatureFlagID: featureFlagID,
+		Variant:       variant,
 	}
-	return r.db.WithContext(ctx).Create(assignment).Error
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "feature_flag_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"variant"}),
+		}).
+		Create(assignment).Error
 }
 
 // UnassignFeatureFlagFromUser removes a feature flag from a user
@@ -52,6 +62,24 @@ func (r *userFeatureFlagRepository) GetUserFeatureFlags(ctx context.Context, use
 	return flags, err
 }
 
+// GetUserAssignments retrieves a user's assignment rows (with pinned variants)
+func (r *userFeatureFlagRepository) GetUserAssignments(ctx context.Context, userID uint) ([]model.UserFeatureFlag, error) {
+	var assignments []model.UserFeatureFlag
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Find(&assignments).Error
+	return assignments, err
+}
+
+// GetAllAssignments retrieves every assignment row
+func (r *userFeatureFlagRepository) GetAllAssignments(ctx context.Context) ([]model.UserFeatureFlag, error) {
+	var assignments []model.UserFeatureFlag
+	err := r.db.WithContext(ctx).
+		Order("user_id, feature_flag_id").
+		Find(&assignments).Error
+	return assignments, err
+}
+
 // GetFeatureFlagUsers retrieves all users assigned to a feature flag
 func (r *userFeatureFlagRepository) GetFeatureFlagUsers(ctx context.Context, featureFlagID uint) ([]model.User, error) {
 	var users []model.User
@@ -62,12 +90,14 @@ func (r *userFeatureFlagRepository) GetFeatureFlagUsers(ctx context.Context, fea
 	return users, err
 }
 
-// IsFeatureFlagAssignedToUser checks if a feature flag is assigned to a user
-func (r *userFeatureFlagRepository) IsFeatureFlagAssignedToUser(ctx context.Context, userID uint, featureFlagID uint) (bool, error) {
-	var count int64
+// GetAssignment retrieves a single assignment, or gorm.ErrRecordNotFound
+func (r *userFeatureFlagRepository) GetAssignment(ctx context.Context, userID uint, featureFlagID uint) (*model.UserFeatureFlag, error) {
+	var assignment model.UserFeatureFlag
 	err := r.db.WithContext(ctx).
-		Model(&model.UserFeatureFlag{}).
 		Where("user_id = ? AND feature_flag_id = ?", userID, featureFlagID).
-		Count(&count).Error
-	return count > 0, err
+		First(&assignment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &assignment, nil
 }
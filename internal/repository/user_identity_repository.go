@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserIdentityRepository defines the interface for external identity data
+// operations
+type UserIdentityRepository interface {
+	Create(ctx context.Context, identity *model.UserIdentity) error
+	GetByProviderSubject(ctx context.Context, provider, subject string) (*model.UserIdentity, error)
+	GetByUserID(ctx context.Context, userID uint) ([]model.UserIdentity, error)
+	RecordLogin(ctx context.Context, id uint, email string, at time.Time) error
+	Delete(ctx context.Context, userID, id uint) (bool, error)
+}
+
+// userIdentityRepository implements UserIdentityRepository
+type userIdentityRepository struct {
+	db *gorm.DB
+}
+
+// NewUserIdentityRepository creates a new external identity repository
+func NewUserIdentityRepository(db *gorm.DB) UserIdentityRepository {
+	return &userIdentityRepository{db: db}
+}
+
+// Create creates a new external identity
+func (r *userIdentityRepository) Create(ctx context.Context, identity *model.UserIdentity) error {
+	return r.db.WithContext(ctx).Create(identity).Error
+}
+
+// GetByProviderSubject retrieves the identity of a provider's account
+func (r *userIdentityRepository) GetByProviderSubject(ctx context.Context, provider, subject string) (*model.UserIdentity, error) {
+	var identity model.UserIdentity
+	err := r.db.WithContext(ctx).
+		Where("provider = ? AND subject = ?", provider, subject).
+		First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// GetByUserID retrieves a user's identities, oldest first
+func (r *userIdentityRepository) GetByUserID(ctx context.Context, userID uint) ([]model.UserIdentity, error) {
+	var identities []model.UserIdentity
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at ASC, id ASC").
+		Find(&identities).Error
+	return identities, err
+}
+
+// RecordLogin stores the email a login reported and when it happened
+func (r *userIdentityRepository) RecordLogin(ctx context.Context, id uint, email string, at time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&model.UserIdentity{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"email":         email,
+			"last_login_at": at,
+		}).Error
+}
+
+// Delete deletes an identity of a user, reporting false if the user has no
+// identity with that ID
+func (r *userIdentityRepository) Delete(ctx context.Context, userID, id uint) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Delete(&model.UserIdentity{}, id)
+	return result.RowsAffected > 0, result.Error
+}
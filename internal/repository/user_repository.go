@@ -14,6 +14,9 @@ type UserRepository interface {
 	GetByEmail(ctx context.Context, email string) (*model.User, error)
 	GetAll(ctx context.Context, limit, offset int) ([]model.User, int64, error)
 	Update(ctx context.Context, user *model.User) error
+	AdvanceTOTPStep(ctx context.Context, id uint, step int64) (bool, error)
+	ListTOTPSecrets(ctx context.Context) ([]model.User, error)
+	ReplaceTOTPSecret(ctx context.Context, id uint, old, secret string) (bool, error)
 	Delete(ctx context.Context, id uint) error
 }
 
@@ -80,6 +83,39 @@ func (r *userRepository) Update(ctx context.Context, user *model.User) error {
 	return r.db.WithContext(ctx).Save(user).Error
 }
 
+// AdvanceTOTPStep records the time step of an accepted TOTP code, reporting
+// false if a code from that step or a later one was already accepted
+func (r *userRepository) AdvanceTOTPStep(ctx context.Context, id uint, step int64) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListTOTPSecrets returns the ID and TOTP secret of every user with one,
+// deleted users included
+func (r *userRepository) ListTOTPSecrets(ctx context.Context) ([]model.User, error) {
+	var users []model.User
+	err := r.db.WithContext(ctx).
+		Unscoped().
+		Select("id", "totp_secret").
+		Where("totp_secret <> ''").
+		Find(&users).Error
+	return users, err
+}
+
+// ReplaceTOTPSecret sets a user's TOTP secret if it is still old, reporting
+// false if it changed in the meantime
+func (r *userRepository) ReplaceTOTPSecret(ctx context.Context, id uint, old, secret string) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Unscoped().
+		Model(&model.User{}).
+		Where("id = ? AND totp_secret = ?", id, old).
+		Update("totp_secret", secret)
+	return result.RowsAffected > 0, result.Error
+}
+
 // Delete soft deletes a user
 func (r *userRepository) Delete(ctx context.Context, id uint) error {
 	return r.db.WithContext(ctx).Delete(&model.User{}, id).Error
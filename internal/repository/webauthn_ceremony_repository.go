@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WebAuthnCeremonyRepository defines the interface for WebAuthn ceremony data
+// operations
+type WebAuthnCeremonyRepository interface {
+	Create(ctx context.Context, ceremony *model.WebAuthnCeremony) error
+	Take(ctx context.Context, id string) (*model.WebAuthnCeremony, error)
+}
+
+// webAuthnCeremonyRepository implements WebAuthnCeremonyRepository
+type webAuthnCeremonyRepository struct {
+	db *gorm.DB
+}
+
+// NewWebAuthnCeremonyRepository creates a new WebAuthn ceremony repository
+func NewWebAuthnCeremonyRepository(db *gorm.DB) WebAuthnCeremonyRepository {
+	return &webAuthnCeremonyRepository{db: db}
+}
+
+// Create creates a new WebAuthn ceremony
+func (r *webAuthnCeremonyRepository) Create(ctx context.Context, ceremony *model.WebAuthnCeremony) error {
+	return r.db.WithContext(ctx).Create(ceremony).Error
+}
+
+// Take deletes a ceremony and returns it, so each one is answered at most
+// once; gorm.ErrRecordNotFound if it doesn't exist (any more)
+func (r *webAuthnCeremonyRepository) Take(ctx context.Context, id string) (*model.WebAuthnCeremony, error) {
+	var ceremonies []model.WebAuthnCeremony
+	err := r.db.WithContext(ctx).
+		Clauses(clause.Returning{}).
+		Where("id = ?", id).
+		Delete(&ceremonies).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(ceremonies) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &ceremonies[0], nil
+}
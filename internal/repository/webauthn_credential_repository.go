@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"identity/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WebAuthnCredentialRepository defines the interface for WebAuthn credential
+// data operations
+type WebAuthnCredentialRepository interface {
+	Create(ctx context.Context, credential *model.WebAuthnCredential) error
+	GetByUserID(ctx context.Context, userID uint) ([]model.WebAuthnCredential, error)
+	CountByUserID(ctx context.Context, userID uint) (int64, error)
+	RecordUse(ctx context.Context, id uint, signCount uint32, backupState bool, usedAt time.Time) error
+	Delete(ctx context.Context, userID, id uint) (bool, error)
+}
+
+// webAuthnCredentialRepository implements WebAuthnCredentialRepository
+type webAuthnCredentialRepository struct {
+	db *gorm.DB
+}
+
+// NewWebAuthnCredentialRepository creates a new WebAuthn credential repository
+func NewWebAuthnCredentialRepository(db *gorm.DB) WebAuthnCredentialRepository {
+	return &webAuthnCredentialRepository{db: db}
+}
+
+// Create creates a new WebAuthn credential
+func (r *webAuthnCredentialRepository) Create(ctx context.Context, credential *model.WebAuthnCredential) error {
+	return r.db.WithContext(ctx).Create(credential).Error
+}
+
+// GetByUserID retrieves a user's credentials, oldest first
+func (r *webAuthnCredentialRepository) GetByUserID(ctx context.Context, userID uint) ([]model.WebAuthnCredential, error) {
+	var credentials []model.WebAuthnCredential
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at ASC, id ASC").
+		Find(&credentials).Error
+	return credentials, err
+}
+
+// CountByUserID counts a user's credentials
+func (r *webAuthnCredentialRepository) CountByUserID(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&model.WebAuthnCredential{}).
+		Where("user_id = ?", userID).
+		Count(&count).Error
+	return count, err
+}
+
+// RecordUse stores what a successful login reported about a credential
+func (r *webAuthnCredentialRepository) RecordUse(ctx context.Context, id uint, signCount uint32, backupState bool, usedAt time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&model.WebAuthnCredential{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"sign_count":   signCount,
+			"backup_state": backupState,
+			"last_used_at": usedAt,
+		}).Error
+}
+
+// Delete deletes a credential of a user, reporting false if the user has no
+// credential with that ID
+func (r *webAuthnCredentialRepository) Delete(ctx context.Context, userID, id uint) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Delete(&model.WebAuthnCredential{}, id)
+	return result.RowsAffected > 0, result.Error
+}
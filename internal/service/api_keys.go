@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"identity/internal/model"
+	"identity/internal/repository"
+	"identity/internal/service/dto"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// apiKeyPrefix starts every API key, so leaked keys are easy to spot
+	apiKeyPrefix = "idk_"
+	// apiKeyPrefixLength is how many characters of a key are stored in the
+	// clear to identify it
+	apiKeyPrefixLength = len(apiKeyPrefix) + 8
+	// maxAPIKeyExpiryDays bounds how far ahead a key may expire
+	maxAPIKeyExpiryDays = 3650
+)
+
+// APIKeyScopes are the permissions an API key can be granted. Never
+// PermUsersSecurity: a key that leaked must not be able to make anyone an
+// admin or take over their account.
+var APIKeyScopes = []Permission{PermFlagsRead, PermFlagsWrite, PermUsersRead, PermUsersWrite}
+
+var (
+	// ErrInvalidAPIKey is returned when a request's API key is unknown,
+	// revoked or expired
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrInvalidAPIKeyRequest matches the errors returned for a key that
+	// can't be minted as asked, e.g. without a name
+	ErrInvalidAPIKeyRequest = errors.New("invalid API key request")
+)
+
+// APIKeyService mints and checks the keys other services call the API with
+type APIKeyService interface {
+	Authenticate(ctx context.Context, key string) (*model.APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]dto.APIKeyResponse, error)
+	CreateAPIKey(ctx context.Context, req *dto.CreateAPIKeyRequest) (*dto.APIKeySecretResponse, error)
+	RevokeAPIKey(ctx context.Context, id uint) error
+}
+
+// apiKeyService implements APIKeyService
+type apiKeyService struct {
+	repo  repository.APIKeyRepository
+	audit AuditLogger
+}
+
+// NewAPIKeyService creates a new API key service
+func NewAPIKeyService(repo repository.APIKeyRepository, audit AuditLogger) APIKeyService {
+	return &apiKeyService{repo: repo, audit: audit}
+}
+
+// Authenticate returns the active key matching key, recording it as used
+func (s *apiKeyService) Authenticate(ctx context.Context, key string) (*model.APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	apiKey, err := s.repo.GetByHash(ctx, hashSecret(key))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	now := time.Now()
+	if !apiKey.IsActive(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// Like a session's last-seen time, written only once it is stale so
+	// busy services don't write on every request
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > lastSeenThreshold {
+		if err := s.repo.UpdateLastUsedAt(ctx, apiKey.ID, now); err == nil {
+			apiKey.LastUsedAt = &now
+		}
+	}
+
+	return apiKey, nil
+}
+
+// GetAPIKeys lists all keys, revoked and expired ones included
+func (s *apiKeyService) GetAPIKeys(ctx context.Context) ([]dto.APIKeyResponse, error) {
+	if err := authorize(ctx, PermAPIKeysRead); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.repo.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API keys: %w", err)
+	}
+	now := time.Now()
+	responses := make([]dto.APIKeyResponse, len(keys))
+	for i := range keys {
+		responses[i] = *toAPIKeyResponse(&keys[i], now)
+	}
+	return responses, nil
+}
+
+// CreateAPIKey mints a key with the given scopes, returning the key itself
+// this once
+func (s *apiKeyService) CreateAPIKey(ctx context.Context, req *dto.CreateAPIKeyRequest) (*dto.APIKeySecretResponse, error) {
+	if err := authorize(ctx, PermAPIKeysWrite); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: a name is required", ErrInvalidAPIKeyRequest)
+	}
+	if len(name) > 100 {
+		return nil, fmt.Errorf("%w: the name must be at most 100 characters", ErrInvalidAPIKeyRequest)
+	}
+	if len(req.Scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidAPIKeyRequest)
+	}
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if !slices.Contains(APIKeyScopes, Permission(scope)) {
+			return nil, fmt.Errorf("%w: unknown scope %q", ErrInvalidAPIKeyRequest, scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPIKeyExpiryDays {
+		return nil, fmt.Errorf("%w: expiry must be between 0 (never) and %d days", ErrInvalidAPIKeyRequest, maxAPIKeyExpiryDays)
+	}
+
+	secret, err := generateSessionID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	key := apiKeyPrefix + secret
+	apiKey := &model.APIKey{
+		Name:            name,
+		Prefix:          key[:apiKeyPrefixLength],
+		KeyHash:         hashSecret(key),
+		Scopes:          scopes,
+		CreatedByUserID: ActorFromContext(ctx),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+	if err := s.repo.Create(ctx, apiKey); err != nil {
+		return nil, fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	s.audit.Log(ctx, nil, AuditAPIKeyCreated, "api_key", fmt.Sprint(apiKey.ID), map[string]any{
+		"name":       apiKey.Name,
+		"prefix":     apiKey.Prefix,
+		"scopes":     scopes,
+		"expires_at": apiKey.ExpiresAt,
+	})
+
+	return &dto.APIKeySecretResponse{APIKey: *toAPIKeyResponse(apiKey, time.Now()), Key: key}, nil
+}
+
+// RevokeAPIKey stops a key from working right away. The key is kept, so the
+// audit entries made with it still name it.
+func (s *apiKeyService) RevokeAPIKey(ctx context.Context, id uint) error {
+	if err := authorize(ctx, PermAPIKeysWrite); err != nil {
+		return err
+	}
+
+	apiKey, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("API key not found")
+		}
+		return fmt.Errorf("failed to get API key: %w", err)
+	}
+	if apiKey.RevokedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	apiKey.RevokedAt = &now
+	if err := s.repo.Update(ctx, apiKey); err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	s.audit.Log(ctx, nil, AuditAPIKeyRevoked, "api_key", fmt.Sprint(apiKey.ID), map[string]any{"name": apiKey.Name, "prefix": apiKey.Prefix})
+
+	return nil
+}
+
+// APIKeyPermissions returns the scopes of an API key as permissions, for
+// WithActorScopes
+func APIKeyPermissions(apiKey *model.APIKey) []Permission {
+	permissions := make([]Permission, len(apiKey.Scopes))
+	for i, scope := range apiKey.Scopes {
+		permissions[i] = Permission(scope)
+	}
+	return permissions
+}
+
+func toAPIKeyResponse(apiKey *model.APIKey, now time.Time) *dto.APIKeyResponse {
+	return &dto.APIKeyResponse{
+		ID:         apiKey.ID,
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Scopes:     apiKey.Scopes,
+		ExpiresAt:  apiKey.ExpiresAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		RevokedAt:  apiKey.RevokedAt,
+		Active:     apiKey.IsActive(now),
+		CreatedAt:  apiKey.CreatedAt,
+	}
+}
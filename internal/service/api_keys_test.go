@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// mockAPIKeyRepository is an in-memory APIKeyRepository
+type mockAPIKeyRepository struct {
+	keys   map[uint]*model.APIKey
+	nextID uint
+}
+
+func newMockAPIKeyRepository() *mockAPIKeyRepository {
+	return &mockAPIKeyRepository{keys: make(map[uint]*model.APIKey)}
+}
+
+func (m *mockAPIKeyRepository) Create(ctx context.Context, key *model.APIKey) error {
+	m.nextID++
+	key.ID = m.nextID
+	key.CreatedAt = time.Now()
+	m.keys[key.ID] = key
+	return nil
+}
+
+func (m *mockAPIKeyRepository) GetByID(ctx context.Context, id uint) (*model.APIKey, error) {
+	key, ok := m.keys[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *key
+	return &copied, nil
+}
+
+func (m *mockAPIKeyRepository) GetByHash(ctx context.Context, keyHash string) (*model.APIKey, error) {
+	for _, key := range m.keys {
+		if key.KeyHash == keyHash {
+			copied := *key
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *mockAPIKeyRepository) GetAll(ctx context.Context) ([]model.APIKey, error) {
+	var keys []model.APIKey
+	for _, key := range m.keys {
+		keys = append(keys, *key)
+	}
+	return keys, nil
+}
+
+func (m *mockAPIKeyRepository) Update(ctx context.Context, key *model.APIKey) error {
+	copied := *key
+	m.keys[key.ID] = &copied
+	return nil
+}
+
+func (m *mockAPIKeyRepository) UpdateLastUsedAt(ctx context.Context, id uint, lastUsedAt time.Time) error {
+	if key, ok := m.keys[id]; ok {
+		key.LastUsedAt = &lastUsedAt
+	}
+	return nil
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	repo := newMockAPIKeyRepository()
+	audit := &recordingAudit{}
+	svc := NewAPIKeyService(repo, audit)
+	admin := WithActorRole(WithActor(context.Background(), 1), model.RoleAdmin)
+
+	created, err := svc.CreateAPIKey(admin, &dto.CreateAPIKeyRequest{
+		Name:          "transactions",
+		Scopes:        []string{"flags:read", "users:read", "flags:read"},
+		ExpiresInDays: 30,
+	})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if !strings.HasPrefix(created.Key, apiKeyPrefix) || !strings.HasPrefix(created.Key, created.APIKey.Prefix) {
+		t.Fatalf("expected a prefixed key starting with %q, got %q", created.APIKey.Prefix, created.Key)
+	}
+	if !slices.Equal(created.APIKey.Scopes, []string{"flags:read", "users:read"}) {
+		t.Fatalf("expected deduplicated scopes, got %v", created.APIKey.Scopes)
+	}
+	stored := repo.keys[created.APIKey.ID]
+	if stored.KeyHash == created.Key || strings.Contains(stored.KeyHash, created.Key[len(apiKeyPrefix):]) {
+		t.Fatal("expected only a hash of the key to be stored")
+	}
+	if stored.CreatedByUserID == nil || *stored.CreatedByUserID != 1 {
+		t.Fatalf("expected the key to record who minted it, got %v", stored.CreatedByUserID)
+	}
+
+	apiKey, err := svc.Authenticate(context.Background(), created.Key)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if apiKey.ID != created.APIKey.ID {
+		t.Fatalf("expected key %d, got %d", created.APIKey.ID, apiKey.ID)
+	}
+	if repo.keys[apiKey.ID].LastUsedAt == nil {
+		t.Fatal("expected the key's use to be recorded")
+	}
+	if _, err := svc.Authenticate(context.Background(), created.Key+"x"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("expected ErrInvalidAPIKey for a wrong key, got %v", err)
+	}
+
+	if err := svc.RevokeAPIKey(admin, apiKey.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	if _, err := svc.Authenticate(context.Background(), created.Key); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("expected a revoked key to be rejected, got %v", err)
+	}
+	keys, err := svc.GetAPIKeys(admin)
+	if err != nil || len(keys) != 1 || keys[0].Active {
+		t.Fatalf("expected the revoked key to be listed as inactive, got %+v (%v)", keys, err)
+	}
+
+	if got := audit.actions(); !slices.Equal(got, []string{AuditAPIKeyCreated, AuditAPIKeyRevoked}) {
+		t.Fatalf("expected created and revoked to be audited, got %v", got)
+	}
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+	repo := newMockAPIKeyRepository()
+	svc := NewAPIKeyService(repo, &recordingAudit{})
+	admin := WithActorRole(context.Background(), model.RoleAdmin)
+
+	created, err := svc.CreateAPIKey(admin, &dto.CreateAPIKeyRequest{Name: "ci", Scopes: []string{"flags:write"}, ExpiresInDays: 1})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	repo.keys[created.APIKey.ID].ExpiresAt = &past
+
+	if _, err := svc.Authenticate(context.Background(), created.Key); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("expected an expired key to be rejected, got %v", err)
+	}
+}
+
+func TestCreateAPIKeyValidation(t *testing.T) {
+	svc := NewAPIKeyService(newMockAPIKeyRepository(), &recordingAudit{})
+	admin := WithActorRole(context.Background(), model.RoleAdmin)
+
+	tests := []struct {
+		name string
+		req  dto.CreateAPIKeyRequest
+	}{
+		{name: "no name", req: dto.CreateAPIKeyRequest{Name: " ", Scopes: []string{"flags:read"}}},
+		{name: "no scopes", req: dto.CreateAPIKeyRequest{Name: "ci"}},
+		{name: "unknown scope", req: dto.CreateAPIKeyRequest{Name: "ci", Scopes: []string{"api-keys:write"}}},
+		{name: "negative expiry", req: dto.CreateAPIKeyRequest{Name: "ci", Scopes: []string{"flags:read"}, ExpiresInDays: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := svc.CreateAPIKey(admin, &tt.req); !errors.Is(err, ErrInvalidAPIKeyRequest) {
+				t.Fatalf("expected ErrInvalidAPIKeyRequest, got %v", err)
+			}
+		})
+	}
+
+	// Only admins mint keys
+	viewer := WithActorRole(context.Background(), model.RoleViewer)
+	if _, err := svc.CreateAPIKey(viewer, &dto.CreateAPIKeyRequest{Name: "ci", Scopes: []string{"flags:read"}}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for a viewer, got %v", err)
+	}
+}
+
+// A request made with an API key is authorized by the key's scopes alone
+func TestAuthorizeWithAPIKeyScopes(t *testing.T) {
+	ctx := WithActorScopes(context.Background(), []Permission{PermFlagsRead})
+
+	if err := authorize(ctx, PermFlagsRead); err != nil {
+		t.Fatalf("expected flags:read to be granted, got %v", err)
+	}
+	if err := authorize(ctx, PermFlagsWrite); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected flags:write to be refused, got %v", err)
+	}
+}
+
+// users:write lets a key manage users, but not decide who can sign in as
+// them: that would let a leaked key make itself an admin
+func TestAPIKeyCannotTakeOverUsers(t *testing.T) {
+	svc, userRepo, _ := setupTwoFactor(t, model.RoleAdmin, TwoFactorConfig{})
+	userService := NewUserService(userRepo, newMockFeatureFlagRepository(), newMockUserFeatureFlagRepository(), nil, newNoopAudit())
+	ctx := WithActorScopes(context.Background(), []Permission{PermUsersRead, PermUsersWrite})
+
+	name, admin, email := "Renamed", string(model.RoleAdmin), "attacker@example.com"
+	if _, err := userService.UpdateUser(ctx, 1, &dto.UpdateUserRequest{Name: &name}); err != nil {
+		t.Fatalf("UpdateUser(name) error = %v", err)
+	}
+	if _, err := userService.CreateUser(ctx, &dto.CreateUserRequest{Name: "Service", Email: "svc@example.com"}); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	refused := map[string]error{
+		"create an admin": func() error {
+			_, err := userService.CreateUser(ctx, &dto.CreateUserRequest{Name: "Eve", Email: "eve@example.com", Role: admin})
+			return err
+		}(),
+		"change a role": func() error {
+			_, err := userService.UpdateUser(ctx, 2, &dto.UpdateUserRequest{Role: &admin})
+			return err
+		}(),
+		"change an email": func() error {
+			_, err := userService.UpdateUser(ctx, 1, &dto.UpdateUserRequest{Email: &email})
+			return err
+		}(),
+		"set a password": svc.SetPassword(ctx, 1, "N3w-passphrase!"),
+		"force logout":   svc.ForceLogout(ctx, nil, 1),
+		"revoke session": svc.RevokeUserSession(ctx, 1, "handle"),
+		"reset TOTP":     svc.ResetTOTP(ctx, 1),
+		"revoke key":     svc.RevokeWebAuthnCredential(ctx, 1, 1),
+		"unlock":         svc.UnlockUser(ctx, 1),
+	}
+	for action, err := range refused {
+		if !errors.Is(err, ErrForbidden) {
+			t.Errorf("%s with users:write: error = %v, want ErrForbidden", action, err)
+		}
+	}
+
+	// Nor can a key be minted with more
+	keys := NewAPIKeyService(newMockAPIKeyRepository(), newNoopAudit())
+	if _, err := keys.CreateAPIKey(adminContext(), &dto.CreateAPIKeyRequest{Name: "ci", Scopes: []string{string(PermUsersSecurity)}}); !errors.Is(err, ErrInvalidAPIKeyRequest) {
+		t.Errorf("CreateAPIKey(users:security) error = %v, want ErrInvalidAPIKeyRequest", err)
+	}
+}
@@ -11,25 +11,59 @@ import (
 
 // Audit action names
 const (
-	AuditLoginSuccess     = "login_success"
-	AuditLoginFailed      = "login_failed"
-	AuditLogout           = "logout"
-	AuditForceLogout      = "force_logout"
-	AuditUserRegistered   = "user_registered"
-	AuditPasswordSet      = "password_set"
-	AuditUserCreated      = "user_created"
-	AuditUserUpdated      = "user_updated"
-	AuditUserDeleted      = "user_deleted"
-	AuditFlagCreated      = "flag_created"
-	AuditFlagUpdated      = "flag_updated"
-	AuditFlagToggled      = "flag_toggled"
-	AuditFlagDeleted      = "flag_deleted"
-	AuditUserFlagAssigned = "user_flag_assigned"
-	AuditUserFlagRemoved  = "user_flag_removed"
+	AuditLoginSuccess             = "login_success"
+	AuditLoginFailed              = "login_failed"
+	AuditLogout                   = "logout"
+	AuditForceLogout              = "force_logout"
+	AuditSessionRevoked           = "session_revoked"
+	AuditUserRegistered           = "user_registered"
+	AuditPasswordSet              = "password_set"
+	AuditPasswordResetRequested   = "password_reset_requested"
+	AuditPasswordReset            = "password_reset"
+	AuditLoginLocked              = "login_locked"
+	AuditUserUnlocked             = "user_unlocked"
+	AuditTOTPEnrolled             = "totp_enrolled"
+	AuditTOTPVerified             = "totp_verified"
+	AuditTOTPFailed               = "totp_failed"
+	AuditTOTPDisabled             = "totp_disabled"
+	AuditTOTPReset                = "totp_reset"
+	AuditRecoveryCodesRegenerated = "recovery_codes_regenerated"
+	AuditWebAuthnRegistered       = "webauthn_registered"
+	AuditWebAuthnRemoved          = "webauthn_removed"
+	AuditWebAuthnFailed           = "webauthn_failed"
+	AuditIdentityLinked           = "identity_linked"
+	AuditIdentityUnlinked         = "identity_unlinked"
+	AuditUserProvisioned          = "user_provisioned"
+	AuditUserCreated              = "user_created"
+	AuditUserUpdated              = "user_updated"
+	AuditUserDeleted              = "user_deleted"
+	AuditFlagCreated              = "flag_created"
+	AuditFlagUpdated              = "flag_updated"
+	AuditFlagToggled              = "flag_toggled"
+	AuditFlagDeleted              = "flag_deleted"
+	AuditFlagChangeScheduled      = "flag_change_scheduled"
+	AuditFlagChangeCancelled      = "flag_change_cancelled"
+	AuditUserFlagAssigned         = "user_flag_assigned"
+	AuditUserFlagRemoved          = "user_flag_removed"
+	AuditOAuthAuthorized          = "oauth_authorized"
+	AuditOAuthTokenReused         = "oauth_token_reused"
+	AuditOAuthClientCreated       = "oauth_client_created"
+	AuditOAuthClientSecretRotated = "oauth_client_secret_rotated"
+	AuditOAuthClientDeleted       = "oauth_client_deleted"
+	AuditAPIKeyCreated            = "api_key_created"
+	AuditAPIKeyRevoked            = "api_key_revoked"
 )
 
 type actorContextKey struct{}
 
+type actorAPIKeyContextKey struct{}
+
+type actorServiceContextKey struct{}
+
+type clientIPContextKey struct{}
+
+type userAgentContextKey struct{}
+
 // WithActor stores the acting user's ID in the context so audit entries can
 // attribute actions without threading the actor through every service call.
 func WithActor(ctx context.Context, userID uint) context.Context {
@@ -44,10 +78,67 @@ func ActorFromContext(ctx context.Context) *uint {
 	return nil
 }
 
+// WithActorAPIKey stores the ID of the API key a request was made with in the
+// context, so audit entries are attributed to the key
+func WithActorAPIKey(ctx context.Context, keyID uint) context.Context {
+	return context.WithValue(ctx, actorAPIKeyContextKey{}, keyID)
+}
+
+// ActorAPIKeyFromContext returns the ID of the API key a request was made
+// with, if any
+func ActorAPIKeyFromContext(ctx context.Context) *uint {
+	if id, ok := ctx.Value(actorAPIKeyContextKey{}).(uint); ok {
+		return &id
+	}
+	return nil
+}
+
+// WithActorService stores the name of the service a request's client
+// certificate identifies in the context, so audit entries are attributed to
+// the service
+func WithActorService(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, actorServiceContextKey{}, name)
+}
+
+// ActorServiceFromContext returns the name of the service a request came
+// from, or "" if it didn't identify one
+func ActorServiceFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(actorServiceContextKey{}).(string)
+	return name
+}
+
+// WithClientIP stores the address a request came from in the context, for
+// login throttling and audit entries
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPContextKey{}, ip)
+}
+
+// ClientIPFromContext returns the address a request came from, or "" if
+// unknown
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPContextKey{}).(string)
+	return ip
+}
+
+// WithUserAgent stores the User-Agent a request came with in the context, to
+// label the sessions it creates
+func WithUserAgent(ctx context.Context, userAgent string) context.Context {
+	return context.WithValue(ctx, userAgentContextKey{}, userAgent)
+}
+
+// UserAgentFromContext returns the User-Agent a request came with, or "" if
+// unknown
+func UserAgentFromContext(ctx context.Context) string {
+	userAgent, _ := ctx.Value(userAgentContextKey{}).(string)
+	return userAgent
+}
+
 // AuditLogger records audit events. Writes are best-effort: failures are
 // logged and swallowed so an audit problem never blocks the main action.
 // When actorUserID is nil, the actor is resolved from the context (set by the
-// auth middleware via WithActor).
+// auth middleware via WithActor); the API key a request was made with and
+// the service its client certificate identifies, if any, are recorded as
+// well.
 type AuditLogger interface {
 	Log(ctx context.Context, actorUserID *uint, action, targetType, targetID string, details map[string]any)
 }
@@ -70,10 +161,13 @@ func (a *auditLogger) Log(ctx context.Context, actorUserID *uint, action, target
 	}
 
 	entry := &model.AuditLog{
-		ActorUserID: actorUserID,
-		Action:      action,
-		TargetType:  targetType,
-		TargetID:    targetID,
+		ActorUserID:   actorUserID,
+		ActorAPIKeyID: ActorAPIKeyFromContext(ctx),
+		ActorService:  ActorServiceFromContext(ctx),
+		Action:        action,
+		TargetType:    targetType,
+		TargetID:      targetID,
+		IP:            ClientIPFromContext(ctx),
 	}
 
 	if details != nil {
@@ -85,8 +179,11 @@ func (a *auditLogger) Log(ctx context.Context, actorUserID *uint, action, target
 	a.logger.Info("audit",
 		"action", action,
 		"actor_user_id", actorUserID,
+		"actor_api_key_id", entry.ActorAPIKeyID,
+		"actor_service", entry.ActorService,
 		"target_type", targetType,
 		"target_id", targetID,
+		"ip", entry.IP,
 		"details", fmt.Sprintf("%v", details),
 	)
 
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -11,18 +12,16 @@ import (
 	"identity/internal/service/dto"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"gorm.io/gorm"
 )
 
 const (
 	// DefaultSessionDuration is used when no duration is configured
 	DefaultSessionDuration = 720 * time.Hour
-	// BcryptCost is the cost factor for bcrypt hashing
-	BcryptCost = 10
 	// slideThreshold avoids a DB write on every validation: the expiry is
-	// only pushed forward once it is more than this much behind the full
-	// sliding window.
+	// only pushed forward once it is more than this much, or a tenth of a
+	// shorter window, behind the full sliding window.
 	slideThreshold = time.Hour
 )
 
@@ -32,35 +31,107 @@ type AuthService interface {
 	Logout(ctx context.Context, sessionID string) error
 	Register(ctx context.Context, req *dto.RegisterRequest) (*dto.UserResponse, error)
 	ValidateSession(ctx context.Context, sessionID string) (*model.User, error)
+	GetSession(ctx context.Context, sessionID string) (*model.Session, error)
 	GetUserBySession(ctx context.Context, sessionID string) (*dto.UserResponse, error)
 	SetPassword(ctx context.Context, userID uint, password string) error
 	ForceLogout(ctx context.Context, actorUserID *uint, userID uint) error
 	SessionDuration() time.Duration
+
+	// Two-factor authentication
+	VerifyLogin(ctx context.Context, req *dto.VerifyLoginRequest) (*dto.LoginResponse, error)
+	BeginLoginEnrollment(ctx context.Context, challengeToken string) (*dto.TOTPEnrollmentResponse, error)
+	BeginTOTPEnrollment(ctx context.Context, userID uint) (*dto.TOTPEnrollmentResponse, error)
+	ConfirmTOTPEnrollment(ctx context.Context, userID uint, code string) (*dto.RecoveryCodesResponse, error)
+	RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) (*dto.RecoveryCodesResponse, error)
+	DisableTOTP(ctx context.Context, userID uint, code string) error
+	ResetTOTP(ctx context.Context, userID uint) error
+
+	// WebAuthn (passkeys and security keys)
+	BeginWebAuthnRegistration(ctx context.Context, userID uint) (*dto.WebAuthnOptionsResponse, error)
+	FinishWebAuthnRegistration(ctx context.Context, userID uint, req *dto.FinishWebAuthnRegistrationRequest) (*dto.WebAuthnCredentialResponse, error)
+	GetWebAuthnCredentials(ctx context.Context, userID uint) ([]dto.WebAuthnCredentialResponse, error)
+	GetUserWebAuthnCredentials(ctx context.Context, userID uint) ([]dto.WebAuthnCredentialResponse, error)
+	DeleteWebAuthnCredential(ctx context.Context, userID, credentialID uint) error
+	RevokeWebAuthnCredential(ctx context.Context, userID, credentialID uint) error
+	BeginPasskeyLogin(ctx context.Context) (*dto.WebAuthnOptionsResponse, error)
+	FinishPasskeyLogin(ctx context.Context, req *dto.FinishPasskeyLoginRequest) (*dto.LoginResponse, error)
+	BeginLoginWebAuthn(ctx context.Context, challengeToken string) (*dto.WebAuthnOptionsResponse, error)
+	VerifyLoginWebAuthn(ctx context.Context, req *dto.VerifyLoginWebAuthnRequest) (*dto.LoginResponse, error)
+
+	// External identity providers
+	LoginWithIdentity(ctx context.Context, userID uint, provider string) (*dto.LoginResponse, error)
+
+	// Sessions
+	GetSessions(ctx context.Context, userID uint, currentSessionID string) ([]dto.SessionResponse, error)
+	RevokeSession(ctx context.Context, userID uint, handle string) error
+	GetUserSessions(ctx context.Context, userID uint) ([]dto.SessionResponse, error)
+	RevokeUserSession(ctx context.Context, userID uint, handle string) error
+
+	// Login lockout
+	GetUserLockout(ctx context.Context, userID uint) (*dto.LoginLockoutResponse, error)
+	GetLoginLockouts(ctx context.Context, emails []string) (map[string]time.Time, error)
+	UnlockUser(ctx context.Context, userID uint) error
 }
 
 // authService implements AuthService
 type authService struct {
-	userRepo        repository.UserRepository
-	sessionRepo     repository.SessionRepository
-	audit           AuditLogger
-	sessionDuration time.Duration
+	userRepo         repository.UserRepository
+	sessionRepo      repository.SessionRepository
+	oauthTokenRepo   repository.OAuthTokenRepository
+	challengeRepo    repository.LoginChallengeRepository
+	recoveryCodeRepo repository.RecoveryCodeRepository
+	credentialRepo   repository.WebAuthnCredentialRepository
+	ceremonyRepo     repository.WebAuthnCeremonyRepository
+	throttleRepo     repository.LoginThrottleRepository
+	audit            AuditLogger
+	sessionDuration  time.Duration
+	timeouts         SessionTimeouts
+	twoFactor        TwoFactorConfig
+	webAuthn         *webauthn.WebAuthn
+	lockout          LockoutConfig
+	passwords        *PasswordPolicy
 }
 
 // NewAuthService creates a new auth service
 func NewAuthService(
 	userRepo repository.UserRepository,
 	sessionRepo repository.SessionRepository,
+	oauthTokenRepo repository.OAuthTokenRepository,
+	challengeRepo repository.LoginChallengeRepository,
+	recoveryCodeRepo repository.RecoveryCodeRepository,
+	credentialRepo repository.WebAuthnCredentialRepository,
+	ceremonyRepo repository.WebAuthnCeremonyRepository,
+	throttleRepo repository.LoginThrottleRepository,
 	audit AuditLogger,
 	sessionDuration time.Duration,
+	timeouts SessionTimeouts,
+	twoFactor TwoFactorConfig,
+	webAuthn *webauthn.WebAuthn,
+	lockout LockoutConfig,
+	passwords *PasswordPolicy,
 ) AuthService {
 	if sessionDuration <= 0 {
 		sessionDuration = DefaultSessionDuration
 	}
+	if twoFactor.Issuer == "" {
+		twoFactor.Issuer = DefaultTOTPIssuer
+	}
 	return &authService{
-		userRepo:        userRepo,
-		sessionRepo:     sessionRepo,
-		audit:           audit,
-		sessionDuration: sessionDuration,
+		userRepo:         userRepo,
+		sessionRepo:      sessionRepo,
+		oauthTokenRepo:   oauthTokenRepo,
+		challengeRepo:    challengeRepo,
+		recoveryCodeRepo: recoveryCodeRepo,
+		credentialRepo:   credentialRepo,
+		ceremonyRepo:     ceremonyRepo,
+		throttleRepo:     throttleRepo,
+		audit:            audit,
+		sessionDuration:  sessionDuration,
+		timeouts:         timeouts,
+		twoFactor:        twoFactor,
+		webAuthn:         webAuthn,
+		lockout:          withLockoutDefaults(lockout),
+		passwords:        passwords,
 	}
 }
 
@@ -69,30 +140,73 @@ func (s *authService) SessionDuration() time.Duration {
 	return s.sessionDuration
 }
 
-// Login authenticates a user and creates a session
+// Login authenticates a user and creates a session. Users with two-factor
+// authentication (a TOTP app or security keys, or whose role requires it)
+// get a login challenge instead, completed by VerifyLogin or
+// VerifyLoginWebAuthn.
+//
+// Failed logins, wrong second factors included, are counted per email
+// address and client IP; past the configured threshold either is locked out
+// with exponential backoff and Login returns ErrLoginLocked. The count is
+// only cleared once a session is issued.
 func (s *authService) Login(ctx context.Context, req *dto.LoginRequest) (*dto.LoginResponse, error) {
+	if err := s.checkLoginLock(ctx, req.Email); err != nil {
+		return nil, err
+	}
+
 	// Get user by email
 	user, err := s.userRepo.GetByEmail(ctx, req.Email)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Take as long as a wrong password would
+			s.passwords.hasher.Verify(req.Password, s.passwords.dummyHash())
 			s.audit.Log(ctx, nil, AuditLoginFailed, "user", "", map[string]any{"email": req.Email, "reason": "unknown email"})
+			if err := s.recordLoginFailure(ctx, req.Email); err != nil {
+				return nil, err
+			}
 			return nil, errors.New("invalid email or password")
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	// Check if user is enabled
-	if !user.Enabled {
-		s.audit.Log(ctx, nil, AuditLoginFailed, "user", fmt.Sprint(user.ID), map[string]any{"email": req.Email, "reason": "account disabled"})
-		return nil, errors.New("user account is disabled")
+	// Verify password, then whether the account is enabled. Both fail
+	// alike, so only the audit log tells which addresses have an account.
+	match, needsRehash := s.passwords.hasher.Verify(req.Password, user.PasswordHash)
+	if !match || !user.Enabled {
+		reason := "wrong password"
+		if match {
+			reason = "account disabled"
+		}
+		s.audit.Log(ctx, nil, AuditLoginFailed, "user", fmt.Sprint(user.ID), map[string]any{"email": req.Email, "reason": reason})
+		if err := s.recordLoginFailure(ctx, req.Email); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("invalid email or password")
 	}
 
-	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		s.audit.Log(ctx, nil, AuditLoginFailed, "user", fmt.Sprint(user.ID), map[string]any{"email": req.Email, "reason": "wrong password"})
-		return nil, errors.New("invalid email or password")
+	// Move the stored hash to the current algorithm and parameters while
+	// the password is at hand; failing that, it is tried again next login
+	if needsRehash {
+		if hash, err := s.passwords.hasher.Hash(req.Password); err == nil {
+			user.PasswordHash = hash
+			_ = s.userRepo.Update(ctx, user)
+		}
+	}
+
+	hasKeys, err := s.hasWebAuthnCredentials(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled || hasKeys || s.twoFactorRequired(user) {
+		return s.startLoginChallenge(ctx, user, hasKeys)
 	}
 
+	return s.createSession(ctx, user, nil)
+}
+
+// createSession logs a user in whose credentials have been verified. details
+// are added to the login_success audit entry.
+func (s *authService) createSession(ctx context.Context, user *model.User, details map[string]any) (*dto.LoginResponse, error) {
 	// Generate session ID
 	sessionID, err := generateSessionID()
 	if err != nil {
@@ -100,16 +214,16 @@ func (s *authService) Login(ctx context.Context, req *dto.LoginRequest) (*dto.Lo
 	}
 
 	// Create session
-	session := &model.Session{
-		ID:        sessionID,
-		UserID:    user.ID,
-		ExpiresAt: time.Now().Add(s.sessionDuration),
-	}
-
+	session := s.newSession(ctx, user, sessionID)
 	if err := s.sessionRepo.Create(ctx, session); err != nil {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
 
+	// Only now, with every factor checked: clearing them once the password
+	// checked out would let guesses at the second factor go on forever.
+	// Failing that, they are forgotten after the failure window.
+	_ = s.clearLoginFailures(ctx, user.Email)
+
 	// Update last login
 	now := time.Now()
 	user.LastLogin = &now
@@ -117,19 +231,15 @@ func (s *authService) Login(ctx context.Context, req *dto.LoginRequest) (*dto.Lo
 		// Log but don't fail
 	}
 
+	if details == nil {
+		details = map[string]any{}
+	}
+	details["email"] = user.Email
 	actorID := user.ID
-	s.audit.Log(ctx, &actorID, AuditLoginSuccess, "user", fmt.Sprint(user.ID), map[string]any{"email": user.Email})
+	s.audit.Log(ctx, &actorID, AuditLoginSuccess, "user", fmt.Sprint(user.ID), details)
 
 	return &dto.LoginResponse{
-		User: dto.UserResponse{
-			ID:        user.ID,
-			Name:      user.Name,
-			Email:     user.Email,
-			Enabled:   user.Enabled,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-			LastLogin: user.LastLogin,
-		},
+		User:      toAuthUserResponse(user),
 		SessionID: sessionID,
 		Message:   "Login successful",
 	}, nil
@@ -141,14 +251,18 @@ func (s *authService) Logout(ctx context.Context, sessionID string) error {
 		return errors.New("session ID is required")
 	}
 
+	id := hashSecret(sessionID)
 	var actorID *uint
-	if session, err := s.sessionRepo.GetByID(ctx, sessionID); err == nil {
+	if session, err := s.sessionRepo.GetByID(ctx, id); err == nil {
 		actorID = &session.UserID
 	}
 
-	if err := s.sessionRepo.Delete(ctx, sessionID); err != nil {
+	if err := s.sessionRepo.Delete(ctx, id); err != nil {
 		return fmt.Errorf("failed to delete session: %w", err)
 	}
+	if err := s.oauthTokenRepo.RevokeSession(ctx, id, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke the session's tokens: %w", err)
+	}
 
 	if actorID != nil {
 		s.audit.Log(ctx, actorID, AuditLogout, "user", fmt.Sprint(*actorID), nil)
@@ -157,7 +271,8 @@ func (s *authService) Logout(ctx context.Context, sessionID string) error {
 	return nil
 }
 
-// Register creates a new user with a password
+// Register creates a new user with a password, which must meet the
+// password policy
 func (s *authService) Register(ctx context.Context, req *dto.RegisterRequest) (*dto.UserResponse, error) {
 	// Check if email already exists
 	existingUser, err := s.userRepo.GetByEmail(ctx, req.Email)
@@ -165,18 +280,16 @@ func (s *authService) Register(ctx context.Context, req *dto.RegisterRequest) (*
 		return nil, errors.New("email already exists")
 	}
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), BcryptCost)
-	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %w", err)
-	}
-
 	// Create user
 	user := &model.User{
-		Name:         req.Name,
-		Email:        req.Email,
-		PasswordHash: string(hashedPassword),
-		Enabled:      true,
+		Name:    req.Name,
+		Email:   req.Email,
+		Enabled: true,
+		Role:    model.RoleUser,
+	}
+	user.PasswordHash, err = s.passwords.newPasswordHash(ctx, user, req.Password)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := s.userRepo.Create(ctx, user); err != nil {
@@ -190,6 +303,7 @@ func (s *authService) Register(ctx context.Context, req *dto.RegisterRequest) (*
 		Name:      user.Name,
 		Email:     user.Email,
 		Enabled:   user.Enabled,
+		Role:      string(user.Role),
 		CreatedAt: user.CreatedAt,
 		UpdatedAt: user.UpdatedAt,
 	}, nil
@@ -197,11 +311,27 @@ func (s *authService) Register(ctx context.Context, req *dto.RegisterRequest) (*
 
 // ValidateSession checks if a session is valid and returns the associated user
 func (s *authService) ValidateSession(ctx context.Context, sessionID string) (*model.User, error) {
+	session, err := s.validateSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &session.User, nil
+}
+
+// GetSession checks a session like ValidateSession and returns it, with its
+// user
+func (s *authService) GetSession(ctx context.Context, sessionID string) (*model.Session, error) {
+	return s.validateSession(ctx, sessionID)
+}
+
+// validateSession checks a session and, while it is valid, slides its expiry
+// and records it as seen
+func (s *authService) validateSession(ctx context.Context, sessionID string) (*model.Session, error) {
 	if sessionID == "" {
 		return nil, errors.New("session ID is required")
 	}
 
-	session, err := s.sessionRepo.GetByID(ctx, sessionID)
+	session, err := s.sessionRepo.GetByID(ctx, hashSecret(sessionID))
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, errors.New("invalid session")
@@ -212,7 +342,7 @@ func (s *authService) ValidateSession(ctx context.Context, sessionID string) (*m
 	// Check if session is expired
 	if session.IsExpired() {
 		// Delete expired session
-		_ = s.sessionRepo.Delete(ctx, sessionID)
+		_ = s.sessionRepo.Delete(ctx, session.ID)
 		return nil, errors.New("session expired")
 	}
 
@@ -221,39 +351,53 @@ func (s *authService) ValidateSession(ctx context.Context, sessionID string) (*m
 		return nil, errors.New("user account is disabled")
 	}
 
-	// Sliding expiration: push the expiry forward, but only once it has
-	// drifted more than slideThreshold behind the full window, so frequent
-	// validations don't cause a DB write each time.
-	newExpiry := time.Now().Add(s.sessionDuration)
-	if newExpiry.Sub(session.ExpiresAt) > slideThreshold {
-		if err := s.sessionRepo.UpdateExpiresAt(ctx, sessionID, newExpiry); err == nil {
+	// Sliding expiration within the role's idle window, capped by its
+	// maximum lifetime. Recomputing it also applies shortened timeouts and
+	// role changes to existing sessions.
+	now := time.Now()
+	role := session.User.Role
+	newExpiry := s.sessionExpiry(role, session.CreatedAt, now)
+	if !newExpiry.After(now) {
+		_ = s.sessionRepo.Delete(ctx, session.ID)
+		return nil, errors.New("session expired")
+	}
+	// Push the expiry forward only once it has drifted more than the
+	// threshold behind, so frequent validations don't cause a DB write each
+	// time; pulling it in is written right away.
+	threshold := min(slideThreshold, s.sessionWindow(role)/10)
+	if drift := newExpiry.Sub(session.ExpiresAt); drift > threshold || drift < 0 {
+		if err := s.sessionRepo.UpdateExpiresAt(ctx, session.ID, newExpiry); err == nil {
 			session.ExpiresAt = newExpiry
 		}
 	}
+	if session.LastSeenAt == nil || now.Sub(*session.LastSeenAt) > lastSeenThreshold {
+		_ = s.sessionRepo.UpdateLastSeenAt(ctx, session.ID, now)
+	}
 
-	return &session.User, nil
+	return session, nil
 }
 
-// GetUserBySession retrieves user info by session ID
+// GetUserBySession retrieves user info by session ID, with when the session
+// expires unless used again and when it ends regardless
 func (s *authService) GetUserBySession(ctx context.Context, sessionID string) (*dto.UserResponse, error) {
-	user, err := s.ValidateSession(ctx, sessionID)
+	session, err := s.validateSession(ctx, sessionID)
 	if err != nil {
 		return nil, err
 	}
 
-	return &dto.UserResponse{
-		ID:        user.ID,
-		Name:      user.Name,
-		Email:     user.Email,
-		Enabled:   user.Enabled,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
-		LastLogin: user.LastLogin,
-	}, nil
+	resp := toAuthUserResponse(&session.User)
+	resp.SessionExpiresAt = &session.ExpiresAt
+	resp.SessionAbsoluteExpiresAt = s.absoluteExpiry(session.User.Role, session.CreatedAt)
+	return resp, nil
 }
 
-// SetPassword sets a new password for a user
+// SetPassword sets a new password for a user, which must meet the password
+// policy
 func (s *authService) SetPassword(ctx context.Context, userID uint, password string) error {
+	if err := authorize(ctx, PermUsersSecurity); err != nil {
+		return err
+	}
+
 	user, err := s.userRepo.GetByID(ctx, userID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -262,12 +406,12 @@ func (s *authService) SetPassword(ctx context.Context, userID uint, password str
 		return fmt.Errorf("failed to get user: %w", err)
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), BcryptCost)
+	hashedPassword, err := s.passwords.newPasswordHash(ctx, user, password)
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
+		return err
 	}
 
-	user.PasswordHash = string(hashedPassword)
+	user.PasswordHash = hashedPassword
 	if err := s.userRepo.Update(ctx, user); err != nil {
 		return fmt.Errorf("failed to update password: %w", err)
 	}
@@ -277,18 +421,42 @@ func (s *authService) SetPassword(ctx context.Context, userID uint, password str
 	return nil
 }
 
-// ForceLogout deletes all sessions for a user (admin action)
+// ForceLogout deletes all sessions for a user and revokes the tokens apps
+// signed in with OpenID Connect hold for them (admin action)
 func (s *authService) ForceLogout(ctx context.Context, actorUserID *uint, userID uint) error {
+	if err := authorize(ctx, PermUsersSecurity); err != nil {
+		return err
+	}
+
 	if err := s.sessionRepo.DeleteByUserID(ctx, userID); err != nil {
 		return fmt.Errorf("failed to delete user sessions: %w", err)
 	}
+	if err := s.oauthTokenRepo.RevokeUser(ctx, userID, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke OAuth tokens: %w", err)
+	}
 
 	s.audit.Log(ctx, actorUserID, AuditForceLogout, "user", fmt.Sprint(userID), nil)
 
 	return nil
 }
 
-// generateSessionID generates a random session ID
+// toAuthUserResponse converts a model.User to dto.UserResponse
+func toAuthUserResponse(user *model.User) *dto.UserResponse {
+	return &dto.UserResponse{
+		ID:          user.ID,
+		Name:        user.Name,
+		Email:       user.Email,
+		Enabled:     user.Enabled,
+		Role:        string(user.Role),
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		LastLogin:   user.LastLogin,
+		TOTPEnabled: user.TOTPEnabled,
+	}
+}
+
+// generateSessionID generates a random session ID. Sessions are stored under
+// its hash (see hashSecret); the ID itself is only given to the client.
 func generateSessionID() (string, error) {
 	bytes := make([]byte, 32)
 	if _, err := rand.Read(bytes); err != nil {
@@ -296,3 +464,12 @@ func generateSessionID() (string, error) {
 	}
 	return hex.EncodeToString(bytes), nil
 }
+
+// hashSecret hashes a random secret, such as a session token, reset token,
+// recovery code, API key or OAuth code, for storage, so the database never
+// holds usable ones. The secrets are random, so a fast unsalted SHA-256 is
+// enough.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
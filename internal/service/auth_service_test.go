@@ -51,6 +51,25 @@ func (m *mockSessionRepository) GetByID(ctx context.Context, id string) (*model.
 	return session, nil
 }
 
+func (m *mockSessionRepository) GetActiveByUserID(ctx context.Context, userID uint) ([]model.Session, error) {
+	var sessions []model.Session
+	for _, session := range m.sessions {
+		if session.UserID == userID && !session.IsExpired() {
+			sessions = append(sessions, *session)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *mockSessionRepository) UpdateLastSeenAt(ctx context.Context, id string, lastSeenAt time.Time) error {
+	session, exists := m.sessions[id]
+	if !exists {
+		return gorm.ErrRecordNotFound
+	}
+	session.LastSeenAt = &lastSeenAt
+	return nil
+}
+
 func (m *mockSessionRepository) UpdateExpiresAt(ctx context.Context, id string, expiresAt time.Time) error {
 	session, exists := m.sessions[id]
 	if !exists {
@@ -74,20 +93,36 @@ func (m *mockSessionRepository) DeleteByUserID(ctx context.Context, userID uint)
 	return nil
 }
 
-func (m *mockSessionRepository) DeleteExpired(ctx context.Context) error {
+func (m *mockSessionRepository) PurgeExpired(ctx context.Context, before time.Time, limit int) (int64, error) {
+	var purged int64
 	for id, session := range m.sessions {
-		if session.IsExpired() {
+		if purged == int64(limit) {
+			break
+		}
+		if session.ExpiresAt.Before(before) {
 			delete(m.sessions, id)
+			purged++
 		}
 	}
-	return nil
+	return purged, nil
+}
+
+func (m *mockSessionRepository) RunExclusive(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	return true, fn(ctx)
 }
 
 func setupAuthService(t *testing.T, sessionDuration time.Duration) (AuthService, *mockUserRepository, *mockSessionRepository) {
+	t.Helper()
+	return setupAuthServiceWithTimeouts(t, sessionDuration, SessionTimeouts{})
+}
+
+func setupAuthServiceWithTimeouts(t *testing.T, sessionDuration time.Duration, timeouts SessionTimeouts) (AuthService, *mockUserRepository, *mockSessionRepository) {
 	t.Helper()
 	userRepo := newMockUserRepository()
 	sessionRepo := newMockSessionRepository(userRepo)
-	svc := NewAuthService(userRepo, sessionRepo, newNoopAudit(), sessionDuration)
+	svc := NewAuthService(userRepo, sessionRepo, newMockOAuthTokenRepository(), newMockLoginChallengeRepository(userRepo), newMockRecoveryCodeRepository(),
+		newMockWebAuthnCredentialRepository(), newMockWebAuthnCeremonyRepository(), newMockLoginThrottleRepository(), newNoopAudit(), sessionDuration, timeouts, TwoFactorConfig{},
+		newTestWebAuthn(t), LockoutConfig{}, newTestPasswordPolicy())
 
 	hash, err := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
 	if err != nil {
@@ -140,6 +175,26 @@ func TestLoginWrongPassword(t *testing.T) {
 	}
 }
 
+func TestLoginDisabledAccount(t *testing.T) {
+	svc, userRepo, _ := setupAuthService(t, 720*time.Hour)
+	ctx := context.Background()
+
+	user, _ := userRepo.GetByEmail(ctx, "test@example.com")
+	user.Enabled = false
+
+	// Disabled accounts fail like unknown ones, right password or not
+	for _, password := range []string{"secret123", "wrong"} {
+		_, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: password})
+		if err == nil || err.Error() != "invalid email or password" {
+			t.Errorf("Login(%q) error = %v, want the generic error", password, err)
+		}
+	}
+	_, err := svc.Login(ctx, &dto.LoginRequest{Email: "nobody@example.com", Password: "secret123"})
+	if err == nil || err.Error() != "invalid email or password" {
+		t.Errorf("Login(unknown email) error = %v, want the generic error", err)
+	}
+}
+
 func TestValidateSlidesExpiry(t *testing.T) {
 	svc, _, sessionRepo := setupAuthService(t, 720*time.Hour)
 
@@ -153,13 +208,13 @@ func TestValidateSlidesExpiry(t *testing.T) {
 
 	// Simulate a session whose expiry has drifted well behind the full window
 	staleExpiry := time.Now().Add(1 * time.Hour)
-	sessionRepo.sessions[resp.SessionID].ExpiresAt = staleExpiry
+	sessionRepo.sessions[hashSecret(resp.SessionID)].ExpiresAt = staleExpiry
 
 	if _, err := svc.ValidateSession(context.Background(), resp.SessionID); err != nil {
 		t.Fatalf("validate failed: %v", err)
 	}
 
-	newExpiry := sessionRepo.sessions[resp.SessionID].ExpiresAt
+	newExpiry := sessionRepo.sessions[hashSecret(resp.SessionID)].ExpiresAt
 	if !newExpiry.After(staleExpiry.Add(24 * time.Hour)) {
 		t.Errorf("expected expiry to slide forward, got %v (was %v)", newExpiry, staleExpiry)
 	}
@@ -177,11 +232,11 @@ func TestValidateFreshSessionDoesNotWrite(t *testing.T) {
 	}
 
 	// A just-created session is within the slide threshold: expiry unchanged
-	before := sessionRepo.sessions[resp.SessionID].ExpiresAt
+	before := sessionRepo.sessions[hashSecret(resp.SessionID)].ExpiresAt
 	if _, err := svc.ValidateSession(context.Background(), resp.SessionID); err != nil {
 		t.Fatalf("validate failed: %v", err)
 	}
-	after := sessionRepo.sessions[resp.SessionID].ExpiresAt
+	after := sessionRepo.sessions[hashSecret(resp.SessionID)].ExpiresAt
 	if !after.Equal(before) {
 		t.Errorf("expected expiry unchanged for fresh session, got %v (was %v)", after, before)
 	}
@@ -198,12 +253,12 @@ func TestValidateExpiredSession(t *testing.T) {
 		t.Fatalf("login failed: %v", err)
 	}
 
-	sessionRepo.sessions[resp.SessionID].ExpiresAt = time.Now().Add(-time.Minute)
+	sessionRepo.sessions[hashSecret(resp.SessionID)].ExpiresAt = time.Now().Add(-time.Minute)
 
 	if _, err := svc.ValidateSession(context.Background(), resp.SessionID); err == nil {
 		t.Fatal("expected expired session to be rejected")
 	}
-	if _, exists := sessionRepo.sessions[resp.SessionID]; exists {
+	if _, exists := sessionRepo.sessions[hashSecret(resp.SessionID)]; exists {
 		t.Error("expected expired session to be deleted")
 	}
 }
@@ -219,7 +274,7 @@ func TestForceLogout(t *testing.T) {
 		t.Fatalf("login failed: %v", err)
 	}
 
-	if err := svc.ForceLogout(context.Background(), nil, 1); err != nil {
+	if err := svc.ForceLogout(adminContext(), nil, 1); err != nil {
 		t.Fatalf("force logout failed: %v", err)
 	}
 
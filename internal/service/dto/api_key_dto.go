@@ -0,0 +1,31 @@
+package dto
+
+import "time"
+
+// CreateAPIKeyRequest mints a key for another service. ExpiresInDays of 0
+// means the key doesn't expire.
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" binding:"required" example:"transactions"`
+	Scopes        []string `json:"scopes" binding:"required" example:"flags:read"`
+	ExpiresInDays int      `json:"expires_in_days" example:"90"`
+}
+
+// APIKeyResponse is an API key; the key itself is only ever shown when it is
+// minted, Prefix identifies it afterwards
+type APIKeyResponse struct {
+	ID         uint       `json:"id" example:"1"`
+	Name       string     `json:"name" example:"transactions"`
+	Prefix     string     `json:"prefix" example:"idk_3f2a9c0d"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Active     bool       `json:"active" example:"true"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// APIKeySecretResponse is a newly minted key, which can't be retrieved later
+type APIKeySecretResponse struct {
+	APIKey APIKeyResponse `json:"api_key"`
+	Key    string         `json:"key"`
+}
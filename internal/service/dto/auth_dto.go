@@ -1,26 +1,105 @@
 package dto
 
+import (
+	"identity/pkg/api"
+	"time"
+)
+
 // LoginRequest represents the request to login
 type LoginRequest struct {
 	Email    string `json:"email" form:"email" binding:"required,email" example:"john@example.com"`
 	Password string `json:"password" form:"password" binding:"required" example:"password123"`
 }
 
-// LoginResponse represents the response after successful login
+// LoginResponse represents the response after successful login. When the
+// user must still pass two-factor authentication, TwoFactorRequired is set
+// and ChallengeToken is returned instead of a session, to be completed with
+// POST /api/v1/auth/login/verify (or, with a security key,
+// /api/v1/auth/login/webauthn/finish); TwoFactorMethods lists what the user
+// can use: "totp", "recovery_code" and "webauthn". EnrollmentRequired means
+// the user's role requires two-factor authentication but they have not set
+// it up yet.
 type LoginResponse struct {
-	User      UserResponse `json:"user"`
-	SessionID string       `json:"session_id"`
-	Message   string       `json:"message"`
+	User               *UserResponse `json:"user,omitempty"`
+	SessionID          string        `json:"session_id"`
+	Message            string        `json:"message"`
+	TwoFactorRequired  bool          `json:"two_factor_required,omitempty"`
+	EnrollmentRequired bool          `json:"enrollment_required,omitempty"`
+	TwoFactorMethods   []string      `json:"two_factor_methods,omitempty"`
+	ChallengeToken     string        `json:"challenge_token,omitempty"`
+	// RecoveryCodes are returned once, when the login completed enrollment
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// VerifyLoginRequest completes a login with a TOTP or recovery code
+type VerifyLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" form:"challenge_token" binding:"required"`
+	Code           string `json:"code" form:"code" binding:"required" example:"123456"`
+}
+
+// LoginEnrollmentRequest starts TOTP enrollment for a login whose user must
+// set up two-factor authentication
+type LoginEnrollmentRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+}
+
+// TOTPEnrollmentResponse is what an authenticator app needs: the secret, as
+// an otpauth:// URI and as a QR code (a PNG data URI) of that URI
+type TOTPEnrollmentResponse struct {
+	Secret     string `json:"secret" example:"JBSWY3DPEHPK3PXP"`
+	OTPAuthURI string `json:"otpauth_uri" example:"otpauth://totp/Identity:john@example.com?issuer=Identity&secret=JBSWY3DPEHPK3PXP"`
+	QRCode     string `json:"qr_code" example:"data:image/png;base64,iVBORw0KGgo..."`
+}
+
+// TOTPCodeRequest carries a current TOTP (or, where accepted, recovery) code
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required" example:"123456"`
+}
+
+// RecoveryCodesResponse lists newly issued recovery codes, shown only once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
 }
 
 // RegisterRequest represents the request to register a new user
 type RegisterRequest struct {
 	Name     string `json:"name" form:"name" binding:"required" example:"John Doe"`
 	Email    string `json:"email" form:"email" binding:"required,email" example:"john@example.com"`
-	Password string `json:"password" form:"password" binding:"required,min=6" example:"password123"`
+	Password string `json:"password" form:"password" binding:"required" example:"correct-horse-battery"`
 }
 
 // ValidateSessionRequest represents the request to validate a session
-type ValidateSessionRequest struct {
-	SessionID string `json:"session_id" example:"abc123"`
+type ValidateSessionRequest = api.ValidateSessionRequest
+
+// ForgotPasswordRequest asks for a password reset link to be emailed
+type ForgotPasswordRequest struct {
+	Email string `json:"email" form:"email" binding:"required,email" example:"john@example.com"`
+}
+
+// ResetPasswordRequest sets a new password with an emailed reset token. The
+// password must meet the password policy.
+type ResetPasswordRequest struct {
+	Token    string `json:"token" form:"token" binding:"required" example:"9f86d081884c7d65…"`
+	Password string `json:"password" form:"password" binding:"required" example:"correct-horse-battery"`
+}
+
+// LoginLockoutResponse is a user's failed login count and, while their
+// account is locked out, when it unlocks
+type LoginLockoutResponse struct {
+	FailedAttempts int        `json:"failed_attempts" example:"5"`
+	Locked         bool       `json:"locked" example:"true"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+}
+
+// SessionResponse is one of a user's sessions. ID identifies it for
+// revocation; it is not the session token and can't be used to log in.
+type SessionResponse struct {
+	ID         string     `json:"id" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	Device     string     `json:"device" example:"Firefox on macOS"`
+	IPAddress  string     `json:"ip_address" example:"203.0.113.7"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
 }
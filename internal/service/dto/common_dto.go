@@ -1,10 +1,9 @@
 package dto
 
+import "identity/pkg/api"
+
 // ErrorResponse represents an error response
-type ErrorResponse struct {
-	Error   string `json:"error" example:"Invalid request"`
-	Message string `json:"message" example:"The request body is invalid"`
-}
+type ErrorResponse = api.ErrorResponse
 
 // SuccessResponse represents a generic success response
 type SuccessResponse struct {
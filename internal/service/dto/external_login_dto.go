@@ -0,0 +1,22 @@
+package dto
+
+import "time"
+
+// ExternalProviderResponse is an external identity provider users can sign
+// in with
+type ExternalProviderResponse struct {
+	ID   string `json:"id" example:"google"`
+	Name string `json:"name" example:"Google"`
+}
+
+// UserIdentityResponse describes a user's account at an external identity
+// provider. Subject is the provider's ID for the account; Email is the one
+// it reported on the last login.
+type UserIdentityResponse struct {
+	ID          uint       `json:"id" example:"1"`
+	Provider    string     `json:"provider" example:"google"`
+	Subject     string     `json:"subject" example:"110169484474386276334"`
+	Email       string     `json:"email" example:"john@example.com"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
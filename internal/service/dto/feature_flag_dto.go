@@ -1,30 +1,52 @@
 package dto
 
 import (
+	"identity/pkg/api"
 	"time"
 )
 
+// Flag, evaluation and flag stream types shared with the client SDK
+type (
+	FeatureFlagResponse        = api.FeatureFlagResponse
+	TargetingCondition         = api.TargetingCondition
+	TargetingRule              = api.TargetingRule
+	FlagVariant                = api.FlagVariant
+	FlagPrerequisite           = api.FlagPrerequisite
+	EvaluationContext          = api.EvaluationContext
+	EvaluateFeatureFlagRequest = api.EvaluateFeatureFlagRequest
+	BulkEvaluateRequest        = api.BulkEvaluateRequest
+	BulkEvaluationResponse     = api.BulkEvaluationResponse
+	FlagEvaluationResponse     = api.FlagEvaluationResponse
+	FlagStreamEvent            = api.FlagStreamEvent
+	FlagSnapshot               = api.FlagSnapshot
+	FlagDeletedEvent           = api.FlagDeletedEvent
+	FlagAssignmentEvent        = api.FlagAssignmentEvent
+	HeartbeatEvent             = api.HeartbeatEvent
+)
+
 // CreateFeatureFlagRequest represents the request to create a new feature flag
 type CreateFeatureFlagRequest struct {
-	Key         string `json:"key" binding:"required" example:"dark_mode"`
-	Description string `json:"description" example:"Enable dark mode interface"`
-	Enabled     bool   `json:"enabled" example:"true"`
+	Key               string             `json:"key" binding:"required" example:"dark_mode"`
+	Description       string             `json:"description" example:"Enable dark mode interface"`
+	Enabled           bool               `json:"enabled" example:"true"`
+	RolloutPercentage int                `json:"rollout_percentage" binding:"min=0,max=100" example:"25"`
+	Rules             []TargetingRule    `json:"rules,omitempty"`
+	VariantType       string             `json:"variant_type,omitempty" binding:"omitempty,oneof=boolean string number json" example:"string"`
+	Variants          []FlagVariant      `json:"variants,omitempty"`
+	OffVariant        string             `json:"off_variant,omitempty" example:"classic"`
+	Prerequisites     []FlagPrerequisite `json:"prerequisites,omitempty"`
 }
 
 // UpdateFeatureFlagRequest represents the request to update a feature flag
 type UpdateFeatureFlagRequest struct {
-	Description *string `json:"description,omitempty" example:"Enable dark mode interface"`
-	Enabled     *bool   `json:"enabled,omitempty" example:"true"`
-}
-
-// FeatureFlagResponse represents the response for a feature flag
-type FeatureFlagResponse struct {
-	ID          uint      `json:"id" example:"1"`
-	Key         string    `json:"key" example:"dark_mode"`
-	Description string    `json:"description" example:"Enable dark mode interface"`
-	Enabled     bool      `json:"enabled" example:"true"`
-	CreatedAt   time.Time `json:"created_at" example:"2024-01-01T00:00:00Z"`
-	UpdatedAt   time.Time `json:"updated_at" example:"2024-01-01T00:00:00Z"`
+	Description       *string             `json:"description,omitempty" example:"Enable dark mode interface"`
+	Enabled           *bool               `json:"enabled,omitempty" example:"true"`
+	RolloutPercentage *int                `json:"rollout_percentage,omitempty" binding:"omitempty,min=0,max=100" example:"25"`
+	Rules             *[]TargetingRule    `json:"rules,omitempty"`
+	VariantType       *string             `json:"variant_type,omitempty" binding:"omitempty,oneof=boolean string number json" example:"string"`
+	Variants          *[]FlagVariant      `json:"variants,omitempty"`
+	OffVariant        *string             `json:"off_variant,omitempty" example:"classic"`
+	Prerequisites     *[]FlagPrerequisite `json:"prerequisites,omitempty"`
 }
 
 // FeatureFlagListResponse represents a paginated list of feature flags
@@ -35,3 +57,50 @@ type FeatureFlagListResponse struct {
 	PageSize     int                   `json:"page_size" example:"10"`
 	TotalPages   int                   `json:"total_pages" example:"5"`
 }
+
+// UserFeatureFlagResponse is a flag assigned to a user, with the variant the
+// assignment pins (empty: the flag's weighted distribution)
+type UserFeatureFlagResponse struct {
+	FeatureFlagResponse
+	AssignedVariant string `json:"assigned_variant,omitempty" example:"compact"`
+}
+
+// AssignFeatureFlagRequest is the optional body of a per-user flag assignment
+type AssignFeatureFlagRequest struct {
+	Variant string `json:"variant,omitempty" example:"compact"`
+}
+
+// Flag stream event types (the SSE "event" field)
+const (
+	FlagStreamSnapshot    = api.FlagStreamSnapshot
+	FlagStreamFlag        = api.FlagStreamFlag
+	FlagStreamFlagDeleted = api.FlagStreamFlagDeleted
+	FlagStreamAssignment  = api.FlagStreamAssignment
+	FlagStreamHeartbeat   = api.FlagStreamHeartbeat
+)
+
+// ScheduleFlagChangeRequest schedules a change to a flag. Action is enable,
+// disable or set_rollout (which needs rollout_percentage); run_at must be in
+// the future.
+type ScheduleFlagChangeRequest struct {
+	Action            string    `json:"action" binding:"required,oneof=enable disable set_rollout" example:"enable"`
+	RolloutPercentage *int      `json:"rollout_percentage,omitempty" binding:"omitempty,min=0,max=100" example:"50"`
+	RunAt             time.Time `json:"run_at" binding:"required" example:"2026-01-01T00:00:00Z"`
+}
+
+// ScheduledFlagChangeResponse is a scheduled flag change. Status is pending,
+// done, cancelled or failed (with Error saying why).
+type ScheduledFlagChangeResponse struct {
+	ID                uint       `json:"id" example:"1"`
+	FlagID            uint       `json:"flag_id" example:"1"`
+	FlagKey           string     `json:"flag_key" example:"dark_mode"`
+	Action            string     `json:"action" example:"set_rollout"`
+	RolloutPercentage *int       `json:"rollout_percentage,omitempty" example:"50"`
+	RunAt             time.Time  `json:"run_at" example:"2026-01-01T00:00:00Z"`
+	Status            string     `json:"status" example:"pending"`
+	CreatedBy         *uint      `json:"created_by,omitempty" example:"1"`
+	CreatedByName     string     `json:"created_by_name,omitempty" example:"Admin"`
+	ExecutedAt        *time.Time `json:"executed_at,omitempty"`
+	Error             string     `json:"error,omitempty"`
+	CreatedAt         time.Time  `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
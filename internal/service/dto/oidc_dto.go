@@ -0,0 +1,131 @@
+package dto
+
+import (
+	"identity/pkg/api"
+	"time"
+)
+
+// Signing keys, as the client SDK verifies session tokens with them
+type (
+	JWK  = api.JWK
+	JWKS = api.JWKS
+)
+
+// OIDCDiscovery is the OpenID Connect discovery document, served at
+// /.well-known/openid-configuration
+type OIDCDiscovery struct {
+	Issuer                                 string   `json:"issuer" example:"https://id.example.com"`
+	AuthorizationEndpoint                  string   `json:"authorization_endpoint"`
+	TokenEndpoint                          string   `json:"token_endpoint"`
+	UserInfoEndpoint                       string   `json:"userinfo_endpoint"`
+	RevocationEndpoint                     string   `json:"revocation_endpoint"`
+	JWKSURI                                string   `json:"jwks_uri"`
+	ResponseTypesSupported                 []string `json:"response_types_supported"`
+	ResponseModesSupported                 []string `json:"response_modes_supported"`
+	GrantTypesSupported                    []string `json:"grant_types_supported"`
+	SubjectTypesSupported                  []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported       []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                        []string `json:"scopes_supported"`
+	ClaimsSupported                        []string `json:"claims_supported"`
+	TokenEndpointAuthMethodsSupported      []string `json:"token_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethodsSupported []string `json:"revocation_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported          []string `json:"code_challenge_methods_supported"`
+	PromptValuesSupported                  []string `json:"prompt_values_supported"`
+	AuthorizationResponseIssParameter      bool     `json:"authorization_response_iss_parameter_supported"`
+}
+
+// AuthorizeRequest is an OAuth 2.0 authorization request, from the query of
+// GET /oauth2/authorize or the consent form posted back to it. Only the
+// authorization code flow with PKCE (S256) is supported.
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type" example:"code"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope" example:"openid profile email"`
+	State               string `form:"state"`
+	Nonce               string `form:"nonce"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" example:"S256"`
+	// Prompt is "none" to fail rather than show a page, or any of "login"
+	// and "consent" to sign in or ask for consent again
+	Prompt string `form:"prompt"`
+	// MaxAge is the longest time in seconds since the user signed in that
+	// the client accepts
+	MaxAge string `form:"max_age"`
+}
+
+// TokenRequest is a request to the token endpoint: exchanging an
+// authorization code (grant_type authorization_code) or a refresh token
+// (grant_type refresh_token). Clients authenticate with HTTP Basic or the
+// client_id and client_secret fields; public clients send client_id only.
+type TokenRequest struct {
+	GrantType    string `form:"grant_type" example:"authorization_code"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+// TokenResponse is the token endpoint's answer. The refresh token replaces
+// the one used, if any, which stops working.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type" example:"Bearer"`
+	ExpiresIn    int    `json:"expires_in" example:"3600"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	Scope        string `json:"scope" example:"openid profile email"`
+}
+
+// RevokeTokenRequest revokes an access or refresh token (RFC 7009); client
+// authentication is as for TokenRequest
+type RevokeTokenRequest struct {
+	Token         string `form:"token" binding:"required"`
+	TokenTypeHint string `form:"token_type_hint" example:"refresh_token"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+// UserInfoResponse holds the claims about the user the access token's
+// scopes allow: name with profile, email with email and role with roles
+type UserInfoResponse struct {
+	Sub   string `json:"sub" example:"1"`
+	Name  string `json:"name,omitempty" example:"John Doe"`
+	Email string `json:"email,omitempty" example:"john@example.com"`
+	Role  string `json:"role,omitempty" example:"user"`
+}
+
+// OAuthErrorResponse is an error as OAuth 2.0 defines them, e.g.
+// invalid_grant
+type OAuthErrorResponse struct {
+	Error            string `json:"error" example:"invalid_grant"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// CreateOAuthClientRequest registers an app with the OpenID Connect
+// provider. Public clients get no secret.
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name" binding:"required" example:"Wiki"`
+	RedirectURIs []string `json:"redirect_uris" binding:"required" example:"https://wiki.example.com/oauth/callback"`
+	Public       bool     `json:"public" example:"false"`
+}
+
+// OAuthClientResponse is a registered app; its secret is only ever shown
+// when it is created or replaced
+type OAuthClientResponse struct {
+	ID           uint      `json:"id" example:"1"`
+	ClientID     string    `json:"client_id" example:"3f2a9c0d8e7b6a5f4e3d2c1b0a998877"`
+	Name         string    `json:"name" example:"Wiki"`
+	Public       bool      `json:"public" example:"false"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// OAuthClientSecretResponse is a client along with its new secret, which
+// can't be retrieved later
+type OAuthClientSecretResponse struct {
+	Client       OAuthClientResponse `json:"client"`
+	ClientSecret string              `json:"client_secret,omitempty"`
+}
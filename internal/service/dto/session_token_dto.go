@@ -0,0 +1,12 @@
+package dto
+
+import "identity/pkg/api"
+
+// SessionTokenType is the "typ" header of session tokens
+const SessionTokenType = api.SessionTokenType
+
+// Session tokens, shared with the client SDK that verifies them
+type (
+	SessionTokenResponse = api.SessionTokenResponse
+	SessionTokenClaims   = api.SessionTokenClaims
+)
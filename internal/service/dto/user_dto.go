@@ -1,7 +1,11 @@
 package dto
 
-import (
-	"time"
+import "identity/pkg/api"
+
+// Users as the client SDK sees them
+type (
+	UserResponse       = api.UserResponse
+	PublicUserResponse = api.PublicUserResponse
 )
 
 // CreateUserRequest represents the request to create a new user
@@ -9,6 +13,7 @@ type CreateUserRequest struct {
 	Name    string `json:"name" binding:"required" example:"John Doe"`
 	Email   string `json:"email" binding:"required,email" example:"john@example.com"`
 	Enabled bool   `json:"enabled" example:"true"`
+	Role    string `json:"role,omitempty" binding:"omitempty,oneof=admin flag-editor viewer user" example:"user"`
 }
 
 // UpdateUserRequest represents the request to update a user
@@ -16,25 +21,7 @@ type UpdateUserRequest struct {
 	Name    *string `json:"name,omitempty" example:"John Doe"`
 	Email   *string `json:"email,omitempty" example:"john@example.com"`
 	Enabled *bool   `json:"enabled,omitempty" example:"true"`
-}
-
-// UserResponse represents the response for a user
-type UserResponse struct {
-	ID        uint       `json:"id" example:"1"`
-	Name      string     `json:"name" example:"John Doe"`
-	Email     string     `json:"email" example:"john@example.com"`
-	Enabled   bool       `json:"enabled" example:"true"`
-	CreatedAt time.Time  `json:"created_at" example:"2024-01-01T00:00:00Z"`
-	UpdatedAt time.Time  `json:"updated_at" example:"2024-01-01T00:00:00Z"`
-	LastLogin *time.Time `json:"last_login,omitempty" example:"2024-01-01T00:00:00Z"`
-}
-
-// PublicUserResponse is the minimal, non-sensitive projection of a user
-// exposed to other services on the internal network (e.g. resolving the
-// creator of a transaction). It deliberately omits email and audit fields.
-type PublicUserResponse struct {
-	ID   uint   `json:"id" example:"1"`
-	Name string `json:"name" example:"John Doe"`
+	Role    *string `json:"role,omitempty" binding:"omitempty,oneof=admin flag-editor viewer user" example:"viewer"`
 }
 
 // UserListResponse represents a paginated list of users
@@ -0,0 +1,57 @@
+package dto
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebAuthnOptionsResponse starts a WebAuthn ceremony. Options (with its
+// binary fields base64url encoded) is for navigator.credentials.create() or
+// navigator.credentials.get(); the authenticator's answer is sent back along
+// with CeremonyToken, or for a login's security key step, the challenge
+// token.
+type WebAuthnOptionsResponse struct {
+	CeremonyToken string `json:"ceremony_token,omitempty"`
+	Options       any    `json:"options" swaggertype:"object"`
+}
+
+// FinishWebAuthnRegistrationRequest completes registering a passkey or
+// security key. Credential is the PublicKeyCredential returned by
+// navigator.credentials.create(), serialized as JSON.
+type FinishWebAuthnRegistrationRequest struct {
+	CeremonyToken string          `json:"ceremony_token" binding:"required"`
+	Name          string          `json:"name" binding:"max=100" example:"YubiKey 5C"`
+	Credential    json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+}
+
+// FinishPasskeyLoginRequest completes a passwordless login. Credential is
+// the PublicKeyCredential returned by navigator.credentials.get(),
+// serialized as JSON.
+type FinishPasskeyLoginRequest struct {
+	CeremonyToken string          `json:"ceremony_token" binding:"required"`
+	Credential    json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+}
+
+// LoginWebAuthnRequest starts the security key step of a login
+type LoginWebAuthnRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+}
+
+// VerifyLoginWebAuthnRequest completes a login with a security key instead
+// of a code
+type VerifyLoginWebAuthnRequest struct {
+	ChallengeToken string          `json:"challenge_token" binding:"required"`
+	Credential     json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+}
+
+// WebAuthnCredentialResponse describes a registered passkey or security key.
+// Synced passkeys are backed up by their provider (e.g. iCloud Keychain)
+// and usable from the user's other devices.
+type WebAuthnCredentialResponse struct {
+	ID         uint       `json:"id" example:"1"`
+	Name       string     `json:"name" example:"YubiKey 5C"`
+	Transports []string   `json:"transports,omitempty" example:"usb,nfc"`
+	Synced     bool       `json:"synced"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
@@ -0,0 +1,701 @@
+package service
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/internal/model"
+	"identity/internal/repository"
+	"identity/internal/service/dto"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// externalLoginTTL is how long users have to sign in at the provider
+	externalLoginTTL = 10 * time.Minute
+	// externalDiscoveryTTL is how long a provider's discovery document is
+	// cached
+	externalDiscoveryTTL = time.Hour
+	// externalKeyRefetchInterval bounds how often a provider's signing keys
+	// are refetched for ID tokens naming a key that isn't known yet
+	externalKeyRefetchInterval = time.Minute
+	// externalRequestTimeout bounds each request to a provider
+	externalRequestTimeout = 10 * time.Second
+	// externalLoginScope is what logins ask providers for: the account's
+	// ID, email address and name
+	externalLoginScope = "openid email profile"
+)
+
+var (
+	// ErrUnknownLoginProvider is returned for providers that aren't
+	// configured
+	ErrUnknownLoginProvider = errors.New("unknown login provider")
+	// ErrInvalidExternalLogin is returned when the provider sends back a
+	// state that is unknown, expired or already used
+	ErrInvalidExternalLogin = errors.New("invalid or expired external login")
+	// ErrExternalLoginFailed matches the errors returned when the provider's
+	// answer can't be verified, whose message gives the reason
+	ErrExternalLoginFailed = errors.New("external login failed")
+	// ErrExternalEmailUnverified is returned for new identities whose email
+	// address the provider hasn't verified, which can't be linked to a user
+	ErrExternalEmailUnverified = errors.New("the provider has not verified this email address")
+	// ErrExternalAccountNotFound is returned for new identities without a
+	// user to link to when the provider doesn't provision users
+	ErrExternalAccountNotFound = errors.New("no account matches this sign-in")
+	// ErrExternalDomainNotAllowed is returned for new identities whose email
+	// address isn't in one of the provider's allowed domains
+	ErrExternalDomainNotAllowed = errors.New("this email address may not sign in with this provider")
+	// ErrExternalAccountNotLinked is returned for new identities whose email
+	// address belongs to a user when the provider doesn't link accounts
+	ErrExternalAccountNotLinked = errors.New("an account with this email address exists, but isn't linked to this sign-in")
+)
+
+// externalProviderIDPattern is what provider IDs look like; they appear in
+// URLs and environment variable names
+var externalProviderIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,49}$`)
+
+// ExternalLoginService signs users in with external OpenID Connect providers
+// (e.g. Google), as a relying party using the authorization code flow with
+// PKCE. Each provider account is linked to a user on its first login: if
+// the provider allows, to the user with the email address the provider
+// verified, or to a user created for it.
+type ExternalLoginService interface {
+	Providers() []dto.ExternalProviderResponse
+	BeginLogin(ctx context.Context, providerID, returnTo string) (redirectURL, state string, err error)
+	FinishLogin(ctx context.Context, state, code string) (*dto.LoginResponse, string, error)
+
+	// Linked identities
+	GetUserIdentities(ctx context.Context, userID uint) ([]dto.UserIdentityResponse, error)
+	UnlinkIdentity(ctx context.Context, userID, identityID uint) error
+}
+
+// ExternalProviderConfig configures an external identity provider
+type ExternalProviderConfig struct {
+	// ID names the provider in URLs and linked identities, e.g. google
+	ID string
+	// Name is shown on the login button
+	Name string
+	// Issuer is the provider's issuer URL, where its discovery document is
+	// found, e.g. https://accounts.google.com
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// AllowedDomains are the email domains accounts may sign in from for
+	// the first time, e.g. example.com; "*" allows any. Required, as most
+	// providers let anyone sign up.
+	AllowedDomains []string
+	// Provision creates users for accounts without a user to link to;
+	// otherwise they can't sign in
+	Provision bool
+	// LinkExisting links accounts to the user with the same email address
+	// on their first login; otherwise such accounts can't sign in. Whoever
+	// controls the address at the provider gets the user, admins included.
+	LinkExisting bool
+}
+
+// ExternalLoginConfig configures logins with external identity providers
+type ExternalLoginConfig struct {
+	// CallbackURL is where providers send users back to, registered as the
+	// redirect URI with each of them
+	CallbackURL string
+	Providers   []ExternalProviderConfig
+}
+
+// externalLoginService implements ExternalLoginService
+type externalLoginService struct {
+	identityRepo repository.UserIdentityRepository
+	stateRepo    repository.ExternalLoginStateRepository
+	userRepo     repository.UserRepository
+	authService  AuthService
+	audit        AuditLogger
+	callbackURL  string
+	providers    []*externalProvider
+	client       *http.Client
+}
+
+// externalProvider is a configured provider with the discovery document and
+// signing keys fetched from it
+type externalProvider struct {
+	cfg ExternalProviderConfig
+
+	mu           sync.Mutex
+	discovery    *dto.OIDCDiscovery
+	discoveredAt time.Time
+	keys         map[string]*rsa.PublicKey
+	keysAt       time.Time
+}
+
+// externalIDTokenClaims are the ID token claims a login uses.
+// EmailVerified is a boolean, but some providers send it as a string.
+type externalIDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// emailVerified reports whether the provider verified the email address
+func (c *externalIDTokenClaims) emailVerified() bool {
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// NewExternalLoginService creates a new external login service. Without
+// providers no one can sign in with it, but identities linked before can
+// still be listed and unlinked.
+func NewExternalLoginService(
+	identityRepo repository.UserIdentityRepository,
+	stateRepo repository.ExternalLoginStateRepository,
+	userRepo repository.UserRepository,
+	authService AuthService,
+	audit AuditLogger,
+	cfg ExternalLoginConfig,
+) (ExternalLoginService, error) {
+	var providers []*externalProvider
+	for _, provider := range cfg.Providers {
+		// callback would be shadowed by the callback route
+		if !externalProviderIDPattern.MatchString(provider.ID) || provider.ID == "callback" {
+			return nil, fmt.Errorf("invalid login provider ID %q, want lowercase letters, digits and dashes", provider.ID)
+		}
+		if slices.ContainsFunc(providers, func(p *externalProvider) bool { return p.cfg.ID == provider.ID }) {
+			return nil, fmt.Errorf("login provider %q is configured twice", provider.ID)
+		}
+		issuer, err := url.Parse(provider.Issuer)
+		if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" || issuer.RawQuery != "" || issuer.Fragment != "" {
+			return nil, fmt.Errorf("invalid issuer %q for login provider %q, want an http(s) URL without query or fragment", provider.Issuer, provider.ID)
+		}
+		if provider.ClientID == "" {
+			return nil, fmt.Errorf("login provider %q has no client ID", provider.ID)
+		}
+		if len(provider.AllowedDomains) == 0 {
+			return nil, fmt.Errorf("login provider %q has no allowed domains, list them or allow any with *", provider.ID)
+		}
+		if provider.Name == "" {
+			provider.Name = provider.ID
+		}
+		providers = append(providers, &externalProvider{cfg: provider})
+	}
+	if len(providers) > 0 {
+		callback, err := url.Parse(cfg.CallbackURL)
+		if err != nil || (callback.Scheme != "https" && callback.Scheme != "http") || callback.Host == "" {
+			return nil, fmt.Errorf("invalid login callback URL %q, want an http(s) URL", cfg.CallbackURL)
+		}
+	}
+
+	return &externalLoginService{
+		identityRepo: identityRepo,
+		stateRepo:    stateRepo,
+		userRepo:     userRepo,
+		authService:  authService,
+		audit:        audit,
+		callbackURL:  cfg.CallbackURL,
+		providers:    providers,
+		client:       &http.Client{Timeout: externalRequestTimeout},
+	}, nil
+}
+
+// Providers returns the providers users can sign in with, in the
+// configured order
+func (s *externalLoginService) Providers() []dto.ExternalProviderResponse {
+	providers := make([]dto.ExternalProviderResponse, len(s.providers))
+	for i, provider := range s.providers {
+		providers[i] = dto.ExternalProviderResponse{ID: provider.cfg.ID, Name: provider.cfg.Name}
+	}
+	return providers
+}
+
+// BeginLogin starts a login with a provider, returning the URL to send the
+// user to and the state the provider sends back. Callers tie the state to
+// the browser (e.g. in a cookie) and check it before FinishLogin, so a login
+// can't be completed in someone else's browser. returnTo is handed back by
+// FinishLogin.
+func (s *externalLoginService) BeginLogin(ctx context.Context, providerID, returnTo string) (string, string, error) {
+	provider := s.provider(providerID)
+	if provider == nil {
+		return "", "", ErrUnknownLoginProvider
+	}
+	discovery, err := s.discover(ctx, provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		if secrets[i], err = generateSessionID(); err != nil {
+			return "", "", fmt.Errorf("failed to generate login state: %w", err)
+		}
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	login := &model.ExternalLoginState{
+		ID:           hashSecret(state),
+		Provider:     provider.cfg.ID,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ReturnTo:     returnTo,
+		ExpiresAt:    time.Now().Add(externalLoginTTL),
+	}
+	if err := s.stateRepo.Create(ctx, login); err != nil {
+		return "", "", fmt.Errorf("failed to create login state: %w", err)
+	}
+
+	redirect, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: invalid authorization endpoint: %v", ErrExternalLoginFailed, err)
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := redirect.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.cfg.ClientID)
+	query.Set("redirect_uri", s.callbackURL)
+	query.Set("scope", externalLoginScope)
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	redirect.RawQuery = query.Encode()
+
+	return redirect.String(), state, nil
+}
+
+// FinishLogin completes a login with the code the provider sent back,
+// logging in the user the provider account is linked to, or links or
+// creates one on the account's first login. Users with two-factor
+// authentication get a login challenge, as with a password. It also returns
+// the login's returnTo.
+func (s *externalLoginService) FinishLogin(ctx context.Context, state, code string) (*dto.LoginResponse, string, error) {
+	login, err := s.stateRepo.Take(ctx, hashSecret(state))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrInvalidExternalLogin
+		}
+		return nil, "", fmt.Errorf("failed to get login state: %w", err)
+	}
+	if login.IsExpired() {
+		return nil, "", ErrInvalidExternalLogin
+	}
+	provider := s.provider(login.Provider)
+	if provider == nil {
+		return nil, "", ErrUnknownLoginProvider
+	}
+
+	claims, err := s.exchangeCode(ctx, provider, login, code)
+	if err != nil {
+		if errors.Is(err, ErrExternalLoginFailed) {
+			s.audit.Log(ctx, nil, AuditLoginFailed, "user", "", map[string]any{"method": "external", "provider": provider.cfg.ID, "reason": err.Error()})
+		}
+		return nil, "", err
+	}
+
+	userID, err := s.linkedUser(ctx, provider, claims)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := s.authService.LoginWithIdentity(ctx, userID, provider.cfg.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp, login.ReturnTo, nil
+}
+
+// linkedUser returns the ID of the user a provider account is linked to.
+// On its first login, an account with a verified email address in one of
+// the allowed domains is linked to the user with that address if the
+// provider links accounts, or else to a new user if it provisions users.
+func (s *externalLoginService) linkedUser(ctx context.Context, provider *externalProvider, claims *externalIDTokenClaims) (uint, error) {
+	details := map[string]any{"method": "external", "provider": provider.cfg.ID, "subject": claims.Subject, "email": claims.Email}
+
+	identity, err := s.identityRepo.GetByProviderSubject(ctx, provider.cfg.ID, claims.Subject)
+	if err == nil {
+		if err := s.identityRepo.RecordLogin(ctx, identity.ID, claims.Email, time.Now()); err != nil {
+			return 0, fmt.Errorf("failed to update identity: %w", err)
+		}
+		return identity.UserID, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, fmt.Errorf("failed to get identity: %w", err)
+	}
+
+	// Linking by email address relies on the provider having checked that
+	// the account owns it
+	if claims.Email == "" || !claims.emailVerified() {
+		details["reason"] = "email not verified"
+		s.audit.Log(ctx, nil, AuditLoginFailed, "user", "", details)
+		return 0, ErrExternalEmailUnverified
+	}
+	if !provider.allowsEmail(claims.Email) {
+		details["reason"] = "email domain not allowed"
+		s.audit.Log(ctx, nil, AuditLoginFailed, "user", "", details)
+		return 0, ErrExternalDomainNotAllowed
+	}
+
+	user, err := s.userRepo.GetByEmail(ctx, claims.Email)
+	switch {
+	case err == nil && !provider.cfg.LinkExisting:
+		details["reason"] = "linking disabled"
+		s.audit.Log(ctx, nil, AuditLoginFailed, "user", fmt.Sprint(user.ID), details)
+		return 0, ErrExternalAccountNotLinked
+	case err == nil:
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return 0, fmt.Errorf("failed to get user: %w", err)
+	case !provider.cfg.Provision:
+		details["reason"] = "no matching user"
+		s.audit.Log(ctx, nil, AuditLoginFailed, "user", "", details)
+		return 0, ErrExternalAccountNotFound
+	default:
+		name := claims.Name
+		if name == "" {
+			name = claims.Email
+		}
+		// Without a password, the user signs in through the provider only
+		// until they reset it
+		user = &model.User{
+			Name:    name,
+			Email:   claims.Email,
+			Enabled: true,
+			Role:    model.RoleUser,
+		}
+		if err := s.userRepo.Create(ctx, user); err != nil {
+			return 0, fmt.Errorf("failed to create user: %w", err)
+		}
+		s.audit.Log(ctx, nil, AuditUserProvisioned, "user", fmt.Sprint(user.ID), details)
+	}
+
+	now := time.Now()
+	identity = &model.UserIdentity{
+		UserID:      user.ID,
+		Provider:    provider.cfg.ID,
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		LastLoginAt: &now,
+	}
+	if err := s.identityRepo.Create(ctx, identity); err != nil {
+		return 0, fmt.Errorf("failed to link identity: %w", err)
+	}
+	s.audit.Log(ctx, nil, AuditIdentityLinked, "user", fmt.Sprint(user.ID), details)
+
+	return user.ID, nil
+}
+
+// allowsEmail reports whether an email address is in one of the provider's
+// allowed domains
+func (p *externalProvider) allowsEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowed := range p.cfg.AllowedDomains {
+		if allowed == "*" || strings.EqualFold(allowed, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUserIdentities lists the provider accounts linked to a user
+func (s *externalLoginService) GetUserIdentities(ctx context.Context, userID uint) ([]dto.UserIdentityResponse, error) {
+	if err := authorize(ctx, PermUsersRead); err != nil {
+		return nil, err
+	}
+	if _, err := s.userRepo.GetByID(ctx, userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	identities, err := s.identityRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identities: %w", err)
+	}
+
+	responses := make([]dto.UserIdentityResponse, len(identities))
+	for i, identity := range identities {
+		responses[i] = dto.UserIdentityResponse{
+			ID:          identity.ID,
+			Provider:    identity.Provider,
+			Subject:     identity.Subject,
+			Email:       identity.Email,
+			CreatedAt:   identity.CreatedAt,
+			LastLoginAt: identity.LastLoginAt,
+		}
+	}
+	return responses, nil
+}
+
+// UnlinkIdentity removes a provider account from a user. Signing in with it
+// again links it anew, to the user with its email address if there is one.
+func (s *externalLoginService) UnlinkIdentity(ctx context.Context, userID, identityID uint) error {
+	if err := authorize(ctx, PermUsersWrite); err != nil {
+		return err
+	}
+
+	deleted, err := s.identityRepo.Delete(ctx, userID, identityID)
+	if err != nil {
+		return fmt.Errorf("failed to delete identity: %w", err)
+	}
+	if !deleted {
+		return errors.New("identity not found")
+	}
+	s.audit.Log(ctx, nil, AuditIdentityUnlinked, "user", fmt.Sprint(userID), map[string]any{"identity_id": identityID})
+
+	return nil
+}
+
+// LoginWithIdentity logs in a user an external identity provider vouched
+// for. The provider stands in for the password only: users with two-factor
+// authentication get a login challenge, as with Login.
+func (s *authService) LoginWithIdentity(ctx context.Context, userID uint, provider string) (*dto.LoginResponse, error) {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Enabled {
+		s.audit.Log(ctx, nil, AuditLoginFailed, "user", fmt.Sprint(user.ID), map[string]any{"email": user.Email, "method": "external", "provider": provider, "reason": "account disabled"})
+		return nil, errors.New("user account is disabled")
+	}
+
+	hasKeys, err := s.hasWebAuthnCredentials(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled || hasKeys || s.twoFactorRequired(user) {
+		return s.startLoginChallenge(ctx, user, hasKeys)
+	}
+
+	return s.createSession(ctx, user, map[string]any{"method": "external", "provider": provider})
+}
+
+// provider returns the configured provider with the given ID, or nil
+func (s *externalLoginService) provider(id string) *externalProvider {
+	for _, provider := range s.providers {
+		if provider.cfg.ID == id {
+			return provider
+		}
+	}
+	return nil
+}
+
+// exchangeCode redeems the code the provider sent back at its token
+// endpoint and returns the verified claims of the ID token it answers with
+func (s *externalLoginService) exchangeCode(ctx context.Context, provider *externalProvider, login *model.ExternalLoginState, code string) (*externalIDTokenClaims, error) {
+	discovery, err := s.discover(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.callbackURL},
+		"code_verifier": {login.CodeVerifier},
+		"client_id":     {provider.cfg.ClientID},
+	}
+	// HTTP Basic is the default; providers that don't list it get the
+	// secret in the form
+	postSecret := provider.cfg.ClientSecret != "" &&
+		len(discovery.TokenEndpointAuthMethodsSupported) > 0 &&
+		!slices.Contains(discovery.TokenEndpointAuthMethodsSupported, "client_secret_basic") &&
+		slices.Contains(discovery.TokenEndpointAuthMethodsSupported, "client_secret_post")
+	if postSecret {
+		form.Set("client_secret", provider.cfg.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid token endpoint: %v", ErrExternalLoginFailed, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if provider.cfg.ClientSecret != "" && !postSecret {
+		req.SetBasicAuth(url.QueryEscape(provider.cfg.ClientID), url.QueryEscape(provider.cfg.ClientSecret))
+	}
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := s.fetchJSON(req, &token)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%w: token endpoint answered %d %s %s", ErrExternalLoginFailed, status, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: no ID token", ErrExternalLoginFailed)
+	}
+
+	claims := &externalIDTokenClaims{}
+	var keyErr error
+	_, err = jwt.ParseWithClaims(token.IDToken, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := s.signingKey(ctx, provider, discovery, kid)
+		if err != nil {
+			keyErr = err
+			return nil, err
+		}
+		if key == nil {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(provider.cfg.Issuer), jwt.WithAudience(provider.cfg.ClientID), jwt.WithExpirationRequired())
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ID token: %v", ErrExternalLoginFailed, err)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(login.Nonce)) != 1 {
+		return nil, fmt.Errorf("%w: ID token nonce doesn't match", ErrExternalLoginFailed)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: ID token has no subject", ErrExternalLoginFailed)
+	}
+	return claims, nil
+}
+
+// discover returns a provider's discovery document, fetching it if it
+// isn't cached
+func (s *externalLoginService) discover(ctx context.Context, provider *externalProvider) (*dto.OIDCDiscovery, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.discovery != nil && time.Since(provider.discoveredAt) < externalDiscoveryTTL {
+		return provider.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(provider.cfg.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover login provider %q: %w", provider.cfg.ID, err)
+	}
+	var discovery dto.OIDCDiscovery
+	status, err := s.fetchJSON(req, &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover login provider %q: %w", provider.cfg.ID, err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("failed to discover login provider %q: status %d", provider.cfg.ID, status)
+	}
+	// Tokens name the issuer the document was published for, which must be
+	// the one configured
+	if discovery.Issuer != provider.cfg.Issuer {
+		return nil, fmt.Errorf("login provider %q: discovery document is for issuer %q", provider.cfg.ID, discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("login provider %q: discovery document lacks endpoints", provider.cfg.ID)
+	}
+
+	provider.discovery = &discovery
+	provider.discoveredAt = time.Now()
+	return provider.discovery, nil
+}
+
+// signingKey returns the provider's key with ID kid, refetching its keys if
+// kid isn't known (at most every externalKeyRefetchInterval), or nil if the
+// provider doesn't publish it. Tokens without a kid are accepted from
+// providers with a single key.
+func (s *externalLoginService) signingKey(ctx context.Context, provider *externalProvider, discovery *dto.OIDCDiscovery, kid string) (*rsa.PublicKey, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	lookup := func() (*rsa.PublicKey, bool) {
+		if kid == "" && len(provider.keys) == 1 {
+			for _, key := range provider.keys {
+				return key, true
+			}
+		}
+		key, ok := provider.keys[kid]
+		return key, ok
+	}
+	if key, ok := lookup(); ok || time.Since(provider.keysAt) < externalKeyRefetchInterval {
+		return key, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discovery.JWKSURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login provider %q keys: %w", provider.cfg.ID, err)
+	}
+	var jwks dto.JWKS
+	status, err := s.fetchJSON(req, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login provider %q keys: %w", provider.cfg.ID, err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("failed to get login provider %q keys: status %d", provider.cfg.ID, status)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		// Keys that don't parse can't have signed anything
+		if key, err := parseRSAJWK(jwk); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	provider.keys = keys
+	provider.keysAt = time.Now()
+
+	key, _ := lookup()
+	return key, nil
+}
+
+// fetchJSON sends a request to a provider and decodes its JSON answer into
+// v, returning the status code
+func (s *externalLoginService) fetchJSON(req *http.Request, v any) (int, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("invalid response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+// parseRSAJWK decodes an RSA public key in JSON Web Key form
+func parseRSAJWK(jwk dto.JWK) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent too large")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
@@ -0,0 +1,379 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"identity/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+type mockUserIdentityRepository struct {
+	identities []model.UserIdentity
+}
+
+func (m *mockUserIdentityRepository) Create(ctx context.Context, identity *model.UserIdentity) error {
+	identity.ID = uint(len(m.identities) + 1)
+	identity.CreatedAt = time.Now()
+	m.identities = append(m.identities, *identity)
+	return nil
+}
+
+func (m *mockUserIdentityRepository) GetByProviderSubject(ctx context.Context, provider, subject string) (*model.UserIdentity, error) {
+	for _, identity := range m.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *mockUserIdentityRepository) GetByUserID(ctx context.Context, userID uint) ([]model.UserIdentity, error) {
+	var identities []model.UserIdentity
+	for _, identity := range m.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func (m *mockUserIdentityRepository) RecordLogin(ctx context.Context, id uint, email string, at time.Time) error {
+	for i := range m.identities {
+		if m.identities[i].ID == id {
+			m.identities[i].Email = email
+			m.identities[i].LastLoginAt = &at
+		}
+	}
+	return nil
+}
+
+func (m *mockUserIdentityRepository) Delete(ctx context.Context, userID, id uint) (bool, error) {
+	for i, identity := range m.identities {
+		if identity.ID == id && identity.UserID == userID {
+			m.identities = append(m.identities[:i], m.identities[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type mockExternalLoginStateRepository struct {
+	states map[string]model.ExternalLoginState
+}
+
+func (m *mockExternalLoginStateRepository) Create(ctx context.Context, state *model.ExternalLoginState) error {
+	m.states[state.ID] = *state
+	return nil
+}
+
+func (m *mockExternalLoginStateRepository) Take(ctx context.Context, id string) (*model.ExternalLoginState, error) {
+	state, ok := m.states[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	delete(m.states, id)
+	return &state, nil
+}
+
+// mockIdP is an OpenID Connect provider for tests. It issues ID tokens with
+// the claims in account for the code it last handed out.
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	keys   *SigningKeys
+
+	mu        sync.Mutex
+	account   jwt.MapClaims
+	nonce     string
+	challenge string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	idp := &mockIdP{t: t, keys: NewSigningKeys(&mockSigningKeyRepository{}, testSecrets, 0)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwks, err := idp.keys.JWKS(r.Context())
+		if err != nil {
+			t.Errorf("JWKS failed: %v", err)
+		}
+		_ = json.NewEncoder(w).Encode(jwks)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+
+		clientID, secret, _ := r.BasicAuth()
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if clientID != "test-client" || secret != "test-secret" || r.PostFormValue("code") != "test-code" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   idp.server.URL,
+			"aud":   "test-client",
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": idp.nonce,
+		}
+		for name, value := range idp.account {
+			claims[name] = value
+		}
+		token, err := idp.keys.Sign(r.Context(), claims)
+		if err != nil {
+			t.Errorf("signing ID token failed: %v", err)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "unused", "token_type": "Bearer", "id_token": token})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// authorize plays the user signing in at the provider as account, returning
+// the state and code it sends back
+func (idp *mockIdP) authorize(redirectURL string, account jwt.MapClaims) (string, string) {
+	idp.t.Helper()
+	redirect, err := url.Parse(redirectURL)
+	if err != nil {
+		idp.t.Fatalf("invalid redirect URL: %v", err)
+	}
+	query := redirect.Query()
+	if query.Get("client_id") != "test-client" || query.Get("code_challenge_method") != "S256" || query.Get("redirect_uri") != "http://localhost/callback" {
+		idp.t.Fatalf("unexpected authorization request %s", redirectURL)
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.account = account
+	idp.nonce = query.Get("nonce")
+	idp.challenge = query.Get("code_challenge")
+	return query.Get("state"), "test-code"
+}
+
+// setupExternalLoginService creates a service with one provider, "mock",
+// configured as provider on top of its ID, issuer and credentials
+func setupExternalLoginService(t *testing.T, provider ExternalProviderConfig) (ExternalLoginService, *mockIdP, *mockUserRepository, *mockUserIdentityRepository, *recordingAudit) {
+	t.Helper()
+	auth, userRepo, _ := setupAuthService(t, time.Hour)
+	idp := newMockIdP(t)
+	identityRepo := &mockUserIdentityRepository{}
+	audit := &recordingAudit{}
+
+	provider.ID, provider.Name, provider.Issuer = "mock", "Mock", idp.server.URL
+	provider.ClientID, provider.ClientSecret = "test-client", "test-secret"
+	svc, err := NewExternalLoginService(identityRepo, &mockExternalLoginStateRepository{states: make(map[string]model.ExternalLoginState)}, userRepo, auth, audit, ExternalLoginConfig{
+		CallbackURL: "http://localhost/callback",
+		Providers:   []ExternalProviderConfig{provider},
+	})
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	return svc, idp, userRepo, identityRepo, audit
+}
+
+func TestExternalLoginLinksByVerifiedEmail(t *testing.T) {
+	svc, idp, _, identityRepo, audit := setupExternalLoginService(t, ExternalProviderConfig{AllowedDomains: []string{"*"}, LinkExisting: true})
+	ctx := context.Background()
+
+	redirectURL, state, err := svc.BeginLogin(ctx, "mock", "/oauth2/authorize?client_id=app")
+	if err != nil {
+		t.Fatalf("begin login failed: %v", err)
+	}
+	sentState, code := idp.authorize(redirectURL, jwt.MapClaims{"sub": "alice", "email": "test@example.com", "email_verified": true})
+	if sentState != state {
+		t.Fatalf("expected the provider to be sent state %q, got %q", state, sentState)
+	}
+	resp, returnTo, err := svc.FinishLogin(ctx, state, code)
+	if err != nil {
+		t.Fatalf("finish login failed: %v", err)
+	}
+	if resp.SessionID == "" || resp.User.ID != 1 || returnTo != "/oauth2/authorize?client_id=app" {
+		t.Errorf("expected a session for user 1 continuing the authorization request, got %+v, %q", resp, returnTo)
+	}
+	if len(identityRepo.identities) != 1 || identityRepo.identities[0].UserID != 1 || identityRepo.identities[0].Subject != "alice" {
+		t.Fatalf("expected the account linked to user 1, got %+v", identityRepo.identities)
+	}
+	if !slices.Contains(audit.actions(), AuditIdentityLinked) {
+		t.Errorf("expected the link to be audited, got %v", audit.actions())
+	}
+
+	// A state is used once
+	if _, _, err := svc.FinishLogin(ctx, state, code); !errors.Is(err, ErrInvalidExternalLogin) {
+		t.Errorf("expected ErrInvalidExternalLogin for a used state, got %v", err)
+	}
+
+	// Later logins find the user by the linked account, whatever its email
+	redirectURL, state, _ = svc.BeginLogin(ctx, "mock", "")
+	_, code = idp.authorize(redirectURL, jwt.MapClaims{"sub": "alice", "email": "alice@elsewhere.example", "email_verified": false})
+	if resp, _, err := svc.FinishLogin(ctx, state, code); err != nil || resp.User.ID != 1 {
+		t.Fatalf("expected the linked account to log in user 1, got %+v, %v", resp, err)
+	}
+	if len(identityRepo.identities) != 1 || identityRepo.identities[0].Email != "alice@elsewhere.example" {
+		t.Errorf("expected the identity's email to be updated, got %+v", identityRepo.identities)
+	}
+
+	// Unlinked accounts no longer sign the user in, unless linked again
+	adminCtx := WithActorRole(ctx, model.RoleAdmin)
+	if err := svc.UnlinkIdentity(adminCtx, 1, identityRepo.identities[0].ID); err != nil {
+		t.Fatalf("unlink failed: %v", err)
+	}
+	if identities, err := svc.GetUserIdentities(adminCtx, 1); err != nil || len(identities) != 0 {
+		t.Errorf("expected no identities after unlinking, got %v, %v", identities, err)
+	}
+	redirectURL, state, _ = svc.BeginLogin(ctx, "mock", "")
+	_, code = idp.authorize(redirectURL, jwt.MapClaims{"sub": "alice", "email": "alice@elsewhere.example", "email_verified": true})
+	if _, _, err := svc.FinishLogin(ctx, state, code); !errors.Is(err, ErrExternalAccountNotFound) {
+		t.Errorf("expected ErrExternalAccountNotFound without provisioning, got %v", err)
+	}
+}
+
+func TestExternalLoginProvisioning(t *testing.T) {
+	svc, idp, userRepo, _, audit := setupExternalLoginService(t, ExternalProviderConfig{AllowedDomains: []string{"example.com"}, Provision: true})
+	ctx := context.Background()
+
+	// Unverified addresses are neither linked nor given a user
+	redirectURL, state, _ := svc.BeginLogin(ctx, "mock", "")
+	_, code := idp.authorize(redirectURL, jwt.MapClaims{"sub": "mallory", "email": "test@example.com", "email_verified": "false"})
+	if _, _, err := svc.FinishLogin(ctx, state, code); !errors.Is(err, ErrExternalEmailUnverified) {
+		t.Errorf("expected ErrExternalEmailUnverified, got %v", err)
+	}
+
+	redirectURL, state, _ = svc.BeginLogin(ctx, "mock", "")
+	_, code = idp.authorize(redirectURL, jwt.MapClaims{"sub": "bob", "email": "bob@example.com", "email_verified": "true", "name": "Bob"})
+	resp, _, err := svc.FinishLogin(ctx, state, code)
+	if err != nil {
+		t.Fatalf("finish login failed: %v", err)
+	}
+	user, err := userRepo.GetByEmail(ctx, "bob@example.com")
+	if err != nil {
+		t.Fatalf("expected a user to be provisioned: %v", err)
+	}
+	if resp.User.ID != user.ID || user.Name != "Bob" || user.Role != model.RoleUser || user.PasswordHash != "" {
+		t.Errorf("expected a password-less end user named Bob, got %+v", user)
+	}
+	if !slices.Contains(audit.actions(), AuditUserProvisioned) {
+		t.Errorf("expected the new user to be audited, got %v", audit.actions())
+	}
+}
+
+func TestExternalLoginRejectsForeignTokens(t *testing.T) {
+	svc, idp, _, identityRepo, _ := setupExternalLoginService(t, ExternalProviderConfig{AllowedDomains: []string{"*"}, Provision: true})
+	ctx := context.Background()
+
+	// An ID token issued to another client
+	redirectURL, state, _ := svc.BeginLogin(ctx, "mock", "")
+	_, code := idp.authorize(redirectURL, jwt.MapClaims{"sub": "alice", "email": "test@example.com", "email_verified": true, "aud": "other-client"})
+	if _, _, err := svc.FinishLogin(ctx, state, code); !errors.Is(err, ErrExternalLoginFailed) {
+		t.Errorf("expected ErrExternalLoginFailed for another audience, got %v", err)
+	}
+
+	// An ID token for another login
+	redirectURL, state, _ = svc.BeginLogin(ctx, "mock", "")
+	_, code = idp.authorize(redirectURL, jwt.MapClaims{"sub": "alice", "email": "test@example.com", "email_verified": true, "nonce": "replayed"})
+	if _, _, err := svc.FinishLogin(ctx, state, code); !errors.Is(err, ErrExternalLoginFailed) {
+		t.Errorf("expected ErrExternalLoginFailed for another nonce, got %v", err)
+	}
+
+	if len(identityRepo.identities) != 0 {
+		t.Errorf("expected nothing linked, got %+v", identityRepo.identities)
+	}
+	if _, _, err := svc.BeginLogin(ctx, "unknown", ""); !errors.Is(err, ErrUnknownLoginProvider) {
+		t.Errorf("expected ErrUnknownLoginProvider, got %v", err)
+	}
+}
+
+// Only accounts from the allowed domains get in, and an existing user is
+// only taken over by a provider account when the provider links accounts
+func TestExternalLoginFirstSignInPolicy(t *testing.T) {
+	svc, idp, _, identityRepo, audit := setupExternalLoginService(t, ExternalProviderConfig{AllowedDomains: []string{"Corp.example"}, Provision: true})
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		account jwt.MapClaims
+		wantErr error
+	}{
+		{
+			name:    "domain not allowed",
+			account: jwt.MapClaims{"sub": "eve", "email": "eve@gmail.example", "email_verified": true},
+			wantErr: ErrExternalDomainNotAllowed,
+		},
+		{
+			name:    "lookalike domain",
+			account: jwt.MapClaims{"sub": "eve", "email": "eve@evilcorp.example", "email_verified": true},
+			wantErr: ErrExternalDomainNotAllowed,
+		},
+		{
+			// test@example.com is user 1, but the domain isn't allowed either
+			name:    "existing user outside the domains",
+			account: jwt.MapClaims{"sub": "mallory", "email": "test@example.com", "email_verified": true},
+			wantErr: ErrExternalDomainNotAllowed,
+		},
+		{
+			name:    "allowed domain, any case",
+			account: jwt.MapClaims{"sub": "carol", "email": "carol@corp.EXAMPLE", "email_verified": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redirectURL, state, _ := svc.BeginLogin(ctx, "mock", "")
+			_, code := idp.authorize(redirectURL, tt.account)
+			if _, _, err := svc.FinishLogin(ctx, state, code); !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+	if len(identityRepo.identities) != 1 || identityRepo.identities[0].Subject != "carol" {
+		t.Errorf("expected only carol's account to be linked, got %+v", identityRepo.identities)
+	}
+	if !slices.Contains(audit.actions(), AuditLoginFailed) {
+		t.Errorf("expected the refusals to be audited, got %v", audit.actions())
+	}
+
+	// Without LINK_EXISTING, an account with a user's email address doesn't
+	// get that user
+	svc, idp, _, identityRepo, _ = setupExternalLoginService(t, ExternalProviderConfig{AllowedDomains: []string{"example.com"}, Provision: true})
+	redirectURL, state, _ := svc.BeginLogin(ctx, "mock", "")
+	_, code := idp.authorize(redirectURL, jwt.MapClaims{"sub": "mallory", "email": "test@example.com", "email_verified": true})
+	if _, _, err := svc.FinishLogin(ctx, state, code); !errors.Is(err, ErrExternalAccountNotLinked) {
+		t.Errorf("expected ErrExternalAccountNotLinked, got %v", err)
+	}
+	if len(identityRepo.identities) != 0 {
+		t.Errorf("expected nothing linked, got %+v", identityRepo.identities)
+	}
+}
+
+func TestExternalLoginRequiresAllowedDomains(t *testing.T) {
+	_, err := NewExternalLoginService(&mockUserIdentityRepository{}, &mockExternalLoginStateRepository{}, newMockUserRepository(), nil, newNoopAudit(), ExternalLoginConfig{
+		CallbackURL: "http://localhost/callback",
+		Providers:   []ExternalProviderConfig{{ID: "google", Issuer: "https://accounts.google.com", ClientID: "client"}},
+	})
+	if err == nil {
+		t.Fatal("expected a provider without allowed domains to be refused")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"identity/internal/model"
 	"identity/internal/repository"
 	"identity/internal/service/dto"
+	"identity/pkg/api"
 
 	"gorm.io/gorm"
 )
@@ -20,51 +21,130 @@ type FeatureFlagService interface {
 	UpdateFeatureFlag(ctx context.Context, id uint, req *dto.UpdateFeatureFlagRequest) (*dto.FeatureFlagResponse, error)
 	DeleteFeatureFlag(ctx context.Context, id uint) error
 	// CheckFeatureFlag returns whether the flag is enabled globally, or for a specific user if userID is provided.
-	// A flag is considered enabled for a user if it is globally enabled OR explicitly assigned to that user.
+	// A flag is considered enabled for a user if it is globally enabled, explicitly assigned to that user,
+	// matched by one of its targeting rules, OR the user falls inside the flag's rollout percentage.
+	// Unlike the management methods it requires no permission, so other services can evaluate flags.
 	CheckFeatureFlag(ctx context.Context, key string, userID *uint) (bool, error)
+	// EvaluateFeatureFlag is CheckFeatureFlag against a full evaluation context, reporting why the
+	// flag resolved the way it did (and which targeting rule matched, if any).
+	EvaluateFeatureFlag(ctx context.Context, key string, evalCtx *dto.EvaluationContext) (*dto.FlagEvaluationResponse, error)
+	// EvaluateFeatureFlags evaluates the given flags (all flags when keys is empty) for one
+	// evaluation context, keyed by flag key. Unknown keys are left out of the result.
+	EvaluateFeatureFlags(ctx context.Context, keys []string, evalCtx *dto.EvaluationContext) (map[string]dto.FlagEvaluationResponse, error)
+	// FlagSnapshot, FlagChangesSince and SubscribeFlagChanges back the flag stream: a
+	// snapshot of every flag and assignment, then the changes logged after a given event
+	// (ErrResyncRequired if they are no longer known), polled whenever the subscription
+	// signals. Like CheckFeatureFlag they require no permission.
+	FlagSnapshot(ctx context.Context) (*dto.FlagStreamEvent, error)
+	FlagChangesSince(ctx context.Context, lastEventID uint64) ([]dto.FlagStreamEvent, error)
+	SubscribeFlagChanges() (<-chan struct{}, func())
+	// ScheduleFlagChange, GetScheduledFlagChanges and CancelScheduledFlagChange manage
+	// changes (enable, disable, set rollout) to apply to a flag at a later time;
+	// RunScheduledFlagChanges applies the due ones on behalf of whoever scheduled them
+	// and, like CheckFeatureFlag, requires no permission.
+	ScheduleFlagChange(ctx context.Context, flagID uint, req *dto.ScheduleFlagChangeRequest) (*dto.ScheduledFlagChangeResponse, error)
+	GetScheduledFlagChanges(ctx context.Context) ([]dto.ScheduledFlagChangeResponse, error)
+	CancelScheduledFlagChange(ctx context.Context, id uint) error
+	RunScheduledFlagChanges(ctx context.Context) (int, error)
 }
 
 // featureFlagService implements FeatureFlagService
 type featureFlagService struct {
 	featureFlagRepo repository.FeatureFlagRepository
 	userFFRepo      repository.UserFeatureFlagRepository
+	userRepo        repository.UserRepository
+	scheduleRepo    repository.ScheduledFlagChangeRepository
+	cache           *FlagCache
+	changes         *FlagChangeFeed
 	audit           AuditLogger
 }
 
-// NewFeatureFlagService creates a new feature flag service
-func NewFeatureFlagService(featureFlagRepo repository.FeatureFlagRepository, userFFRepo repository.UserFeatureFlagRepository, audit AuditLogger) FeatureFlagService {
+// NewFeatureFlagService creates a new feature flag service. Evaluation reads
+// flags and assignments through cache; a nil cache reads the database every time.
+// Flag changes are recorded in changes, which may be nil.
+func NewFeatureFlagService(
+	featureFlagRepo repository.FeatureFlagRepository,
+	userFFRepo repository.UserFeatureFlagRepository,
+	userRepo repository.UserRepository,
+	scheduleRepo repository.ScheduledFlagChangeRepository,
+	cache *FlagCache,
+	changes *FlagChangeFeed,
+	audit AuditLogger,
+) FeatureFlagService {
 	return &featureFlagService{
 		featureFlagRepo: featureFlagRepo,
 		userFFRepo:      userFFRepo,
+		userRepo:        userRepo,
+		scheduleRepo:    scheduleRepo,
+		cache:           cache,
+		changes:         changes,
 		audit:           audit,
 	}
 }
 
 // CreateFeatureFlag creates a new feature flag
 func (s *featureFlagService) CreateFeatureFlag(ctx context.Context, req *dto.CreateFeatureFlagRequest) (*dto.FeatureFlagResponse, error) {
+	if err := authorize(ctx, PermFlagsWrite); err != nil {
+		return nil, err
+	}
+
 	// Validate key uniqueness
 	existingFlag, err := s.featureFlagRepo.GetByKey(ctx, req.Key)
 	if err == nil && existingFlag != nil {
 		return nil, errors.New("feature flag key already exists")
 	}
 
+	if err := validateRolloutPercentage(req.RolloutPercentage); err != nil {
+		return nil, err
+	}
+	rules := orEmpty(req.Rules)
+	if err := validateRules(rules); err != nil {
+		return nil, err
+	}
+
 	flag := &model.FeatureFlag{
-		Key:         req.Key,
-		Description: req.Description,
-		Enabled:     req.Enabled,
+		Key:               req.Key,
+		Description:       req.Description,
+		Enabled:           req.Enabled,
+		RolloutPercentage: req.RolloutPercentage,
+		Rules:             rules,
+		VariantType:       req.VariantType,
+		Variants:          orEmpty(req.Variants),
+		OffVariant:        req.OffVariant,
+		Prerequisites:     orEmpty(req.Prerequisites),
+	}
+	if flag.VariantType == "" {
+		flag.VariantType = api.VariantTypeBoolean
+	}
+	if err := validateVariants(flag); err != nil {
+		return nil, err
 	}
 
-	if err := s.featureFlagRepo.Create(ctx, flag); err != nil {
-		return nil, fmt.Errorf("failed to create feature flag: %w", err)
+	err = s.featureFlagRepo.RunLocked(ctx, func(ctx context.Context) error {
+		if err := s.validatePrerequisites(ctx, flag); err != nil {
+			return err
+		}
+		if err := s.featureFlagRepo.Create(ctx, flag); err != nil {
+			return fmt.Errorf("failed to create feature flag: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	s.changes.FlagChanged(ctx, flag.Key)
 
-	s.audit.Log(ctx, nil, AuditFlagCreated, "feature_flag", flag.Key, map[string]any{"enabled": flag.Enabled})
+	s.audit.Log(ctx, nil, AuditFlagCreated, "feature_flag", flag.Key, map[string]any{"enabled": flag.Enabled, "rollout_percentage": flag.RolloutPercentage, "variant_type": flag.VariantType, "variants": len(flag.Variants), "prerequisites": len(flag.Prerequisites)})
 
-	return s.toFeatureFlagResponse(flag), nil
+	return toFeatureFlagResponse(flag), nil
 }
 
 // GetFeatureFlag retrieves a feature flag by ID
 func (s *featureFlagService) GetFeatureFlag(ctx context.Context, id uint) (*dto.FeatureFlagResponse, error) {
+	if err := authorize(ctx, PermFlagsRead); err != nil {
+		return nil, err
+	}
+
 	flag, err := s.featureFlagRepo.GetByID(ctx, id)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -73,11 +153,15 @@ func (s *featureFlagService) GetFeatureFlag(ctx context.Context, id uint) (*dto.
 		return nil, fmt.Errorf("failed to get feature flag: %w", err)
 	}
 
-	return s.toFeatureFlagResponse(flag), nil
+	return toFeatureFlagResponse(flag), nil
 }
 
 // GetFeatureFlagByKey retrieves a feature flag by key
 func (s *featureFlagService) GetFeatureFlagByKey(ctx context.Context, key string) (*dto.FeatureFlagResponse, error) {
+	if err := authorize(ctx, PermFlagsRead); err != nil {
+		return nil, err
+	}
+
 	flag, err := s.featureFlagRepo.GetByKey(ctx, key)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -86,11 +170,15 @@ func (s *featureFlagService) GetFeatureFlagByKey(ctx context.Context, key string
 		return nil, fmt.Errorf("failed to get feature flag: %w", err)
 	}
 
-	return s.toFeatureFlagResponse(flag), nil
+	return toFeatureFlagResponse(flag), nil
 }
 
 // GetFeatureFlags retrieves all feature flags with pagination
 func (s *featureFlagService) GetFeatureFlags(ctx context.Context, pagination *dto.PaginationParams) (*dto.FeatureFlagListResponse, error) {
+	if err := authorize(ctx, PermFlagsRead); err != nil {
+		return nil, err
+	}
+
 	flags, total, err := s.featureFlagRepo.GetAll(ctx, pagination.GetLimit(), pagination.GetOffset())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get feature flags: %w", err)
@@ -98,7 +186,7 @@ func (s *featureFlagService) GetFeatureFlags(ctx context.Context, pagination *dt
 
 	flagResponses := make([]dto.FeatureFlagResponse, len(flags))
 	for i, flag := range flags {
-		flagResponses[i] = *s.toFeatureFlagResponse(&flag)
+		flagResponses[i] = *toFeatureFlagResponse(&flag)
 	}
 
 	return &dto.FeatureFlagListResponse{
@@ -112,37 +200,94 @@ func (s *featureFlagService) GetFeatureFlags(ctx context.Context, pagination *dt
 
 // UpdateFeatureFlag updates a feature flag
 func (s *featureFlagService) UpdateFeatureFlag(ctx context.Context, id uint, req *dto.UpdateFeatureFlagRequest) (*dto.FeatureFlagResponse, error) {
-	flag, err := s.featureFlagRepo.GetByID(ctx, id)
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("feature flag not found")
+	if err := authorize(ctx, PermFlagsWrite); err != nil {
+		return nil, err
+	}
+
+	// The flag is read, checked and saved under the flag lock, so flags
+	// depending on it can't change in between
+	var flag *model.FeatureFlag
+	err := s.featureFlagRepo.RunLocked(ctx, func(ctx context.Context) error {
+		var err error
+		flag, err = s.featureFlagRepo.GetByID(ctx, id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("feature flag not found")
+			}
+			return fmt.Errorf("failed to get feature flag: %w", err)
 		}
-		return nil, fmt.Errorf("failed to get feature flag: %w", err)
+		if err := applyFlagUpdate(flag, req); err != nil {
+			return err
+		}
+		// Prerequisites are checked against the resulting flag too, since
+		// dependents may require a variant just removed
+		if err := s.validatePrerequisites(ctx, flag); err != nil {
+			return err
+		}
+		if err := s.featureFlagRepo.Update(ctx, flag); err != nil {
+			return fmt.Errorf("failed to update feature flag: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.changes.FlagChanged(ctx, flag.Key)
+
+	action := AuditFlagUpdated
+	if req.Enabled != nil {
+		action = AuditFlagToggled
 	}
+	s.audit.Log(ctx, nil, action, "feature_flag", flag.Key, map[string]any{"enabled": flag.Enabled, "rollout_percentage": flag.RolloutPercentage, "rules": len(flag.Rules), "variant_type": flag.VariantType, "variants": len(flag.Variants), "prerequisites": len(flag.Prerequisites)})
+
+	return toFeatureFlagResponse(flag), nil
+}
 
-	// Update fields if provided
+// applyFlagUpdate sets the fields an update request provides on a flag and
+// validates the result, except for its prerequisites
+func applyFlagUpdate(flag *model.FeatureFlag, req *dto.UpdateFeatureFlagRequest) error {
 	if req.Description != nil {
 		flag.Description = *req.Description
 	}
 	if req.Enabled != nil {
 		flag.Enabled = *req.Enabled
 	}
-
-	if err := s.featureFlagRepo.Update(ctx, flag); err != nil {
-		return nil, fmt.Errorf("failed to update feature flag: %w", err)
+	if req.RolloutPercentage != nil {
+		if err := validateRolloutPercentage(*req.RolloutPercentage); err != nil {
+			return err
+		}
+		flag.RolloutPercentage = *req.RolloutPercentage
 	}
-
-	action := AuditFlagUpdated
-	if req.Enabled != nil {
-		action = AuditFlagToggled
+	if req.Rules != nil {
+		rules := orEmpty(*req.Rules)
+		if err := validateRules(rules); err != nil {
+			return err
+		}
+		flag.Rules = rules
 	}
-	s.audit.Log(ctx, nil, action, "feature_flag", flag.Key, map[string]any{"enabled": flag.Enabled})
-
-	return s.toFeatureFlagResponse(flag), nil
+	if req.VariantType != nil {
+		flag.VariantType = *req.VariantType
+	}
+	if req.Variants != nil {
+		flag.Variants = orEmpty(*req.Variants)
+	}
+	if req.OffVariant != nil {
+		flag.OffVariant = *req.OffVariant
+	}
+	if req.Prerequisites != nil {
+		flag.Prerequisites = orEmpty(*req.Prerequisites)
+	}
+	// Variants are checked against the resulting flag, since rules and the
+	// off variant may reference variants changed in the same request
+	return validateVariants(flag)
 }
 
 // DeleteFeatureFlag deletes a feature flag
 func (s *featureFlagService) DeleteFeatureFlag(ctx context.Context, id uint) error {
+	if err := authorize(ctx, PermFlagsWrite); err != nil {
+		return err
+	}
+
 	// Check if feature flag exists
 	flag, err := s.featureFlagRepo.GetByID(ctx, id)
 	if err != nil {
@@ -151,9 +296,22 @@ func (s *featureFlagService) DeleteFeatureFlag(ctx context.Context, id uint) err
 		}
 		return fmt.Errorf("failed to get feature flag: %w", err)
 	}
-
-	if err := s.featureFlagRepo.Delete(ctx, id); err != nil {
-		return fmt.Errorf("failed to delete feature flag: %w", err)
+	err = s.featureFlagRepo.RunLocked(ctx, func(ctx context.Context) error {
+		if err := s.checkNoDependents(ctx, flag.Key); err != nil {
+			return err
+		}
+		if err := s.featureFlagRepo.Delete(ctx, id); err != nil {
+			return fmt.Errorf("failed to delete feature flag: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.changes.FlagChanged(ctx, flag.Key)
+	// Flags are soft deleted, so their scheduled changes don't cascade
+	if err := s.scheduleRepo.CancelPendingForFlag(ctx, id); err != nil {
+		return fmt.Errorf("failed to cancel scheduled flag changes: %w", err)
 	}
 
 	s.audit.Log(ctx, nil, AuditFlagDeleted, "feature_flag", flag.Key, nil)
@@ -163,37 +321,225 @@ func (s *featureFlagService) DeleteFeatureFlag(ctx context.Context, id uint) err
 
 // CheckFeatureFlag returns whether the flag is enabled for the given key (and optionally a specific user).
 func (s *featureFlagService) CheckFeatureFlag(ctx context.Context, key string, userID *uint) (bool, error) {
-	flag, err := s.featureFlagRepo.GetByKey(ctx, key)
+	result, err := s.EvaluateFeatureFlag(ctx, key, &dto.EvaluationContext{UserID: userID})
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return false, errors.New("feature flag not found")
+		return false, err
+	}
+	return result.Enabled, nil
+}
+
+// EvaluateFeatureFlag resolves a single flag for an evaluation context
+func (s *featureFlagService) EvaluateFeatureFlag(ctx context.Context, key string, evalCtx *dto.EvaluationContext) (*dto.FlagEvaluationResponse, error) {
+	flags, err := s.lookupFlags(ctx, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	if len(flags) == 0 {
+		return nil, errors.New("feature flag not found")
+	}
+
+	eval := s.newEvaluation(ctx, evalCtx)
+	eval.single = true
+	return eval.evaluate(flags[0])
+}
+
+// EvaluateFeatureFlags evaluates many flags for one context. Flags are loaded
+// in one query; the user's assignments and record at most once each, and only
+// if some flag needs them.
+func (s *featureFlagService) EvaluateFeatureFlags(ctx context.Context, keys []string, evalCtx *dto.EvaluationContext) (map[string]dto.FlagEvaluationResponse, error) {
+	flags, err := s.lookupFlags(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	eval := s.newEvaluation(ctx, evalCtx)
+	results := make(map[string]dto.FlagEvaluationResponse, len(flags))
+	for _, flag := range flags {
+		result, err := eval.evaluate(flag)
+		if err != nil {
+			return nil, err
 		}
-		return false, fmt.Errorf("failed to get feature flag: %w", err)
+		results[result.Key] = *result
 	}
+	return results, nil
+}
 
-	if userID == nil {
-		return flag.Enabled, nil
+// lookupFlags returns the flags with the given keys (all flags when keys is
+// empty) for evaluation, skipping unknown keys. The cache holds every flag,
+// so lookups through it never miss on a single key.
+func (s *featureFlagService) lookupFlags(ctx context.Context, keys []string) ([]*model.FeatureFlag, error) {
+	if s.cache == nil {
+		rows, err := s.featureFlagRepo.GetByKeys(ctx, keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get feature flags: %w", err)
+		}
+		flags := make([]*model.FeatureFlag, len(rows))
+		for i := range rows {
+			flags[i] = &rows[i]
+		}
+		return flags, nil
 	}
 
-	if flag.Enabled {
-		return true, nil
+	all, err := s.cache.allFlags(ctx, func(ctx context.Context) ([]model.FeatureFlag, error) {
+		return s.featureFlagRepo.GetByKeys(ctx, nil)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feature flags: %w", err)
 	}
 
-	assigned, err := s.userFFRepo.IsFeatureFlagAssignedToUser(ctx, *userID, flag.ID)
+	flags := make([]*model.FeatureFlag, 0, len(all))
+	if len(keys) == 0 {
+		for _, flag := range all {
+			flags = append(flags, flag)
+		}
+		return flags, nil
+	}
+	for _, key := range keys {
+		if flag, ok := all[key]; ok {
+			flags = append(flags, flag)
+		}
+	}
+	return flags, nil
+}
+
+// evaluation resolves flags for one evaluation context. The user's flag
+// assignments and attributes are loaded lazily and at most once, so
+// evaluating many flags costs no more queries than evaluating one. Without
+// the cache, which keeps all of a user's assignments for later requests, a
+// single flag is evaluated with only its own assignment loaded.
+type evaluation struct {
+	s           *featureFlagService
+	ctx         context.Context
+	evalCtx     *dto.EvaluationContext
+	single      bool
+	assignments map[uint]*model.UserFeatureFlag
+	attrs       map[string]any
+}
+
+func (s *featureFlagService) newEvaluation(ctx context.Context, evalCtx *dto.EvaluationContext) *evaluation {
+	if evalCtx == nil {
+		evalCtx = &dto.EvaluationContext{}
+	}
+	return &evaluation{s: s, ctx: ctx, evalCtx: evalCtx}
+}
+
+// evaluate resolves one flag with the user's assignments and attributes
+// loaded on demand
+func (e *evaluation) evaluate(flag *model.FeatureFlag) (*dto.FlagEvaluationResponse, error) {
+	return api.EvaluateFlag(toFeatureFlagResponse(flag), api.FlagInputs{
+		UserID:     e.evalCtx.UserID,
+		Assignment: e.assignment,
+		Attributes: e.attributes,
+		Flag:       e.flag,
+	})
+}
+
+// flag looks up a prerequisite flag, nil if there is no such flag
+func (e *evaluation) flag(key string) (*dto.FeatureFlagResponse, error) {
+	flags, err := e.s.lookupFlags(e.ctx, []string{key})
+	if err != nil || len(flags) == 0 {
+		return nil, err
+	}
+	return toFeatureFlagResponse(flags[0]), nil
+}
+
+// assignment returns the variant the user's assignment of a flag pins, and
+// whether there is one
+func (e *evaluation) assignment(flag *dto.FeatureFlagResponse) (string, bool, error) {
+	if e.evalCtx.UserID == nil {
+		return "", false, nil
+	}
+	if e.single && e.s.cache == nil {
+		return e.loadAssignment(flag)
+	}
+	if e.assignments == nil {
+		assignments, err := e.s.cache.userAssignments(e.ctx, *e.evalCtx.UserID, e.s.userFFRepo.GetUserAssignments)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to check flag assignment: %w", err)
+		}
+		e.assignments = assignments
+	}
+	assignment, ok := e.assignments[flag.ID]
+	if !ok {
+		return "", false, nil
+	}
+	return assignment.Variant, true, nil
+}
+
+// loadAssignment loads the user's assignment of one flag
+func (e *evaluation) loadAssignment(flag *dto.FeatureFlagResponse) (string, bool, error) {
+	assignment, err := e.s.userFFRepo.GetAssignment(e.ctx, *e.evalCtx.UserID, flag.ID)
 	if err != nil {
-		return false, fmt.Errorf("failed to check flag assignment: %w", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to check flag assignment: %w", err)
+	}
+	return assignment.Variant, true, nil
+}
+
+// attributes merges caller-supplied attributes with those of the user
+// record. Identity attributes only ever come from the user record. An
+// unknown user ID is not an error: rules simply see no user attributes.
+func (e *evaluation) attributes() (map[string]any, error) {
+	if e.attrs != nil {
+		return e.attrs, nil
+	}
+
+	attrs := make(map[string]any, len(e.evalCtx.Attributes)+5)
+	for k, v := range e.evalCtx.Attributes {
+		if !identityAttributes[k] {
+			attrs[k] = v
+		}
 	}
-	return assigned, nil
+
+	if e.evalCtx.UserID != nil {
+		user, err := e.s.userRepo.GetByID(e.ctx, *e.evalCtx.UserID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to get user: %w", err)
+		}
+		if user != nil {
+			for k, v := range userAttributes(user) {
+				attrs[k] = v
+			}
+		}
+	}
+
+	e.attrs = attrs
+	return attrs, nil
 }
 
 // toFeatureFlagResponse converts a model.FeatureFlag to dto.FeatureFlagResponse
-func (s *featureFlagService) toFeatureFlagResponse(flag *model.FeatureFlag) *dto.FeatureFlagResponse {
+func toFeatureFlagResponse(flag *model.FeatureFlag) *dto.FeatureFlagResponse {
 	return &dto.FeatureFlagResponse{
-		ID:          flag.ID,
-		Key:         flag.Key,
-		Description: flag.Description,
-		Enabled:     flag.Enabled,
-		CreatedAt:   flag.CreatedAt,
-		UpdatedAt:   flag.UpdatedAt,
+		ID:                flag.ID,
+		Key:               flag.Key,
+		Description:       flag.Description,
+		Enabled:           flag.Enabled,
+		RolloutPercentage: flag.RolloutPercentage,
+		Rules:             orEmpty(flag.Rules),
+		VariantType:       flag.VariantType,
+		Variants:          orEmpty(flag.Variants),
+		OffVariant:        flag.OffVariant,
+		Prerequisites:     orEmpty(flag.Prerequisites),
+		CreatedAt:         flag.CreatedAt,
+		UpdatedAt:         flag.UpdatedAt,
+	}
+}
+
+// validateRolloutPercentage rejects percentages outside 0-100
+func validateRolloutPercentage(percentage int) error {
+	if percentage < 0 || percentage > 100 {
+		return errors.New("rollout percentage must be between 0 and 100")
+	}
+	return nil
+}
+
+// orEmpty returns an empty list for nil, so lists left out are stored and
+// sent as [] rather than null
+func orEmpty[T any](list []T) []T {
+	if list == nil {
+		return []T{}
 	}
+	return list
 }
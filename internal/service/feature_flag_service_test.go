@@ -0,0 +1,506 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"identity/pkg/api"
+	"testing"
+	"time"
+)
+
+func setupFeatureFlagService(t *testing.T) (FeatureFlagService, *mockFeatureFlagRepository, *mockUserFeatureFlagRepository) {
+	svc, featureFlagRepo, userFFRepo, _ := setupFeatureFlagServiceWithUsers(t)
+	return svc, featureFlagRepo, userFFRepo
+}
+
+func setupFeatureFlagServiceWithUsers(t *testing.T) (FeatureFlagService, *mockFeatureFlagRepository, *mockUserFeatureFlagRepository, *mockUserRepository) {
+	t.Helper()
+	featureFlagRepo := newMockFeatureFlagRepository()
+	userFFRepo := newMockUserFeatureFlagRepository()
+	userRepo := newMockUserRepository()
+	svc := NewFeatureFlagService(featureFlagRepo, userFFRepo, userRepo, newMockScheduledFlagChangeRepository(), nil, nil, newNoopAudit())
+	return svc, featureFlagRepo, userFFRepo, userRepo
+}
+
+// enabledUsers counts how many of the first n user IDs see the flag enabled
+func enabledUsers(t *testing.T, svc FeatureFlagService, key string, n int) map[uint]bool {
+	t.Helper()
+	enabled := make(map[uint]bool)
+	for i := 1; i <= n; i++ {
+		uid := uint(i)
+		on, err := svc.CheckFeatureFlag(context.Background(), key, &uid)
+		if err != nil {
+			t.Fatalf("CheckFeatureFlag() error = %v", err)
+		}
+		if on {
+			enabled[uid] = true
+		}
+	}
+	return enabled
+}
+
+func TestFeatureFlagService_RolloutPercentage(t *testing.T) {
+	svc, _, _ := setupFeatureFlagService(t)
+
+	flag, err := svc.CreateFeatureFlag(adminContext(), &dto.CreateFeatureFlagRequest{
+		Key:               "use-transactions-v2",
+		RolloutPercentage: 25,
+	})
+	if err != nil {
+		t.Fatalf("CreateFeatureFlag() error = %v", err)
+	}
+
+	const users = 10000
+	at25 := enabledUsers(t, svc, flag.Key, users)
+	if got := len(at25); got < 2300 || got > 2700 {
+		t.Errorf("25%% rollout enabled %d of %d users, want roughly 2500", got, users)
+	}
+
+	// Without a user only the global switch counts
+	on, err := svc.CheckFeatureFlag(context.Background(), flag.Key, nil)
+	if err != nil {
+		t.Fatalf("CheckFeatureFlag() error = %v", err)
+	}
+	if on {
+		t.Error("CheckFeatureFlag() without user = true, want false for a partial rollout")
+	}
+
+	// Raising the percentage must keep everyone who already had the flag
+	fifty := 50
+	if _, err := svc.UpdateFeatureFlag(adminContext(), flag.ID, &dto.UpdateFeatureFlagRequest{RolloutPercentage: &fifty}); err != nil {
+		t.Fatalf("UpdateFeatureFlag() error = %v", err)
+	}
+	at50 := enabledUsers(t, svc, flag.Key, users)
+	for uid := range at25 {
+		if !at50[uid] {
+			t.Fatalf("user %d lost the flag when the rollout grew from 25%% to 50%%", uid)
+		}
+	}
+
+	hundred := 100
+	if _, err := svc.UpdateFeatureFlag(adminContext(), flag.ID, &dto.UpdateFeatureFlagRequest{RolloutPercentage: &hundred}); err != nil {
+		t.Fatalf("UpdateFeatureFlag() error = %v", err)
+	}
+	if got := len(enabledUsers(t, svc, flag.Key, 500)); got != 500 {
+		t.Errorf("100%% rollout enabled %d of 500 users, want all", got)
+	}
+}
+
+func TestFeatureFlagService_RolloutPercentageValidation(t *testing.T) {
+	svc, _, _ := setupFeatureFlagService(t)
+
+	if _, err := svc.CreateFeatureFlag(adminContext(), &dto.CreateFeatureFlagRequest{Key: "bad", RolloutPercentage: 101}); err == nil {
+		t.Error("CreateFeatureFlag() accepted a rollout above 100")
+	}
+
+	flag, err := svc.CreateFeatureFlag(adminContext(), &dto.CreateFeatureFlagRequest{Key: "ok"})
+	if err != nil {
+		t.Fatalf("CreateFeatureFlag() error = %v", err)
+	}
+	negative := -1
+	if _, err := svc.UpdateFeatureFlag(adminContext(), flag.ID, &dto.UpdateFeatureFlagRequest{RolloutPercentage: &negative}); err == nil {
+		t.Error("UpdateFeatureFlag() accepted a negative rollout")
+	}
+}
+
+func TestFeatureFlagService_AssignmentOverridesRollout(t *testing.T) {
+	svc, featureFlagRepo, userFFRepo := setupFeatureFlagService(t)
+
+	flag := &model.FeatureFlag{Key: "beta"}
+	_ = featureFlagRepo.Create(context.Background(), flag)
+	_ = userFFRepo.AssignFeatureFlagToUser(context.Background(), 7, flag.ID, "")
+
+	uid := uint(7)
+	on, err := svc.CheckFeatureFlag(context.Background(), flag.Key, &uid)
+	if err != nil {
+		t.Fatalf("CheckFeatureFlag() error = %v", err)
+	}
+	if !on {
+		t.Error("CheckFeatureFlag() = false for an explicitly assigned user at 0% rollout")
+	}
+}
+
+func TestFeatureFlagService_TargetingRules(t *testing.T) {
+	svc, _, _, userRepo := setupFeatureFlagServiceWithUsers(t)
+	ctx := context.Background()
+
+	staff := &model.User{Email: "ana@Example.com", Name: "Ana", Role: model.RoleUser}
+	_ = userRepo.Create(ctx, staff)
+	staff.CreatedAt = time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+	outsider := &model.User{Email: "bob@other.org", Name: "Bob", Role: model.RoleUser}
+	_ = userRepo.Create(ctx, outsider)
+	outsider.CreatedAt = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := svc.CreateFeatureFlag(adminContext(), &dto.CreateFeatureFlagRequest{
+		Key: "new-dashboard",
+		Rules: []dto.TargetingRule{
+			{Name: "staff", Conditions: []dto.TargetingCondition{
+				{Attribute: "email", Operator: api.OpEndsWith, Values: []string{"@example.com"}},
+			}},
+			{Name: "new signups", Conditions: []dto.TargetingCondition{
+				{Attribute: "created_at", Operator: api.OpAfter, Values: []string{"2026-01-01"}},
+			}},
+			{Name: "beta group on pro plan", Conditions: []dto.TargetingCondition{
+				{Attribute: "groups", Operator: api.OpIn, Values: []string{"beta-testers"}},
+				{Attribute: "plan", Operator: api.OpEquals, Values: []string{"pro"}},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateFeatureFlag() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		evalCtx   *dto.EvaluationContext
+		enabled   bool
+		ruleIndex int
+	}{
+		{"email suffix, case-insensitive", &dto.EvaluationContext{UserID: &staff.ID}, true, 0},
+		{"no match", &dto.EvaluationContext{UserID: &outsider.ID}, false, -1},
+		{"group and plan from context", &dto.EvaluationContext{UserID: &outsider.ID, Attributes: map[string]any{
+			"groups": []any{"staff", "beta-testers"},
+			"plan":   "pro",
+		}}, true, 2},
+		{"group without plan", &dto.EvaluationContext{Attributes: map[string]any{
+			"groups": []any{"beta-testers"},
+		}}, false, -1},
+		{"context cannot spoof user attributes", &dto.EvaluationContext{UserID: &outsider.ID, Attributes: map[string]any{
+			"email": "mallory@example.com",
+		}}, false, -1},
+		{"context cannot claim user attributes without a user", &dto.EvaluationContext{Attributes: map[string]any{
+			"email":      "mallory@example.com",
+			"created_at": "2026-03-01T10:00:00Z",
+		}}, false, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := svc.EvaluateFeatureFlag(ctx, "new-dashboard", tt.evalCtx)
+			if err != nil {
+				t.Fatalf("EvaluateFeatureFlag() error = %v", err)
+			}
+			if result.Enabled != tt.enabled {
+				t.Errorf("Enabled = %v, want %v", result.Enabled, tt.enabled)
+			}
+			if tt.ruleIndex < 0 {
+				if result.Reason != api.ReasonDefault || result.RuleIndex != nil {
+					t.Errorf("Reason = %q, RuleIndex = %v, want default with no rule", result.Reason, result.RuleIndex)
+				}
+				return
+			}
+			if result.Reason != api.ReasonRule || result.RuleIndex == nil || *result.RuleIndex != tt.ruleIndex {
+				t.Errorf("Reason = %q, RuleIndex = %v, want rule %d", result.Reason, result.RuleIndex, tt.ruleIndex)
+			}
+		})
+	}
+}
+
+func TestFeatureFlagService_TargetingRulesValidation(t *testing.T) {
+	svc, _, _ := setupFeatureFlagService(t)
+
+	invalid := map[string][]dto.TargetingRule{
+		"unknown operator": {{Conditions: []dto.TargetingCondition{{Attribute: "email", Operator: "matches", Values: []string{"x"}}}}},
+		"no conditions":    {{Name: "empty"}},
+		"no values":        {{Conditions: []dto.TargetingCondition{{Attribute: "email", Operator: api.OpEquals}}}},
+		"bad date":         {{Conditions: []dto.TargetingCondition{{Attribute: "created_at", Operator: api.OpAfter, Values: []string{"last week"}}}}},
+	}
+	for name, rules := range invalid {
+		_, err := svc.CreateFeatureFlag(adminContext(), &dto.CreateFeatureFlagRequest{Key: "bad", Rules: rules})
+		if !errors.Is(err, ErrInvalidRules) {
+			t.Errorf("%s: CreateFeatureFlag() error = %v, want ErrInvalidRules", name, err)
+		}
+	}
+}
+
+func TestFeatureFlagService_Variants(t *testing.T) {
+	svc, _, userFFRepo, userRepo := setupFeatureFlagServiceWithUsers(t)
+	ctx := context.Background()
+
+	flag, err := svc.CreateFeatureFlag(adminContext(), &dto.CreateFeatureFlagRequest{
+		Key:               "pricing-layout",
+		VariantType:       api.VariantTypeString,
+		RolloutPercentage: 100,
+		Variants: []dto.FlagVariant{
+			{Key: "classic", Value: json.RawMessage(`"classic"`), Weight: 50},
+			{Key: "compact", Value: json.RawMessage(`"compact"`), Weight: 50},
+			{Key: "off", Value: json.RawMessage(`"none"`)},
+		},
+		OffVariant: "off",
+		Rules: []dto.TargetingRule{
+			{Conditions: []dto.TargetingCondition{{Attribute: "plan", Operator: api.OpEquals, Values: []string{"enterprise"}}}, Variant: "classic"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateFeatureFlag() error = %v", err)
+	}
+
+	counts := map[string]int{}
+	for uid := uint(1); uid <= 2000; uid++ {
+		result, err := svc.EvaluateFeatureFlag(ctx, flag.Key, &dto.EvaluationContext{UserID: &uid})
+		if err != nil {
+			t.Fatalf("EvaluateFeatureFlag() error = %v", err)
+		}
+		if string(result.Value) != `"`+result.Variant+`"` {
+			t.Fatalf("variant %q served value %s", result.Variant, result.Value)
+		}
+		counts[result.Variant]++
+	}
+	if counts["classic"] < 900 || counts["compact"] < 900 || counts["off"] != 0 {
+		t.Errorf("50/50 split served %v, want roughly 1000 each and no weightless variant", counts)
+	}
+
+	// A rule's variant applies to matching contexts, an assignment's variant wins over it
+	user := &model.User{Email: "a@example.com"}
+	_ = userRepo.Create(ctx, user)
+	enterprise := &dto.EvaluationContext{UserID: &user.ID, Attributes: map[string]any{"plan": "enterprise"}}
+	zero := 0
+	if _, err := svc.UpdateFeatureFlag(adminContext(), flag.ID, &dto.UpdateFeatureFlagRequest{RolloutPercentage: &zero}); err != nil {
+		t.Fatalf("UpdateFeatureFlag() error = %v", err)
+	}
+	result, _ := svc.EvaluateFeatureFlag(ctx, flag.Key, enterprise)
+	if result.Variant != "classic" || result.Reason != api.ReasonRule {
+		t.Errorf("rule match served %q (%s), want classic by rule", result.Variant, result.Reason)
+	}
+	_ = userFFRepo.AssignFeatureFlagToUser(ctx, user.ID, flag.ID, "compact")
+	result, _ = svc.EvaluateFeatureFlag(ctx, flag.Key, enterprise)
+	if result.Variant != "compact" || result.Reason != api.ReasonAssigned {
+		t.Errorf("assigned user served %q (%s), want pinned compact", result.Variant, result.Reason)
+	}
+
+	// Everyone else gets the off variant
+	other := uint(999)
+	result, _ = svc.EvaluateFeatureFlag(ctx, flag.Key, &dto.EvaluationContext{UserID: &other})
+	if result.Enabled || result.Variant != "off" || string(result.Value) != `"none"` {
+		t.Errorf("disabled evaluation = %+v, want off variant", result)
+	}
+}
+
+func TestFeatureFlagService_BooleanFlagValue(t *testing.T) {
+	svc, _, _ := setupFeatureFlagService(t)
+
+	flag, err := svc.CreateFeatureFlag(adminContext(), &dto.CreateFeatureFlagRequest{Key: "dark-mode"})
+	if err != nil {
+		t.Fatalf("CreateFeatureFlag() error = %v", err)
+	}
+	if flag.VariantType != api.VariantTypeBoolean {
+		t.Errorf("VariantType = %q, want boolean by default", flag.VariantType)
+	}
+
+	result, _ := svc.EvaluateFeatureFlag(context.Background(), flag.Key, nil)
+	if string(result.Value) != "false" || result.Variant != "" {
+		t.Errorf("disabled boolean flag = %+v, want value false", result)
+	}
+	enabled := true
+	_, _ = svc.UpdateFeatureFlag(adminContext(), flag.ID, &dto.UpdateFeatureFlagRequest{Enabled: &enabled})
+	result, _ = svc.EvaluateFeatureFlag(context.Background(), flag.Key, nil)
+	if string(result.Value) != "true" {
+		t.Errorf("enabled boolean flag value = %s, want true", result.Value)
+	}
+}
+
+func TestFeatureFlagService_VariantsValidation(t *testing.T) {
+	svc, _, _ := setupFeatureFlagService(t)
+
+	half := func(key, value string) dto.FlagVariant {
+		return dto.FlagVariant{Key: key, Value: json.RawMessage(value), Weight: 50}
+	}
+	invalid := map[string]*dto.CreateFeatureFlagRequest{
+		"weights not 100":      {VariantType: api.VariantTypeNumber, Variants: []dto.FlagVariant{half("a", "1")}},
+		"value of wrong type":  {VariantType: api.VariantTypeNumber, Variants: []dto.FlagVariant{half("a", "1"), half("b", `"2"`)}},
+		"duplicate key":        {VariantType: api.VariantTypeJSON, Variants: []dto.FlagVariant{half("a", "{}"), half("a", "[]")}},
+		"string without any":   {VariantType: api.VariantTypeString},
+		"unknown off variant":  {VariantType: api.VariantTypeBoolean, Variants: []dto.FlagVariant{half("on", "true"), half("also-on", "true")}, OffVariant: "off"},
+		"unknown rule variant": {Rules: []dto.TargetingRule{{Conditions: []dto.TargetingCondition{{Attribute: "plan", Operator: api.OpEquals, Values: []string{"pro"}}}, Variant: "gold"}}},
+	}
+	for name, req := range invalid {
+		req.Key = "bad"
+		if _, err := svc.CreateFeatureFlag(adminContext(), req); !errors.Is(err, ErrInvalidVariants) {
+			t.Errorf("%s: CreateFeatureFlag() error = %v, want ErrInvalidVariants", name, err)
+		}
+	}
+}
+
+// countingUserRepository counts user lookups, to check that bulk evaluation
+// loads the user at most once
+type countingUserRepository struct {
+	*mockUserRepository
+	lookups int
+}
+
+func (r *countingUserRepository) GetByID(ctx context.Context, id uint) (*model.User, error) {
+	r.lookups++
+	return r.mockUserRepository.GetByID(ctx, id)
+}
+
+func TestFeatureFlagService_EvaluateFeatureFlags(t *testing.T) {
+	featureFlagRepo := newMockFeatureFlagRepository()
+	userFFRepo := newMockUserFeatureFlagRepository()
+	userRepo := &countingUserRepository{mockUserRepository: newMockUserRepository()}
+	svc := NewFeatureFlagService(featureFlagRepo, userFFRepo, userRepo, newMockScheduledFlagChangeRepository(), nil, nil, newNoopAudit())
+	ctx := context.Background()
+
+	user := &model.User{Email: "ana@example.com"}
+	_ = userRepo.Create(ctx, user)
+
+	staffRule := []dto.TargetingRule{{Conditions: []dto.TargetingCondition{{Attribute: "email", Operator: api.OpEndsWith, Values: []string{"@example.com"}}}}}
+	for _, req := range []*dto.CreateFeatureFlagRequest{
+		{Key: "global", Enabled: true},
+		{Key: "assigned"},
+		{Key: "staff-only", Rules: staffRule},
+		{Key: "staff-beta", Rules: staffRule},
+		{Key: "off"},
+	} {
+		if _, err := svc.CreateFeatureFlag(adminContext(), req); err != nil {
+			t.Fatalf("CreateFeatureFlag(%s) error = %v", req.Key, err)
+		}
+	}
+	assigned, _ := featureFlagRepo.GetByKey(ctx, "assigned")
+	_ = userFFRepo.AssignFeatureFlagToUser(ctx, user.ID, assigned.ID, "")
+
+	results, err := svc.EvaluateFeatureFlags(ctx, nil, &dto.EvaluationContext{UserID: &user.ID})
+	if err != nil {
+		t.Fatalf("EvaluateFeatureFlags() error = %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("EvaluateFeatureFlags() returned %d flags, want all 5", len(results))
+	}
+	if userRepo.lookups != 1 {
+		t.Errorf("EvaluateFeatureFlags() loaded the user %d times, want once", userRepo.lookups)
+	}
+	if userFFRepo.userLoads != 1 {
+		t.Errorf("EvaluateFeatureFlags() loaded the user's assignments %d times, want once", userFFRepo.userLoads)
+	}
+	want := map[string]string{"global": api.ReasonGlobal, "assigned": api.ReasonAssigned, "staff-only": api.ReasonRule, "staff-beta": api.ReasonRule, "off": api.ReasonDefault}
+	for key, reason := range want {
+		single, err := svc.EvaluateFeatureFlag(ctx, key, &dto.EvaluationContext{UserID: &user.ID})
+		if err != nil {
+			t.Fatalf("EvaluateFeatureFlag(%s) error = %v", key, err)
+		}
+		if got := results[key]; got.Reason != reason || got.Enabled != single.Enabled {
+			t.Errorf("%s: bulk = %+v, want reason %s matching single evaluation %+v", key, got, reason, single)
+		}
+	}
+	// Uncached, a single evaluation loads only its flag's assignment
+	if userFFRepo.userLoads != 1 {
+		t.Errorf("EvaluateFeatureFlag() loaded all of the user's assignments")
+	}
+
+	// A requested subset leaves out other and unknown flags
+	results, err = svc.EvaluateFeatureFlags(ctx, []string{"global", "missing"}, nil)
+	if err != nil {
+		t.Fatalf("EvaluateFeatureFlags() error = %v", err)
+	}
+	if len(results) != 1 || !results["global"].Enabled {
+		t.Errorf("EvaluateFeatureFlags(global, missing) = %+v, want only global", results)
+	}
+}
+
+func TestFeatureFlagService_Prerequisites(t *testing.T) {
+	svc, featureFlagRepo, userFFRepo := setupFeatureFlagService(t)
+	ctx := context.Background()
+
+	for _, req := range []*dto.CreateFeatureFlagRequest{
+		{Key: "use-transactions-v2"},
+		{Key: "layout", VariantType: api.VariantTypeString, Variants: []dto.FlagVariant{
+			{Key: "classic", Value: json.RawMessage(`"classic"`), Weight: 100},
+			{Key: "compact", Value: json.RawMessage(`"compact"`), Weight: 0},
+		}},
+		{Key: "use-transactions-v2-export", Enabled: true, Prerequisites: []dto.FlagPrerequisite{{FlagKey: "use-transactions-v2"}}},
+		{Key: "compact-export", Enabled: true, Prerequisites: []dto.FlagPrerequisite{{FlagKey: "use-transactions-v2-export"}, {FlagKey: "layout", Variant: "compact"}}},
+	} {
+		if _, err := svc.CreateFeatureFlag(adminContext(), req); err != nil {
+			t.Fatalf("CreateFeatureFlag(%s) error = %v", req.Key, err)
+		}
+	}
+
+	evaluate := func(key string, userID uint) *dto.FlagEvaluationResponse {
+		t.Helper()
+		result, err := svc.EvaluateFeatureFlag(ctx, key, &dto.EvaluationContext{UserID: &userID})
+		if err != nil {
+			t.Fatalf("EvaluateFeatureFlag(%s) error = %v", key, err)
+		}
+		return result
+	}
+
+	// Globally on, but the prerequisite is off for everyone
+	if got := evaluate("use-transactions-v2-export", 1); got.Enabled || got.Reason != api.ReasonPrerequisite || got.Prerequisite != "use-transactions-v2" {
+		t.Errorf("export without v2 = %+v, want off by prerequisite use-transactions-v2", got)
+	}
+
+	// Enabling the prerequisite for one user enables the dependent for them only
+	v2, _ := featureFlagRepo.GetByKey(ctx, "use-transactions-v2")
+	_ = userFFRepo.AssignFeatureFlagToUser(ctx, 1, v2.ID, "")
+	if got := evaluate("use-transactions-v2-export", 1); !got.Enabled || got.Reason != api.ReasonGlobal {
+		t.Errorf("export with v2 = %+v, want enabled", got)
+	}
+	otherUser := uint(2)
+	if on, _ := svc.CheckFeatureFlag(ctx, "use-transactions-v2-export", &otherUser); on {
+		t.Error("CheckFeatureFlag() enabled export for a user without v2")
+	}
+
+	// Prerequisites chain, and a required variant must be the one served
+	if got := evaluate("compact-export", 1); got.Enabled || got.Prerequisite != "layout" {
+		t.Errorf("compact-export with classic layout = %+v, want off by prerequisite layout", got)
+	}
+	layout, _ := featureFlagRepo.GetByKey(ctx, "layout")
+	_ = userFFRepo.AssignFeatureFlagToUser(ctx, 1, layout.ID, "compact")
+	if got := evaluate("compact-export", 1); !got.Enabled {
+		t.Errorf("compact-export with compact layout = %+v, want enabled", got)
+	}
+}
+
+func TestFeatureFlagService_PrerequisitesValidation(t *testing.T) {
+	svc, featureFlagRepo, _ := setupFeatureFlagService(t)
+	ctx := adminContext()
+
+	for _, req := range []*dto.CreateFeatureFlagRequest{
+		{Key: "a"},
+		{Key: "b", Prerequisites: []dto.FlagPrerequisite{{FlagKey: "a"}}},
+		{Key: "c", Prerequisites: []dto.FlagPrerequisite{{FlagKey: "b"}}},
+	} {
+		if _, err := svc.CreateFeatureFlag(ctx, req); err != nil {
+			t.Fatalf("CreateFeatureFlag(%s) error = %v", req.Key, err)
+		}
+	}
+	a, _ := featureFlagRepo.GetByKey(ctx, "a")
+	b, _ := featureFlagRepo.GetByKey(ctx, "b")
+
+	tests := []struct {
+		name          string
+		id            uint
+		prerequisites []dto.FlagPrerequisite
+	}{
+		{"cycle", a.ID, []dto.FlagPrerequisite{{FlagKey: "c"}}},
+		{"self", a.ID, []dto.FlagPrerequisite{{FlagKey: "a"}}},
+		{"unknown flag", a.ID, []dto.FlagPrerequisite{{FlagKey: "missing"}}},
+		{"unknown variant", a.ID, []dto.FlagPrerequisite{{FlagKey: "b", Variant: "nope"}}},
+		{"duplicate", b.ID, []dto.FlagPrerequisite{{FlagKey: "a"}, {FlagKey: "a"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.UpdateFeatureFlag(ctx, tt.id, &dto.UpdateFeatureFlagRequest{Prerequisites: &tt.prerequisites})
+			if !errors.Is(err, ErrInvalidPrerequisites) {
+				t.Errorf("UpdateFeatureFlag() error = %v, want ErrInvalidPrerequisites", err)
+			}
+		})
+	}
+
+	// A flag others depend on can't be deleted until they let go of it
+	if err := svc.DeleteFeatureFlag(ctx, a.ID); !errors.Is(err, ErrFlagHasDependents) {
+		t.Fatalf("DeleteFeatureFlag(a) error = %v, want ErrFlagHasDependents", err)
+	}
+	if _, err := svc.UpdateFeatureFlag(ctx, b.ID, &dto.UpdateFeatureFlagRequest{Prerequisites: &[]dto.FlagPrerequisite{}}); err != nil {
+		t.Fatalf("UpdateFeatureFlag(b) error = %v", err)
+	}
+	if err := svc.DeleteFeatureFlag(ctx, a.ID); err != nil {
+		t.Errorf("DeleteFeatureFlag(a) after dropping the dependency error = %v", err)
+	}
+
+	if featureFlagRepo.unlockedWrites != 0 {
+		t.Errorf("%d flag writes were made outside the flag lock's transaction", featureFlagRepo.unlockedWrites)
+	}
+}
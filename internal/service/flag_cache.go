@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"identity/internal/model"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxCachedUsers bounds the per-user assignment cache
+const maxCachedUsers = 10000
+
+// FlagCacheStats are the cache's counters since startup
+type FlagCacheStats struct {
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	HitRate       float64 `json:"hit_rate"`
+	Invalidations int64   `json:"invalidations"`
+	CachedUsers   int     `json:"cached_users"`
+}
+
+// FlagCache keeps flags and per-user assignments in memory for evaluation.
+// The FlagChangeFeed invalidates it on every change made by any replica; the
+// TTL bounds staleness should a notification be missed. A nil *FlagCache
+// disables caching.
+type FlagCache struct {
+	ttl time.Duration
+
+	mu            sync.RWMutex
+	flags         map[string]*model.FeatureFlag
+	flagsLoadedAt time.Time
+	flagsGen      uint64
+	users         map[uint]*cachedAssignments
+	usersGen      uint64
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	invalidations atomic.Int64
+}
+
+type cachedAssignments struct {
+	byFlag   map[uint]*model.UserFeatureFlag
+	loadedAt time.Time
+}
+
+// NewFlagCache creates a flag cache whose entries live for at most ttl
+func NewFlagCache(ttl time.Duration) *FlagCache {
+	return &FlagCache{
+		ttl:   ttl,
+		users: make(map[uint]*cachedAssignments),
+	}
+}
+
+// allFlags returns every flag by key, from the cache or via load
+func (c *FlagCache) allFlags(ctx context.Context, load func(context.Context) ([]model.FeatureFlag, error)) (map[string]*model.FeatureFlag, error) {
+	if c == nil {
+		return loadFlagMap(ctx, load)
+	}
+
+	c.mu.RLock()
+	flags, gen := c.flags, c.flagsGen
+	fresh := flags != nil && time.Since(c.flagsLoadedAt) < c.ttl
+	c.mu.RUnlock()
+	if fresh {
+		c.hits.Add(1)
+		return flags, nil
+	}
+	c.misses.Add(1)
+
+	flags, err := loadFlagMap(ctx, load)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	// An invalidation that raced with the load wins: don't cache stale data
+	if c.flagsGen == gen {
+		c.flags = flags
+		c.flagsLoadedAt = time.Now()
+	}
+	c.mu.Unlock()
+	return flags, nil
+}
+
+func loadFlagMap(ctx context.Context, load func(context.Context) ([]model.FeatureFlag, error)) (map[string]*model.FeatureFlag, error) {
+	rows, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flags := make(map[string]*model.FeatureFlag, len(rows))
+	for i := range rows {
+		flags[rows[i].Key] = &rows[i]
+	}
+	return flags, nil
+}
+
+// userAssignments returns a user's assignments by flag ID, from the cache or via load
+func (c *FlagCache) userAssignments(ctx context.Context, userID uint, load func(context.Context, uint) ([]model.UserFeatureFlag, error)) (map[uint]*model.UserFeatureFlag, error) {
+	if c == nil {
+		return loadAssignmentMap(ctx, userID, load)
+	}
+
+	c.mu.RLock()
+	entry, gen := c.users[userID], c.usersGen
+	c.mu.RUnlock()
+	if entry != nil && time.Since(entry.loadedAt) < c.ttl {
+		c.hits.Add(1)
+		return entry.byFlag, nil
+	}
+	c.misses.Add(1)
+
+	byFlag, err := loadAssignmentMap(ctx, userID, load)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	// An invalidation that raced with the load wins: don't cache stale data
+	if c.usersGen == gen {
+		if len(c.users) >= maxCachedUsers {
+			c.evictUsersLocked()
+		}
+		c.users[userID] = &cachedAssignments{byFlag: byFlag, loadedAt: time.Now()}
+	}
+	c.mu.Unlock()
+	return byFlag, nil
+}
+
+func loadAssignmentMap(ctx context.Context, userID uint, load func(context.Context, uint) ([]model.UserFeatureFlag, error)) (map[uint]*model.UserFeatureFlag, error) {
+	rows, err := load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	byFlag := make(map[uint]*model.UserFeatureFlag, len(rows))
+	for i := range rows {
+		byFlag[rows[i].FeatureFlagID] = &rows[i]
+	}
+	return byFlag, nil
+}
+
+// evictUsersLocked drops expired user entries, or all of them if none has
+// expired yet. Callers hold c.mu.
+func (c *FlagCache) evictUsersLocked() {
+	for id, entry := range c.users {
+		if time.Since(entry.loadedAt) >= c.ttl {
+			delete(c.users, id)
+		}
+	}
+	if len(c.users) >= maxCachedUsers {
+		c.users = make(map[uint]*cachedAssignments)
+	}
+}
+
+// apply drops whatever a change made stale
+func (c *FlagCache) apply(change model.FlagChange) {
+	if c == nil {
+		return
+	}
+	switch {
+	case change.Kind == model.FlagChangeFlag:
+		c.invalidations.Add(1)
+		c.mu.Lock()
+		c.flags = nil
+		c.flagsGen++
+		c.mu.Unlock()
+	case change.Kind == model.FlagChangeAssignment && change.UserID != nil:
+		c.invalidations.Add(1)
+		c.mu.Lock()
+		delete(c.users, *change.UserID)
+		c.usersGen++
+		c.mu.Unlock()
+	default:
+		c.InvalidateAll()
+	}
+}
+
+// InvalidateAll drops everything cached, e.g. after reconnecting to the
+// notification channel, when changes may have been missed
+func (c *FlagCache) InvalidateAll() {
+	if c == nil {
+		return
+	}
+	c.invalidations.Add(1)
+	c.mu.Lock()
+	c.flags = nil
+	c.flagsGen++
+	c.users = make(map[uint]*cachedAssignments)
+	c.usersGen++
+	c.mu.Unlock()
+}
+
+// Stats returns the cache's counters
+func (c *FlagCache) Stats() FlagCacheStats {
+	if c == nil {
+		return FlagCacheStats{}
+	}
+	stats := FlagCacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Invalidations: c.invalidations.Load(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	c.mu.RLock()
+	stats.CachedUsers = len(c.users)
+	c.mu.RUnlock()
+	return stats
+}
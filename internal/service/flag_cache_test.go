@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"testing"
+	"time"
+)
+
+// countingFeatureFlagRepository counts bulk flag loads
+type countingFeatureFlagRepository struct {
+	*mockFeatureFlagRepository
+	loads int
+}
+
+func (r *countingFeatureFlagRepository) GetByKeys(ctx context.Context, keys []string) ([]model.FeatureFlag, error) {
+	r.loads++
+	return r.mockFeatureFlagRepository.GetByKeys(ctx, keys)
+}
+
+func TestFlagCache_ServesAndInvalidates(t *testing.T) {
+	cache := NewFlagCache(time.Minute)
+	changeRepo := newMockFlagChangeRepository()
+	feed := newTestFlagChangeFeed(changeRepo, cache)
+	featureFlagRepo := &countingFeatureFlagRepository{mockFeatureFlagRepository: newMockFeatureFlagRepository()}
+	userFFRepo := newMockUserFeatureFlagRepository()
+	userRepo := newMockUserRepository()
+	flags := NewFeatureFlagService(featureFlagRepo, userFFRepo, userRepo, newMockScheduledFlagChangeRepository(), cache, feed, newNoopAudit())
+	users := NewUserService(userRepo, featureFlagRepo, userFFRepo, feed, newNoopAudit())
+	ctx := context.Background()
+
+	flag, err := flags.CreateFeatureFlag(adminContext(), &dto.CreateFeatureFlagRequest{Key: "beta"})
+	if err != nil {
+		t.Fatalf("CreateFeatureFlag() error = %v", err)
+	}
+	user, _ := users.CreateUser(adminContext(), &dto.CreateUserRequest{Name: "Jane", Email: "jane@example.com"})
+
+	for i := 0; i < 3; i++ {
+		if on, _ := flags.CheckFeatureFlag(ctx, "beta", &user.ID); on {
+			t.Fatal("CheckFeatureFlag() = true before any assignment")
+		}
+	}
+	if featureFlagRepo.loads != 1 {
+		t.Errorf("flags loaded %d times for 3 checks, want once", featureFlagRepo.loads)
+	}
+	if stats := cache.Stats(); stats.Hits != 4 || stats.Misses != 2 {
+		t.Errorf("Stats() = %+v, want 4 hits and 2 misses (flags + assignments)", stats)
+	}
+
+	// Assigning through the user service drops that user's cached assignments
+	if err := users.AssignFeatureFlagToUser(adminContext(), user.ID, "beta", ""); err != nil {
+		t.Fatalf("AssignFeatureFlagToUser() error = %v", err)
+	}
+	if on, _ := flags.CheckFeatureFlag(ctx, "beta", &user.ID); !on {
+		t.Error("CheckFeatureFlag() = false after assignment, want the cache invalidated")
+	}
+
+	// Updating a flag drops the cached flags
+	enabled := true
+	if _, err := flags.UpdateFeatureFlag(adminContext(), flag.ID, &dto.UpdateFeatureFlagRequest{Enabled: &enabled}); err != nil {
+		t.Fatalf("UpdateFeatureFlag() error = %v", err)
+	}
+	if on, _ := flags.CheckFeatureFlag(ctx, "beta", nil); !on {
+		t.Error("CheckFeatureFlag() = false after enabling, want the cache invalidated")
+	}
+
+	if len(changeRepo.changes) != 3 {
+		t.Errorf("logged %+v, want the create, assign and update", changeRepo.changes)
+	}
+}
+
+func TestFlagCache_HandleNotification(t *testing.T) {
+	cache := NewFlagCache(time.Minute)
+	feed := newTestFlagChangeFeed(newMockFlagChangeRepository(), cache)
+	featureFlagRepo := &countingFeatureFlagRepository{mockFeatureFlagRepository: newMockFeatureFlagRepository()}
+	flags := NewFeatureFlagService(featureFlagRepo, newMockUserFeatureFlagRepository(), newMockUserRepository(), newMockScheduledFlagChangeRepository(), cache, feed, newNoopAudit())
+	ctx := context.Background()
+
+	_ = featureFlagRepo.Create(ctx, &model.FeatureFlag{Key: "beta"})
+	_, _ = flags.CheckFeatureFlag(ctx, "beta", nil)
+
+	// Another replica changed a flag
+	feed.HandleNotification(`{"id":1,"kind":"flag","flag_key":"beta"}`)
+	_, _ = flags.CheckFeatureFlag(ctx, "beta", nil)
+	// Unreadable payloads drop everything rather than risk staleness
+	feed.HandleNotification(`garbage`)
+	_, _ = flags.CheckFeatureFlag(ctx, "beta", nil)
+
+	if featureFlagRepo.loads != 3 {
+		t.Errorf("flags loaded %d times, want a reload after each notification", featureFlagRepo.loads)
+	}
+}
+
+func TestFlagCache_TTL(t *testing.T) {
+	cache := NewFlagCache(time.Millisecond)
+	featureFlagRepo := &countingFeatureFlagRepository{mockFeatureFlagRepository: newMockFeatureFlagRepository()}
+	flags := NewFeatureFlagService(featureFlagRepo, newMockUserFeatureFlagRepository(), newMockUserRepository(), newMockScheduledFlagChangeRepository(), cache, nil, newNoopAudit())
+	ctx := context.Background()
+
+	_ = featureFlagRepo.Create(ctx, &model.FeatureFlag{Key: "beta"})
+	_, _ = flags.CheckFeatureFlag(ctx, "beta", nil)
+	time.Sleep(5 * time.Millisecond)
+	_, _ = flags.CheckFeatureFlag(ctx, "beta", nil)
+
+	if featureFlagRepo.loads != 2 {
+		t.Errorf("flags loaded %d times, want a reload once the TTL passed", featureFlagRepo.loads)
+	}
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"identity/internal/model"
+	"identity/internal/repository"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// FlagChangesChannel is the Postgres NOTIFY channel every flag change log
+// entry is announced on (by a trigger on flag_changes)
+const FlagChangesChannel = "flag_changes"
+
+const (
+	// flagChangeRetention is how long the change log is kept; streams
+	// resuming from an older event get a fresh snapshot instead
+	flagChangeRetention = 7 * 24 * time.Hour
+	// flagChangePruneInterval is how often changes past the retention are
+	// deleted
+	flagChangePruneInterval = time.Hour
+	// maxReplayChanges bounds a resume; a longer backlog is replaced by a snapshot
+	maxReplayChanges = 1000
+)
+
+// ErrResyncRequired is returned when a stream can't be resumed from the
+// requested event and needs a fresh snapshot
+var ErrResyncRequired = errors.New("flag stream must resync from a snapshot")
+
+// FlagChangeFeed records flag and assignment changes in the flag change log
+// and relays the log's notifications from every replica (including this one)
+// to the flag cache and to this replica's flag streams. A nil *FlagChangeFeed
+// records nothing.
+type FlagChangeFeed struct {
+	repo   repository.FlagChangeRepository
+	cache  *FlagCache
+	logger *slog.Logger
+
+	mu          sync.Mutex
+	subscribers map[chan struct{}]struct{}
+	closed      bool
+}
+
+// NewFlagChangeFeed creates a change feed that keeps cache (which may be nil) fresh
+func NewFlagChangeFeed(repo repository.FlagChangeRepository, cache *FlagCache, logger *slog.Logger) *FlagChangeFeed {
+	return &FlagChangeFeed{
+		repo:        repo,
+		cache:       cache,
+		logger:      logger,
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// FlagChanged records that a flag was created, updated or deleted
+func (f *FlagChangeFeed) FlagChanged(ctx context.Context, key string) {
+	f.record(ctx, model.FlagChange{Kind: model.FlagChangeFlag, FlagKey: key})
+}
+
+// AssignmentChanged records that a user's assignment to a flag changed
+func (f *FlagChangeFeed) AssignmentChanged(ctx context.Context, userID uint, key string) {
+	f.record(ctx, model.FlagChange{Kind: model.FlagChangeAssignment, FlagKey: key, UserID: &userID})
+}
+
+func (f *FlagChangeFeed) record(ctx context.Context, change model.FlagChange) {
+	if f == nil {
+		return
+	}
+	// Reads on this replica see the change right away; the notification
+	// that follows takes care of everyone else
+	f.cache.apply(change)
+
+	if err := f.repo.Create(ctx, &change); err != nil {
+		// Other replicas' caches catch up when their entries expire; streams
+		// only see the change with the next one that is logged
+		f.logger.Error("failed to log flag change", "kind", change.Kind, "flag_key", change.FlagKey, "error", err)
+	}
+}
+
+// Prune deletes the changes logged longer ago than the retention period
+func (f *FlagChangeFeed) Prune(ctx context.Context) error {
+	return f.repo.DeleteBefore(ctx, time.Now().Add(-flagChangeRetention))
+}
+
+// RunPruner prunes the change log every hour until ctx is done. Every
+// replica runs it; pruning twice does no harm.
+func (f *FlagChangeFeed) RunPruner(ctx context.Context) {
+	ticker := time.NewTicker(flagChangePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.Prune(ctx); err != nil && ctx.Err() == nil {
+			f.logger.Warn("failed to prune flag change log", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// HandleNotification applies a change announced on FlagChangesChannel.
+// Unreadable payloads drop everything cached.
+func (f *FlagChangeFeed) HandleNotification(payload string) {
+	var change model.FlagChange
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		f.logger.Warn("unreadable flag change notification", "payload", payload, "error", err)
+		f.cache.InvalidateAll()
+	} else {
+		f.cache.apply(change)
+	}
+	f.wake()
+}
+
+// Resync drops everything cached and wakes all streams, e.g. after
+// reconnecting to the notification channel, when changes may have been missed
+func (f *FlagChangeFeed) Resync() {
+	f.cache.InvalidateAll()
+	f.wake()
+}
+
+// Subscribe returns a channel that receives a signal whenever changes may
+// have been logged, and a func to unsubscribe. Signals coalesce: receivers
+// read the log to find out what changed. The channel is closed when the feed
+// shuts down.
+func (f *FlagChangeFeed) Subscribe() (<-chan struct{}, func()) {
+	if f == nil {
+		return nil, func() {}
+	}
+	ch := make(chan struct{}, 1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	f.subscribers[ch] = struct{}{}
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.subscribers[ch]; ok {
+			delete(f.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (f *FlagChangeFeed) wake() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close ends every subscription, so open streams finish on shutdown
+func (f *FlagChangeFeed) Close() {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for ch := range f.subscribers {
+		close(ch)
+	}
+	f.subscribers = make(map[chan struct{}]struct{})
+}
+
+// latestID returns the ID of the most recent logged change, or 0
+func (f *FlagChangeFeed) latestID(ctx context.Context) (uint64, error) {
+	if f == nil {
+		return 0, nil
+	}
+	_, latest, err := f.repo.Bounds(ctx)
+	return latest, err
+}
+
+// since returns the changes logged after afterID, or ErrResyncRequired when
+// some of them are no longer in the log
+func (f *FlagChangeFeed) since(ctx context.Context, afterID uint64) ([]model.FlagChange, error) {
+	if f == nil {
+		return nil, nil
+	}
+	oldest, latest, err := f.repo.Bounds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case afterID == latest:
+		return nil, nil
+	case afterID > latest, afterID+1 < oldest:
+		// From another database, or older than the retained log
+		return nil, ErrResyncRequired
+	}
+
+	changes, err := f.repo.ListSince(ctx, afterID, maxReplayChanges+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > maxReplayChanges {
+		return nil, ErrResyncRequired
+	}
+	return changes, nil
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"identity/internal/model"
+	"identity/pkg/api"
+)
+
+// ErrInvalidRules is wrapped by errors from validating targeting rules
+var ErrInvalidRules = errors.New("invalid targeting rules")
+
+// identityAttributes name the attributes taken from the user record. They are
+// dropped from caller-supplied attributes, with or without a user, so nobody
+// can claim e.g. a staff email on the public check endpoints.
+var identityAttributes = map[string]bool{
+	"user_id":    true,
+	"email":      true,
+	"name":       true,
+	"role":       true,
+	"created_at": true,
+}
+
+// userAttributes are the evaluation attributes taken from the user record
+func userAttributes(user *model.User) map[string]any {
+	return map[string]any{
+		"user_id":    user.ID,
+		"email":      user.Email,
+		"name":       user.Name,
+		"role":       string(user.Role),
+		"created_at": user.CreatedAt,
+	}
+}
+
+// validateRules rejects rules that could never be evaluated meaningfully
+func validateRules(rules []model.TargetingRule) error {
+	for i, rule := range rules {
+		if len(rule.Conditions) == 0 {
+			return fmt.Errorf("%w: rule %d has no conditions", ErrInvalidRules, i+1)
+		}
+		for _, cond := range rule.Conditions {
+			if cond.Attribute == "" {
+				return fmt.Errorf("%w: rule %d has a condition without an attribute", ErrInvalidRules, i+1)
+			}
+			if len(cond.Values) == 0 {
+				return fmt.Errorf("%w: rule %d condition on %q has no values", ErrInvalidRules, i+1, cond.Attribute)
+			}
+			switch cond.Operator {
+			case api.OpEquals, api.OpNotEquals, api.OpIn, api.OpNotIn,
+				api.OpContains, api.OpStartsWith, api.OpEndsWith:
+			case api.OpBefore, api.OpAfter:
+				for _, v := range cond.Values {
+					if _, ok := api.ParseDate(v); !ok {
+						return fmt.Errorf("%w: rule %d condition on %q: %q is not a date", ErrInvalidRules, i+1, cond.Attribute, v)
+					}
+				}
+			default:
+				return fmt.Errorf("%w: rule %d has unknown operator %q", ErrInvalidRules, i+1, cond.Operator)
+			}
+		}
+	}
+	return nil
+}
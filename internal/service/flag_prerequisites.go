@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"identity/internal/model"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrInvalidPrerequisites is wrapped by errors from validating a flag's prerequisites
+	ErrInvalidPrerequisites = errors.New("invalid prerequisites")
+	// ErrFlagHasDependents is returned when deleting a flag other flags depend on
+	ErrFlagHasDependents = errors.New("feature flag is a prerequisite of other flags")
+)
+
+// validatePrerequisites checks a flag about to be saved against the
+// dependency graph: its prerequisites must exist (serving the required
+// variant, if any) and must not lead back to it, and the variants flags
+// depending on it require must still exist. It must run under the flag lock
+// (see FeatureFlagRepository.RunLocked), so the graph can't change before
+// the flag is saved.
+func (s *featureFlagService) validatePrerequisites(ctx context.Context, flag *model.FeatureFlag) error {
+	// A new flag without prerequisites has nothing to check: nothing can
+	// depend on it yet
+	if flag.ID == 0 && len(flag.Prerequisites) == 0 {
+		return nil
+	}
+
+	edges, err := s.featureFlagRepo.GetWithPrerequisites(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get feature flag prerequisites: %w", err)
+	}
+	byKey := make(map[string]*model.FeatureFlag, len(edges)+1)
+	for i := range edges {
+		byKey[edges[i].Key] = &edges[i]
+	}
+	byKey[flag.Key] = flag
+
+	preFlags, err := s.prerequisiteFlags(ctx, flag)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(flag.Prerequisites))
+	for _, pre := range flag.Prerequisites {
+		if pre.FlagKey == flag.Key {
+			return fmt.Errorf("%w: a flag can't be its own prerequisite", ErrInvalidPrerequisites)
+		}
+		if seen[pre.FlagKey] {
+			return fmt.Errorf("%w: duplicate prerequisite %q", ErrInvalidPrerequisites, pre.FlagKey)
+		}
+		seen[pre.FlagKey] = true
+		preFlag, ok := preFlags[pre.FlagKey]
+		if !ok {
+			return fmt.Errorf("%w: unknown flag %q", ErrInvalidPrerequisites, pre.FlagKey)
+		}
+		if pre.Variant != "" && findVariant(preFlag, pre.Variant) == nil {
+			return fmt.Errorf("%w: %q has no variant %q", ErrInvalidPrerequisites, pre.FlagKey, pre.Variant)
+		}
+	}
+
+	if cycle := prerequisiteCycle(byKey, flag.Key); cycle != nil {
+		return fmt.Errorf("%w: cycle %s", ErrInvalidPrerequisites, strings.Join(cycle, " → "))
+	}
+
+	for _, dependent := range dependentsOf(edges, flag.Key) {
+		for _, pre := range dependent.Prerequisites {
+			if pre.FlagKey == flag.Key && pre.Variant != "" && findVariant(flag, pre.Variant) == nil {
+				return fmt.Errorf("%w: %q requires variant %q", ErrInvalidPrerequisites, dependent.Key, pre.Variant)
+			}
+		}
+	}
+	return nil
+}
+
+// prerequisiteFlags loads the flags a flag lists as prerequisites, by key
+func (s *featureFlagService) prerequisiteFlags(ctx context.Context, flag *model.FeatureFlag) (map[string]*model.FeatureFlag, error) {
+	byKey := make(map[string]*model.FeatureFlag, len(flag.Prerequisites))
+	if len(flag.Prerequisites) == 0 {
+		return byKey, nil
+	}
+	keys := make([]string, len(flag.Prerequisites))
+	for i, pre := range flag.Prerequisites {
+		keys[i] = pre.FlagKey
+	}
+	flags, err := s.featureFlagRepo.GetByKeys(ctx, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feature flags: %w", err)
+	}
+	for i := range flags {
+		byKey[flags[i].Key] = &flags[i]
+	}
+	return byKey, nil
+}
+
+// prerequisiteCycle returns a path of prerequisites leading from key back to
+// itself, or nil if there is none. Saved flags never form a cycle, so only
+// the flag being saved needs checking.
+func prerequisiteCycle(byKey map[string]*model.FeatureFlag, key string) []string {
+	visited := make(map[string]bool)
+	var walk func(current string, path []string) []string
+	walk = func(current string, path []string) []string {
+		flag, ok := byKey[current]
+		if !ok {
+			return nil
+		}
+		for _, pre := range flag.Prerequisites {
+			next := append(path[:len(path):len(path)], pre.FlagKey)
+			if pre.FlagKey == key {
+				return next
+			}
+			if visited[pre.FlagKey] {
+				continue
+			}
+			visited[pre.FlagKey] = true
+			if cycle := walk(pre.FlagKey, next); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+	return walk(key, []string{key})
+}
+
+// dependentsOf returns the flags that list key as a prerequisite, by key
+func dependentsOf(flags []model.FeatureFlag, key string) []model.FeatureFlag {
+	var dependents []model.FeatureFlag
+	for _, flag := range flags {
+		for _, pre := range flag.Prerequisites {
+			if pre.FlagKey == key {
+				dependents = append(dependents, flag)
+				break
+			}
+		}
+	}
+	sort.Slice(dependents, func(i, j int) bool { return dependents[i].Key < dependents[j].Key })
+	return dependents
+}
+
+// checkNoDependents returns ErrFlagHasDependents, naming them, if other
+// flags depend on the flag with the given key. Like validatePrerequisites,
+// it must run under the flag lock.
+func (s *featureFlagService) checkNoDependents(ctx context.Context, key string) error {
+	edges, err := s.featureFlagRepo.GetWithPrerequisites(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get feature flag prerequisites: %w", err)
+	}
+	dependents := dependentsOf(edges, key)
+	if len(dependents) == 0 {
+		return nil
+	}
+	keys := make([]string, len(dependents))
+	for i, flag := range dependents {
+		keys[i] = flag.Key
+	}
+	return fmt.Errorf("%w: required by %s", ErrFlagHasDependents, strings.Join(keys, ", "))
+}
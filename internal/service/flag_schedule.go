@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxDueFlagChanges bounds how many scheduled changes one scheduler run applies
+const maxDueFlagChanges = 100
+
+// ErrInvalidSchedule is wrapped by errors from validating a scheduled flag change
+var ErrInvalidSchedule = errors.New("invalid scheduled flag change")
+
+// ScheduleFlagChange schedules enabling, disabling or setting the rollout
+// percentage of a flag at a future time
+func (s *featureFlagService) ScheduleFlagChange(ctx context.Context, flagID uint, req *dto.ScheduleFlagChangeRequest) (*dto.ScheduledFlagChangeResponse, error) {
+	if err := authorize(ctx, PermFlagsWrite); err != nil {
+		return nil, err
+	}
+
+	flag, err := s.featureFlagRepo.GetByID(ctx, flagID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("feature flag not found")
+		}
+		return nil, fmt.Errorf("failed to get feature flag: %w", err)
+	}
+
+	change := &model.ScheduledFlagChange{
+		FeatureFlagID: flag.ID,
+		Action:        req.Action,
+		RunAt:         req.RunAt.UTC(),
+		Status:        model.ScheduledFlagPending,
+		CreatedBy:     ActorFromContext(ctx),
+	}
+	switch req.Action {
+	case model.ScheduledFlagEnable, model.ScheduledFlagDisable:
+	case model.ScheduledFlagSetRollout:
+		if req.RolloutPercentage == nil {
+			return nil, fmt.Errorf("%w: set_rollout needs a rollout percentage", ErrInvalidSchedule)
+		}
+		if err := validateRolloutPercentage(*req.RolloutPercentage); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+		}
+		change.RolloutPercentage = req.RolloutPercentage
+	default:
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidSchedule, req.Action)
+	}
+	if !change.RunAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: run time must be in the future", ErrInvalidSchedule)
+	}
+
+	if err := s.scheduleRepo.Create(ctx, change); err != nil {
+		return nil, fmt.Errorf("failed to schedule flag change: %w", err)
+	}
+	change.FeatureFlag = flag
+
+	s.audit.Log(ctx, nil, AuditFlagChangeScheduled, "feature_flag", flag.Key, scheduledChangeDetails(change))
+
+	return toScheduledFlagChangeResponse(change), nil
+}
+
+// GetScheduledFlagChanges lists the scheduled flag changes still pending,
+// soonest first
+func (s *featureFlagService) GetScheduledFlagChanges(ctx context.Context) ([]dto.ScheduledFlagChangeResponse, error) {
+	if err := authorize(ctx, PermFlagsRead); err != nil {
+		return nil, err
+	}
+
+	changes, err := s.scheduleRepo.ListPending(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled flag changes: %w", err)
+	}
+
+	responses := make([]dto.ScheduledFlagChangeResponse, len(changes))
+	for i := range changes {
+		responses[i] = *toScheduledFlagChangeResponse(&changes[i])
+	}
+	return responses, nil
+}
+
+// CancelScheduledFlagChange cancels a pending scheduled flag change
+func (s *featureFlagService) CancelScheduledFlagChange(ctx context.Context, id uint) error {
+	if err := authorize(ctx, PermFlagsWrite); err != nil {
+		return err
+	}
+
+	change, err := s.scheduleRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("scheduled flag change not found")
+		}
+		return fmt.Errorf("failed to get scheduled flag change: %w", err)
+	}
+
+	cancelled, err := s.scheduleRepo.Cancel(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to cancel scheduled flag change: %w", err)
+	}
+	if !cancelled {
+		return fmt.Errorf("%w: change is no longer pending", ErrInvalidSchedule)
+	}
+
+	s.audit.Log(ctx, nil, AuditFlagChangeCancelled, "feature_flag", scheduledChangeFlagKey(change), scheduledChangeDetails(change))
+
+	return nil
+}
+
+// RunScheduledFlagChanges applies the scheduled changes that are due and
+// returns how many were applied. Replicas may run it at the same time: each
+// change is claimed in the transaction that applies it, so it is applied
+// exactly once, and never after a cancel. A change whose flag was deleted is
+// marked failed. Like CheckFeatureFlag it requires no permission: it acts
+// for whoever scheduled each change.
+func (s *featureFlagService) RunScheduledFlagChanges(ctx context.Context) (int, error) {
+	due, err := s.scheduleRepo.ListDue(ctx, time.Now(), maxDueFlagChanges)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get due flag changes: %w", err)
+	}
+
+	applied := 0
+	for i := range due {
+		ok, err := s.applyScheduledChange(ctx, &due[i])
+		if err != nil {
+			return applied, err
+		}
+		if ok {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// applyScheduledChange applies a due change to its flag, reporting false if
+// it was cancelled or applied elsewhere meanwhile, or could not be applied
+func (s *featureFlagService) applyScheduledChange(ctx context.Context, change *model.ScheduledFlagChange) (bool, error) {
+	flag, applied, err := s.scheduleRepo.Apply(ctx, change, time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if err := s.scheduleRepo.MarkFailed(ctx, change.ID, "feature flag not found"); err != nil {
+			return false, fmt.Errorf("failed to mark scheduled flag change failed: %w", err)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to apply scheduled flag change: %w", err)
+	}
+	if !applied {
+		return false, nil
+	}
+	s.changes.FlagChanged(ctx, flag.Key)
+
+	details := scheduledChangeDetails(change)
+	details["enabled"] = flag.Enabled
+	details["rollout_percentage"] = flag.RolloutPercentage
+	s.audit.Log(ctx, change.CreatedBy, AuditFlagToggled, "feature_flag", flag.Key, details)
+
+	return true, nil
+}
+
+// RunFlagScheduler applies due scheduled flag changes every interval until
+// ctx is done. Every replica runs it; claiming each change keeps them from
+// applying the same one twice.
+func RunFlagScheduler(ctx context.Context, flags FeatureFlagService, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		applied, err := flags.RunScheduledFlagChanges(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("failed to run scheduled flag changes", "error", err)
+		} else if applied > 0 {
+			logger.Info("applied scheduled flag changes", "count", applied)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func scheduledChangeDetails(change *model.ScheduledFlagChange) map[string]any {
+	details := map[string]any{
+		"scheduled_change_id": change.ID,
+		"action":              change.Action,
+		"run_at":              change.RunAt.UTC().Format(time.RFC3339),
+	}
+	if change.RolloutPercentage != nil {
+		details["scheduled_rollout_percentage"] = *change.RolloutPercentage
+	}
+	return details
+}
+
+// scheduledChangeFlagKey returns the key of a change's flag, which is not
+// loaded once the flag is deleted
+func scheduledChangeFlagKey(change *model.ScheduledFlagChange) string {
+	if change.FeatureFlag == nil {
+		return ""
+	}
+	return change.FeatureFlag.Key
+}
+
+func toScheduledFlagChangeResponse(change *model.ScheduledFlagChange) *dto.ScheduledFlagChangeResponse {
+	resp := &dto.ScheduledFlagChangeResponse{
+		ID:                change.ID,
+		FlagID:            change.FeatureFlagID,
+		FlagKey:           scheduledChangeFlagKey(change),
+		Action:            change.Action,
+		RolloutPercentage: change.RolloutPercentage,
+		RunAt:             change.RunAt,
+		Status:            change.Status,
+		CreatedBy:         change.CreatedBy,
+		ExecutedAt:        change.ExecutedAt,
+		Error:             change.Error,
+		CreatedAt:         change.CreatedAt,
+	}
+	if change.Creator != nil {
+		resp.CreatedByName = change.Creator.Name
+	}
+	return resp
+}
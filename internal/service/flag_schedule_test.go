@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"sort"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// mockScheduledFlagChangeRepository is an in-memory
+// ScheduledFlagChangeRepository, applying changes to the flags of flagRepo
+type mockScheduledFlagChangeRepository struct {
+	changes  map[uint]*model.ScheduledFlagChange
+	flagRepo *mockFeatureFlagRepository
+}
+
+func newMockScheduledFlagChangeRepository() *mockScheduledFlagChangeRepository {
+	return &mockScheduledFlagChangeRepository{changes: make(map[uint]*model.ScheduledFlagChange)}
+}
+
+func (m *mockScheduledFlagChangeRepository) Create(ctx context.Context, change *model.ScheduledFlagChange) error {
+	change.ID = uint(len(m.changes) + 1)
+	stored := *change
+	m.changes[change.ID] = &stored
+	return nil
+}
+
+func (m *mockScheduledFlagChangeRepository) GetByID(ctx context.Context, id uint) (*model.ScheduledFlagChange, error) {
+	change, exists := m.changes[id]
+	if !exists {
+		return nil, gorm.ErrRecordNotFound
+	}
+	c := *change
+	return &c, nil
+}
+
+func (m *mockScheduledFlagChangeRepository) ListPending(ctx context.Context) ([]model.ScheduledFlagChange, error) {
+	return m.list(func(c *model.ScheduledFlagChange) bool { return c.Status == model.ScheduledFlagPending }), nil
+}
+
+func (m *mockScheduledFlagChangeRepository) ListDue(ctx context.Context, now time.Time, limit int) ([]model.ScheduledFlagChange, error) {
+	due := m.list(func(c *model.ScheduledFlagChange) bool {
+		return c.Status == model.ScheduledFlagPending && !c.RunAt.After(now)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (m *mockScheduledFlagChangeRepository) Cancel(ctx context.Context, id uint) (bool, error) {
+	return m.transition(id, model.ScheduledFlagCancelled), nil
+}
+
+func (m *mockScheduledFlagChangeRepository) CancelPendingForFlag(ctx context.Context, featureFlagID uint) error {
+	for _, c := range m.changes {
+		if c.FeatureFlagID == featureFlagID {
+			m.transition(c.ID, model.ScheduledFlagCancelled)
+		}
+	}
+	return nil
+}
+
+func (m *mockScheduledFlagChangeRepository) Apply(ctx context.Context, change *model.ScheduledFlagChange, executedAt time.Time) (*model.FeatureFlag, bool, error) {
+	if stored, exists := m.changes[change.ID]; !exists || stored.Status != model.ScheduledFlagPending {
+		return nil, false, nil
+	}
+	flag, exists := m.flagRepo.flags[change.FeatureFlagID]
+	if !exists {
+		return nil, false, gorm.ErrRecordNotFound
+	}
+	switch change.Action {
+	case model.ScheduledFlagEnable:
+		flag.Enabled = true
+	case model.ScheduledFlagDisable:
+		flag.Enabled = false
+	case model.ScheduledFlagSetRollout:
+		flag.RolloutPercentage = *change.RolloutPercentage
+	}
+	m.transition(change.ID, model.ScheduledFlagDone)
+	m.changes[change.ID].ExecutedAt = &executedAt
+	applied := *flag
+	return &applied, true, nil
+}
+
+func (m *mockScheduledFlagChangeRepository) MarkFailed(ctx context.Context, id uint, reason string) error {
+	if m.transition(id, model.ScheduledFlagFailed) {
+		m.changes[id].Error = reason
+	}
+	return nil
+}
+
+func (m *mockScheduledFlagChangeRepository) transition(id uint, status string) bool {
+	change, exists := m.changes[id]
+	if !exists || change.Status != model.ScheduledFlagPending {
+		return false
+	}
+	change.Status = status
+	return true
+}
+
+// list returns copies of the matching changes in run order
+func (m *mockScheduledFlagChangeRepository) list(match func(*model.ScheduledFlagChange) bool) []model.ScheduledFlagChange {
+	var changes []model.ScheduledFlagChange
+	for _, c := range m.changes {
+		if match(c) {
+			changes = append(changes, *c)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if !changes[i].RunAt.Equal(changes[j].RunAt) {
+			return changes[i].RunAt.Before(changes[j].RunAt)
+		}
+		return changes[i].ID < changes[j].ID
+	})
+	return changes
+}
+
+// recordingAudit is an AuditLogger that keeps its entries, for tests
+type recordingAudit struct {
+	entries []auditEntry
+}
+
+type auditEntry struct {
+	actor    *uint
+	action   string
+	targetID string
+	details  map[string]any
+}
+
+func (a *recordingAudit) Log(ctx context.Context, actorUserID *uint, action, targetType, targetID string, details map[string]any) {
+	a.entries = append(a.entries, auditEntry{actor: actorUserID, action: action, targetID: targetID, details: details})
+}
+
+func setupFlagSchedule(t *testing.T) (FeatureFlagService, *mockFeatureFlagRepository, *mockScheduledFlagChangeRepository, *recordingAudit) {
+	t.Helper()
+	featureFlagRepo := newMockFeatureFlagRepository()
+	scheduleRepo := newMockScheduledFlagChangeRepository()
+	scheduleRepo.flagRepo = featureFlagRepo
+	audit := &recordingAudit{}
+	svc := NewFeatureFlagService(featureFlagRepo, newMockUserFeatureFlagRepository(), newMockUserRepository(), scheduleRepo, nil, nil, audit)
+	return svc, featureFlagRepo, scheduleRepo, audit
+}
+
+func intPtr(v int) *int { return &v }
+
+func TestFeatureFlagService_ScheduleFlagChange(t *testing.T) {
+	svc, _, _, _ := setupFlagSchedule(t)
+	ctx := adminContext()
+	flag, err := svc.CreateFeatureFlag(ctx, &dto.CreateFeatureFlagRequest{Key: "launch"})
+	if err != nil {
+		t.Fatalf("CreateFeatureFlag() error = %v", err)
+	}
+	later := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		flagID  uint
+		req     dto.ScheduleFlagChangeRequest
+		wantErr error
+	}{
+		{"enable", flag.ID, dto.ScheduleFlagChangeRequest{Action: model.ScheduledFlagEnable, RunAt: later}, nil},
+		{"set rollout", flag.ID, dto.ScheduleFlagChangeRequest{Action: model.ScheduledFlagSetRollout, RolloutPercentage: intPtr(50), RunAt: later}, nil},
+		{"rollout missing", flag.ID, dto.ScheduleFlagChangeRequest{Action: model.ScheduledFlagSetRollout, RunAt: later}, ErrInvalidSchedule},
+		{"rollout out of range", flag.ID, dto.ScheduleFlagChangeRequest{Action: model.ScheduledFlagSetRollout, RolloutPercentage: intPtr(101), RunAt: later}, ErrInvalidSchedule},
+		{"unknown action", flag.ID, dto.ScheduleFlagChangeRequest{Action: "delete", RunAt: later}, ErrInvalidSchedule},
+		{"in the past", flag.ID, dto.ScheduleFlagChangeRequest{Action: model.ScheduledFlagEnable, RunAt: time.Now().Add(-time.Minute)}, ErrInvalidSchedule},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.ScheduleFlagChange(ctx, tt.flagID, &tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ScheduleFlagChange() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := svc.ScheduleFlagChange(ctx, 99, &dto.ScheduleFlagChangeRequest{Action: model.ScheduledFlagEnable, RunAt: later}); err == nil || err.Error() != "feature flag not found" {
+		t.Errorf("ScheduleFlagChange(unknown flag) error = %v, want feature flag not found", err)
+	}
+	viewer := WithActorRole(context.Background(), model.RoleViewer)
+	if _, err := svc.ScheduleFlagChange(viewer, flag.ID, &dto.ScheduleFlagChangeRequest{Action: model.ScheduledFlagEnable, RunAt: later}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ScheduleFlagChange(viewer) error = %v, want ErrForbidden", err)
+	}
+
+	pending, err := svc.GetScheduledFlagChanges(viewer)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("GetScheduledFlagChanges() = %d changes, %v, want 2", len(pending), err)
+	}
+	if pending[0].FlagID != flag.ID || pending[0].Action != model.ScheduledFlagEnable || pending[0].CreatedBy == nil || *pending[0].CreatedBy != 1 {
+		t.Errorf("GetScheduledFlagChanges()[0] = %+v, want the enable scheduled by user 1", pending[0])
+	}
+}
+
+func TestFeatureFlagService_RunScheduledFlagChanges(t *testing.T) {
+	svc, featureFlagRepo, scheduleRepo, audit := setupFlagSchedule(t)
+	ctx := adminContext()
+	flag, err := svc.CreateFeatureFlag(ctx, &dto.CreateFeatureFlagRequest{Key: "launch"})
+	if err != nil {
+		t.Fatalf("CreateFeatureFlag() error = %v", err)
+	}
+	enable, _ := svc.ScheduleFlagChange(ctx, flag.ID, &dto.ScheduleFlagChangeRequest{Action: model.ScheduledFlagEnable, RunAt: time.Now().Add(time.Hour)})
+	rollout, _ := svc.ScheduleFlagChange(ctx, flag.ID, &dto.ScheduleFlagChangeRequest{Action: model.ScheduledFlagSetRollout, RolloutPercentage: intPtr(30), RunAt: time.Now().Add(2 * time.Hour)})
+	cancelled, _ := svc.ScheduleFlagChange(ctx, flag.ID, &dto.ScheduleFlagChangeRequest{Action: model.ScheduledFlagDisable, RunAt: time.Now().Add(3 * time.Hour)})
+	if err := svc.CancelScheduledFlagChange(ctx, cancelled.ID); err != nil {
+		t.Fatalf("CancelScheduledFlagChange() error = %v", err)
+	}
+	if err := svc.CancelScheduledFlagChange(ctx, cancelled.ID); !errors.Is(err, ErrInvalidSchedule) {
+		t.Errorf("CancelScheduledFlagChange() twice error = %v, want ErrInvalidSchedule", err)
+	}
+
+	// Nothing is due yet
+	if applied, err := svc.RunScheduledFlagChanges(context.Background()); err != nil || applied != 0 {
+		t.Fatalf("RunScheduledFlagChanges() = %d, %v, want nothing applied", applied, err)
+	}
+
+	// Time passes for everything; the scheduler runs without a user context
+	for _, c := range scheduleRepo.changes {
+		c.RunAt = time.Now().Add(-time.Minute)
+	}
+	// Edits made since the changes were scheduled are kept
+	description := "Launch the new checkout"
+	if _, err := svc.UpdateFeatureFlag(ctx, flag.ID, &dto.UpdateFeatureFlagRequest{Description: &description}); err != nil {
+		t.Fatalf("UpdateFeatureFlag() error = %v", err)
+	}
+	audit.entries = nil
+	applied, err := svc.RunScheduledFlagChanges(context.Background())
+	if err != nil || applied != 2 {
+		t.Fatalf("RunScheduledFlagChanges() = %d, %v, want 2 applied", applied, err)
+	}
+
+	got := featureFlagRepo.flags[flag.ID]
+	if !got.Enabled || got.RolloutPercentage != 30 || got.Description != description {
+		t.Errorf("flag = enabled %v, rollout %d, description %q, want enabled at 30%% with the edited description", got.Enabled, got.RolloutPercentage, got.Description)
+	}
+	wantStatus := map[uint]string{enable.ID: model.ScheduledFlagDone, rollout.ID: model.ScheduledFlagDone, cancelled.ID: model.ScheduledFlagCancelled}
+	for id, want := range wantStatus {
+		if status := scheduleRepo.changes[id].Status; status != want {
+			t.Errorf("change %d status = %q, want %q", id, status, want)
+		}
+	}
+	if len(audit.entries) != 2 {
+		t.Fatalf("audit entries = %d, want one per applied change", len(audit.entries))
+	}
+	for _, e := range audit.entries {
+		if e.action != AuditFlagToggled || e.targetID != "launch" || e.actor == nil || *e.actor != 1 {
+			t.Errorf("audit entry = %+v, want flag_toggled on launch by user 1", e)
+		}
+	}
+
+	// Applied changes don't run again
+	if applied, _ := svc.RunScheduledFlagChanges(context.Background()); applied != 0 {
+		t.Errorf("RunScheduledFlagChanges() reapplied %d changes", applied)
+	}
+}
+
+func TestFeatureFlagService_ScheduledChangeOfDeletedFlag(t *testing.T) {
+	svc, featureFlagRepo, scheduleRepo, _ := setupFlagSchedule(t)
+	ctx := adminContext()
+	flag, _ := svc.CreateFeatureFlag(ctx, &dto.CreateFeatureFlagRequest{Key: "gone"})
+	pending, _ := svc.ScheduleFlagChange(ctx, flag.ID, &dto.ScheduleFlagChangeRequest{Action: model.ScheduledFlagEnable, RunAt: time.Now().Add(time.Hour)})
+	racing, _ := svc.ScheduleFlagChange(ctx, flag.ID, &dto.ScheduleFlagChangeRequest{Action: model.ScheduledFlagDisable, RunAt: time.Now().Add(time.Hour)})
+
+	// Deleting the flag cancels its pending changes...
+	if err := svc.DeleteFeatureFlag(ctx, flag.ID); err != nil {
+		t.Fatalf("DeleteFeatureFlag() error = %v", err)
+	}
+	if status := scheduleRepo.changes[pending.ID].Status; status != model.ScheduledFlagCancelled {
+		t.Errorf("pending change status = %q, want cancelled", status)
+	}
+
+	// ...and one that slipped through fails instead of resurrecting it
+	scheduleRepo.changes[racing.ID].Status = model.ScheduledFlagPending
+	scheduleRepo.changes[racing.ID].RunAt = time.Now().Add(-time.Minute)
+	if applied, err := svc.RunScheduledFlagChanges(context.Background()); err != nil || applied != 0 {
+		t.Fatalf("RunScheduledFlagChanges() = %d, %v, want nothing applied", applied, err)
+	}
+	if c := scheduleRepo.changes[racing.ID]; c.Status != model.ScheduledFlagFailed || c.Error != "feature flag not found" {
+		t.Errorf("change = %q (%q), want failed: feature flag not found", c.Status, c.Error)
+	}
+	if _, exists := featureFlagRepo.flags[flag.ID]; exists {
+		t.Error("deleted flag was recreated")
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+
+	"gorm.io/gorm"
+)
+
+// FlagSnapshot returns every flag and assignment. The event's ID is the
+// latest logged change, read first so nothing logged meanwhile is missed
+// (at worst it is sent again).
+func (s *featureFlagService) FlagSnapshot(ctx context.Context) (*dto.FlagStreamEvent, error) {
+	latest, err := s.changes.latestID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flag change log: %w", err)
+	}
+
+	flags, err := s.featureFlagRepo.GetByKeys(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feature flags: %w", err)
+	}
+	assignments, err := s.userFFRepo.GetAllAssignments(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assignments: %w", err)
+	}
+
+	snapshot := dto.FlagSnapshot{
+		Flags:       make([]dto.FeatureFlagResponse, len(flags)),
+		Assignments: make([]dto.FlagAssignmentEvent, 0, len(assignments)),
+	}
+	keys := make(map[uint]string, len(flags))
+	for i := range flags {
+		snapshot.Flags[i] = *toFeatureFlagResponse(&flags[i])
+		keys[flags[i].ID] = flags[i].Key
+	}
+	for _, a := range assignments {
+		// Assignments of deleted flags linger until the flag row is purged
+		key, ok := keys[a.FeatureFlagID]
+		if !ok {
+			continue
+		}
+		snapshot.Assignments = append(snapshot.Assignments, dto.FlagAssignmentEvent{
+			UserID:   a.UserID,
+			FlagKey:  key,
+			Assigned: true,
+			Variant:  a.Variant,
+		})
+	}
+
+	return &dto.FlagStreamEvent{ID: latest, Event: dto.FlagStreamSnapshot, Data: snapshot}, nil
+}
+
+// FlagChangesSince turns the changes logged after lastEventID into stream
+// events carrying the current state of what changed. Repeated changes to the
+// same flag or assignment collapse into one event at the position of the last.
+func (s *featureFlagService) FlagChangesSince(ctx context.Context, lastEventID uint64) ([]dto.FlagStreamEvent, error) {
+	changes, err := s.changes.since(ctx, lastEventID)
+	if err != nil {
+		if errors.Is(err, ErrResyncRequired) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read flag change log: %w", err)
+	}
+
+	type target struct {
+		kind   string
+		key    string
+		userID uint
+	}
+	targetOf := func(change model.FlagChange) target {
+		t := target{kind: change.Kind, key: change.FlagKey}
+		if change.UserID != nil {
+			t.userID = *change.UserID
+		}
+		return t
+	}
+	last := make(map[target]int, len(changes))
+	for i, change := range changes {
+		last[targetOf(change)] = i
+	}
+
+	events := make([]dto.FlagStreamEvent, 0, len(last))
+	for i, change := range changes {
+		if last[targetOf(change)] != i {
+			continue
+		}
+		event, err := s.currentState(ctx, change)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	return events, nil
+}
+
+// currentState builds the stream event for a logged change from the flag or
+// assignment as it is now
+func (s *featureFlagService) currentState(ctx context.Context, change model.FlagChange) (*dto.FlagStreamEvent, error) {
+	event := &dto.FlagStreamEvent{ID: change.ID}
+
+	flag, err := s.featureFlagRepo.GetByKey(ctx, change.FlagKey)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get feature flag: %w", err)
+	}
+
+	switch change.Kind {
+	case model.FlagChangeFlag:
+		if flag == nil {
+			event.Event = dto.FlagStreamFlagDeleted
+			event.Data = dto.FlagDeletedEvent{Key: change.FlagKey}
+		} else {
+			event.Event = dto.FlagStreamFlag
+			event.Data = toFeatureFlagResponse(flag)
+		}
+	case model.FlagChangeAssignment:
+		if change.UserID == nil {
+			return nil, fmt.Errorf("assignment change %d has no user", change.ID)
+		}
+		state := dto.FlagAssignmentEvent{UserID: *change.UserID, FlagKey: change.FlagKey}
+		if flag != nil {
+			assignment, err := s.userFFRepo.GetAssignment(ctx, *change.UserID, flag.ID)
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("failed to get assignment: %w", err)
+			}
+			if assignment != nil {
+				state.Assigned = true
+				state.Variant = assignment.Variant
+			}
+		}
+		event.Event = dto.FlagStreamAssignment
+		event.Data = state
+	default:
+		return nil, fmt.Errorf("unknown flag change kind %q", change.Kind)
+	}
+	return event, nil
+}
+
+// SubscribeFlagChanges signals whenever FlagChangesSince may have news
+func (s *featureFlagService) SubscribeFlagChanges() (<-chan struct{}, func()) {
+	return s.changes.Subscribe()
+}
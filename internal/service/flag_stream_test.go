@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// mockFlagChangeRepository is an in-memory flag change log
+type mockFlagChangeRepository struct {
+	changes []model.FlagChange
+	nextID  uint64
+}
+
+func newMockFlagChangeRepository() *mockFlagChangeRepository {
+	return &mockFlagChangeRepository{nextID: 1}
+}
+
+func (m *mockFlagChangeRepository) Create(ctx context.Context, change *model.FlagChange) error {
+	change.ID = m.nextID
+	change.CreatedAt = time.Now()
+	m.nextID++
+	m.changes = append(m.changes, *change)
+	return nil
+}
+
+func (m *mockFlagChangeRepository) ListSince(ctx context.Context, afterID uint64, limit int) ([]model.FlagChange, error) {
+	var changes []model.FlagChange
+	for _, change := range m.changes {
+		if change.ID > afterID && len(changes) < limit {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func (m *mockFlagChangeRepository) Bounds(ctx context.Context) (uint64, uint64, error) {
+	if len(m.changes) == 0 {
+		return 0, 0, nil
+	}
+	return m.changes[0].ID, m.changes[len(m.changes)-1].ID, nil
+}
+
+func (m *mockFlagChangeRepository) DeleteBefore(ctx context.Context, before time.Time) error {
+	kept := m.changes[:0]
+	for i, change := range m.changes {
+		if !change.CreatedAt.Before(before) || i == len(m.changes)-1 {
+			kept = append(kept, change)
+		}
+	}
+	m.changes = kept
+	return nil
+}
+
+func newTestFlagChangeFeed(repo *mockFlagChangeRepository, cache *FlagCache) *FlagChangeFeed {
+	return NewFlagChangeFeed(repo, cache, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestFeatureFlagService_FlagStream(t *testing.T) {
+	featureFlagRepo := newMockFeatureFlagRepository()
+	userFFRepo := newMockUserFeatureFlagRepository()
+	userRepo := newMockUserRepository()
+	feed := newTestFlagChangeFeed(newMockFlagChangeRepository(), nil)
+	flags := NewFeatureFlagService(featureFlagRepo, userFFRepo, userRepo, newMockScheduledFlagChangeRepository(), nil, feed, newNoopAudit())
+	users := NewUserService(userRepo, featureFlagRepo, userFFRepo, feed, newNoopAudit())
+	ctx := context.Background()
+
+	beta, _ := flags.CreateFeatureFlag(adminContext(), &dto.CreateFeatureFlagRequest{Key: "beta"})
+	user, _ := users.CreateUser(adminContext(), &dto.CreateUserRequest{Name: "Jane", Email: "jane@example.com"})
+	_ = users.AssignFeatureFlagToUser(adminContext(), user.ID, "beta", "")
+
+	snapshot, err := flags.FlagSnapshot(ctx)
+	if err != nil {
+		t.Fatalf("FlagSnapshot() error = %v", err)
+	}
+	data := snapshot.Data.(dto.FlagSnapshot)
+	if snapshot.ID != 2 || len(data.Flags) != 1 || len(data.Assignments) != 1 || data.Assignments[0].FlagKey != "beta" {
+		t.Fatalf("FlagSnapshot() = %+v, want id 2 with beta and its assignment", snapshot)
+	}
+
+	signal, unsubscribe := flags.SubscribeFlagChanges()
+	defer unsubscribe()
+
+	// Several changes to the same flag collapse into its current state
+	enabled := true
+	_, _ = flags.UpdateFeatureFlag(adminContext(), beta.ID, &dto.UpdateFeatureFlagRequest{Enabled: &enabled})
+	percentage := 50
+	_, _ = flags.UpdateFeatureFlag(adminContext(), beta.ID, &dto.UpdateFeatureFlagRequest{RolloutPercentage: &percentage})
+	_ = users.UnassignFeatureFlagFromUser(adminContext(), user.ID, "beta")
+	other, _ := flags.CreateFeatureFlag(adminContext(), &dto.CreateFeatureFlagRequest{Key: "other"})
+	_ = flags.DeleteFeatureFlag(adminContext(), other.ID)
+
+	// Changes reach this replica's streams via the log's notification
+	feed.HandleNotification(`{"id":7,"kind":"flag","flag_key":"other"}`)
+	select {
+	case <-signal:
+	default:
+		t.Error("subscriber not signalled after a notification")
+	}
+
+	events, err := flags.FlagChangesSince(ctx, snapshot.ID)
+	if err != nil {
+		t.Fatalf("FlagChangesSince() error = %v", err)
+	}
+	want := []struct {
+		id    uint64
+		event string
+	}{
+		{4, dto.FlagStreamFlag},
+		{5, dto.FlagStreamAssignment},
+		{7, dto.FlagStreamFlagDeleted},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("FlagChangesSince() = %+v, want %d events", events, len(want))
+	}
+	for i, w := range want {
+		if events[i].ID != w.id || events[i].Event != w.event {
+			t.Errorf("event %d = %d %s, want %d %s", i, events[i].ID, events[i].Event, w.id, w.event)
+		}
+	}
+	if flag := events[0].Data.(*dto.FeatureFlagResponse); !flag.Enabled || flag.RolloutPercentage != 50 {
+		t.Errorf("flag event = %+v, want the flag's latest state", flag)
+	}
+	if assignment := events[1].Data.(dto.FlagAssignmentEvent); assignment.Assigned || assignment.UserID != user.ID {
+		t.Errorf("assignment event = %+v, want the removed assignment", assignment)
+	}
+
+	// Resuming from the latest event is a no-op
+	if events, err := flags.FlagChangesSince(ctx, 7); err != nil || len(events) != 0 {
+		t.Errorf("FlagChangesSince(latest) = %v, %v, want nothing", events, err)
+	}
+}
+
+func TestFeatureFlagService_FlagStreamResync(t *testing.T) {
+	changeRepo := newMockFlagChangeRepository()
+	feed := newTestFlagChangeFeed(changeRepo, nil)
+	flags := NewFeatureFlagService(newMockFeatureFlagRepository(), newMockUserFeatureFlagRepository(), newMockUserRepository(), newMockScheduledFlagChangeRepository(), nil, feed, newNoopAudit())
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "c"} {
+		_, _ = flags.CreateFeatureFlag(adminContext(), &dto.CreateFeatureFlagRequest{Key: key})
+	}
+	// Everything but the latest change ages out of the log; writes alone
+	// don't prune it
+	for i := range changeRepo.changes {
+		changeRepo.changes[i].CreatedAt = time.Now().Add(-flagChangeRetention - time.Hour)
+	}
+	_, _ = flags.CreateFeatureFlag(adminContext(), &dto.CreateFeatureFlagRequest{Key: "d"})
+	if len(changeRepo.changes) != 4 {
+		t.Fatalf("expected the log to keep all 4 changes until pruned, got %d", len(changeRepo.changes))
+	}
+	changeRepo.changes[3].CreatedAt = time.Now().Add(-flagChangeRetention - time.Hour)
+	if err := feed.Prune(ctx); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID uint64
+		wantErr     error
+	}{
+		{"pruned changes", 1, ErrResyncRequired},
+		{"only the latest change to replay", 3, nil},
+		{"unknown future event", 9, ErrResyncRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := flags.FlagChangesSince(ctx, tt.lastEventID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("FlagChangesSince(%d) error = %v, want %v", tt.lastEventID, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFlagChangeFeed_Close(t *testing.T) {
+	feed := newTestFlagChangeFeed(newMockFlagChangeRepository(), nil)
+	signal, unsubscribe := feed.Subscribe()
+	defer unsubscribe()
+
+	feed.Close()
+	if _, ok := <-signal; ok {
+		t.Error("subscription still open after Close()")
+	}
+	late, _ := feed.Subscribe()
+	if _, ok := <-late; ok {
+		t.Error("Subscribe() after Close() returned an open channel")
+	}
+}
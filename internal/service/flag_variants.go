@@ -0,0 +1,94 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/internal/model"
+	"identity/pkg/api"
+)
+
+// ErrInvalidVariants is wrapped by errors from validating a flag's variants
+var ErrInvalidVariants = errors.New("invalid variants")
+
+// validateVariants checks a flag's variants against its variant type, and
+// that the off variant and every variant pinned by a rule exist. Boolean
+// flags may have no variants, in which case they evaluate to true/false.
+func validateVariants(flag *model.FeatureFlag) error {
+	if !validVariantType(flag.VariantType) {
+		return fmt.Errorf("%w: unknown variant type %q", ErrInvalidVariants, flag.VariantType)
+	}
+	if len(flag.Variants) == 0 && flag.VariantType != api.VariantTypeBoolean {
+		return fmt.Errorf("%w: %s flags need at least one variant", ErrInvalidVariants, flag.VariantType)
+	}
+
+	keys := make(map[string]bool, len(flag.Variants))
+	total := 0
+	for _, v := range flag.Variants {
+		if v.Key == "" {
+			return fmt.Errorf("%w: variant without a key", ErrInvalidVariants)
+		}
+		if keys[v.Key] {
+			return fmt.Errorf("%w: duplicate variant %q", ErrInvalidVariants, v.Key)
+		}
+		keys[v.Key] = true
+		if v.Weight < 0 || v.Weight > 100 {
+			return fmt.Errorf("%w: variant %q weight must be between 0 and 100", ErrInvalidVariants, v.Key)
+		}
+		total += v.Weight
+		if !validVariantValue(flag.VariantType, v.Value) {
+			return fmt.Errorf("%w: variant %q value is not a %s", ErrInvalidVariants, v.Key, flag.VariantType)
+		}
+	}
+	if len(flag.Variants) > 0 && total != 100 {
+		return fmt.Errorf("%w: variant weights add up to %d, want 100", ErrInvalidVariants, total)
+	}
+
+	if flag.OffVariant != "" && !keys[flag.OffVariant] {
+		return fmt.Errorf("%w: off variant %q is not a variant of the flag", ErrInvalidVariants, flag.OffVariant)
+	}
+	for i, rule := range flag.Rules {
+		if rule.Variant != "" && !keys[rule.Variant] {
+			return fmt.Errorf("%w: rule %d serves unknown variant %q", ErrInvalidVariants, i+1, rule.Variant)
+		}
+	}
+	return nil
+}
+
+func validVariantType(variantType string) bool {
+	for _, t := range api.VariantTypes {
+		if t == variantType {
+			return true
+		}
+	}
+	return false
+}
+
+func validVariantValue(variantType string, value json.RawMessage) bool {
+	var v any
+	if len(value) == 0 || json.Unmarshal(value, &v) != nil {
+		return false
+	}
+	switch variantType {
+	case api.VariantTypeBoolean:
+		_, ok := v.(bool)
+		return ok
+	case api.VariantTypeString:
+		_, ok := v.(string)
+		return ok
+	case api.VariantTypeNumber:
+		_, ok := v.(float64)
+		return ok
+	}
+	return true
+}
+
+// findVariant returns the flag's variant with the given key, if any
+func findVariant(flag *model.FeatureFlag, key string) *model.FlagVariant {
+	for i := range flag.Variants {
+		if flag.Variants[i].Key == key {
+			return &flag.Variants[i]
+		}
+	}
+	return nil
+}
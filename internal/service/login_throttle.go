@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"identity/internal/service/dto"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultLockoutThreshold is how many failed logins an email address
+	// gets before it is locked
+	DefaultLockoutThreshold = 5
+	// DefaultLockoutDuration is the first lock; it doubles with every
+	// further failure
+	DefaultLockoutDuration = time.Minute
+	// DefaultMaxLockoutDuration caps the lock
+	DefaultMaxLockoutDuration = time.Hour
+	// emailFailureWindow and ipFailureWindow are how long failures are
+	// remembered: a failure further apart from the previous one starts the
+	// count over. A client IP's count is never cleared by a successful login,
+	// so it is forgotten sooner.
+	emailFailureWindow = 24 * time.Hour
+	ipFailureWindow    = time.Hour
+)
+
+// ErrLoginLocked is returned while an email address or client IP is locked
+// out. Addresses without an account are counted and locked the same way, so
+// the lockout says nothing about which exist.
+var ErrLoginLocked = errors.New("too many failed login attempts, try again later")
+
+// LockoutConfig configures how failed logins lock an email address or
+// client IP out
+type LockoutConfig struct {
+	Threshold int
+	// IPThreshold is kept separate and higher, since many users can share an
+	// address; 0 leaves client IPs unlimited
+	IPThreshold int
+	// Duration is the lock once a threshold is reached; every further
+	// failure doubles it, up to MaxDuration
+	Duration    time.Duration
+	MaxDuration time.Duration
+}
+
+// GetUserLockout returns a user's failed login count and lock
+func (s *authService) GetUserLockout(ctx context.Context, userID uint) (*dto.LoginLockoutResponse, error) {
+	if err := authorize(ctx, PermUsersRead); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	throttles, err := s.throttleRepo.GetByKeys(ctx, []string{emailThrottleKey(user.Email)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get failed logins: %w", err)
+	}
+	resp := &dto.LoginLockoutResponse{}
+	if len(throttles) > 0 {
+		resp.FailedAttempts = throttles[0].Failures
+		if throttles[0].IsLocked(time.Now()) {
+			resp.Locked = true
+			resp.LockedUntil = throttles[0].LockedUntil
+		}
+	}
+	return resp, nil
+}
+
+// GetLoginLockouts returns when the locked ones among the given users'
+// email addresses unlock, keyed by email
+func (s *authService) GetLoginLockouts(ctx context.Context, emails []string) (map[string]time.Time, error) {
+	if err := authorize(ctx, PermUsersRead); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(emails))
+	emailByKey := make(map[string]string, len(emails))
+	for i, email := range emails {
+		keys[i] = emailThrottleKey(email)
+		emailByKey[keys[i]] = email
+	}
+	throttles, err := s.throttleRepo.GetByKeys(ctx, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get failed logins: %w", err)
+	}
+
+	now := time.Now()
+	lockouts := make(map[string]time.Time)
+	for _, throttle := range throttles {
+		if throttle.IsLocked(now) {
+			lockouts[emailByKey[throttle.Key]] = *throttle.LockedUntil
+		}
+	}
+	return lockouts, nil
+}
+
+// UnlockUser clears a user's failed logins and lock (admin action). Locks
+// on client IPs stay.
+func (s *authService) UnlockUser(ctx context.Context, userID uint) error {
+	if err := authorize(ctx, PermUsersSecurity); err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("user not found")
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if err := s.throttleRepo.Delete(ctx, emailThrottleKey(user.Email)); err != nil {
+		return fmt.Errorf("failed to unlock user: %w", err)
+	}
+
+	s.audit.Log(ctx, nil, AuditUserUnlocked, "user", fmt.Sprint(user.ID), map[string]any{"email": user.Email})
+	return nil
+}
+
+// checkLoginLock refuses logins for a locked email address or from a locked
+// client IP, before their password or second factor is looked at
+func (s *authService) checkLoginLock(ctx context.Context, email string) error {
+	throttles, err := s.throttleRepo.GetByKeys(ctx, s.throttleKeys(ctx, email))
+	if err != nil {
+		return fmt.Errorf("failed to get failed logins: %w", err)
+	}
+
+	now := time.Now()
+	for _, throttle := range throttles {
+		if throttle.IsLocked(now) {
+			s.audit.Log(ctx, nil, AuditLoginFailed, "user", "", map[string]any{"email": email, "reason": "locked", "key": throttle.Key})
+			return ErrLoginLocked
+		}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login (a wrong password or second
+// factor) against the email address and client IP, locking whichever reached
+// its threshold
+func (s *authService) recordLoginFailure(ctx context.Context, email string) error {
+	now := time.Now()
+	for _, key := range s.throttleKeys(ctx, email) {
+		threshold, window := s.lockout.Threshold, emailFailureWindow
+		if strings.HasPrefix(key, "ip:") {
+			threshold, window = s.lockout.IPThreshold, ipFailureWindow
+		}
+
+		failures, err := s.throttleRepo.RecordFailure(ctx, key, now, window)
+		if err != nil {
+			return fmt.Errorf("failed to record failed login: %w", err)
+		}
+		if failures < threshold {
+			continue
+		}
+
+		until := now.Add(s.lockoutDuration(failures - threshold))
+		if err := s.throttleRepo.Lock(ctx, key, until); err != nil {
+			return fmt.Errorf("failed to lock login: %w", err)
+		}
+		s.audit.Log(ctx, nil, AuditLoginLocked, "user", "", map[string]any{
+			"email":        email,
+			"key":          key,
+			"failures":     failures,
+			"locked_until": until,
+		})
+	}
+	return nil
+}
+
+// clearLoginFailures forgets an email address's failed logins once its user
+// was issued a session. The client IP's stay: an attacker with an account of their own
+// could otherwise clear them between guesses.
+func (s *authService) clearLoginFailures(ctx context.Context, email string) error {
+	return s.throttleRepo.Delete(ctx, emailThrottleKey(email))
+}
+
+// lockoutDuration is the lock after the given number of failures past the
+// threshold: doubling from the configured duration, up to the maximum
+func (s *authService) lockoutDuration(beyondThreshold int) time.Duration {
+	d := s.lockout.Duration
+	for i := 0; i < beyondThreshold && d < s.lockout.MaxDuration; i++ {
+		d *= 2
+	}
+	return min(d, s.lockout.MaxDuration)
+}
+
+// throttleKeys are the counters a login attempt is checked against: the
+// email address, and the client IP when known and limited
+func (s *authService) throttleKeys(ctx context.Context, email string) []string {
+	keys := []string{emailThrottleKey(email)}
+	if ip := ClientIPFromContext(ctx); ip != "" && s.lockout.IPThreshold > 0 {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// emailThrottleKey is the failed login counter of an email address
+func emailThrottleKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// withLockoutDefaults fills in unset lockout settings, but for IPThreshold
+func withLockoutDefaults(cfg LockoutConfig) LockoutConfig {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = DefaultLockoutThreshold
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = DefaultLockoutDuration
+	}
+	if cfg.MaxDuration < cfg.Duration {
+		cfg.MaxDuration = max(DefaultMaxLockoutDuration, cfg.Duration)
+	}
+	return cfg
+}
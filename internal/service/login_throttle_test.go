@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// mockLoginThrottleRepository is an in-memory LoginThrottleRepository
+type mockLoginThrottleRepository struct {
+	throttles map[string]*model.LoginThrottle
+}
+
+func newMockLoginThrottleRepository() *mockLoginThrottleRepository {
+	return &mockLoginThrottleRepository{throttles: make(map[string]*model.LoginThrottle)}
+}
+
+func (m *mockLoginThrottleRepository) GetByKeys(ctx context.Context, keys []string) ([]model.LoginThrottle, error) {
+	var throttles []model.LoginThrottle
+	for _, key := range keys {
+		if throttle, ok := m.throttles[key]; ok {
+			throttles = append(throttles, *throttle)
+		}
+	}
+	return throttles, nil
+}
+
+func (m *mockLoginThrottleRepository) RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (int, error) {
+	throttle, ok := m.throttles[key]
+	if !ok || throttle.LastFailureAt.Before(at.Add(-window)) {
+		throttle = &model.LoginThrottle{Key: key}
+		m.throttles[key] = throttle
+	}
+	throttle.Failures++
+	throttle.LastFailureAt = at
+	return throttle.Failures, nil
+}
+
+func (m *mockLoginThrottleRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	if throttle, ok := m.throttles[key]; ok {
+		throttle.LockedUntil = &until
+	}
+	return nil
+}
+
+func (m *mockLoginThrottleRepository) Delete(ctx context.Context, key string) error {
+	delete(m.throttles, key)
+	return nil
+}
+
+// setupLockout creates an auth service locking out after 3 failed logins,
+// with one user whose password is secret123
+func setupLockout(t *testing.T) (AuthService, *mockLoginThrottleRepository) {
+	t.Helper()
+	userRepo := newMockUserRepository()
+	throttleRepo := newMockLoginThrottleRepository()
+	svc := NewAuthService(userRepo, newMockSessionRepository(userRepo), newMockOAuthTokenRepository(), newMockLoginChallengeRepository(userRepo),
+		newMockRecoveryCodeRepository(), newMockWebAuthnCredentialRepository(), newMockWebAuthnCeremonyRepository(),
+		throttleRepo, newNoopAudit(), time.Hour, SessionTimeouts{}, TwoFactorConfig{}, newTestWebAuthn(t), LockoutConfig{
+			Threshold:   3,
+			IPThreshold: 5,
+			Duration:    time.Minute,
+			MaxDuration: 10 * time.Minute,
+		}, newTestPasswordPolicy())
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if err := userRepo.Create(context.Background(), &model.User{
+		Name:         "Test User",
+		Email:        "test@example.com",
+		PasswordHash: string(hash),
+		Enabled:      true,
+	}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return svc, throttleRepo
+}
+
+func failLogins(t *testing.T, svc AuthService, ctx context.Context, email string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		_, err := svc.Login(ctx, &dto.LoginRequest{Email: email, Password: "wrong"})
+		if err == nil || errors.Is(err, ErrLoginLocked) {
+			t.Fatalf("expected attempt %d to fail with a wrong password, got %v", i, err)
+		}
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	svc, throttleRepo := setupLockout(t)
+	ctx := context.Background()
+
+	failLogins(t, svc, ctx, "test@example.com", 3)
+
+	_, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+	if !errors.Is(err, ErrLoginLocked) {
+		t.Fatalf("expected the account to be locked, got %v", err)
+	}
+
+	lockout, err := svc.GetUserLockout(adminContext(), 1)
+	if err != nil {
+		t.Fatalf("get lockout failed: %v", err)
+	}
+	if !lockout.Locked || lockout.FailedAttempts != 3 {
+		t.Errorf("expected a lock after 3 failures, got %+v", lockout)
+	}
+
+	// Once the lock ran out, every further failure doubles it
+	throttle := throttleRepo.throttles["email:test@example.com"]
+	expired := time.Now().Add(-time.Second)
+	throttle.LockedUntil = &expired
+	failLogins(t, svc, ctx, "test@example.com", 1)
+	if d := time.Until(*throttle.LockedUntil); d < time.Minute || d > 2*time.Minute {
+		t.Errorf("expected a 2 minute lock after the 4th failure, got %s", d)
+	}
+
+	if err := svc.UnlockUser(adminContext(), 1); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	if _, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"}); err != nil {
+		t.Errorf("expected login after unlock, got %v", err)
+	}
+}
+
+func TestLoginLockoutDoesNotRevealAccounts(t *testing.T) {
+	svc, _ := setupLockout(t)
+	ctx := context.Background()
+
+	failLogins(t, svc, ctx, "nobody@example.com", 3)
+
+	_, err := svc.Login(ctx, &dto.LoginRequest{Email: "nobody@example.com", Password: "wrong"})
+	if !errors.Is(err, ErrLoginLocked) {
+		t.Errorf("expected unknown emails to lock like known ones, got %v", err)
+	}
+}
+
+func TestLoginSuccessClearsFailures(t *testing.T) {
+	svc, throttleRepo := setupLockout(t)
+	ctx := context.Background()
+
+	failLogins(t, svc, ctx, "test@example.com", 2)
+	if _, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"}); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if _, ok := throttleRepo.throttles["email:test@example.com"]; ok {
+		t.Error("expected a successful login to clear failures")
+	}
+}
+
+// Wrong second factors count toward the lockout, and a password that checks
+// out doesn't clear them: every login starts a new challenge, so otherwise
+// codes could be guessed without end
+func TestSecondFactorFailuresLockLogin(t *testing.T) {
+	svc, userRepo, _ := setupTwoFactor(t, model.RoleUser, TwoFactorConfig{})
+	ctx := context.Background()
+	login := &dto.LoginRequest{Email: "test@example.com", Password: "secret123"}
+
+	secret := enableTestTOTP(t, userRepo, 1)
+
+	var challengeToken string
+	for i := 0; i < DefaultLockoutThreshold; i++ {
+		resp, err := svc.Login(ctx, login)
+		if err != nil {
+			t.Fatalf("Login() before attempt %d error = %v", i+1, err)
+		}
+		challengeToken = resp.ChallengeToken
+		if _, err := svc.VerifyLogin(ctx, &dto.VerifyLoginRequest{ChallengeToken: challengeToken, Code: "000000"}); !errors.Is(err, ErrInvalidTOTPCode) {
+			t.Fatalf("attempt %d error = %v, want ErrInvalidTOTPCode", i+1, err)
+		}
+	}
+
+	if _, err := svc.Login(ctx, login); !errors.Is(err, ErrLoginLocked) {
+		t.Fatalf("Login() after %d wrong codes error = %v, want ErrLoginLocked", DefaultLockoutThreshold, err)
+	}
+	// Even the right code on a challenge still open
+	if _, err := svc.VerifyLogin(ctx, &dto.VerifyLoginRequest{ChallengeToken: challengeToken, Code: totpCode(t, secret, 0)}); !errors.Is(err, ErrLoginLocked) {
+		t.Fatalf("VerifyLogin() while locked error = %v, want ErrLoginLocked", err)
+	}
+
+	// Passing the second factor clears the count
+	if err := svc.UnlockUser(adminContext(), 1); err != nil {
+		t.Fatalf("UnlockUser() error = %v", err)
+	}
+	resp, _ := svc.Login(ctx, login)
+	if _, err := svc.VerifyLogin(ctx, &dto.VerifyLoginRequest{ChallengeToken: resp.ChallengeToken, Code: "000000"}); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatalf("VerifyLogin(wrong code) error = %v", err)
+	}
+	if _, err := svc.VerifyLogin(ctx, &dto.VerifyLoginRequest{ChallengeToken: resp.ChallengeToken, Code: totpCode(t, secret, 0)}); err != nil {
+		t.Fatalf("VerifyLogin() error = %v", err)
+	}
+	lockout, _ := svc.GetUserLockout(adminContext(), 1)
+	if lockout.FailedAttempts != 0 {
+		t.Errorf("expected the session to clear failures, got %d", lockout.FailedAttempts)
+	}
+}
+
+func TestLoginIPLockout(t *testing.T) {
+	svc, _ := setupLockout(t)
+	ctx := WithClientIP(context.Background(), "203.0.113.7")
+
+	// Spread over addresses, so no email reaches its own threshold
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"} {
+		failLogins(t, svc, ctx, email, 1)
+	}
+
+	_, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+	if !errors.Is(err, ErrLoginLocked) {
+		t.Errorf("expected the client IP to be locked, got %v", err)
+	}
+	if _, err := svc.Login(context.Background(), &dto.LoginRequest{Email: "test@example.com", Password: "secret123"}); err != nil {
+		t.Errorf("expected other clients to log in, got %v", err)
+	}
+}
+
+func TestUnlockUserRequiresPermission(t *testing.T) {
+	svc, _ := setupLockout(t)
+
+	ctx := WithActorRole(WithActor(context.Background(), 2), model.RoleViewer)
+	if err := svc.UnlockUser(ctx, 1); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden, got %v", err)
+	}
+}
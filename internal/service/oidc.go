@@ -0,0 +1,874 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"identity/internal/model"
+	"identity/internal/repository"
+	"identity/internal/service/dto"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultOIDCAccessTokenTTL is how long access and ID tokens are valid
+	DefaultOIDCAccessTokenTTL = time.Hour
+	// DefaultOIDCRefreshTokenTTL is how long a refresh token can be used;
+	// each use replaces it with a new one
+	DefaultOIDCRefreshTokenTTL = 30 * 24 * time.Hour
+	// authorizationCodeTTL is how long a client has to exchange a code
+	authorizationCodeTTL = time.Minute
+	// oauthClientIDBytes is the length of generated client IDs
+	oauthClientIDBytes = 16
+	// OIDCMaintenanceInterval is how often RunOIDCMaintenance should run
+	OIDCMaintenanceInterval = 10 * time.Minute
+)
+
+// Scopes clients can request. openid is required; the others add claims
+// about the user to ID tokens and userinfo.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+	ScopeRoles   = "roles"
+)
+
+// oidcScopes are the supported scopes, in the order they are listed
+var oidcScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail, ScopeRoles}
+
+var (
+	// ErrUnknownOAuthClient and ErrInvalidRedirectURI reject authorization
+	// requests that can't be answered by redirecting to the client, which
+	// may not be who it claims to be
+	ErrUnknownOAuthClient = errors.New("unknown client")
+	ErrInvalidRedirectURI = errors.New("redirect_uri is not registered for the client")
+	// ErrInvalidAccessToken is returned for access tokens that are unknown,
+	// expired or revoked
+	ErrInvalidAccessToken = errors.New("invalid or expired access token")
+	// ErrInvalidClientRegistration matches the errors returned for client
+	// registrations that can't be saved, whose message gives the reason
+	ErrInvalidClientRegistration = errors.New("invalid client")
+)
+
+// OAuthError is an error OAuth 2.0 defines, answered to the client with its
+// code, e.g. invalid_grant
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func oauthError(code, format string, args ...any) *OAuthError {
+	return &OAuthError{Code: code, Description: fmt.Sprintf(format, args...)}
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// OIDCService makes identity an OpenID Connect provider, so other apps can
+// sign their users in with it (authorization code flow with PKCE)
+type OIDCService interface {
+	Discovery() *dto.OIDCDiscovery
+	JWKS(ctx context.Context) (*dto.JWKS, error)
+
+	// Authorization endpoint
+	CheckAuthorization(ctx context.Context, req *dto.AuthorizeRequest) (*model.OAuthClient, error)
+	HasConsent(ctx context.Context, userID uint, client *model.OAuthClient, scope string) (bool, error)
+	Authorize(ctx context.Context, session *model.Session, client *model.OAuthClient, req *dto.AuthorizeRequest) (string, error)
+	AuthorizationErrorURL(req *dto.AuthorizeRequest, err *OAuthError) string
+
+	Token(ctx context.Context, req *dto.TokenRequest) (*dto.TokenResponse, error)
+	UserInfo(ctx context.Context, accessToken string) (*dto.UserInfoResponse, error)
+	Revoke(ctx context.Context, req *dto.RevokeTokenRequest) error
+	Maintain(ctx context.Context) error
+
+	// Client registration
+	GetClients(ctx context.Context) ([]dto.OAuthClientResponse, error)
+	CreateClient(ctx context.Context, req *dto.CreateOAuthClientRequest) (*dto.OAuthClientSecretResponse, error)
+	RegenerateClientSecret(ctx context.Context, id uint) (*dto.OAuthClientSecretResponse, error)
+	DeleteClient(ctx context.Context, id uint) error
+}
+
+// OIDCConfig configures the OpenID Connect provider
+type OIDCConfig struct {
+	// Issuer is the provider's public base URL, e.g. https://id.example.com;
+	// the endpoints are below it and tokens name it as their issuer
+	Issuer string
+	// AccessTokenTTL is how long access and ID tokens are valid
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is how long a refresh token can be used
+	RefreshTokenTTL time.Duration
+}
+
+// oidcService implements OIDCService
+type oidcService struct {
+	clientRepo  repository.OAuthClientRepository
+	codeRepo    repository.OAuthAuthorizationCodeRepository
+	tokenRepo   repository.OAuthTokenRepository
+	consentRepo repository.OAuthConsentRepository
+	userRepo    repository.UserRepository
+	sessionRepo repository.SessionRepository
+	keys        *SigningKeys
+	audit       AuditLogger
+	cfg         OIDCConfig
+}
+
+// NewOIDCService creates a new OpenID Connect provider service
+func NewOIDCService(
+	clientRepo repository.OAuthClientRepository,
+	codeRepo repository.OAuthAuthorizationCodeRepository,
+	tokenRepo repository.OAuthTokenRepository,
+	consentRepo repository.OAuthConsentRepository,
+	userRepo repository.UserRepository,
+	sessionRepo repository.SessionRepository,
+	keys *SigningKeys,
+	audit AuditLogger,
+	cfg OIDCConfig,
+) (OIDCService, error) {
+	issuer, err := url.Parse(cfg.Issuer)
+	if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" || issuer.RawQuery != "" || issuer.Fragment != "" {
+		return nil, fmt.Errorf("invalid issuer %q, want an http(s) URL without query or fragment", cfg.Issuer)
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if cfg.AccessTokenTTL <= 0 {
+		cfg.AccessTokenTTL = DefaultOIDCAccessTokenTTL
+	}
+	if cfg.RefreshTokenTTL <= 0 {
+		cfg.RefreshTokenTTL = DefaultOIDCRefreshTokenTTL
+	}
+	if cfg.AccessTokenTTL > keys.rotation {
+		return nil, fmt.Errorf("access tokens (%s) must not outlive a signing key rotation (%s)", cfg.AccessTokenTTL, keys.rotation)
+	}
+
+	return &oidcService{
+		clientRepo:  clientRepo,
+		codeRepo:    codeRepo,
+		tokenRepo:   tokenRepo,
+		consentRepo: consentRepo,
+		userRepo:    userRepo,
+		sessionRepo: sessionRepo,
+		keys:        keys,
+		audit:       audit,
+		cfg:         cfg,
+	}, nil
+}
+
+// Discovery returns the discovery document
+func (s *oidcService) Discovery() *dto.OIDCDiscovery {
+	authMethods := []string{"client_secret_basic", "client_secret_post", "none"}
+	return &dto.OIDCDiscovery{
+		Issuer:                                 s.cfg.Issuer,
+		AuthorizationEndpoint:                  s.cfg.Issuer + "/oauth2/authorize",
+		TokenEndpoint:                          s.cfg.Issuer + "/oauth2/token",
+		UserInfoEndpoint:                       s.cfg.Issuer + "/oauth2/userinfo",
+		RevocationEndpoint:                     s.cfg.Issuer + "/oauth2/revoke",
+		JWKSURI:                                s.cfg.Issuer + "/oauth2/jwks",
+		ResponseTypesSupported:                 []string{"code"},
+		ResponseModesSupported:                 []string{"query"},
+		GrantTypesSupported:                    []string{"authorization_code", "refresh_token"},
+		SubjectTypesSupported:                  []string{"public"},
+		IDTokenSigningAlgValuesSupported:       []string{signingKeyAlg},
+		ScopesSupported:                        oidcScopes,
+		ClaimsSupported:                        []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "name", "email", "role"},
+		TokenEndpointAuthMethodsSupported:      authMethods,
+		RevocationEndpointAuthMethodsSupported: authMethods,
+		CodeChallengeMethodsSupported:          []string{"S256"},
+		PromptValuesSupported:                  []string{"none", "login", "consent"},
+		AuthorizationResponseIssParameter:      true,
+	}
+}
+
+// JWKS returns the public keys ID tokens may be signed with
+func (s *oidcService) JWKS(ctx context.Context) (*dto.JWKS, error) {
+	return s.keys.JWKS(ctx)
+}
+
+// CheckAuthorization validates an authorization request and returns the
+// client it is for. Requests for unknown clients or unregistered redirect
+// URIs fail with ErrUnknownOAuthClient or ErrInvalidRedirectURI, which must
+// be shown to the user; other problems are *OAuthError, to be sent back to
+// the client with AuthorizationErrorURL. Unsupported scopes are dropped
+// from req.Scope.
+func (s *oidcService) CheckAuthorization(ctx context.Context, req *dto.AuthorizeRequest) (*model.OAuthClient, error) {
+	client, err := s.clientRepo.GetByClientID(ctx, req.ClientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownOAuthClient
+		}
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+
+	if req.ResponseType != "code" {
+		return nil, oauthError("unsupported_response_type", "only the code response type is supported")
+	}
+	req.Scope = normalizeScope(req.Scope)
+	if !hasScope(req.Scope, ScopeOpenID) {
+		return nil, oauthError("invalid_scope", "the openid scope is required")
+	}
+	if req.CodeChallengeMethod != "S256" || !validCodeChallenge(req.CodeChallenge) {
+		return nil, oauthError("invalid_request", "a PKCE code_challenge with code_challenge_method S256 is required")
+	}
+	prompts := strings.Fields(req.Prompt)
+	for _, prompt := range prompts {
+		if !slices.Contains([]string{"none", "login", "consent", "select_account"}, prompt) {
+			return nil, oauthError("invalid_request", "unsupported prompt %q", prompt)
+		}
+	}
+	if slices.Contains(prompts, "none") && len(prompts) > 1 {
+		return nil, oauthError("invalid_request", "prompt none can't be combined with other values")
+	}
+	if req.MaxAge != "" {
+		if maxAge, err := strconv.Atoi(req.MaxAge); err != nil || maxAge < 0 {
+			return nil, oauthError("invalid_request", "max_age must be a number of seconds")
+		}
+	}
+	return client, nil
+}
+
+// LoginRequired reports whether an authorization request wants the user to
+// sign in again although they have a session that was created at authTime:
+// prompt includes login, or the session is older than max_age
+func LoginRequired(req *dto.AuthorizeRequest, authTime time.Time) bool {
+	if slices.Contains(strings.Fields(req.Prompt), "login") {
+		return true
+	}
+	if maxAge, err := strconv.Atoi(req.MaxAge); err == nil && time.Since(authTime) > time.Duration(maxAge)*time.Second {
+		return true
+	}
+	return false
+}
+
+// HasConsent reports whether the user already allowed the client every
+// scope in scope
+func (s *oidcService) HasConsent(ctx context.Context, userID uint, client *model.OAuthClient, scope string) (bool, error) {
+	consent, err := s.consentRepo.Get(ctx, userID, client.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get consent: %w", err)
+	}
+	for _, requested := range strings.Fields(scope) {
+		if !hasScope(consent.Scope, requested) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Authorize records the user's consent to a checked authorization request
+// and issues an authorization code for it, returning the client's redirect
+// URI with the code added. The user is the session's, which must have been
+// validated.
+func (s *oidcService) Authorize(ctx context.Context, session *model.Session, client *model.OAuthClient, req *dto.AuthorizeRequest) (string, error) {
+	consented := req.Scope
+	if consent, err := s.consentRepo.Get(ctx, session.UserID, client.ID); err == nil {
+		consented = normalizeScope(consent.Scope + " " + req.Scope)
+	}
+	if err := s.consentRepo.Save(ctx, &model.OAuthConsent{UserID: session.UserID, ClientID: client.ID, Scope: consented}); err != nil {
+		return "", fmt.Errorf("failed to save consent: %w", err)
+	}
+
+	code, err := generateSessionID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate authorization code: %w", err)
+	}
+	grantID, err := generateSessionID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate grant ID: %w", err)
+	}
+	record := &model.OAuthAuthorizationCode{
+		CodeHash:      hashSecret(code),
+		GrantID:       grantID,
+		ClientID:      client.ID,
+		UserID:        session.UserID,
+		SessionID:     session.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      session.CreatedAt,
+		ExpiresAt:     time.Now().Add(authorizationCodeTTL),
+	}
+	if err := s.codeRepo.Create(ctx, record); err != nil {
+		return "", fmt.Errorf("failed to create authorization code: %w", err)
+	}
+
+	s.audit.Log(ctx, &session.UserID, AuditOAuthAuthorized, "user", fmt.Sprint(session.UserID), map[string]any{
+		"client_id": client.ClientID,
+		"client":    client.Name,
+		"scope":     req.Scope,
+	})
+
+	return s.redirectURL(req, url.Values{"code": {code}}), nil
+}
+
+// AuthorizationErrorURL returns the client's redirect URI with an error
+// added, for a request that passed the client and redirect URI checks
+func (s *oidcService) AuthorizationErrorURL(req *dto.AuthorizeRequest, err *OAuthError) string {
+	return s.redirectURL(req, url.Values{"error": {err.Code}, "error_description": {err.Description}})
+}
+
+// redirectURL adds params, the state and the issuer (RFC 9207) to the
+// request's redirect URI
+func (s *oidcService) redirectURL(req *dto.AuthorizeRequest, params url.Values) string {
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	params.Set("iss", s.cfg.Issuer)
+
+	// The redirect URI was registered, so it parses
+	redirect, _ := url.Parse(req.RedirectURI)
+	query := redirect.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	redirect.RawQuery = query.Encode()
+	return redirect.String()
+}
+
+// Token exchanges an authorization code or refresh token for new tokens.
+// Errors the client should see are *OAuthError.
+func (s *oidcService) Token(ctx context.Context, req *dto.TokenRequest) (*dto.TokenResponse, error) {
+	client, err := s.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.GrantType {
+	case "authorization_code":
+		return s.exchangeCode(ctx, client, req)
+	case "refresh_token":
+		return s.refresh(ctx, client, req.RefreshToken)
+	default:
+		return nil, oauthError("unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+	}
+}
+
+// exchangeCode issues the first tokens of a grant for its authorization
+// code. A code presented again revokes the tokens it was exchanged for, as
+// it may have been stolen; one presented by another client than it was
+// issued to is refused and stays usable.
+func (s *oidcService) exchangeCode(ctx context.Context, client *model.OAuthClient, req *dto.TokenRequest) (*dto.TokenResponse, error) {
+	if req.Code == "" {
+		return nil, oauthError("invalid_request", "code is required")
+	}
+	codeHash := hashSecret(req.Code)
+	now := time.Now()
+
+	code, err := s.codeRepo.Use(ctx, codeHash, client.ID, now)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if used, err := s.codeRepo.GetByHash(ctx, codeHash); err == nil && used.UsedAt != nil && used.ClientID == client.ID {
+			if err := s.tokenRepo.RevokeGrant(ctx, used.GrantID, now); err != nil {
+				return nil, fmt.Errorf("failed to revoke grant: %w", err)
+			}
+			s.audit.Log(ctx, nil, AuditOAuthTokenReused, "user", fmt.Sprint(used.UserID), map[string]any{"client_id": client.ClientID, "token": "authorization_code"})
+		}
+		return nil, oauthError("invalid_grant", "the code is invalid, expired or already used")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to use authorization code: %w", err)
+	}
+
+	if req.RedirectURI != code.RedirectURI {
+		return nil, oauthError("invalid_grant", "redirect_uri doesn't match the authorization request")
+	}
+	if !verifyCodeChallenge(code.CodeChallenge, req.CodeVerifier) {
+		return nil, oauthError("invalid_grant", "code_verifier doesn't match the code_challenge")
+	}
+
+	if err := s.activeSession(ctx, code.SessionID); err != nil {
+		return nil, err
+	}
+	user, err := s.activeUser(ctx, code.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueTokens(ctx, client, user, code.GrantID, code.SessionID, code.Scope, code.AuthTime, code.Nonce)
+}
+
+// refresh replaces a refresh token with new tokens. Refresh tokens work
+// once; one presented again revokes its whole grant, as it may have been
+// stolen. They stop working when the session they were signed in with ends.
+func (s *oidcService) refresh(ctx context.Context, client *model.OAuthClient, refreshToken string) (*dto.TokenResponse, error) {
+	if refreshToken == "" {
+		return nil, oauthError("invalid_request", "refresh_token is required")
+	}
+	invalid := oauthError("invalid_grant", "the refresh token is invalid, expired or revoked")
+
+	token, err := s.tokenRepo.GetByHash(ctx, hashSecret(refreshToken))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, invalid
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if token.Kind != model.OAuthRefreshToken || token.ClientID != client.ID || !time.Now().Before(token.ExpiresAt) {
+		return nil, invalid
+	}
+
+	now := time.Now()
+	if err := s.activeSession(ctx, token.SessionID); err != nil {
+		if err := s.tokenRepo.RevokeGrant(ctx, token.GrantID, now); err != nil {
+			return nil, fmt.Errorf("failed to revoke grant: %w", err)
+		}
+		return nil, err
+	}
+	revoked, err := s.tokenRepo.Revoke(ctx, token.ID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+	if !revoked {
+		if err := s.tokenRepo.RevokeGrant(ctx, token.GrantID, now); err != nil {
+			return nil, fmt.Errorf("failed to revoke grant: %w", err)
+		}
+		s.audit.Log(ctx, nil, AuditOAuthTokenReused, "user", fmt.Sprint(token.UserID), map[string]any{"client_id": client.ClientID, "token": "refresh_token"})
+		return nil, invalid
+	}
+
+	user, err := s.activeUser(ctx, token.UserID)
+	if err != nil {
+		if err := s.tokenRepo.RevokeGrant(ctx, token.GrantID, now); err != nil {
+			return nil, fmt.Errorf("failed to revoke grant: %w", err)
+		}
+		return nil, err
+	}
+	return s.issueTokens(ctx, client, user, token.GrantID, token.SessionID, token.Scope, token.AuthTime, "")
+}
+
+// activeSession checks that the session a grant was signed in with has
+// neither expired nor ended
+func (s *oidcService) activeSession(ctx context.Context, sessionID string) error {
+	session, err := s.sessionRepo.GetByID(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return oauthError("invalid_grant", "the session has ended")
+		}
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	if session.IsExpired() {
+		return oauthError("invalid_grant", "the session has ended")
+	}
+	return nil
+}
+
+// activeUser returns a grant's user, who must still exist and be enabled
+func (s *oidcService) activeUser(ctx context.Context, userID uint) (*model.User, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, oauthError("invalid_grant", "the user no longer exists")
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if !user.Enabled {
+		return nil, oauthError("invalid_grant", "the user account is disabled")
+	}
+	return user, nil
+}
+
+// idTokenClaims are the claims of an ID token
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	AuthTime int64  `json:"auth_time"`
+	Nonce    string `json:"nonce,omitempty"`
+	AtHash   string `json:"at_hash,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Role     string `json:"role,omitempty"`
+}
+
+// issueTokens issues an access token, a refresh token and an ID token of a
+// grant
+func (s *oidcService) issueTokens(ctx context.Context, client *model.OAuthClient, user *model.User, grantID, sessionID, scope string, authTime time.Time, nonce string) (*dto.TokenResponse, error) {
+	now := time.Now()
+	accessToken, err := s.createToken(ctx, model.OAuthAccessToken, client, user, grantID, sessionID, scope, authTime, now.Add(s.cfg.AccessTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := s.createToken(ctx, model.OAuthRefreshToken, client, user, grantID, sessionID, scope, authTime, now.Add(s.cfg.RefreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	// at_hash binds the access token to the ID token: the left half of its
+	// SHA-256, base64url encoded
+	atHash := sha256.Sum256([]byte(accessToken))
+	info := userInfo(user, scope)
+	idToken, err := s.keys.Sign(ctx, &idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.cfg.Issuer,
+			Subject:   info.Sub,
+			Audience:  jwt.ClaimStrings{client.ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.cfg.AccessTokenTTL)),
+		},
+		AuthTime: authTime.Unix(),
+		Nonce:    nonce,
+		AtHash:   base64.RawURLEncoding.EncodeToString(atHash[:len(atHash)/2]),
+		Name:     info.Name,
+		Email:    info.Email,
+		Role:     info.Role,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign ID token: %w", err)
+	}
+
+	return &dto.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.cfg.AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+		Scope:        scope,
+	}, nil
+}
+
+// createToken stores a new access or refresh token and returns it
+func (s *oidcService) createToken(ctx context.Context, kind model.OAuthTokenKind, client *model.OAuthClient, user *model.User, grantID, sessionID, scope string, authTime, expiresAt time.Time) (string, error) {
+	token, err := generateSessionID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate %s token: %w", kind, err)
+	}
+	record := &model.OAuthToken{
+		TokenHash: hashSecret(token),
+		Kind:      kind,
+		GrantID:   grantID,
+		ClientID:  client.ID,
+		UserID:    user.ID,
+		SessionID: sessionID,
+		Scope:     scope,
+		AuthTime:  authTime,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.tokenRepo.Create(ctx, record); err != nil {
+		return "", fmt.Errorf("failed to create %s token: %w", kind, err)
+	}
+	return token, nil
+}
+
+// UserInfo returns the claims about the user an access token's scopes
+// allow
+func (s *oidcService) UserInfo(ctx context.Context, accessToken string) (*dto.UserInfoResponse, error) {
+	token, err := s.tokenRepo.GetByHash(ctx, hashSecret(accessToken))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAccessToken
+		}
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+	if token.Kind != model.OAuthAccessToken || !token.IsActive() {
+		return nil, ErrInvalidAccessToken
+	}
+
+	user, err := s.userRepo.GetByID(ctx, token.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAccessToken
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if !user.Enabled {
+		return nil, ErrInvalidAccessToken
+	}
+	return userInfo(user, token.Scope), nil
+}
+
+// userInfo returns the claims about a user the scopes allow
+func userInfo(user *model.User, scope string) *dto.UserInfoResponse {
+	info := &dto.UserInfoResponse{Sub: strconv.FormatUint(uint64(user.ID), 10)}
+	if hasScope(scope, ScopeProfile) {
+		info.Name = user.Name
+	}
+	if hasScope(scope, ScopeEmail) {
+		info.Email = user.Email
+	}
+	if hasScope(scope, ScopeRoles) {
+		info.Role = string(user.Role)
+	}
+	return info
+}
+
+// Revoke revokes a token of the authenticated client (RFC 7009); revoking
+// a refresh token revokes its whole grant. Unknown tokens and other
+// clients' tokens are ignored.
+func (s *oidcService) Revoke(ctx context.Context, req *dto.RevokeTokenRequest) error {
+	client, err := s.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return err
+	}
+
+	token, err := s.tokenRepo.GetByHash(ctx, hashSecret(req.Token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to get token: %w", err)
+	}
+	if token.ClientID != client.ID {
+		return nil
+	}
+
+	now := time.Now()
+	if token.Kind == model.OAuthRefreshToken {
+		err = s.tokenRepo.RevokeGrant(ctx, token.GrantID, now)
+	} else {
+		_, err = s.tokenRepo.Revoke(ctx, token.ID, now)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+// authenticateClient checks the credentials a client sent to the token or
+// revocation endpoint. Public clients send no secret.
+func (s *oidcService) authenticateClient(ctx context.Context, clientID, secret string) (*model.OAuthClient, error) {
+	invalid := oauthError("invalid_client", "client authentication failed")
+	if clientID == "" {
+		return nil, invalid
+	}
+	client, err := s.clientRepo.GetByClientID(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, invalid
+		}
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+
+	if client.IsPublic() {
+		if secret != "" {
+			return nil, invalid
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, invalid
+	}
+	return client, nil
+}
+
+// Maintain deletes expired authorization codes and tokens
+func (s *oidcService) Maintain(ctx context.Context) error {
+	now := time.Now()
+	if _, err := s.codeRepo.DeleteExpired(ctx, now); err != nil {
+		return fmt.Errorf("failed to delete expired authorization codes: %w", err)
+	}
+	if _, err := s.tokenRepo.DeleteExpired(ctx, now); err != nil {
+		return fmt.Errorf("failed to delete expired tokens: %w", err)
+	}
+	return nil
+}
+
+// RunOIDCMaintenance runs the provider's maintenance every interval until
+// ctx is done
+func RunOIDCMaintenance(ctx context.Context, oidc OIDCService, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := oidc.Maintain(ctx); err != nil && ctx.Err() == nil {
+			logger.Error("failed to run OpenID Connect maintenance", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetClients lists the registered clients
+func (s *oidcService) GetClients(ctx context.Context) ([]dto.OAuthClientResponse, error) {
+	if err := authorize(ctx, PermClientsRead); err != nil {
+		return nil, err
+	}
+
+	clients, err := s.clientRepo.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clients: %w", err)
+	}
+	responses := make([]dto.OAuthClientResponse, len(clients))
+	for i := range clients {
+		responses[i] = *toOAuthClientResponse(&clients[i])
+	}
+	return responses, nil
+}
+
+// CreateClient registers a client, returning its secret unless it is
+// public
+func (s *oidcService) CreateClient(ctx context.Context, req *dto.CreateOAuthClientRequest) (*dto.OAuthClientSecretResponse, error) {
+	if err := authorize(ctx, PermClientsWrite); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: a name is required", ErrInvalidClientRegistration)
+	}
+	if len(req.RedirectURIs) == 0 {
+		return nil, fmt.Errorf("%w: at least one redirect URI is required", ErrInvalidClientRegistration)
+	}
+	for _, uri := range req.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return nil, err
+		}
+	}
+
+	id := make([]byte, oauthClientIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate client ID: %w", err)
+	}
+	client := &model.OAuthClient{
+		ClientID:     hex.EncodeToString(id),
+		Name:         name,
+		RedirectURIs: req.RedirectURIs,
+	}
+	var secret string
+	if !req.Public {
+		var err error
+		if secret, err = generateSessionID(); err != nil {
+			return nil, fmt.Errorf("failed to generate client secret: %w", err)
+		}
+		client.SecretHash = hashSecret(secret)
+	}
+	if err := s.clientRepo.Create(ctx, client); err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	s.audit.Log(ctx, nil, AuditOAuthClientCreated, "oauth_client", fmt.Sprint(client.ID), map[string]any{
+		"client_id":     client.ClientID,
+		"name":          client.Name,
+		"public":        req.Public,
+		"redirect_uris": req.RedirectURIs,
+	})
+
+	return &dto.OAuthClientSecretResponse{Client: *toOAuthClientResponse(client), ClientSecret: secret}, nil
+}
+
+// RegenerateClientSecret replaces a confidential client's secret; the old
+// one stops working right away
+func (s *oidcService) RegenerateClientSecret(ctx context.Context, id uint) (*dto.OAuthClientSecretResponse, error) {
+	if err := authorize(ctx, PermClientsWrite); err != nil {
+		return nil, err
+	}
+
+	client, err := s.clientRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("client not found")
+		}
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+	if client.IsPublic() {
+		return nil, fmt.Errorf("%w: public clients have no secret", ErrInvalidClientRegistration)
+	}
+
+	secret, err := generateSessionID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate client secret: %w", err)
+	}
+	client.SecretHash = hashSecret(secret)
+	if err := s.clientRepo.Update(ctx, client); err != nil {
+		return nil, fmt.Errorf("failed to update client: %w", err)
+	}
+
+	s.audit.Log(ctx, nil, AuditOAuthClientSecretRotated, "oauth_client", fmt.Sprint(client.ID), map[string]any{"client_id": client.ClientID})
+
+	return &dto.OAuthClientSecretResponse{Client: *toOAuthClientResponse(client), ClientSecret: secret}, nil
+}
+
+// DeleteClient deletes a client along with the tokens issued to it
+func (s *oidcService) DeleteClient(ctx context.Context, id uint) error {
+	if err := authorize(ctx, PermClientsWrite); err != nil {
+		return err
+	}
+
+	client, err := s.clientRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("client not found")
+		}
+		return fmt.Errorf("failed to get client: %w", err)
+	}
+	if _, err := s.clientRepo.Delete(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete client: %w", err)
+	}
+
+	s.audit.Log(ctx, nil, AuditOAuthClientDeleted, "oauth_client", fmt.Sprint(id), map[string]any{"client_id": client.ClientID, "name": client.Name})
+
+	return nil
+}
+
+func toOAuthClientResponse(client *model.OAuthClient) *dto.OAuthClientResponse {
+	return &dto.OAuthClientResponse{
+		ID:           client.ID,
+		ClientID:     client.ClientID,
+		Name:         client.Name,
+		Public:       client.IsPublic(),
+		RedirectURIs: client.RedirectURIs,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// validateRedirectURI accepts absolute http(s) URLs without a fragment,
+// which OAuth 2.0 forbids in redirect URIs
+func validateRedirectURI(uri string) error {
+	parsed, err := url.Parse(uri)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" || parsed.Fragment != "" || strings.Contains(uri, "#") {
+		return fmt.Errorf("%w: redirect URI %q must be an absolute http(s) URL without a fragment", ErrInvalidClientRegistration, uri)
+	}
+	return nil
+}
+
+// normalizeScope keeps the supported scopes of a space-separated list,
+// once each, in the order of oidcScopes
+func normalizeScope(scope string) string {
+	requested := strings.Fields(scope)
+	var kept []string
+	for _, supported := range oidcScopes {
+		if slices.Contains(requested, supported) {
+			kept = append(kept, supported)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// hasScope reports whether a space-separated scope list includes a scope
+func hasScope(scope, want string) bool {
+	return slices.Contains(strings.Fields(scope), want)
+}
+
+// validCodeChallenge reports whether challenge is an S256 PKCE challenge:
+// a base64url encoded SHA-256
+func validCodeChallenge(challenge string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// verifyCodeChallenge reports whether verifier is the PKCE code verifier of
+// an S256 challenge
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
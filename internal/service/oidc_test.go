@@ -0,0 +1,798 @@
+package service
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"math/big"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// mockOAuthClientRepository is an in-memory OAuthClientRepository
+type mockOAuthClientRepository struct {
+	clients map[uint]*model.OAuthClient
+	nextID  uint
+}
+
+func (m *mockOAuthClientRepository) Create(ctx context.Context, client *model.OAuthClient) error {
+	m.nextID++
+	client.ID = m.nextID
+	client.CreatedAt = time.Now()
+	m.clients[client.ID] = client
+	return nil
+}
+
+func (m *mockOAuthClientRepository) GetByID(ctx context.Context, id uint) (*model.OAuthClient, error) {
+	client, ok := m.clients[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return client, nil
+}
+
+func (m *mockOAuthClientRepository) GetByClientID(ctx context.Context, clientID string) (*model.OAuthClient, error) {
+	for _, client := range m.clients {
+		if client.ClientID == clientID {
+			return client, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *mockOAuthClientRepository) GetAll(ctx context.Context) ([]model.OAuthClient, error) {
+	var clients []model.OAuthClient
+	for _, client := range m.clients {
+		clients = append(clients, *client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Name < clients[j].Name })
+	return clients, nil
+}
+
+func (m *mockOAuthClientRepository) Update(ctx context.Context, client *model.OAuthClient) error {
+	m.clients[client.ID] = client
+	return nil
+}
+
+func (m *mockOAuthClientRepository) Delete(ctx context.Context, id uint) (bool, error) {
+	_, ok := m.clients[id]
+	delete(m.clients, id)
+	return ok, nil
+}
+
+// mockOAuthCodeRepository is an in-memory OAuthAuthorizationCodeRepository
+type mockOAuthCodeRepository struct {
+	codes map[string]*model.OAuthAuthorizationCode
+}
+
+func (m *mockOAuthCodeRepository) Create(ctx context.Context, code *model.OAuthAuthorizationCode) error {
+	m.codes[code.CodeHash] = code
+	return nil
+}
+
+func (m *mockOAuthCodeRepository) GetByHash(ctx context.Context, codeHash string) (*model.OAuthAuthorizationCode, error) {
+	code, ok := m.codes[codeHash]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return code, nil
+}
+
+func (m *mockOAuthCodeRepository) Use(ctx context.Context, codeHash string, clientID uint, usedAt time.Time) (*model.OAuthAuthorizationCode, error) {
+	code, ok := m.codes[codeHash]
+	if !ok || code.ClientID != clientID || code.UsedAt != nil || !usedAt.Before(code.ExpiresAt) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	code.UsedAt = &usedAt
+	return code, nil
+}
+
+func (m *mockOAuthCodeRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	var deleted int64
+	for hash, code := range m.codes {
+		if code.ExpiresAt.Before(before) {
+			delete(m.codes, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// mockOAuthTokenRepository is an in-memory OAuthTokenRepository. Tokens are
+// signed in with the sessions of sessions, if set.
+type mockOAuthTokenRepository struct {
+	tokens   map[string]*model.OAuthToken
+	nextID   uint
+	sessions *mockSessionRepository
+}
+
+func newMockOAuthTokenRepository() *mockOAuthTokenRepository {
+	return &mockOAuthTokenRepository{tokens: make(map[string]*model.OAuthToken)}
+}
+
+func (m *mockOAuthTokenRepository) Create(ctx context.Context, token *model.OAuthToken) error {
+	m.nextID++
+	token.ID = m.nextID
+	m.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (m *mockOAuthTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*model.OAuthToken, error) {
+	token, ok := m.tokens[tokenHash]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return token, nil
+}
+
+func (m *mockOAuthTokenRepository) Revoke(ctx context.Context, id uint, revokedAt time.Time) (bool, error) {
+	for _, token := range m.tokens {
+		if token.ID == id && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockOAuthTokenRepository) RevokeGrant(ctx context.Context, grantID string, revokedAt time.Time) error {
+	for _, token := range m.tokens {
+		if token.GrantID == grantID && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (m *mockOAuthTokenRepository) RevokeSession(ctx context.Context, sessionID string, revokedAt time.Time) error {
+	for _, token := range m.tokens {
+		if token.SessionID == sessionID && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (m *mockOAuthTokenRepository) RevokeUser(ctx context.Context, userID uint, revokedAt time.Time) error {
+	for _, token := range m.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (m *mockOAuthTokenRepository) RevokeEndedSessions(ctx context.Context, revokedAt time.Time) (int64, error) {
+	var revoked int64
+	for _, token := range m.tokens {
+		if token.RevokedAt != nil || !token.ExpiresAt.After(revokedAt) {
+			continue
+		}
+		if m.sessions != nil {
+			if session, ok := m.sessions.sessions[token.SessionID]; ok && session.ExpiresAt.After(revokedAt) {
+				continue
+			}
+		}
+		token.RevokedAt = &revokedAt
+		revoked++
+	}
+	return revoked, nil
+}
+
+func (m *mockOAuthTokenRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	var deleted int64
+	for hash, token := range m.tokens {
+		if token.ExpiresAt.Before(before) {
+			delete(m.tokens, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// mockOAuthConsentRepository is an in-memory OAuthConsentRepository
+type mockOAuthConsentRepository struct {
+	consents map[[2]uint]*model.OAuthConsent
+}
+
+func (m *mockOAuthConsentRepository) Get(ctx context.Context, userID, clientID uint) (*model.OAuthConsent, error) {
+	consent, ok := m.consents[[2]uint{userID, clientID}]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return consent, nil
+}
+
+func (m *mockOAuthConsentRepository) Save(ctx context.Context, consent *model.OAuthConsent) error {
+	m.consents[[2]uint{consent.UserID, consent.ClientID}] = consent
+	return nil
+}
+
+// mockSigningKeyRepository is an in-memory SigningKeyRepository
+type mockSigningKeyRepository struct {
+	keys []model.SigningKey
+}
+
+func (m *mockSigningKeyRepository) Create(ctx context.Context, key *model.SigningKey) error {
+	m.keys = append([]model.SigningKey{*key}, m.keys...)
+	return nil
+}
+
+func (m *mockSigningKeyRepository) GetAll(ctx context.Context) ([]model.SigningKey, error) {
+	return append([]model.SigningKey(nil), m.keys...), nil
+}
+
+func (m *mockSigningKeyRepository) ReplacePrivateKey(ctx context.Context, id, old, privateKey string) (bool, error) {
+	for i := range m.keys {
+		if m.keys[i].ID == id && m.keys[i].PrivateKey == old {
+			m.keys[i].PrivateKey = privateKey
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockSigningKeyRepository) DeleteCreatedBefore(ctx context.Context, before time.Time) (int64, error) {
+	var kept []model.SigningKey
+	for _, key := range m.keys {
+		if !key.CreatedAt.Before(before) {
+			kept = append(kept, key)
+		}
+	}
+	deleted := int64(len(m.keys) - len(kept))
+	m.keys = kept
+	return deleted, nil
+}
+
+func (m *mockSigningKeyRepository) RunExclusive(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	return true, fn(ctx)
+}
+
+const (
+	testIssuer       = "https://id.example.com"
+	testRedirectURI  = "https://wiki.example.com/callback"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+type oidcTest struct {
+	svc      OIDCService
+	users    *mockUserRepository
+	sessions *mockSessionRepository
+	tokens   *mockOAuthTokenRepository
+	consents *mockOAuthConsentRepository
+	keys     *mockSigningKeyRepository
+	audit    *recordingAudit
+	client   *model.OAuthClient
+	secret   string
+	session  *model.Session
+}
+
+// setupOIDC creates a provider with one user and one confidential client
+// registered for testRedirectURI
+func setupOIDC(t *testing.T) *oidcTest {
+	t.Helper()
+	test := &oidcTest{
+		users:    newMockUserRepository(),
+		tokens:   newMockOAuthTokenRepository(),
+		consents: &mockOAuthConsentRepository{consents: make(map[[2]uint]*model.OAuthConsent)},
+		keys:     &mockSigningKeyRepository{},
+		audit:    &recordingAudit{},
+	}
+	test.sessions = newMockSessionRepository(test.users)
+	test.tokens.sessions = test.sessions
+	clients := &mockOAuthClientRepository{clients: make(map[uint]*model.OAuthClient)}
+	codes := &mockOAuthCodeRepository{codes: make(map[string]*model.OAuthAuthorizationCode)}
+
+	svc, err := NewOIDCService(clients, codes, test.tokens, test.consents, test.users, test.sessions,
+		NewSigningKeys(test.keys, testSecrets, 0), test.audit, OIDCConfig{Issuer: testIssuer + "/"})
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	test.svc = svc
+
+	_ = test.users.Create(context.Background(), &model.User{Name: "Test User", Email: "test@example.com", Role: model.RoleUser, Enabled: true})
+	test.session = &model.Session{ID: "test-session", UserID: 1, CreatedAt: time.Now().Add(-time.Minute), ExpiresAt: time.Now().Add(time.Hour)}
+	_ = test.sessions.Create(context.Background(), test.session)
+
+	ctx := WithActorRole(WithActor(context.Background(), 1), model.RoleAdmin)
+	created, err := svc.CreateClient(ctx, &dto.CreateOAuthClientRequest{Name: "Wiki", RedirectURIs: []string{testRedirectURI}})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	test.client, _ = clients.GetByID(ctx, created.Client.ID)
+	test.secret = created.ClientSecret
+	return test
+}
+
+func (test *oidcTest) authorizeRequest() *dto.AuthorizeRequest {
+	challenge := sha256.Sum256([]byte(testCodeVerifier))
+	return &dto.AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            test.client.ClientID,
+		RedirectURI:         testRedirectURI,
+		Scope:               "openid email bogus profile",
+		State:               "xyz",
+		Nonce:               "n-0S6_WzA2Mj",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(challenge[:]),
+		CodeChallengeMethod: "S256",
+	}
+}
+
+// authorize runs an authorization request through to its code
+func (test *oidcTest) authorize(t *testing.T) string {
+	t.Helper()
+	ctx := context.Background()
+	req := test.authorizeRequest()
+	client, err := test.svc.CheckAuthorization(ctx, req)
+	if err != nil {
+		t.Fatalf("check authorization failed: %v", err)
+	}
+	redirect, err := test.svc.Authorize(ctx, test.session, client, req)
+	if err != nil {
+		t.Fatalf("authorize failed: %v", err)
+	}
+
+	parsed, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatalf("invalid redirect %q: %v", redirect, err)
+	}
+	query := parsed.Query()
+	if query.Get("state") != "xyz" || query.Get("iss") != testIssuer || query.Get("code") == "" {
+		t.Fatalf("expected code, state and issuer in the redirect, got %q", redirect)
+	}
+	return query.Get("code")
+}
+
+func (test *oidcTest) exchange(code, verifier string) (*dto.TokenResponse, error) {
+	return test.svc.Token(context.Background(), &dto.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: verifier,
+		ClientID:     test.client.ClientID,
+		ClientSecret: test.secret,
+	})
+}
+
+func (test *oidcTest) refresh(refreshToken string) (*dto.TokenResponse, error) {
+	return test.svc.Token(context.Background(), &dto.TokenRequest{
+		GrantType:    "refresh_token",
+		RefreshToken: refreshToken,
+		ClientID:     test.client.ClientID,
+		ClientSecret: test.secret,
+	})
+}
+
+func oauthErrorCode(err error) string {
+	var oauthErr *OAuthError
+	if errors.As(err, &oauthErr) {
+		return oauthErr.Code
+	}
+	return ""
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	test := setupOIDC(t)
+	ctx := context.Background()
+
+	code := test.authorize(t)
+	if consented, _ := test.svc.HasConsent(ctx, 1, test.client, "openid email"); !consented {
+		t.Error("expected the consent to be remembered")
+	}
+
+	tokens, err := test.exchange(code, testCodeVerifier)
+	if err != nil {
+		t.Fatalf("token exchange failed: %v", err)
+	}
+	if tokens.Scope != "openid profile email" {
+		t.Errorf("expected unsupported scopes to be dropped, got %q", tokens.Scope)
+	}
+
+	// The ID token verifies against the published keys
+	jwks, err := test.svc.JWKS(ctx)
+	if err != nil {
+		t.Fatalf("JWKS failed: %v", err)
+	}
+	claims := &idTokenClaims{}
+	_, err = jwt.ParseWithClaims(tokens.IDToken, claims, func(token *jwt.Token) (any, error) {
+		for _, key := range jwks.Keys {
+			if key.Kid == token.Header["kid"] {
+				n, _ := base64.RawURLEncoding.DecodeString(key.N)
+				e, _ := base64.RawURLEncoding.DecodeString(key.E)
+				return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+			}
+		}
+		return nil, errors.New("unknown key")
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(testIssuer), jwt.WithAudience(test.client.ClientID))
+	if err != nil {
+		t.Fatalf("ID token doesn't verify: %v", err)
+	}
+	if claims.Subject != "1" || claims.Nonce != "n-0S6_WzA2Mj" || claims.Email != "test@example.com" || claims.Name != "Test User" || claims.Role != "" {
+		t.Errorf("unexpected ID token claims %+v", claims)
+	}
+
+	info, err := test.svc.UserInfo(ctx, tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("userinfo failed: %v", err)
+	}
+	if info.Sub != "1" || info.Email != "test@example.com" {
+		t.Errorf("unexpected userinfo %+v", info)
+	}
+	if _, err := test.svc.UserInfo(ctx, tokens.RefreshToken); !errors.Is(err, ErrInvalidAccessToken) {
+		t.Errorf("expected refresh tokens to be refused as access tokens, got %v", err)
+	}
+}
+
+func TestOIDCCheckAuthorization(t *testing.T) {
+	test := setupOIDC(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		modify func(*dto.AuthorizeRequest)
+		want   error
+		code   string
+	}{
+		{"unknown client", func(r *dto.AuthorizeRequest) { r.ClientID = "nope" }, ErrUnknownOAuthClient, ""},
+		{"unregistered redirect", func(r *dto.AuthorizeRequest) { r.RedirectURI = "https://evil.example.com/cb" }, ErrInvalidRedirectURI, ""},
+		{"no openid", func(r *dto.AuthorizeRequest) { r.Scope = "email" }, nil, "invalid_scope"},
+		{"no PKCE", func(r *dto.AuthorizeRequest) { r.CodeChallenge, r.CodeChallengeMethod = "", "" }, nil, "invalid_request"},
+		{"plain PKCE", func(r *dto.AuthorizeRequest) { r.CodeChallengeMethod = "plain" }, nil, "invalid_request"},
+		{"implicit flow", func(r *dto.AuthorizeRequest) { r.ResponseType = "token" }, nil, "unsupported_response_type"},
+		{"prompt none and login", func(r *dto.AuthorizeRequest) { r.Prompt = "none login" }, nil, "invalid_request"},
+	}
+	for _, tt := range tests {
+		req := test.authorizeRequest()
+		tt.modify(req)
+		_, err := test.svc.CheckAuthorization(ctx, req)
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+		if tt.code != "" && oauthErrorCode(err) != tt.code {
+			t.Errorf("%s: expected %s, got %v", tt.name, tt.code, err)
+		}
+	}
+}
+
+func TestOIDCTokenExchangeChecks(t *testing.T) {
+	test := setupOIDC(t)
+
+	if _, err := test.exchange(test.authorize(t), "wrong-verifier-wrong-verifier-wrong-verifier"); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("expected a wrong code verifier to be refused, got %v", err)
+	}
+
+	code := test.authorize(t)
+	test.secret = "not-the-secret"
+	if _, err := test.exchange(code, testCodeVerifier); oauthErrorCode(err) != "invalid_client" {
+		t.Errorf("expected a wrong client secret to be refused, got %v", err)
+	}
+}
+
+// A code presented by another client than it was issued to is refused
+// without being used up, so a client can't burn another's codes
+func TestOIDCCodeOfAnotherClient(t *testing.T) {
+	test := setupOIDC(t)
+	ctx := WithActorRole(WithActor(context.Background(), 1), model.RoleAdmin)
+
+	other, err := test.svc.CreateClient(ctx, &dto.CreateOAuthClientRequest{Name: "Blog", RedirectURIs: []string{testRedirectURI}})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	code := test.authorize(t)
+	_, err = test.svc.Token(context.Background(), &dto.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: testCodeVerifier,
+		ClientID:     other.Client.ClientID,
+		ClientSecret: other.ClientSecret,
+	})
+	if oauthErrorCode(err) != "invalid_grant" {
+		t.Fatalf("expected another client's code to be refused, got %v", err)
+	}
+
+	if _, err := test.exchange(code, testCodeVerifier); err != nil {
+		t.Errorf("expected the code to still work for its client, got %v", err)
+	}
+}
+
+func TestOIDCCodeReuseRevokesGrant(t *testing.T) {
+	test := setupOIDC(t)
+	ctx := context.Background()
+
+	code := test.authorize(t)
+	tokens, err := test.exchange(code, testCodeVerifier)
+	if err != nil {
+		t.Fatalf("token exchange failed: %v", err)
+	}
+
+	if _, err := test.exchange(code, testCodeVerifier); oauthErrorCode(err) != "invalid_grant" {
+		t.Fatalf("expected a used code to be refused, got %v", err)
+	}
+	if _, err := test.svc.UserInfo(ctx, tokens.AccessToken); !errors.Is(err, ErrInvalidAccessToken) {
+		t.Errorf("expected the tokens of a reused code to be revoked, got %v", err)
+	}
+	if len(test.audit.entries) == 0 || test.audit.entries[len(test.audit.entries)-1].action != AuditOAuthTokenReused {
+		t.Error("expected the reuse to be audited")
+	}
+}
+
+func TestOIDCRefreshTokenRotation(t *testing.T) {
+	test := setupOIDC(t)
+	ctx := context.Background()
+
+	first, err := test.exchange(test.authorize(t), testCodeVerifier)
+	if err != nil {
+		t.Fatalf("token exchange failed: %v", err)
+	}
+	second, err := test.refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("expected a new refresh token")
+	}
+
+	// The replaced refresh token presented again looks stolen: the whole
+	// grant is revoked, the current tokens included
+	if _, err := test.refresh(first.RefreshToken); oauthErrorCode(err) != "invalid_grant" {
+		t.Fatalf("expected a used refresh token to be refused, got %v", err)
+	}
+	if _, err := test.refresh(second.RefreshToken); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("expected the grant's current refresh token to be revoked, got %v", err)
+	}
+	if _, err := test.svc.UserInfo(ctx, second.AccessToken); !errors.Is(err, ErrInvalidAccessToken) {
+		t.Errorf("expected the grant's access token to be revoked, got %v", err)
+	}
+}
+
+func TestOIDCRefreshDisabledUser(t *testing.T) {
+	test := setupOIDC(t)
+
+	tokens, err := test.exchange(test.authorize(t), testCodeVerifier)
+	if err != nil {
+		t.Fatalf("token exchange failed: %v", err)
+	}
+	user, _ := test.users.GetByID(context.Background(), 1)
+	user.Enabled = false
+
+	if _, err := test.refresh(tokens.RefreshToken); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("expected disabled users' tokens to be refused, got %v", err)
+	}
+}
+
+// Ending the session a user signed in to an app with revokes the app's
+// tokens, and logging the user out everywhere revokes all of them
+func TestOIDCTokensEndWithSessions(t *testing.T) {
+	test := setupOIDC(t)
+	ctx := context.Background()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	user, _ := test.users.GetByID(ctx, 1)
+	user.PasswordHash = string(hash)
+	sessions := test.sessions
+	auth := NewAuthService(test.users, sessions, test.tokens, newMockLoginChallengeRepository(test.users), newMockRecoveryCodeRepository(),
+		newMockWebAuthnCredentialRepository(), newMockWebAuthnCeremonyRepository(), newMockLoginThrottleRepository(), newNoopAudit(), time.Hour,
+		SessionTimeouts{}, TwoFactorConfig{}, newTestWebAuthn(t), LockoutConfig{}, newTestPasswordPolicy())
+
+	// signIn logs in and signs in to the client with the new session,
+	// returning the session cookie's value and the client's tokens
+	signIn := func() (string, *dto.TokenResponse) {
+		t.Helper()
+		login, err := auth.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+		if err != nil {
+			t.Fatalf("login failed: %v", err)
+		}
+		test.session, _ = sessions.GetByID(ctx, hashSecret(login.SessionID))
+		tokens, err := test.exchange(test.authorize(t), testCodeVerifier)
+		if err != nil {
+			t.Fatalf("token exchange failed: %v", err)
+		}
+		return login.SessionID, tokens
+	}
+
+	sessionID, loggedOut := signIn()
+	if err := auth.Logout(ctx, sessionID); err != nil {
+		t.Fatalf("logout failed: %v", err)
+	}
+	if _, err := test.svc.UserInfo(ctx, loggedOut.AccessToken); !errors.Is(err, ErrInvalidAccessToken) {
+		t.Errorf("expected logging out to revoke the access token, got %v", err)
+	}
+	if _, err := test.refresh(loggedOut.RefreshToken); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("expected logging out to revoke the refresh token, got %v", err)
+	}
+
+	revokedID, revoked := signIn()
+	_, kept := signIn()
+	if err := auth.RevokeSession(ctx, 1, sessionHandle(hashSecret(revokedID))); err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if _, err := test.refresh(revoked.RefreshToken); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("expected the revoked session's refresh token to be refused, got %v", err)
+	}
+	if _, err := test.svc.UserInfo(ctx, kept.AccessToken); err != nil {
+		t.Fatalf("expected the other session's tokens to stay valid, got %v", err)
+	}
+
+	if err := auth.ForceLogout(adminContext(), nil, 1); err != nil {
+		t.Fatalf("force logout failed: %v", err)
+	}
+	if _, err := test.refresh(kept.RefreshToken); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("expected force logout to revoke the refresh token, got %v", err)
+	}
+}
+
+func TestOIDCRefreshNeedsSession(t *testing.T) {
+	test := setupOIDC(t)
+	ctx := context.Background()
+
+	tokens, err := test.exchange(test.authorize(t), testCodeVerifier)
+	if err != nil {
+		t.Fatalf("token exchange failed: %v", err)
+	}
+
+	// The session expired, e.g. after an idle timeout, but nothing revoked
+	// its tokens yet
+	test.session.ExpiresAt = time.Now().Add(-time.Second)
+	if _, err := test.refresh(tokens.RefreshToken); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("expected the refresh token of an expired session to be refused, got %v", err)
+	}
+	if _, err := test.svc.UserInfo(ctx, tokens.AccessToken); !errors.Is(err, ErrInvalidAccessToken) {
+		t.Errorf("expected the refused refresh to revoke the grant, got %v", err)
+	}
+
+	test.session.ExpiresAt = time.Now().Add(time.Hour)
+	code := test.authorize(t)
+	delete(test.sessions.sessions, test.session.ID)
+	if _, err := test.exchange(code, testCodeVerifier); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("expected the code of a purged session to be refused, got %v", err)
+	}
+}
+
+func TestOIDCRevoke(t *testing.T) {
+	test := setupOIDC(t)
+	ctx := context.Background()
+
+	tokens, err := test.exchange(test.authorize(t), testCodeVerifier)
+	if err != nil {
+		t.Fatalf("token exchange failed: %v", err)
+	}
+
+	if err := test.svc.Revoke(ctx, &dto.RevokeTokenRequest{Token: "unknown", ClientID: test.client.ClientID, ClientSecret: test.secret}); err != nil {
+		t.Errorf("expected unknown tokens to be ignored, got %v", err)
+	}
+	if err := test.svc.Revoke(ctx, &dto.RevokeTokenRequest{Token: tokens.RefreshToken, ClientID: test.client.ClientID, ClientSecret: test.secret}); err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if _, err := test.svc.UserInfo(ctx, tokens.AccessToken); !errors.Is(err, ErrInvalidAccessToken) {
+		t.Errorf("expected revoking the refresh token to revoke the grant, got %v", err)
+	}
+}
+
+func TestOIDCClientsRequirePermission(t *testing.T) {
+	test := setupOIDC(t)
+
+	ctx := WithActorRole(WithActor(context.Background(), 2), model.RoleViewer)
+	if clients, err := test.svc.GetClients(ctx); err != nil || len(clients) != 1 {
+		t.Errorf("expected viewers to list clients, got %v", err)
+	}
+	if _, err := test.svc.CreateClient(ctx, &dto.CreateOAuthClientRequest{Name: "Blog", RedirectURIs: []string{testRedirectURI}}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden, got %v", err)
+	}
+
+	admin := WithActorRole(WithActor(context.Background(), 1), model.RoleAdmin)
+	if _, err := test.svc.CreateClient(admin, &dto.CreateOAuthClientRequest{Name: "Blog", RedirectURIs: []string{"https://blog.example.com/cb#frag"}}); !errors.Is(err, ErrInvalidClientRegistration) {
+		t.Errorf("expected redirect URIs with a fragment to be refused, got %v", err)
+	}
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	repo := &mockSigningKeyRepository{}
+	keys := NewSigningKeys(repo, testSecrets, 24*time.Hour)
+	ctx := context.Background()
+
+	if err := keys.Rotate(ctx); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	if err := keys.Rotate(ctx); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	if len(repo.keys) != 1 {
+		t.Fatalf("expected one key until it is due for replacement, got %d", len(repo.keys))
+	}
+	if !IsSealed(repo.keys[0].PrivateKey) {
+		t.Error("expected the private key to be stored encrypted")
+	}
+	first := repo.keys[0].ID
+
+	// Due for replacement: the next key is published but doesn't sign yet
+	repo.keys[0].CreatedAt = time.Now().Add(-23 * time.Hour)
+	if err := keys.Rotate(ctx); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	jwks, err := keys.JWKS(ctx)
+	if err != nil {
+		t.Fatalf("JWKS failed: %v", err)
+	}
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("expected both keys to be published, got %d", len(jwks.Keys))
+	}
+	signed, err := keys.Sign(ctx, jwt.RegisteredClaims{Subject: "1"})
+	if err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+	token, _, _ := jwt.NewParser().ParseUnverified(signed, &jwt.RegisteredClaims{})
+	if token.Header["kid"] != first {
+		t.Errorf("expected the old key to keep signing during the lead time, got %v", token.Header["kid"])
+	}
+
+	// Retired keys are dropped once the tokens they signed have expired
+	repo.keys[1].CreatedAt = time.Now().Add(-72 * time.Hour)
+	if err := keys.Rotate(ctx); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	if len(repo.keys) != 1 || repo.keys[0].ID == first {
+		t.Errorf("expected the retired key to be deleted, got %d keys", len(repo.keys))
+	}
+}
+
+func TestEncryptSigningKeys(t *testing.T) {
+	repo := &mockSigningKeyRepository{}
+	ctx := context.Background()
+
+	// A key stored in the clear before private keys were encrypted
+	legacy, err := newSigningKey(testSecrets)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	if legacy.PrivateKey, err = testSecrets.Open(legacy.PrivateKey, signingKeyOwner(legacy.ID)); err != nil {
+		t.Fatalf("failed to open new key: %v", err)
+	}
+	_ = repo.Create(ctx, legacy)
+	plaintext := legacy.PrivateKey
+
+	keys := NewSigningKeys(repo, testSecrets, 0)
+	if _, err := keys.Sign(ctx, jwt.RegisteredClaims{Subject: "1"}); err != nil {
+		t.Fatalf("expected a key stored in the clear to keep signing, got %v", err)
+	}
+
+	encrypted, err := EncryptSigningKeys(ctx, repo, testSecrets)
+	if err != nil || encrypted != 1 {
+		t.Fatalf("EncryptSigningKeys() = %d, %v, want 1 key encrypted", encrypted, err)
+	}
+	if opened, err := testSecrets.Open(repo.keys[0].PrivateKey, signingKeyOwner(legacy.ID)); err != nil || opened != plaintext {
+		t.Fatalf("stored key opens to %.20q, %v, want the original", opened, err)
+	}
+	if encrypted, err := EncryptSigningKeys(ctx, repo, testSecrets); err != nil || encrypted != 0 {
+		t.Errorf("EncryptSigningKeys() again = %d, %v, want 0", encrypted, err)
+	}
+
+	keys = NewSigningKeys(repo, testSecrets, 0)
+	signed, err := keys.Sign(ctx, jwt.RegisteredClaims{Subject: "1"})
+	if err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+	if token, _, _ := jwt.NewParser().ParseUnverified(signed, &jwt.RegisteredClaims{}); token.Header["kid"] != legacy.ID {
+		t.Errorf("expected the encrypted key to sign, got %v", token.Header["kid"])
+	}
+
+	// A copy under another key's ID doesn't open
+	other, _ := newSigningKey(testSecrets)
+	other.PrivateKey = repo.keys[0].PrivateKey
+	if _, err := parseSigningKey(testSecrets, other); err == nil {
+		t.Error("expected a private key copied to another key to be refused")
+	}
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// PasswordHashArgon2id and PasswordHashBcrypt are the algorithms new
+	// password hashes can use
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+
+	// DefaultBcryptCost is the bcrypt cost factor used when none is
+	// configured
+	DefaultBcryptCost = 10
+	// DefaultArgon2Memory (KiB), DefaultArgon2Iterations and
+	// DefaultArgon2Parallelism follow the OWASP recommendation for argon2id
+	DefaultArgon2Memory      = 19 * 1024
+	DefaultArgon2Iterations  = 2
+	DefaultArgon2Parallelism = 1
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// PasswordHasher hashes passwords and verifies them against stored hashes
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the hash, and whether the
+	// hash should be replaced because it uses another algorithm or weaker
+	// parameters than new hashes do. Hashes in unknown formats never match.
+	Verify(password, hash string) (match, needsRehash bool)
+}
+
+// PasswordHashConfig configures how new password hashes are made. Existing
+// hashes of either algorithm keep verifying whatever is configured.
+type PasswordHashConfig struct {
+	// Algorithm is PasswordHashArgon2id (the default) or PasswordHashBcrypt
+	Algorithm  string
+	BcryptCost int
+	// Argon2Memory is in KiB
+	Argon2Memory      int
+	Argon2Iterations  int
+	Argon2Parallelism int
+}
+
+// passwordHasher implements PasswordHasher
+type passwordHasher struct {
+	cfg    PasswordHashConfig
+	argon2 argon2Params
+}
+
+// NewPasswordHasher creates a password hasher, filling in unset parameters
+// with the defaults
+func NewPasswordHasher(cfg PasswordHashConfig) (PasswordHasher, error) {
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = PasswordHashArgon2id
+	}
+	if cfg.BcryptCost == 0 {
+		cfg.BcryptCost = DefaultBcryptCost
+	}
+	if cfg.Argon2Memory == 0 {
+		cfg.Argon2Memory = DefaultArgon2Memory
+	}
+	if cfg.Argon2Iterations == 0 {
+		cfg.Argon2Iterations = DefaultArgon2Iterations
+	}
+	if cfg.Argon2Parallelism == 0 {
+		cfg.Argon2Parallelism = DefaultArgon2Parallelism
+	}
+
+	switch cfg.Algorithm {
+	case PasswordHashArgon2id:
+		if cfg.Argon2Iterations < 0 || cfg.Argon2Parallelism < 0 || cfg.Argon2Parallelism > math.MaxUint8 {
+			return nil, fmt.Errorf("argon2 iterations must be positive and parallelism between 1 and %d", math.MaxUint8)
+		}
+		if cfg.Argon2Memory < 8*cfg.Argon2Parallelism || cfg.Argon2Memory > math.MaxUint32 {
+			return nil, fmt.Errorf("argon2 memory must be at least 8 KiB per thread")
+		}
+	case PasswordHashBcrypt:
+		if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", cfg.Algorithm)
+	}
+	return &passwordHasher{cfg: cfg, argon2: argon2Params{
+		memory:      uint32(cfg.Argon2Memory),
+		iterations:  uint32(cfg.Argon2Iterations),
+		parallelism: uint8(cfg.Argon2Parallelism),
+	}}, nil
+}
+
+// Hash hashes a password with the configured algorithm
+func (h *passwordHasher) Hash(password string) (string, error) {
+	if h.cfg.Algorithm == PasswordHashBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cfg.BcryptCost)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash password: %w", err)
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	params := h.argon2
+	key := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, argon2KeyLength)
+	return params.encode(salt, key), nil
+}
+
+// Verify checks a password against a bcrypt or argon2id hash
+func (h *passwordHasher) Verify(password, hash string) (bool, bool) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return false, false
+		}
+		computed := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false
+		}
+		outdated := h.cfg.Algorithm != PasswordHashArgon2id ||
+			params.memory < h.argon2.memory ||
+			params.iterations < h.argon2.iterations ||
+			params.parallelism < h.argon2.parallelism ||
+			len(key) < argon2KeyLength
+		return true, outdated
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false, false
+	}
+	cost, _ := bcrypt.Cost([]byte(hash))
+	return true, h.cfg.Algorithm != PasswordHashBcrypt || cost < h.cfg.BcryptCost
+}
+
+// argon2Params are the parameters an argon2id hash was made with
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+// encode formats an argon2id hash in the PHC string format shared by other
+// implementations: $argon2id$v=19$m=<KiB>,t=<iterations>,p=<threads>$<salt>$<key>
+func (p argon2Params) encode(salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.iterations, p.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+}
+
+// decodeArgon2Hash parses a hash written by argon2Params.encode
+func decodeArgon2Hash(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+	if params.iterations == 0 || params.parallelism == 0 {
+		return params, nil, nil, errors.New("invalid argon2 parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("invalid argon2 key")
+	}
+	return params, salt, key, nil
+}
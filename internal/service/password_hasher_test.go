@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"identity/internal/service/dto"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHasherArgon2id(t *testing.T) {
+	hasher, err := NewPasswordHasher(PasswordHashConfig{Argon2Memory: 64, Argon2Iterations: 2})
+	if err != nil {
+		t.Fatalf("failed to create hasher: %v", err)
+	}
+
+	hash, err := hasher.Hash("correct-horse-battery")
+	if err != nil {
+		t.Fatalf("hash failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=2,p=1$") {
+		t.Errorf("unexpected hash format %q", hash)
+	}
+
+	if match, needsRehash := hasher.Verify("correct-horse-battery", hash); !match || needsRehash {
+		t.Errorf("expected a current match, got match=%v needsRehash=%v", match, needsRehash)
+	}
+	if match, _ := hasher.Verify("wrong-horse-battery", hash); match {
+		t.Error("expected a wrong password not to match")
+	}
+
+	stronger, err := NewPasswordHasher(PasswordHashConfig{Argon2Memory: 64, Argon2Iterations: 3})
+	if err != nil {
+		t.Fatalf("failed to create hasher: %v", err)
+	}
+	if match, needsRehash := stronger.Verify("correct-horse-battery", hash); !match || !needsRehash {
+		t.Errorf("expected weaker parameters to need a rehash, got match=%v needsRehash=%v", match, needsRehash)
+	}
+}
+
+func TestPasswordHasherBcrypt(t *testing.T) {
+	old, err := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	bcryptHasher, err := NewPasswordHasher(PasswordHashConfig{Algorithm: PasswordHashBcrypt, BcryptCost: bcrypt.MinCost + 1})
+	if err != nil {
+		t.Fatalf("failed to create hasher: %v", err)
+	}
+	if match, needsRehash := bcryptHasher.Verify("secret123", string(old)); !match || !needsRehash {
+		t.Errorf("expected a lower cost to need a rehash, got match=%v needsRehash=%v", match, needsRehash)
+	}
+
+	argonHasher, err := NewPasswordHasher(PasswordHashConfig{Argon2Memory: 64, Argon2Iterations: 1})
+	if err != nil {
+		t.Fatalf("failed to create hasher: %v", err)
+	}
+	if match, needsRehash := argonHasher.Verify("secret123", string(old)); !match || !needsRehash {
+		t.Errorf("expected bcrypt hashes to need a rehash, got match=%v needsRehash=%v", match, needsRehash)
+	}
+	if match, _ := argonHasher.Verify("secret123", "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5"); match {
+		t.Error("expected an unknown format not to match")
+	}
+}
+
+func TestNewPasswordHasherRejectsInvalidConfig(t *testing.T) {
+	for _, cfg := range []PasswordHashConfig{
+		{Algorithm: "md5"},
+		{Algorithm: PasswordHashBcrypt, BcryptCost: 40},
+		{Argon2Memory: 8, Argon2Parallelism: 4},
+		{Argon2Iterations: -1},
+	} {
+		if _, err := NewPasswordHasher(cfg); err == nil {
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestLoginUpgradesPasswordHash(t *testing.T) {
+	svc, userRepo, _ := setupAuthService(t, time.Hour)
+	ctx := context.Background()
+
+	// setupAuthService stores a bcrypt hash
+	if _, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"}); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	user, _ := userRepo.GetByID(ctx, 1)
+	if !strings.HasPrefix(user.PasswordHash, "$argon2id$") {
+		t.Fatalf("expected the hash to be upgraded to argon2id, got %q", user.PasswordHash)
+	}
+
+	if _, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"}); err != nil {
+		t.Errorf("expected login with the upgraded hash, got %v", err)
+	}
+}
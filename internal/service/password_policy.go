@@ -0,0 +1,306 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"identity/internal/model"
+	"identity/internal/repository"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultPasswordMinLength is the shortest password accepted
+	DefaultPasswordMinLength = 8
+	// passwordMaxBytes is bcrypt's limit; longer passwords would be cut off.
+	// It holds for argon2id too, so switching back to bcrypt never leaves a
+	// password unusable.
+	passwordMaxBytes = 72
+	// minPersonalTokenLength skips name parts too short to matter, like
+	// initials
+	minPersonalTokenLength = 3
+)
+
+// ErrWeakPassword matches the errors returned for passwords the policy
+// refuses, whose message gives the reason
+var ErrWeakPassword = errors.New("password does not meet the password policy")
+
+// weakPasswordError is a password refused by the policy
+type weakPasswordError struct {
+	reason string
+}
+
+func weakPassword(format string, args ...any) error {
+	return &weakPasswordError{reason: fmt.Sprintf(format, args...)}
+}
+
+func (e *weakPasswordError) Error() string {
+	return "password " + e.reason
+}
+
+func (e *weakPasswordError) Is(target error) bool {
+	return target == ErrWeakPassword
+}
+
+//go:embed breached_passwords.txt
+var bundledBreachedPasswords string
+
+// PasswordPolicyConfig configures what passwords are accepted
+type PasswordPolicyConfig struct {
+	MinLength int
+	// MinCharClasses is how many of lowercase letters, uppercase letters,
+	// digits and other characters a password must mix
+	MinCharClasses int
+	// History is how many of a user's latest passwords, the current one
+	// included, can't be reused; 0 turns the check off
+	History int
+	// Breached are refused however they score otherwise; nil uses the
+	// bundled list of common passwords
+	Breached *BreachedPasswords
+	// Hasher hashes new passwords; nil uses argon2id with the default
+	// parameters
+	Hasher PasswordHasher
+}
+
+// PasswordPolicy checks new passwords and keeps the history that prevents
+// reusing old ones. Every way of setting a password goes through it.
+type PasswordPolicy struct {
+	cfg         PasswordPolicyConfig
+	hasher      PasswordHasher
+	historyRepo repository.PasswordHistoryRepository
+	// dummyHash is verified against for unknown emails, so they take as long
+	// to fail as wrong passwords
+	dummyHash func() string
+}
+
+// NewPasswordPolicy creates a password policy
+func NewPasswordPolicy(cfg PasswordPolicyConfig, historyRepo repository.PasswordHistoryRepository) *PasswordPolicy {
+	if cfg.MinLength <= 0 {
+		cfg.MinLength = DefaultPasswordMinLength
+	}
+	if cfg.Breached == nil {
+		cfg.Breached = BundledBreachedPasswords()
+	}
+	hasher := cfg.Hasher
+	if hasher == nil {
+		// The defaults are always valid
+		hasher, _ = NewPasswordHasher(PasswordHashConfig{})
+	}
+	return &PasswordPolicy{
+		cfg:         cfg,
+		hasher:      hasher,
+		historyRepo: historyRepo,
+		dummyHash: sync.OnceValue(func() string {
+			hash, _ := hasher.Hash("not a password")
+			return hash
+		}),
+	}
+}
+
+// Validate checks a password against the rules that don't need the user's
+// history. name and email are the user's, which the password must not
+// contain.
+func (p *PasswordPolicy) Validate(password, name, email string) error {
+	if utf8.RuneCountInString(password) < p.cfg.MinLength {
+		return weakPassword("must be at least %d characters long", p.cfg.MinLength)
+	}
+	if len(password) > passwordMaxBytes {
+		return weakPassword("must be at most %d bytes long", passwordMaxBytes)
+	}
+	if classes := charClasses(password); classes < p.cfg.MinCharClasses {
+		return weakPassword("must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.cfg.MinCharClasses)
+	}
+
+	lower := strings.ToLower(password)
+	for _, token := range personalTokens(name, email) {
+		if strings.Contains(lower, token) {
+			return weakPassword("must not contain your name or email")
+		}
+	}
+
+	if p.cfg.Breached.Contains(password) {
+		return weakPassword("appears in a list of breached passwords")
+	}
+	return nil
+}
+
+// newPasswordHash validates a new password for a user and returns its
+// hash. For existing users it also refuses their recent passwords and
+// moves the one being replaced into the history; the caller saves the
+// returned hash.
+func (p *PasswordPolicy) newPasswordHash(ctx context.Context, user *model.User, password string) (string, error) {
+	if err := p.Validate(password, user.Name, user.Email); err != nil {
+		return "", err
+	}
+
+	if user.ID != 0 && p.cfg.History > 0 {
+		if err := p.checkReuse(ctx, user, password); err != nil {
+			return "", err
+		}
+	}
+
+	hashed, err := p.hasher.Hash(password)
+	if err != nil {
+		return "", err
+	}
+
+	if user.ID != 0 && p.cfg.History > 1 && user.PasswordHash != "" {
+		if err := p.historyRepo.Create(ctx, &model.PasswordHistory{UserID: user.ID, PasswordHash: user.PasswordHash}); err != nil {
+			return "", fmt.Errorf("failed to record password history: %w", err)
+		}
+		// The current password makes up the rest of History
+		if err := p.historyRepo.Prune(ctx, user.ID, p.cfg.History-1); err != nil {
+			return "", fmt.Errorf("failed to prune password history: %w", err)
+		}
+	}
+	return hashed, nil
+}
+
+// checkReuse refuses the user's current password and the previous ones
+// still in the history
+func (p *PasswordPolicy) checkReuse(ctx context.Context, user *model.User, password string) error {
+	hashes := []string{user.PasswordHash}
+	if p.cfg.History > 1 {
+		previous, err := p.historyRepo.GetRecent(ctx, user.ID, p.cfg.History-1)
+		if err != nil {
+			return fmt.Errorf("failed to get password history: %w", err)
+		}
+		for _, entry := range previous {
+			hashes = append(hashes, entry.PasswordHash)
+		}
+	}
+
+	for _, hash := range hashes {
+		if match, _ := p.hasher.Verify(password, hash); match {
+			return weakPassword("must differ from your last %d passwords", p.cfg.History)
+		}
+	}
+	return nil
+}
+
+// charClasses counts the kinds of characters in a password
+func charClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	count := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
+
+// personalTokens are the lowercased parts of a user's name and email
+// address a password must not contain
+func personalTokens(name, email string) []string {
+	var tokens []string
+	add := func(token string) {
+		if utf8.RuneCountInString(token) >= minPersonalTokenLength {
+			tokens = append(tokens, strings.ToLower(token))
+		}
+	}
+
+	for _, part := range strings.Fields(name) {
+		add(part)
+	}
+	if local, _, ok := strings.Cut(email, "@"); ok {
+		add(local)
+	}
+	return tokens
+}
+
+// BreachedPasswords is a set of passwords known from breaches, held as SHA-1
+// hashes like the Pwned Passwords downloads
+type BreachedPasswords struct {
+	hashes map[[sha1.Size]byte]struct{}
+}
+
+// BundledBreachedPasswords returns the common passwords bundled with the
+// service. The list is parsed once and shared, so it must not be modified.
+var BundledBreachedPasswords = sync.OnceValue(func() *BreachedPasswords {
+	list, err := ReadBreachedPasswords(strings.NewReader(bundledBreachedPasswords))
+	if err != nil {
+		panic(fmt.Sprintf("invalid bundled breached password list: %v", err))
+	}
+	return list
+})
+
+// LoadBreachedPasswords reads a breached password list from a file, adding
+// the bundled common passwords
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer f.Close()
+
+	list, err := ReadBreachedPasswords(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for hash := range BundledBreachedPasswords().hashes {
+		list.hashes[hash] = struct{}{}
+	}
+	return list, nil
+}
+
+// ReadBreachedPasswords reads SHA-1 hashes in hex, one per line. A ":count"
+// suffix, as in the Pwned Passwords downloads, is ignored, as are blank
+// lines and lines starting with #.
+func ReadBreachedPasswords(r io.Reader) (*BreachedPasswords, error) {
+	list := &BreachedPasswords{hashes: make(map[[sha1.Size]byte]struct{})}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text, _, _ = strings.Cut(text, ":")
+
+		var hash [sha1.Size]byte
+		if len(text) != hex.EncodedLen(sha1.Size) {
+			return nil, fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		if _, err := hex.Decode(hash[:], []byte(text)); err != nil {
+			return nil, fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		list.hashes[hash] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+	return list, nil
+}
+
+// Len returns how many passwords the list holds
+func (b *BreachedPasswords) Len() int {
+	return len(b.hashes)
+}
+
+// Contains reports whether a password is on the list
+func (b *BreachedPasswords) Contains(password string) bool {
+	_, ok := b.hashes[sha1.Sum([]byte(password))]
+	return ok
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockPasswordHistoryRepository is an in-memory PasswordHistoryRepository
+type mockPasswordHistoryRepository struct {
+	entries []model.PasswordHistory
+}
+
+func (m *mockPasswordHistoryRepository) Create(ctx context.Context, entry *model.PasswordHistory) error {
+	entry.ID = uint(len(m.entries) + 1)
+	entry.CreatedAt = time.Now()
+	m.entries = append(m.entries, *entry)
+	return nil
+}
+
+func (m *mockPasswordHistoryRepository) GetRecent(ctx context.Context, userID uint, limit int) ([]model.PasswordHistory, error) {
+	var entries []model.PasswordHistory
+	for _, entry := range m.entries {
+		if entry.UserID == userID {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID > entries[j].ID })
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (m *mockPasswordHistoryRepository) Prune(ctx context.Context, userID uint, keep int) error {
+	recent, _ := m.GetRecent(ctx, userID, keep)
+	kept := make(map[uint]bool, len(recent))
+	for _, entry := range recent {
+		kept[entry.ID] = true
+	}
+	entries := m.entries[:0]
+	for _, entry := range m.entries {
+		if entry.UserID != userID || kept[entry.ID] {
+			entries = append(entries, entry)
+		}
+	}
+	m.entries = entries
+	return nil
+}
+
+// newTestPasswordPolicy accepts passwords of 8 characters mixing two kinds,
+// and refuses the last 3. It hashes with argon2id at minimal cost.
+func newTestPasswordPolicy() *PasswordPolicy {
+	hasher, err := NewPasswordHasher(PasswordHashConfig{Argon2Memory: 64, Argon2Iterations: 1})
+	if err != nil {
+		panic(err)
+	}
+	return NewPasswordPolicy(PasswordPolicyConfig{MinCharClasses: 2, History: 3, Hasher: hasher}, &mockPasswordHistoryRepository{})
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := newTestPasswordPolicy()
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{"valid", "correct-horse-battery", false},
+		{"too short", "ab1-cd2", true},
+		{"too long for bcrypt", strings.Repeat("ab1-", 19), true},
+		{"one kind of character", "correcthorsebattery", true},
+		{"contains the name", "ada-lovelace-1815", true},
+		{"contains the email", "xx-ada.l-xx", true},
+		{"breached", "Password123", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.password, "Ada Lovelace", "ada.l@example.com")
+			if tt.wantErr && !errors.Is(err, ErrWeakPassword) {
+				t.Errorf("expected ErrWeakPassword, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("expected the password to be accepted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSetPasswordRefusesRecentPasswords(t *testing.T) {
+	svc, _, _ := setupAuthService(t, time.Hour)
+	ctx := adminContext()
+
+	// The current password is secret123
+	if err := svc.SetPassword(ctx, 1, "secret123"); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("expected the current password to be refused, got %v", err)
+	}
+
+	for _, password := range []string{"first-pass1", "second-pass2"} {
+		if err := svc.SetPassword(ctx, 1, password); err != nil {
+			t.Fatalf("set password failed: %v", err)
+		}
+	}
+	if err := svc.SetPassword(ctx, 1, "secret123"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("expected the password from two changes ago to be refused, got %v", err)
+	}
+
+	// Three changes on, the first password is out of the history
+	if err := svc.SetPassword(ctx, 1, "third-pass3"); err != nil {
+		t.Fatalf("set password failed: %v", err)
+	}
+	if err := svc.SetPassword(ctx, 1, "secret123"); err != nil {
+		t.Errorf("expected an old enough password to be accepted, got %v", err)
+	}
+}
+
+func TestRegisterEnforcesPasswordPolicy(t *testing.T) {
+	svc, _, _ := setupAuthService(t, time.Hour)
+
+	_, err := svc.Register(context.Background(), &dto.RegisterRequest{Name: "New User", Email: "new@example.com", Password: "qwerty123"})
+	if !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("expected a breached password to be refused, got %v", err)
+	}
+}
+
+func TestPasswordResetKeepsTokenForWeakPassword(t *testing.T) {
+	svc, _, _, mail, _ := setupPasswordReset(t)
+	ctx := context.Background()
+
+	if err := svc.RequestPasswordReset(ctx, "test@example.com"); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	token := receiveResetToken(t, mail, "test@example.com")
+
+	err := svc.ResetPassword(ctx, &dto.ResetPasswordRequest{Token: token, Password: "password"})
+	if !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("expected a weak password to be refused, got %v", err)
+	}
+	if err := svc.ResetPassword(ctx, &dto.ResetPasswordRequest{Token: token, Password: "new-secret"}); err != nil {
+		t.Errorf("expected the token to still work, got %v", err)
+	}
+}
+
+func TestReadBreachedPasswords(t *testing.T) {
+	list, err := ReadBreachedPasswords(strings.NewReader(`# SHA-1 of "hunter2" with a count, then another hash in lowercase
+F3BBBD66A63D4BF1747940578EC3D0103530E21D:17
+
+b9c8c1b3a6a7a9e33ba2e0b7fcf1d19e6b2a4f7e
+`))
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if list.Len() != 2 {
+		t.Errorf("expected 2 hashes, got %d", list.Len())
+	}
+	if !list.Contains("hunter2") {
+		t.Error("expected hunter2 to be on the list")
+	}
+	if list.Contains("correct-horse-battery") {
+		t.Error("expected correct-horse-battery not to be on the list")
+	}
+
+	if _, err := ReadBreachedPasswords(strings.NewReader("not-a-hash\n")); err == nil {
+		t.Error("expected an invalid line to be rejected")
+	}
+}
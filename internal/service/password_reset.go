@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"identity/internal/mailer"
+	"identity/internal/model"
+	"identity/internal/repository"
+	"identity/internal/service/dto"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultPasswordResetTTL is how long an emailed reset link works
+	DefaultPasswordResetTTL = time.Hour
+	// DefaultPasswordResetLimit is how many reset emails an address gets per
+	// passwordResetWindow
+	DefaultPasswordResetLimit = 3
+	passwordResetWindow       = time.Hour
+	// passwordResetSendTimeout bounds sending a reset email, which happens
+	// after the request has been answered
+	passwordResetSendTimeout = 30 * time.Second
+)
+
+// ErrInvalidResetToken is returned for reset tokens that are unknown,
+// expired or already used
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// PasswordResetService lets users who forgot their password set a new one
+// through a link emailed to them
+type PasswordResetService interface {
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, req *dto.ResetPasswordRequest) error
+	// Close waits for the reset links still being issued and sent in the
+	// background. Call it on shutdown, once no more requests come in.
+	Close()
+}
+
+// PasswordResetConfig configures the password reset flow
+type PasswordResetConfig struct {
+	// URL is the page that takes the new password; the emailed link is this
+	// URL with the token added as the "token" query parameter
+	URL string
+	// TTL is how long a link works
+	TTL time.Duration
+	// Limit is how many links an address gets per hour; further requests
+	// are dropped
+	Limit int
+}
+
+// passwordResetService implements PasswordResetService
+type passwordResetService struct {
+	userRepo       repository.UserRepository
+	sessionRepo    repository.SessionRepository
+	oauthTokenRepo repository.OAuthTokenRepository
+	tokenRepo      repository.PasswordResetTokenRepository
+	passwords      *PasswordPolicy
+	mailer         mailer.Mailer
+	audit          AuditLogger
+	cfg            PasswordResetConfig
+	logger         *slog.Logger
+
+	// pending counts the requests still being handled in the background
+	pending sync.WaitGroup
+}
+
+// NewPasswordResetService creates a new password reset service
+func NewPasswordResetService(
+	userRepo repository.UserRepository,
+	sessionRepo repository.SessionRepository,
+	oauthTokenRepo repository.OAuthTokenRepository,
+	tokenRepo repository.PasswordResetTokenRepository,
+	passwords *PasswordPolicy,
+	mailer mailer.Mailer,
+	audit AuditLogger,
+	cfg PasswordResetConfig,
+	logger *slog.Logger,
+) PasswordResetService {
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultPasswordResetTTL
+	}
+	if cfg.Limit <= 0 {
+		cfg.Limit = DefaultPasswordResetLimit
+	}
+	return &passwordResetService{
+		userRepo:       userRepo,
+		sessionRepo:    sessionRepo,
+		oauthTokenRepo: oauthTokenRepo,
+		tokenRepo:      tokenRepo,
+		passwords:      passwords,
+		mailer:         mailer,
+		audit:          audit,
+		cfg:            cfg,
+		logger:         logger,
+	}
+}
+
+// RequestPasswordReset emails a reset link to the user with the given
+// email. To not reveal which addresses have an account, it succeeds whether
+// or not a link is sent, and only looks the address up before answering:
+// the link is issued and sent in the background, so the response takes as
+// long either way.
+func (s *passwordResetService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.audit.Log(ctx, nil, AuditPasswordResetRequested, "user", "", map[string]any{"email": email, "sent": false, "reason": "unknown email"})
+			return nil
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		s.sendResetLink(context.WithoutCancel(ctx), user, email)
+	}()
+	return nil
+}
+
+// sendResetLink issues a reset token to a user and emails it, unless the
+// account is disabled or got its share of links lately. Failures are logged,
+// the request having been answered.
+func (s *passwordResetService) sendResetLink(ctx context.Context, user *model.User, email string) {
+	token, err := s.issueResetToken(ctx, user, email)
+	if err != nil {
+		s.logger.Error("failed to issue password reset token", "error", err, "user_id", user.ID)
+		return
+	}
+	if token == "" {
+		return
+	}
+
+	s.send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    s.resetEmailBody(user, token),
+	})
+}
+
+// issueResetToken creates a reset token for a user, returning "" if none is
+// due. The limit is enforced as the token is stored, so parallel requests
+// can't get past it together.
+func (s *passwordResetService) issueResetToken(ctx context.Context, user *model.User, email string) (string, error) {
+	target := fmt.Sprint(user.ID)
+	if !user.Enabled {
+		s.audit.Log(ctx, nil, AuditPasswordResetRequested, "user", target, map[string]any{"email": email, "sent": false, "reason": "account disabled"})
+		return "", nil
+	}
+
+	token, err := generateSessionID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate password reset token: %w", err)
+	}
+	record := &model.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashSecret(token),
+		ExpiresAt: time.Now().Add(s.cfg.TTL),
+	}
+	created, err := s.tokenRepo.CreateWithinLimit(ctx, record, time.Now().Add(-passwordResetWindow), s.cfg.Limit)
+	if err != nil {
+		return "", fmt.Errorf("failed to create password reset token: %w", err)
+	}
+	if !created {
+		s.audit.Log(ctx, nil, AuditPasswordResetRequested, "user", target, map[string]any{"email": email, "sent": false, "reason": "rate limited"})
+		return "", nil
+	}
+
+	s.audit.Log(ctx, nil, AuditPasswordResetRequested, "user", target, map[string]any{"email": email, "sent": true})
+	return token, nil
+}
+
+// Close waits for the requests still being handled in the background
+func (s *passwordResetService) Close() {
+	s.pending.Wait()
+}
+
+// ResetPassword sets a new password with an emailed token. The token is
+// used up, the user's other tokens are dropped, all of their sessions end
+// and the tokens of apps they signed in to with OpenID Connect are revoked,
+// so whoever held the old password is logged out. Two-factor
+// authentication stays as it was. A password the policy refuses leaves the
+// token unused, so the user can pick another.
+func (s *passwordResetService) ResetPassword(ctx context.Context, req *dto.ResetPasswordRequest) error {
+	tokenHash := hashSecret(req.Token)
+	token, err := s.tokenRepo.GetValid(ctx, tokenHash, time.Now())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return fmt.Errorf("failed to get password reset token: %w", err)
+	}
+
+	user, err := s.userRepo.GetByID(ctx, token.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if !user.Enabled {
+		return errors.New("user account is disabled")
+	}
+
+	hashedPassword, err := s.passwords.newPasswordHash(ctx, user, req.Password)
+	if err != nil {
+		return err
+	}
+
+	// Parallel requests may both have got this far; only one uses the token
+	if _, err := s.tokenRepo.Use(ctx, tokenHash, time.Now()); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return fmt.Errorf("failed to use password reset token: %w", err)
+	}
+	user.PasswordHash = hashedPassword
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	if err := s.sessionRepo.DeleteByUserID(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to delete user sessions: %w", err)
+	}
+	if err := s.oauthTokenRepo.RevokeUser(ctx, user.ID, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke OAuth tokens: %w", err)
+	}
+	if err := s.tokenRepo.DeleteByUserID(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to delete password reset tokens: %w", err)
+	}
+
+	actorID := user.ID
+	s.audit.Log(ctx, &actorID, AuditPasswordReset, "user", fmt.Sprint(user.ID), map[string]any{"email": user.Email})
+	return nil
+}
+
+// send delivers a reset email, logging rather than returning failures since
+// the request has been answered by then
+func (s *passwordResetService) send(ctx context.Context, msg mailer.Message) {
+	ctx, cancel := context.WithTimeout(ctx, passwordResetSendTimeout)
+	defer cancel()
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		s.logger.Error("failed to send password reset email", "error", err, "to", msg.To)
+	}
+}
+
+// resetEmailBody writes the email carrying a reset link
+func (s *passwordResetService) resetEmailBody(user *model.User, token string) string {
+	link := s.cfg.URL
+	if u, err := url.Parse(s.cfg.URL); err == nil {
+		query := u.Query()
+		query.Set("token", token)
+		u.RawQuery = query.Encode()
+		link = u.String()
+	}
+
+	return fmt.Sprintf(`Hi %s,
+
+Someone asked to reset the password of your account. Open this link to choose a new one:
+
+%s
+
+The link works once, within %s. If you didn't ask for it, you can ignore this email: your password stays as it is.
+`, user.Name, link, formatDuration(s.cfg.TTL))
+}
+
+// formatDuration words a reset link's lifetime, e.g. "1 hour" or
+// "30 minutes"
+func formatDuration(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		if hours := int(d / time.Hour); hours != 1 {
+			return fmt.Sprintf("%d hours", hours)
+		}
+		return "1 hour"
+	}
+	if minutes := int(d.Round(time.Minute) / time.Minute); minutes != 1 {
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+	return "1 minute"
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"identity/internal/mailer"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"log/slog"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// mockPasswordResetTokenRepository is an in-memory
+// PasswordResetTokenRepository
+type mockPasswordResetTokenRepository struct {
+	mu     sync.Mutex
+	tokens []*model.PasswordResetToken
+	// gate, when set, holds CreateWithinLimit until it is closed
+	gate chan struct{}
+}
+
+func (m *mockPasswordResetTokenRepository) CreateWithinLimit(ctx context.Context, token *model.PasswordResetToken, since time.Time, limit int) (bool, error) {
+	if m.gate != nil {
+		<-m.gate
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, issued := range m.tokens {
+		if issued.UserID == token.UserID && !issued.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	if count >= limit {
+		return false, nil
+	}
+	token.ID = uint(len(m.tokens) + 1)
+	token.CreatedAt = time.Now()
+	m.tokens = append(m.tokens, token)
+	return true, nil
+}
+
+func (m *mockPasswordResetTokenRepository) GetValid(ctx context.Context, tokenHash string, at time.Time) (*model.PasswordResetToken, error) {
+	for _, token := range m.tokens {
+		if token.TokenHash == tokenHash && token.UsedAt == nil && token.ExpiresAt.After(at) {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *mockPasswordResetTokenRepository) Use(ctx context.Context, tokenHash string, usedAt time.Time) (*model.PasswordResetToken, error) {
+	for _, token := range m.tokens {
+		if token.TokenHash == tokenHash && token.UsedAt == nil && token.ExpiresAt.After(usedAt) {
+			token.UsedAt = &usedAt
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *mockPasswordResetTokenRepository) DeleteByUserID(ctx context.Context, userID uint) error {
+	kept := m.tokens[:0]
+	for _, token := range m.tokens {
+		if token.UserID != userID {
+			kept = append(kept, token)
+		}
+	}
+	m.tokens = kept
+	return nil
+}
+
+// mockMailer hands sent messages to the test; sends happen in the background
+type mockMailer struct {
+	sent chan mailer.Message
+}
+
+func (m *mockMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent <- msg
+	return nil
+}
+
+var resetLinkToken = regexp.MustCompile(`\?token=([0-9a-f]{64})`)
+
+// receiveResetToken waits for a reset email and returns the token in its link
+func receiveResetToken(t *testing.T, mail *mockMailer, to string) string {
+	t.Helper()
+	select {
+	case msg := <-mail.sent:
+		if msg.To != to {
+			t.Fatalf("expected email to %s, got %s", to, msg.To)
+		}
+		match := resetLinkToken.FindStringSubmatch(msg.Body)
+		if match == nil {
+			t.Fatalf("expected a reset link in %q", msg.Body)
+		}
+		return match[1]
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a reset email")
+		return ""
+	}
+}
+
+func setupPasswordReset(t *testing.T) (PasswordResetService, AuthService, *mockPasswordResetTokenRepository, *mockMailer, *mockUserRepository) {
+	t.Helper()
+	auth, userRepo, sessionRepo := setupAuthService(t, 720*time.Hour)
+	tokenRepo := &mockPasswordResetTokenRepository{}
+	mail := &mockMailer{sent: make(chan mailer.Message, 10)}
+	svc := NewPasswordResetService(userRepo, sessionRepo, newMockOAuthTokenRepository(), tokenRepo, newTestPasswordPolicy(), mail, newNoopAudit(), PasswordResetConfig{
+		URL: "https://app.example.com/reset-password",
+	}, slog.Default())
+	return svc, auth, tokenRepo, mail, userRepo
+}
+
+func TestPasswordReset(t *testing.T) {
+	svc, auth, tokenRepo, mail, _ := setupPasswordReset(t)
+	ctx := context.Background()
+
+	session, err := auth.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	if err := svc.RequestPasswordReset(ctx, "test@example.com"); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	token := receiveResetToken(t, mail, "test@example.com")
+	if tokenRepo.tokens[0].TokenHash == token {
+		t.Fatal("expected only the token's hash to be stored")
+	}
+
+	if err := svc.ResetPassword(ctx, &dto.ResetPasswordRequest{Token: token, Password: "new-secret"}); err != nil {
+		t.Fatalf("reset failed: %v", err)
+	}
+
+	if _, err := auth.ValidateSession(ctx, session.SessionID); err == nil {
+		t.Error("expected existing sessions to end")
+	}
+	if _, err := auth.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"}); err == nil {
+		t.Error("expected the old password to stop working")
+	}
+	if _, err := auth.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "new-secret"}); err != nil {
+		t.Errorf("expected the new password to work: %v", err)
+	}
+
+	err = svc.ResetPassword(ctx, &dto.ResetPasswordRequest{Token: token, Password: "another-secret"})
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("expected a used token to be rejected, got %v", err)
+	}
+}
+
+func TestPasswordResetDoesNotRevealAccounts(t *testing.T) {
+	svc, _, tokenRepo, mail, userRepo := setupPasswordReset(t)
+	ctx := context.Background()
+
+	if err := svc.RequestPasswordReset(ctx, "nobody@example.com"); err != nil {
+		t.Errorf("expected unknown emails to succeed like known ones, got %v", err)
+	}
+
+	user, _ := userRepo.GetByID(ctx, 1)
+	user.Enabled = false
+	if err := svc.RequestPasswordReset(ctx, "test@example.com"); err != nil {
+		t.Errorf("expected disabled accounts to succeed like others, got %v", err)
+	}
+	svc.Close()
+
+	if len(tokenRepo.tokens) != 0 || len(mail.sent) != 0 {
+		t.Error("expected no reset link for unknown or disabled accounts")
+	}
+}
+
+// Known addresses are answered before the link is issued, so the response
+// takes no longer than for unknown ones
+func TestPasswordResetAnswersBeforeIssuing(t *testing.T) {
+	svc, _, tokenRepo, mail, _ := setupPasswordReset(t)
+	tokenRepo.gate = make(chan struct{})
+
+	answered := make(chan error, 1)
+	go func() { answered <- svc.RequestPasswordReset(context.Background(), "test@example.com") }()
+	select {
+	case err := <-answered:
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the request to be answered before the link is issued")
+	}
+
+	close(tokenRepo.gate)
+	receiveResetToken(t, mail, "test@example.com")
+}
+
+func TestPasswordResetRateLimit(t *testing.T) {
+	svc, _, tokenRepo, mail, _ := setupPasswordReset(t)
+	ctx := context.Background()
+
+	for i := 0; i < DefaultPasswordResetLimit+2; i++ {
+		if err := svc.RequestPasswordReset(ctx, "test@example.com"); err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+	}
+	svc.Close()
+	for i := 0; i < DefaultPasswordResetLimit; i++ {
+		receiveResetToken(t, mail, "test@example.com")
+	}
+
+	if len(tokenRepo.tokens) != DefaultPasswordResetLimit {
+		t.Errorf("expected %d reset links, got %d", DefaultPasswordResetLimit, len(tokenRepo.tokens))
+	}
+
+	// Requests older than the window no longer count
+	for _, token := range tokenRepo.tokens {
+		token.CreatedAt = time.Now().Add(-passwordResetWindow - time.Minute)
+	}
+	if err := svc.RequestPasswordReset(ctx, "test@example.com"); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	receiveResetToken(t, mail, "test@example.com")
+}
+
+func TestPasswordResetExpiredToken(t *testing.T) {
+	svc, _, tokenRepo, mail, _ := setupPasswordReset(t)
+	ctx := context.Background()
+
+	if err := svc.RequestPasswordReset(ctx, "test@example.com"); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	token := receiveResetToken(t, mail, "test@example.com")
+	tokenRepo.tokens[0].ExpiresAt = time.Now().Add(-time.Second)
+
+	err := svc.ResetPassword(ctx, &dto.ResetPasswordRequest{Token: token, Password: "new-secret"})
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("expected an expired token to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// secretBoxPrefix marks values sealed by a SecretBox, and the format they
+// are in, should it ever change
+const secretBoxPrefix = "v1:"
+
+// ErrSecretNotSealed is returned when opening a value that wasn't sealed
+var ErrSecretNotSealed = errors.New("secret is not encrypted")
+
+// SecretBox encrypts secrets kept in the database, like TOTP secrets, with
+// AES-256-GCM. Each value is sealed for what it belongs to (e.g. a user),
+// so it can't be copied over to something else and still open.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox creates a SecretBox with a 32-byte key
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts plaintext for owner, returning it as text to store
+func (b *SecretBox) Seal(plaintext, owner string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), []byte(owner))
+	return secretBoxPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value Seal returned for the same owner
+func (b *SecretBox) Open(value, owner string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, secretBoxPrefix)
+	if !ok {
+		return "", ErrSecretNotSealed
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", errors.New("malformed encrypted secret")
+	}
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, []byte(owner))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// IsSealed reports whether value was sealed by a SecretBox, as opposed to
+// stored in the clear before secrets were encrypted
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, secretBoxPrefix)
+}
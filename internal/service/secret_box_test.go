@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"identity/internal/model"
+	"testing"
+)
+
+func TestSecretBox(t *testing.T) {
+	sealed, err := testSecrets.Seal("JBSWY3DPEHPK3PXP", "user:1:totp")
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if !IsSealed(sealed) {
+		t.Fatalf("Seal() = %q, want it marked as sealed", sealed)
+	}
+	if again, _ := testSecrets.Seal("JBSWY3DPEHPK3PXP", "user:1:totp"); again == sealed {
+		t.Error("Seal() twice gave the same value, want a new nonce each time")
+	}
+
+	if opened, err := testSecrets.Open(sealed, "user:1:totp"); err != nil || opened != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("Open() = %q, %v, want the plaintext", opened, err)
+	}
+	// Copied over to another user, it doesn't open
+	if _, err := testSecrets.Open(sealed, "user:2:totp"); err == nil {
+		t.Error("Open() for another owner succeeded")
+	}
+	other, _ := NewSecretBox(make([]byte, 32))
+	if _, err := other.Open(sealed, "user:1:totp"); err == nil {
+		t.Error("Open() with another key succeeded")
+	}
+	if _, err := testSecrets.Open("JBSWY3DPEHPK3PXP", "user:1:totp"); !errors.Is(err, ErrSecretNotSealed) {
+		t.Errorf("Open(plaintext) error = %v, want ErrSecretNotSealed", err)
+	}
+
+	if _, err := NewSecretBox(make([]byte, 16)); err == nil {
+		t.Error("NewSecretBox() with a 16-byte key succeeded")
+	}
+}
+
+func TestEncryptTOTPSecrets(t *testing.T) {
+	ctx := context.Background()
+	userRepo := newMockUserRepository()
+	legacy := &model.User{Email: "legacy@example.com", TOTPSecret: "JBSWY3DPEHPK3PXP", TOTPEnabled: true}
+	_ = userRepo.Create(ctx, legacy)
+	_ = userRepo.Create(ctx, &model.User{Email: "none@example.com"})
+
+	encrypted, err := EncryptTOTPSecrets(ctx, userRepo, testSecrets)
+	if err != nil || encrypted != 1 {
+		t.Fatalf("EncryptTOTPSecrets() = %d, %v, want 1 secret encrypted", encrypted, err)
+	}
+	if secret, err := testSecrets.Open(legacy.TOTPSecret, totpSecretOwner(legacy.ID)); err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("stored secret opens to %q, %v, want the original", secret, err)
+	}
+
+	// Running again finds nothing left to do
+	if encrypted, err := EncryptTOTPSecrets(ctx, userRepo, testSecrets); err != nil || encrypted != 0 {
+		t.Errorf("EncryptTOTPSecrets() again = %d, %v, want 0", encrypted, err)
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"identity/internal/repository"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSessionReaperBatchSize is how many sessions one delete statement
+// removes when no batch size is configured
+const DefaultSessionReaperBatchSize = 1000
+
+// SessionReaperStats are the reaper's counters since startup. Only the
+// replica that got to run a purge counts its rows.
+type SessionReaperStats struct {
+	Runs       int64      `json:"runs"`
+	Skipped    int64      `json:"skipped"`
+	Failures   int64      `json:"failures"`
+	Purged     int64      `json:"purged"`
+	LastPurged int64      `json:"last_purged"`
+	LastRunAt  *time.Time `json:"last_run_at,omitempty"`
+}
+
+// SessionReaper permanently deletes sessions that expired or were ended
+// (logouts only soft delete them), so the sessions table doesn't grow
+// forever, and revokes the OpenID Connect tokens signed in with them
+type SessionReaper struct {
+	repo      repository.SessionRepository
+	tokenRepo repository.OAuthTokenRepository
+	batchSize int
+	logger    *slog.Logger
+
+	runs       atomic.Int64
+	skipped    atomic.Int64
+	failures   atomic.Int64
+	purged     atomic.Int64
+	lastPurged atomic.Int64
+	lastRunAt  atomic.Int64
+}
+
+// NewSessionReaper creates a session reaper deleting batchSize sessions per
+// statement
+func NewSessionReaper(repo repository.SessionRepository, tokenRepo repository.OAuthTokenRepository, batchSize int, logger *slog.Logger) *SessionReaper {
+	if batchSize <= 0 {
+		batchSize = DefaultSessionReaperBatchSize
+	}
+	return &SessionReaper{repo: repo, tokenRepo: tokenRepo, batchSize: batchSize, logger: logger}
+}
+
+// Purge deletes every session that has expired or been soft deleted, in
+// batches so no statement holds many row locks for long, and returns how
+// many it deleted. The tokens of those sessions are revoked first. Only one
+// replica purges at a time; on the others it does nothing.
+func (r *SessionReaper) Purge(ctx context.Context) (int64, error) {
+	var purged int64
+	ran, err := r.repo.RunExclusive(ctx, func(ctx context.Context) error {
+		now := time.Now()
+		if _, err := r.tokenRepo.RevokeEndedSessions(ctx, now); err != nil {
+			return fmt.Errorf("failed to revoke tokens of ended sessions: %w", err)
+		}
+		for ctx.Err() == nil {
+			n, err := r.repo.PurgeExpired(ctx, now, r.batchSize)
+			purged += n
+			if err != nil {
+				return fmt.Errorf("failed to purge sessions: %w", err)
+			}
+			if n < int64(r.batchSize) {
+				break
+			}
+		}
+		return ctx.Err()
+	})
+
+	switch {
+	case err != nil:
+		r.failures.Add(1)
+	case !ran:
+		r.skipped.Add(1)
+	}
+	if ran {
+		r.runs.Add(1)
+		r.purged.Add(purged)
+		r.lastPurged.Store(purged)
+		r.lastRunAt.Store(time.Now().Unix())
+	}
+	return purged, err
+}
+
+// Run purges dead sessions every interval until ctx is done. Every replica
+// runs it; an advisory lock keeps them from purging concurrently.
+func (r *SessionReaper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := r.Purge(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Error("failed to purge sessions", "error", err)
+		} else if purged > 0 {
+			r.logger.Info("purged dead sessions", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stats returns the reaper's counters
+func (r *SessionReaper) Stats() SessionReaperStats {
+	stats := SessionReaperStats{
+		Runs:       r.runs.Load(),
+		Skipped:    r.skipped.Load(),
+		Failures:   r.failures.Load(),
+		Purged:     r.purged.Load(),
+		LastPurged: r.lastPurged.Load(),
+	}
+	if unix := r.lastRunAt.Load(); unix != 0 {
+		lastRunAt := time.Unix(unix, 0).UTC()
+		stats.LastRunAt = &lastRunAt
+	}
+	return stats
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"identity/internal/model"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestSessionReaperPurge(t *testing.T) {
+	sessionRepo := newMockSessionRepository(newMockUserRepository())
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		_ = sessionRepo.Create(ctx, &model.Session{ID: fmt.Sprintf("expired-%d", i), UserID: 1, ExpiresAt: time.Now().Add(-time.Minute)})
+	}
+	_ = sessionRepo.Create(ctx, &model.Session{ID: "live", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)})
+
+	tokenRepo := newMockOAuthTokenRepository()
+	tokenRepo.sessions = sessionRepo
+	for _, sessionID := range []string{"expired-0", "live"} {
+		_ = tokenRepo.Create(ctx, &model.OAuthToken{TokenHash: sessionID, SessionID: sessionID, ExpiresAt: time.Now().Add(time.Hour)})
+	}
+
+	reaper := NewSessionReaper(sessionRepo, tokenRepo, 2, slog.Default())
+	purged, err := reaper.Purge(ctx)
+	if err != nil {
+		t.Fatalf("purge failed: %v", err)
+	}
+	if purged != 5 {
+		t.Errorf("expected 5 sessions purged over several batches, got %d", purged)
+	}
+	if _, ok := sessionRepo.sessions["live"]; !ok || len(sessionRepo.sessions) != 1 {
+		t.Errorf("expected only the live session to remain, got %d sessions", len(sessionRepo.sessions))
+	}
+
+	if tokenRepo.tokens["expired-0"].RevokedAt == nil {
+		t.Error("expected the purged session's token to be revoked")
+	}
+	if tokenRepo.tokens["live"].RevokedAt != nil {
+		t.Error("expected the live session's token to stay valid")
+	}
+
+	stats := reaper.Stats()
+	if stats.Runs != 1 || stats.Purged != 5 || stats.LastPurged != 5 || stats.LastRunAt == nil {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"identity/internal/service/dto"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultSessionTokenTTL is how long a session token is valid
+const DefaultSessionTokenTTL = 5 * time.Minute
+
+// ErrSessionRejected is returned when a session can't be exchanged for a
+// token, wrapping why
+var ErrSessionRejected = errors.New("session rejected")
+
+// SessionTokenService exchanges sessions for short-lived signed access
+// tokens, which other services verify against the published keys instead of
+// validating the session with identity on every request
+type SessionTokenService interface {
+	Exchange(ctx context.Context, sessionID string) (*dto.SessionTokenResponse, error)
+	JWKS(ctx context.Context) (*dto.JWKS, error)
+}
+
+// SessionTokenConfig configures session tokens
+type SessionTokenConfig struct {
+	// Issuer is the "iss" claim verifiers check
+	Issuer string
+	// TTL is how long a token is valid; it never outlives its session
+	TTL time.Duration
+}
+
+// sessionTokenService implements SessionTokenService
+type sessionTokenService struct {
+	authService AuthService
+	flags       FeatureFlagService
+	keys        *SigningKeys
+	cfg         SessionTokenConfig
+}
+
+// NewSessionTokenService creates a new session token service
+func NewSessionTokenService(authService AuthService, flags FeatureFlagService, keys *SigningKeys, cfg SessionTokenConfig) (SessionTokenService, error) {
+	if cfg.Issuer == "" {
+		return nil, errors.New("session token issuer is required")
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultSessionTokenTTL
+	}
+	if cfg.TTL > keys.rotation {
+		return nil, fmt.Errorf("session tokens (%s) must not outlive a signing key rotation (%s)", cfg.TTL, keys.rotation)
+	}
+
+	return &sessionTokenService{
+		authService: authService,
+		flags:       flags,
+		keys:        keys,
+		cfg:         cfg,
+	}, nil
+}
+
+// Exchange validates a session, sliding its expiry like any other use, and
+// issues a token for its user. Clients exchange the session again for a new
+// token when theirs expires.
+func (s *sessionTokenService) Exchange(ctx context.Context, sessionID string) (*dto.SessionTokenResponse, error) {
+	session, err := s.authService.GetSession(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSessionRejected, err)
+	}
+	user := &session.User
+
+	evaluations, err := s.flags.EvaluateFeatureFlags(ctx, nil, &dto.EvaluationContext{UserID: &user.ID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate feature flags: %w", err)
+	}
+	flags := make(map[string]bool, len(evaluations))
+	var variants map[string]string
+	for key, evaluation := range evaluations {
+		flags[key] = evaluation.Enabled
+		if evaluation.Variant != "" {
+			if variants == nil {
+				variants = make(map[string]string)
+			}
+			variants[key] = evaluation.Variant
+		}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.cfg.TTL)
+	if session.ExpiresAt.Before(expiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+	token, err := s.keys.SignTyped(ctx, dto.SessionTokenType, &dto.SessionTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.cfg.Issuer,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		Name:         user.Name,
+		Roles:        []string{string(user.Role)},
+		Flags:        flags,
+		FlagVariants: variants,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign session token: %w", err)
+	}
+
+	return &dto.SessionTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(expiresAt.Sub(now).Seconds()),
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+// JWKS returns the keys session tokens are verified with
+func (s *sessionTokenService) JWKS(ctx context.Context) (*dto.JWKS, error) {
+	return s.keys.JWKS(ctx)
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"identity/pkg/api"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSessionTokenExchange(t *testing.T) {
+	// Sessions idle out after two minutes, before tokens would
+	auth, userRepo, _ := setupAuthService(t, 2*time.Minute)
+	ctx := context.Background()
+	user, _ := userRepo.GetByID(ctx, 1)
+	user.Role = model.RoleFlagEditor
+	_ = userRepo.Update(ctx, user)
+
+	featureFlagRepo := newMockFeatureFlagRepository()
+	userFFRepo := newMockUserFeatureFlagRepository()
+	flags := NewFeatureFlagService(featureFlagRepo, userFFRepo, userRepo, newMockScheduledFlagChangeRepository(), nil, nil, newNoopAudit())
+	beta := &model.FeatureFlag{Key: "beta", VariantType: api.VariantTypeBoolean}
+	_ = featureFlagRepo.Create(ctx, beta)
+	_ = featureFlagRepo.Create(ctx, &model.FeatureFlag{Key: "dark-mode", VariantType: api.VariantTypeBoolean})
+	_ = userFFRepo.AssignFeatureFlagToUser(ctx, user.ID, beta.ID, "")
+
+	svc, err := NewSessionTokenService(auth, flags, NewSigningKeys(&mockSigningKeyRepository{}, testSecrets, 0), SessionTokenConfig{Issuer: "identity"})
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	login, err := auth.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	resp, err := svc.Exchange(ctx, login.SessionID)
+	if err != nil {
+		t.Fatalf("exchange failed: %v", err)
+	}
+	if resp.TokenType != "Bearer" || resp.ExpiresIn <= 0 || resp.ExpiresIn > 120 {
+		t.Errorf("expected a bearer token ending with the session, got %+v", resp)
+	}
+
+	// The token verifies against the published keys
+	jwks, err := svc.JWKS(ctx)
+	if err != nil {
+		t.Fatalf("JWKS failed: %v", err)
+	}
+	claims := &dto.SessionTokenClaims{}
+	token, err := jwt.ParseWithClaims(resp.AccessToken, claims, func(token *jwt.Token) (any, error) {
+		for _, key := range jwks.Keys {
+			if key.Kid == token.Header["kid"] {
+				n, _ := base64.RawURLEncoding.DecodeString(key.N)
+				e, _ := base64.RawURLEncoding.DecodeString(key.E)
+				return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+			}
+		}
+		return nil, errors.New("unknown key")
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer("identity"), jwt.WithExpirationRequired())
+	if err != nil {
+		t.Fatalf("token doesn't verify: %v", err)
+	}
+	if token.Header["typ"] != dto.SessionTokenType {
+		t.Errorf("expected typ %q, got %v", dto.SessionTokenType, token.Header["typ"])
+	}
+	if id, _ := claims.UserID(); id != user.ID || claims.Name != "Test User" || len(claims.Roles) != 1 || claims.Roles[0] != string(model.RoleFlagEditor) {
+		t.Errorf("unexpected user claims %+v", claims)
+	}
+	if len(claims.Flags) != 2 || !claims.Flags["beta"] || claims.Flags["dark-mode"] {
+		t.Errorf("expected beta on and dark-mode off, got %v", claims.Flags)
+	}
+
+	// Ended sessions can't be exchanged
+	if err := auth.Logout(ctx, login.SessionID); err != nil {
+		t.Fatalf("logout failed: %v", err)
+	}
+	if _, err := svc.Exchange(ctx, login.SessionID); !errors.Is(err, ErrSessionRejected) {
+		t.Errorf("expected ErrSessionRejected after logout, got %v", err)
+	}
+}
+
+func TestSessionTokenTTLBoundedByKeyRotation(t *testing.T) {
+	auth, _, _ := setupAuthService(t, time.Hour)
+	flags, _, _ := setupFeatureFlagService(t)
+	keys := NewSigningKeys(&mockSigningKeyRepository{}, testSecrets, time.Hour)
+
+	if _, err := NewSessionTokenService(auth, flags, keys, SessionTokenConfig{Issuer: "identity", TTL: 2 * time.Hour}); err == nil {
+		t.Error("expected tokens outliving a key rotation to be rejected")
+	}
+	if _, err := NewSessionTokenService(auth, flags, keys, SessionTokenConfig{TTL: time.Minute}); err == nil {
+		t.Error("expected a missing issuer to be rejected")
+	}
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"strings"
+	"time"
+)
+
+const (
+	// lastSeenThreshold is how stale a session's last-seen time may get
+	// before a validation writes it, so busy sessions don't write on every
+	// request
+	lastSeenThreshold = 5 * time.Minute
+	// maxUserAgentLength matches the sessions.user_agent column
+	maxUserAgentLength = 512
+	// sessionHandleLength is how many hex characters of the stored token
+	// hash identify a session in listings
+	sessionHandleLength = 32
+)
+
+// SessionTimeouts end sessions independently of the sliding session
+// duration
+type SessionTimeouts struct {
+	// MaxLifetime ends a session this long after login, however active it
+	// is; 0 sets no limit
+	MaxLifetime time.Duration
+	// IdleTimeout ends a session not used for this long. It narrows the
+	// sliding session duration, which is the idle limit when this is 0 or
+	// longer.
+	IdleTimeout time.Duration
+	// Roles override either timeout for users of a role
+	Roles map[model.Role]RoleSessionTimeouts
+}
+
+// RoleSessionTimeouts override the session timeouts for one role; zero
+// fields keep the global ones
+type RoleSessionTimeouts struct {
+	MaxLifetime time.Duration
+	IdleTimeout time.Duration
+}
+
+// forRole returns the timeouts that apply to users of a role
+func (t SessionTimeouts) forRole(role model.Role) (maxLifetime, idleTimeout time.Duration) {
+	maxLifetime, idleTimeout = t.MaxLifetime, t.IdleTimeout
+	if override, ok := t.Roles[role]; ok {
+		if override.MaxLifetime > 0 {
+			maxLifetime = override.MaxLifetime
+		}
+		if override.IdleTimeout > 0 {
+			idleTimeout = override.IdleTimeout
+		}
+	}
+	return maxLifetime, idleTimeout
+}
+
+// sessionWindow is how long a session of a role lasts unused
+func (s *authService) sessionWindow(role model.Role) time.Duration {
+	_, idleTimeout := s.timeouts.forRole(role)
+	if idleTimeout > 0 && idleTimeout < s.sessionDuration {
+		return idleTimeout
+	}
+	return s.sessionDuration
+}
+
+// absoluteExpiry is when a session of a role created at createdAt ends
+// however active it is, or nil without a maximum lifetime
+func (s *authService) absoluteExpiry(role model.Role, createdAt time.Time) *time.Time {
+	maxLifetime, _ := s.timeouts.forRole(role)
+	if maxLifetime <= 0 {
+		return nil
+	}
+	expiry := createdAt.Add(maxLifetime)
+	return &expiry
+}
+
+// sessionExpiry is when a session of a role created at createdAt and last
+// used now expires: one window after now, but no later than its absolute
+// expiry. Sessions store it as ExpiresAt.
+func (s *authService) sessionExpiry(role model.Role, createdAt, now time.Time) time.Time {
+	expiry := now.Add(s.sessionWindow(role))
+	if absolute := s.absoluteExpiry(role, createdAt); absolute != nil && absolute.Before(expiry) {
+		return *absolute
+	}
+	return expiry
+}
+
+// GetSessions lists the logged-in user's active sessions, marking the one
+// currentSessionID belongs to
+func (s *authService) GetSessions(ctx context.Context, userID uint, currentSessionID string) ([]dto.SessionResponse, error) {
+	sessions, err := s.sessionRepo.GetActiveByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+
+	responses := make([]dto.SessionResponse, len(sessions))
+	for i := range sessions {
+		responses[i] = *toSessionResponse(&sessions[i], sessions[i].ID == hashSecret(currentSessionID))
+	}
+	return responses, nil
+}
+
+// RevokeSession ends one of the logged-in user's sessions, given its ID from
+// GetSessions. Revoking the current session logs the user out.
+func (s *authService) RevokeSession(ctx context.Context, userID uint, handle string) error {
+	if err := s.deleteSession(ctx, userID, handle); err != nil {
+		return err
+	}
+	s.audit.Log(ctx, &userID, AuditSessionRevoked, "user", fmt.Sprint(userID), map[string]any{"session": handle})
+
+	return nil
+}
+
+// GetUserSessions lists the active sessions of any user (admin action)
+func (s *authService) GetUserSessions(ctx context.Context, userID uint) ([]dto.SessionResponse, error) {
+	if err := authorize(ctx, PermUsersRead); err != nil {
+		return nil, err
+	}
+	if _, err := s.getUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return s.GetSessions(ctx, userID, "")
+}
+
+// RevokeUserSession ends one session of any user, e.g. one on a lost device
+// (admin action)
+func (s *authService) RevokeUserSession(ctx context.Context, userID uint, handle string) error {
+	if err := authorize(ctx, PermUsersSecurity); err != nil {
+		return err
+	}
+
+	if err := s.deleteSession(ctx, userID, handle); err != nil {
+		return err
+	}
+	s.audit.Log(ctx, nil, AuditSessionRevoked, "user", fmt.Sprint(userID), map[string]any{"session": handle, "revoked": true})
+
+	return nil
+}
+
+// deleteSession deletes the session of a user with the given handle and
+// revokes the OAuth tokens of apps the user signed in to with it
+func (s *authService) deleteSession(ctx context.Context, userID uint, handle string) error {
+	sessions, err := s.sessionRepo.GetActiveByUserID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get sessions: %w", err)
+	}
+
+	for _, session := range sessions {
+		if sessionHandle(session.ID) == handle {
+			if err := s.sessionRepo.Delete(ctx, session.ID); err != nil {
+				return fmt.Errorf("failed to delete session: %w", err)
+			}
+			if err := s.oauthTokenRepo.RevokeSession(ctx, session.ID, time.Now()); err != nil {
+				return fmt.Errorf("failed to revoke OAuth tokens: %w", err)
+			}
+			return nil
+		}
+	}
+	return errors.New("session not found")
+}
+
+// newSession builds a session for a user, recording the client it is
+// created for
+func (s *authService) newSession(ctx context.Context, user *model.User, sessionID string) *model.Session {
+	now := time.Now()
+	userAgent := UserAgentFromContext(ctx)
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+
+	return &model.Session{
+		ID:         hashSecret(sessionID),
+		UserID:     user.ID,
+		CreatedAt:  now,
+		ExpiresAt:  s.sessionExpiry(user.Role, now, now),
+		IPAddress:  ClientIPFromContext(ctx),
+		UserAgent:  userAgent,
+		Device:     deviceLabel(userAgent),
+		LastSeenAt: &now,
+	}
+}
+
+// sessionHandle identifies a session in listings by a prefix of its stored
+// ID, which is already a hash of its token
+func sessionHandle(id string) string {
+	return id[:min(len(id), sessionHandleLength)]
+}
+
+func toSessionResponse(session *model.Session, current bool) *dto.SessionResponse {
+	return &dto.SessionResponse{
+		ID:         sessionHandle(session.ID),
+		Device:     session.Device,
+		IPAddress:  session.IPAddress,
+		UserAgent:  session.UserAgent,
+		Current:    current,
+		CreatedAt:  session.CreatedAt,
+		LastSeenAt: session.LastSeenAt,
+		ExpiresAt:  session.ExpiresAt,
+	}
+}
+
+// userAgentBrowsers and userAgentPlatforms map User-Agent tokens to names,
+// checked in order: Edge and Opera also claim to be Chrome, and Chrome to be
+// Safari, and Android to be Linux
+var (
+	userAgentBrowsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"CriOS/", "Chrome"},
+		{"FxiOS/", "Firefox"},
+		{"Safari/", "Safari"},
+	}
+	userAgentPlatforms = []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// deviceLabel turns a User-Agent into a short label like "Firefox on
+// macOS". Clients that aren't browsers are named by their product token,
+// e.g. "curl".
+func deviceLabel(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	var browser, platform string
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, p := range userAgentPlatforms {
+		if strings.Contains(userAgent, p.token) {
+			platform = p.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	product, _, _ := strings.Cut(userAgent, "/")
+	product, _, _ = strings.Cut(product, " ")
+	if len(product) > 100 {
+		product = strings.ToValidUTF8(product[:100], "")
+	}
+	return product
+}
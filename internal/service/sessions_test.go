@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"testing"
+	"time"
+)
+
+const firefoxUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.1; rv:120.0) Gecko/20100101 Firefox/120.0"
+
+func TestLoginRecordsSessionDetails(t *testing.T) {
+	svc, _, sessionRepo := setupAuthService(t, time.Hour)
+	ctx := WithUserAgent(WithClientIP(context.Background(), "203.0.113.7"), firefoxUserAgent)
+
+	resp, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	session := sessionRepo.sessions[hashSecret(resp.SessionID)]
+	if session.IPAddress != "203.0.113.7" || session.UserAgent != firefoxUserAgent {
+		t.Errorf("expected the client to be recorded, got %q and %q", session.IPAddress, session.UserAgent)
+	}
+	if session.Device != "Firefox on macOS" {
+		t.Errorf("expected device Firefox on macOS, got %q", session.Device)
+	}
+	if session.LastSeenAt == nil {
+		t.Error("expected the last-seen time to be set")
+	}
+}
+
+func TestSessionTokensStoredHashed(t *testing.T) {
+	svc, _, sessionRepo := setupAuthService(t, time.Hour)
+	ctx := context.Background()
+
+	resp, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if _, ok := sessionRepo.sessions[resp.SessionID]; ok {
+		t.Fatal("expected the session not to be stored under its token")
+	}
+
+	// What the database holds can't be presented as a session
+	for id := range sessionRepo.sessions {
+		if _, err := svc.ValidateSession(ctx, id); err == nil {
+			t.Error("expected the stored ID to be rejected as a session token")
+		}
+	}
+	if _, err := svc.ValidateSession(ctx, resp.SessionID); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	if err := svc.Logout(ctx, resp.SessionID); err != nil {
+		t.Fatalf("logout failed: %v", err)
+	}
+	if len(sessionRepo.sessions) != 0 {
+		t.Error("expected logout to delete the session")
+	}
+}
+
+func TestGetAndRevokeSessions(t *testing.T) {
+	svc, _, _ := setupAuthService(t, time.Hour)
+	ctx := context.Background()
+
+	current, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	other, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	sessions, err := svc.GetSessions(ctx, 1, current.SessionID)
+	if err != nil {
+		t.Fatalf("get sessions failed: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+	var otherHandle string
+	for _, session := range sessions {
+		if session.ID == current.SessionID || session.ID == other.SessionID {
+			t.Fatal("expected session listings not to expose tokens")
+		}
+		if !session.Current {
+			otherHandle = session.ID
+		}
+	}
+
+	if err := svc.RevokeSession(ctx, 2, otherHandle); err == nil || err.Error() != "session not found" {
+		t.Errorf("expected other users' sessions to be out of reach, got %v", err)
+	}
+	if err := svc.RevokeSession(ctx, 1, otherHandle); err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if _, err := svc.ValidateSession(ctx, other.SessionID); err == nil {
+		t.Error("expected the revoked session to be invalid")
+	}
+	if _, err := svc.ValidateSession(ctx, current.SessionID); err != nil {
+		t.Errorf("expected the current session to stay valid, got %v", err)
+	}
+}
+
+func TestUserSessionsRequirePermission(t *testing.T) {
+	svc, _, _ := setupAuthService(t, time.Hour)
+
+	ctx := WithActorRole(WithActor(context.Background(), 2), model.RoleViewer)
+	if _, err := svc.GetUserSessions(ctx, 1); err != nil {
+		t.Errorf("expected viewers to list sessions, got %v", err)
+	}
+	if err := svc.RevokeUserSession(ctx, 1, "0123"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden, got %v", err)
+	}
+}
+
+func TestDeviceLabel(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{firefoxUserAgent, "Firefox on macOS"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1", "Safari on iPhone"},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"curl/8.4.0", "curl"},
+		{"", "Unknown device"},
+	}
+
+	for _, tt := range tests {
+		if got := deviceLabel(tt.userAgent); got != tt.want {
+			t.Errorf("deviceLabel(%q) = %q, want %q", tt.userAgent, got, tt.want)
+		}
+	}
+}
+
+// setupSessionTimeouts creates an auth service with a 30-day sliding session
+// and the given timeouts, and one user of the given role whose password is
+// secret123
+func setupSessionTimeouts(t *testing.T, role model.Role, timeouts SessionTimeouts) (AuthService, *mockSessionRepository) {
+	t.Helper()
+	svc, userRepo, sessionRepo := setupAuthServiceWithTimeouts(t, 720*time.Hour, timeouts)
+	user, _ := userRepo.GetByID(context.Background(), 1)
+	user.Role = role
+	return svc, sessionRepo
+}
+
+func TestSessionMaxLifetime(t *testing.T) {
+	svc, sessionRepo := setupSessionTimeouts(t, model.RoleUser, SessionTimeouts{MaxLifetime: 24 * time.Hour})
+	ctx := context.Background()
+
+	resp, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	session := sessionRepo.sessions[hashSecret(resp.SessionID)]
+	if d := time.Until(session.ExpiresAt); d > 24*time.Hour {
+		t.Errorf("expected the expiry to be capped at the maximum lifetime, got %s", d)
+	}
+
+	user, err := svc.GetUserBySession(ctx, resp.SessionID)
+	if err != nil {
+		t.Fatalf("get user by session failed: %v", err)
+	}
+	if user.SessionExpiresAt == nil || user.SessionAbsoluteExpiresAt == nil || !user.SessionAbsoluteExpiresAt.Equal(session.CreatedAt.Add(24*time.Hour)) {
+		t.Errorf("expected both expiries, got %v and %v", user.SessionExpiresAt, user.SessionAbsoluteExpiresAt)
+	}
+
+	// However active, the session ends a day after login
+	session.CreatedAt = time.Now().Add(-25 * time.Hour)
+	session.ExpiresAt = time.Now().Add(time.Hour)
+	if _, err := svc.ValidateSession(ctx, resp.SessionID); err == nil {
+		t.Error("expected a session past its maximum lifetime to be rejected")
+	}
+}
+
+func TestSessionIdleTimeoutPerRole(t *testing.T) {
+	timeouts := SessionTimeouts{
+		Roles: map[model.Role]RoleSessionTimeouts{model.RoleAdmin: {IdleTimeout: 30 * time.Minute}},
+	}
+	ctx := context.Background()
+
+	svc, sessionRepo := setupSessionTimeouts(t, model.RoleUser, timeouts)
+	resp, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if d := time.Until(sessionRepo.sessions[hashSecret(resp.SessionID)].ExpiresAt); d < 719*time.Hour {
+		t.Errorf("expected other roles to keep the sliding duration, got %s", d)
+	}
+
+	svc, sessionRepo = setupSessionTimeouts(t, model.RoleAdmin, timeouts)
+	resp, err = svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	session := sessionRepo.sessions[hashSecret(resp.SessionID)]
+	if d := time.Until(session.ExpiresAt); d > 30*time.Minute {
+		t.Errorf("expected admin sessions to expire after 30 idle minutes, got %s", d)
+	}
+
+	// Use slides the expiry, even within the hour the sliding duration
+	// would wait for
+	session.ExpiresAt = time.Now().Add(time.Minute)
+	if _, err := svc.ValidateSession(ctx, resp.SessionID); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if d := time.Until(session.ExpiresAt); d < 29*time.Minute {
+		t.Errorf("expected the expiry to slide, got %s", d)
+	}
+
+	// Sessions from before the timeout was shortened are pulled in
+	session.ExpiresAt = time.Now().Add(720 * time.Hour)
+	if _, err := svc.ValidateSession(ctx, resp.SessionID); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if d := time.Until(session.ExpiresAt); d > 30*time.Minute {
+		t.Errorf("expected the expiry to be pulled in, got %s", d)
+	}
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"identity/internal/model"
+	"identity/internal/repository"
+	"identity/internal/service/dto"
+	"log/slog"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// DefaultSigningKeyRotation is how long each signing key signs tokens
+	// before the next one takes over
+	DefaultSigningKeyRotation = 30 * 24 * time.Hour
+	// signingKeyLead is how long a new key is published before it signs
+	// anything, so relying parties that cache the JWKS have it by then
+	signingKeyLead = 2 * time.Hour
+	// signingKeyCacheTTL bounds how long a replica takes to see a key
+	// another replica added
+	signingKeyCacheTTL = time.Minute
+	signingKeyBits     = 2048
+	signingKeyAlg      = "RS256"
+	// SigningKeyRotationInterval is how often RunSigningKeyRotation should
+	// run; well within signingKeyLead, so new keys are published in time
+	SigningKeyRotationInterval = 10 * time.Minute
+)
+
+// SigningKeys are the rotating RSA keys tokens are signed with, shared by
+// all replicas through the database. A key is added every rotation period
+// and starts signing signingKeyLead later; it stays published for another
+// rotation period after it is replaced, so tokens must not outlive one.
+// Private keys are stored encrypted with secrets.
+type SigningKeys struct {
+	repo     repository.SigningKeyRepository
+	secrets  *SecretBox
+	rotation time.Duration
+
+	mu       sync.Mutex
+	keys     []signingKey // newest first
+	loadedAt time.Time
+}
+
+// signingKey is a parsed model.SigningKey
+type signingKey struct {
+	id        string
+	key       *rsa.PrivateKey
+	createdAt time.Time
+}
+
+// NewSigningKeys creates the signing key set, each key signing for the
+// given rotation period
+func NewSigningKeys(repo repository.SigningKeyRepository, secrets *SecretBox, rotation time.Duration) *SigningKeys {
+	if rotation <= 0 {
+		rotation = DefaultSigningKeyRotation
+	}
+	return &SigningKeys{repo: repo, secrets: secrets, rotation: rotation}
+}
+
+// Rotate adds the next key when the signing one is due to be replaced, or
+// the first key, and deletes keys that are no longer published. Only one
+// replica rotates at a time; on the others it does nothing.
+func (k *SigningKeys) Rotate(ctx context.Context) error {
+	_, err := k.repo.RunExclusive(ctx, func(ctx context.Context) error {
+		keys, err := k.repo.GetAll(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get signing keys: %w", err)
+		}
+
+		now := time.Now()
+		if len(keys) == 0 || now.Sub(keys[0].CreatedAt) >= k.rotation-signingKeyLead {
+			key, err := newSigningKey(k.secrets)
+			if err != nil {
+				return err
+			}
+			if err := k.repo.Create(ctx, key); err != nil {
+				return fmt.Errorf("failed to create signing key: %w", err)
+			}
+		}
+
+		// A key signs for a rotation period from signingKeyLead after its
+		// creation, then stays published for one more
+		if _, err := k.repo.DeleteCreatedBefore(ctx, now.Add(-2*k.rotation-signingKeyLead)); err != nil {
+			return fmt.Errorf("failed to delete retired signing keys: %w", err)
+		}
+		return nil
+	})
+
+	k.mu.Lock()
+	k.loadedAt = time.Time{}
+	k.mu.Unlock()
+	return err
+}
+
+// Sign signs claims with the current key, naming it in the "kid" header
+func (k *SigningKeys) Sign(ctx context.Context, claims jwt.Claims) (string, error) {
+	return k.SignTyped(ctx, "JWT", claims)
+}
+
+// SignTyped is Sign with the given "typ" header
+func (k *SigningKeys) SignTyped(ctx context.Context, typ string, claims jwt.Claims) (string, error) {
+	keys, err := k.load(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		// Nothing rotated a key in yet, e.g. right after the first start
+		if err := k.Rotate(ctx); err != nil {
+			return "", err
+		}
+		if keys, err = k.load(ctx); err != nil {
+			return "", err
+		}
+		if len(keys) == 0 {
+			return "", errors.New("no signing key available")
+		}
+	}
+
+	// The newest key that has been published long enough, or failing that
+	// the oldest, which is the only one when there is just the first
+	signer := keys[len(keys)-1]
+	activeSince := time.Now().Add(-signingKeyLead)
+	for _, key := range keys {
+		if !key.createdAt.After(activeSince) {
+			signer = key
+			break
+		}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["typ"] = typ
+	token.Header["kid"] = signer.id
+	return token.SignedString(signer.key)
+}
+
+// RunSigningKeyRotation rotates the keys every interval until ctx is done
+func RunSigningKeyRotation(ctx context.Context, keys *SigningKeys, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := keys.Rotate(ctx); err != nil && ctx.Err() == nil {
+			logger.Error("failed to rotate signing keys", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// JWKS returns the public halves of the published keys
+func (k *SigningKeys) JWKS(ctx context.Context) (*dto.JWKS, error) {
+	keys, err := k.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := &dto.JWKS{Keys: make([]dto.JWK, len(keys))}
+	for i, key := range keys {
+		jwks.Keys[i] = dto.JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: signingKeyAlg,
+			Kid: key.id,
+			N:   base64.RawURLEncoding.EncodeToString(key.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.key.E)).Bytes()),
+		}
+	}
+	return jwks, nil
+}
+
+// load returns the published keys, newest first, from the database at most
+// every signingKeyCacheTTL
+func (k *SigningKeys) load(ctx context.Context) ([]signingKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if time.Since(k.loadedAt) < signingKeyCacheTTL {
+		return k.keys, nil
+	}
+
+	records, err := k.repo.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signing keys: %w", err)
+	}
+	keys := make([]signingKey, 0, len(records))
+	for _, record := range records {
+		key, err := parseSigningKey(k.secrets, &record)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", record.ID, err)
+		}
+		keys = append(keys, signingKey{id: record.ID, key: key, createdAt: record.CreatedAt})
+	}
+
+	k.keys = keys
+	k.loadedAt = time.Now()
+	return keys, nil
+}
+
+// newSigningKey generates a key with a random ID, its private key sealed
+// with secrets
+func newSigningKey(secrets *SecretBox) (*model.SigningKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, signingKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signing key: %w", err)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate signing key ID: %w", err)
+	}
+
+	keyID := hex.EncodeToString(id)
+	sealed, err := secrets.Seal(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), signingKeyOwner(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt signing key: %w", err)
+	}
+
+	return &model.SigningKey{
+		ID:         keyID,
+		Algorithm:  signingKeyAlg,
+		PrivateKey: sealed,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// parseSigningKey decrypts and decodes a key's PEM encoded PKCS #8 RSA
+// private key. Keys stored in the clear, before EncryptSigningKeys ran, are
+// read as they are.
+func parseSigningKey(secrets *SecretBox, record *model.SigningKey) (*rsa.PrivateKey, error) {
+	encoded := record.PrivateKey
+	if IsSealed(encoded) {
+		var err error
+		if encoded, err = secrets.Open(encoded, signingKeyOwner(record.ID)); err != nil {
+			return nil, err
+		}
+	}
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+	return key, nil
+}
+
+// signingKeyOwner names what a signing key's private key is encrypted for
+func signingKeyOwner(keyID string) string {
+	return "signing_key:" + keyID
+}
+
+// EncryptSigningKeys encrypts the private keys stored in the clear before
+// they were encrypted, returning how many it encrypted. It runs at startup;
+// once every key is encrypted it has nothing left to do.
+func EncryptSigningKeys(ctx context.Context, repo repository.SigningKeyRepository, secrets *SecretBox) (int, error) {
+	keys, err := repo.GetAll(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get signing keys: %w", err)
+	}
+
+	encrypted := 0
+	for _, key := range keys {
+		if IsSealed(key.PrivateKey) {
+			continue
+		}
+		sealed, err := secrets.Seal(key.PrivateKey, signingKeyOwner(key.ID))
+		if err != nil {
+			return encrypted, fmt.Errorf("failed to encrypt signing key: %w", err)
+		}
+		// Conditional on the key still being the one read, in case another
+		// replica starting up got there first
+		replaced, err := repo.ReplacePrivateKey(ctx, key.ID, key.PrivateKey, sealed)
+		if err != nil {
+			return encrypted, fmt.Errorf("failed to save signing key: %w", err)
+		}
+		if replaced {
+			encrypted++
+		}
+	}
+	return encrypted, nil
+}
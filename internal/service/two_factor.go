@@ -0,0 +1,689 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"identity/internal/model"
+	"identity/internal/repository"
+	"identity/internal/service/dto"
+	"image/png"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+// DefaultTOTPIssuer names the service in authenticator apps unless configured
+const DefaultTOTPIssuer = "Identity"
+
+const (
+	// loginChallengeTTL is how long a user has to enter their two-factor code
+	loginChallengeTTL = 5 * time.Minute
+	// maxLoginChallengeAttempts bounds the codes tried against one challenge;
+	// after that the user has to start over with their password
+	maxLoginChallengeAttempts = 5
+	// recoveryCodeCount is how many recovery codes a user is issued at a time
+	recoveryCodeCount = 10
+	// totpPeriod is the TOTP time step in seconds, the authenticator default
+	totpPeriod = 30
+	// qrCodeSize is the width and height of enrollment QR codes in pixels
+	qrCodeSize = 200
+)
+
+// totpOptions are the parameters every authenticator app supports: SHA-1,
+// six digits, 30-second steps
+var totpOptions = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+var (
+	// ErrInvalidLoginChallenge is returned for unknown, expired or used-up
+	// login challenges
+	ErrInvalidLoginChallenge = errors.New("login challenge is invalid or expired")
+	// ErrInvalidTOTPCode is returned when a two-factor code doesn't check out
+	ErrInvalidTOTPCode = errors.New("invalid two-factor code")
+	// ErrTOTPAlreadyEnabled is returned when enrolling a user who is enrolled
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTOTPNotEnabled is returned for actions needing an enrolled user
+	ErrTOTPNotEnabled = errors.New("two-factor authentication is not enabled")
+	// ErrTOTPEnrollmentNotStarted is returned when confirming an enrollment
+	// that has no secret yet
+	ErrTOTPEnrollmentNotStarted = errors.New("two-factor enrollment has not been started")
+	// ErrTOTPRequired is returned when disabling two-factor authentication
+	// the user's role requires
+	ErrTOTPRequired = errors.New("two-factor authentication is required for this role")
+)
+
+// TwoFactorConfig configures TOTP two-factor authentication
+type TwoFactorConfig struct {
+	// Issuer names the service in authenticator apps
+	Issuer string
+	// RequiredRoles must log in with a second factor; their users enroll as
+	// part of their next login if they haven't already
+	RequiredRoles []model.Role
+	// Secrets encrypts TOTP secrets in the database
+	Secrets *SecretBox
+}
+
+// twoFactorRequired reports whether the user's role requires two-factor
+// authentication
+func (s *authService) twoFactorRequired(user *model.User) bool {
+	return slices.Contains(s.twoFactor.RequiredRoles, user.Role)
+}
+
+// startLoginChallenge answers a login whose password checked out with a
+// challenge for the second factor instead of a session. Users without any
+// second factor are asked to set up TOTP.
+func (s *authService) startLoginChallenge(ctx context.Context, user *model.User, hasKeys bool) (*dto.LoginResponse, error) {
+	token, err := generateSessionID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate challenge token: %w", err)
+	}
+
+	challenge := &model.LoginChallenge{
+		ID:        token,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(loginChallengeTTL),
+	}
+	if err := s.challengeRepo.Create(ctx, challenge); err != nil {
+		return nil, fmt.Errorf("failed to create login challenge: %w", err)
+	}
+
+	var methods []string
+	if user.TOTPEnabled {
+		methods = append(methods, "totp", "recovery_code")
+	}
+	if hasKeys {
+		methods = append(methods, "webauthn")
+	}
+
+	// Nothing about the user is given away before the second factor passes
+	return &dto.LoginResponse{
+		Message:            "Two-factor authentication required",
+		TwoFactorRequired:  true,
+		EnrollmentRequired: len(methods) == 0,
+		TwoFactorMethods:   methods,
+		ChallengeToken:     token,
+	}, nil
+}
+
+// VerifyLogin completes a login challenge with a TOTP code or, for enrolled
+// users, a recovery code, and creates the session. For a user enrolling as
+// part of the login, the code confirms the enrollment and the response
+// carries their recovery codes.
+func (s *authService) VerifyLogin(ctx context.Context, req *dto.VerifyLoginRequest) (*dto.LoginResponse, error) {
+	challenge, err := s.getLoginChallenge(ctx, req.ChallengeToken)
+	if err != nil {
+		return nil, err
+	}
+	user := &challenge.User
+
+	if err := s.checkLoginLock(ctx, user.Email); err != nil {
+		return nil, err
+	}
+	if err := s.recordLoginAttempt(ctx, challenge); err != nil {
+		return nil, err
+	}
+
+	var method, enrollmentSecret string
+	var ok bool
+	if user.TOTPEnabled {
+		method, ok, err = s.verifySecondFactor(ctx, user, req.Code)
+	} else {
+		if challenge.EnrollmentSecret == "" {
+			return nil, ErrTOTPEnrollmentNotStarted
+		}
+		if enrollmentSecret, err = s.enrollmentSecret(challenge); err != nil {
+			return nil, err
+		}
+		method = "totp"
+		ok, err = s.checkTOTP(ctx, user, enrollmentSecret, normalizeCode(req.Code))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.audit.Log(ctx, nil, AuditTOTPFailed, "user", fmt.Sprint(user.ID), map[string]any{"email": user.Email, "method": method})
+		// Counted like a wrong password: every login whose password checks
+		// out starts a new challenge, so its own attempt limit alone
+		// wouldn't bound the guesses
+		if err := s.recordLoginFailure(ctx, user.Email); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidTOTPCode
+	}
+
+	var recoveryCodes []string
+	if !user.TOTPEnabled {
+		if recoveryCodes, err = s.enableTOTP(ctx, user, enrollmentSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	// A parallel request may have passed the challenge already
+	consumed, err := s.challengeRepo.Consume(ctx, challenge.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume login challenge: %w", err)
+	}
+	if !consumed {
+		return nil, ErrInvalidLoginChallenge
+	}
+
+	actorID := user.ID
+	s.audit.Log(ctx, &actorID, AuditTOTPVerified, "user", fmt.Sprint(user.ID), map[string]any{"method": method})
+
+	resp, err := s.createSession(ctx, user, map[string]any{"two_factor": method})
+	if err != nil {
+		return nil, err
+	}
+	resp.RecoveryCodes = recoveryCodes
+	return resp, nil
+}
+
+// BeginLoginEnrollment returns the TOTP secret a user whose role requires
+// two-factor authentication enrolls with during their login. The secret is
+// kept with the challenge, so asking again returns the same one.
+func (s *authService) BeginLoginEnrollment(ctx context.Context, challengeToken string) (*dto.TOTPEnrollmentResponse, error) {
+	challenge, err := s.getLoginChallenge(ctx, challengeToken)
+	if err != nil {
+		return nil, err
+	}
+	if challenge.User.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	if challenge.EnrollmentSecret != "" {
+		secret, err := s.enrollmentSecret(challenge)
+		if err != nil {
+			return nil, err
+		}
+		return s.totpEnrollment(&challenge.User, secret)
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.twoFactor.Secrets.Seal(secret, enrollmentSecretOwner(challenge.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt enrollment secret: %w", err)
+	}
+	if err := s.challengeRepo.SetEnrollmentSecret(ctx, challenge.ID, sealed); err != nil {
+		return nil, fmt.Errorf("failed to save enrollment secret: %w", err)
+	}
+
+	return s.totpEnrollment(&challenge.User, secret)
+}
+
+// BeginTOTPEnrollment generates a new TOTP secret for a logged-in user. It
+// takes effect once ConfirmTOTPEnrollment is given a code generated from it.
+func (s *authService) BeginTOTPEnrollment(ctx context.Context, userID uint) (*dto.TOTPEnrollmentResponse, error) {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setTOTPSecret(user, secret); err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to save enrollment secret: %w", err)
+	}
+
+	return s.totpEnrollment(user, secret)
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication for a user who
+// began enrolling, given a code from their authenticator, and returns their
+// recovery codes
+func (s *authService) ConfirmTOTPEnrollment(ctx context.Context, userID uint, code string) (*dto.RecoveryCodesResponse, error) {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPEnrollmentNotStarted
+	}
+	secret, err := s.totpSecret(user)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := s.checkTOTP(ctx, user, secret, normalizeCode(code))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.audit.Log(ctx, &user.ID, AuditTOTPFailed, "user", fmt.Sprint(user.ID), map[string]any{"method": "totp", "reason": "enrollment"})
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes, err := s.enableTOTP(ctx, user, secret)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// RegenerateRecoveryCodes replaces an enrolled user's recovery codes, given a
+// current TOTP code
+func (s *authService) RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) (*dto.RecoveryCodesResponse, error) {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrTOTPNotEnabled
+	}
+	secret, err := s.totpSecret(user)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := s.checkTOTP(ctx, user, secret, normalizeCode(code))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.audit.Log(ctx, &user.ID, AuditTOTPFailed, "user", fmt.Sprint(user.ID), map[string]any{"method": "totp", "reason": "recovery code regeneration"})
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes, err := s.issueRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.audit.Log(ctx, &user.ID, AuditRecoveryCodesRegenerated, "user", fmt.Sprint(user.ID), nil)
+
+	return &dto.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// DisableTOTP turns off two-factor authentication for a user, given a TOTP
+// or recovery code. Users whose role requires two-factor authentication
+// can't turn it off, unless they have a security key instead.
+func (s *authService) DisableTOTP(ctx context.Context, userID uint, code string) error {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	if s.twoFactorRequired(user) {
+		hasKeys, err := s.hasWebAuthnCredentials(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if !hasKeys {
+			return ErrTOTPRequired
+		}
+	}
+
+	method, ok, err := s.verifySecondFactor(ctx, user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.audit.Log(ctx, &user.ID, AuditTOTPFailed, "user", fmt.Sprint(user.ID), map[string]any{"method": method, "reason": "disable"})
+		return ErrInvalidTOTPCode
+	}
+
+	if err := s.clearTOTP(ctx, user); err != nil {
+		return err
+	}
+	s.audit.Log(ctx, &user.ID, AuditTOTPDisabled, "user", fmt.Sprint(user.ID), map[string]any{"method": method})
+
+	return nil
+}
+
+// ResetTOTP turns off two-factor authentication for a user who lost their
+// authenticator and recovery codes (admin action). If their role requires
+// it, they enroll again on their next login.
+func (s *authService) ResetTOTP(ctx context.Context, userID uint) error {
+	if err := authorize(ctx, PermUsersSecurity); err != nil {
+		return err
+	}
+
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.clearTOTP(ctx, user); err != nil {
+		return err
+	}
+
+	s.audit.Log(ctx, nil, AuditTOTPReset, "user", fmt.Sprint(userID), nil)
+
+	return nil
+}
+
+// recordLoginAttempt counts an attempt at passing a challenge, dropping the
+// challenge once they are used up
+func (s *authService) recordLoginAttempt(ctx context.Context, challenge *model.LoginChallenge) error {
+	allowed, err := s.challengeRepo.RecordAttempt(ctx, challenge.ID, maxLoginChallengeAttempts)
+	if err != nil {
+		return fmt.Errorf("failed to record login attempt: %w", err)
+	}
+	if !allowed {
+		_ = s.challengeRepo.Delete(ctx, challenge.ID)
+		s.audit.Log(ctx, nil, AuditTOTPFailed, "user", fmt.Sprint(challenge.UserID), map[string]any{"email": challenge.User.Email, "reason": "too many attempts"})
+		return ErrInvalidLoginChallenge
+	}
+	return nil
+}
+
+// getLoginChallenge returns a challenge that can still be passed
+func (s *authService) getLoginChallenge(ctx context.Context, token string) (*model.LoginChallenge, error) {
+	if token == "" {
+		return nil, ErrInvalidLoginChallenge
+	}
+
+	challenge, err := s.challengeRepo.GetByID(ctx, token)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidLoginChallenge
+		}
+		return nil, fmt.Errorf("failed to get login challenge: %w", err)
+	}
+	if challenge.IsExpired() {
+		_ = s.challengeRepo.Delete(ctx, challenge.ID)
+		return nil, ErrInvalidLoginChallenge
+	}
+	if !challenge.User.Enabled {
+		return nil, errors.New("user account is disabled")
+	}
+
+	return challenge, nil
+}
+
+func (s *authService) getUser(ctx context.Context, userID uint) (*model.User, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+// verifySecondFactor checks a code of an enrolled user: a six-digit TOTP
+// code, or else one of their recovery codes, which is used up. It returns
+// which of the two the code was taken for.
+func (s *authService) verifySecondFactor(ctx context.Context, user *model.User, code string) (string, bool, error) {
+	code = normalizeCode(code)
+	if isTOTPCode(code) {
+		secret, err := s.totpSecret(user)
+		if err != nil {
+			return "totp", false, err
+		}
+		ok, err := s.checkTOTP(ctx, user, secret, code)
+		return "totp", ok, err
+	}
+
+	ok, err := s.recoveryCodeRepo.Use(ctx, user.ID, hashSecret(code), time.Now())
+	if err != nil {
+		return "recovery_code", false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	return "recovery_code", ok, nil
+}
+
+// checkTOTP checks a TOTP code against secret, allowing a step of clock
+// drift either way. Each code is accepted once: the step it belongs to is
+// recorded on the user, and codes from that step or earlier are refused.
+func (s *authService) checkTOTP(ctx context.Context, user *model.User, secret, code string) (bool, error) {
+	current := time.Now().Unix() / totpPeriod
+	for _, step := range []int64{current, current - 1, current + 1} {
+		if step <= user.TOTPLastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totpOptions)
+		if err != nil {
+			return false, fmt.Errorf("failed to generate TOTP code: %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+
+		// Recorded with a conditional update, so a code replayed in parallel
+		// is only accepted once
+		advanced, err := s.userRepo.AdvanceTOTPStep(ctx, user.ID, step)
+		if err != nil {
+			return false, fmt.Errorf("failed to record TOTP step: %w", err)
+		}
+		if advanced {
+			user.TOTPLastStep = step
+		}
+		return advanced, nil
+	}
+	return false, nil
+}
+
+// enableTOTP turns on two-factor authentication with a confirmed secret and
+// issues the user's recovery codes
+func (s *authService) enableTOTP(ctx context.Context, user *model.User, secret string) ([]string, error) {
+	if err := s.setTOTPSecret(user, secret); err != nil {
+		return nil, err
+	}
+	user.TOTPEnabled = true
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+
+	codes, err := s.issueRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Log(ctx, &user.ID, AuditTOTPEnrolled, "user", fmt.Sprint(user.ID), map[string]any{"email": user.Email})
+
+	return codes, nil
+}
+
+// clearTOTP turns off two-factor authentication and drops the recovery
+// codes. The last accepted step is kept, so old codes stay used up should
+// the same secret ever come back.
+func (s *authService) clearTOTP(ctx context.Context, user *model.User) error {
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+	if err := s.recoveryCodeRepo.DeleteByUserID(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	return nil
+}
+
+// setTOTPSecret sets the user's TOTP secret, encrypted for storage
+func (s *authService) setTOTPSecret(user *model.User, secret string) error {
+	sealed, err := s.twoFactor.Secrets.Seal(secret, totpSecretOwner(user.ID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt TOTP secret: %w", err)
+	}
+	user.TOTPSecret = sealed
+	return nil
+}
+
+// totpSecret decrypts the user's TOTP secret
+func (s *authService) totpSecret(user *model.User) (string, error) {
+	secret, err := s.twoFactor.Secrets.Open(user.TOTPSecret, totpSecretOwner(user.ID))
+	if err != nil {
+		return "", fmt.Errorf("failed to read TOTP secret: %w", err)
+	}
+	return secret, nil
+}
+
+// enrollmentSecret decrypts the TOTP secret a login challenge is enrolling
+// with
+func (s *authService) enrollmentSecret(challenge *model.LoginChallenge) (string, error) {
+	secret, err := s.twoFactor.Secrets.Open(challenge.EnrollmentSecret, enrollmentSecretOwner(challenge.ID))
+	if err != nil {
+		return "", fmt.Errorf("failed to read enrollment secret: %w", err)
+	}
+	return secret, nil
+}
+
+// totpSecretOwner and enrollmentSecretOwner name what a TOTP secret is
+// encrypted for: the user it belongs to, or the login challenge enrolling
+// with it
+func totpSecretOwner(userID uint) string {
+	return fmt.Sprintf("user:%d:totp", userID)
+}
+
+func enrollmentSecretOwner(challengeID string) string {
+	return "login_challenge:" + challengeID
+}
+
+// EncryptTOTPSecrets encrypts the TOTP secrets stored in the clear before
+// secrets were encrypted, returning how many it encrypted. It runs at
+// startup; once every secret is encrypted it has nothing left to do.
+func EncryptTOTPSecrets(ctx context.Context, userRepo repository.UserRepository, secrets *SecretBox) (int, error) {
+	users, err := userRepo.ListTOTPSecrets(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list TOTP secrets: %w", err)
+	}
+
+	encrypted := 0
+	for _, user := range users {
+		if IsSealed(user.TOTPSecret) {
+			continue
+		}
+		sealed, err := secrets.Seal(user.TOTPSecret, totpSecretOwner(user.ID))
+		if err != nil {
+			return encrypted, fmt.Errorf("failed to encrypt TOTP secret: %w", err)
+		}
+		// Conditional on the secret still being the one read, in case
+		// another replica starting up got there first
+		replaced, err := userRepo.ReplaceTOTPSecret(ctx, user.ID, user.TOTPSecret, sealed)
+		if err != nil {
+			return encrypted, fmt.Errorf("failed to save TOTP secret: %w", err)
+		}
+		if replaced {
+			encrypted++
+		}
+	}
+	return encrypted, nil
+}
+
+// issueRecoveryCodes replaces a user's recovery codes with new ones and
+// returns them; only their hashes are kept
+func (s *authService) issueRecoveryCodes(ctx context.Context, userID uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashSecret(normalizeCode(code))
+	}
+
+	if err := s.recoveryCodeRepo.ReplaceForUser(ctx, userID, hashes); err != nil {
+		return nil, fmt.Errorf("failed to save recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// totpEnrollment describes a secret for authenticator apps: as an otpauth://
+// URI, and as a QR code of it
+func (s *authService) totpEnrollment(user *model.User, secret string) (*dto.TOTPEnrollmentResponse, error) {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", s.twoFactor.Issuer)
+	query.Set("algorithm", totpOptions.Algorithm.String())
+	query.Set("digits", totpOptions.Digits.String())
+	query.Set("period", strconv.Itoa(int(totpOptions.Period)))
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + s.twoFactor.Issuer + ":" + user.Email,
+		RawQuery: query.Encode(),
+	}
+
+	key, err := otp.NewKeyFromURL(uri.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to build TOTP key: %w", err)
+	}
+	img, err := key.Image(qrCodeSize, qrCodeSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render QR code: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+
+	return &dto.TOTPEnrollmentResponse{
+		Secret:     secret,
+		OTPAuthURI: key.String(),
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// newTOTPSecret generates a 160-bit TOTP secret, base32 encoded as
+// authenticator apps expect
+func newTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+// recoveryCodeEncoding spells recovery codes in lower-case base32, which
+// avoids easily confused characters like 0/o and 1/l
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// newRecoveryCode generates a 50-bit recovery code like "abcde-fgh23"
+func newRecoveryCode() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+	code := recoveryCodeEncoding.EncodeToString(raw)[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeCode drops the spaces and dashes people type or paste along with
+// a code
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code)))
+}
+
+// isTOTPCode reports whether a normalized code looks like a TOTP code
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,369 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// mockLoginChallengeRepository is an in-memory LoginChallengeRepository
+type mockLoginChallengeRepository struct {
+	challenges map[string]*model.LoginChallenge
+	users      *mockUserRepository
+}
+
+func newMockLoginChallengeRepository(users *mockUserRepository) *mockLoginChallengeRepository {
+	return &mockLoginChallengeRepository{
+		challenges: make(map[string]*model.LoginChallenge),
+		users:      users,
+	}
+}
+
+func (m *mockLoginChallengeRepository) Create(ctx context.Context, challenge *model.LoginChallenge) error {
+	m.challenges[challenge.ID] = challenge
+	return nil
+}
+
+func (m *mockLoginChallengeRepository) GetByID(ctx context.Context, id string) (*model.LoginChallenge, error) {
+	challenge, exists := m.challenges[id]
+	if !exists {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *challenge
+	if user, err := m.users.GetByID(ctx, challenge.UserID); err == nil {
+		copied.User = *user
+	}
+	return &copied, nil
+}
+
+func (m *mockLoginChallengeRepository) SetEnrollmentSecret(ctx context.Context, id, secret string) error {
+	if challenge, exists := m.challenges[id]; exists {
+		challenge.EnrollmentSecret = secret
+	}
+	return nil
+}
+
+func (m *mockLoginChallengeRepository) SetWebAuthnSession(ctx context.Context, id string, session []byte) error {
+	if challenge, exists := m.challenges[id]; exists {
+		challenge.WebAuthnSession = session
+	}
+	return nil
+}
+
+func (m *mockLoginChallengeRepository) RecordAttempt(ctx context.Context, id string, maxAttempts int) (bool, error) {
+	challenge, exists := m.challenges[id]
+	if !exists || challenge.Attempts >= maxAttempts {
+		return false, nil
+	}
+	challenge.Attempts++
+	return true, nil
+}
+
+func (m *mockLoginChallengeRepository) Consume(ctx context.Context, id string) (bool, error) {
+	_, exists := m.challenges[id]
+	delete(m.challenges, id)
+	return exists, nil
+}
+
+func (m *mockLoginChallengeRepository) Delete(ctx context.Context, id string) error {
+	delete(m.challenges, id)
+	return nil
+}
+
+// mockRecoveryCodeRepository is an in-memory RecoveryCodeRepository
+type mockRecoveryCodeRepository struct {
+	codes []model.RecoveryCode
+}
+
+func newMockRecoveryCodeRepository() *mockRecoveryCodeRepository {
+	return &mockRecoveryCodeRepository{}
+}
+
+func (m *mockRecoveryCodeRepository) ReplaceForUser(ctx context.Context, userID uint, codeHashes []string) error {
+	_ = m.DeleteByUserID(ctx, userID)
+	for _, hash := range codeHashes {
+		m.codes = append(m.codes, model.RecoveryCode{ID: uint(len(m.codes) + 1), UserID: userID, CodeHash: hash})
+	}
+	return nil
+}
+
+func (m *mockRecoveryCodeRepository) Use(ctx context.Context, userID uint, codeHash string, usedAt time.Time) (bool, error) {
+	for i := range m.codes {
+		if m.codes[i].UserID == userID && m.codes[i].CodeHash == codeHash && m.codes[i].UsedAt == nil {
+			m.codes[i].UsedAt = &usedAt
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockRecoveryCodeRepository) CountUnused(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+	for _, code := range m.codes {
+		if code.UserID == userID && code.UsedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockRecoveryCodeRepository) DeleteByUserID(ctx context.Context, userID uint) error {
+	kept := m.codes[:0]
+	for _, code := range m.codes {
+		if code.UserID != userID {
+			kept = append(kept, code)
+		}
+	}
+	m.codes = kept
+	return nil
+}
+
+// testSecrets encrypts TOTP secrets in tests
+var testSecrets, _ = NewSecretBox(bytes.Repeat([]byte{0x42}, 32))
+
+// setupTwoFactor creates an auth service with one user of the given role,
+// whose password is secret123
+func setupTwoFactor(t *testing.T, role model.Role, twoFactor TwoFactorConfig) (AuthService, *mockUserRepository, *recordingAudit) {
+	t.Helper()
+	twoFactor.Secrets = testSecrets
+	userRepo := newMockUserRepository()
+	audit := &recordingAudit{}
+	svc := NewAuthService(userRepo, newMockSessionRepository(userRepo), newMockOAuthTokenRepository(), newMockLoginChallengeRepository(userRepo),
+		newMockRecoveryCodeRepository(), newMockWebAuthnCredentialRepository(), newMockWebAuthnCeremonyRepository(),
+		newMockLoginThrottleRepository(), audit, time.Hour, SessionTimeouts{}, twoFactor, newTestWebAuthn(t), LockoutConfig{},
+		newTestPasswordPolicy())
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if err := userRepo.Create(context.Background(), &model.User{
+		Name:         "Test User",
+		Email:        "test@example.com",
+		PasswordHash: string(hash),
+		Enabled:      true,
+		Role:         role,
+	}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	return svc, userRepo, audit
+}
+
+// enableTestTOTP turns on TOTP for a user with a new secret, bypassing the
+// enrollment, and returns the secret
+func enableTestTOTP(t *testing.T, userRepo *mockUserRepository, userID uint) string {
+	t.Helper()
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := testSecrets.Seal(secret, totpSecretOwner(userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := userRepo.GetByID(context.Background(), userID)
+	user.TOTPSecret, user.TOTPEnabled = sealed, true
+	return secret
+}
+
+// totpCode returns the code for secret at now shifted by the given number of
+// 30-second steps; consecutive steps give distinct, unused codes
+func totpCode(t *testing.T, secret string, steps int) string {
+	t.Helper()
+	code, err := totp.GenerateCode(secret, time.Now().Add(time.Duration(steps)*totpPeriod*time.Second))
+	if err != nil {
+		t.Fatalf("GenerateCode() error = %v", err)
+	}
+	return code
+}
+
+func (a *recordingAudit) actions() []string {
+	actions := make([]string, len(a.entries))
+	for i, entry := range a.entries {
+		actions[i] = entry.action
+	}
+	return actions
+}
+
+func TestTOTPEnrollmentAndLogin(t *testing.T) {
+	svc, userRepo, audit := setupTwoFactor(t, model.RoleUser, TwoFactorConfig{Issuer: "Acme"})
+	ctx := context.Background()
+	login := &dto.LoginRequest{Email: "test@example.com", Password: "secret123"}
+
+	enrollment, err := svc.BeginTOTPEnrollment(ctx, 1)
+	if err != nil {
+		t.Fatalf("BeginTOTPEnrollment() error = %v", err)
+	}
+	if enrollment.OTPAuthURI == "" || enrollment.QRCode == "" || enrollment.Secret == "" {
+		t.Fatalf("BeginTOTPEnrollment() = %+v, want secret, URI and QR code", enrollment)
+	}
+
+	// Until confirmed, logging in needs no code
+	if resp, _ := svc.Login(ctx, login); resp == nil || resp.TwoFactorRequired {
+		t.Fatalf("Login() before confirming = %+v, want a session", resp)
+	}
+
+	if _, err := svc.ConfirmTOTPEnrollment(ctx, 1, "000000"); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatalf("ConfirmTOTPEnrollment(wrong code) error = %v, want ErrInvalidTOTPCode", err)
+	}
+	enrollmentCode := totpCode(t, enrollment.Secret, 0)
+	codes, err := svc.ConfirmTOTPEnrollment(ctx, 1, enrollmentCode)
+	if err != nil {
+		t.Fatalf("ConfirmTOTPEnrollment() error = %v", err)
+	}
+	if len(codes.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes.RecoveryCodes), recoveryCodeCount)
+	}
+
+	// Logging in now takes two steps
+	resp, err := svc.Login(ctx, login)
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if !resp.TwoFactorRequired || resp.EnrollmentRequired || resp.SessionID != "" || resp.ChallengeToken == "" {
+		t.Fatalf("Login() = %+v, want a challenge instead of a session", resp)
+	}
+	if resp.User != nil {
+		t.Errorf("Login() = %+v, want nothing about the user before the second factor", resp.User)
+	}
+
+	// The code used to confirm the enrollment can't be replayed
+	replayed := &dto.VerifyLoginRequest{ChallengeToken: resp.ChallengeToken, Code: enrollmentCode}
+	if _, err := svc.VerifyLogin(ctx, replayed); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatalf("VerifyLogin(replayed code) error = %v, want ErrInvalidTOTPCode", err)
+	}
+
+	verified, err := svc.VerifyLogin(ctx, &dto.VerifyLoginRequest{ChallengeToken: resp.ChallengeToken, Code: totpCode(t, enrollment.Secret, 1)})
+	if err != nil {
+		t.Fatalf("VerifyLogin() error = %v", err)
+	}
+	if verified.SessionID == "" {
+		t.Fatal("VerifyLogin() returned no session")
+	}
+	if _, err := svc.ValidateSession(ctx, verified.SessionID); err != nil {
+		t.Errorf("ValidateSession() error = %v", err)
+	}
+
+	// The challenge is used up
+	if _, err := svc.VerifyLogin(ctx, &dto.VerifyLoginRequest{ChallengeToken: resp.ChallengeToken, Code: codes.RecoveryCodes[0]}); !errors.Is(err, ErrInvalidLoginChallenge) {
+		t.Errorf("VerifyLogin(used challenge) error = %v, want ErrInvalidLoginChallenge", err)
+	}
+
+	// A recovery code works once, typed loosely
+	for i, wantErr := range []error{nil, ErrInvalidTOTPCode} {
+		resp, _ := svc.Login(ctx, login)
+		code := " " + codes.RecoveryCodes[1] + " "
+		if _, err := svc.VerifyLogin(ctx, &dto.VerifyLoginRequest{ChallengeToken: resp.ChallengeToken, Code: code}); !errors.Is(err, wantErr) {
+			t.Errorf("VerifyLogin(recovery code, use %d) error = %v, want %v", i+1, err, wantErr)
+		}
+	}
+
+	user, _ := userRepo.GetByID(ctx, 1)
+	if !user.TOTPEnabled {
+		t.Error("TOTPEnabled = false after enrollment")
+	}
+	if strings.Contains(user.TOTPSecret, enrollment.Secret) {
+		t.Error("TOTP secret stored in the clear")
+	}
+	wantActions := map[string]bool{AuditTOTPEnrolled: false, AuditTOTPVerified: false, AuditTOTPFailed: false}
+	for _, action := range audit.actions() {
+		if _, ok := wantActions[action]; ok {
+			wantActions[action] = true
+		}
+	}
+	for action, seen := range wantActions {
+		if !seen {
+			t.Errorf("no %s audit entry in %v", action, audit.actions())
+		}
+	}
+}
+
+func TestLoginChallengeAttemptLimit(t *testing.T) {
+	svc, userRepo, _ := setupTwoFactor(t, model.RoleUser, TwoFactorConfig{})
+	ctx := context.Background()
+
+	secret := enableTestTOTP(t, userRepo, 1)
+
+	resp, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	for i := 0; i < maxLoginChallengeAttempts; i++ {
+		if _, err := svc.VerifyLogin(ctx, &dto.VerifyLoginRequest{ChallengeToken: resp.ChallengeToken, Code: "000000"}); !errors.Is(err, ErrInvalidTOTPCode) {
+			t.Fatalf("attempt %d error = %v, want ErrInvalidTOTPCode", i+1, err)
+		}
+	}
+
+	// The failures also locked the account (see
+	// TestSecondFactorFailuresLockLogin); lifted, the challenge alone still
+	// refuses even the right code once its attempts are used up
+	if err := svc.UnlockUser(adminContext(), 1); err != nil {
+		t.Fatalf("UnlockUser() error = %v", err)
+	}
+	_, err = svc.VerifyLogin(ctx, &dto.VerifyLoginRequest{ChallengeToken: resp.ChallengeToken, Code: totpCode(t, secret, 0)})
+	if !errors.Is(err, ErrInvalidLoginChallenge) {
+		t.Errorf("VerifyLogin() after %d failures error = %v, want ErrInvalidLoginChallenge", maxLoginChallengeAttempts, err)
+	}
+}
+
+func TestTOTPRequiredRoleEnrollsAtLogin(t *testing.T) {
+	svc, userRepo, _ := setupTwoFactor(t, model.RoleAdmin, TwoFactorConfig{RequiredRoles: []model.Role{model.RoleAdmin}})
+	ctx := context.Background()
+
+	resp, err := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if !resp.TwoFactorRequired || !resp.EnrollmentRequired {
+		t.Fatalf("Login() = %+v, want enrollment required", resp)
+	}
+
+	if _, err := svc.VerifyLogin(ctx, &dto.VerifyLoginRequest{ChallengeToken: resp.ChallengeToken, Code: "123456"}); !errors.Is(err, ErrTOTPEnrollmentNotStarted) {
+		t.Fatalf("VerifyLogin() before enrolling error = %v, want ErrTOTPEnrollmentNotStarted", err)
+	}
+
+	enrollment, err := svc.BeginLoginEnrollment(ctx, resp.ChallengeToken)
+	if err != nil {
+		t.Fatalf("BeginLoginEnrollment() error = %v", err)
+	}
+	again, _ := svc.BeginLoginEnrollment(ctx, resp.ChallengeToken)
+	if again == nil || again.Secret != enrollment.Secret {
+		t.Errorf("BeginLoginEnrollment() again = %+v, want the same secret", again)
+	}
+
+	verified, err := svc.VerifyLogin(ctx, &dto.VerifyLoginRequest{ChallengeToken: resp.ChallengeToken, Code: totpCode(t, enrollment.Secret, 0)})
+	if err != nil {
+		t.Fatalf("VerifyLogin() error = %v", err)
+	}
+	if verified.SessionID == "" || len(verified.RecoveryCodes) != recoveryCodeCount {
+		t.Errorf("VerifyLogin() = %+v, want a session and recovery codes", verified)
+	}
+	user, _ := userRepo.GetByID(ctx, 1)
+	if secret, _ := testSecrets.Open(user.TOTPSecret, totpSecretOwner(1)); !user.TOTPEnabled || secret != enrollment.Secret {
+		t.Error("enrollment during login did not enable TOTP")
+	}
+
+	// The role requires it, so it can't be turned off; an admin can reset it
+	if err := svc.DisableTOTP(ctx, 1, verified.RecoveryCodes[0]); !errors.Is(err, ErrTOTPRequired) {
+		t.Errorf("DisableTOTP() error = %v, want ErrTOTPRequired", err)
+	}
+	if err := svc.ResetTOTP(ctx, 1); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ResetTOTP() without an actor error = %v, want ErrForbidden", err)
+	}
+	if err := svc.ResetTOTP(adminContext(), 1); err != nil {
+		t.Fatalf("ResetTOTP() error = %v", err)
+	}
+	if resp, _ := svc.Login(ctx, &dto.LoginRequest{Email: "test@example.com", Password: "secret123"}); resp == nil || !resp.EnrollmentRequired {
+		t.Errorf("Login() after reset = %+v, want enrollment required again", resp)
+	}
+}
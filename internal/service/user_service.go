@@ -12,15 +12,27 @@ import (
 	"gorm.io/gorm"
 )
 
+var (
+	// ErrInvalidRole is returned when a request names a role that doesn't exist
+	ErrInvalidRole = errors.New("invalid role")
+	// ErrOwnRoleChange is returned when users try to change their own role
+	ErrOwnRoleChange = errors.New("you cannot change your own role")
+)
+
 // UserService defines the interface for user business logic
 type UserService interface {
 	CreateUser(ctx context.Context, req *dto.CreateUserRequest) (*dto.UserResponse, error)
 	GetUser(ctx context.Context, id uint) (*dto.UserResponse, error)
+	// GetPublicUser returns the non-sensitive projection of a user. It requires
+	// no permission: it backs the internal lookup used by other services.
+	GetPublicUser(ctx context.Context, id uint) (*dto.PublicUserResponse, error)
 	GetUsers(ctx context.Context, pagination *dto.PaginationParams) (*dto.UserListResponse, error)
 	UpdateUser(ctx context.Context, id uint, req *dto.UpdateUserRequest) (*dto.UserResponse, error)
 	DeleteUser(ctx context.Context, id uint) error
-	GetUserFeatureFlags(ctx context.Context, userID uint) ([]dto.FeatureFlagResponse, error)
-	AssignFeatureFlagToUser(ctx context.Context, userID uint, featureFlagKey string) error
+	GetUserFeatureFlags(ctx context.Context, userID uint) ([]dto.UserFeatureFlagResponse, error)
+	// AssignFeatureFlagToUser enables a flag for a user. A non-empty variant pins the variant
+	// they are served; assigning an already assigned flag changes the pinned variant.
+	AssignFeatureFlagToUser(ctx context.Context, userID uint, featureFlagKey string, variant string) error
 	UnassignFeatureFlagFromUser(ctx context.Context, userID uint, featureFlagKey string) error
 }
 
@@ -29,26 +41,47 @@ type userService struct {
 	userRepo        repository.UserRepository
 	featureFlagRepo repository.FeatureFlagRepository
 	userFFRepo      repository.UserFeatureFlagRepository
+	flagChanges     *FlagChangeFeed
 	audit           AuditLogger
 }
 
-// NewUserService creates a new user service
+// NewUserService creates a new user service. Assignment changes are recorded
+// in flagChanges, which may be nil.
 func NewUserService(
 	userRepo repository.UserRepository,
 	featureFlagRepo repository.FeatureFlagRepository,
 	userFFRepo repository.UserFeatureFlagRepository,
+	flagChanges *FlagChangeFeed,
 	audit AuditLogger,
 ) UserService {
 	return &userService{
 		userRepo:        userRepo,
 		featureFlagRepo: featureFlagRepo,
 		userFFRepo:      userFFRepo,
+		flagChanges:     flagChanges,
 		audit:           audit,
 	}
 }
 
 // CreateUser creates a new user
 func (s *userService) CreateUser(ctx context.Context, req *dto.CreateUserRequest) (*dto.UserResponse, error) {
+	if err := authorize(ctx, PermUsersWrite); err != nil {
+		return nil, err
+	}
+
+	role := model.RoleUser
+	if req.Role != "" {
+		role = model.Role(req.Role)
+		if !role.Valid() {
+			return nil, ErrInvalidRole
+		}
+	}
+	if role != model.RoleUser {
+		if err := authorize(ctx, PermUsersSecurity); err != nil {
+			return nil, err
+		}
+	}
+
 	// Validate email uniqueness
 	existingUser, err := s.userRepo.GetByEmail(ctx, req.Email)
 	if err == nil && existingUser != nil {
@@ -59,19 +92,24 @@ func (s *userService) CreateUser(ctx context.Context, req *dto.CreateUserRequest
 		Name:    req.Name,
 		Email:   req.Email,
 		Enabled: req.Enabled,
+		Role:    role,
 	}
 
 	if err := s.userRepo.Create(ctx, user); err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
-	s.audit.Log(ctx, nil, AuditUserCreated, "user", fmt.Sprint(user.ID), map[string]any{"email": user.Email})
+	s.audit.Log(ctx, nil, AuditUserCreated, "user", fmt.Sprint(user.ID), map[string]any{"email": user.Email, "role": user.Role})
 
 	return s.toUserResponse(user), nil
 }
 
 // GetUser retrieves a user by ID
 func (s *userService) GetUser(ctx context.Context, id uint) (*dto.UserResponse, error) {
+	if err := authorize(ctx, PermUsersRead); err != nil {
+		return nil, err
+	}
+
 	user, err := s.userRepo.GetByID(ctx, id)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -83,8 +121,28 @@ func (s *userService) GetUser(ctx context.Context, id uint) (*dto.UserResponse,
 	return s.toUserResponse(user), nil
 }
 
+// GetPublicUser retrieves a user's public info by ID
+func (s *userService) GetPublicUser(ctx context.Context, id uint) (*dto.PublicUserResponse, error) {
+	user, err := s.userRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return &dto.PublicUserResponse{
+		ID:   user.ID,
+		Name: user.Name,
+	}, nil
+}
+
 // GetUsers retrieves all users with pagination
 func (s *userService) GetUsers(ctx context.Context, pagination *dto.PaginationParams) (*dto.UserListResponse, error) {
+	if err := authorize(ctx, PermUsersRead); err != nil {
+		return nil, err
+	}
+
 	users, total, err := s.userRepo.GetAll(ctx, pagination.GetLimit(), pagination.GetOffset())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get users: %w", err)
@@ -106,6 +164,10 @@ func (s *userService) GetUsers(ctx context.Context, pagination *dto.PaginationPa
 
 // UpdateUser updates a user
 func (s *userService) UpdateUser(ctx context.Context, id uint, req *dto.UpdateUserRequest) (*dto.UserResponse, error) {
+	if err := authorize(ctx, PermUsersWrite); err != nil {
+		return nil, err
+	}
+
 	user, err := s.userRepo.GetByID(ctx, id)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -118,7 +180,11 @@ func (s *userService) UpdateUser(ctx context.Context, id uint, req *dto.UpdateUs
 	if req.Name != nil {
 		user.Name = *req.Name
 	}
-	if req.Email != nil {
+	if req.Email != nil && *req.Email != user.Email {
+		// Password reset links go to the email address
+		if err := authorize(ctx, PermUsersSecurity); err != nil {
+			return nil, err
+		}
 		// Check if email is already taken by another user
 		existingUser, err := s.userRepo.GetByEmail(ctx, *req.Email)
 		if err == nil && existingUser != nil && existingUser.ID != id {
@@ -129,18 +195,36 @@ func (s *userService) UpdateUser(ctx context.Context, id uint, req *dto.UpdateUs
 	if req.Enabled != nil {
 		user.Enabled = *req.Enabled
 	}
+	if req.Role != nil && model.Role(*req.Role) != user.Role {
+		if err := authorize(ctx, PermUsersSecurity); err != nil {
+			return nil, err
+		}
+		role := model.Role(*req.Role)
+		if !role.Valid() {
+			return nil, ErrInvalidRole
+		}
+		// An admin demoting themselves could leave nobody able to manage roles
+		if actorID := ActorFromContext(ctx); actorID != nil && *actorID == id {
+			return nil, ErrOwnRoleChange
+		}
+		user.Role = role
+	}
 
 	if err := s.userRepo.Update(ctx, user); err != nil {
 		return nil, fmt.Errorf("failed to update user: %w", err)
 	}
 
-	s.audit.Log(ctx, nil, AuditUserUpdated, "user", fmt.Sprint(user.ID), map[string]any{"email": user.Email, "enabled": user.Enabled})
+	s.audit.Log(ctx, nil, AuditUserUpdated, "user", fmt.Sprint(user.ID), map[string]any{"email": user.Email, "enabled": user.Enabled, "role": user.Role})
 
 	return s.toUserResponse(user), nil
 }
 
 // DeleteUser deletes a user
 func (s *userService) DeleteUser(ctx context.Context, id uint) error {
+	if err := authorize(ctx, PermUsersWrite); err != nil {
+		return err
+	}
+
 	// Check if user exists
 	_, err := s.userRepo.GetByID(ctx, id)
 	if err != nil {
@@ -160,7 +244,11 @@ func (s *userService) DeleteUser(ctx context.Context, id uint) error {
 }
 
 // GetUserFeatureFlags retrieves all feature flags for a user
-func (s *userService) GetUserFeatureFlags(ctx context.Context, userID uint) ([]dto.FeatureFlagResponse, error) {
+func (s *userService) GetUserFeatureFlags(ctx context.Context, userID uint) ([]dto.UserFeatureFlagResponse, error) {
+	if err := authorize(ctx, PermUsersRead); err != nil {
+		return nil, err
+	}
+
 	// Check if user exists
 	_, err := s.userRepo.GetByID(ctx, userID)
 	if err != nil {
@@ -175,15 +263,20 @@ func (s *userService) GetUserFeatureFlags(ctx context.Context, userID uint) ([]d
 		return nil, fmt.Errorf("failed to get user feature flags: %w", err)
 	}
 
-	responses := make([]dto.FeatureFlagResponse, len(flags))
+	assignments, err := s.userFFRepo.GetUserAssignments(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user feature flags: %w", err)
+	}
+	variants := make(map[uint]string, len(assignments))
+	for _, a := range assignments {
+		variants[a.FeatureFlagID] = a.Variant
+	}
+
+	responses := make([]dto.UserFeatureFlagResponse, len(flags))
 	for i, flag := range flags {
-		responses[i] = dto.FeatureFlagResponse{
-			ID:          flag.ID,
-			Key:         flag.Key,
-			Description: flag.Description,
-			Enabled:     flag.Enabled,
-			CreatedAt:   flag.CreatedAt,
-			UpdatedAt:   flag.UpdatedAt,
+		responses[i] = dto.UserFeatureFlagResponse{
+			FeatureFlagResponse: *toFeatureFlagResponse(&flag),
+			AssignedVariant:     variants[flag.ID],
 		}
 	}
 
@@ -191,7 +284,11 @@ func (s *userService) GetUserFeatureFlags(ctx context.Context, userID uint) ([]d
 }
 
 // AssignFeatureFlagToUser assigns a feature flag to a user
-func (s *userService) AssignFeatureFlagToUser(ctx context.Context, userID uint, featureFlagKey string) error {
+func (s *userService) AssignFeatureFlagToUser(ctx context.Context, userID uint, featureFlagKey string, variant string) error {
+	if err := authorize(ctx, PermFlagsWrite); err != nil {
+		return err
+	}
+
 	// Check if user exists
 	_, err := s.userRepo.GetByID(ctx, userID)
 	if err != nil {
@@ -210,27 +307,40 @@ func (s *userService) AssignFeatureFlagToUser(ctx context.Context, userID uint,
 		return fmt.Errorf("failed to get feature flag: %w", err)
 	}
 
+	if variant != "" && findVariant(flag, variant) == nil {
+		return fmt.Errorf("%w: %q is not a variant of %s", ErrInvalidVariants, variant, flag.Key)
+	}
+
 	// Check if already assigned
-	isAssigned, err := s.userFFRepo.IsFeatureFlagAssignedToUser(ctx, userID, flag.ID)
-	if err != nil {
+	existing, err := s.userFFRepo.GetAssignment(ctx, userID, flag.ID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		return fmt.Errorf("failed to check assignment: %w", err)
 	}
-	if isAssigned {
+	if existing != nil && existing.Variant == variant {
 		return errors.New("feature flag already assigned to user")
 	}
 
-	// Assign
-	if err := s.userFFRepo.AssignFeatureFlagToUser(ctx, userID, flag.ID); err != nil {
+	// Assign (or re-pin the variant of an existing assignment)
+	if err := s.userFFRepo.AssignFeatureFlagToUser(ctx, userID, flag.ID, variant); err != nil {
 		return fmt.Errorf("failed to assign feature flag: %w", err)
 	}
+	s.flagChanges.AssignmentChanged(ctx, userID, flag.Key)
 
-	s.audit.Log(ctx, nil, AuditUserFlagAssigned, "user_feature_flag", flag.Key, map[string]any{"user_id": userID})
+	details := map[string]any{"user_id": userID}
+	if variant != "" {
+		details["variant"] = variant
+	}
+	s.audit.Log(ctx, nil, AuditUserFlagAssigned, "user_feature_flag", flag.Key, details)
 
 	return nil
 }
 
 // UnassignFeatureFlagFromUser removes a feature flag from a user
 func (s *userService) UnassignFeatureFlagFromUser(ctx context.Context, userID uint, featureFlagKey string) error {
+	if err := authorize(ctx, PermFlagsWrite); err != nil {
+		return err
+	}
+
 	// Check if user exists
 	_, err := s.userRepo.GetByID(ctx, userID)
 	if err != nil {
@@ -253,6 +363,7 @@ func (s *userService) UnassignFeatureFlagFromUser(ctx context.Context, userID ui
 	if err := s.userFFRepo.UnassignFeatureFlagFromUser(ctx, userID, flag.ID); err != nil {
 		return fmt.Errorf("failed to unassign feature flag: %w", err)
 	}
+	s.flagChanges.AssignmentChanged(ctx, userID, flag.Key)
 
 	s.audit.Log(ctx, nil, AuditUserFlagRemoved, "user_feature_flag", flag.Key, map[string]any{"user_id": userID})
 
@@ -267,12 +378,14 @@ func (s *userService) toUserResponse(user *model.User) *dto.UserResponse {
 	}
 
 	return &dto.UserResponse{
-		ID:        user.ID,
-		Name:      user.Name,
-		Email:     user.Email,
-		Enabled:   user.Enabled,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
-		LastLogin: lastLogin,
+		ID:          user.ID,
+		Name:        user.Name,
+		Email:       user.Email,
+		Enabled:     user.Enabled,
+		Role:        string(user.Role),
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		LastLogin:   lastLogin,
+		TOTPEnabled: user.TOTPEnabled,
 	}
 }
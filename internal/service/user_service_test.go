@@ -2,8 +2,11 @@ package service
 
 import (
 	"context"
+	"errors"
 	"identity/internal/model"
 	"identity/internal/service/dto"
+	"identity/pkg/api"
+	"sort"
 	"testing"
 	"time"
 
@@ -63,6 +66,34 @@ func (m *mockUserRepository) Update(ctx context.Context, user *model.User) error
 	return nil
 }
 
+func (m *mockUserRepository) AdvanceTOTPStep(ctx context.Context, id uint, step int64) (bool, error) {
+	user, exists := m.users[id]
+	if !exists || user.TOTPLastStep >= step {
+		return false, nil
+	}
+	user.TOTPLastStep = step
+	return true, nil
+}
+
+func (m *mockUserRepository) ListTOTPSecrets(ctx context.Context) ([]model.User, error) {
+	var users []model.User
+	for _, user := range m.users {
+		if user.TOTPSecret != "" {
+			users = append(users, model.User{ID: user.ID, TOTPSecret: user.TOTPSecret})
+		}
+	}
+	return users, nil
+}
+
+func (m *mockUserRepository) ReplaceTOTPSecret(ctx context.Context, id uint, old, secret string) (bool, error) {
+	user, exists := m.users[id]
+	if !exists || user.TOTPSecret != old {
+		return false, nil
+	}
+	user.TOTPSecret = secret
+	return true, nil
+}
+
 func (m *mockUserRepository) Delete(ctx context.Context, id uint) error {
 	if _, exists := m.users[id]; !exists {
 		return gorm.ErrRecordNotFound
@@ -73,6 +104,18 @@ func (m *mockUserRepository) Delete(ctx context.Context, id uint) error {
 
 type mockFeatureFlagRepository struct {
 	flags map[uint]*model.FeatureFlag
+	// unlockedWrites counts writes not made with the ctx RunLocked passes
+	// on, which would run outside the transaction holding the lock
+	unlockedWrites int
+}
+
+// mockLockedKey marks the ctx the mock's RunLocked passes on
+type mockLockedKey struct{}
+
+func (m *mockFeatureFlagRepository) countWrite(ctx context.Context) {
+	if ctx.Value(mockLockedKey{}) == nil {
+		m.unlockedWrites++
+	}
 }
 
 func newMockFeatureFlagRepository() *mockFeatureFlagRepository {
@@ -82,6 +125,7 @@ func newMockFeatureFlagRepository() *mockFeatureFlagRepository {
 }
 
 func (m *mockFeatureFlagRepository) Create(ctx context.Context, flag *model.FeatureFlag) error {
+	m.countWrite(ctx)
 	flag.ID = uint(len(m.flags) + 1)
 	m.flags[flag.ID] = flag
 	return nil
@@ -92,7 +136,8 @@ func (m *mockFeatureFlagRepository) GetByID(ctx context.Context, id uint) (*mode
 	if !exists {
 		return nil, gorm.ErrRecordNotFound
 	}
-	return flag, nil
+	copied := *flag
+	return &copied, nil
 }
 
 func (m *mockFeatureFlagRepository) GetByKey(ctx context.Context, key string) (*model.FeatureFlag, error) {
@@ -112,7 +157,32 @@ func (m *mockFeatureFlagRepository) GetAll(ctx context.Context, limit, offset in
 	return flags, int64(len(flags)), nil
 }
 
+func (m *mockFeatureFlagRepository) GetByKeys(ctx context.Context, keys []string) ([]model.FeatureFlag, error) {
+	wanted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	var flags []model.FeatureFlag
+	for _, flag := range m.flags {
+		if len(keys) == 0 || wanted[flag.Key] {
+			flags = append(flags, *flag)
+		}
+	}
+	return flags, nil
+}
+
+func (m *mockFeatureFlagRepository) GetWithPrerequisites(ctx context.Context) ([]model.FeatureFlag, error) {
+	var flags []model.FeatureFlag
+	for _, flag := range m.flags {
+		if len(flag.Prerequisites) > 0 {
+			flags = append(flags, model.FeatureFlag{Key: flag.Key, Prerequisites: flag.Prerequisites})
+		}
+	}
+	return flags, nil
+}
+
 func (m *mockFeatureFlagRepository) Update(ctx context.Context, flag *model.FeatureFlag) error {
+	m.countWrite(ctx)
 	if _, exists := m.flags[flag.ID]; !exists {
 		return gorm.ErrRecordNotFound
 	}
@@ -121,6 +191,7 @@ func (m *mockFeatureFlagRepository) Update(ctx context.Context, flag *model.Feat
 }
 
 func (m *mockFeatureFlagRepository) Delete(ctx context.Context, id uint) error {
+	m.countWrite(ctx)
 	if _, exists := m.flags[id]; !exists {
 		return gorm.ErrRecordNotFound
 	}
@@ -128,19 +199,25 @@ func (m *mockFeatureFlagRepository) Delete(ctx context.Context, id uint) error {
 	return nil
 }
 
+func (m *mockFeatureFlagRepository) RunLocked(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(context.WithValue(ctx, mockLockedKey{}, true))
+}
+
 type mockUserFeatureFlagRepository struct {
-	assignments map[string]bool
+	assignments map[string]*model.UserFeatureFlag
+	// userLoads counts GetUserAssignments calls
+	userLoads int
 }
 
 func newMockUserFeatureFlagRepository() *mockUserFeatureFlagRepository {
 	return &mockUserFeatureFlagRepository{
-		assignments: make(map[string]bool),
+		assignments: make(map[string]*model.UserFeatureFlag),
 	}
 }
 
-func (m *mockUserFeatureFlagRepository) AssignFeatureFlagToUser(ctx context.Context, userID uint, featureFlagID uint) error {
+func (m *mockUserFeatureFlagRepository) AssignFeatureFlagToUser(ctx context.Context, userID uint, featureFlagID uint, variant string) error {
 	key := m.key(userID, featureFlagID)
-	m.assignments[key] = true
+	m.assignments[key] = &model.UserFeatureFlag{UserID: userID, FeatureFlagID: featureFlagID, Variant: variant}
 	return nil
 }
 
@@ -154,25 +231,59 @@ func (m *mockUserFeatureFlagRepository) GetUserFeatureFlags(ctx context.Context,
 	return []model.FeatureFlag{}, nil
 }
 
+func (m *mockUserFeatureFlagRepository) GetUserAssignments(ctx context.Context, userID uint) ([]model.UserFeatureFlag, error) {
+	m.userLoads++
+	var assignments []model.UserFeatureFlag
+	for _, a := range m.assignments {
+		if a.UserID == userID {
+			assignments = append(assignments, *a)
+		}
+	}
+	return assignments, nil
+}
+
+func (m *mockUserFeatureFlagRepository) GetAllAssignments(ctx context.Context) ([]model.UserFeatureFlag, error) {
+	var assignments []model.UserFeatureFlag
+	for _, a := range m.assignments {
+		assignments = append(assignments, *a)
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		if assignments[i].UserID != assignments[j].UserID {
+			return assignments[i].UserID < assignments[j].UserID
+		}
+		return assignments[i].FeatureFlagID < assignments[j].FeatureFlagID
+	})
+	return assignments, nil
+}
+
 func (m *mockUserFeatureFlagRepository) GetFeatureFlagUsers(ctx context.Context, featureFlagID uint) ([]model.User, error) {
 	return []model.User{}, nil
 }
 
-func (m *mockUserFeatureFlagRepository) IsFeatureFlagAssignedToUser(ctx context.Context, userID uint, featureFlagID uint) (bool, error) {
-	key := m.key(userID, featureFlagID)
-	return m.assignments[key], nil
+func (m *mockUserFeatureFlagRepository) GetAssignment(ctx context.Context, userID uint, featureFlagID uint) (*model.UserFeatureFlag, error) {
+	assignment, exists := m.assignments[m.key(userID, featureFlagID)]
+	if !exists {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return assignment, nil
 }
 
 func (m *mockUserFeatureFlagRepository) key(userID, featureFlagID uint) string {
 	return string(rune(userID)) + "-" + string(rune(featureFlagID))
 }
 
+// adminContext returns a context acting as an admin, as set by the auth middleware
+func adminContext() context.Context {
+	ctx := WithActor(context.Background(), 1)
+	return WithActorRole(ctx, model.RoleAdmin)
+}
+
 // Tests
 func TestUserService_CreateUser(t *testing.T) {
 	userRepo := newMockUserRepository()
 	featureFlagRepo := newMockFeatureFlagRepository()
 	userFFRepo := newMockUserFeatureFlagRepository()
-	svc := NewUserService(userRepo, featureFlagRepo, userFFRepo, newNoopAudit())
+	svc := NewUserService(userRepo, featureFlagRepo, userFFRepo, nil, newNoopAudit())
 
 	tests := []struct {
 		name    string
@@ -201,7 +312,7 @@ func TestUserService_CreateUser(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			user, err := svc.CreateUser(context.Background(), tt.req)
+			user, err := svc.CreateUser(adminContext(), tt.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("CreateUser() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -217,7 +328,7 @@ func TestUserService_GetUser(t *testing.T) {
 	userRepo := newMockUserRepository()
 	featureFlagRepo := newMockFeatureFlagRepository()
 	userFFRepo := newMockUserFeatureFlagRepository()
-	svc := NewUserService(userRepo, featureFlagRepo, userFFRepo, newNoopAudit())
+	svc := NewUserService(userRepo, featureFlagRepo, userFFRepo, nil, newNoopAudit())
 
 	// Create a test user
 	createReq := &dto.CreateUserRequest{
@@ -225,7 +336,7 @@ func TestUserService_GetUser(t *testing.T) {
 		Email:   "john@example.com",
 		Enabled: true,
 	}
-	created, _ := svc.CreateUser(context.Background(), createReq)
+	created, _ := svc.CreateUser(adminContext(), createReq)
 
 	tests := []struct {
 		name    string
@@ -246,7 +357,7 @@ func TestUserService_GetUser(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			user, err := svc.GetUser(context.Background(), tt.id)
+			user, err := svc.GetUser(adminContext(), tt.id)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetUser() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -262,7 +373,7 @@ func TestUserService_UpdateUser(t *testing.T) {
 	userRepo := newMockUserRepository()
 	featureFlagRepo := newMockFeatureFlagRepository()
 	userFFRepo := newMockUserFeatureFlagRepository()
-	svc := NewUserService(userRepo, featureFlagRepo, userFFRepo, newNoopAudit())
+	svc := NewUserService(userRepo, featureFlagRepo, userFFRepo, nil, newNoopAudit())
 
 	// Create a test user
 	createReq := &dto.CreateUserRequest{
@@ -270,14 +381,14 @@ func TestUserService_UpdateUser(t *testing.T) {
 		Email:   "john@example.com",
 		Enabled: true,
 	}
-	created, _ := svc.CreateUser(context.Background(), createReq)
+	created, _ := svc.CreateUser(adminContext(), createReq)
 
 	newName := "Jane Doe"
 	updateReq := &dto.UpdateUserRequest{
 		Name: &newName,
 	}
 
-	updated, err := svc.UpdateUser(context.Background(), created.ID, updateReq)
+	updated, err := svc.UpdateUser(adminContext(), created.ID, updateReq)
 	if err != nil {
 		t.Errorf("UpdateUser() error = %v", err)
 		return
@@ -292,7 +403,7 @@ func TestUserService_DeleteUser(t *testing.T) {
 	userRepo := newMockUserRepository()
 	featureFlagRepo := newMockFeatureFlagRepository()
 	userFFRepo := newMockUserFeatureFlagRepository()
-	svc := NewUserService(userRepo, featureFlagRepo, userFFRepo, newNoopAudit())
+	svc := NewUserService(userRepo, featureFlagRepo, userFFRepo, nil, newNoopAudit())
 
 	// Create a test user
 	createReq := &dto.CreateUserRequest{
@@ -300,18 +411,127 @@ func TestUserService_DeleteUser(t *testing.T) {
 		Email:   "john@example.com",
 		Enabled: true,
 	}
-	created, _ := svc.CreateUser(context.Background(), createReq)
+	created, _ := svc.CreateUser(adminContext(), createReq)
 
 	// Delete user
-	err := svc.DeleteUser(context.Background(), created.ID)
+	err := svc.DeleteUser(adminContext(), created.ID)
 	if err != nil {
 		t.Errorf("DeleteUser() error = %v", err)
 		return
 	}
 
 	// Verify user is deleted
-	_, err = svc.GetUser(context.Background(), created.ID)
+	_, err = svc.GetUser(adminContext(), created.ID)
 	if err == nil {
 		t.Error("DeleteUser() user still exists")
 	}
 }
+
+func TestUserService_EnforcesPermissions(t *testing.T) {
+	userRepo := newMockUserRepository()
+	featureFlagRepo := newMockFeatureFlagRepository()
+	userFFRepo := newMockUserFeatureFlagRepository()
+	svc := NewUserService(userRepo, featureFlagRepo, userFFRepo, nil, newNoopAudit())
+
+	created, err := svc.CreateUser(adminContext(), &dto.CreateUserRequest{
+		Name:    "John Doe",
+		Email:   "john@example.com",
+		Enabled: true,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser() as admin error = %v", err)
+	}
+
+	viewer := WithActorRole(WithActor(context.Background(), 2), model.RoleViewer)
+	if _, err := svc.GetUser(viewer, created.ID); err != nil {
+		t.Errorf("GetUser() as viewer error = %v, want nil", err)
+	}
+	if err := svc.DeleteUser(viewer, created.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("DeleteUser() as viewer error = %v, want ErrForbidden", err)
+	}
+
+	endUser := WithActorRole(WithActor(context.Background(), 3), model.RoleUser)
+	if _, err := svc.GetUsers(endUser, &dto.PaginationParams{}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("GetUsers() as end user error = %v, want ErrForbidden", err)
+	}
+
+	if _, err := svc.GetUser(context.Background(), created.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("GetUser() without actor error = %v, want ErrForbidden", err)
+	}
+
+	// The public projection stays reachable without any actor
+	if _, err := svc.GetPublicUser(context.Background(), created.ID); err != nil {
+		t.Errorf("GetPublicUser() error = %v, want nil", err)
+	}
+}
+
+func TestUserService_UpdateRole(t *testing.T) {
+	userRepo := newMockUserRepository()
+	featureFlagRepo := newMockFeatureFlagRepository()
+	userFFRepo := newMockUserFeatureFlagRepository()
+	svc := NewUserService(userRepo, featureFlagRepo, userFFRepo, nil, newNoopAudit())
+
+	// The acting admin is user #1
+	admin, _ := svc.CreateUser(adminContext(), &dto.CreateUserRequest{Name: "Admin", Email: "admin@example.com", Role: "admin"})
+	other, _ := svc.CreateUser(adminContext(), &dto.CreateUserRequest{Name: "Jane", Email: "jane@example.com"})
+	if other.Role != string(model.RoleUser) {
+		t.Fatalf("CreateUser() default role = %v, want %v", other.Role, model.RoleUser)
+	}
+
+	editor := string(model.RoleFlagEditor)
+	updated, err := svc.UpdateUser(adminContext(), other.ID, &dto.UpdateUserRequest{Role: &editor})
+	if err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+	if updated.Role != editor {
+		t.Errorf("UpdateUser() role = %v, want %v", updated.Role, editor)
+	}
+
+	viewer := string(model.RoleViewer)
+	if _, err := svc.UpdateUser(adminContext(), admin.ID, &dto.UpdateUserRequest{Role: &viewer}); !errors.Is(err, ErrOwnRoleChange) {
+		t.Errorf("UpdateUser() own role error = %v, want %v", err, ErrOwnRoleChange)
+	}
+
+	bogus := "superuser"
+	if _, err := svc.UpdateUser(adminContext(), other.ID, &dto.UpdateUserRequest{Role: &bogus}); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("UpdateUser() unknown role error = %v, want %v", err, ErrInvalidRole)
+	}
+}
+
+func TestUserService_AssignFeatureFlagVariant(t *testing.T) {
+	userRepo := newMockUserRepository()
+	featureFlagRepo := newMockFeatureFlagRepository()
+	userFFRepo := newMockUserFeatureFlagRepository()
+	svc := NewUserService(userRepo, featureFlagRepo, userFFRepo, nil, newNoopAudit())
+
+	user, _ := svc.CreateUser(adminContext(), &dto.CreateUserRequest{Name: "Jane", Email: "jane@example.com"})
+	flag := &model.FeatureFlag{
+		Key:         "pricing-layout",
+		VariantType: api.VariantTypeString,
+		Variants: []model.FlagVariant{
+			{Key: "classic", Value: []byte(`"classic"`), Weight: 50},
+			{Key: "compact", Value: []byte(`"compact"`), Weight: 50},
+		},
+	}
+	_ = featureFlagRepo.Create(context.Background(), flag)
+
+	if err := svc.AssignFeatureFlagToUser(adminContext(), user.ID, flag.Key, "gold"); !errors.Is(err, ErrInvalidVariants) {
+		t.Errorf("AssignFeatureFlagToUser() with unknown variant error = %v, want ErrInvalidVariants", err)
+	}
+
+	if err := svc.AssignFeatureFlagToUser(adminContext(), user.ID, flag.Key, "classic"); err != nil {
+		t.Fatalf("AssignFeatureFlagToUser() error = %v", err)
+	}
+	if err := svc.AssignFeatureFlagToUser(adminContext(), user.ID, flag.Key, "classic"); err == nil {
+		t.Error("AssignFeatureFlagToUser() accepted the same assignment twice")
+	}
+
+	// Re-assigning with another variant re-pins it
+	if err := svc.AssignFeatureFlagToUser(adminContext(), user.ID, flag.Key, "compact"); err != nil {
+		t.Fatalf("AssignFeatureFlagToUser() re-pin error = %v", err)
+	}
+	assignment, err := userFFRepo.GetAssignment(context.Background(), user.ID, flag.ID)
+	if err != nil || assignment.Variant != "compact" {
+		t.Errorf("assignment = %+v, %v, want variant compact", assignment, err)
+	}
+}
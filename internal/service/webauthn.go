@@ -0,0 +1,580 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/gorm"
+)
+
+// webAuthnCeremonyTTL is how long a browser has to answer a WebAuthn
+// challenge, the same as for entering a two-factor code
+const webAuthnCeremonyTTL = loginChallengeTTL
+
+var (
+	// ErrInvalidWebAuthnCeremony is returned for unknown, expired or already
+	// answered WebAuthn ceremonies
+	ErrInvalidWebAuthnCeremony = errors.New("webauthn ceremony is invalid or expired")
+	// ErrWebAuthnVerification is wrapped by errors from checking an
+	// authenticator's answer
+	ErrWebAuthnVerification = errors.New("webauthn verification failed")
+	// ErrNoWebAuthnCredentials is returned when asking for a security key
+	// from a user who has none
+	ErrNoWebAuthnCredentials = errors.New("no security keys registered")
+)
+
+// WebAuthnConfig identifies this service (the relying party) to
+// authenticators
+type WebAuthnConfig struct {
+	// RPID is the domain credentials are bound to, e.g. "example.com"; they
+	// work on it and its subdomains
+	RPID string
+	// RPName is the name browsers show when asking for a passkey
+	RPName string
+	// RPOrigins are the origins the browser may run the ceremony from, e.g.
+	// "https://admin.example.com"
+	RPOrigins []string
+}
+
+// NewWebAuthn creates the WebAuthn relying party. Registration asks for a
+// passkey (a discoverable credential) where the authenticator supports it,
+// so the same credential can sign in without a password.
+func NewWebAuthn(cfg WebAuthnConfig) (*webauthn.WebAuthn, error) {
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnCeremonyTTL, TimeoutUVD: webAuthnCeremonyTTL}
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPName,
+		RPOrigins:     cfg.RPOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+		Timeouts: webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+}
+
+// webAuthnUser presents a user and their credentials to the WebAuthn library
+type webAuthnUser struct {
+	user        *model.User
+	credentials []model.WebAuthnCredential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte          { return webAuthnUserHandle(u.user.ID) }
+func (u *webAuthnUser) WebAuthnName() string        { return u.user.Email }
+func (u *webAuthnUser) WebAuthnDisplayName() string { return u.user.Name }
+func (u *webAuthnUser) WebAuthnIcon() string        { return "" }
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.credentials))
+	for i, c := range u.credentials {
+		credentials[i] = webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       splitTransports(c.Transports),
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		}
+	}
+	return credentials
+}
+
+// credential returns the stored credential the library verified
+func (u *webAuthnUser) credential(id []byte) *model.WebAuthnCredential {
+	for i := range u.credentials {
+		if bytes.Equal(u.credentials[i].CredentialID, id) {
+			return &u.credentials[i]
+		}
+	}
+	return nil
+}
+
+// descriptors lists the user's credentials, so registration doesn't add an
+// authenticator twice
+func (u *webAuthnUser) descriptors() []protocol.CredentialDescriptor {
+	descriptors := make([]protocol.CredentialDescriptor, 0, len(u.credentials))
+	for _, c := range u.WebAuthnCredentials() {
+		descriptors = append(descriptors, c.Descriptor())
+	}
+	return descriptors
+}
+
+// BeginWebAuthnRegistration starts registering a passkey or security key for
+// a logged-in user
+func (s *authService) BeginWebAuthnRegistration(ctx context.Context, userID uint) (*dto.WebAuthnOptionsResponse, error) {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	waUser, err := s.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	creation, session, err := s.webAuthn.BeginRegistration(waUser, webauthn.WithExclusions(waUser.descriptors()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin webauthn registration: %w", err)
+	}
+	token, err := s.startWebAuthnCeremony(ctx, model.WebAuthnCeremonyRegistration, &user.ID, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.WebAuthnOptionsResponse{CeremonyToken: token, Options: creation}, nil
+}
+
+// FinishWebAuthnRegistration checks the authenticator's answer to a
+// registration and saves the new credential
+func (s *authService) FinishWebAuthnRegistration(ctx context.Context, userID uint, req *dto.FinishWebAuthnRegistrationRequest) (*dto.WebAuthnCredentialResponse, error) {
+	ceremony, session, err := s.takeWebAuthnCeremony(ctx, req.CeremonyToken, model.WebAuthnCeremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if ceremony.UserID == nil || *ceremony.UserID != userID {
+		return nil, ErrInvalidWebAuthnCeremony
+	}
+
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	waUser, err := s.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		return nil, webAuthnError(err)
+	}
+	created, err := s.webAuthn.CreateCredential(waUser, *session, parsed)
+	if err != nil {
+		s.audit.Log(ctx, &user.ID, AuditWebAuthnFailed, "user", fmt.Sprint(user.ID), map[string]any{"reason": "registration"})
+		return nil, webAuthnError(err)
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Security key"
+		if created.Flags.BackupEligible {
+			name = "Passkey"
+		}
+	}
+	transports := make([]string, len(created.Transport))
+	for i, t := range created.Transport {
+		transports[i] = string(t)
+	}
+	credential := &model.WebAuthnCredential{
+		UserID:          user.ID,
+		Name:            name,
+		CredentialID:    created.ID,
+		PublicKey:       created.PublicKey,
+		AttestationType: created.AttestationType,
+		AAGUID:          created.Authenticator.AAGUID,
+		Transports:      strings.Join(transports, ","),
+		SignCount:       created.Authenticator.SignCount,
+		BackupEligible:  created.Flags.BackupEligible,
+		BackupState:     created.Flags.BackupState,
+	}
+	if err := s.credentialRepo.Create(ctx, credential); err != nil {
+		return nil, fmt.Errorf("failed to save webauthn credential: %w", err)
+	}
+
+	s.audit.Log(ctx, &user.ID, AuditWebAuthnRegistered, "user", fmt.Sprint(user.ID), map[string]any{"credential_id": credential.ID, "name": credential.Name})
+
+	return toWebAuthnCredentialResponse(credential), nil
+}
+
+// GetWebAuthnCredentials lists the passkeys and security keys of a
+// logged-in user
+func (s *authService) GetWebAuthnCredentials(ctx context.Context, userID uint) ([]dto.WebAuthnCredentialResponse, error) {
+	credentials, err := s.credentialRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webauthn credentials: %w", err)
+	}
+
+	responses := make([]dto.WebAuthnCredentialResponse, len(credentials))
+	for i := range credentials {
+		responses[i] = *toWebAuthnCredentialResponse(&credentials[i])
+	}
+	return responses, nil
+}
+
+// GetUserWebAuthnCredentials lists the passkeys and security keys of any
+// user (admin action)
+func (s *authService) GetUserWebAuthnCredentials(ctx context.Context, userID uint) ([]dto.WebAuthnCredentialResponse, error) {
+	if err := authorize(ctx, PermUsersRead); err != nil {
+		return nil, err
+	}
+	if _, err := s.getUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return s.GetWebAuthnCredentials(ctx, userID)
+}
+
+// DeleteWebAuthnCredential removes a passkey or security key of a logged-in
+// user. Users whose role requires two-factor authentication can't remove
+// their last one unless they have TOTP set up.
+func (s *authService) DeleteWebAuthnCredential(ctx context.Context, userID, credentialID uint) error {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if s.twoFactorRequired(user) && !user.TOTPEnabled {
+		count, err := s.credentialRepo.CountByUserID(ctx, user.ID)
+		if err != nil {
+			return fmt.Errorf("failed to count webauthn credentials: %w", err)
+		}
+		if count <= 1 {
+			return ErrTOTPRequired
+		}
+	}
+
+	if err := s.deleteWebAuthnCredential(ctx, userID, credentialID); err != nil {
+		return err
+	}
+	s.audit.Log(ctx, &user.ID, AuditWebAuthnRemoved, "user", fmt.Sprint(userID), map[string]any{"credential_id": credentialID})
+
+	return nil
+}
+
+// RevokeWebAuthnCredential removes a passkey or security key of any user,
+// e.g. a lost one (admin action)
+func (s *authService) RevokeWebAuthnCredential(ctx context.Context, userID, credentialID uint) error {
+	if err := authorize(ctx, PermUsersSecurity); err != nil {
+		return err
+	}
+
+	if err := s.deleteWebAuthnCredential(ctx, userID, credentialID); err != nil {
+		return err
+	}
+	s.audit.Log(ctx, nil, AuditWebAuthnRemoved, "user", fmt.Sprint(userID), map[string]any{"credential_id": credentialID, "revoked": true})
+
+	return nil
+}
+
+// BeginPasskeyLogin starts a passwordless login. Any passkey may answer; the
+// user is whoever it belongs to.
+func (s *authService) BeginPasskeyLogin(ctx context.Context) (*dto.WebAuthnOptionsResponse, error) {
+	// The passkey stands in for both password and second factor, so the
+	// authenticator must verify the user (PIN, biometrics)
+	assertion, session, err := s.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey login: %w", err)
+	}
+	token, err := s.startWebAuthnCeremony(ctx, model.WebAuthnCeremonyLogin, nil, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.WebAuthnOptionsResponse{CeremonyToken: token, Options: assertion}, nil
+}
+
+// FinishPasskeyLogin checks a passkey's answer and creates the session. No
+// password or two-factor code is asked for: the passkey proves possession
+// and its user verification the rest.
+func (s *authService) FinishPasskeyLogin(ctx context.Context, req *dto.FinishPasskeyLoginRequest) (*dto.LoginResponse, error) {
+	_, session, err := s.takeWebAuthnCeremony(ctx, req.CeremonyToken, model.WebAuthnCeremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		return nil, webAuthnError(err)
+	}
+
+	var waUser *webAuthnUser
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, ok := parseWebAuthnUserHandle(userHandle)
+		if !ok {
+			return nil, errors.New("unknown user handle")
+		}
+		user, err := s.getUser(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if waUser, err = s.loadWebAuthnUser(ctx, user); err != nil {
+			return nil, err
+		}
+		return waUser, nil
+	}
+	verified, err := s.webAuthn.ValidateDiscoverableLogin(findUser, *session, parsed)
+	if err != nil {
+		details := map[string]any{"method": "passkey", "reason": "invalid passkey"}
+		targetID := ""
+		if waUser != nil {
+			details["email"] = waUser.user.Email
+			targetID = fmt.Sprint(waUser.user.ID)
+		}
+		s.audit.Log(ctx, nil, AuditLoginFailed, "user", targetID, details)
+		return nil, webAuthnError(err)
+	}
+	user := waUser.user
+
+	if !user.Enabled {
+		s.audit.Log(ctx, nil, AuditLoginFailed, "user", fmt.Sprint(user.ID), map[string]any{"email": user.Email, "method": "passkey", "reason": "account disabled"})
+		return nil, errors.New("user account is disabled")
+	}
+	if err := s.recordWebAuthnUse(ctx, waUser, verified); err != nil {
+		return nil, err
+	}
+
+	return s.createSession(ctx, user, map[string]any{"method": "passkey"})
+}
+
+// BeginLoginWebAuthn starts the security key step of a login challenge, as
+// an alternative to entering a code
+func (s *authService) BeginLoginWebAuthn(ctx context.Context, challengeToken string) (*dto.WebAuthnOptionsResponse, error) {
+	challenge, err := s.getLoginChallenge(ctx, challengeToken)
+	if err != nil {
+		return nil, err
+	}
+	waUser, err := s.loadWebAuthnUser(ctx, &challenge.User)
+	if err != nil {
+		return nil, err
+	}
+	if len(waUser.credentials) == 0 {
+		return nil, ErrNoWebAuthnCredentials
+	}
+
+	assertion, session, err := s.webAuthn.BeginLogin(waUser)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin webauthn login: %w", err)
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode webauthn session: %w", err)
+	}
+	if err := s.challengeRepo.SetWebAuthnSession(ctx, challenge.ID, data); err != nil {
+		return nil, fmt.Errorf("failed to save webauthn session: %w", err)
+	}
+
+	return &dto.WebAuthnOptionsResponse{Options: assertion}, nil
+}
+
+// VerifyLoginWebAuthn completes a login challenge with a security key and
+// creates the session
+func (s *authService) VerifyLoginWebAuthn(ctx context.Context, req *dto.VerifyLoginWebAuthnRequest) (*dto.LoginResponse, error) {
+	challenge, err := s.getLoginChallenge(ctx, req.ChallengeToken)
+	if err != nil {
+		return nil, err
+	}
+	user := &challenge.User
+
+	if err := s.checkLoginLock(ctx, user.Email); err != nil {
+		return nil, err
+	}
+	if err := s.recordLoginAttempt(ctx, challenge); err != nil {
+		return nil, err
+	}
+	if len(challenge.WebAuthnSession) == 0 {
+		return nil, ErrInvalidWebAuthnCeremony
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal(challenge.WebAuthnSession, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode webauthn session: %w", err)
+	}
+
+	waUser, err := s.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		return nil, webAuthnError(err)
+	}
+	verified, err := s.webAuthn.ValidateLogin(waUser, session, parsed)
+	if err != nil {
+		s.audit.Log(ctx, nil, AuditWebAuthnFailed, "user", fmt.Sprint(user.ID), map[string]any{"email": user.Email, "reason": "login"})
+		if err := s.recordLoginFailure(ctx, user.Email); err != nil {
+			return nil, err
+		}
+		return nil, webAuthnError(err)
+	}
+	if err := s.recordWebAuthnUse(ctx, waUser, verified); err != nil {
+		return nil, err
+	}
+
+	// A parallel request may have passed the challenge already
+	consumed, err := s.challengeRepo.Consume(ctx, challenge.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume login challenge: %w", err)
+	}
+	if !consumed {
+		return nil, ErrInvalidLoginChallenge
+	}
+
+	return s.createSession(ctx, user, map[string]any{"two_factor": "webauthn"})
+}
+
+// loadWebAuthnUser loads a user's credentials
+func (s *authService) loadWebAuthnUser(ctx context.Context, user *model.User) (*webAuthnUser, error) {
+	credentials, err := s.credentialRepo.GetByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webauthn credentials: %w", err)
+	}
+	return &webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+// hasWebAuthnCredentials reports whether a user registered any passkey or
+// security key
+func (s *authService) hasWebAuthnCredentials(ctx context.Context, userID uint) (bool, error) {
+	count, err := s.credentialRepo.CountByUserID(ctx, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to count webauthn credentials: %w", err)
+	}
+	return count > 0, nil
+}
+
+// recordWebAuthnUse stores the signature counter a credential reported on
+// login. A counter that didn't go up suggests a cloned authenticator, so the
+// login is refused.
+func (s *authService) recordWebAuthnUse(ctx context.Context, waUser *webAuthnUser, verified *webauthn.Credential) error {
+	credential := waUser.credential(verified.ID)
+	if credential == nil {
+		return fmt.Errorf("%w: unknown credential", ErrWebAuthnVerification)
+	}
+	if verified.Authenticator.CloneWarning {
+		s.audit.Log(ctx, nil, AuditWebAuthnFailed, "user", fmt.Sprint(credential.UserID), map[string]any{"credential_id": credential.ID, "reason": "signature counter went backwards"})
+		return fmt.Errorf("%w: the security key may have been cloned", ErrWebAuthnVerification)
+	}
+
+	if err := s.credentialRepo.RecordUse(ctx, credential.ID, verified.Authenticator.SignCount, verified.Flags.BackupState, time.Now()); err != nil {
+		return fmt.Errorf("failed to update webauthn credential: %w", err)
+	}
+	return nil
+}
+
+// deleteWebAuthnCredential deletes a credential of a user
+func (s *authService) deleteWebAuthnCredential(ctx context.Context, userID, credentialID uint) error {
+	deleted, err := s.credentialRepo.Delete(ctx, userID, credentialID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webauthn credential: %w", err)
+	}
+	if !deleted {
+		return errors.New("credential not found")
+	}
+	return nil
+}
+
+// startWebAuthnCeremony keeps a ceremony's session data until the browser
+// answers, returning the token to answer with
+func (s *authService) startWebAuthnCeremony(ctx context.Context, kind string, userID *uint, session *webauthn.SessionData) (string, error) {
+	token, err := generateSessionID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate ceremony token: %w", err)
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode webauthn session: %w", err)
+	}
+
+	ceremony := &model.WebAuthnCeremony{
+		ID:          token,
+		Kind:        kind,
+		UserID:      userID,
+		SessionData: data,
+		ExpiresAt:   time.Now().Add(webAuthnCeremonyTTL),
+	}
+	if err := s.ceremonyRepo.Create(ctx, ceremony); err != nil {
+		return "", fmt.Errorf("failed to create webauthn ceremony: %w", err)
+	}
+	return token, nil
+}
+
+// takeWebAuthnCeremony returns a ceremony of the given kind along with its
+// session data, using it up
+func (s *authService) takeWebAuthnCeremony(ctx context.Context, token, kind string) (*model.WebAuthnCeremony, *webauthn.SessionData, error) {
+	if token == "" {
+		return nil, nil, ErrInvalidWebAuthnCeremony
+	}
+
+	ceremony, err := s.ceremonyRepo.Take(ctx, token)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidWebAuthnCeremony
+		}
+		return nil, nil, fmt.Errorf("failed to get webauthn ceremony: %w", err)
+	}
+	if ceremony.Kind != kind || ceremony.IsExpired() {
+		return nil, nil, ErrInvalidWebAuthnCeremony
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(ceremony.SessionData, &session); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode webauthn session: %w", err)
+	}
+	return ceremony, &session, nil
+}
+
+// webAuthnError wraps what the library reports about a bad answer in
+// ErrWebAuthnVerification, keeping its details for the logs
+func webAuthnError(err error) error {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) && protocolErr.DevInfo != "" {
+		return fmt.Errorf("%w: %s: %s", ErrWebAuthnVerification, protocolErr.Details, protocolErr.DevInfo)
+	}
+	return fmt.Errorf("%w: %v", ErrWebAuthnVerification, err)
+}
+
+// webAuthnUserHandle is the user ID authenticators store with a passkey and
+// return on login, identifying the user
+func webAuthnUserHandle(userID uint) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+// parseWebAuthnUserHandle reverses webAuthnUserHandle
+func parseWebAuthnUserHandle(handle []byte) (uint, bool) {
+	if len(handle) != 8 {
+		return 0, false
+	}
+	return uint(binary.BigEndian.Uint64(handle)), true
+}
+
+// splitTransports parses the comma-separated transports of a stored
+// credential
+func splitTransports(transports string) []protocol.AuthenticatorTransport {
+	if transports == "" {
+		return nil
+	}
+	parts := strings.Split(transports, ",")
+	out := make([]protocol.AuthenticatorTransport, len(parts))
+	for i, t := range parts {
+		out[i] = protocol.AuthenticatorTransport(t)
+	}
+	return out
+}
+
+// toWebAuthnCredentialResponse converts a stored credential to its API form
+func toWebAuthnCredentialResponse(credential *model.WebAuthnCredential) *dto.WebAuthnCredentialResponse {
+	var transports []string
+	if credential.Transports != "" {
+		transports = strings.Split(credential.Transports, ",")
+	}
+	return &dto.WebAuthnCredentialResponse{
+		ID:         credential.ID,
+		Name:       credential.Name,
+		Transports: transports,
+		Synced:     credential.BackupState,
+		CreatedAt:  credential.CreatedAt,
+		LastUsedAt: credential.LastUsedAt,
+	}
+}
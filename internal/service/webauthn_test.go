@@ -0,0 +1,412 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"identity/internal/model"
+	"identity/internal/service/dto"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/gorm"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:8080"
+)
+
+// mockWebAuthnCredentialRepository is an in-memory WebAuthnCredentialRepository
+type mockWebAuthnCredentialRepository struct {
+	credentials []model.WebAuthnCredential
+}
+
+func newMockWebAuthnCredentialRepository() *mockWebAuthnCredentialRepository {
+	return &mockWebAuthnCredentialRepository{}
+}
+
+func (m *mockWebAuthnCredentialRepository) Create(ctx context.Context, credential *model.WebAuthnCredential) error {
+	credential.ID = uint(len(m.credentials) + 1)
+	credential.CreatedAt = time.Now()
+	m.credentials = append(m.credentials, *credential)
+	return nil
+}
+
+func (m *mockWebAuthnCredentialRepository) GetByUserID(ctx context.Context, userID uint) ([]model.WebAuthnCredential, error) {
+	var credentials []model.WebAuthnCredential
+	for _, c := range m.credentials {
+		if c.UserID == userID {
+			credentials = append(credentials, c)
+		}
+	}
+	return credentials, nil
+}
+
+func (m *mockWebAuthnCredentialRepository) CountByUserID(ctx context.Context, userID uint) (int64, error) {
+	credentials, _ := m.GetByUserID(ctx, userID)
+	return int64(len(credentials)), nil
+}
+
+func (m *mockWebAuthnCredentialRepository) RecordUse(ctx context.Context, id uint, signCount uint32, backupState bool, usedAt time.Time) error {
+	for i := range m.credentials {
+		if m.credentials[i].ID == id {
+			m.credentials[i].SignCount = signCount
+			m.credentials[i].BackupState = backupState
+			m.credentials[i].LastUsedAt = &usedAt
+		}
+	}
+	return nil
+}
+
+func (m *mockWebAuthnCredentialRepository) Delete(ctx context.Context, userID, id uint) (bool, error) {
+	for i, c := range m.credentials {
+		if c.UserID == userID && c.ID == id {
+			m.credentials = slices.Delete(m.credentials, i, i+1)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// mockWebAuthnCeremonyRepository is an in-memory WebAuthnCeremonyRepository
+type mockWebAuthnCeremonyRepository struct {
+	ceremonies map[string]*model.WebAuthnCeremony
+}
+
+func newMockWebAuthnCeremonyRepository() *mockWebAuthnCeremonyRepository {
+	return &mockWebAuthnCeremonyRepository{ceremonies: make(map[string]*model.WebAuthnCeremony)}
+}
+
+func (m *mockWebAuthnCeremonyRepository) Create(ctx context.Context, ceremony *model.WebAuthnCeremony) error {
+	m.ceremonies[ceremony.ID] = ceremony
+	return nil
+}
+
+func (m *mockWebAuthnCeremonyRepository) Take(ctx context.Context, id string) (*model.WebAuthnCeremony, error) {
+	ceremony, exists := m.ceremonies[id]
+	if !exists {
+		return nil, gorm.ErrRecordNotFound
+	}
+	delete(m.ceremonies, id)
+	return ceremony, nil
+}
+
+func newTestWebAuthn(t *testing.T) *webauthn.WebAuthn {
+	t.Helper()
+	webAuthn, err := NewWebAuthn(WebAuthnConfig{RPID: testRPID, RPName: "Identity", RPOrigins: []string{testOrigin}})
+	if err != nil {
+		t.Fatalf("NewWebAuthn() error = %v", err)
+	}
+	return webAuthn
+}
+
+// softAuthenticator is a software passkey: a P-256 key answering WebAuthn
+// ceremonies the way a browser relays a platform authenticator's answers
+type softAuthenticator struct {
+	t            *testing.T
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	credentialID := make([]byte, 16)
+	_, _ = rand.Read(credentialID)
+	return &softAuthenticator{t: t, key: key, credentialID: credentialID}
+}
+
+// register answers navigator.credentials.create() options with a new
+// credential, attested with the "none" format
+func (a *softAuthenticator) register(options *dto.WebAuthnOptionsResponse) json.RawMessage {
+	a.t.Helper()
+	var creation protocol.CredentialCreation
+	a.decodeOptions(options, &creation)
+	a.userHandle = decodeUserID(a.t, creation.Response.User.ID)
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatalf("failed to encode public key: %v", err)
+	}
+
+	authData := a.authData(protocol.FlagAttestedCredentialData)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(struct {
+		Fmt      string         `cbor:"fmt"`
+		AttStmt  map[string]any `cbor:"attStmt"`
+		AuthData []byte         `cbor:"authData"`
+	}{Fmt: "none", AttStmt: map[string]any{}, AuthData: authData})
+	if err != nil {
+		a.t.Fatalf("failed to encode attestation: %v", err)
+	}
+
+	return a.credential(map[string]any{
+		"clientDataJSON":    b64(a.clientData("webauthn.create", creation.Response.Challenge)),
+		"attestationObject": b64(attestation),
+		"transports":        []string{"internal"},
+	})
+}
+
+// assert answers navigator.credentials.get() options, signing the
+// challenge; replaying keeps the signature counter where it was, like a
+// cloned authenticator would
+func (a *softAuthenticator) assert(options *dto.WebAuthnOptionsResponse, replay bool) json.RawMessage {
+	a.t.Helper()
+	var assertion protocol.CredentialAssertion
+	a.decodeOptions(options, &assertion)
+
+	if !replay {
+		a.signCount++
+	}
+	authData := a.authData(0)
+	clientData := a.clientData("webauthn.get", assertion.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(slices.Clone(authData), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatalf("failed to sign assertion: %v", err)
+	}
+
+	return a.credential(map[string]any{
+		"clientDataJSON":    b64(clientData),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) decodeOptions(options *dto.WebAuthnOptionsResponse, v any) {
+	a.t.Helper()
+	raw, err := json.Marshal(options.Options)
+	if err != nil {
+		a.t.Fatalf("failed to encode options: %v", err)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		a.t.Fatalf("failed to decode options: %v", err)
+	}
+}
+
+// authData is the authenticator data of a passkey that verified the user
+func (a *softAuthenticator) authData(extra protocol.AuthenticatorFlags) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	flags := protocol.FlagUserPresent | protocol.FlagUserVerified | protocol.FlagBackupEligible | protocol.FlagBackupState | extra
+	data := append(rpIDHash[:], byte(flags))
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": b64(challenge),
+		"origin":    testOrigin,
+	})
+	return data
+}
+
+func (a *softAuthenticator) credential(response map[string]any) json.RawMessage {
+	data, _ := json.Marshal(map[string]any{
+		"id":       b64(a.credentialID),
+		"rawId":    b64(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	return data
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUserID(t *testing.T, id any) []byte {
+	t.Helper()
+	encoded, _ := id.(string)
+	handle, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("failed to decode user ID %v: %v", id, err)
+	}
+	return handle
+}
+
+// registerSoftAuthenticator registers a new software passkey for user 1
+func registerSoftAuthenticator(t *testing.T, svc AuthService) *softAuthenticator {
+	t.Helper()
+	ctx := context.Background()
+	authenticator := newSoftAuthenticator(t)
+
+	options, err := svc.BeginWebAuthnRegistration(ctx, 1)
+	if err != nil {
+		t.Fatalf("BeginWebAuthnRegistration() error = %v", err)
+	}
+	credential, err := svc.FinishWebAuthnRegistration(ctx, 1, &dto.FinishWebAuthnRegistrationRequest{
+		CeremonyToken: options.CeremonyToken,
+		Name:          "Laptop",
+		Credential:    authenticator.register(options),
+	})
+	if err != nil {
+		t.Fatalf("FinishWebAuthnRegistration() error = %v", err)
+	}
+	if credential.Name != "Laptop" || !credential.Synced {
+		t.Errorf("FinishWebAuthnRegistration() = %+v, want a synced credential named Laptop", credential)
+	}
+	return authenticator
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	svc, _, audit := setupTwoFactor(t, model.RoleUser, TwoFactorConfig{})
+	ctx := context.Background()
+	authenticator := registerSoftAuthenticator(t, svc)
+
+	credentials, err := svc.GetWebAuthnCredentials(ctx, 1)
+	if err != nil || len(credentials) != 1 {
+		t.Fatalf("GetWebAuthnCredentials() = %v, %v, want one credential", credentials, err)
+	}
+
+	options, err := svc.BeginPasskeyLogin(ctx)
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin() error = %v", err)
+	}
+	answer := authenticator.assert(options, false)
+	resp, err := svc.FinishPasskeyLogin(ctx, &dto.FinishPasskeyLoginRequest{CeremonyToken: options.CeremonyToken, Credential: answer})
+	if err != nil {
+		t.Fatalf("FinishPasskeyLogin() error = %v", err)
+	}
+	if resp.SessionID == "" || resp.User.ID != 1 {
+		t.Fatalf("FinishPasskeyLogin() = %+v, want a session for user 1", resp)
+	}
+
+	// Each ceremony is answered once
+	_, err = svc.FinishPasskeyLogin(ctx, &dto.FinishPasskeyLoginRequest{CeremonyToken: options.CeremonyToken, Credential: answer})
+	if !errors.Is(err, ErrInvalidWebAuthnCeremony) {
+		t.Errorf("FinishPasskeyLogin(answered ceremony) error = %v, want ErrInvalidWebAuthnCeremony", err)
+	}
+
+	// A signature counter that doesn't go up is refused
+	options, _ = svc.BeginPasskeyLogin(ctx)
+	_, err = svc.FinishPasskeyLogin(ctx, &dto.FinishPasskeyLoginRequest{CeremonyToken: options.CeremonyToken, Credential: authenticator.assert(options, true)})
+	if !errors.Is(err, ErrWebAuthnVerification) {
+		t.Errorf("FinishPasskeyLogin(cloned authenticator) error = %v, want ErrWebAuthnVerification", err)
+	}
+
+	// An unregistered passkey is refused
+	options, _ = svc.BeginPasskeyLogin(ctx)
+	stranger := newSoftAuthenticator(t)
+	stranger.userHandle = authenticator.userHandle
+	_, err = svc.FinishPasskeyLogin(ctx, &dto.FinishPasskeyLoginRequest{CeremonyToken: options.CeremonyToken, Credential: stranger.assert(options, false)})
+	if !errors.Is(err, ErrWebAuthnVerification) {
+		t.Errorf("FinishPasskeyLogin(unknown passkey) error = %v, want ErrWebAuthnVerification", err)
+	}
+
+	actions := audit.actions()
+	for _, want := range []string{AuditWebAuthnRegistered, AuditLoginSuccess, AuditWebAuthnFailed, AuditLoginFailed} {
+		if !slices.Contains(actions, want) {
+			t.Errorf("no %s audit entry in %v", want, actions)
+		}
+	}
+}
+
+func TestWebAuthnSecondFactor(t *testing.T) {
+	svc, _, _ := setupTwoFactor(t, model.RoleAdmin, TwoFactorConfig{RequiredRoles: []model.Role{model.RoleAdmin}})
+	ctx := context.Background()
+	login := &dto.LoginRequest{Email: "test@example.com", Password: "secret123"}
+	authenticator := registerSoftAuthenticator(t, svc)
+
+	// The security key satisfies the role's two-factor requirement
+	resp, err := svc.Login(ctx, login)
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if !resp.TwoFactorRequired || resp.EnrollmentRequired || !slices.Equal(resp.TwoFactorMethods, []string{"webauthn"}) {
+		t.Fatalf("Login() = %+v, want a security key challenge", resp)
+	}
+
+	// Answering before asking for the key's options fails
+	if _, err := svc.VerifyLoginWebAuthn(ctx, &dto.VerifyLoginWebAuthnRequest{ChallengeToken: resp.ChallengeToken, Credential: json.RawMessage(`{}`)}); !errors.Is(err, ErrInvalidWebAuthnCeremony) {
+		t.Errorf("VerifyLoginWebAuthn() before BeginLoginWebAuthn error = %v, want ErrInvalidWebAuthnCeremony", err)
+	}
+
+	options, err := svc.BeginLoginWebAuthn(ctx, resp.ChallengeToken)
+	if err != nil {
+		t.Fatalf("BeginLoginWebAuthn() error = %v", err)
+	}
+	verified, err := svc.VerifyLoginWebAuthn(ctx, &dto.VerifyLoginWebAuthnRequest{ChallengeToken: resp.ChallengeToken, Credential: authenticator.assert(options, false)})
+	if err != nil {
+		t.Fatalf("VerifyLoginWebAuthn() error = %v", err)
+	}
+	if verified.SessionID == "" {
+		t.Fatal("VerifyLoginWebAuthn() returned no session")
+	}
+	if _, err := svc.ValidateSession(ctx, verified.SessionID); err != nil {
+		t.Errorf("ValidateSession() error = %v", err)
+	}
+
+	// The last key of a role requiring two-factor authentication stays,
+	// unless an admin revokes it
+	credentials, _ := svc.GetWebAuthnCredentials(ctx, 1)
+	if err := svc.DeleteWebAuthnCredential(ctx, 1, credentials[0].ID); !errors.Is(err, ErrTOTPRequired) {
+		t.Errorf("DeleteWebAuthnCredential(last key) error = %v, want ErrTOTPRequired", err)
+	}
+	if err := svc.RevokeWebAuthnCredential(ctx, 1, credentials[0].ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("RevokeWebAuthnCredential() without an actor error = %v, want ErrForbidden", err)
+	}
+	if err := svc.RevokeWebAuthnCredential(adminContext(), 1, credentials[0].ID); err != nil {
+		t.Fatalf("RevokeWebAuthnCredential() error = %v", err)
+	}
+	if resp, _ := svc.Login(ctx, login); resp == nil || !resp.EnrollmentRequired {
+		t.Errorf("Login() after revoking = %+v, want enrollment required", resp)
+	}
+	if _, err := svc.BeginLoginWebAuthn(ctx, resp.ChallengeToken); !errors.Is(err, ErrInvalidLoginChallenge) {
+		t.Errorf("BeginLoginWebAuthn(used challenge) error = %v, want ErrInvalidLoginChallenge", err)
+	}
+}
+
+func TestWebAuthnRegistrationRejectsOtherUsersCeremony(t *testing.T) {
+	svc, userRepo, _ := setupTwoFactor(t, model.RoleUser, TwoFactorConfig{})
+	ctx := context.Background()
+	if err := userRepo.Create(ctx, &model.User{Name: "Other", Email: "other@example.com", Enabled: true, Role: model.RoleUser}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	options, err := svc.BeginWebAuthnRegistration(ctx, 1)
+	if err != nil {
+		t.Fatalf("BeginWebAuthnRegistration() error = %v", err)
+	}
+	_, err = svc.FinishWebAuthnRegistration(ctx, 2, &dto.FinishWebAuthnRegistrationRequest{
+		CeremonyToken: options.CeremonyToken,
+		Credential:    newSoftAuthenticator(t).register(options),
+	})
+	if !errors.Is(err, ErrInvalidWebAuthnCeremony) {
+		t.Errorf("FinishWebAuthnRegistration(other user) error = %v, want ErrInvalidWebAuthnCeremony", err)
+	}
+	if credentials, _ := svc.GetWebAuthnCredentials(ctx, 2); len(credentials) != 0 {
+		t.Errorf("user 2 has %d credentials, want none", len(credentials))
+	}
+}
@@ -0,0 +1,164 @@
+// Package tlsconfig serves the server's certificate, and the CAs client
+// certificates are verified against, from PEM files, picking up renewed
+// files without a restart.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Files names the PEM files the TLS configuration is read from
+type Files struct {
+	// CertFile holds the server's certificate, followed by any intermediates
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is a bundle of the CAs client certificates must chain to;
+	// empty asks clients for no certificate
+	ClientCAFile string
+}
+
+// Reloader holds the TLS configuration read from Files and reads it again
+// when they change. Handshakes always use the last configuration that
+// loaded without error.
+type Reloader struct {
+	files Files
+
+	mu       sync.RWMutex
+	config   *tls.Config
+	modTimes []time.Time
+}
+
+// NewReloader loads the files, failing if any of them can't be used
+func NewReloader(files Files) (*Reloader, error) {
+	r := &Reloader{files: files}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns the configuration for the server to listen with. Each
+// handshake picks up the certificates loaded last.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.config, nil
+		},
+	}
+}
+
+// Reload reads the files again. On error the configuration loaded before
+// stays in use.
+func (r *Reloader) Reload() error {
+	// Taken before reading, so a file replaced while it is read is seen as
+	// changed on the next check
+	modTimes := r.statFiles()
+
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		// The returned configuration replaces the server's, ALPN included
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	if r.files.ClientCAFile != "" {
+		clientCAs, err := loadCertPool(r.files.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		// Certificates are checked if given; the routes that need one turn
+		// away requests without
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	r.mu.Lock()
+	r.config = config
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+// Run checks the files for changes every interval and reloads them when one
+// changed, until ctx is cancelled. A file that fails to load is retried on
+// the next check, e.g. when a certificate was renewed before its key.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !r.changed() {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			logger.Error("failed to reload TLS certificates, keeping the current ones", "error", err)
+			continue
+		}
+		logger.Info("TLS certificates reloaded")
+	}
+}
+
+// changed reports whether any file was modified (or can no longer be read)
+// since it was last loaded
+func (r *Reloader) changed() bool {
+	modTimes := r.statFiles()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i, modTime := range modTimes {
+		if modTime.IsZero() || !modTime.Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// statFiles returns the modification time of each file, zero for one that
+// can't be read
+func (r *Reloader) statFiles() []time.Time {
+	paths := []string{r.files.CertFile, r.files.KeyFile}
+	if r.files.ClientCAFile != "" {
+		paths = append(paths, r.files.ClientCAFile)
+	}
+
+	modTimes := make([]time.Time, len(paths))
+	for i, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[i] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+// loadCertPool reads a PEM bundle of CA certificates
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in client CA bundle %s", path)
+	}
+	return pool, nil
+}
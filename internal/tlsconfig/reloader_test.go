@@ -0,0 +1,266 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA signs certificates for the tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for commonName
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeFile writes data to path with a modification time of modTime, so
+// changes are seen whatever the file system's timestamp resolution
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedCommonName returns the subject of the certificate a handshake would
+// be served
+func servedCommonName(t *testing.T, r *Reloader) string {
+	t.Helper()
+	config, err := r.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Subject.CommonName
+}
+
+func TestReloaderPicksUpRenewedCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	files := Files{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	loaded := time.Now().Add(-time.Minute)
+	certPEM, keyPEM := ca.issue(t, "old.internal", x509.ExtKeyUsageServerAuth)
+	writeFile(t, files.CertFile, certPEM, loaded)
+	writeFile(t, files.KeyFile, keyPEM, loaded)
+	writeFile(t, files.ClientCAFile, ca.pem, loaded)
+
+	r, err := NewReloader(files)
+	if err != nil {
+		t.Fatalf("NewReloader: %v", err)
+	}
+	if r.changed() {
+		t.Fatal("expected no change right after loading")
+	}
+
+	// A certificate renewed before its key doesn't load; the old one stays
+	renewed := time.Now()
+	certPEM, keyPEM = ca.issue(t, "new.internal", x509.ExtKeyUsageServerAuth)
+	writeFile(t, files.CertFile, certPEM, renewed)
+	if !r.changed() {
+		t.Fatal("expected the renewed certificate to be noticed")
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected a certificate not matching its key to fail")
+	}
+	if got := servedCommonName(t, r); got != "old.internal" {
+		t.Fatalf("expected the old certificate to stay in use, got %q", got)
+	}
+
+	writeFile(t, files.KeyFile, keyPEM, renewed)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := servedCommonName(t, r); got != "new.internal" {
+		t.Fatalf("expected the renewed certificate, got %q", got)
+	}
+	if r.changed() {
+		t.Fatal("expected no change after reloading")
+	}
+}
+
+func TestNewReloaderRejectsEmptyCABundle(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	files := Files{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	certPEM, keyPEM := ca.issue(t, "identity.internal", x509.ExtKeyUsageServerAuth)
+	writeFile(t, files.CertFile, certPEM, time.Now())
+	writeFile(t, files.KeyFile, keyPEM, time.Now())
+	writeFile(t, files.ClientCAFile, []byte("not a certificate"), time.Now())
+
+	if _, err := NewReloader(files); err == nil {
+		t.Fatal("expected a CA bundle without certificates to be refused")
+	}
+}
+
+// Client certificates are optional in the handshake, and verified against
+// the CA bundle when given
+func TestClientCertificateVerification(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	files := Files{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	certPEM, keyPEM := ca.issue(t, "identity.internal", x509.ExtKeyUsageServerAuth)
+	writeFile(t, files.CertFile, certPEM, time.Now())
+	writeFile(t, files.KeyFile, keyPEM, time.Now())
+	writeFile(t, files.ClientCAFile, ca.pem, time.Now())
+
+	r, err := NewReloader(files)
+	if err != nil {
+		t.Fatalf("NewReloader: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCertPEM, clientKeyPEM := ca.issue(t, "transactions.internal", x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strangerCertPEM, strangerKeyPEM := newTestCA(t).issue(t, "transactions.internal", x509.ExtKeyUsageClientAuth)
+	strangerCert, err := tls.X509KeyPair(strangerCertPEM, strangerKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		certs        []tls.Certificate
+		wantErr      bool
+		wantVerified bool
+	}{
+		{name: "no certificate", certs: nil},
+		{name: "certificate from the CA", certs: []tls.Certificate{clientCert}, wantVerified: true},
+		{name: "certificate from another CA", certs: []tls.Certificate{strangerCert}, wantErr: true},
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", r.TLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverDone := make(chan struct{})
+			go func() {
+				conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+					RootCAs:      roots,
+					ServerName:   "identity.internal",
+					Certificates: tt.certs,
+				})
+				if err == nil {
+					// Held open until the server has checked the certificate
+					<-serverDone
+					conn.Close()
+				}
+			}()
+
+			conn, err := listener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			server := conn.(*tls.Conn)
+			err = server.Handshake()
+			close(serverDone)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected the handshake to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handshake: %v", err)
+			}
+			verified := len(server.ConnectionState().VerifiedChains) > 0
+			if verified != tt.wantVerified {
+				t.Fatalf("expected verified %v, got %v", tt.wantVerified, verified)
+			}
+		})
+	}
+}
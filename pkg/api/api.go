@@ -0,0 +1,46 @@
+// Package api holds the types of identity's HTTP API that services consuming
+// it see as well: users, session tokens, flags and the flag stream. The
+// server's DTOs and the client SDK both use them, as they do the flag
+// evaluation in EvaluateFlag, so the SDK needs nothing internal to identity.
+package api
+
+import "time"
+
+// ErrorResponse represents an error response
+type ErrorResponse struct {
+	Error   string `json:"error" example:"Invalid request"`
+	Message string `json:"message" example:"The request body is invalid"`
+}
+
+// ValidateSessionRequest represents the request to validate a session
+type ValidateSessionRequest struct {
+	SessionID string `json:"session_id" example:"abc123"`
+}
+
+// UserResponse represents the response for a user
+type UserResponse struct {
+	ID        uint       `json:"id" example:"1"`
+	Name      string     `json:"name" example:"John Doe"`
+	Email     string     `json:"email" example:"john@example.com"`
+	Enabled   bool       `json:"enabled" example:"true"`
+	Role      string     `json:"role" example:"user"`
+	CreatedAt time.Time  `json:"created_at" example:"2024-01-01T00:00:00Z"`
+	UpdatedAt time.Time  `json:"updated_at" example:"2024-01-01T00:00:00Z"`
+	LastLogin *time.Time `json:"last_login,omitempty" example:"2024-01-01T00:00:00Z"`
+	// TOTPEnabled reports whether the user has two-factor authentication set up
+	TOTPEnabled bool `json:"totp_enabled" example:"false"`
+	// SessionExpiresAt and SessionAbsoluteExpiresAt are set when the user is
+	// looked up by session: the session expires at the first unless used
+	// again, and ends at the second however active it is (omitted without a
+	// maximum lifetime)
+	SessionExpiresAt         *time.Time `json:"session_expires_at,omitempty"`
+	SessionAbsoluteExpiresAt *time.Time `json:"session_absolute_expires_at,omitempty"`
+}
+
+// PublicUserResponse is the minimal, non-sensitive projection of a user
+// exposed to other services on the internal network (e.g. resolving the
+// creator of a transaction). It deliberately omits email and audit fields.
+type PublicUserResponse struct {
+	ID   uint   `json:"id" example:"1"`
+	Name string `json:"name" example:"John Doe"`
+}
@@ -0,0 +1,351 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Evaluation reasons reported in FlagEvaluationResponse
+const (
+	ReasonPrerequisite = "prerequisite_failed"
+	ReasonGlobal       = "global"
+	ReasonAssigned     = "assigned"
+	ReasonRule         = "rule"
+	ReasonRollout      = "rollout"
+	ReasonDefault      = "default"
+)
+
+// dateLayouts are accepted for before/after conditions
+var dateLayouts = []string{time.RFC3339, "2006-01-02"}
+
+// FlagInputs supplies what evaluating a flag may need besides the flag
+// itself. Assignment (the variant the user's assignment of the flag pins, and
+// whether there is one), Attributes (for targeting rules) and Flag (a
+// prerequisite flag by key, nil if unknown) are only called when the flag's
+// configuration requires them; any may be nil when there is nothing to look
+// up, in which case prerequisites are never met.
+type FlagInputs struct {
+	UserID     *uint
+	Assignment func(flag *FeatureFlagResponse) (variant string, assigned bool, err error)
+	Attributes func() (map[string]any, error)
+	Flag       func(key string) (*FeatureFlagResponse, error)
+}
+
+// EvaluateFlag resolves one flag. Unmet prerequisites turn it off; otherwise
+// the global switch wins, then an explicit user assignment, then the first
+// matching targeting rule, then the rollout. It is the evaluation behind both
+// the check endpoints and the client SDK's local evaluator, so the two always
+// agree.
+func EvaluateFlag(flag *FeatureFlagResponse, in FlagInputs) (*FlagEvaluationResponse, error) {
+	return evaluateFlag(flag, in, nil)
+}
+
+// evaluateFlag is EvaluateFlag for a flag reached through the prerequisites
+// of the flags in path
+func evaluateFlag(flag *FeatureFlagResponse, in FlagInputs, path []string) (*FlagEvaluationResponse, error) {
+	result := &FlagEvaluationResponse{Key: flag.Key}
+
+	unmet, err := unmetPrerequisite(flag, in, append(path[:len(path):len(path)], flag.Key))
+	if err != nil {
+		return nil, err
+	}
+	if unmet != "" {
+		result.Reason = ReasonPrerequisite
+		result.Prerequisite = unmet
+		resolveVariant(flag, result, in.UserID, "")
+		return result, nil
+	}
+
+	// The assignment decides enablement unless the flag is globally on, but
+	// may still pin the variant served
+	pinned, assigned := "", false
+	if in.UserID != nil && in.Assignment != nil && (!flag.Enabled || len(flag.Variants) > 0) {
+		var err error
+		if pinned, assigned, err = in.Assignment(flag); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case flag.Enabled:
+		result.Enabled = true
+		result.Reason = ReasonGlobal
+	case assigned:
+		result.Enabled = true
+		result.Reason = ReasonAssigned
+	default:
+		if err := evaluateTargeting(flag, in, result); err != nil {
+			return nil, err
+		}
+		if result.RuleIndex != nil && pinned == "" {
+			pinned = flag.Rules[*result.RuleIndex].Variant
+		}
+	}
+
+	resolveVariant(flag, result, in.UserID, pinned)
+	return result, nil
+}
+
+// unmetPrerequisite returns the key of the first of the flag's prerequisites
+// that is not met for the evaluation context, or "" if all are. path lists
+// the flags being evaluated: a cycle (rejected on save, but a stale local copy
+// could hold one) counts as unmet instead of recursing forever, as does an
+// unknown flag.
+func unmetPrerequisite(flag *FeatureFlagResponse, in FlagInputs, path []string) (string, error) {
+	for _, pre := range flag.Prerequisites {
+		if in.Flag == nil || slices.Contains(path, pre.FlagKey) {
+			return pre.FlagKey, nil
+		}
+		preFlag, err := in.Flag(pre.FlagKey)
+		if err != nil {
+			return "", err
+		}
+		if preFlag == nil {
+			return pre.FlagKey, nil
+		}
+		result, err := evaluateFlag(preFlag, in, path)
+		if err != nil {
+			return "", err
+		}
+		if pre.Variant == "" && !result.Enabled || pre.Variant != "" && result.Variant != pre.Variant {
+			return pre.FlagKey, nil
+		}
+	}
+	return "", nil
+}
+
+// evaluateTargeting resolves a flag that is neither globally enabled nor
+// assigned to the user: the first matching rule wins, then the rollout.
+func evaluateTargeting(flag *FeatureFlagResponse, in FlagInputs, result *FlagEvaluationResponse) error {
+	if len(flag.Rules) > 0 {
+		var attrs map[string]any
+		if in.Attributes != nil {
+			var err error
+			if attrs, err = in.Attributes(); err != nil {
+				return err
+			}
+		}
+		if i := matchRules(flag.Rules, attrs); i >= 0 {
+			result.Enabled = true
+			result.Reason = ReasonRule
+			result.RuleIndex = &i
+			result.RuleName = flag.Rules[i].Name
+			return nil
+		}
+	}
+
+	if in.UserID != nil && rolloutBucket(flag.Key, *in.UserID) < flag.RolloutPercentage {
+		result.Enabled = true
+		result.Reason = ReasonRollout
+		return nil
+	}
+
+	result.Reason = ReasonDefault
+	return nil
+}
+
+// rolloutBucket maps a user to a stable bucket in [0, 100) for a flag. Hashing
+// the flag key together with the user ID keeps a user's bucket fixed across
+// requests and replicas, while different flags get independent user samples.
+// Because buckets are fixed, raising a rollout percentage only ever adds
+// users: everyone in at 5% is still in at 25%.
+func rolloutBucket(flagKey string, userID uint) int {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", flagKey, userID)))
+	return int(binary.BigEndian.Uint32(sum[:4]) % 100)
+}
+
+// matchRules returns the index of the first rule whose conditions all match
+// attrs, or -1 when none does.
+func matchRules(rules []TargetingRule, attrs map[string]any) int {
+	for i, rule := range rules {
+		if matchRule(rule, attrs) {
+			return i
+		}
+	}
+	return -1
+}
+
+func matchRule(rule TargetingRule, attrs map[string]any) bool {
+	if len(rule.Conditions) == 0 {
+		return false
+	}
+	for _, cond := range rule.Conditions {
+		if !matchCondition(cond, attrs) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchCondition evaluates one condition. A missing attribute never matches.
+// List attributes (e.g. groups) match positive operators when any element
+// matches, and negative operators (not_equals, not_in) when no element does.
+// String comparisons are case-insensitive.
+func matchCondition(cond TargetingCondition, attrs map[string]any) bool {
+	values := attributeValues(attrs[cond.Attribute])
+	if len(values) == 0 {
+		return false
+	}
+
+	switch cond.Operator {
+	case OpNotEquals, OpNotIn:
+		for _, v := range values {
+			if anyValue(cond.Values, func(want string) bool { return strings.EqualFold(v, want) }) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, v := range values {
+		if matchValue(cond.Operator, v, cond.Values) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchValue(operator, value string, wants []string) bool {
+	lower := strings.ToLower(value)
+	switch operator {
+	case OpEquals, OpIn:
+		return anyValue(wants, func(want string) bool { return strings.EqualFold(value, want) })
+	case OpContains:
+		return anyValue(wants, func(want string) bool { return strings.Contains(lower, strings.ToLower(want)) })
+	case OpStartsWith:
+		return anyValue(wants, func(want string) bool { return strings.HasPrefix(lower, strings.ToLower(want)) })
+	case OpEndsWith:
+		return anyValue(wants, func(want string) bool { return strings.HasSuffix(lower, strings.ToLower(want)) })
+	case OpBefore, OpAfter:
+		t, ok := ParseDate(value)
+		if !ok {
+			return false
+		}
+		return anyValue(wants, func(want string) bool {
+			bound, ok := ParseDate(want)
+			if !ok {
+				return false
+			}
+			if operator == OpBefore {
+				return t.Before(bound)
+			}
+			return t.After(bound)
+		})
+	}
+	return false
+}
+
+func anyValue(values []string, fn func(string) bool) bool {
+	for _, v := range values {
+		if fn(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDate parses a date the way before/after conditions do: RFC3339 or
+// YYYY-MM-DD
+func ParseDate(value string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// attributeValues flattens an attribute (scalar or list, as decoded from
+// JSON or taken from the user record) into comparable strings.
+func attributeValues(v any) []string {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	case []any:
+		values := make([]string, 0, len(t))
+		for _, item := range t {
+			values = append(values, attributeValues(item)...)
+		}
+		return values
+	case time.Time:
+		return []string{t.UTC().Format(time.RFC3339)}
+	case float64:
+		return []string{strconv.FormatFloat(t, 'f', -1, 64)}
+	default:
+		return []string{fmt.Sprint(t)}
+	}
+}
+
+// findVariant returns the flag's variant with the given key, if any
+func findVariant(flag *FeatureFlagResponse, key string) *FlagVariant {
+	for i := range flag.Variants {
+		if flag.Variants[i].Key == key {
+			return &flag.Variants[i]
+		}
+	}
+	return nil
+}
+
+// pickVariant distributes users over the flag's variants by weight. The
+// bucket is salted differently from the rollout bucket, so which users are in
+// a rollout and which variant they get are independent. Without a user there
+// is nothing to bucket by, and the first variant is served.
+func pickVariant(flag *FeatureFlagResponse, userID *uint) *FlagVariant {
+	if len(flag.Variants) == 0 {
+		return nil
+	}
+	if userID == nil {
+		return &flag.Variants[0]
+	}
+
+	bucket := rolloutBucket(flag.Key+"#variant", *userID)
+	cumulative := 0
+	for i := range flag.Variants {
+		cumulative += flag.Variants[i].Weight
+		if bucket < cumulative {
+			return &flag.Variants[i]
+		}
+	}
+	return &flag.Variants[len(flag.Variants)-1]
+}
+
+// resolveVariant fills in the variant and value of an evaluation result.
+// Enabled results get the pinned variant (from the user's assignment or the
+// matching rule) or a weighted pick; disabled ones get the off variant, or a
+// null value when the flag has none. Boolean flags without variants report
+// their enabled state as the value.
+func resolveVariant(flag *FeatureFlagResponse, result *FlagEvaluationResponse, userID *uint, pinned string) {
+	if len(flag.Variants) == 0 {
+		result.Value = json.RawMessage(strconv.FormatBool(result.Enabled))
+		return
+	}
+
+	var variant *FlagVariant
+	if !result.Enabled {
+		variant = findVariant(flag, flag.OffVariant)
+	} else {
+		if pinned != "" {
+			variant = findVariant(flag, pinned)
+		}
+		if variant == nil {
+			variant = pickVariant(flag, userID)
+		}
+	}
+
+	if variant == nil {
+		result.Value = json.RawMessage("null")
+		return
+	}
+	result.Variant = variant.Key
+	result.Value = variant.Value
+}
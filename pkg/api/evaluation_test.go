@@ -0,0 +1,19 @@
+package api
+
+import "testing"
+
+func TestRolloutBucketsAreIndependentPerFlag(t *testing.T) {
+	same := 0
+	for uid := uint(1); uid <= 1000; uid++ {
+		if rolloutBucket("flag-a", uid) == rolloutBucket("flag-b", uid) {
+			same++
+		}
+	}
+	// Independent buckets collide about 1% of the time
+	if same > 50 {
+		t.Errorf("%d of 1000 users share a bucket across flags, want independent sampling", same)
+	}
+	if rolloutBucket("flag-a", 42) != rolloutBucket("flag-a", 42) {
+		t.Error("rolloutBucket() is not deterministic")
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Targeting rule operators
+const (
+	OpEquals     = "equals"
+	OpNotEquals  = "not_equals"
+	OpIn         = "in"
+	OpNotIn      = "not_in"
+	OpContains   = "contains"
+	OpStartsWith = "starts_with"
+	OpEndsWith   = "ends_with"
+	OpBefore     = "before"
+	OpAfter      = "after"
+)
+
+// Variant value types
+const (
+	VariantTypeBoolean = "boolean"
+	VariantTypeString  = "string"
+	VariantTypeNumber  = "number"
+	VariantTypeJSON    = "json"
+)
+
+// VariantTypes lists the supported variant value types
+var VariantTypes = []string{VariantTypeBoolean, VariantTypeString, VariantTypeNumber, VariantTypeJSON}
+
+// FeatureFlagResponse represents the response for a feature flag
+type FeatureFlagResponse struct {
+	ID                uint               `json:"id" example:"1"`
+	Key               string             `json:"key" example:"dark_mode"`
+	Description       string             `json:"description" example:"Enable dark mode interface"`
+	Enabled           bool               `json:"enabled" example:"true"`
+	RolloutPercentage int                `json:"rollout_percentage" example:"25"`
+	Rules             []TargetingRule    `json:"rules"`
+	VariantType       string             `json:"variant_type" example:"string"`
+	Variants          []FlagVariant      `json:"variants"`
+	OffVariant        string             `json:"off_variant,omitempty" example:"classic"`
+	Prerequisites     []FlagPrerequisite `json:"prerequisites"`
+	CreatedAt         time.Time          `json:"created_at" example:"2024-01-01T00:00:00Z"`
+	UpdatedAt         time.Time          `json:"updated_at" example:"2024-01-01T00:00:00Z"`
+}
+
+// TargetingCondition compares one evaluation attribute against values.
+// Operators: equals, not_equals, in, not_in, contains, starts_with, ends_with,
+// before, after (dates as RFC3339 or YYYY-MM-DD).
+type TargetingCondition struct {
+	Attribute string   `json:"attribute" example:"email"`
+	Operator  string   `json:"operator" example:"ends_with"`
+	Values    []string `json:"values" example:"@ourcompany.com"`
+}
+
+// TargetingRule enables a flag when all of its conditions match, optionally
+// serving a specific variant. A flag's rules are evaluated in order; the
+// first match wins.
+type TargetingRule struct {
+	Name       string               `json:"name,omitempty" example:"staff"`
+	Conditions []TargetingCondition `json:"conditions"`
+	Variant    string               `json:"variant,omitempty" example:"compact"`
+}
+
+// FlagVariant is one value of a multivariate flag. Value must match the
+// flag's variant_type; weights of all variants add up to 100.
+type FlagVariant struct {
+	Key    string          `json:"key" example:"compact"`
+	Value  json.RawMessage `json:"value" swaggertype:"object"`
+	Weight int             `json:"weight" example:"50"`
+}
+
+// FlagPrerequisite is a flag that must be enabled for the same evaluation
+// context (or, when variant is set, serve that variant) for the flag
+// declaring it to be on
+type FlagPrerequisite struct {
+	FlagKey string `json:"flag_key" example:"use-transactions-v2"`
+	Variant string `json:"variant,omitempty" example:"compact"`
+}
+
+// EvaluationContext is what a flag is evaluated against: an optional user
+// (whose record supplies user_id, email, name, role and created_at) plus
+// arbitrary attributes from the calling service (e.g. groups, plan, country).
+// The check endpoints ignore attributes named like the user record's ones.
+type EvaluationContext struct {
+	UserID     *uint
+	Attributes map[string]any
+}
+
+// EvaluateFeatureFlagRequest is the JSON body of POST /feature-flags/check
+type EvaluateFeatureFlagRequest struct {
+	Key     string         `json:"key" binding:"required" example:"use-transactions-v2"`
+	UserID  *uint          `json:"user_id,omitempty" example:"42"`
+	Context map[string]any `json:"context,omitempty"`
+}
+
+// BulkEvaluateRequest is the JSON body of POST /feature-flags/evaluate. An
+// empty Keys evaluates every flag.
+type BulkEvaluateRequest struct {
+	Keys    []string       `json:"keys,omitempty" example:"dark_mode,pricing-layout"`
+	UserID  *uint          `json:"user_id,omitempty" example:"42"`
+	Context map[string]any `json:"context,omitempty"`
+}
+
+// BulkEvaluationResponse maps flag keys to their evaluation results
+type BulkEvaluationResponse struct {
+	Flags map[string]FlagEvaluationResponse `json:"flags"`
+}
+
+// FlagEvaluationResponse is the result of evaluating a flag. Reason is one of
+// prerequisite_failed, global, assigned, rule, rollout or default;
+// RuleIndex/RuleName identify the matching targeting rule when Reason is rule,
+// Prerequisite the first unmet prerequisite when it is prerequisite_failed.
+// Variant and Value are the resolved variant; boolean flags without variants
+// report Value = Enabled.
+type FlagEvaluationResponse struct {
+	Key          string          `json:"key" example:"use-transactions-v2"`
+	Enabled      bool            `json:"enabled" example:"true"`
+	Variant      string          `json:"variant,omitempty" example:"compact"`
+	Value        json.RawMessage `json:"value" swaggertype:"object"`
+	Reason       string          `json:"reason" example:"rule"`
+	RuleIndex    *int            `json:"rule_index,omitempty" example:"0"`
+	RuleName     string          `json:"rule_name,omitempty" example:"staff"`
+	Prerequisite string          `json:"prerequisite,omitempty" example:"use-transactions-v2"`
+}
+
+// Flag stream event types (the SSE "event" field)
+const (
+	FlagStreamSnapshot    = "snapshot"
+	FlagStreamFlag        = "flag"
+	FlagStreamFlagDeleted = "flag_deleted"
+	FlagStreamAssignment  = "assignment"
+	FlagStreamHeartbeat   = "heartbeat"
+)
+
+// FlagStreamEvent is one event of GET /feature-flags/stream. ID is the SSE
+// event ID to resume from (sent back as Last-Event-ID); Data is the JSON
+// payload: FlagSnapshot, FeatureFlagResponse, FlagDeletedEvent,
+// FlagAssignmentEvent or HeartbeatEvent depending on Event.
+type FlagStreamEvent struct {
+	ID    uint64
+	Event string
+	Data  any
+}
+
+// FlagSnapshot is the full flag set (with every user assignment) a stream
+// starts from
+type FlagSnapshot struct {
+	Flags       []FeatureFlagResponse `json:"flags"`
+	Assignments []FlagAssignmentEvent `json:"assignments"`
+}
+
+// FlagDeletedEvent reports a deleted flag
+type FlagDeletedEvent struct {
+	Key string `json:"key" example:"dark_mode"`
+}
+
+// FlagAssignmentEvent is a user's current assignment to a flag; Assigned is
+// false once it was removed
+type FlagAssignmentEvent struct {
+	UserID   uint   `json:"user_id" example:"42"`
+	FlagKey  string `json:"flag_key" example:"dark_mode"`
+	Assigned bool   `json:"assigned" example:"true"`
+	Variant  string `json:"variant,omitempty" example:"compact"`
+}
+
+// HeartbeatEvent keeps an idle stream (and any proxy in between) alive
+type HeartbeatEvent struct {
+	Time time.Time `json:"time"`
+}
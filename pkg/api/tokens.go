@@ -0,0 +1,53 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SessionTokenType is the "typ" header of session tokens (RFC 9068), which
+// tells them apart from ID tokens signed with the same keys
+const SessionTokenType = "at+jwt"
+
+// SessionTokenResponse is a signed access token exchanged for a session
+type SessionTokenResponse struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type" example:"Bearer"`
+	ExpiresIn   int       `json:"expires_in" example:"300"`
+	ExpiresAt   time.Time `json:"expires_at" example:"2024-01-01T00:05:00Z"`
+}
+
+// SessionTokenClaims are the claims of a session token. The subject is the
+// user ID. Flags holds whether each flag is on for the user, and
+// FlagVariants the variant of those that have one, as evaluated when the
+// token was issued without context attributes.
+type SessionTokenClaims struct {
+	jwt.RegisteredClaims
+	Name         string            `json:"name"`
+	Roles        []string          `json:"roles"`
+	Flags        map[string]bool   `json:"flags"`
+	FlagVariants map[string]string `json:"flag_variants,omitempty"`
+}
+
+// UserID returns the user ID the token's subject names
+func (c *SessionTokenClaims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 32)
+	return uint(id), err
+}
+
+// JWK is a public signing key in JSON Web Key form
+type JWK struct {
+	Kty string `json:"kty" example:"RSA"`
+	Use string `json:"use" example:"sig"`
+	Alg string `json:"alg" example:"RS256"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e" example:"AQAB"`
+}
+
+// JWKS is the set of public keys tokens may be signed with
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
@@ -0,0 +1,236 @@
+// Package client is the Go SDK for services consuming identity: a typed
+// client for session validation, flag checks and public user lookups, a local
+// flag evaluator kept current by the flag stream, a short-lived session
+// validation cache, gin/net-http middleware mirroring identity's own session
+// auth, and a cache of the signed access tokens sessions are exchanged for
+// with a verifier checking them locally. Package clienttest provides a fake
+// identity server for tests.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/pkg/api"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTimeout bounds each call made with the default HTTP client
+const defaultTimeout = 5 * time.Second
+
+// Types shared with the identity API
+type (
+	// User is a validated session's user
+	User = api.UserResponse
+	// PublicUser is the minimal user info exposed to other services
+	PublicUser = api.PublicUserResponse
+	// EvaluationContext is what a flag is evaluated against
+	EvaluationContext = api.EvaluationContext
+	// FlagEvaluation is the result of evaluating a flag
+	FlagEvaluation = api.FlagEvaluationResponse
+	// Flag is a flag as sent by the flag stream
+	Flag = api.FeatureFlagResponse
+	// TargetingRule, TargetingCondition, FlagVariant and FlagPrerequisite
+	// make up a Flag
+	TargetingRule      = api.TargetingRule
+	TargetingCondition = api.TargetingCondition
+	FlagVariant        = api.FlagVariant
+	FlagPrerequisite   = api.FlagPrerequisite
+)
+
+var (
+	// ErrUnauthorized is returned for missing, invalid or expired sessions
+	// and API keys
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotFound is returned for unknown flags and users
+	ErrNotFound = errors.New("not found")
+)
+
+// APIError is an error response from identity. It matches ErrUnauthorized
+// and ErrNotFound (via errors.Is) for 401 and 404 responses.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("identity: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Unwrap maps the status code to ErrUnauthorized or ErrNotFound
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusNotFound:
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Client calls the identity API
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	// streamClient has no timeout, for the long-lived flag stream
+	streamClient *http.Client
+	// apiKey, if set, is sent with every call
+	apiKey string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient replaces the HTTP client used for regular calls (the flag
+// stream uses its transport without a timeout)
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+		c.streamClient = &http.Client{Transport: httpClient.Transport}
+	}
+}
+
+// WithAPIKey sends an API key (Authorization: Bearer) with every call, which
+// identity requires of flag checks and user lookups when API_KEY_REQUIRED is
+// set, and attributes the calls to in its audit log
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// New creates a client for the identity service at baseURL (e.g.
+// http://identity:8080)
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		httpClient:   &http.Client{Timeout: defaultTimeout},
+		streamClient: &http.Client{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ValidateSession returns the user of a session, or ErrUnauthorized
+func (c *Client) ValidateSession(ctx context.Context, sessionID string) (*User, error) {
+	var user User
+	err := c.do(ctx, http.MethodPost, "/api/v1/auth/validate", api.ValidateSessionRequest{SessionID: sessionID}, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CheckFeatureFlag evaluates a flag globally, or for userID when not nil
+func (c *Client) CheckFeatureFlag(ctx context.Context, key string, userID *uint) (*FlagEvaluation, error) {
+	query := url.Values{"key": {key}}
+	if userID != nil {
+		query.Set("user_id", strconv.FormatUint(uint64(*userID), 10))
+	}
+	var result FlagEvaluation
+	if err := c.do(ctx, http.MethodGet, "/api/v1/feature-flags/check?"+query.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// EvaluateFeatureFlag evaluates a flag against a full evaluation context
+func (c *Client) EvaluateFeatureFlag(ctx context.Context, key string, evalCtx *EvaluationContext) (*FlagEvaluation, error) {
+	req := api.EvaluateFeatureFlagRequest{Key: key}
+	if evalCtx != nil {
+		req.UserID, req.Context = evalCtx.UserID, evalCtx.Attributes
+	}
+	var result FlagEvaluation
+	if err := c.do(ctx, http.MethodPost, "/api/v1/feature-flags/check", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// EvaluateFeatureFlags evaluates the given flags (all of them when keys is
+// empty) for one evaluation context, keyed by flag key
+func (c *Client) EvaluateFeatureFlags(ctx context.Context, keys []string, evalCtx *EvaluationContext) (map[string]FlagEvaluation, error) {
+	req := api.BulkEvaluateRequest{Keys: keys}
+	if evalCtx != nil {
+		req.UserID, req.Context = evalCtx.UserID, evalCtx.Attributes
+	}
+	var resp api.BulkEvaluationResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/feature-flags/evaluate", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Flags, nil
+}
+
+// GetPublicUser returns a user's public info (id, name), or ErrNotFound
+func (c *Client) GetPublicUser(ctx context.Context, id uint) (*PublicUser, error) {
+	var user PublicUser
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/v1/internal/users/%d", id), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// setAPIKey adds the client's API key, if any, to a request
+func (c *Client) setAPIKey(req *http.Request) {
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+}
+
+// do sends a JSON request and decodes a 2xx JSON response into out, or
+// returns an *APIError
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	c.setAPIKey(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("identity: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return decodeAPIError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("identity: decoding response: %w", err)
+	}
+	return nil
+}
+
+func decodeAPIError(resp *http.Response) error {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	var errResp api.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil {
+		apiErr.Code, apiErr.Message = errResp.Error, errResp.Message
+	} else {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
@@ -0,0 +1,274 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"identity/pkg/client"
+	"identity/pkg/client/clienttest"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func uintPtr(v uint) *uint { return &v }
+
+func TestClient(t *testing.T) {
+	server := clienttest.NewServer(t)
+	server.AddSession("s1", client.User{ID: 7, Name: "Jane", Role: "user"})
+	server.AddUser(client.PublicUser{ID: 7, Name: "Jane"})
+	server.SetFlag(client.Flag{Key: "beta"})
+	server.Assign(7, "beta", "")
+	c := server.Client()
+	ctx := context.Background()
+
+	user, err := c.ValidateSession(ctx, "s1")
+	if err != nil || user.ID != 7 {
+		t.Fatalf("ValidateSession() = %+v, %v, want user 7", user, err)
+	}
+	if _, err := c.ValidateSession(ctx, "nope"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("ValidateSession(unknown) error = %v, want ErrUnauthorized", err)
+	}
+
+	result, err := c.CheckFeatureFlag(ctx, "beta", uintPtr(7))
+	if err != nil || !result.Enabled || result.Reason != "assigned" {
+		t.Errorf("CheckFeatureFlag() = %+v, %v, want enabled by assignment", result, err)
+	}
+	if _, err := c.CheckFeatureFlag(ctx, "missing", nil); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("CheckFeatureFlag(unknown) error = %v, want ErrNotFound", err)
+	}
+	results, err := c.EvaluateFeatureFlags(ctx, nil, &client.EvaluationContext{UserID: uintPtr(8)})
+	if err != nil || len(results) != 1 || results["beta"].Enabled {
+		t.Errorf("EvaluateFeatureFlags() = %+v, %v, want beta off for user 8", results, err)
+	}
+
+	public, err := c.GetPublicUser(ctx, 7)
+	if err != nil || public.Name != "Jane" {
+		t.Errorf("GetPublicUser() = %+v, %v, want Jane", public, err)
+	}
+	if _, err := c.GetPublicUser(ctx, 8); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("GetPublicUser(unknown) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestClient_SendsAPIKey(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id": 7, "name": "Jane"}`)
+	}))
+	defer srv.Close()
+
+	c := client.New(srv.URL, client.WithAPIKey("idk_secret"))
+	if _, err := c.GetPublicUser(context.Background(), 7); err != nil {
+		t.Fatalf("GetPublicUser() error = %v", err)
+	}
+	if got != "Bearer idk_secret" {
+		t.Errorf("Authorization = %q, want the API key as bearer token", got)
+	}
+}
+
+func TestLocalFlags_FollowsStream(t *testing.T) {
+	server := clienttest.NewServer(t)
+	server.SetFlag(client.Flag{Key: "beta"})
+	server.Assign(7, "beta", "")
+
+	flags := client.NewLocalFlags(server.Client(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = flags.Run(ctx) }()
+
+	select {
+	case <-flags.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no snapshot received")
+	}
+	if !flags.IsEnabled("beta", uintPtr(7)) || flags.IsEnabled("beta", uintPtr(8)) {
+		t.Fatal("IsEnabled() does not reflect the snapshot's assignment")
+	}
+
+	// Changes arrive as stream deltas
+	server.SetFlag(client.Flag{
+		Key: "staff",
+		Rules: []client.TargetingRule{{Conditions: []client.TargetingCondition{
+			{Attribute: "email", Operator: "ends_with", Values: []string{"@example.com"}},
+		}}},
+	})
+	server.Unassign(7, "beta")
+	server.DeleteFlag("beta")
+
+	waitFor(t, func() bool {
+		_, err := flags.Evaluate("beta", nil)
+		return errors.Is(err, client.ErrNotFound)
+	})
+	user := &client.User{ID: 9, Email: "jane@example.com"}
+	result, err := flags.Evaluate("staff", &client.EvaluationContext{UserID: &user.ID, Attributes: client.UserAttributes(user)})
+	if err != nil || !result.Enabled || result.Reason != "rule" {
+		t.Errorf("Evaluate(staff) = %+v, %v, want enabled by rule", result, err)
+	}
+}
+
+func TestSessionCache(t *testing.T) {
+	server := clienttest.NewServer(t)
+	server.AddSession("s1", client.User{ID: 7})
+	cache := client.NewSessionCache(server.Client(), time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if user, err := cache.ValidateSession(ctx, "s1"); err != nil || user.ID != 7 {
+			t.Fatalf("ValidateSession() = %+v, %v, want user 7", user, err)
+		}
+		if _, err := cache.ValidateSession(ctx, "nope"); !errors.Is(err, client.ErrUnauthorized) {
+			t.Fatalf("ValidateSession(unknown) error = %v, want ErrUnauthorized", err)
+		}
+	}
+	if calls := server.Calls("POST /api/v1/auth/validate"); calls != 2 {
+		t.Errorf("identity called %d times, want once per session", calls)
+	}
+
+	// A logout the service performs itself is seen right away
+	server.RemoveSession("s1")
+	cache.Invalidate("s1")
+	if _, err := cache.ValidateSession(ctx, "s1"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("ValidateSession() after Invalidate error = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestSessionTokens(t *testing.T) {
+	server := clienttest.NewServer(t)
+	server.AddSession("s1", client.User{ID: 7, Name: "Jane", Role: "user"})
+	server.SetFlag(client.Flag{Key: "beta"})
+	server.Assign(7, "beta", "")
+	c := server.Client()
+	tokens := client.NewSessionTokenCache(c)
+	verifier := client.NewTokenVerifier(c, clienttest.TokenIssuer)
+	ctx := context.Background()
+
+	// Tokens are exchanged once while fresh and verified without identity
+	for i := 0; i < 3; i++ {
+		token, err := tokens.Token(ctx, "s1")
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		claims, err := verifier.Verify(ctx, token)
+		if err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+		if id, _ := claims.UserID(); id != 7 || claims.Name != "Jane" || claims.Roles[0] != "user" || !claims.Flags["beta"] {
+			t.Fatalf("Verify() = %+v, want Jane with beta on", claims)
+		}
+	}
+	if calls := server.Calls("POST /api/v1/auth/token"); calls != 1 {
+		t.Errorf("identity exchanged the session %d times, want once", calls)
+	}
+	if calls := server.Calls("GET /api/v1/auth/jwks"); calls != 1 {
+		t.Errorf("identity served the keys %d times, want once", calls)
+	}
+
+	// Forged and foreign tokens are rejected, without refetching the keys
+	token, _ := tokens.Token(ctx, "s1")
+	if _, err := verifier.Verify(ctx, token[:len(token)-4]+"AAAA"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Verify(tampered) error = %v, want ErrUnauthorized", err)
+	}
+	if _, err := client.NewTokenVerifier(c, "elsewhere").Verify(ctx, token); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Verify(other issuer) error = %v, want ErrUnauthorized", err)
+	}
+	if calls := server.Calls("GET /api/v1/auth/jwks"); calls != 2 {
+		t.Errorf("identity served the keys %d times, want once per verifier", calls)
+	}
+
+	// Once the session ends it can't be exchanged again
+	server.RemoveSession("s1")
+	tokens.Invalidate("s1")
+	if _, err := tokens.Token(ctx, "s1"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Token() after logout error = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestGinAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := clienttest.NewServer(t)
+	server.AddSession("s1", client.User{ID: 7, Name: "Jane"})
+
+	router := gin.New()
+	router.Use(client.GinAuth(server.Client(), false))
+	router.GET("/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"gin": client.GinUser(c).ID, "ctx": client.UserFromContext(c.Request.Context()).ID})
+	})
+
+	tests := []struct {
+		name        string
+		cookie      string
+		header      string
+		wantStatus  int
+		wantCleared bool
+	}{
+		{"no session", "", "", http.StatusUnauthorized, false},
+		{"valid cookie", "s1", "", http.StatusOK, false},
+		{"valid header", "", "s1", http.StatusOK, false},
+		{"dead cookie", "gone", "", http.StatusUnauthorized, true},
+		{"dead header", "", "gone", http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: client.SessionCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(client.SessionHeaderName, tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if cleared := w.Header().Get("Set-Cookie") != ""; cleared != tt.wantCleared {
+				t.Errorf("Set-Cookie = %q, want cleared = %v", w.Header().Get("Set-Cookie"), tt.wantCleared)
+			}
+			if w.Code == http.StatusOK {
+				var body map[string]uint
+				_ = json.Unmarshal(w.Body.Bytes(), &body)
+				if body["gin"] != 7 || body["ctx"] != 7 {
+					t.Errorf("handler saw %v, want user 7 in the gin and request contexts", body)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddleware_IdentityDown(t *testing.T) {
+	server := clienttest.NewServer(t)
+	c := server.Client()
+	server.Close()
+
+	handler := client.Middleware(c, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called without a validated session")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(client.SessionHeaderName, "s1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503 when identity is unreachable", w.Code)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 5s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,466 @@
+// Package clienttest provides a fake identity server for testing services
+// that use package client.
+package clienttest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"identity/pkg/api"
+	"identity/pkg/client"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// TokenIssuer is the issuer of the session tokens the server signs, for
+	// client.NewTokenVerifier
+	TokenIssuer = "identity"
+	// tokenKeyID names the server's one signing key
+	tokenKeyID = "clienttest"
+	// tokenTTL is how long session tokens are valid, as by default in identity
+	tokenTTL = 5 * time.Minute
+)
+
+// Server is an in-memory identity server. It serves session validation and
+// exchange for signed tokens, flag checks and evaluation (with identity's own
+// evaluation logic), public user lookups and the flag stream, from state set
+// up by the test.
+type Server struct {
+	// URL is the base URL of the server, for client.New
+	URL string
+
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	sessions    map[string]client.User
+	users       map[uint]client.PublicUser
+	flags       map[string]*client.Flag
+	assignments map[uint]map[string]string // user ID → flag key → pinned variant
+	nextFlagID  uint
+	events      []api.FlagStreamEvent // event N has ID N+1
+	subscribers map[chan struct{}]struct{}
+	calls       map[string]int
+	tokenKey    *rsa.PrivateKey // generated on first use
+}
+
+// NewServer starts a fake identity server, closed when the test ends
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		sessions:    make(map[string]client.User),
+		users:       make(map[uint]client.PublicUser),
+		flags:       make(map[string]*client.Flag),
+		assignments: make(map[uint]map[string]string),
+		nextFlagID:  1,
+		subscribers: make(map[chan struct{}]struct{}),
+		calls:       make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/auth/validate", s.validate)
+	mux.HandleFunc("POST /api/v1/auth/token", s.token)
+	mux.HandleFunc("GET /api/v1/auth/jwks", s.jwks)
+	mux.HandleFunc("GET /api/v1/feature-flags/check", s.check)
+	mux.HandleFunc("POST /api/v1/feature-flags/check", s.checkWithContext)
+	mux.HandleFunc("POST /api/v1/feature-flags/evaluate", s.evaluate)
+	mux.HandleFunc("GET /api/v1/feature-flags/stream", s.stream)
+	mux.HandleFunc("GET /api/v1/internal/users/{id}", s.publicUser)
+
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.calls[r.Method+" "+r.URL.Path]++
+		s.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	s.URL = s.srv.URL
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Client returns a client for the server
+func (s *Server) Client() *client.Client {
+	return client.New(s.URL)
+}
+
+// Close ends open flag streams and shuts the server down
+func (s *Server) Close() {
+	s.mu.Lock()
+	for ch := range s.subscribers {
+		close(ch)
+	}
+	s.subscribers = make(map[chan struct{}]struct{})
+	s.mu.Unlock()
+	s.srv.Close()
+}
+
+// Calls returns how often an endpoint was called, e.g.
+// Calls("POST /api/v1/auth/validate")
+func (s *Server) Calls(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[endpoint]
+}
+
+// AddSession makes sessionID valid for user
+func (s *Server) AddSession(sessionID string, user client.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[sessionID] = user
+}
+
+// RemoveSession invalidates a session, as a logout would
+func (s *Server) RemoveSession(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+}
+
+// AddUser makes a user resolvable through the public user lookup
+func (s *Server) AddUser(user client.PublicUser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.ID] = user
+}
+
+// SetFlag creates or replaces a flag (matched by key) and streams the change.
+// A boolean flag only needs Key and Enabled.
+func (s *Server) SetFlag(flag client.Flag) {
+	if flag.VariantType == "" {
+		flag.VariantType = api.VariantTypeBoolean
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.flags[flag.Key]; ok {
+		flag.ID = existing.ID
+	} else {
+		flag.ID = s.nextFlagID
+		s.nextFlagID++
+	}
+	s.flags[flag.Key] = &flag
+	s.publishLocked(api.FlagStreamFlag, flag)
+}
+
+// DeleteFlag deletes a flag and streams the change
+func (s *Server) DeleteFlag(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.flags, key)
+	for _, byKey := range s.assignments {
+		delete(byKey, key)
+	}
+	s.publishLocked(api.FlagStreamFlagDeleted, api.FlagDeletedEvent{Key: key})
+}
+
+// Assign assigns a flag to a user, optionally pinning a variant, and
+// streams the change
+func (s *Server) Assign(userID uint, key, variant string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.assignments[userID] == nil {
+		s.assignments[userID] = make(map[string]string)
+	}
+	s.assignments[userID][key] = variant
+	s.publishLocked(api.FlagStreamAssignment, api.FlagAssignmentEvent{UserID: userID, FlagKey: key, Assigned: true, Variant: variant})
+}
+
+// Unassign removes a user's assignment of a flag and streams the change
+func (s *Server) Unassign(userID uint, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.assignments[userID], key)
+	s.publishLocked(api.FlagStreamAssignment, api.FlagAssignmentEvent{UserID: userID, FlagKey: key})
+}
+
+func (s *Server) publishLocked(event string, data any) {
+	s.events = append(s.events, api.FlagStreamEvent{ID: uint64(len(s.events) + 1), Event: event, Data: data})
+	for ch := range s.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Server) validate(w http.ResponseWriter, r *http.Request) {
+	var req api.ValidateSessionRequest
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	if req.SessionID == "" {
+		req.SessionID = r.Header.Get(client.SessionHeaderName)
+	}
+
+	s.mu.Lock()
+	user, ok := s.sessions[req.SessionID]
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, api.ErrorResponse{Error: "unauthorized", Message: "invalid or expired session"})
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// token exchanges a session for a token signed like identity's, carrying
+// the user's flags as evaluated now
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	var req api.ValidateSessionRequest
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	if req.SessionID == "" {
+		req.SessionID = client.SessionIDFromRequest(r)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.sessions[req.SessionID]
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, api.ErrorResponse{Error: "unauthorized", Message: "session rejected: invalid session"})
+		return
+	}
+
+	claims := &api.SessionTokenClaims{
+		Name:  user.Name,
+		Roles: []string{user.Role},
+		Flags: make(map[string]bool, len(s.flags)),
+	}
+	for key, flag := range s.flags {
+		result := s.evaluateLocked(flag, &api.EvaluationContext{UserID: &user.ID})
+		claims.Flags[key] = result.Enabled
+		if result.Variant != "" {
+			if claims.FlagVariants == nil {
+				claims.FlagVariants = make(map[string]string)
+			}
+			claims.FlagVariants[key] = result.Variant
+		}
+	}
+	now := time.Now()
+	expiresAt := now.Add(tokenTTL)
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    TokenIssuer,
+		Subject:   strconv.FormatUint(uint64(user.ID), 10),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["typ"] = api.SessionTokenType
+	token.Header["kid"] = tokenKeyID
+	signed, err := token.SignedString(s.tokenKeyLocked())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, api.ErrorResponse{Error: "internal_error", Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, api.SessionTokenResponse{
+		AccessToken: signed,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(tokenTTL.Seconds()),
+		ExpiresAt:   expiresAt,
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	key := s.tokenKeyLocked()
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, api.JWKS{Keys: []api.JWK{{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: tokenKeyID,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+}
+
+// tokenKeyLocked returns the key tokens are signed with, generating it the
+// first time. Callers hold s.mu.
+func (s *Server) tokenKeyLocked() *rsa.PrivateKey {
+	if s.tokenKey == nil {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(fmt.Sprintf("clienttest: generating signing key: %v", err))
+		}
+		s.tokenKey = key
+	}
+	return s.tokenKey
+}
+
+func (s *Server) check(w http.ResponseWriter, r *http.Request) {
+	evalCtx := &api.EvaluationContext{}
+	if userIDStr := r.URL.Query().Get("user_id"); userIDStr != "" {
+		id, err := strconv.ParseUint(userIDStr, 10, 32)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: "invalid_request", Message: "Invalid user_id"})
+			return
+		}
+		uid := uint(id)
+		evalCtx.UserID = &uid
+	}
+	s.evaluateOne(w, r.URL.Query().Get("key"), evalCtx)
+}
+
+func (s *Server) checkWithContext(w http.ResponseWriter, r *http.Request) {
+	var req api.EvaluateFeatureFlagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
+	s.evaluateOne(w, req.Key, &api.EvaluationContext{UserID: req.UserID, Attributes: req.Context})
+}
+
+func (s *Server) evaluateOne(w http.ResponseWriter, key string, evalCtx *api.EvaluationContext) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flag, ok := s.flags[key]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, api.ErrorResponse{Error: "not_found", Message: "feature flag not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.evaluateLocked(flag, evalCtx))
+}
+
+func (s *Server) evaluate(w http.ResponseWriter, r *http.Request) {
+	var req api.BulkEvaluateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
+	evalCtx := &api.EvaluationContext{UserID: req.UserID, Attributes: req.Context}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := req.Keys
+	if len(keys) == 0 {
+		for key := range s.flags {
+			keys = append(keys, key)
+		}
+	}
+	results := make(map[string]api.FlagEvaluationResponse, len(keys))
+	for _, key := range keys {
+		if flag, ok := s.flags[key]; ok {
+			results[key] = *s.evaluateLocked(flag, evalCtx)
+		}
+	}
+	writeJSON(w, http.StatusOK, api.BulkEvaluationResponse{Flags: results})
+}
+
+func (s *Server) evaluateLocked(flag *client.Flag, evalCtx *api.EvaluationContext) *api.FlagEvaluationResponse {
+	result, _ := api.EvaluateFlag(flag, api.FlagInputs{
+		UserID: evalCtx.UserID,
+		Assignment: func(flag *client.Flag) (string, bool, error) {
+			variant, ok := s.assignments[*evalCtx.UserID][flag.Key]
+			return variant, ok, nil
+		},
+		Attributes: func() (map[string]any, error) {
+			attrs := make(map[string]any, len(evalCtx.Attributes)+1)
+			for k, v := range evalCtx.Attributes {
+				attrs[k] = v
+			}
+			if evalCtx.UserID != nil {
+				attrs["user_id"] = *evalCtx.UserID
+			}
+			return attrs, nil
+		},
+	})
+	return result
+}
+
+func (s *Server) publicUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: "invalid_request", Message: "Invalid user ID"})
+		return
+	}
+
+	s.mu.Lock()
+	user, ok := s.users[uint(id)]
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, api.ErrorResponse{Error: "not_found", Message: "user not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// stream serves the flag stream: a snapshot (or, with a known Last-Event-ID,
+// the events since), then every change made through the Server's methods
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	signal := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.subscribers[signal] = struct{}{}
+	sent := len(s.events)
+	var initial []api.FlagStreamEvent
+	if lastID, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil && lastID <= len(s.events) {
+		initial, sent = s.events[lastID:], len(s.events)
+	} else {
+		initial = []api.FlagStreamEvent{s.snapshotLocked()}
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, signal)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	events := initial
+	for {
+		for _, event := range events {
+			data, _ := json.Marshal(event.Data)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Event, data)
+		}
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case _, ok := <-signal:
+			if !ok {
+				return
+			}
+		case now := <-time.After(15 * time.Second):
+			data, _ := json.Marshal(api.HeartbeatEvent{Time: now.UTC()})
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", api.FlagStreamHeartbeat, data)
+		}
+
+		s.mu.Lock()
+		events = append([]api.FlagStreamEvent(nil), s.events[sent:]...)
+		sent = len(s.events)
+		s.mu.Unlock()
+	}
+}
+
+func (s *Server) snapshotLocked() api.FlagStreamEvent {
+	snapshot := api.FlagSnapshot{
+		Flags:       make([]api.FeatureFlagResponse, 0, len(s.flags)),
+		Assignments: []api.FlagAssignmentEvent{},
+	}
+	for _, flag := range s.flags {
+		snapshot.Flags = append(snapshot.Flags, *flag)
+	}
+	sort.Slice(snapshot.Flags, func(i, j int) bool { return snapshot.Flags[i].Key < snapshot.Flags[j].Key })
+	for userID, byKey := range s.assignments {
+		for key, variant := range byKey {
+			snapshot.Assignments = append(snapshot.Assignments, api.FlagAssignmentEvent{UserID: userID, FlagKey: key, Assigned: true, Variant: variant})
+		}
+	}
+	return api.FlagStreamEvent{ID: uint64(len(s.events)), Event: api.FlagStreamSnapshot, Data: snapshot}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,314 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"identity/pkg/api"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// streamIdleTimeout drops a stream that missed three heartbeats
+	streamIdleTimeout = 45 * time.Second
+	// Reconnect backoff for LocalFlags.Run
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Snapshot is the full flag set (with every user assignment) the flag stream
+// starts from
+type Snapshot = api.FlagSnapshot
+
+// LocalFlags evaluates flags in process, against a copy of every flag and
+// assignment that Run keeps current from identity's flag stream. Evaluation
+// is identity's own, so results match the check endpoints, except that
+// targeting rules only see the attributes passed in the evaluation context:
+// identity adds the user record's (email, name, role, created_at), which
+// callers can supply with UserAttributes.
+type LocalFlags struct {
+	client *Client
+	logger *slog.Logger
+
+	mu          sync.RWMutex
+	flags       map[string]*Flag
+	assignments map[uint]map[string]string // user ID → flag key → pinned variant
+	lastEventID string
+
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// NewLocalFlags creates a local evaluator fed by c's flag stream. It holds no
+// flags until Run received the first snapshot (or Load was called).
+func NewLocalFlags(c *Client, logger *slog.Logger) *LocalFlags {
+	return &LocalFlags{
+		client:      c,
+		logger:      logger,
+		flags:       make(map[string]*Flag),
+		assignments: make(map[uint]map[string]string),
+		ready:       make(chan struct{}),
+	}
+}
+
+// Ready is closed once the first snapshot was loaded
+func (l *LocalFlags) Ready() <-chan struct{} {
+	return l.ready
+}
+
+// Run follows the flag stream until ctx is cancelled, reconnecting with
+// backoff and resuming from the last event received. Call it in a goroutine.
+func (l *LocalFlags) Run(ctx context.Context) error {
+	backoff := minBackoff
+	for {
+		err := l.follow(ctx, func() { backoff = minBackoff })
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		l.logger.Warn("identity flag stream disconnected", "error", err, "retry_in", backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// follow reads the stream until it fails; onConnect runs once it is open
+func (l *LocalFlags) follow(ctx context.Context, onConnect func()) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.client.baseURL+"/api/v1/feature-flags/stream", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	l.client.setAPIKey(req)
+	l.mu.RLock()
+	if l.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", l.lastEventID)
+	}
+	l.mu.RUnlock()
+
+	resp, err := l.client.streamClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decodeAPIError(resp)
+	}
+	onConnect()
+
+	// Heartbeats arrive every 15s; a silent stream is a dead one
+	idle := time.AfterFunc(streamIdleTimeout, cancel)
+	defer idle.Stop()
+
+	reader := bufio.NewReader(resp.Body)
+	var id, event string
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if ctx.Err() != nil && !idle.Stop() {
+				return fmt.Errorf("no heartbeat for %s", streamIdleTimeout)
+			}
+			return err
+		}
+		idle.Reset(streamIdleTimeout)
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if event != "" {
+				if err := l.apply(id, event, []byte(data.String())); err != nil {
+					return err
+				}
+			}
+			id, event = "", ""
+			data.Reset()
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			event = value
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		}
+	}
+}
+
+// apply updates the local copy with one stream event
+func (l *LocalFlags) apply(id, event string, data []byte) error {
+	switch event {
+	case api.FlagStreamSnapshot:
+		var snapshot Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return fmt.Errorf("decoding snapshot: %w", err)
+		}
+		l.load(snapshot.Flags, snapshot.Assignments)
+	case api.FlagStreamFlag:
+		var flag Flag
+		if err := json.Unmarshal(data, &flag); err != nil {
+			return fmt.Errorf("decoding flag: %w", err)
+		}
+		l.mu.Lock()
+		l.flags[flag.Key] = &flag
+		l.mu.Unlock()
+	case api.FlagStreamFlagDeleted:
+		var deleted api.FlagDeletedEvent
+		if err := json.Unmarshal(data, &deleted); err != nil {
+			return fmt.Errorf("decoding deleted flag: %w", err)
+		}
+		l.mu.Lock()
+		delete(l.flags, deleted.Key)
+		for _, byKey := range l.assignments {
+			delete(byKey, deleted.Key)
+		}
+		l.mu.Unlock()
+	case api.FlagStreamAssignment:
+		var assignment api.FlagAssignmentEvent
+		if err := json.Unmarshal(data, &assignment); err != nil {
+			return fmt.Errorf("decoding assignment: %w", err)
+		}
+		l.mu.Lock()
+		l.applyAssignmentLocked(assignment)
+		l.mu.Unlock()
+	}
+
+	if id != "" {
+		l.mu.Lock()
+		l.lastEventID = id
+		l.mu.Unlock()
+	}
+	return nil
+}
+
+// Load replaces the local copy with a snapshot, e.g. one saved to bootstrap
+// a service while identity is unreachable
+func (l *LocalFlags) Load(snapshot Snapshot) {
+	l.load(snapshot.Flags, snapshot.Assignments)
+}
+
+func (l *LocalFlags) load(flags []Flag, assignments []api.FlagAssignmentEvent) {
+	byKey := make(map[string]*Flag, len(flags))
+	for i := range flags {
+		byKey[flags[i].Key] = &flags[i]
+	}
+
+	l.mu.Lock()
+	l.flags = byKey
+	l.assignments = make(map[uint]map[string]string)
+	for _, assignment := range assignments {
+		l.applyAssignmentLocked(assignment)
+	}
+	l.mu.Unlock()
+
+	l.readyOnce.Do(func() { close(l.ready) })
+}
+
+func (l *LocalFlags) applyAssignmentLocked(assignment api.FlagAssignmentEvent) {
+	byKey := l.assignments[assignment.UserID]
+	if !assignment.Assigned {
+		delete(byKey, assignment.FlagKey)
+		if len(byKey) == 0 {
+			delete(l.assignments, assignment.UserID)
+		}
+		return
+	}
+	if byKey == nil {
+		byKey = make(map[string]string)
+		l.assignments[assignment.UserID] = byKey
+	}
+	byKey[assignment.FlagKey] = assignment.Variant
+}
+
+// Evaluate resolves a flag for an evaluation context, or returns ErrNotFound
+func (l *LocalFlags) Evaluate(key string, evalCtx *EvaluationContext) (*FlagEvaluation, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	flag, ok := l.flags[key]
+	if !ok {
+		return nil, fmt.Errorf("flag %q: %w", key, ErrNotFound)
+	}
+	return l.evaluateLocked(flag, evalCtx)
+}
+
+// EvaluateAll resolves every flag for an evaluation context, keyed by flag key
+func (l *LocalFlags) EvaluateAll(evalCtx *EvaluationContext) (map[string]FlagEvaluation, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	results := make(map[string]FlagEvaluation, len(l.flags))
+	for key, flag := range l.flags {
+		result, err := l.evaluateLocked(flag, evalCtx)
+		if err != nil {
+			return nil, err
+		}
+		results[key] = *result
+	}
+	return results, nil
+}
+
+// IsEnabled reports whether a flag is enabled globally, or for userID when
+// not nil. Unknown flags are off.
+func (l *LocalFlags) IsEnabled(key string, userID *uint) bool {
+	result, err := l.Evaluate(key, &EvaluationContext{UserID: userID})
+	return err == nil && result.Enabled
+}
+
+func (l *LocalFlags) evaluateLocked(flag *Flag, evalCtx *EvaluationContext) (*FlagEvaluation, error) {
+	if evalCtx == nil {
+		evalCtx = &EvaluationContext{}
+	}
+	return api.EvaluateFlag(flag, api.FlagInputs{
+		UserID: evalCtx.UserID,
+		Assignment: func(flag *Flag) (string, bool, error) {
+			variant, ok := l.assignments[*evalCtx.UserID][flag.Key]
+			return variant, ok, nil
+		},
+		Attributes: func() (map[string]any, error) {
+			attrs := make(map[string]any, len(evalCtx.Attributes)+1)
+			for k, v := range evalCtx.Attributes {
+				attrs[k] = v
+			}
+			if _, ok := attrs["user_id"]; !ok && evalCtx.UserID != nil {
+				attrs["user_id"] = *evalCtx.UserID
+			}
+			return attrs, nil
+		},
+		Flag: func(key string) (*Flag, error) {
+			return l.flags[key], nil
+		},
+	})
+}
+
+// UserAttributes are the attributes identity takes from a user's record for
+// targeting rules, to pass to LocalFlags along with the user's ID
+func UserAttributes(user *User) map[string]any {
+	return map[string]any{
+		"user_id":    user.ID,
+		"email":      user.Email,
+		"name":       user.Name,
+		"role":       user.Role,
+		"created_at": user.CreatedAt,
+	}
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"identity/pkg/api"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// SessionCookieName is the name of identity's session cookie
+	SessionCookieName = "session_id"
+	// SessionHeaderName carries the session for service-to-service calls
+	SessionHeaderName = "X-Session-ID"
+	// UserContextKey is the gin context key GinAuth stores the user under
+	UserContextKey = "user"
+)
+
+type userContextKey struct{}
+
+// SessionIDFromRequest extracts the session ID from the cookie, falling back
+// to the X-Session-ID header
+func SessionIDFromRequest(r *http.Request) string {
+	if cookie, err := r.Cookie(SessionCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	return r.Header.Get(SessionHeaderName)
+}
+
+// GinAuth is identity's session middleware for gin services: it validates
+// the session from the cookie or X-Session-ID header and stores the user in
+// the gin context (see GinUser) and the request context (see
+// UserFromContext). Missing or invalid sessions get the same 401 responses
+// identity sends, clearing a dead session cookie; if identity can't be
+// reached it answers 503 instead.
+func GinAuth(validator SessionValidator, cookieSecure bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := authenticate(c.Writer, c.Request, validator, cookieSecure)
+		if !ok {
+			c.Abort()
+			return
+		}
+		c.Set(UserContextKey, user)
+		c.Request = c.Request.WithContext(WithUser(c.Request.Context(), user))
+		c.Next()
+	}
+}
+
+// Middleware is GinAuth for net/http services
+func Middleware(validator SessionValidator, cookieSecure bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := authenticate(w, r, validator, cookieSecure)
+			if !ok {
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+		})
+	}
+}
+
+// GinUser returns the user stored by GinAuth, or nil
+func GinUser(c *gin.Context) *User {
+	if user, exists := c.Get(UserContextKey); exists {
+		if u, ok := user.(*User); ok {
+			return u
+		}
+	}
+	return nil
+}
+
+// WithUser returns a context carrying user
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// UserFromContext returns the user stored by the middleware, or nil
+func UserFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userContextKey{}).(*User)
+	return user
+}
+
+// authenticate validates the request's session, writing the error response
+// and returning false when there is no valid one
+func authenticate(w http.ResponseWriter, r *http.Request, validator SessionValidator, cookieSecure bool) (*User, bool) {
+	sessionID := SessionIDFromRequest(r)
+	if sessionID == "" {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+		return nil, false
+	}
+
+	user, err := validator.ValidateSession(r.Context(), sessionID)
+	if err != nil {
+		if !errors.Is(err, ErrUnauthorized) {
+			writeError(w, http.StatusServiceUnavailable, "unavailable", "Identity service unavailable")
+			return nil, false
+		}
+		// The session is dead; if it arrived as a cookie, tell the browser to
+		// drop it so it stops replaying a request that will only ever 401
+		if cookie, err := r.Cookie(SessionCookieName); err == nil && cookie.Value != "" {
+			http.SetCookie(w, &http.Cookie{
+				Name:     SessionCookieName,
+				Path:     "/",
+				MaxAge:   -1,
+				Secure:   cookieSecure,
+				HttpOnly: true,
+			})
+		}
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Invalid or expired session")
+		return nil, false
+	}
+	return user, true
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(api.ErrorResponse{Error: code, Message: message})
+}
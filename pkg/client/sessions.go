@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// maxCachedSessions bounds the session validation cache
+const maxCachedSessions = 10000
+
+// SessionValidator resolves a session ID to its user; *Client and
+// *SessionCache implement it
+type SessionValidator interface {
+	ValidateSession(ctx context.Context, sessionID string) (*User, error)
+}
+
+// SessionCache remembers session validations for a short TTL, so a service
+// validating every request doesn't call identity for each one. Rejected
+// sessions are remembered too; other errors (identity unreachable) are not.
+// A logout or force-logout takes up to the TTL to be noticed, so keep it to
+// seconds.
+type SessionCache struct {
+	validator SessionValidator
+	ttl       time.Duration
+
+	mu      sync.Mutex
+	entries map[string]sessionEntry
+}
+
+type sessionEntry struct {
+	user      *User
+	err       error
+	expiresAt time.Time
+}
+
+// NewSessionCache wraps validator (usually a *Client) with a cache whose
+// entries live for ttl
+func NewSessionCache(validator SessionValidator, ttl time.Duration) *SessionCache {
+	return &SessionCache{
+		validator: validator,
+		ttl:       ttl,
+		entries:   make(map[string]sessionEntry),
+	}
+}
+
+// ValidateSession returns the session's user, from the cache if it is fresh
+func (c *SessionCache) ValidateSession(ctx context.Context, sessionID string) (*User, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[sessionID]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return copyUser(entry.user), entry.err
+	}
+
+	user, err := c.validator.ValidateSession(ctx, sessionID)
+	if err != nil && !errors.Is(err, ErrUnauthorized) {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= maxCachedSessions {
+		c.evictLocked()
+	}
+	c.entries[sessionID] = sessionEntry{user: user, err: err, expiresAt: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return copyUser(user), err
+}
+
+// Invalidate forgets a session, e.g. when the service itself logs it out
+func (c *SessionCache) Invalidate(sessionID string) {
+	c.mu.Lock()
+	delete(c.entries, sessionID)
+	c.mu.Unlock()
+}
+
+// evictLocked drops expired entries, or all of them if none has expired
+// yet. Callers hold c.mu.
+func (c *SessionCache) evictLocked() {
+	now := time.Now()
+	for id, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, id)
+		}
+	}
+	if len(c.entries) >= maxCachedSessions {
+		c.entries = make(map[string]sessionEntry)
+	}
+}
+
+// copyUser keeps callers from modifying the cached user
+func copyUser(user *User) *User {
+	if user == nil {
+		return nil
+	}
+	u := *user
+	return &u
+}
@@ -0,0 +1,222 @@
+package client
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"identity/pkg/api"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// tokenKeyRefetchInterval bounds how often a TokenVerifier refetches the
+	// signing keys for tokens naming a key it doesn't know
+	tokenKeyRefetchInterval = time.Minute
+	// tokenRefreshMargin is how long before its expiry SessionTokenCache
+	// replaces a token, so it doesn't expire on the way downstream
+	tokenRefreshMargin = 30 * time.Second
+)
+
+// Types shared with the identity API
+type (
+	// SessionToken is a signed access token a session was exchanged for
+	SessionToken = api.SessionTokenResponse
+	// TokenClaims are a verified session token's claims
+	TokenClaims = api.SessionTokenClaims
+	// JWKS is the set of keys session tokens are signed with
+	JWKS = api.JWKS
+)
+
+// ExchangeSession exchanges a session for a signed access token, or returns
+// ErrUnauthorized
+func (c *Client) ExchangeSession(ctx context.Context, sessionID string) (*SessionToken, error) {
+	var token SessionToken
+	err := c.do(ctx, http.MethodPost, "/api/v1/auth/token", api.ValidateSessionRequest{SessionID: sessionID}, &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// JWKS returns the keys session tokens are signed with
+func (c *Client) JWKS(ctx context.Context) (*JWKS, error) {
+	var jwks JWKS
+	if err := c.do(ctx, http.MethodGet, "/api/v1/auth/jwks", nil, &jwks); err != nil {
+		return nil, err
+	}
+	return &jwks, nil
+}
+
+// TokenVerifier verifies session tokens locally, against signing keys it
+// fetches from identity when a token names one it doesn't know yet (at most
+// every tokenKeyRefetchInterval). Tokens can't be revoked: a logout is
+// noticed when the token expires and can't be exchanged again.
+type TokenVerifier struct {
+	client *Client
+	issuer string
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewTokenVerifier creates a verifier for tokens issued by issuer (identity's
+// SESSION_TOKEN_ISSUER) through client
+func NewTokenVerifier(client *Client, issuer string) *TokenVerifier {
+	return &TokenVerifier{
+		client: client,
+		issuer: issuer,
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+}
+
+// Verify checks a token's signature, type, issuer and expiry and returns its
+// claims. Invalid tokens match ErrUnauthorized; other errors mean the keys
+// couldn't be fetched.
+func (v *TokenVerifier) Verify(ctx context.Context, token string) (*TokenClaims, error) {
+	var claims TokenClaims
+	var fetchErr error
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (any, error) {
+		if typ, _ := t.Header["typ"].(string); typ != api.SessionTokenType {
+			return nil, fmt.Errorf("token type %q is not %q", typ, api.SessionTokenType)
+		}
+		kid, _ := t.Header["kid"].(string)
+		key, err := v.key(ctx, kid)
+		if err != nil {
+			fetchErr = err
+			return nil, err
+		}
+		if key == nil {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(v.issuer), jwt.WithExpirationRequired())
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	return &claims, nil
+}
+
+// key returns the signing key with ID kid, or nil if identity doesn't
+// publish it
+func (v *TokenVerifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok || time.Since(v.fetchedAt) < tokenKeyRefetchInterval {
+		return key, nil
+	}
+
+	jwks, err := v.client.JWKS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		key, err := parseRSAKey(jwk)
+		if err != nil {
+			return nil, fmt.Errorf("identity: signing key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	return keys[kid], nil
+}
+
+// parseRSAKey decodes an RSA public key in JSON Web Key form
+func parseRSAKey(jwk api.JWK) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent too large")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// SessionTokenCache holds the token each session was last exchanged for, so
+// a service forwarding tokens downstream only calls identity when one is
+// about to expire. Rejected sessions are not remembered.
+type SessionTokenCache struct {
+	client *Client
+
+	mu      sync.Mutex
+	entries map[string]*SessionToken
+}
+
+// NewSessionTokenCache creates a cache exchanging sessions through client
+func NewSessionTokenCache(client *Client) *SessionTokenCache {
+	return &SessionTokenCache{
+		client:  client,
+		entries: make(map[string]*SessionToken),
+	}
+}
+
+// Token returns a token for the session, exchanging it again if the cached
+// one expires within tokenRefreshMargin
+func (c *SessionTokenCache) Token(ctx context.Context, sessionID string) (string, error) {
+	c.mu.Lock()
+	token, ok := c.entries[sessionID]
+	c.mu.Unlock()
+	if ok && time.Until(token.ExpiresAt) > tokenRefreshMargin {
+		return token.AccessToken, nil
+	}
+
+	token, err := c.client.ExchangeSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			c.Invalidate(sessionID)
+		}
+		return "", err
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= maxCachedSessions {
+		c.evictLocked()
+	}
+	c.entries[sessionID] = token
+	c.mu.Unlock()
+	return token.AccessToken, nil
+}
+
+// Invalidate forgets a session's token, e.g. when the service itself logs
+// the session out
+func (c *SessionTokenCache) Invalidate(sessionID string) {
+	c.mu.Lock()
+	delete(c.entries, sessionID)
+	c.mu.Unlock()
+}
+
+// evictLocked drops expired tokens, or all of them if none has expired yet.
+// Callers hold c.mu.
+func (c *SessionTokenCache) evictLocked() {
+	now := time.Now()
+	for id, token := range c.entries {
+		if !now.Before(token.ExpiresAt) {
+			delete(c.entries, id)
+		}
+	}
+	if len(c.entries) >= maxCachedSessions {
+		c.entries = make(map[string]*SessionToken)
+	}
+}